@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/r0qs/beezim/internal/beeclient/debugapi"
+	"github.com/r0qs/beezim/internal/postage"
+	"github.com/r0qs/beezim/internal/result"
+	"github.com/r0qs/beezim/internal/store"
+
+	"github.com/spf13/cobra"
+)
+
+func newBatchCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "batch",
+		Short: "Maintain the postage batches used by the published mirrors",
+	}
+
+	topUpCmd := &cobra.Command{
+		Use:   "topup",
+		Short: "Top up the batches of the published mirrors whose TTL is below a threshold",
+		Long: "\nChecks the TTL of the postage batches used by the mirrors recorded in the local database." +
+			"\nBatches below --ttl-threshold are topped up to --ttl-target as long as the total spent in --spend-period stays below --max-spend;" +
+			"\notherwise they are reported and the webhooks are notified.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			actions, err := maintainBatches(cmd.Context(), runResult)
+			if err != nil {
+				return err
+			}
+			if optionJSON {
+				runResult.Data = actions
+				return nil
+			}
+			printBatchPlan(actions)
+			return nil
+		},
+	}
+	addBatchFlags(topUpCmd)
+	addNotifyFlags(topUpCmd)
+
+	cmd.AddCommand(topUpCmd)
+	return cmd
+}
+
+// addBatchFlags adds the flags of the commands that maintain the postage batches.
+func addBatchFlags(cmd *cobra.Command) {
+	cmd.Flags().DurationVar(&optionTopUpThreshold, optionNameTopUpThreshold, 30*24*time.Hour, "top up batches whose TTL is below this threshold")
+	cmd.Flags().DurationVar(&optionTopUpTarget, optionNameTopUpTarget, 90*24*time.Hour, "TTL the topped up batches should reach")
+	cmd.Flags().StringVar(&optionTopUpMaxSpend, optionNameTopUpMaxSpend, "", "maximum amount in PLUR spent in top-ups per period, batches are only reported when not set")
+	cmd.Flags().DurationVar(&optionTopUpSpendPeriod, optionNameTopUpSpendPeriod, 30*24*time.Hour, "period in which the spending cap applies")
+	cmd.Flags().DurationVar(&optionTopUpBlockTime, optionNameTopUpBlockTime, postage.DefaultBlockTime, "block time of the chain")
+	cmd.Flags().BoolVar(&optionDryRun, optionNameDryRun, false, "only print the planned top-ups")
+}
+
+// maintainBatches plans and executes the top-ups of the batches used by
+// the recorded mirrors. Batches that need attention are reported as
+// warnings of res and sent to the webhooks.
+func maintainBatches(ctx context.Context, res *result.Result) ([]postage.Action, error) {
+	if !bee.HasDebugAPI() {
+		return nil, fmt.Errorf("the bee debug api is required to maintain batches")
+	}
+
+	policy := postage.Policy{
+		Threshold: optionTopUpThreshold,
+		Target:    optionTopUpTarget,
+		BlockTime: optionTopUpBlockTime,
+	}
+	if optionTopUpMaxSpend != "" {
+		maxSpend, ok := new(big.Int).SetString(optionTopUpMaxSpend, 10)
+		if !ok || maxSpend.Sign() < 0 {
+			return nil, fmt.Errorf("invalid --%s: %q", optionNameTopUpMaxSpend, optionTopUpMaxSpend)
+		}
+		policy.MaxSpend = maxSpend
+	}
+	if policy.Target < policy.Threshold {
+		return nil, fmt.Errorf("--%s must be greater than --%s", optionNameTopUpTarget, optionNameTopUpThreshold)
+	}
+
+	s, err := store.Open(filepath.Join(optionDataDir, dbFile))
+	if err != nil {
+		return nil, err
+	}
+
+	batches, err := mirrorBatches(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if len(batches) == 0 {
+		logger.Info("no batches used by the recorded mirrors")
+		return nil, nil
+	}
+
+	cs, err := bee.ChainState(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching chain state: %v", err)
+	}
+	var price *big.Int
+	if cs.CurrentPrice != nil {
+		price = cs.CurrentPrice.Int
+	}
+
+	actions := postage.Plan(batches, price, spentSince(s, time.Now().Add(-optionTopUpSpendPeriod)), policy)
+	if !optionDryRun {
+		for i := range actions {
+			if actions[i].Kind == postage.KindTopUp {
+				topUp(ctx, s, &actions[i])
+			}
+		}
+	}
+
+	alerts := 0
+	for _, a := range actions {
+		if a.Kind == postage.KindAlert {
+			alerts++
+			res.Warn(fmt.Sprintf("batch %s (TTL %v): %s", a.BatchID, time.Duration(a.TTL)*time.Second, a.Reason))
+		}
+	}
+	if alerts > 0 && !optionDryRun {
+		res.Data = actions
+		notifyResult(res, fmt.Errorf("%d batches need attention", alerts))
+	}
+	return actions, nil
+}
+
+// mirrorBatches returns the batches used by the completed runs with their roots.
+func mirrorBatches(ctx context.Context, s *store.Store) ([]postage.Batch, error) {
+	roots := make(map[string][]string)
+	for _, r := range s.Runs() {
+		if r.Status != store.StatusCompleted || r.BatchID == "" || r.Reference == "" {
+			continue
+		}
+		roots[r.BatchID] = append(roots[r.BatchID], r.Reference)
+	}
+	if len(roots) == 0 {
+		return nil, nil
+	}
+
+	stamps, err := bee.PostageBatches(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching postage batches: %v", err)
+	}
+	known := make(map[string]debugapi.PostageStampResponse, len(stamps))
+	for _, st := range stamps {
+		known[st.BatchID] = st
+	}
+
+	batches := make([]postage.Batch, 0, len(roots))
+	for id, refs := range roots {
+		b := postage.Batch{ID: id, Roots: refs}
+		if st, ok := known[id]; ok {
+			b.Depth = st.Depth
+			b.TTL = st.BatchTTL
+			b.Exists = st.Exists
+		}
+		batches = append(batches, b)
+	}
+	sort.Slice(batches, func(i, j int) bool {
+		return batches[i].ID < batches[j].ID
+	})
+	return batches, nil
+}
+
+func spentSince(s *store.Store, since time.Time) *big.Int {
+	total := new(big.Int)
+	for _, sp := range s.Spends() {
+		if sp.Time.Before(since) {
+			continue
+		}
+		if cost, ok := new(big.Int).SetString(sp.Cost, 10); ok {
+			total.Add(total, cost)
+		}
+	}
+	return total
+}
+
+// topUp executes the planned top-up and records the spend. Failures turn
+// the action into an alert.
+func topUp(ctx context.Context, s *store.Store, a *postage.Action) {
+	logger.Info("topping up batch", "batch", a.BatchID, "amount", a.Amount, "cost", a.Cost.String())
+	err := bee.TopUpPostageBatch(ctx, a.BatchID, a.Amount, debugapi.PostageOptions{GasPrice: optionGasPrice})
+	if err != nil {
+		a.Kind = postage.KindAlert
+		a.Reason = fmt.Sprintf("top-up failed: %v", err)
+		return
+	}
+
+	err = s.AddSpend(store.Spend{
+		Kind:    "topup",
+		BatchID: a.BatchID,
+		Amount:  a.Amount,
+		Depth:   a.Depth,
+		Cost:    a.Cost.String(),
+	})
+	if err != nil {
+		logger.Error("error recording top-up", "batch", a.BatchID, "err", err)
+	}
+}
+
+func printBatchPlan(actions []postage.Action) {
+	w := tabwriter.NewWriter(stdout, 2, 8, 2, ' ', 0)
+	if optionDryRun {
+		fmt.Fprintln(w, "Dry run, no batch was topped up")
+	}
+	fmt.Fprintf(w, "Batch\tDepth\tTTL\tAction\tAmount\tCost (PLUR)\tReason\t\n")
+	for _, a := range actions {
+		amount, cost := "-", "-"
+		if a.Cost != nil {
+			amount, cost = fmt.Sprint(a.Amount), a.Cost.String()
+		}
+		fmt.Fprintf(w, "%s\t%d\t%v\t%s\t%s\t%s\t%s\t\n",
+			a.BatchID, a.Depth, time.Duration(a.TTL)*time.Second, a.Kind, amount, cost, a.Reason)
+	}
+	w.Flush()
+}
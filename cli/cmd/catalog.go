@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/r0qs/beezim/internal/catalog"
+
+	"github.com/spf13/cobra"
+)
+
+const catalogCacheFile = "catalog.xml"
+
+func newCatalogCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "catalog",
+		Short: "List the ZIM files available in the Kiwix catalog",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if optionCatalogRefresh {
+				if err := catalogCache().Refresh(cmd.Context()); err != nil {
+					return err
+				}
+			}
+
+			entries, err := catalogEntries(cmd.Context(), catalogFilter(optionCatalogName))
+			if err != nil {
+				return err
+			}
+			if optionJSON {
+				runResult.Data = entries
+				return nil
+			}
+			printCatalog(entries)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&optionCatalogName, optionNameCatalogName, "", "filter entries by name (glob pattern or substring)")
+	cmd.Flags().BoolVar(&optionCatalogRefresh, optionNameCatalogRefresh, false, "ignore the cached catalog and fetch it again")
+	addCatalogFlags(cmd)
+
+	return cmd
+}
+
+// addCatalogFlags adds the flags shared by the commands that query the catalog.
+func addCatalogFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&optionCatalogLang, optionNameCatalogLang, "", "filter catalog entries by language code (e.g. \"eng\")")
+	cmd.Flags().StringVar(&optionCatalogCategory, optionNameCatalogCategory, "", "filter catalog entries by category (e.g. \"wikipedia\")")
+	cmd.Flags().DurationVar(&optionCatalogTTL, optionNameCatalogTTL, catalog.DefaultCacheTTL, "how long the cached catalog is kept before fetching it again")
+}
+
+func catalogCache() *catalog.Cache {
+	c := catalog.NewCache(filepath.Join(optionDataDir, catalogCacheFile), optionCatalogTTL)
+	c.Logger = logger
+	return c
+}
+
+func catalogFilter(name string) catalog.Filter {
+	return catalog.Filter{
+		Language: optionCatalogLang,
+		Category: optionCatalogCategory,
+		Name:     name,
+	}
+}
+
+func catalogEntries(ctx context.Context, f catalog.Filter) ([]catalog.Entry, error) {
+	entries, err := catalogCache().Entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return catalog.Select(entries, f), nil
+}
+
+// resolveCatalogURL returns the download url of the newest catalog entry matching the query.
+func resolveCatalogURL(ctx context.Context, query string) (string, error) {
+	entries, err := catalogCache().Entries(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	entry, err := catalog.Latest(entries, catalogFilter(query))
+	if err != nil {
+		return "", err
+	}
+	return entry.URL, nil
+}
+
+func printCatalog(entries []catalog.Entry) {
+	const sep = "======="
+
+	w := tabwriter.NewWriter(os.Stdout, 2, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "%s Kiwix Catalog: %d entries found %s\n", sep, len(entries), sep)
+	fmt.Fprintf(w, "#\tName\tTitle\tLanguage\tCategory\tSize\tDate\tURL\t\n")
+	for i, e := range entries {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			i+1, e.Name, e.Title, e.Language, e.Category, formatSize(e.Size), e.Date.Format("2006-01-02"), e.URL)
+	}
+	w.Flush()
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
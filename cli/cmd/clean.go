@@ -4,22 +4,144 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/internal/store"
+	"github.com/r0qs/beezim/internal/workdir"
 
 	"github.com/spf13/cobra"
 )
 
+const defaultWorkDir = "work"
+
+var work *workdir.Workdir
+
 func newCleanCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "clean",
-		Short: "Clean files in datadir",
+		Short: "Clean the artifacts left in the workdir",
+		Long: `Remove the artifacts of the workdir (tars and extraction directories) that are
+not needed to resume an unfinished run recorded in the local database.
+Use --all to delete every file in the datadir instead.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return cleanDatadir()
+			if optionCleanAll {
+				return cleanDatadir()
+			}
+
+			removed, err := cleanWorkdir(optionDryRun)
+			if err != nil {
+				return err
+			}
+			runResult.Data = removed
+			for _, p := range removed {
+				if optionDryRun {
+					fmt.Fprintln(stdout, "would delete", p)
+				} else {
+					fmt.Fprintln(stdout, "deleted", p)
+				}
+			}
+			if len(removed) == 0 {
+				fmt.Fprintln(stdout, "nothing to clean")
+			}
+			return nil
 		},
 	}
+	cmd.Flags().BoolVar(&optionCleanAll, optionNameCleanAll, false, "delete all files in the datadir, asking for confirmation")
+	cmd.Flags().BoolVar(&optionDryRun, optionNameDryRun, false, "only print the artifacts that would be deleted")
 
 	return cmd
 }
 
+// setWorkDir sets the directory of the artifacts to the workdir option or
+// to a directory in the datadir by default.
+func setWorkDir() (err error) {
+	dir := optionWorkDir
+	if dir == "" {
+		dir = filepath.Join(optionDataDir, defaultWorkDir)
+	}
+	work, err = workdir.New(dir)
+	return err
+}
+
+// artifactPath resolves the path of a tar or directory given by name.
+// Relative paths that do not exist are looked up in the workdir, then in
+// the datadir where they were written by older versions.
+func artifactPath(name string) string {
+	if _, err := os.Stat(name); err == nil || filepath.IsAbs(name) {
+		return name
+	}
+	if p := filepath.Join(work.Dir, name); exists(p) {
+		return p
+	}
+	if p := filepath.Join(optionDataDir, name); exists(p) {
+		return p
+	}
+	return filepath.Join(work.Dir, name)
+}
+
+func exists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+func addKeepFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&optionKeepTar, optionNameKeepTar, false, "keep the tar in the workdir after a successful upload")
+	cmd.Flags().BoolVar(&optionKeepExtracted, optionNameKeepExtracted, false, "keep the extracted zim in the workdir after a successful upload")
+}
+
+// releaseArtifacts removes the artifacts of an uploaded tar not kept by
+// the --keep-tar and --keep-extracted policy. Tars outside the workdir
+// are never removed.
+func releaseArtifacts(tarPath string) {
+	if filepath.Dir(tarPath) != filepath.Clean(work.Dir) {
+		return
+	}
+	err := work.Release(filepath.Base(tarPath), workdir.Policy{
+		KeepTar:       optionKeepTar,
+		KeepExtracted: optionKeepExtracted,
+	})
+	if err != nil {
+		logger.Warn("error removing the artifacts", "tar", filepath.Base(tarPath), "err", err)
+	}
+}
+
+// cleanWorkdir removes the artifacts of the workdir that are not referenced
+// by an unfinished run, which may still be resumed from them.
+func cleanWorkdir(dryRun bool) ([]string, error) {
+	s, err := store.Open(filepath.Join(optionDataDir, dbFile))
+	if err != nil {
+		return nil, err
+	}
+
+	keep := make(map[string]bool)
+	// a tar is resumed from its checkpoint and journal
+	keepTar := func(name string) {
+		keep[name] = true
+		keep[indexer.CheckpointFile(name)] = true
+		keep[indexer.JournalFile(name)] = true
+	}
+	for _, r := range s.Runs() {
+		if r.Status == store.StatusCompleted || r.Status == store.StatusSkipped {
+			continue
+		}
+		if r.TarFile != "" {
+			keepTar(r.TarFile)
+		}
+		if r.ZimFile != "" {
+			keep[strings.TrimSuffix(r.ZimFile, filepath.Ext(r.ZimFile))] = true
+			keep[filepath.Base(work.SearchTarPath(r.ZimFile))] = true
+			keep[filepath.Base(work.VolumesDir(r.ZimFile))] = true
+		}
+		for _, p := range r.Resume {
+			if filepath.Dir(p) == filepath.Clean(work.Dir) {
+				keepTar(filepath.Base(p))
+			}
+		}
+	}
+	return work.Clean(keep, dryRun)
+}
+
 // TODO: add option for no confirmation?
 func cleanDatadir() error {
 	if optionDataDir == "" || optionDataDir == "/" {
@@ -37,10 +159,10 @@ func cleanDatadir() error {
 		confirmationReader := NewConfirmationInputReader(action, func() error {
 			filePath := filepath.Join(baseDir, file.Name())
 			if file.IsDir() {
-				fmt.Println("deleting directory...", filePath)
+				fmt.Fprintln(stdout, "deleting directory...", filePath)
 				return os.RemoveAll(filePath)
 			}
-			fmt.Println("deleting file...", filePath)
+			fmt.Fprintln(stdout, "deleting file...", filePath)
 			return os.Remove(filePath)
 		})
 
@@ -0,0 +1,70 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/r0qs/beezim/internal/progress"
+	"github.com/r0qs/beezim/internal/store"
+)
+
+// TestCleanSparesCheckpoints cleans a workdir holding the artifacts of an
+// interrupted parse, resumable from the checkpoint of its tar, and of a
+// completed mirror.
+func TestCleanSparesCheckpoints(t *testing.T) {
+	dataDir := t.TempDir()
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(config, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	workDir := filepath.Join(dataDir, defaultWorkDir)
+	if err := os.MkdirAll(workDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		"wiki_en_all_2022-05.tar", "wiki_en_all_2022-05.tar.checkpoint", "wiki_en_all_2022-05.tar.journal",
+		"wiki_fr_all_2022-05.tar", "wiki_fr_all_2022-05.tar.checkpoint",
+	} {
+		if err := os.WriteFile(filepath.Join(workDir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := store.Open(filepath.Join(dataDir, dbFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	for _, r := range []store.Run{
+		{ID: "interrupted", Command: "parse", ZimFile: "wiki_en_all_2022-05.zim", TarFile: "wiki_en_all_2022-05.tar", Stage: "parse", Status: store.StatusInterrupted,
+			Resume: map[string]string{"partialTar": filepath.Join(workDir, "wiki_en_all_2022-05.tar")}, StartedAt: now, UpdatedAt: now},
+		{ID: "completed", Command: "mirror", ZimFile: "wiki_fr_all_2022-05.zim", TarFile: "wiki_fr_all_2022-05.tar", Status: store.StatusCompleted, StartedAt: now, UpdatedAt: now},
+	} {
+		if err := db.PutRun(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = execute(context.Background(), progress.ReporterFunc(func(progress.Event) {}), "clean", "--config", config, "--datadir", dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, e := range entries {
+		left = append(left, e.Name())
+	}
+	want := []string{"wiki_en_all_2022-05.tar", "wiki_en_all_2022-05.tar.checkpoint", "wiki_en_all_2022-05.tar.journal"}
+	if !slices.Equal(left, want) {
+		t.Errorf("left %v, want the tar of the interrupted parse with its checkpoint", left)
+	}
+}
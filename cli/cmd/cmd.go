@@ -1,15 +1,24 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
+	"testing"
+	"time"
 
+	"github.com/r0qs/beezim/indexer"
 	"github.com/r0qs/beezim/internal/beeclient"
+	"github.com/r0qs/beezim/internal/iobuf"
+	"github.com/r0qs/beezim/internal/limiter"
+	"github.com/r0qs/beezim/internal/progress"
+	"github.com/r0qs/beezim/internal/random"
+	"github.com/r0qs/beezim/pkg/logging"
 
 	"github.com/joho/godotenv"
 	"github.com/spf13/cobra"
@@ -22,46 +31,276 @@ const (
 var (
 	baseDir string
 	bee     *beeclient.BeeClient
+	// logger receives the log output of the commands and of the packages
+	// they use, written to stderr.
+	logger = logging.Default()
 )
 
 var (
-	optionKiwix          string
-	optionGasPrice       string
-	optionBeeApiUrl      string
-	optionBeeDebugApiUrl string
-	optionBeeBatchID     string
-	optionBeeBatchDepth  uint64
-	optionBeeBatchAmount int64
-	optionBeeTag         uint32
-	optionBeePin         bool
-	optionGatewayMode    bool
-	optionDataDir        string
-	optionClean          bool
-	optionZimFile        string
-	optionZimURL         string
-	optionTarFile        string
-	optionExtractOnly    bool
-	optionEnableSearch   bool
+	optionKiwix             string
+	optionGasPrice          string
+	optionBeeApiUrl         string
+	optionBeeDebugApiUrl    string
+	optionBeeBatchID        string
+	optionBeeBatchDepth     uint64
+	optionBeeBatchAmount    int64
+	optionBeeTag            uint32
+	optionBeePin            bool
+	optionGatewayMode       bool
+	optionDataDir           string
+	optionClean             bool
+	optionZimFile           string
+	optionZimURL            string
+	optionTarFile           string
+	optionExtractOnly       bool
+	optionExtractWorkers    int
+	optionOverwrite         string
+	optionEnableSearch      bool
+	optionFromCatalog       string
+	optionCatalogName       string
+	optionCatalogLang       string
+	optionCatalogCategory   string
+	optionCatalogTTL        time.Duration
+	optionCatalogRefresh    bool
+	optionDownloadMirrors   []string
+	optionDownloadRetries   int
+	optionVerifyChecksum    bool
+	optionServeTar          string
+	optionServeDir          string
+	optionServeAddr         string
+	optionJSON              bool
+	optionJSONOut           string
+	optionConfig            string
+	optionWatchWikis        []string
+	optionWatchInterval     time.Duration
+	optionWatchJitter       time.Duration
+	optionWatchDir          string
+	optionWatchStatusFile   string
+	optionWatchStatusAddr   string
+	optionNotifyURLs        []string
+	optionNotifySecret      string
+	optionNotifyTemplate    string
+	optionNotifyRetries     int
+	optionSignKey           string
+	optionSignKeyFile       string
+	optionKeystore          string
+	optionKeyPassword       string
+	optionVerifyRoot        string
+	optionVerifyPubKey      string
+	optionVerifySignature   string
+	optionTopUpThreshold    time.Duration
+	optionTopUpTarget       time.Duration
+	optionTopUpMaxSpend     string
+	optionTopUpSpendPeriod  time.Duration
+	optionTopUpBlockTime    time.Duration
+	optionDryRun            bool
+	optionWatchBatches      bool
+	optionCheckGateways     []string
+	optionCheckSample       int
+	optionCheckInterval     time.Duration
+	optionRequestRate       float64
+	optionBatchZims         []string
+	optionBatchURLs         []string
+	optionParallel          int
+	optionParseWorkers      int
+	optionTarWriters        int
+	optionUploadMemory      int64
+	optionMinUploads        int
+	optionMaxUploads        int
+	optionForce             bool
+	optionWorkDir           string
+	optionKeepTar           bool
+	optionKeepExtracted     bool
+	optionCleanAll          bool
+	optionExportOut         string
+	optionHistory           int
+	optionSplitSearch       bool
+	optionParseBatchSize    int
+	optionParseBuffer       int
+	optionReadWorkers       int
+	optionIncludeNamespaces string
+	optionExcludeNamespaces string
+	optionAllowMimes        []string
+	optionBlockMimes        []string
+	optionMimePlaceholders  bool
+	optionMimeOverrides     []string
+	optionSniffMime         bool
+	optionMinify            bool
+	optionOffline           string
+	optionRelativeLinks     bool
+	optionNavBar            bool
+	optionMaxArticleSize    int64
+	optionVerifyZim         bool
+	optionDedup             string
+	optionShareAssets       bool
+	optionCollisions        string
+	optionEntryStore        string
+	optionMainPage          string
+	optionListingPageSize   int
+	optionDropTitleIndex    bool
+	optionDropSearchIndexes bool
+	optionSnippets          bool
+	optionSitemap           bool
+	optionSitemapURL        string
+	optionTemplateDir       string
+	optionAssetsDir         string
+	optionExtraFiles        []string
+	optionLanguage          string
+	optionTheme             string
+	optionStream            bool
+	optionGzipLevel         int
+	optionVolumeSize        int64
+	optionDiffFrom          string
+	optionCheckpointEvery   int
+	optionResume            bool
+	optionVolumes           string
+	optionPrintFiles        bool
+	optionManifestRedirects bool
+	optionCompressBuffered  bool
+	optionPanicBudget       int
+	optionSkipBudget        int
+	optionSkippedReport     string
+	optionReproducible      bool
+	optionTarBuffer         int
+	optionCopyBuffer        int
+	optionUploadChunk       int
+	optionDownloadBuffer    int
+	optionMinFreeSpace      int64
+	optionSeed              int64
+	optionOnLocked          string
+	optionLockTimeout       time.Duration
 )
 
 const (
-	optionNameKiwix          = "kiwix"
-	optionNameGasPrice       = "gas-price"
-	optionNameBeeApiUrl      = "bee-api-url"
-	optionNameBeeDebugApiUrl = "bee-debug-api-url"
-	optionNameBeeBatchID     = "batch-id"
-	optionNameBeeBatchDepth  = "batch-depth"
-	optionNameBeeBatchAmount = "batch-amount"
-	optionNameBeeTag         = "tag"
-	optionNameBeePin         = "pin"
-	optionNameGatewayMode    = "gateway"
-	optionNameDataDir        = "datadir"
-	optionNameClean          = "clean"
-	optionNameZimFile        = "zim"
-	optionNameZimURL         = "url"
-	optionNameTarFile        = "tar"
-	optionNameExtractOnly    = "extract-only"
-	optionNameEnableSearch   = "enable-search"
+	optionNameKiwix             = "kiwix"
+	optionNameGasPrice          = "gas-price"
+	optionNameBeeApiUrl         = "bee-api-url"
+	optionNameBeeDebugApiUrl    = "bee-debug-api-url"
+	optionNameBeeBatchID        = "batch-id"
+	optionNameBeeBatchDepth     = "batch-depth"
+	optionNameBeeBatchAmount    = "batch-amount"
+	optionNameBeeTag            = "tag"
+	optionNameBeePin            = "pin"
+	optionNameGatewayMode       = "gateway"
+	optionNameDataDir           = "datadir"
+	optionNameClean             = "clean"
+	optionNameZimFile           = "zim"
+	optionNameZimURL            = "url"
+	optionNameTarFile           = "tar"
+	optionNameExtractOnly       = "extract-only"
+	optionNameExtractWorkers    = "extract-workers"
+	optionNameOverwrite         = "overwrite"
+	optionNameEnableSearch      = "enable-search"
+	optionNameFromCatalog       = "from-catalog"
+	optionNameCatalogName       = "name"
+	optionNameCatalogLang       = "lang"
+	optionNameCatalogCategory   = "category"
+	optionNameCatalogTTL        = "catalog-ttl"
+	optionNameCatalogRefresh    = "refresh"
+	optionNameDownloadMirrors   = "mirror-url"
+	optionNameDownloadRetries   = "retries"
+	optionNameVerifyChecksum    = "verify-checksum"
+	optionNameServeDir          = "dir"
+	optionNameServeAddr         = "addr"
+	optionNameJSON              = "json"
+	optionNameJSONOut           = "json-out"
+	optionNameConfig            = "config"
+	optionNameWatchWikis        = "wiki"
+	optionNameWatchInterval     = "interval"
+	optionNameWatchJitter       = "jitter"
+	optionNameWatchDir          = "watch-dir"
+	optionNameWatchStatusFile   = "status-file"
+	optionNameWatchStatusAddr   = "status-addr"
+	optionNameNotifyURLs        = "notify-url"
+	optionNameNotifySecret      = "notify-secret"
+	optionNameNotifyTemplate    = "notify-template"
+	optionNameNotifyRetries     = "notify-retries"
+	optionNameSignKey           = "sign-key"
+	optionNameSignKeyFile       = "sign-key-file"
+	optionNameKeystore          = "keystore"
+	optionNameKeyPassword       = "key-password"
+	optionNameVerifyRoot        = "root"
+	optionNameVerifyPubKey      = "pubkey"
+	optionNameVerifySignature   = "signature"
+	optionNameTopUpThreshold    = "ttl-threshold"
+	optionNameTopUpTarget       = "ttl-target"
+	optionNameTopUpMaxSpend     = "max-spend"
+	optionNameTopUpSpendPeriod  = "spend-period"
+	optionNameTopUpBlockTime    = "block-time"
+	optionNameDryRun            = "dry-run"
+	optionNameWatchBatches      = "maintain-batches"
+	optionNameCheckGateways     = "check-gateway"
+	optionNameCheckSample       = "check-sample"
+	optionNameCheckInterval     = "check-interval"
+	optionNameRequestRate       = "request-rate"
+	optionNameParallel          = "parallel"
+	optionNameParseWorkers      = "parse-workers"
+	optionNameTarWriters        = "tar-writers"
+	optionNameUploadMemory      = "upload-memory"
+	optionNameMinUploads        = "min-uploads"
+	optionNameMaxUploads        = "max-uploads"
+	optionNameForce             = "force"
+	optionNameWorkDir           = "workdir"
+	optionNameKeepTar           = "keep-tar"
+	optionNameKeepExtracted     = "keep-extracted"
+	optionNameCleanAll          = "all"
+	optionNameExportOut         = "out"
+	optionNameHistory           = "history"
+	optionNameSplitSearch       = "split-search"
+	optionNameParseBatchSize    = "parse-batch-size"
+	optionNameParseBuffer       = "parse-buffer"
+	optionNameReadWorkers       = "read-workers"
+	optionNameIncludeNamespaces = "include-namespaces"
+	optionNameExcludeNamespaces = "exclude-namespaces"
+	optionNameAllowMimes        = "allow-mime"
+	optionNameBlockMimes        = "block-mime"
+	optionNameMimePlaceholders  = "mime-placeholders"
+	optionNameMimeOverrides     = "mime-override"
+	optionNameSniffMime         = "sniff-mime"
+	optionNameMinify            = "minify"
+	optionNameOffline           = "offline"
+	optionNameRelativeLinks     = "relative-links"
+	optionNameNavBar            = "nav-bar"
+	optionNameMaxArticleSize    = "max-article-size"
+	optionNameVerifyZim         = "verify-zim"
+	optionNameDedup             = "dedup"
+	optionNameShareAssets       = "share-assets"
+	optionNameCollisions        = "collisions"
+	optionNameEntryStore        = "entry-store"
+	optionNameMainPage          = "main-page"
+	optionNameListingPageSize   = "listing-page-size"
+	optionNameDropTitleIndex    = "drop-title-index"
+	optionNameDropSearchIndexes = "drop-search-indexes"
+	optionNameSnippets          = "snippets"
+	optionNameSitemap           = "sitemap"
+	optionNameSitemapURL        = "sitemap-url"
+	optionNameTemplateDir       = "template-dir"
+	optionNameAssetsDir         = "assets-dir"
+	optionNameExtraFiles        = "extra-file"
+	optionNameLanguage          = "language"
+	optionNameTheme             = "theme"
+	optionNameStream            = "stream"
+	optionNameGzipLevel         = "gzip-level"
+	optionNameVolumeSize        = "volume-size"
+	optionNameDiffFrom          = "diff-from"
+	optionNameCheckpointEvery   = "checkpoint-every"
+	optionNameResume            = "resume"
+	optionNameVolumes           = "volumes"
+	optionNamePrintFiles        = "print-files"
+	optionNameManifestRedirects = "manifest-redirects"
+	optionNameCompressBuffered  = "compress-buffered"
+	optionNamePanicBudget       = "panic-budget"
+	optionNameSkipBudget        = "skip-budget"
+	optionNameSkippedReport     = "skipped-report"
+	optionNameReproducible      = "reproducible"
+	optionNameTarBuffer         = "tar-buffer"
+	optionNameCopyBuffer        = "copy-buffer"
+	optionNameUploadChunk       = "upload-chunk"
+	optionNameDownloadBuffer    = "download-buffer"
+	optionNameMinFreeSpace      = "min-free-space"
+	optionNameSeed              = "seed"
+	optionNameOnLocked          = "on-locked"
+	optionNameLockTimeout       = "lock-timeout"
 )
 
 func init() {
@@ -71,8 +310,12 @@ func init() {
 	// TODO: load from config (use viper)
 	// FIXME: this approach currently does not work with make install.
 	// TODO: move config files to home over ~/.beezim
-	if err := godotenv.Load(filepath.Join(baseDir, ".env")); err != nil {
-		log.Fatalf("error loading .env file: %v", err)
+	// the tests of the commands do not depend on the .env of the checkout
+	if !testing.Testing() {
+		if err := godotenv.Load(filepath.Join(baseDir, ".env")); err != nil {
+			logger.Error("error loading .env file", "err", err)
+			os.Exit(1)
+		}
 	}
 
 	rootCmd.PersistentFlags().StringVar(&optionKiwix, optionNameKiwix, "wikipedia", "name of the compressed website hosted by Kiwix. Run \"list\" to see all available options")
@@ -86,8 +329,51 @@ func init() {
 	rootCmd.PersistentFlags().BoolVar(&optionBeePin, optionNameBeePin, false, "whether the uploaded data should be locally pinned on a node")
 	rootCmd.PersistentFlags().BoolVar(&optionGatewayMode, optionNameGatewayMode, false, fmt.Sprintf("connect to the swarm public gateway (default \"%s\")", os.Getenv("BEE_GATEWAY")))
 	rootCmd.PersistentFlags().StringVar(&optionDataDir, optionNameDataDir, "", "path to datadir directory (default \"./datadir\")")
+	rootCmd.PersistentFlags().StringVar(&optionWorkDir, optionNameWorkDir, "", fmt.Sprintf("path to the directory of the generated tars and extracted zims (default \"<datadir>/%s\")", defaultWorkDir))
+	rootCmd.PersistentFlags().Int64Var(&optionMinFreeSpace, optionNameMinFreeSpace, 256, "MiB kept free in the workdir: required besides the estimate of a parse, which stops when less is left (0 to not check while writing)")
 	rootCmd.PersistentFlags().BoolVar(&optionClean, optionNameClean, false, "delete all downloaded zim and generated tar files")
 	rootCmd.PersistentFlags().BoolVar(&optionEnableSearch, optionNameEnableSearch, false, "enable search index")
+	rootCmd.PersistentFlags().StringVar(&optionIncludeNamespaces, optionNameIncludeNamespaces, "", "the only zim namespaces parsed, e.g. \"-AI\", instead of those parsed by default")
+	rootCmd.PersistentFlags().StringVar(&optionExcludeNamespaces, optionNameExcludeNamespaces, "", "zim namespaces never parsed, e.g. \"I\" to leave out the media, even when included")
+	rootCmd.PersistentFlags().StringSliceVar(&optionAllowMimes, optionNameAllowMimes, nil, "glob pattern of the only mime types of the articles parsed, e.g. \"text/*\" (can be repeated)")
+	rootCmd.PersistentFlags().StringSliceVar(&optionBlockMimes, optionNameBlockMimes, nil, "glob pattern of mime types of the articles never parsed, e.g. \"image/*\", even when allowed (can be repeated)")
+	rootCmd.PersistentFlags().BoolVar(&optionMimePlaceholders, optionNameMimePlaceholders, false, "replace the articles left out by --allow-mime and --block-mime by small placeholders, so the links to them do not fail")
+	rootCmd.PersistentFlags().StringArrayVar(&optionMimeOverrides, optionNameMimeOverrides, nil, "mime type given to the entries instead of that of the zim, as pattern=type, the pattern an extension such as .svg or a glob of the entry paths such as \"I/*.svg\", e.g. .svg=image/svg+xml (can be repeated)")
+	rootCmd.PersistentFlags().BoolVar(&optionSniffMime, optionNameSniffMime, false, "guess the mime type of the entries the zim gives an empty type or application/octet-stream from their extension or content")
+	rootCmd.PersistentFlags().BoolVar(&optionMinify, optionNameMinify, false, "minify the html, css and javascript articles, dropping their comments and collapsing their spaces, those that can not be minified being kept as they are")
+	rootCmd.PersistentFlags().StringVar(&optionOffline, optionNameOffline, "keep", "rewrite the html articles so that browsing them sends no request out of the tar: keep them as they are, annotate to drop the scripts, stylesheets and images of other sites and open the links to other sites in a new tab without referrer, or strip to also make those links plain text")
+	rootCmd.PersistentFlags().BoolVar(&optionRelativeLinks, optionNameRelativeLinks, false, "make the root-absolute links of the html and css articles, e.g. \"/A/Foo\", relative to the article, so that they work when the tar is served under a path, e.g. /bzz/<hash>/")
+	rootCmd.PersistentFlags().BoolVar(&optionNavBar, optionNameNavBar, false, "add to the html articles a navigation bar linking to the index page of the tar, with the search box when --enable-search is set")
+	rootCmd.PersistentFlags().Int64Var(&optionMaxArticleSize, optionNameMaxArticleSize, 0, "MiB above which the articles are left out and listed in a skipped.html page of the tar (0 for no limit)")
+	rootCmd.PersistentFlags().BoolVar(&optionVerifyZim, optionNameVerifyZim, false, "verify the md5 checksum of the zim before parsing it, failing on a corrupt or truncated file")
+	rootCmd.PersistentFlags().StringVar(&optionDedup, optionNameDedup, "off", "replace the articles identical to one parsed before by small aliases of it: off, assets (all but the HTML pages) or all")
+	rootCmd.PersistentFlags().BoolVar(&optionShareAssets, optionNameShareAssets, false, "serve the assets already uploaded with the same batch by another wiki from its manifest instead of uploading them again")
+	rootCmd.PersistentFlags().StringVar(&optionCollisions, optionNameCollisions, "keep-last", "which of the entries of a zim at the same path is kept, the others being left out: keep-last, keep-first or error to fail the parse")
+	rootCmd.PersistentFlags().StringVar(&optionEntryStore, optionNameEntryStore, "memory", "where a parse keeps the entries of the zim listed by the pages of the tar: memory, or disk for the zims of millions of entries, in a temporary database of the workdir")
+	rootCmd.PersistentFlags().StringVar(&optionMainPage, optionNameMainPage, "", "path of the entry taken for the main page of the zims, e.g. \"A/Home\", instead of the one they tell or guessed without one")
+	rootCmd.PersistentFlags().IntVar(&optionListingPageSize, optionNameListingPageSize, indexer.DefaultListingPageSize, "number of articles of each page listing the html articles of the tar from A to Z")
+	rootCmd.PersistentFlags().BoolVar(&optionDropTitleIndex, optionNameDropTitleIndex, false, "leave the xapian title index and title listings of the zim out of the tar, the search page suggesting the titles of _beezim/titles.json instead")
+	rootCmd.PersistentFlags().BoolVar(&optionDropSearchIndexes, optionNameDropSearchIndexes, false, "leave the whole x namespace of the zim, its xapian full text and title indexes, out of the tar or directory, the search page searching the titles only")
+	rootCmd.PersistentFlags().BoolVar(&optionSnippets, optionNameSnippets, false, "extract the first 200 characters of the text of the html articles, shown under the title search results of the search page")
+	rootCmd.PersistentFlags().BoolVar(&optionSitemap, optionNameSitemap, false, "add the sitemap of the html articles to the tar, _beezim/sitemap.xml and its shards of 50000 urls")
+	rootCmd.PersistentFlags().StringVar(&optionSitemapURL, optionNameSitemapURL, "", "absolute url of the root of the uploaded tar, e.g. of a gateway, prefixing the urls of the sitemap instead of relative ones, implies --sitemap")
+	rootCmd.PersistentFlags().StringVar(&optionTemplateDir, optionNameTemplateDir, "", "directory of templates of the pages of the tar, e.g. page/header.html, replacing the embedded ones of the same name")
+	rootCmd.PersistentFlags().StringVar(&optionLanguage, optionNameLanguage, "", "language of the pages of the tar, e.g. \"fa\" or \"fas\", instead of the language metadata of the zim")
+	rootCmd.PersistentFlags().StringVar(&optionTheme, optionNameTheme, "", "stylesheet of the pages of the tar, \"light\", \"dark\", \"sepia\" or the path of a css file packed into the tar")
+	rootCmd.PersistentFlags().StringVar(&optionAssetsDir, optionNameAssetsDir, "", "directory of assets packed into the tar, e.g. css/beezim.css, replacing the embedded ones of the same name")
+	rootCmd.PersistentFlags().StringArrayVar(&optionExtraFiles, optionNameExtraFiles, nil, "local file or directory packed into the tar after the articles, as path=local, e.g. about.html=./about.html, or .=./branding for the files of a directory at the root (can be repeated)")
+	rootCmd.PersistentFlags().BoolVar(&optionManifestRedirects, optionNameManifestRedirects, false, "leave the redirects out of the tar and add them to the manifest after the upload, serving their target, instead of redirect pages")
+	rootCmd.PersistentFlags().BoolVar(&optionJSON, optionNameJSON, false, "write a machine-readable JSON result to stdout, logs are written to stderr")
+	rootCmd.PersistentFlags().StringVar(&optionConfig, optionNameConfig, "", fmt.Sprintf("path to the configuration file (default \"~/%s\")", defaultConfigFile))
+	rootCmd.PersistentFlags().Float64Var(&optionRequestRate, optionNameRequestRate, 0, "maximum requests per second sent to the bee node (0 for unlimited)")
+	rootCmd.PersistentFlags().StringVar(&optionJSONOut, optionNameJSONOut, "", "write the JSON result to this file instead of stdout (implies --json)")
+	rootCmd.PersistentFlags().Int64Var(&optionSeed, optionNameSeed, 0, "seed of the random samples, e.g. the files verified, to choose them again (random when 0)")
+	rootCmd.PersistentFlags().IntVar(&optionTarBuffer, optionNameTarBuffer, iobuf.DefaultTarWriter>>10, "KiB buffered in front of the tars written")
+	rootCmd.PersistentFlags().IntVar(&optionCopyBuffer, optionNameCopyBuffer, iobuf.DefaultFileCopy>>10, "KiB of the writes of the files extracted from a zim")
+	rootCmd.PersistentFlags().IntVar(&optionUploadChunk, optionNameUploadChunk, iobuf.DefaultUploadChunk>>10, "KiB of the writes of the uploads to the bee node")
+	rootCmd.PersistentFlags().StringVar(&optionOnLocked, optionNameOnLocked, onLockedFail, fmt.Sprintf("what to do with a wiki used by another run: %q for it until --%s, %q it or %q", onLockedWait, optionNameLockTimeout, onLockedSkip, onLockedFail))
+	rootCmd.PersistentFlags().DurationVar(&optionLockTimeout, optionNameLockTimeout, 30*time.Minute, fmt.Sprintf("how long to wait for a wiki used by another run with --%s=%s", optionNameOnLocked, onLockedWait))
+	rootCmd.PersistentFlags().IntVar(&optionDownloadBuffer, optionNameDownloadBuffer, iobuf.DefaultDownloadCopy>>10, "KiB of the buffer copying the downloads to disk")
 }
 
 var rootCmd = &cobra.Command{
@@ -95,7 +381,20 @@ var rootCmd = &cobra.Command{
 	Short:         "Swarm zim mirror command-line tool",
 	SilenceErrors: true,
 	SilenceUsage:  true,
-	PersistentPreRunE: func(_ *cobra.Command, _ []string) (err error) {
+	PersistentPreRunE: func(cmd *cobra.Command, _ []string) (err error) {
+		if err := applyConfig(cmd); err != nil {
+			return err
+		}
+
+		if optionJSONOut != "" {
+			optionJSON = true
+		}
+		if optionSeed == 0 {
+			optionSeed = random.NewSeed()
+		}
+		initResult(cmd)
+		startProgress()
+
 		if optionGatewayMode {
 			optionBeeApiUrl = os.Getenv("BEE_GATEWAY")
 			optionBeeDebugApiUrl = ""
@@ -106,7 +405,13 @@ var rootCmd = &cobra.Command{
 			return err
 		}
 
-		return setDataDir()
+		if err := setDataDir(); err != nil {
+			return err
+		}
+		if err := setWorkDir(); err != nil {
+			return err
+		}
+		return startRun(cmd)
 	},
 }
 
@@ -118,9 +423,45 @@ func Execute() (err error) {
 		newParserCmd(),
 		newMirrorCmd(),
 		newCleanCmd(),
+		newCatalogCmd(),
+		newServeCmd(),
+		newConfigCmd(),
+		newWatchCmd(),
+		newVerifySignatureCmd(),
+		newBatchCmd(),
+		newCheckGatewaysCmd(),
+		newDBCmd(),
+		newVersionCmd(),
 	)
 
-	return rootCmd.Execute()
+	ctx, stop := handleSignals(context.Background())
+	defer stop()
+	ctx = progress.WithReporter(ctx, reportProgress)
+
+	err = rootCmd.ExecuteContext(ctx)
+	stopProgress()
+	err = finishRun(err)
+	if errors.Is(err, errSkipped) {
+		err = nil
+	}
+	if bee != nil {
+		bee.Shutdown()
+	}
+
+	if werr := writeResult(err); werr != nil && err == nil {
+		err = werr
+	}
+	return describeError(err)
+}
+
+// bufferConfig returns the sizes of the I/O buffers given in KiB.
+func bufferConfig() iobuf.Config {
+	return iobuf.Config{
+		TarWriter:    optionTarBuffer << 10,
+		FileCopy:     optionCopyBuffer << 10,
+		UploadChunk:  optionUploadChunk << 10,
+		DownloadCopy: optionDownloadBuffer << 10,
+	}
 }
 
 // setDataDir sets the data directory to the root directory
@@ -147,7 +488,7 @@ func makeURL(filePath string) string {
 
 func NewBeeClient(beeApiUrl string, beeDebugApiUrl string) (*beeclient.BeeClient, error) {
 	var err error
-	opts := beeclient.ClientOptions{}
+	opts := beeclient.ClientOptions{Buffers: bufferConfig()}
 
 	opts.APIURL, err = url.Parse(beeApiUrl)
 	if err != nil {
@@ -161,6 +502,11 @@ func NewBeeClient(beeApiUrl string, beeDebugApiUrl string) (*beeclient.BeeClient
 		}
 	}
 
+	// a single limiter is shared by the api and the debug api clients
+	if rate := limiter.NewRate(optionRequestRate); rate != nil {
+		opts.RateLimiter = rate
+	}
+
 	return beeclient.NewBee(opts)
 }
 
@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/r0qs/beezim/internal/config"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const defaultConfigFile = ".beezim/config.yaml"
+
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the configuration file",
+	}
+
+	validateCmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Validate the configuration file and print the effective configuration for a zim",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if loadedConfig == nil {
+				return fmt.Errorf("no configuration file found, use --%s", optionNameConfig)
+			}
+			printEffectiveConfig(cmd, loadedConfig.Resolve(configZimName()))
+			return nil
+		},
+	}
+	validateCmd.Flags().StringVar(&optionZimFile, optionNameZimFile, "", "zim file name used to match the per-wiki sections")
+
+	cmd.AddCommand(validateCmd)
+	return cmd
+}
+
+var (
+	loadedConfig *config.Config
+	// userFlags are the flags given in the command line, which take
+	// precedence over the configuration file.
+	userFlags = make(map[string]bool)
+	// configFlags are the flags set from the configuration file.
+	configFlags = make(map[string]bool)
+)
+
+// envFlags are the environment variables of the flags, whose values take
+// precedence over the configuration file but not over the command line.
+var envFlags = map[string]string{
+	optionNameBeeApiUrl:      "BEE_API_URL",
+	optionNameBeeDebugApiUrl: "BEE_DEBUG_API_URL",
+	optionNameNotifySecret:   notifySecretEnv,
+}
+
+// flagEnv returns the environment variable set for the flag, "" when it
+// has none or it is not set.
+func flagEnv(name string) string {
+	if env, ok := envFlags[name]; ok && os.Getenv(env) != "" {
+		return env
+	}
+	return ""
+}
+
+// applyConfig loads the configuration file and sets the flags that were not
+// given in the command line. Per-wiki sections matching the zim being
+// processed override the global section.
+func applyConfig(cmd *cobra.Command) error {
+	configFile := optionConfig
+	if configFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		configFile = filepath.Join(home, defaultConfigFile)
+		if _, err := os.Stat(configFile); err != nil {
+			return nil
+		}
+	}
+
+	c, err := config.Load(configFile)
+	if err != nil {
+		return err
+	}
+	if err := c.Validate(func(name string) bool { return knownOption(cmd.Root(), name) }); err != nil {
+		return fmt.Errorf("%s: %v", configFile, err)
+	}
+	loadedConfig = c
+
+	userFlags, configFlags = make(map[string]bool), make(map[string]bool)
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		userFlags[f.Name] = true
+	})
+	// the defaults of the flags are read from the environment when the
+	// commands are made, it may have changed since
+	for name := range envFlags {
+		f := cmd.Flags().Lookup(name)
+		if f == nil || userFlags[name] || flagEnv(name) == "" {
+			continue
+		}
+		if err := f.Value.Set(os.Getenv(envFlags[name])); err != nil {
+			return fmt.Errorf("invalid value of %s for option %s: %v", envFlags[name], name, err)
+		}
+	}
+
+	// the global section may define the zim itself, so it is applied
+	// before resolving the per-wiki sections
+	if err := setFlags(cmd, c.Resolve("")); err != nil {
+		return err
+	}
+	return setFlags(cmd, c.Resolve(configZimName()))
+}
+
+// applyWikiConfig replaces the options set from the configuration file
+// by the ones resolved for the given zim. It is used by the commands that
+// process more than one zim.
+func applyWikiConfig(cmd *cobra.Command, zimName string) error {
+	if loadedConfig == nil {
+		return nil
+	}
+
+	for name := range configFlags {
+		f := cmd.Flags().Lookup(name)
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			sv.Replace(nil)
+		} else if err := f.Value.Set(f.DefValue); err != nil {
+			return err
+		}
+	}
+	configFlags = make(map[string]bool)
+	return setFlags(cmd, loadedConfig.Resolve(zimName))
+}
+
+func setFlags(cmd *cobra.Command, opts map[string]config.Value) error {
+	for name, v := range opts {
+		f := cmd.Flags().Lookup(name)
+		// options of other commands are ignored
+		if f == nil || userFlags[name] || flagEnv(name) != "" {
+			continue
+		}
+		// Set appends to lists already set, so overrides replace them instead
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			var items []string
+			if v.Value != "" {
+				items = strings.Split(v.Value, ",")
+			}
+			if err := sv.Replace(items); err != nil {
+				return fmt.Errorf("invalid value %q for option %s in config (%s): %v", v.Value, name, v.Source, err)
+			}
+		} else if err := f.Value.Set(v.Value); err != nil {
+			return fmt.Errorf("invalid value %q for option %s in config (%s): %v", v.Value, name, v.Source, err)
+		}
+		configFlags[name] = true
+	}
+	return nil
+}
+
+// configZimName returns the name of the zim used to match the per-wiki sections.
+func configZimName() string {
+	switch {
+	case optionZimFile != "":
+		return filepath.Base(optionZimFile)
+	case optionZimURL != "":
+		return path.Base(optionZimURL)
+	case optionTarFile != "":
+		return filepath.Base(optionTarFile)
+	}
+	return ""
+}
+
+// knownOption reports whether any command accepts the named flag.
+func knownOption(root *cobra.Command, name string) bool {
+	if name == optionNameConfig || name == "help" {
+		return false
+	}
+
+	var found bool
+	var walk func(c *cobra.Command)
+	walk = func(c *cobra.Command) {
+		if c.Flags().Lookup(name) != nil || c.PersistentFlags().Lookup(name) != nil {
+			found = true
+			return
+		}
+		for _, sub := range c.Commands() {
+			walk(sub)
+		}
+	}
+	walk(root)
+	return found
+}
+
+func printEffectiveConfig(cmd *cobra.Command, opts map[string]config.Value) {
+	names := make([]string, 0, len(opts))
+	effective := make(map[string]config.Value, len(opts))
+	for name, v := range opts {
+		names = append(names, name)
+		// the command line, then the environment, override the file
+		if f := cmd.Flags().Lookup(name); f != nil && f.Changed {
+			v = config.Value{Value: f.Value.String(), Source: "flag"}
+		} else if env := flagEnv(name); f != nil && env != "" {
+			v = config.Value{Value: f.Value.String(), Source: "env " + env}
+		}
+		effective[name] = v
+	}
+	sort.Strings(names)
+
+	if optionJSON {
+		runResult.Data = effective
+		return
+	}
+
+	w := tabwriter.NewWriter(stdout, 2, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Effective configuration for %q\n", configZimName())
+	fmt.Fprintf(w, "Option\tValue\tSource\t\n")
+	for _, name := range names {
+		v := effective[name]
+		fmt.Fprintf(w, "%s\t%s\t%s\t\n", name, v.Value, v.Source)
+	}
+	w.Flush()
+}
@@ -0,0 +1,100 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// resetFlag sets the persistent flag of the root command back to its
+// default once the test is done, the commands made again keeping them.
+func resetFlag(t *testing.T, name string) {
+	t.Cleanup(func() {
+		f := rootCmd.PersistentFlags().Lookup(name)
+		f.Value.Set(f.DefValue)
+		f.Changed = false
+	})
+}
+
+// TestConfigPrecedence resolves the options of a wiki from the command
+// line over the environment over the sections of the configuration file.
+func TestConfigPrecedence(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	data := `global:
+  bee-api-url: http://config:1633
+  kiwix: wiktionary
+wikis:
+  - match: "wikipedia_*"
+    options:
+      kiwix: wikipedia
+  - match: "*_en_*"
+    options:
+      kiwix: wikibooks
+  - match: "*_fr_*"
+    options:
+      kiwix: wikiversity
+`
+	if err := os.WriteFile(config, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	resetFlag(t, optionNameBeeApiUrl)
+	resetFlag(t, optionNameKiwix)
+	ctx := context.Background()
+	validate := func(args ...string) {
+		t.Helper()
+		args = append([]string{"config", "validate", "--config", config, "--datadir", t.TempDir()}, args...)
+		if err := execute(ctx, nil, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("BEE_API_URL", "")
+	validate("--zim", "wiktionary_de_all.zim")
+	if optionBeeApiUrl != "http://config:1633" || optionKiwix != "wiktionary" {
+		t.Errorf("global section: got %s and %s", optionBeeApiUrl, optionKiwix)
+	}
+	// the sections matching the wiki override the global one, in order
+	validate("--zim", "wikipedia_en_all.zim")
+	if optionKiwix != "wikibooks" {
+		t.Errorf("got %s, want the last section matching the wiki", optionKiwix)
+	}
+	validate("--zim", "wikipedia_de_all.zim")
+	if optionKiwix != "wikipedia" {
+		t.Errorf("got %s, want the only section matching the wiki", optionKiwix)
+	}
+
+	// the environment overrides the file, the command line both
+	t.Setenv("BEE_API_URL", "http://env:1633")
+	validate("--zim", "wikipedia_en_all.zim")
+	if optionBeeApiUrl != "http://env:1633" {
+		t.Errorf("got %s, want the url of the environment", optionBeeApiUrl)
+	}
+	validate("--zim", "wikipedia_en_all.zim", "--bee-api-url", "http://flag:1633", "--kiwix", "wikinews")
+	if optionBeeApiUrl != "http://flag:1633" || optionKiwix != "wikinews" {
+		t.Errorf("got %s and %s, want the options of the command line", optionBeeApiUrl, optionKiwix)
+	}
+}
+
+func TestConfigUnknownOption(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	data := `global:
+  bee-api-url: http://config:1633
+  colour: blue
+wikis:
+  - match: "wikipedia_*"
+    options:
+      retry: 3
+`
+	if err := os.WriteFile(config, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	resetFlag(t, optionNameBeeApiUrl)
+	err := execute(context.Background(), nil, "config", "validate", "--config", config, "--datadir", t.TempDir())
+	want := config + ": unknown options in config: global: colour, wikis[wikipedia_*]: retry"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/r0qs/beezim/internal/store"
+
+	"github.com/spf13/cobra"
+)
+
+func newDBCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "db",
+		Short: "Export and import the local database of the mirrors",
+	}
+
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write a portable dump of the local database",
+		Long: "\nWrites the runs, postage spends and gateway checks recorded in the local database as a schema-versioned JSON document," +
+			"\nto stdout or --out. The credentials of the zim urls and the local artifacts of the runs are not exported.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if optionJSON && optionExportOut == "" {
+				return fmt.Errorf("--%s is required with --json", optionNameExportOut)
+			}
+
+			s, err := store.Open(filepath.Join(optionDataDir, dbFile))
+			if err != nil {
+				return err
+			}
+			data, err := json.MarshalIndent(s.Export(), "", "  ")
+			if err != nil {
+				return err
+			}
+			data = append(data, '\n')
+
+			if optionExportOut == "" {
+				_, err = os.Stdout.Write(data)
+				return err
+			}
+			if err := os.WriteFile(optionExportOut, data, 0644); err != nil {
+				return fmt.Errorf("error writing export: %v", err)
+			}
+			fmt.Fprintf(stdout, "Database exported to %s\n", optionExportOut)
+			return nil
+		},
+	}
+	exportCmd.Flags().StringVar(&optionExportOut, optionNameExportOut, "", "file to write the export to (default stdout)")
+
+	importCmd := &cobra.Command{
+		Use:   "import <file>",
+		Short: "Merge an export into the local database",
+		Long: "\nMerges an export written by \"beezim db export\" into the local database." +
+			"\nA run already recorded is replaced only when the imported one is more recent; spends and gateway checks already recorded are skipped.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			e, err := readExport(args[0])
+			if err != nil {
+				return err
+			}
+
+			s, err := store.Open(filepath.Join(optionDataDir, dbFile))
+			if err != nil {
+				return err
+			}
+			stats, err := s.Import(e)
+			if err != nil {
+				return err
+			}
+
+			if optionJSON {
+				runResult.Data = stats
+				return nil
+			}
+			fmt.Fprintf(stdout, "Imported %s: %d runs added, %d runs updated, %d spends added, %d gateway checks added, %d records skipped\n",
+				args[0], stats.RunsAdded, stats.RunsUpdated, stats.SpendsAdded, stats.ChecksAdded, stats.Skipped)
+			return nil
+		},
+	}
+
+	cmd.AddCommand(exportCmd, importCmd)
+	return cmd
+}
+
+// readExport reads the export in path, "-" for stdin.
+func readExport(path string) (store.Export, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return store.Export{}, err
+		}
+		defer f.Close()
+		r = f
+	}
+	return store.ReadExport(r)
+}
@@ -1,16 +1,19 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"log"
-	"net/http"
 	"os"
 	"path"
 	"path/filepath"
-	"strconv"
+	"time"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/internal/downloader"
+	"github.com/r0qs/beezim/internal/progress"
+	"github.com/r0qs/beezim/internal/store"
 
-	"github.com/cheggaaa/pb/v3"
 	"github.com/spf13/cobra"
 )
 
@@ -20,21 +23,43 @@ func newDownloadCmd() *cobra.Command {
 		Use:   "download",
 		Short: "Download zim file",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			_, err := download(optionDataDir, optionZimFile, optionZimURL)
+			unlock, err := lockWiki(cmd.Context(), wikiName(optionZimFile, optionZimURL))
+			if err != nil {
+				return err
+			}
+			defer unlock()
+			_, err = download(cmd.Context(), optionDataDir, optionZimFile, optionZimURL)
 			return err
 		},
 	}
 	cmd.Flags().StringVar(&optionZimFile, optionNameZimFile, "", "path to the zim file")
-	cmd.Flags().StringVar(&optionZimURL, optionZimURL, "", "download URL for the zim files")
+	cmd.Flags().StringVar(&optionZimURL, optionNameZimURL, "", "download URL for the zim files")
+	addDownloadFlags(cmd)
 	// TODO: add download all option
 
 	return cmd
 }
 
-func download(dataDir, zimFile, zimURL string) (string, error) {
+// addDownloadFlags adds the flags shared by the commands that download zim files.
+func addDownloadFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&optionDownloadMirrors, optionNameDownloadMirrors, nil, "fallback download URLs for the zim file")
+	cmd.Flags().IntVar(&optionDownloadRetries, optionNameDownloadRetries, 5, "number of download attempts per URL")
+	cmd.Flags().BoolVar(&optionVerifyChecksum, optionNameVerifyChecksum, true, "verify the downloaded zim against the published sha256 checksum")
+}
+
+// wikiName returns the name of the zim given by its file name or its
+// download url.
+func wikiName(zimFile, zimURL string) string {
+	if zimFile == "" && zimURL != "" {
+		return path.Base(zimURL)
+	}
+	return zimFile
+}
+
+func download(ctx context.Context, dataDir, zimFile, zimURL string) (string, error) {
 	if zimFile != "" && zimURL == "" {
-		if filepath.Ext(zimFile) != ".zim" {
-			return "", fmt.Errorf("file must has .zim extention")
+		if ext := filepath.Ext(zimFile); ext != ".zim" && ext != indexer.FirstZimPart {
+			return "", fmt.Errorf("file must has .zim extention, or %s for the first part of a split zim", indexer.FirstZimPart)
 		}
 		zimURL = fmt.Sprintf("%s/%s/%s", kiwixZimURL, optionKiwix, zimFile)
 	} else if zimFile == "" && zimURL != "" {
@@ -45,47 +70,45 @@ func download(dataDir, zimFile, zimURL string) (string, error) {
 		return "", fmt.Errorf("--zim or --url should be provided")
 	}
 
+	res := resultFrom(ctx)
+	res.Inputs.ZimFile = zimFile
+	res.Inputs.ZimURL = zimURL
+	updateRun(ctx, func(r *store.Run) {
+		r.Stage = "download"
+		r.ZimFile = zimFile
+		r.ZimURL = zimURL
+	})
+
 	zimDownloadPath := fmt.Sprintf("%s/%s", dataDir, zimFile)
-	if _, err := os.Stat(zimDownloadPath); os.IsNotExist(err) {
-		if err := downloadZim(zimURL, zimDownloadPath); err != nil {
+	// a zim split in parts is not downloaded again either
+	if _, err := indexer.ZimSize(zimDownloadPath); errors.Is(err, os.ErrNotExist) {
+		start := time.Now()
+		if err := downloadZim(ctx, zimURL, zimDownloadPath); err != nil {
 			return "", err
 		}
+		res.Stage("download", start)
 	}
 	return zimDownloadPath, nil
 }
 
-// TODO: continue the download from where it stopped in case of crash
 // TODO: keep track of already uploaded files (in the metadata kv)
-func downloadZim(targetURL string, dstFile string) error {
-	resp, err := http.Get(targetURL)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed: %v [status: %v]\n", targetURL, resp.Status)
-	}
+func downloadZim(ctx context.Context, targetURL string, dstFile string) error {
+	logger.Info("downloading zim file", "zim", filepath.Base(dstFile), "url", targetURL)
 
-	log.Printf("Downloading zim file to: %v\n", filepath.Base(dstFile))
-	size, err := strconv.Atoi(resp.Header.Get("Content-Length"))
-	if err != nil {
-		return err
+	opts := downloader.Options{
+		Mirrors:        optionDownloadMirrors,
+		Retries:        optionDownloadRetries,
+		VerifyChecksum: optionVerifyChecksum,
+		Progress:       progress.DefaultBar(logger, filepath.Base(dstFile)),
+		Buffers:        bufferConfig(),
+		Logger:         logger,
 	}
 
-	dest, err := os.Create(dstFile)
+	err := downloader.Download(ctx, targetURL, dstFile, opts)
 	if err != nil {
 		return err
 	}
-	defer dest.Close()
-
-	progressBar := pb.Full.New(int(size))
-	progressBar.Start()
-
-	io.Copy(dest, progressBar.NewProxyReader(resp.Body))
-
-	progressBar.Finish()
 
-	log.Printf("Zim file saved to: %s \n", dstFile)
+	logger.Info("zim file saved", "path", dstFile)
 	return nil
 }
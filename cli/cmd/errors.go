@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/internal/beeclient"
+	"github.com/r0qs/beezim/internal/diskspace"
+	"github.com/r0qs/beezim/internal/filelock"
+	"github.com/r0qs/beezim/internal/tarball"
+)
+
+// remedies are the hints printed with the errors the user can act on.
+var remedies = []struct {
+	err  error
+	hint string
+}{
+	{indexer.ErrTooManyPanics, fmt.Sprintf("the zim is probably corrupt, download it again or raise --%s", optionNamePanicBudget)},
+	{errTooManySkipped, fmt.Sprintf("the zim is probably corrupt, download it again or raise --%s", optionNameSkipBudget)},
+	{indexer.ErrZimCorrupt, "download the zim again"},
+	{errPanic, "this is a bug, please report it with the stack logged"},
+	{indexer.ErrNoMainPage, fmt.Sprintf("no html article is left to list, check --%s, --%s, --%s and --%s", optionNameIncludeNamespaces, optionNameExcludeNamespaces, optionNameAllowMimes, optionNameBlockMimes)},
+	{tarball.ErrTarIncomplete, fmt.Sprintf("parse the zim again with --%s", optionNameForce)},
+	{diskspace.ErrNoSpace, fmt.Sprintf("free some space, e.g. with \"beezim clean\", or use --%s or --%s", optionNameDataDir, optionNameWorkDir)},
+	{filelock.ErrLocked, fmt.Sprintf("another beezim run is using it, use --%s=%s to wait for it or --%s=%s to skip it", optionNameOnLocked, onLockedWait, optionNameOnLocked, onLockedSkip)},
+	{beeclient.ErrRootNotFound, "the node may not have stored the upload yet, check it again later with \"beezim check-gateways\" or upload it again"},
+}
+
+// describeError adds to the error of a run the stage that failed and the
+// remedy of the error, when known.
+func describeError(err error) error {
+	if err == nil || errors.Is(err, errInterrupted) {
+		return err
+	}
+	if currentRun != nil && currentRun.Stage != "" {
+		err = fmt.Errorf("%s: %w", currentRun.Stage, err)
+	}
+	for _, r := range remedies {
+		if errors.Is(err, r.err) {
+			return fmt.Errorf("%w (%s)", err, r.hint)
+		}
+	}
+	return err
+}
+
+// errPanic is the error of a run that panicked.
+var errPanic = errors.New("panic")
+
+// recoverPanic, deferred, turns a panic of the function into an error set
+// in err, logging its stack, so that the run is recorded as failed
+// instead of crashing the process and the other runs.
+func recoverPanic(what string, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	logger.Error("recovered panic", "in", what, "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+	*err = fmt.Errorf("%s: %w: %v", what, errPanic, r)
+}
@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/r0qs/beezim/internal/gateway"
+	"github.com/r0qs/beezim/internal/result"
+	"github.com/r0qs/beezim/internal/store"
+	mirrorpkg "github.com/r0qs/beezim/pkg/mirror"
+
+	"github.com/spf13/cobra"
+)
+
+func newCheckGatewaysCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check-gateways",
+		Short: "Check that a published root is served by public gateways",
+		Long: "\nFetches the index document and a sample of files of the root through public gateways and compares them with the tar that was uploaded." +
+			"\nThe tar is looked up in the local database when --tar is not given and the BEE_GATEWAY gateway is used when no --check-gateway is given.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if optionVerifyRoot == "" {
+				return fmt.Errorf("--%s should be provided", optionNameVerifyRoot)
+			}
+
+			tarFile := optionTarFile
+			if tarFile == "" {
+				var err error
+				if tarFile, err = lookupTarFile(optionVerifyRoot); err != nil {
+					return err
+				}
+			}
+
+			reports, err := checkGateways(cmd.Context(), optionVerifyRoot, artifactPath(tarFile), nil)
+			if err != nil {
+				return err
+			}
+			prov, ok := tarProvenance(runResult, artifactPath(tarFile))
+			if optionJSON {
+				runResult.Data = reports
+			} else {
+				printGatewayReports(reports)
+				if ok {
+					fmt.Fprintln(stdout)
+					printProvenance(prov)
+				}
+			}
+			if !runResult.Verification.Verified {
+				return fmt.Errorf("%s is not available on all gateways", optionVerifyRoot)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&optionVerifyRoot, optionNameVerifyRoot, "", "swarm reference of the mirror")
+	cmd.Flags().StringVar(&optionTarFile, optionNameTarFile, "", "tar file uploaded with the root")
+	addGatewayCheckFlags(cmd)
+
+	return cmd
+}
+
+// addGatewayCheckFlags adds the flags of the commands that check roots
+// through public gateways.
+func addGatewayCheckFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&optionCheckGateways, optionNameCheckGateways, nil, "url of a public gateway used to check the uploaded root (can be repeated)")
+	cmd.Flags().IntVar(&optionCheckSample, optionNameCheckSample, 10, "number of files checked besides the index document")
+	cmd.Flags().DurationVar(&optionCheckInterval, optionNameCheckInterval, time.Second, "minimum time between two requests to the same gateway")
+}
+
+// checkGateways checks the root through the configured gateways using
+// the content of the tar as reference, or the checks sampled from the
+// tar streamed when set, and records the results.
+func checkGateways(ctx context.Context, root string, tarPath string, checks []gateway.Check) ([]gateway.Report, error) {
+	gateways := optionCheckGateways
+	if len(gateways) == 0 {
+		if gw := os.Getenv("BEE_GATEWAY"); gw != "" {
+			gateways = []string{gw}
+		}
+	}
+	if len(gateways) == 0 {
+		return nil, fmt.Errorf("no gateway to check, use --%s", optionNameCheckGateways)
+	}
+
+	o := mirrorpkg.VerifyOptions{
+		Gateways: gateways,
+		Sample:   optionCheckSample,
+		Gateway:  gateway.Options{Interval: optionCheckInterval},
+		SkipNode: true,
+		Seed:     optionSeed,
+	}
+	var v *result.Verification
+	var reports []gateway.Report
+	var err error
+	if checks != nil {
+		v, reports, err = stages().VerifyChecks(ctx, root, checks, o)
+	} else {
+		v, reports, err = stages().Verify(ctx, root, tarPath, o)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]store.GatewayCheck, 0, len(reports))
+	for _, r := range reports {
+		rec := store.GatewayCheck{
+			Root:       root,
+			Gateway:    r.Gateway,
+			Success:    r.Success,
+			Checked:    r.Checked,
+			Failed:     len(r.Failed),
+			AvgLatency: r.AvgLatency,
+			MaxLatency: r.MaxLatency,
+		}
+		for _, f := range r.Failed {
+			rec.Errors = append(rec.Errors, fmt.Sprintf("/%s: %s", f.Path, f.Error))
+		}
+		records = append(records, rec)
+	}
+	resultFrom(ctx).Verification = v
+
+	s, err := store.Open(filepath.Join(optionDataDir, dbFile))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.AddGatewayChecks(records...); err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+// lookupTarFile returns the tar file recorded for the root.
+func lookupTarFile(root string) (string, error) {
+	s, err := store.Open(filepath.Join(optionDataDir, dbFile))
+	if err != nil {
+		return "", err
+	}
+
+	runs := s.Runs()
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].Reference == root && runs[i].TarFile != "" {
+			return runs[i].TarFile, nil
+		}
+	}
+	return "", fmt.Errorf("no tar recorded for %s, use --%s", root, optionNameTarFile)
+}
+
+func printGatewayReports(reports []gateway.Report) {
+	w := tabwriter.NewWriter(stdout, 2, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Gateway\tStatus\tChecked\tFailed\tAvg latency\tMax latency\t\n")
+	for _, r := range reports {
+		status := "ok"
+		if !r.Success {
+			status = "FAIL"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%dms\t%dms\t\n", r.Gateway, status, r.Checked, len(r.Failed), r.AvgLatency, r.MaxLatency)
+	}
+	w.Flush()
+
+	for _, r := range reports {
+		for _, f := range r.Failed {
+			fmt.Fprintf(stdout, "%s /%s: %s\n", r.Gateway, f.Path, f.Error)
+		}
+	}
+}
+
+// checkUploadedRoot checks the uploaded root when gateways are configured.
+// Unavailable content is only reported as a warning since it may not
+// have reached the gateways yet.
+func checkUploadedRoot(ctx context.Context, root string, tarPath string, checks []gateway.Check) error {
+	if len(optionCheckGateways) == 0 {
+		return nil
+	}
+
+	reports, err := checkGateways(ctx, root, tarPath, checks)
+	if err != nil {
+		return err
+	}
+	for _, r := range reports {
+		if !r.Success {
+			msg := fmt.Sprintf("%s: %d of %d files not available", r.Gateway, len(r.Failed), r.Checked)
+			logger.Warn("files not available through gateway", "gateway", r.Gateway, "failed", len(r.Failed), "checked", r.Checked)
+			resultFrom(ctx).Warn(msg)
+		}
+	}
+	return nil
+}
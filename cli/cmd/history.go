@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/internal/catalog"
+	"github.com/r0qs/beezim/internal/store"
+	"github.com/r0qs/beezim/internal/tarball"
+
+	"github.com/ethersphere/bee/pkg/swarm"
+	"github.com/spf13/cobra"
+)
+
+// historyCheckTimeout bounds the retrieval of a previous root.
+const historyCheckTimeout = 30 * time.Second
+
+// addHistoryFlag adds the flag of the commands publishing a history page.
+func addHistoryFlag(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&optionHistory, optionNameHistory, 0, "number of previous versions listed in a history.html page of the mirror, as recorded in the local database (0 for no page)")
+}
+
+// addHistoryPage appends to the tar a page listing the previous versions
+// of the wiki mirrored, as recorded in the local database: the roots
+// published from other machines are only listed once their runs are
+// imported with db import.
+func addHistoryPage(ctx context.Context, zimFile string, tarPath string) error {
+	if optionHistory <= 0 || db == nil {
+		return nil
+	}
+
+	a, err := tarball.OpenArchive(tarPath)
+	if err != nil {
+		return err
+	}
+	_, found := a.Entry(indexer.HistoryFile)
+	a.Close()
+	// a reused tar already has the history of when it was built
+	if found {
+		logger.Info("tar already has a history page", "tar", filepath.Base(tarPath))
+		return nil
+	}
+
+	r := store.Run{ZimFile: zimFile}
+	if cur := pipelineFrom(ctx).run; cur != nil {
+		r.Wiki = cur.Wiki
+	}
+	wiki := runWiki(r)
+	logger.Info("appending page", "page", indexer.HistoryFile, "tar", filepath.Base(tarPath))
+	return indexer.MakeHistoryPage(tarPath, wiki, previousVersions(ctx, wiki, optionHistory))
+}
+
+// runWiki returns the wiki mirrored by the run.
+func runWiki(r store.Run) string {
+	if r.Wiki != "" {
+		return r.Wiki
+	}
+	return catalog.WikiName(r.ZimFile)
+}
+
+// previousVersions returns up to max roots published for the wiki, newest
+// first. Roots that can not be retrieved are listed too.
+func previousVersions(ctx context.Context, wiki string, max int) []indexer.Version {
+	runs := db.Runs()
+	seen := make(map[string]bool)
+	var versions []indexer.Version
+	for i := len(runs) - 1; i >= 0 && len(versions) < max; i-- {
+		r := runs[i]
+		if r.Status != store.StatusCompleted || r.Reference == "" || seen[r.Reference] || runWiki(r) != wiki {
+			continue
+		}
+		seen[r.Reference] = true
+
+		v := indexer.Version{
+			Root:        r.Reference,
+			ZimFile:     r.ZimFile,
+			PublishedAt: r.UpdatedAt,
+		}
+		if err := retrieveRoot(ctx, r.Reference); err != nil {
+			v.Error = err.Error()
+		} else {
+			v.Available = true
+		}
+		versions = append(versions, v)
+	}
+	return versions
+}
+
+// retrieveRoot downloads the index document of the root.
+func retrieveRoot(ctx context.Context, root string) error {
+	addr, err := swarm.ParseHexAddress(root)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, historyCheckTimeout)
+	defer cancel()
+	_, _, err = bee.DownloadManifestFile(ctx, addr, "index.html")
+	return err
+}
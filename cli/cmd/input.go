@@ -23,7 +23,7 @@ func readInput() (string, error) {
 }
 
 func terminalPrompt(r inputReader, msg string) (input string, err error) {
-	fmt.Print(msg + ": ")
+	fmt.Fprint(stdout, msg+": ")
 	input, err = readInput()
 	if err != nil {
 		return "", err
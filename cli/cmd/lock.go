@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/r0qs/beezim/internal/filelock"
+)
+
+// The behaviors on a wiki locked by another run, chosen with --on-locked.
+const (
+	onLockedWait = "wait"
+	onLockedSkip = "skip"
+	onLockedFail = "fail"
+)
+
+// lockPoll is how often a locked wiki is checked again with --on-locked=wait.
+const lockPoll = time.Second
+
+// errSkipped is the error of a run skipped since its wiki was locked by
+// another run. It is not a failure.
+var errSkipped = errors.New("skipped")
+
+// heldLocks are the wiki locks held by the runs of the process, keyed by
+// path. The file locks of a process do not exclude each other everywhere,
+// so the runs of the process are excluded here.
+var (
+	heldLocksMu sync.Mutex
+	heldLocks   = make(map[string]string)
+)
+
+// lockWiki locks the artifacts of the wiki in the workdir for the run of
+// the context, so that two runs, of this or another process, never work
+// on the same wiki, and returns the function releasing the lock. A wiki
+// locked by another run is waited for, skipped with errSkipped or fails
+// with filelock.ErrLocked, as chosen with --on-locked.
+func lockWiki(ctx context.Context, zimFile string) (func(), error) {
+	path := work.LockPath(zimFile)
+	var deadline <-chan time.Time
+	switch optionOnLocked {
+	case onLockedWait:
+		timer := time.NewTimer(optionLockTimeout)
+		defer timer.Stop()
+		deadline = timer.C
+	case onLockedSkip, onLockedFail:
+	default:
+		return nil, fmt.Errorf("invalid --%s %q, use %q, %q or %q", optionNameOnLocked, optionOnLocked, onLockedWait, onLockedSkip, onLockedFail)
+	}
+
+	run := "unknown"
+	if r := pipelineFrom(ctx).run; r != nil {
+		run = r.ID
+	}
+	waiting := false
+	for {
+		l, err := tryLockWiki(path, run)
+		if err == nil {
+			if l.Stale.PID != 0 {
+				logger.Warn("took over the lock of a run which did not release it", "lock", path, "owner", l.Stale.String())
+			}
+			return func() {
+				heldLocksMu.Lock()
+				delete(heldLocks, path)
+				heldLocksMu.Unlock()
+				if err := l.Unlock(); err != nil {
+					logger.Warn("error releasing the lock", "lock", path, "err", err)
+				}
+			}, nil
+		}
+		if !errors.Is(err, filelock.ErrLocked) {
+			return nil, err
+		}
+
+		switch optionOnLocked {
+		case onLockedSkip:
+			return nil, fmt.Errorf("%w: %w", errSkipped, err)
+		case onLockedFail:
+			return nil, err
+		}
+		if !waiting {
+			logger.Info("waiting for the wiki used by another run", "lock", path, "timeout", optionLockTimeout, "err", err)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline:
+			return nil, fmt.Errorf("%w, waited %s", err, optionLockTimeout)
+		case <-time.After(lockPoll):
+		}
+	}
+}
+
+// tryLockWiki takes the lock at path for the run, failing with
+// filelock.ErrLocked when another run holds it.
+func tryLockWiki(path, run string) (*filelock.Lock, error) {
+	heldLocksMu.Lock()
+	defer heldLocksMu.Unlock()
+	if other, ok := heldLocks[path]; ok {
+		return nil, fmt.Errorf("%s: %w by run %s of this process", path, filelock.ErrLocked, other)
+	}
+	l, err := filelock.TryLock(path)
+	if err != nil {
+		return nil, err
+	}
+	heldLocks[path] = run
+	return l, nil
+}
+
+// wikiLocked reports whether a run, of this or another process, holds the
+// lock of the wiki.
+func wikiLocked(zimFile string) bool {
+	path := work.LockPath(zimFile)
+	if !exists(path) {
+		return false
+	}
+	l, err := tryLockWiki(path, "check")
+	if err != nil {
+		return errors.Is(err, filelock.ErrLocked)
+	}
+	heldLocksMu.Lock()
+	delete(heldLocks, path)
+	heldLocksMu.Unlock()
+	l.Unlock()
+	return false
+}
@@ -0,0 +1,117 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/r0qs/beezim/internal/filelock"
+	"github.com/r0qs/beezim/internal/store"
+	"github.com/r0qs/beezim/internal/workdir"
+)
+
+// lockRuns sets up a workdir and returns the contexts of two runs of the
+// process executed concurrently, restoring the options of the locks once
+// the test is done.
+func lockRuns(t *testing.T) (a, b context.Context) {
+	t.Helper()
+	prevWork, prevOnLocked, prevTimeout := work, optionOnLocked, optionLockTimeout
+	t.Cleanup(func() { work, optionOnLocked, optionLockTimeout = prevWork, prevOnLocked, prevTimeout })
+	var err error
+	if work, err = workdir.New(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	a = withPipeline(ctx, pipeline{run: &store.Run{ID: "a"}})
+	b = withPipeline(ctx, pipeline{run: &store.Run{ID: "b"}})
+	return a, b
+}
+
+func TestLockWikiContention(t *testing.T) {
+	const zim = "wiki_en_all_2022-05.zim"
+	a, b := lockRuns(t)
+	optionOnLocked = onLockedFail
+	unlock, err := lockWiki(a, zim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wikiLocked(zim) {
+		t.Error("wiki not locked by the first run")
+	}
+
+	if _, err := lockWiki(b, zim); !errors.Is(err, filelock.ErrLocked) || !strings.Contains(err.Error(), "run a") {
+		t.Errorf("--on-locked=fail: got %v, want %v by run a", err, filelock.ErrLocked)
+	}
+	optionOnLocked = onLockedSkip
+	if _, err := lockWiki(b, zim); !errors.Is(err, errSkipped) {
+		t.Errorf("--on-locked=skip: got %v, want %v", err, errSkipped)
+	}
+	optionOnLocked, optionLockTimeout = onLockedWait, 10*time.Millisecond
+	if _, err := lockWiki(b, zim); !errors.Is(err, filelock.ErrLocked) {
+		t.Errorf("--on-locked=wait past the timeout: got %v, want %v", err, filelock.ErrLocked)
+	}
+	// another wiki is not locked
+	if unlockOther, err := lockWiki(b, "wiki_fr_all_2022-05.zim"); err != nil {
+		t.Errorf("other wiki: %v", err)
+	} else {
+		unlockOther()
+	}
+
+	// the second run waits for the first to release the wiki
+	optionLockTimeout = 10 * time.Second
+	released := make(chan time.Time, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		released <- time.Now()
+		unlock()
+	}()
+	unlock, err = lockWiki(b, zim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Now().Before(<-released) {
+		t.Error("the second run locked the wiki before the first released it")
+	}
+	unlock()
+	if wikiLocked(zim) {
+		t.Error("wiki still locked once released")
+	}
+}
+
+func TestLockWikiOtherProcess(t *testing.T) {
+	const zim = "wiki_en_all_2022-05.zim"
+	a, _ := lockRuns(t)
+	// a lock of the file not recorded by the runs, as another process
+	// takes it
+	l, err := filelock.TryLock(work.LockPath(zim))
+	if err != nil {
+		t.Fatal(err)
+	}
+	optionOnLocked = onLockedFail
+	if _, err := lockWiki(a, zim); !errors.Is(err, filelock.ErrLocked) {
+		t.Errorf("got %v, want %v", err, filelock.ErrLocked)
+	}
+	if !wikiLocked(zim) {
+		t.Error("wiki not locked by the other process")
+	}
+	if err := l.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := lockWiki(a, zim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+}
+
+func TestLockWikiOnLocked(t *testing.T) {
+	a, _ := lockRuns(t)
+	optionOnLocked = "retry"
+	if _, err := lockWiki(a, "wiki_en_all_2022-05.zim"); err == nil || !strings.Contains(err.Error(), optionNameOnLocked) {
+		t.Errorf("got %v, want the invalid --%s", err, optionNameOnLocked)
+	}
+}
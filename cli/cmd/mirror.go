@@ -2,10 +2,16 @@ package cmd
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
-	"log"
 	"path/filepath"
+	"time"
 
+	"github.com/ethersphere/bee/pkg/swarm"
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/internal/beeclient/api"
+	"github.com/r0qs/beezim/internal/store"
+	mirrorpkg "github.com/r0qs/beezim/pkg/mirror"
 	"github.com/spf13/cobra"
 )
 
@@ -14,33 +20,204 @@ func newMirrorCmd() *cobra.Command {
 		Use:   "mirror",
 		Short: "Mirror zim files to swarm",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			zimPath, err := download(optionDataDir, optionZimFile, optionZimURL)
-			if err != nil {
-				return err
-			}
-
-			zimFile := filepath.Base(zimPath)
-			err = parse(optionDataDir, zimFile)
-			if err != nil {
-				return err
+			zimURL := optionZimURL
+			if optionFromCatalog != "" {
+				if optionZimFile != "" || optionZimURL != "" {
+					return fmt.Errorf("--from-catalog can not be used together with --zim or --url")
+				}
+				catalogURL, err := resolveCatalogURL(cmd.Context(), optionFromCatalog)
+				if err != nil {
+					return err
+				}
+				zimURL = catalogURL
 			}
-
-			ctx, cancel := context.WithCancel(cmd.Context())
-			defer cancel()
-
-			ext := filepath.Ext(zimFile)
-			tarFile := fmt.Sprintf("%s.tar", zimFile[:len(zimFile)-len(ext)])
-			addr, err := upload(ctx, optionDataDir, tarFile, optionBeeBatchID)
+			addr, err := mirror(cmd.Context(), optionZimFile, zimURL)
 			if err != nil {
 				return err
 			}
-			log.Printf("collection %v uploaded with reference: %v", tarFile, addr)
-			fmt.Printf("\nTry the link: %s\n", makeURL(addr.String()))
+			fmt.Fprintf(stdout, "\nTry the link: %s\n", makeURL(addr.String()))
 			return nil
 		},
 	}
-	cmd.Flags().StringVar(&optionZimFile, optionNameZimFile, "", "path to the zim file")
+	cmd.Flags().StringVar(&optionZimFile, optionNameZimFile, "", "path to the zim file, or to the first part of a split zim, e.g. wikipedia_en_all.zimaa")
 	cmd.Flags().StringVar(&optionZimURL, optionNameZimURL, "", "download URL for the zim files")
+	cmd.Flags().StringVar(&optionFromCatalog, optionNameFromCatalog, "", "mirror the newest zim in the Kiwix catalog whose name matches the query")
+	addCatalogFlags(cmd)
+	addForceFlag(cmd)
+	addHistoryFlag(cmd)
+	addSplitSearchFlag(cmd)
+	cmd.Flags().BoolVar(&optionStream, optionNameStream, false, "upload the tar as it is written instead of writing it to the workdir first")
+	addParseBatchFlags(cmd)
+	addKeepFlags(cmd)
+	addDownloadFlags(cmd)
+	addNotifyFlags(cmd)
+	addSignFlags(cmd)
+	addGatewayCheckFlags(cmd)
+	cmd.AddCommand(
+		newMirrorBatchCmd(),
+	)
 
 	return cmd
 }
+
+// mirror downloads, parses and uploads a zim file.
+func mirror(ctx context.Context, zimFile string, zimURL string) (_ swarm.Address, err error) {
+	defer recoverPanic("mirror", &err)
+	if err := checkStreamFlags(); err != nil {
+		return swarm.Address{}, err
+	}
+	unlock, err := lockWiki(ctx, wikiName(zimFile, zimURL))
+	if err != nil {
+		return swarm.Address{}, err
+	}
+	defer unlock()
+
+	zimPath, err := download(ctx, optionDataDir, zimFile, zimURL)
+	if err != nil {
+		return swarm.Address{}, err
+	}
+
+	zimFile = filepath.Base(zimPath)
+	if optionStream {
+		return streamMirror(ctx, zimPath)
+	}
+	err = parse(ctx, optionDataDir, zimFile)
+	if err != nil {
+		return swarm.Address{}, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ext := filepath.Ext(zimFile)
+	tarFile := fmt.Sprintf("%s.tar", zimFile[:len(zimFile)-len(ext)])
+	if err := addHistoryPage(ctx, zimFile, artifactPath(tarFile)); err != nil {
+		return swarm.Address{}, fmt.Errorf("Failed to add history.html page to tar file: %v", err)
+	}
+	if splitSearch() {
+		root, err := uploadSearchIndex(ctx, artifactPath(tarFile), optionBeeBatchID)
+		if err != nil {
+			return swarm.Address{}, err
+		}
+		recordSearchReference(ctx, root)
+	}
+	addr, err := upload(ctx, optionDataDir, tarFile, optionBeeBatchID)
+	if err != nil {
+		return swarm.Address{}, err
+	}
+	logger.Info("collection uploaded", "tar", tarFile, "reference", addr.String())
+	return addr, nil
+}
+
+// checkStreamFlags returns why --stream can not be used with the other
+// options, which add to the tar before it is uploaded. It is checked for
+// every zim mirrored, as the options may come from the config of a wiki.
+func checkStreamFlags() error {
+	if !optionStream {
+		return nil
+	}
+	switch {
+	case optionHistory > 0:
+		return fmt.Errorf("--%s can not be used together with --%s", optionNameStream, optionNameHistory)
+	case optionSplitSearch:
+		return fmt.Errorf("--%s can not be used together with --%s", optionNameStream, optionNameSplitSearch)
+	case optionShareAssets:
+		return fmt.Errorf("--%s can not be used together with --%s", optionNameStream, optionNameShareAssets)
+	}
+	return nil
+}
+
+// streamMirror parses the zim and uploads its tar as it is written, the
+// files checked through the gateways sampled from the stream.
+func streamMirror(ctx context.Context, zimPath string) (swarm.Address, error) {
+	if optionEnableSearch && !optionDropSearchIndexes && !namespaceFilter().Keeps('X', true) {
+		return swarm.Address{}, fmt.Errorf("--%s needs the search index of the X namespace, left out by --%s or --%s", optionNameEnableSearch, optionNameIncludeNamespaces, optionNameExcludeNamespaces)
+	}
+	dedup, err := indexer.ParseDedup(optionDedup)
+	if err != nil {
+		return swarm.Address{}, fmt.Errorf("--%s: %w", optionNameDedup, err)
+	}
+	if _, err := collisions(); err != nil {
+		return swarm.Address{}, err
+	}
+	if _, err := offlinePolicy(); err != nil {
+		return swarm.Address{}, err
+	}
+	if _, err := extraFiles(); err != nil {
+		return swarm.Address{}, err
+	}
+	if _, err := mimeOverrides(); err != nil {
+		return swarm.Address{}, err
+	}
+	if _, err := entryStoreDir(zimPath); err != nil {
+		return swarm.Address{}, err
+	}
+	fp, err := zimFingerprint(zimPath)
+	if err != nil {
+		return swarm.Address{}, err
+	}
+
+	zimFile := filepath.Base(zimPath)
+	tarFile := filepath.Base(work.TarPath(zimFile))
+	res := resultFrom(ctx)
+	start := time.Now()
+	defer res.Stage("upload", start)
+	updateRun(ctx, func(r *store.Run) {
+		r.Stage = "upload"
+		r.ZimFile = zimFile
+		r.TarFile = tarFile
+		r.BatchID = optionBeeBatchID
+	})
+
+	opts := mirrorpkg.StreamOptions{
+		TarOptions: tarOptions(&fp, dedup, zimFile),
+		Upload: api.UploadCollectionOptions{
+			Tag:                 optionBeeTag,
+			Pin:                 optionBeePin,
+			BatchID:             optionBeeBatchID,
+			IndexDocumentHeader: "index.html",
+			ErrorDocumentHeader: "error.html",
+		},
+		Sample: optionCheckSample,
+		Seed:   optionSeed,
+	}
+	skipped := &skippedEntries{zimFile: zimFile}
+	defer skipped.report(res)
+	opts.OnEntryError = skipped.onEntryError
+	if l := limitsFrom(ctx); l != nil {
+		n, err := l.TarWriters.Acquire(ctx, 1)
+		if err != nil {
+			return swarm.Address{}, err
+		}
+		defer l.TarWriters.Release(n)
+		opts.Workers = l.ParseWorkers
+	}
+
+	s, err := stages().StreamUpload(ctx, zimPath, tarFile, opts)
+	if s != nil {
+		res.Stats = s.Stats
+	}
+	if err != nil {
+		return swarm.Address{}, err
+	}
+	addr := s.File.Address()
+	res.TarFile = tarFile
+	res.TarHash = hex.EncodeToString(s.File.Hash())
+	res.Reference = addr.String()
+	res.BatchID = optionBeeBatchID
+	updateRun(ctx, func(r *store.Run) {
+		r.Reference = addr.String()
+	})
+	logger.Info("collection uploaded", "tar", tarFile, "reference", addr.String())
+
+	if err := signMirror(ctx, optionDataDir, tarFile, addr, optionBeeBatchID); err != nil {
+		return swarm.Address{}, err
+	}
+	if err := checkUploadedRoot(ctx, addr.String(), "", s.Checks); err != nil {
+		return swarm.Address{}, err
+	}
+	if optionClean {
+		cleanDatadir()
+	}
+	return addr, nil
+}
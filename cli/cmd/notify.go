@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/r0qs/beezim/internal/notify"
+	"github.com/r0qs/beezim/internal/result"
+
+	"github.com/spf13/cobra"
+)
+
+const notifySecretEnv = "BEEZIM_NOTIFY_SECRET"
+
+var notifier *notify.Notifier
+
+// addNotifyFlags adds the flags of the commands that notify webhooks
+// when a run finishes.
+func addNotifyFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&optionNotifyURLs, optionNameNotifyURLs, nil, "webhook url receiving the JSON result when the run finishes (can be repeated)")
+	cmd.Flags().StringVar(&optionNotifySecret, optionNameNotifySecret, "", "shared secret used to sign the notifications (default $"+notifySecretEnv+")")
+	cmd.Flags().StringVar(&optionNotifyTemplate, optionNameNotifyTemplate, notify.DefaultTemplate, "template of the plain-text summary sent in the text field")
+	cmd.Flags().IntVar(&optionNotifyRetries, optionNameNotifyRetries, 3, "number of attempts per webhook url")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		return initNotifier()
+	}
+}
+
+func initNotifier() error {
+	if len(optionNotifyURLs) == 0 {
+		return nil
+	}
+
+	tmpl, err := notify.ParseTemplate(optionNotifyTemplate)
+	if err != nil {
+		return err
+	}
+
+	secret := optionNotifySecret
+	if secret == "" {
+		secret = os.Getenv(notifySecretEnv)
+	}
+
+	notifier = notify.New(optionNotifyURLs, notify.Options{
+		Secret:   secret,
+		Retries:  optionNotifyRetries,
+		Template: tmpl,
+		Logger:   logger,
+	})
+	return nil
+}
+
+// notifyResult sends a result to the configured webhooks. Failures are
+// only reported as warnings.
+func notifyResult(res *result.Result, runErr error) {
+	if notifier == nil || res == nil {
+		return
+	}
+	res.Finish(runErr)
+
+	// the run context may already be canceled when the run was interrupted
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	if err := notifier.Notify(ctx, res); err != nil {
+		logger.Warn("error sending notifications", "err", err)
+		res.Warn(err.Error())
+	}
+}
@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/r0qs/beezim/internal/limiter"
+	"github.com/r0qs/beezim/internal/perf"
+	"github.com/r0qs/beezim/internal/progress"
+	"github.com/r0qs/beezim/internal/store"
+
+	"github.com/spf13/cobra"
+)
+
+// batchJob is a wiki mirrored by the batch mode, given by its zim file
+// name or its download url.
+type batchJob struct {
+	ZimFile string `json:"zimFile,omitempty"`
+	ZimURL  string `json:"zimURL,omitempty"`
+}
+
+func (j batchJob) name() string {
+	return wikiName(j.ZimFile, j.ZimURL)
+}
+
+// batchOutcome is the outcome of mirroring a wiki in batch mode.
+type batchOutcome struct {
+	batchJob
+	RunID     string `json:"runID"`
+	Reference string `json:"reference,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// Skipped is set when the wiki was skipped, being used by another run.
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+func newMirrorBatchCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "batch",
+		Short: "Mirror several zim files, optionally in parallel",
+		Long: `Mirror several zim files, running up to --parallel wikis at the same time.
+
+The wikis share the parse workers, tar writers and in-flight upload bytes
+budgets instead of multiplying them, and the requests to the bee node are
+limited by --request-rate. The tars uploaded at the same time grow from
+--min-uploads up to --max-uploads while the node answers fast, and shrink
+when it slows down or fails. Per-wiki options of the configuration file are
+not applied in batch mode.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var jobs []batchJob
+			for _, z := range optionBatchZims {
+				jobs = append(jobs, batchJob{ZimFile: z})
+			}
+			for _, u := range optionBatchURLs {
+				jobs = append(jobs, batchJob{ZimURL: u})
+			}
+			if len(jobs) == 0 {
+				return fmt.Errorf("--zim or --url should be provided")
+			}
+			if optionClean && optionParallel > 1 {
+				return fmt.Errorf("--clean can not be used when mirroring in parallel")
+			}
+
+			var err error
+			db, err = store.Open(filepath.Join(optionDataDir, dbFile))
+			if err != nil {
+				return err
+			}
+
+			limits := &limiter.Limits{
+				ParseWorkers: limiter.NewPool(int64(optionParseWorkers)),
+				TarWriters:   limiter.NewPool(int64(optionTarWriters)),
+				UploadBytes:  limiter.NewPool(optionUploadMemory << 20),
+				Uploads:      uploadLimiter(),
+			}
+
+			outcomes := mirrorBatch(cmd, jobs, optionParallel, limits)
+			runResult.Data = outcomes
+
+			failed := 0
+			for _, o := range outcomes {
+				if o.Skipped {
+					fmt.Fprintf(stdout, "%s: %s\n", o.name(), o.Error)
+					continue
+				}
+				if o.Error != "" {
+					failed++
+					continue
+				}
+				fmt.Fprintf(stdout, "%s: %s\n", o.name(), makeURL(o.Reference))
+			}
+			if wasInterrupted() {
+				return errInterrupted
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d wikis failed", failed, len(outcomes))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringSliceVar(&optionBatchZims, optionNameZimFile, nil, "path to a zim file (can be repeated)")
+	cmd.Flags().StringSliceVar(&optionBatchURLs, optionNameZimURL, nil, "download URL of a zim file (can be repeated)")
+	cmd.Flags().IntVar(&optionParallel, optionNameParallel, 1, "number of wikis mirrored at the same time")
+	cmd.Flags().IntVar(&optionParseWorkers, optionNameParseWorkers, runtime.NumCPU(), "total parse workers shared by all wikis (0 for unlimited)")
+	cmd.Flags().IntVar(&optionTarWriters, optionNameTarWriters, 0, "total tars written at the same time by all wikis (0 for unlimited)")
+	cmd.Flags().Int64Var(&optionUploadMemory, optionNameUploadMemory, 0, "total MiB of tars uploaded at the same time by all wikis (0 for unlimited)")
+	cmd.Flags().IntVar(&optionMinUploads, optionNameMinUploads, 1, "minimum tars uploaded at the same time by all wikis")
+	cmd.Flags().IntVar(&optionMaxUploads, optionNameMaxUploads, 0, "maximum tars uploaded at the same time by all wikis, adapted to the latency and errors of the node (0 for --parallel)")
+	addForceFlag(cmd)
+	addHistoryFlag(cmd)
+	addSplitSearchFlag(cmd)
+	addParseBatchFlags(cmd)
+	addKeepFlags(cmd)
+	addDownloadFlags(cmd)
+	addNotifyFlags(cmd)
+	addSignFlags(cmd)
+	addGatewayCheckFlags(cmd)
+
+	return cmd
+}
+
+// uploadLimiter returns the limiter adapting the uploads in flight to the
+// node, logging its decisions.
+func uploadLimiter() *limiter.Adaptive {
+	max := optionMaxUploads
+	if max <= 0 {
+		max = optionParallel
+	}
+	l := limiter.NewAdaptive(optionMinUploads, max)
+	if l != nil {
+		l.OnDecision = func(d limiter.Decision) {
+			logger.Info("upload concurrency", "from", d.From, "to", d.To, "reason", d.Reason, "latency_per_mib", d.Latency, "best", d.Best)
+		}
+	}
+	return l
+}
+
+// mirrorBatch mirrors the jobs running up to parallel of them at the same
+// time. Each job is recorded as its own run and notified with its own
+// result.
+func mirrorBatch(cmd *cobra.Command, jobs []batchJob, parallel int, limits *limiter.Limits) []batchOutcome {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	// every wiki is rendered on its own line, in the order of the jobs
+	lines := make([]*progress.Line, len(jobs))
+	for i, j := range jobs {
+		lines[i] = progressUI.Line(j.name())
+	}
+
+	outcomes := make([]batchOutcome, len(jobs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				outcomes[i] = mirrorJob(cmd, jobs[i], limits, lines[i])
+			}
+		}()
+	}
+
+	ctx := cmd.Context()
+	for i := range jobs {
+		if ctx.Err() != nil {
+			outcomes[i] = batchOutcome{batchJob: jobs[i], Error: ctx.Err().Error()}
+			lines[i].SetStatus("not started")
+			continue
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return outcomes
+}
+
+func mirrorJob(cmd *cobra.Command, job batchJob, limits *limiter.Limits, line *progress.Line) batchOutcome {
+	out := batchOutcome{batchJob: job}
+
+	p := pipeline{
+		run: &store.Run{
+			ID:        store.NewRunID(),
+			Command:   cmd.CommandPath(),
+			ZimFile:   job.ZimFile,
+			ZimURL:    job.ZimURL,
+			Status:    store.StatusRunning,
+			BatchID:   optionBeeBatchID,
+			StartedAt: time.Now().UTC(),
+		},
+		result: newResult(cmd),
+		perf:   perf.Start(),
+		limits: limits,
+	}
+	out.RunID = p.run.ID
+	if err := db.PutRun(*p.run); err != nil {
+		out.Error = err.Error()
+		line.SetStatus("failed: " + err.Error())
+		return out
+	}
+
+	ctx := withPipeline(progress.WithReporter(cmd.Context(), line), p)
+	addr, err := mirror(ctx, job.ZimFile, job.ZimURL)
+	err = p.finish(err)
+	if errors.Is(err, errSkipped) {
+		out.Skipped = true
+		out.Error = err.Error()
+		line.SetStatus(err.Error())
+		return out
+	}
+	if err != nil {
+		out.Error = err.Error()
+		line.SetStatus("failed: " + err.Error())
+		return out
+	}
+	out.Reference = addr.String()
+	line.SetStatus("done " + out.Reference)
+	return out
+}
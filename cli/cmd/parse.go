@@ -1,9 +1,22 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/r0qs/beezim/internal/limiter"
+	"github.com/r0qs/beezim/internal/result"
+	"github.com/r0qs/beezim/internal/store"
+	"github.com/r0qs/beezim/internal/tarball"
+	"github.com/r0qs/beezim/internal/workdir"
+	mirrorpkg "github.com/r0qs/beezim/pkg/mirror"
 
 	"github.com/r0qs/beezim/indexer"
 
@@ -17,66 +30,587 @@ func newParserCmd() *cobra.Command {
 		Long:  "\nThe default behavior is to parse the ZIM and convert it to a tar file ready for upload.\nIf you only want to extract its content, use this command with the option --extract-only.",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if optionZimFile != "" {
-				if filepath.Ext(optionZimFile) != ".zim" {
-					return fmt.Errorf("file must has .zim extention")
+				if ext := filepath.Ext(optionZimFile); ext != ".zim" && ext != indexer.FirstZimPart {
+					return fmt.Errorf("file must has .zim extention, or %s for the first part of a split zim", indexer.FirstZimPart)
+				}
+				unlock, err := lockWiki(cmd.Context(), optionZimFile)
+				if err != nil {
+					return err
 				}
-				return parse(optionDataDir, optionZimFile)
+				defer unlock()
+				return parse(cmd.Context(), optionDataDir, optionZimFile)
 			}
 			return fmt.Errorf("zim file not provided")
 		},
 	}
-	cmd.Flags().StringVar(&optionZimFile, optionNameZimFile, "", "path to the zim file")
+	cmd.Flags().StringVar(&optionZimFile, optionNameZimFile, "", "path to the zim file, or to the first part of a split zim, e.g. wikipedia_en_all.zimaa")
 	cmd.Flags().BoolVar(&optionExtractOnly, optionNameExtractOnly, false, "parse and extract the zim file to the datadir")
+	cmd.Flags().IntVar(&optionExtractWorkers, optionNameExtractWorkers, runtime.NumCPU(), "number of files written at the same time with --extract-only")
+	cmd.Flags().StringVar(&optionOverwrite, optionNameOverwrite, "always", "what --extract-only does with the files already extracted: always write them again, skip-same-size to keep those of the size of their article, or fail")
+	cmd.Flags().Int64Var(&optionVolumeSize, optionNameVolumeSize, 0, "MiB at most of the tars the zim is split to, written with a volumes.json listing their files to a <name>-volumes directory (0 for one tar)")
+	cmd.Flags().StringVar(&optionDiffFrom, optionNameDiffFrom, "", "tar, or its _beezim/entries.json, of the previous version of the zim: only the articles added or changed since are put in the tar, with the full entries and a _beezim/diff.json listing the entries added, changed and removed")
+	cmd.Flags().IntVar(&optionCheckpointEvery, optionNameCheckpointEvery, 0, "record a checkpoint of the tar every N articles beside it, from which --resume resumes an interrupted parse (0 for none)")
+	cmd.Flags().BoolVar(&optionResume, optionNameResume, false, "resume the tar of an interrupted parse from its last checkpoint, see --checkpoint-every, instead of parsing the zim again from the start")
+	cmd.Flags().IntVar(&optionGzipLevel, optionNameGzipLevel, 0, "gzip the tar at this level, from 1 to 9, as a .tar.gz kept on disk (0 for the plain tar the node takes)")
+	addForceFlag(cmd)
+	addSplitSearchFlag(cmd)
+	addParseBatchFlags(cmd)
 
 	return cmd
 }
 
-func parse(dataDir string, zimFile string) error {
-	zimPath := filepath.Join(dataDir, zimFile)
-	dirName := strings.TrimSuffix(filepath.Base(zimPath), ".zim")
+func addForceFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&optionForce, optionNameForce, false, "parse the zim again even if a tar built from it already exists")
+}
+
+func addParseBatchFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&optionParseBatchSize, optionNameParseBatchSize, indexer.DefaultBatchSize, "number of articles sent together from the parser to the tar writer")
+	cmd.Flags().IntVar(&optionParseBuffer, optionNameParseBuffer, indexer.DefaultBatchBuffer, "number of article batches parsed ahead of the tar writer")
+	cmd.Flags().IntVar(&optionReadWorkers, optionNameReadWorkers, runtime.NumCPU(), "number of workers of a parse reading and decompressing zim entries at the same time")
+	cmd.Flags().BoolVar(&optionCompressBuffered, optionNameCompressBuffered, false, "compress the text articles parsed ahead of the tar writer, saving memory for some CPU")
+	cmd.Flags().IntVar(&optionPanicBudget, optionNamePanicBudget, 10, "number of zim entries making the reader panic that are skipped before the parse fails")
+	cmd.Flags().IntVar(&optionSkipBudget, optionNameSkipBudget, -1, "number of zim entries that can not be read that are skipped before the parse fails, no limit when negative")
+	cmd.Flags().StringVar(&optionSkippedReport, optionNameSkippedReport, "", "file the zim entries skipped by the parse are appended to, one per line")
+	cmd.Flags().BoolVar(&optionReproducible, optionNameReproducible, false, "record SOURCE_DATE_EPOCH, or the Unix epoch, in the provenance of the tar instead of the time of the parse, so the same zim and options give the same tar")
+}
 
-	sidx, err := indexer.New(zimPath, optionEnableSearch)
+// reproducibleTime returns the time pinned in the provenance of the tars
+// with --reproducible, zero otherwise.
+func reproducibleTime() time.Time {
+	if !optionReproducible {
+		return time.Time{}
+	}
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		return time.Unix(epoch, 0)
+	}
+	return time.Unix(0, 0)
+}
+
+func parse(ctx context.Context, dataDir string, zimFile string) error {
+	zimPath := filepath.Join(dataDir, zimFile)
+	if optionSplitSearch && !optionEnableSearch {
+		return fmt.Errorf("--%s requires --%s", optionNameSplitSearch, optionNameEnableSearch)
+	}
+	if optionDropSearchIndexes && splitSearch() {
+		return fmt.Errorf("--%s can not be used together with --%s", optionNameDropSearchIndexes, optionNameSplitSearch)
+	}
+	if optionEnableSearch && !optionDropSearchIndexes && !namespaceFilter().Keeps('X', true) {
+		return fmt.Errorf("--%s needs the search index of the X namespace, left out by --%s or --%s", optionNameEnableSearch, optionNameIncludeNamespaces, optionNameExcludeNamespaces)
+	}
+	dedup, err := indexer.ParseDedup(optionDedup)
 	if err != nil {
+		return fmt.Errorf("--%s: %w", optionNameDedup, err)
+	}
+	if _, err := collisions(); err != nil {
+		return err
+	}
+	if _, err := offlinePolicy(); err != nil {
+		return err
+	}
+	if _, err := extraFiles(); err != nil {
+		return err
+	}
+	if _, err := mimeOverrides(); err != nil {
 		return err
 	}
+	if _, err := entryStoreDir(zimFile); err != nil {
+		return err
+	}
+	if optionGzipLevel != 0 {
+		if err := tarball.CheckGzipLevel(optionGzipLevel); err != nil {
+			return fmt.Errorf("--%s: %w", optionNameGzipLevel, err)
+		}
+	}
+	if optionVolumeSize > 0 && splitSearch() {
+		return fmt.Errorf("--%s can not be used together with --%s", optionNameVolumeSize, optionNameSplitSearch)
+	}
+	if optionDiffFrom != "" && optionExtractOnly {
+		return fmt.Errorf("--%s can not be used together with --%s", optionNameDiffFrom, optionNameExtractOnly)
+	}
+	if len(optionExtraFiles) > 0 && optionExtractOnly {
+		return fmt.Errorf("--%s can not be used together with --%s", optionNameExtraFiles, optionNameExtractOnly)
+	}
+	if optionCheckpointEvery < 0 {
+		return fmt.Errorf("--%s: invalid number of articles %d", optionNameCheckpointEvery, optionCheckpointEvery)
+	}
+	if optionCheckpointEvery > 0 || optionResume {
+		if err := checkCheckpointFlags(); err != nil {
+			return err
+		}
+	}
+	split := optionVolumeSize > 0 && !optionExtractOnly
 
-	// Parse zim file
-	zimArticles := sidx.ParseZIM()
+	res := resultFrom(ctx)
+	start := time.Now()
+	defer res.Stage("parse", start)
+	setStage(ctx, "parse")
 
-	if optionExtractOnly {
-		outputDir := filepath.Join(optionDataDir, dirName)
-		return sidx.UnZim(outputDir, zimArticles)
-	} else {
-		// TODO: what should be the default policy? check if file already exists and
-		// do not build the tar, or overwrite it everytime?
-		tarFile := filepath.Join(dataDir, fmt.Sprintf("%s.tar", dirName))
-		// Build tar
-		if err := sidx.TarZim(tarFile, zimArticles); err != nil {
+	var fp indexer.Fingerprint
+	if split {
+		if fp, err = zimFingerprint(zimPath); err != nil {
+			return err
+		}
+	} else if !optionExtractOnly {
+		tarFile := parsedTarPath(zimFile)
+		fp, err = zimFingerprint(zimPath)
+		if err != nil {
+			return err
+		}
+		reuse, err := reusableTar(tarFile, fp)
+		if err != nil {
 			return err
 		}
+		if reuse {
+			logger.Info("reusing tar built from the same zim and options", "tar", filepath.Base(tarFile))
+			updateRun(ctx, func(r *store.Run) {
+				r.ZimFile = zimFile
+				r.TarFile = filepath.Base(tarFile)
+			})
+			tarProvenance(res, tarFile)
+			return nil
+		}
+	}
 
-		if optionEnableSearch {
-			// Append index page with search tool
-			if err := sidx.MakeIndexSearchPage(tarFile); err != nil {
-				return fmt.Errorf("Failed to copy index.html page to tar file: %v", err)
-			}
+	if err := checkWorkdirSpace(zimPath); err != nil {
+		return err
+	}
 
-			// Append assets
-			if err := indexer.AddAssets(tarFile); err != nil {
-				return fmt.Errorf("Failed to copy assets directory to tar file %v", err)
-			}
-		} else {
-			// Append redirected index page
-			if err := sidx.MakeRedirectIndexPage(tarFile); err != nil {
-				return fmt.Errorf("Failed to copy index.html page to tar file: %v", err)
-			}
+	opts := tarOptions(&fp, dedup, zimFile)
+	opts.SpaceCheck = workdirSpaceCheck()
+	opts.GzipLevel = optionGzipLevel
+	opts.CheckpointEvery, opts.Resume = optionCheckpointEvery, optionResume
+	if optionDiffFrom != "" {
+		if opts.Previous, err = previousEntries(optionDiffFrom); err != nil {
+			return fmt.Errorf("--%s: %w", optionNameDiffFrom, err)
 		}
+	}
+	if splitSearch() {
+		opts.SearchTarPath = work.SearchTarPath(zimFile)
+	}
+	if l := limitsFrom(ctx); l != nil {
+		// the tar writer is acquired before the parse worker feeding it,
+		// so concurrent runs always take the budgets in the same order
+		n, err := l.TarWriters.Acquire(ctx, 1)
+		if err != nil {
+			return err
+		}
+		defer l.TarWriters.Release(n)
+		opts.Workers = l.ParseWorkers
+	}
+
+	if optionExtractOnly {
+		return extract(ctx, zimPath, zimFile, opts.Workers)
+	}
 
-		// Append 404 page
-		if err := sidx.MakeErrorPage(tarFile); err != nil {
-			return fmt.Errorf("Failed to copy error.html page to tar file: %v", err)
+	tarFile := parsedTarPath(zimFile)
+	if split {
+		tarFile = work.VolumesDir(zimFile)
+	}
+	updateRun(ctx, func(r *store.Run) {
+		r.ZimFile = zimFile
+		r.TarFile = filepath.Base(tarFile)
+	})
+	skipped := &skippedEntries{zimFile: zimFile}
+	defer skipped.report(res)
+	opts.OnEntryError = skipped.onEntryError
+	if split {
+		// the volumes are always written again
+		if err := os.RemoveAll(tarFile); err != nil {
+			return err
+		}
+		stats, err := stages().BuildVolumes(ctx, zimPath, tarFile, optionVolumeSize<<20, opts)
+		if stats != nil {
+			res.Stats = stats
 		}
+		return err
+	}
+	stats, err := stages().BuildTar(ctx, zimPath, work.TarPath(zimFile), opts)
+	if stats != nil {
+		res.Stats = stats
 	}
+	if err != nil {
+		return err
+	}
+	tarProvenance(res, tarFile)
+	return nil
+}
 
+// checkCheckpointFlags returns why --checkpoint-every and --resume can not
+// be used with the other options.
+func checkCheckpointFlags() error {
+	name := optionNameCheckpointEvery
+	if optionResume {
+		name = optionNameResume
+	}
+	switch {
+	case optionExtractOnly:
+		return fmt.Errorf("--%s can not be used together with --%s", name, optionNameExtractOnly)
+	case optionVolumeSize > 0:
+		return fmt.Errorf("--%s can not be used together with --%s", name, optionNameVolumeSize)
+	case optionGzipLevel != 0:
+		return fmt.Errorf("--%s can not be used together with --%s", name, optionNameGzipLevel)
+	case splitSearch():
+		return fmt.Errorf("--%s can not be used together with --%s", name, optionNameSplitSearch)
+	case optionDiffFrom != "":
+		return fmt.Errorf("--%s can not be used together with --%s", name, optionNameDiffFrom)
+	}
 	return nil
 }
+
+// parsedTarPath returns the path of the tar written by parse, gzipped with
+// --gzip-level.
+func parsedTarPath(zimFile string) string {
+	if optionGzipLevel != 0 {
+		return tarball.GzipName(work.TarPath(zimFile))
+	}
+	return work.TarPath(zimFile)
+}
+
+// tarOptions returns the options of the tar built with the current
+// options.
+func tarOptions(fp *indexer.Fingerprint, dedup indexer.Dedup, zimFile string) mirrorpkg.TarOptions {
+	c, _ := collisions()
+	o, _ := offlinePolicy()
+	extra, _ := extraFiles()
+	overrides, _ := mimeOverrides()
+	entries, _ := entryStoreDir(zimFile)
+	return mirrorpkg.TarOptions{
+		EnableSearch:      optionEnableSearch,
+		Fingerprint:       fp,
+		Namespaces:        namespaceFilter(),
+		Mimes:             mimeFilter(),
+		MimePlaceholders:  optionMimePlaceholders,
+		MimeOverrides:     overrides,
+		SniffMime:         optionSniffMime,
+		Minify:            optionMinify,
+		Offline:           o,
+		RelativeLinks:     optionRelativeLinks,
+		NavBar:            optionNavBar,
+		HistoryPage:       optionHistory > 0 && !optionStream,
+		MaxArticleSize:    maxArticleSize(),
+		Dedup:             dedup,
+		Collisions:        c,
+		MainPage:          optionMainPage,
+		ListingPageSize:   optionListingPageSize,
+		DropTitleIndex:    optionDropTitleIndex,
+		DropSearchIndexes: optionDropSearchIndexes,
+		Snippets:          optionSnippets,
+		Sitemap:           optionSitemap || optionSitemapURL != "",
+		SitemapURL:        optionSitemapURL,
+		TemplateDir:       optionTemplateDir,
+		AssetsDir:         optionAssetsDir,
+		ExtraFiles:        extra,
+		Language:          optionLanguage,
+		Theme:             optionTheme,
+		ManifestRedirects: optionManifestRedirects,
+		DiffFrom:          optionDiffFrom,
+		VerifyZim:         optionVerifyZim,
+		BatchSize:         optionParseBatchSize,
+		BatchBuffer:       optionParseBuffer,
+		ReadWorkers:       optionReadWorkers,
+		CompressBuffered:  optionCompressBuffered,
+		Buffers:           bufferConfig(),
+		PanicBudget:       optionPanicBudget,
+		ReproducibleTime:  reproducibleTime(),
+		EntryStoreDir:     entries,
+	}
+}
+
+// extract parses the zim and extracts its content to the workdir.
+func extract(ctx context.Context, zimPath string, zimFile string, workers *limiter.Pool) error {
+	opts := []indexer.Option{
+		indexer.WithVerify(optionVerifyZim),
+		indexer.WithTemplateDir(optionTemplateDir),
+		indexer.WithAssetsDir(optionAssetsDir),
+	}
+	if dir, err := entryStoreDir(zimFile); err != nil {
+		return err
+	} else if dir != "" {
+		opts = append(opts, indexer.WithEntryStore(indexer.DiskStore(dir)))
+	}
+	sidx, err := indexer.New(zimPath, optionEnableSearch, opts...)
+	if err != nil {
+		return err
+	}
+	defer sidx.Close()
+	defer recordParseStats(resultFrom(ctx), sidx, zimPath)
+	sidx.Workers = workers
+	f := namespaceFilter()
+	sidx.SetNamespaceFilter(f.Include, f.Exclude)
+	if err := sidx.SetMimeFilter(optionAllowMimes, optionBlockMimes); err != nil {
+		return err
+	}
+	sidx.MimePlaceholders = optionMimePlaceholders
+	overrides, err := mimeOverrides()
+	if err != nil {
+		return err
+	}
+	if err := sidx.SetMimeOverrides(overrides); err != nil {
+		return fmt.Errorf("--%s: %w", optionNameMimeOverrides, err)
+	}
+	sidx.SniffMime = optionSniffMime
+	sidx.Minify = optionMinify
+	sidx.MaxArticleSize = maxArticleSize()
+	sidx.DropTitleIndex = optionDropTitleIndex
+	sidx.DropSearchIndexes = optionDropSearchIndexes
+	sidx.Language = optionLanguage
+	if err := sidx.SetTheme(optionTheme); err != nil {
+		return fmt.Errorf("--%s: %w", optionNameTheme, err)
+	}
+	if sidx.Dedup, err = indexer.ParseDedup(optionDedup); err != nil {
+		return err
+	}
+	if sidx.Collisions, err = collisions(); err != nil {
+		return err
+	}
+	sidx.RelativeLinks = optionRelativeLinks
+	sidx.NavBar = optionNavBar
+	if sidx.Offline, err = offlinePolicy(); err != nil {
+		return err
+	}
+	if sidx.Overwrite, err = indexer.ParseOverwrite(optionOverwrite); err != nil {
+		return fmt.Errorf("--%s: %w", optionNameOverwrite, err)
+	}
+	sidx.ExtractWorkers = optionExtractWorkers
+	sidx.BatchSize = optionParseBatchSize
+	sidx.BatchBuffer = optionParseBuffer
+	sidx.ReadWorkers = optionReadWorkers
+	sidx.CompressBuffered = optionCompressBuffered
+	sidx.Buffers = bufferConfig()
+	sidx.SpaceCheck = workdirSpaceCheck()
+	sidx.PanicBudget = optionPanicBudget
+	skipped := &skippedEntries{zimFile: zimFile}
+	defer skipped.report(resultFrom(ctx))
+	sidx.OnEntryError = skipped.onEntryError
+	sidx.Logger = logger
+
+	// stop parsing if extracting fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	zimArticles := sidx.ParseZIMBatches(ctx)
+	return sidx.UnZimBatches(ctx, work.ExtractDir(zimFile), zimArticles)
+}
+
+// previousEntries reads the entries of the previous version of the zim
+// for --diff-from.
+func previousEntries(file string) ([]indexer.ManifestEntry, error) {
+	entries, err := indexer.ReadEntriesManifest(file)
+	if err != nil {
+		return nil, err
+	}
+	hashed := 0
+	for _, e := range entries {
+		if e.SHA256 != "" || e.Target != "" {
+			hashed++
+		}
+	}
+	if hashed == 0 && len(entries) > 0 {
+		logger.Warn("previous entries have no checksum, every article is taken as changed", "file", filepath.Base(file))
+	}
+	logger.Info("diffing with the previous entries", "file", filepath.Base(file), "entries", len(entries))
+	return entries, nil
+}
+
+// checkWorkdirSpace fails before parsing when the workdir does not have
+// the space expected to be needed by the parsed zim, besides the space
+// kept free.
+func checkWorkdirSpace(zimPath string) error {
+	size, err := indexer.ZimSize(zimPath)
+	if err != nil {
+		return err
+	}
+	return work.CheckSpace(workdir.EstimateSize(size) + minFreeSpace())
+}
+
+// workdirSpaceCheck returns the check stopping the parse when the space
+// kept free is reached, nil when it is disabled.
+func workdirSpaceCheck() func() error {
+	if optionMinFreeSpace <= 0 {
+		return nil
+	}
+	return work.SpaceGuard(minFreeSpace())
+}
+
+func minFreeSpace() uint64 {
+	if optionMinFreeSpace <= 0 {
+		return 0
+	}
+	return uint64(optionMinFreeSpace) << 20
+}
+
+// zimFingerprint returns the fingerprint of the zim and of the tar built
+// with the current options, see mirrorpkg.TarOptions.Filters.
+func zimFingerprint(zimPath string) (indexer.Fingerprint, error) {
+	logger.Info("computing checksum of zim file", "zim", filepath.Base(zimPath))
+	fp, err := mirrorpkg.Fingerprint(zimPath, optionEnableSearch)
+	if err != nil {
+		return indexer.Fingerprint{}, err
+	}
+	zimFile := filepath.Base(zimPath)
+	dedup, _ := indexer.ParseDedup(optionDedup)
+	opts := tarOptions(&fp, dedup, zimFile)
+	if splitSearch() {
+		opts.SearchTarPath = work.SearchTarPath(zimFile)
+	}
+	fp.Filters = opts.Filters()
+	return fp, nil
+}
+
+// namespaceFilter returns the filter of the namespaces parsed given with
+// --include-namespaces and --exclude-namespaces.
+func namespaceFilter() indexer.NamespaceFilter {
+	return indexer.NewNamespaceFilter([]byte(optionIncludeNamespaces), []byte(optionExcludeNamespaces))
+}
+
+// mimeFilter returns the filter of the mime types parsed given with
+// --allow-mime and --block-mime.
+func mimeFilter() indexer.MimeFilter {
+	return indexer.MimeFilter{Allowed: optionAllowMimes, Blocked: optionBlockMimes}
+}
+
+// maxArticleSize returns the size in bytes given in MiB with
+// --max-article-size, zero for no limit.
+func maxArticleSize() int64 {
+	if optionMaxArticleSize <= 0 {
+		return 0
+	}
+	return optionMaxArticleSize << 20
+}
+
+// collisions returns the policy given with --collisions.
+func collisions() (indexer.Collisions, error) {
+	c, err := indexer.ParseCollisions(optionCollisions)
+	if err != nil {
+		return c, fmt.Errorf("--%s: %w", optionNameCollisions, err)
+	}
+	return c, nil
+}
+
+// offlinePolicy returns how the HTML articles are rewritten with
+// --offline.
+func offlinePolicy() (indexer.Offline, error) {
+	o, err := indexer.ParseOffline(optionOffline)
+	if err != nil {
+		return o, fmt.Errorf("--%s: %w", optionNameOffline, err)
+	}
+	return o, nil
+}
+
+// extraFiles returns the local files packed into the tar given with
+// --extra-file, by their path in the tar.
+func extraFiles() (map[string]string, error) {
+	if len(optionExtraFiles) == 0 {
+		return nil, nil
+	}
+	files := make(map[string]string, len(optionExtraFiles))
+	for _, f := range optionExtraFiles {
+		name, local, ok := strings.Cut(f, "=")
+		if !ok || local == "" {
+			return nil, fmt.Errorf("--%s: %q is not path=local", optionNameExtraFiles, f)
+		}
+		if _, dup := files[name]; dup {
+			return nil, fmt.Errorf("--%s: path %q given twice", optionNameExtraFiles, name)
+		}
+		files[name] = local
+	}
+	return files, nil
+}
+
+// mimeOverrides returns the types given to the entries with
+// --mime-override, by pattern.
+func mimeOverrides() (indexer.MimeOverrides, error) {
+	if len(optionMimeOverrides) == 0 {
+		return nil, nil
+	}
+	overrides := make(indexer.MimeOverrides, len(optionMimeOverrides))
+	for _, o := range optionMimeOverrides {
+		pattern, t, ok := strings.Cut(o, "=")
+		if !ok || pattern == "" || t == "" {
+			return nil, fmt.Errorf("--%s: %q is not pattern=type", optionNameMimeOverrides, o)
+		}
+		if _, dup := overrides[pattern]; dup {
+			return nil, fmt.Errorf("--%s: pattern %q given twice", optionNameMimeOverrides, pattern)
+		}
+		overrides[pattern] = t
+	}
+	return overrides, nil
+}
+
+// entryStoreDir returns the directory of the entries of the parses of the
+// zim with --entry-store=disk, none to keep them in memory.
+func entryStoreDir(zimFile string) (string, error) {
+	switch optionEntryStore {
+	case "memory":
+		return "", nil
+	case "disk":
+		return work.EntriesDir(zimFile), nil
+	}
+	return "", fmt.Errorf("--%s: unknown entry store %q, want memory or disk", optionNameEntryStore, optionEntryStore)
+}
+
+// reusableTar reports whether the tar left by a previous run can be
+// uploaded without parsing the zim again. Tars built from another zim or
+// with other options are refused unless --force is given.
+func reusableTar(tarPath string, fp indexer.Fingerprint) (bool, error) {
+	if _, err := os.Stat(tarPath); os.IsNotExist(err) || optionForce {
+		return false, nil
+	}
+
+	ok, err := stages().ReusableTar(tarPath, fp)
+	if errors.Is(err, mirrorpkg.ErrTarMismatch) {
+		return false, fmt.Errorf("%v: use --%s to parse it again", err, optionNameForce)
+	}
+	if err != nil || !ok {
+		return false, err
+	}
+
+	// the search index is either still in its own tar or already uploaded
+	if splitSearch() {
+		a, err := tarball.OpenArchive(tarPath)
+		if err != nil {
+			return false, err
+		}
+		defer a.Close()
+		name := filepath.Base(tarPath)
+		_, uploaded := a.Entry(searchPointerFile)
+		if _, err := os.Stat(searchTarPath(tarPath)); err != nil && !uploaded {
+			logger.Info("tar has no search index tar, parsing the zim again", "tar", name)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func recordParseStats(res *result.Result, sidx *indexer.SwarmZimIndexer, zimPath string) {
+	stats := &result.Stats{
+		Articles: int(sidx.Z.ArticleCount()),
+		Entries:  sidx.EntryCount(),
+		Panics:   len(sidx.Panics()),
+		Skipped:  len(sidx.Skipped()),
+		TooLarge: len(sidx.TooLarge()),
+	}
+	d := sidx.DedupStats()
+	stats.Duplicates, stats.DedupSaved = d.Duplicates, d.Saved
+	m := sidx.MinifyStats()
+	stats.Minified, stats.MinifySaved, stats.MinifyInvalid = m.Minified, m.Saved, m.Invalid
+	r := sidx.RelativeLinksStats()
+	stats.RelativeArticles, stats.RelativeLinks = r.Articles, r.Links
+	o := sidx.OfflineStats()
+	stats.OfflineRewritten, stats.OfflineLinks, stats.OfflineResources = o.Articles, o.Links, o.Resources
+	x := sidx.SearchIndexStats()
+	stats.SearchIndexes, stats.SearchIndexBytes = x.Entries, x.Bytes
+	f := sidx.MimeFixes()
+	stats.MimeOverridden, stats.MimeSniffed = f.Overridden, f.Sniffed
+	stats.Collisions = len(sidx.PathCollisions())
+	for t, s := range sidx.MimeFiltered() {
+		if stats.MimeFiltered == nil {
+			stats.MimeFiltered = make(map[string]result.MimeStats)
+		}
+		stats.MimeFiltered[t] = result.MimeStats(s)
+	}
+	if size, err := indexer.ZimSize(zimPath); err == nil {
+		stats.ZimSize = size
+	}
+	res.Stats = stats
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/r0qs/beezim/internal/progress"
+
+	"github.com/mattn/go-isatty"
+)
+
+var (
+	// progressUI renders the progress reported by the pipeline stages,
+	// one line per stage or, in batch mode, one line per wiki.
+	progressUI     *progress.UI
+	progressStages progress.Reporter
+)
+
+// startProgress starts rendering the progress on stderr. Progress lines
+// are redrawn in place on a terminal, below the logs and the output of the
+// commands, and written as periodic log lines otherwise or when the result
+// is written as JSON.
+func startProgress() {
+	tty := isatty.IsTerminal(os.Stderr.Fd()) && !optionJSON
+	progressUI = progress.NewUI(os.Stderr, progress.Options{TTY: tty})
+	progressStages = progressUI.Stages()
+	if tty && isatty.IsTerminal(os.Stdout.Fd()) {
+		stdout = progressUI
+	}
+	progressUI.Start()
+}
+
+func stopProgress() {
+	if progressUI != nil {
+		progressUI.Stop()
+	}
+}
+
+// reportProgress forwards the events of the stages to the progress UI once
+// it is started, since the context is set before the flags are parsed, and
+// to the collector of the current run.
+var reportProgress = progress.ReporterFunc(func(e progress.Event) {
+	if progressStages != nil {
+		progressStages.Report(e)
+	}
+	if currentPerf != nil {
+		currentPerf.Report(e)
+	}
+})
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"io"
+	"os"
+
+	"github.com/r0qs/beezim/internal/result"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// stdout receives the human readable output of the commands. It is
+	// redirected to stderr in JSON mode so stdout only contains the result.
+	stdout io.Writer = os.Stdout
+
+	runResult *result.Result
+)
+
+// initResult starts recording the result of the executed command.
+func initResult(cmd *cobra.Command) {
+	if optionJSON {
+		stdout = os.Stderr
+	}
+
+	runResult = newResult(cmd)
+}
+
+// newResult returns an empty result with the inputs given to the command.
+func newResult(cmd *cobra.Command) *result.Result {
+	res := result.New(cmd.CommandPath())
+	res.Inputs = result.Inputs{
+		ZimFile:      optionZimFile,
+		ZimURL:       optionZimURL,
+		Kiwix:        optionKiwix,
+		EnableSearch: optionEnableSearch,
+		ExtractOnly:  optionExtractOnly,
+		Seed:         optionSeed,
+	}
+
+	filters := map[string]string{
+		optionNameFromCatalog:     optionFromCatalog,
+		optionNameCatalogName:     optionCatalogName,
+		optionNameCatalogLang:     optionCatalogLang,
+		optionNameCatalogCategory: optionCatalogCategory,
+	}
+	for k, v := range filters {
+		if v == "" {
+			delete(filters, k)
+		}
+	}
+	if len(filters) > 0 {
+		res.Inputs.Filters = filters
+	}
+	return res
+}
+
+// writeResult writes the JSON result of the command when requested.
+func writeResult(runErr error) error {
+	if !optionJSON || runResult == nil {
+		return nil
+	}
+	runResult.Finish(runErr)
+
+	if optionJSONOut == "" || optionJSONOut == "-" {
+		return runResult.Write(os.Stdout)
+	}
+
+	f, err := os.Create(optionJSONOut)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return runResult.Write(f)
+}
@@ -0,0 +1,303 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/r0qs/beezim/internal/limiter"
+	"github.com/r0qs/beezim/internal/perf"
+	"github.com/r0qs/beezim/internal/progress"
+	"github.com/r0qs/beezim/internal/result"
+	"github.com/r0qs/beezim/internal/store"
+	mirrorpkg "github.com/r0qs/beezim/pkg/mirror"
+
+	"github.com/spf13/cobra"
+)
+
+const dbFile = "beezim.db"
+
+// pipelineCommands are the commands whose runs are recorded in the local database.
+var pipelineCommands = map[string]bool{
+	"mirror":   true,
+	"download": true,
+	"parse":    true,
+	"upload":   true,
+	"all":      true,
+}
+
+var errInterrupted = errors.New("run interrupted")
+
+var (
+	db          *store.Store
+	currentRun  *store.Run
+	currentPerf *perf.Collector
+	interrupted int32
+)
+
+// handleSignals cancels the returned context on the first SIGINT or SIGTERM,
+// so the running stage can stop and persist its state. A second signal
+// forces an immediate exit.
+func handleSignals(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-sigs:
+			atomic.StoreInt32(&interrupted, 1)
+			logger.Info("shutting down gracefully (repeat to force exit)", "signal", sig.String())
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+
+		<-sigs
+		logger.Warn("forced exit")
+		os.Exit(130)
+	}()
+
+	return ctx, func() {
+		signal.Stop(sigs)
+		cancel()
+	}
+}
+
+func wasInterrupted() bool {
+	return atomic.LoadInt32(&interrupted) == 1
+}
+
+// startRun opens the local database and records the beginning of a pipeline run.
+func startRun(cmd *cobra.Command) (err error) {
+	if !pipelineCommands[cmd.Name()] {
+		return nil
+	}
+
+	db, err = store.Open(filepath.Join(optionDataDir, dbFile))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	currentRun = &store.Run{
+		ID:        store.NewRunID(),
+		Command:   cmd.CommandPath(),
+		ZimFile:   optionZimFile,
+		ZimURL:    optionZimURL,
+		TarFile:   optionTarFile,
+		Status:    store.StatusRunning,
+		BatchID:   optionBeeBatchID,
+		StartedAt: now,
+	}
+	currentPerf = perf.Start()
+	return db.PutRun(*currentRun)
+}
+
+// pipeline is the state of a pipeline run: the run recorded in the local
+// database, its result, the collector of its performance and, for runs
+// executed concurrently, the shared limits.
+type pipeline struct {
+	run    *store.Run
+	result *result.Result
+	perf   *perf.Collector
+	limits *limiter.Limits
+}
+
+type pipelineKey struct{}
+
+// withPipeline returns a context carrying the state of a run, whose
+// progress is also reported to its collector. Runs executed concurrently
+// must use it instead of the current run.
+func withPipeline(ctx context.Context, p pipeline) context.Context {
+	if p.perf != nil {
+		ctx = progress.WithReporter(ctx, progress.Tee(progress.FromContext(ctx), p.perf))
+	}
+	return context.WithValue(ctx, pipelineKey{}, p)
+}
+
+// pipelineFrom returns the state of the run carried by the context, or the
+// current run.
+func pipelineFrom(ctx context.Context) pipeline {
+	if p, ok := ctx.Value(pipelineKey{}).(pipeline); ok {
+		return p
+	}
+	return pipeline{run: currentRun, result: runResult, perf: currentPerf}
+}
+
+// resultFrom returns the result of the run carried by the context.
+func resultFrom(ctx context.Context) *result.Result {
+	return pipelineFrom(ctx).result
+}
+
+// limitsFrom returns the shared limits of the run carried by the context,
+// nil when the run is not limited.
+func limitsFrom(ctx context.Context) *limiter.Limits {
+	return pipelineFrom(ctx).limits
+}
+
+// updateRun applies fn to the run carried by the context and persists it.
+func updateRun(ctx context.Context, fn func(r *store.Run)) {
+	pipelineFrom(ctx).update(fn)
+}
+
+func (p pipeline) update(fn func(r *store.Run)) {
+	if p.run == nil {
+		return
+	}
+	fn(p.run)
+	if err := db.PutRun(*p.run); err != nil {
+		logger.Error("error updating run in the local database", "run", p.run.ID, "err", err)
+	}
+}
+
+// stages returns the stages of the mirror pipeline, run with the node.
+func stages() *mirrorpkg.Pipeline {
+	return mirrorpkg.New(mirrorpkg.Options{Bee: bee, Store: db, Logger: logger})
+}
+
+// setStage records the pipeline stage being executed.
+func setStage(ctx context.Context, stage string) {
+	updateRun(ctx, func(r *store.Run) {
+		r.Stage = stage
+	})
+}
+
+// finishRun records the outcome of the current run.
+func finishRun(runErr error) error {
+	return pipeline{run: currentRun, result: runResult, perf: currentPerf}.finish(runErr)
+}
+
+// finish records the outcome of the run. Interrupted runs keep pointers
+// to the preserved artifacts so they can be resumed, and skipped runs are
+// recorded as such.
+func (p pipeline) finish(runErr error) error {
+	if p.run == nil {
+		return runErr
+	}
+	p.reportPerformance()
+
+	if wasInterrupted() {
+		p.update(func(r *store.Run) {
+			r.Status = store.StatusInterrupted
+			r.Resume = preservedArtifacts(*r)
+		})
+		printInterruptSummary(*p.run)
+		notifyResult(p.result, errInterrupted)
+		return errInterrupted
+	}
+
+	// a skipped run is not a failure, nor notified
+	if errors.Is(runErr, errSkipped) {
+		p.update(func(r *store.Run) {
+			r.Status = store.StatusSkipped
+			r.Error = runErr.Error()
+		})
+		logger.Warn("run skipped", "run", p.run.ID, "reason", runErr)
+		if p.result != nil {
+			p.result.Warn(runErr.Error())
+		}
+		return runErr
+	}
+
+	if runErr != nil && p.result != nil {
+		p.result.FailedStage = p.run.Stage
+	}
+	p.update(func(r *store.Run) {
+		if runErr != nil {
+			r.Status = store.StatusFailed
+			r.Error = runErr.Error()
+			return
+		}
+		r.Status = store.StatusCompleted
+	})
+	notifyResult(p.result, runErr)
+	return runErr
+}
+
+// reportPerformance stops the collector of the run, logs the throughput
+// of its stages and adds it to the result.
+func (p pipeline) reportPerformance() {
+	if p.perf == nil {
+		return
+	}
+	perf := p.perf.Stop()
+	if p.result != nil {
+		// the result adds the stages that reported no progress
+		p.result.SetPerformance(perf)
+		perf = p.result.Performance
+	}
+	const mib = 1 << 20
+	for _, s := range perf.Stages {
+		logger.Info("stage performance", "run", p.run.ID, "stage", s.Stage,
+			"seconds", fmt.Sprintf("%.2f", s.Seconds),
+			"itemsPerSecond", fmt.Sprintf("%.1f", s.ItemsPerSecond),
+			"mibPerSecond", fmt.Sprintf("%.2f", s.BytesPerSecond/mib))
+	}
+	logger.Info("run resources", "run", p.run.ID,
+		"peakRSSMiB", fmt.Sprintf("%.1f", float64(perf.PeakRSS)/mib),
+		"peakGoMemoryMiB", fmt.Sprintf("%.1f", float64(perf.PeakGoMemory)/mib),
+		"gcCycles", perf.GCCycles,
+		"gcPauseSeconds", fmt.Sprintf("%.3f", perf.GCPauseSeconds),
+		"allocs", perf.Allocs,
+		"allocMiB", fmt.Sprintf("%.1f", float64(perf.AllocBytes)/mib))
+}
+
+// preservedArtifacts returns the artifacts left on disk by the run.
+func preservedArtifacts(r store.Run) map[string]string {
+	resume := make(map[string]string)
+	exists := func(p string) bool {
+		_, err := os.Stat(p)
+		return err == nil
+	}
+
+	if r.ZimFile != "" {
+		zimPath := filepath.Join(optionDataDir, r.ZimFile)
+		if exists(zimPath) {
+			resume["zim"] = zimPath
+		} else if exists(zimPath + ".part") {
+			resume["partialDownload"] = zimPath + ".part"
+		}
+	}
+
+	if r.TarFile != "" {
+		tarPath := artifactPath(r.TarFile)
+		if exists(tarPath) {
+			// the tar is only complete once the parse stage finished
+			if r.Stage == "parse" {
+				resume["partialTar"] = tarPath
+			} else {
+				resume["tar"] = tarPath
+			}
+		}
+	}
+	return resume
+}
+
+func printInterruptSummary(r store.Run) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Run interrupted during stage %q.", r.Stage)
+	if len(r.Resume) == 0 {
+		b.WriteString(" Nothing was preserved.")
+	} else {
+		b.WriteString(" Preserved:")
+		kinds := make([]string, 0, len(r.Resume))
+		for k := range r.Resume {
+			kinds = append(kinds, k)
+		}
+		sort.Strings(kinds)
+		for _, k := range kinds {
+			fmt.Fprintf(&b, "\n  %s: %s", k, r.Resume[k])
+		}
+	}
+	fmt.Fprintf(&b, "\nThe run was recorded as %s in %s", r.ID, filepath.Join(optionDataDir, dbFile))
+	logger.Info(b.String())
+}
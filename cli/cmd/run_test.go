@@ -0,0 +1,127 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/indexer/zimtest"
+	"github.com/r0qs/beezim/internal/progress"
+	"github.com/r0qs/beezim/internal/store"
+	"github.com/r0qs/beezim/internal/tarball"
+)
+
+// execute runs the command line as Execute does, with the reporter of the
+// progress of the stages, and returns the error of the recorded run. The
+// commands are made again, as they keep the context of their first run
+// and the flags set.
+func execute(ctx context.Context, rep progress.Reporter, args ...string) error {
+	rootCmd.ResetCommands()
+	rootCmd.AddCommand(newParserCmd(), newMirrorCmd(), newCleanCmd(), newConfigCmd())
+	rootCmd.SetArgs(args)
+	err := rootCmd.ExecuteContext(progress.WithReporter(ctx, rep))
+	stopProgress()
+	return finishRun(err)
+}
+
+// writeZim writes a ZIM of n articles to the datadir.
+func writeZim(t *testing.T, dataDir string, name string, n int) {
+	t.Helper()
+	entries := make([]zimtest.Entry, n)
+	for i := range entries {
+		url := fmt.Sprintf("Article%d", i)
+		entries[i] = zimtest.Entry{Namespace: 'A', URL: url, Title: url, Mime: "text/html", Content: []byte("<p>" + url + "</p>")}
+	}
+	if err := zimtest.New(entries...).WriteFile(filepath.Join(dataDir, name)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestInterruptedParse sends SIGINT to the process in the middle of a
+// parse checkpointed every 10 articles, whose progress waits for the run
+// to be canceled, and checks the run recorded and the checkpoint kept.
+func TestInterruptedParse(t *testing.T) {
+	dataDir := t.TempDir()
+	writeZim(t, dataDir, "wiki_en_all_2022-05.zim", 500)
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(config, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, stop := handleSignals(context.Background())
+	defer stop()
+	defer atomic.StoreInt32(&interrupted, 0)
+	var signaled atomic.Bool
+	slow := progress.ReporterFunc(func(e progress.Event) {
+		if e.Stage != "parse" || e.Done < 100 {
+			return
+		}
+		if !signaled.Swap(true) {
+			p, err := os.FindProcess(os.Getpid())
+			if err == nil {
+				err = p.Signal(os.Interrupt)
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		<-ctx.Done()
+	})
+
+	err := execute(ctx, slow, "parse", "--config", config, "--datadir", dataDir, "--zim", "wiki_en_all_2022-05.zim", "--checkpoint-every", "10")
+	if !errors.Is(err, errInterrupted) {
+		t.Fatalf("got %v, want %v", err, errInterrupted)
+	}
+
+	tarPath := filepath.Join(dataDir, defaultWorkDir, "wiki_en_all_2022-05.tar")
+	db, err := store.Open(filepath.Join(dataDir, dbFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := db.Runs()
+	if len(runs) != 1 {
+		t.Fatalf("got %d runs recorded, want 1", len(runs))
+	}
+	r := runs[0]
+	if r.Status != store.StatusInterrupted || r.Stage != "parse" {
+		t.Errorf("run recorded %s during %q, want %s during the parse", r.Status, r.Stage, store.StatusInterrupted)
+	}
+	if r.Resume["partialTar"] != tarPath {
+		t.Errorf("run resumable from %v, want the partial tar %s", r.Resume, tarPath)
+	}
+	if r.Resume["zim"] != filepath.Join(dataDir, "wiki_en_all_2022-05.zim") {
+		t.Errorf("run resumable from %v, want the zim", r.Resume)
+	}
+	if _, err := os.Stat(indexer.CheckpointFile(tarPath)); err != nil {
+		t.Fatalf("checkpoint of the tar: %v", err)
+	}
+
+	// the next run resumes the tar from its checkpoint
+	stop()
+	atomic.StoreInt32(&interrupted, 0)
+	err = execute(context.Background(), progress.ReporterFunc(func(progress.Event) {}), "parse", "--config", config, "--datadir", dataDir, "--zim", "wiki_en_all_2022-05.zim", "--checkpoint-every", "10", "--resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := tarball.OpenArchive(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	for i := range 500 {
+		if _, _, err := a.Open(fmt.Sprintf("A/Article%d", i)); err != nil {
+			t.Fatalf("resumed tar: %v", err)
+		}
+	}
+	if _, err := os.Stat(indexer.CheckpointFile(tarPath)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("checkpoint left after the tar was ended: %v", err)
+	}
+}
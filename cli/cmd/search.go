@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/internal/beeclient/api"
+	"github.com/r0qs/beezim/internal/store"
+	"github.com/r0qs/beezim/internal/tarball"
+
+	"github.com/spf13/cobra"
+)
+
+// searchPointerFile is the file of a mirror referencing the collection
+// its search index was uploaded to.
+const searchPointerFile = "search.json"
+
+// searchPointer is the content of the search pointer file: the search
+// index is served at /bzz/<root>/<path>.
+type searchPointer struct {
+	Root string `json:"root"`
+	Path string `json:"path"`
+}
+
+func addSplitSearchFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&optionSplitSearch, optionNameSplitSearch, false, "upload the search index as its own collection referenced by the mirror (requires --enable-search)")
+}
+
+// splitSearch reports whether the search index is built and uploaded
+// apart from the content.
+func splitSearch() bool {
+	return optionSplitSearch && optionEnableSearch && !optionExtractOnly
+}
+
+// searchTarPath returns the path of the search index tar built along
+// with the tar.
+func searchTarPath(tarPath string) string {
+	return strings.TrimSuffix(tarPath, filepath.Ext(tarPath)) + "-search.tar"
+}
+
+// uploadSearchIndex uploads the search index tar built along with the tar
+// and adds to the tar the pointer to its root. An index uploaded by a
+// previous run is not uploaded again.
+func uploadSearchIndex(ctx context.Context, tarPath string, batchID string) (string, error) {
+	if p, ok, err := readSearchPointer(tarPath); err != nil || ok {
+		return p.Root, err
+	}
+
+	searchPath := searchTarPath(tarPath)
+	name := filepath.Base(searchPath)
+	logger.Info("uploading the search index", "tar", name)
+	f, _, err := uploadCollection(ctx, searchPath, name, api.UploadCollectionOptions{
+		Tag:     optionBeeTag,
+		Pin:     optionBeePin,
+		BatchID: batchID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error uploading the search index: %v", err)
+	}
+	root := f.Address().String()
+	logger.Info("search index uploaded", "tar", name, "reference", root)
+
+	data, err := json.Marshal(searchPointer{Root: root, Path: indexer.SearchIndexPath})
+	if err != nil {
+		return "", err
+	}
+	if err := tarball.AppendTarFile(tarPath, tarball.NewBytesFile(searchPointerFile, data)); err != nil {
+		return "", err
+	}
+	return root, nil
+}
+
+// readSearchPointer reads the search pointer of the tar, if any.
+func readSearchPointer(tarPath string) (searchPointer, bool, error) {
+	var p searchPointer
+	a, err := tarball.OpenArchive(tarPath)
+	if err != nil {
+		return p, false, err
+	}
+	defer a.Close()
+
+	r, _, err := a.Open(searchPointerFile)
+	if os.IsNotExist(err) {
+		return p, false, nil
+	}
+	if err != nil {
+		return p, false, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return p, false, err
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, false, fmt.Errorf("error reading %s: %v", searchPointerFile, err)
+	}
+	return p, true, nil
+}
+
+// recordSearchReference records the root of the search index of the run.
+func recordSearchReference(ctx context.Context, root string) {
+	resultFrom(ctx).SearchReference = root
+	updateRun(ctx, func(r *store.Run) {
+		r.SearchReference = root
+	})
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/internal/preview"
+
+	"github.com/spf13/cobra"
+)
+
+func newServeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Preview a parsed zim locally before uploading it",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			src, err := previewSource(optionServeTar, optionServeDir)
+			if err != nil {
+				return err
+			}
+			defer src.Close()
+
+			handler := preview.NewHandler(src, preview.Options{
+				IndexDocument: "index.html",
+				ErrorDocument: "error.html",
+				Listing:       true,
+				MetadataFile:  "files.json",
+				RedirectsFile: indexer.RedirectsFile,
+				PathsFile:     indexer.PathsFile,
+				Logger:        logger,
+			})
+
+			logger.Info("serving preview", "url", fmt.Sprintf("http://%s", optionServeAddr))
+			return http.ListenAndServe(optionServeAddr, handler)
+		},
+	}
+	cmd.Flags().StringVar(&optionServeTar, optionNameTarFile, "", "tar file generated by the parse command")
+	cmd.Flags().StringVar(&optionServeDir, optionNameServeDir, "", "directory extracted with the parse --extract-only command")
+	cmd.Flags().StringVar(&optionServeAddr, optionNameServeAddr, "localhost:8080", "address where the preview server listens")
+
+	return cmd
+}
+
+// previewSource returns the source of the files to be served. Relative
+// paths that do not exist are looked up in the workdir and the datadir.
+func previewSource(tarFile string, dir string) (preview.Source, error) {
+	switch {
+	case tarFile != "" && dir != "":
+		return nil, fmt.Errorf("--tar and --dir are mutually exclusive")
+	case tarFile != "":
+		if err := checkTarFileName(tarFile); err != nil {
+			return nil, err
+		}
+		return preview.NewTarSource(artifactPath(tarFile))
+	case dir != "":
+		return preview.NewDirSource(artifactPath(dir))
+	default:
+		return nil, fmt.Errorf("--tar or --dir should be provided")
+	}
+}
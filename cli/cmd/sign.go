@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/internal/beeclient/api"
+	"github.com/r0qs/beezim/internal/keystore"
+	"github.com/r0qs/beezim/internal/signature"
+	"github.com/r0qs/beezim/internal/store"
+	"github.com/r0qs/beezim/internal/tarball"
+	"github.com/r0qs/beezim/internal/version"
+
+	"github.com/ethersphere/bee/pkg/swarm"
+	"github.com/spf13/cobra"
+)
+
+const keyPasswordEnv = "BEEZIM_KEY_PASSWORD"
+
+// signer signs the uploaded mirrors, it is nil when signing is disabled.
+var signer signature.Signer
+
+// addSignFlags adds the flags of the commands that sign the uploaded mirrors.
+func addSignFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&optionSignKey, optionNameSignKey, "", "name of the secp256k1 key in the keystore used to sign the mirror (generated if missing)")
+	cmd.Flags().StringVar(&optionSignKeyFile, optionNameSignKeyFile, "", "PEM file with the ed25519 key used to sign the mirror")
+	addKeystoreFlags(cmd)
+
+	// load the key before running, so a wrong password does not fail
+	// the run after the upload
+	preRun := cmd.PreRunE
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) (err error) {
+		if preRun != nil {
+			if err := preRun(cmd, args); err != nil {
+				return err
+			}
+		}
+		signer, err = mirrorSigner()
+		return err
+	}
+}
+
+// addKeystoreFlags adds the flags of the commands that use the keystore.
+func addKeystoreFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&optionKeystore, optionNameKeystore, "", fmt.Sprintf("path to the keystore directory (default \"~/%s\")", keystore.DefaultDir))
+	cmd.Flags().StringVar(&optionKeyPassword, optionNameKeyPassword, "", "password of the keystore keys (default $"+keyPasswordEnv+")")
+}
+
+func openKeystore() (*keystore.Keystore, error) {
+	dir := optionKeystore
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(home, keystore.DefaultDir)
+	}
+	return keystore.Open(dir), nil
+}
+
+// mirrorSigner returns the signer configured by the flags, or nil when
+// the mirror should not be signed.
+func mirrorSigner() (signature.Signer, error) {
+	if optionSignKey == "" && optionSignKeyFile == "" {
+		return nil, nil
+	}
+	if optionSignKey != "" && optionSignKeyFile != "" {
+		return nil, fmt.Errorf("--%s and --%s are mutually exclusive", optionNameSignKey, optionNameSignKeyFile)
+	}
+
+	ks, err := openKeystore()
+	if err != nil {
+		return nil, err
+	}
+
+	if optionSignKeyFile != "" {
+		key, err := ks.Ed25519(optionSignKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return signature.NewEd25519Signer(key), nil
+	}
+
+	password := optionKeyPassword
+	if password == "" {
+		password = os.Getenv(keyPasswordEnv)
+	}
+	key, created, err := ks.Secp256k1(optionSignKey, password)
+	if err != nil {
+		return nil, err
+	}
+	s := signature.NewSecp256k1Signer(key)
+	if created {
+		logger.Info("created signing key", "key", optionSignKey, "publicKey", fmt.Sprintf("%x", s.PublicKey()))
+	}
+	return s, nil
+}
+
+// signMirror signs a statement about the uploaded collection and uploads
+// it. The signature can not be part of the collection since it covers its
+// reference, so it is uploaded as a separate reference recorded in the
+// local database.
+func signMirror(ctx context.Context, dataDir string, tarFile string, root swarm.Address, batchID string) error {
+	if signer == nil {
+		return nil
+	}
+
+	name := strings.TrimSuffix(tarFile, tarball.GzipExt)
+	zimFile := strings.TrimSuffix(name, filepath.Ext(name)) + ".zim"
+	st := signature.Statement{
+		ZimFile:   zimFile,
+		Root:      root.String(),
+		Timestamp: time.Now(),
+		Beezim:    version.Get().Version,
+	}
+	if sum, err := zimSHA256(filepath.Join(dataDir, zimFile)); err == nil {
+		st.ZimSHA256 = sum
+	} else {
+		msg := fmt.Sprintf("zim checksum not included in the signature: %v", err)
+		logger.Warn("zim checksum not included in the signature", "zim", zimFile, "err", err)
+		resultFrom(ctx).Warn(msg)
+	}
+
+	signed, err := signature.Sign(st, signer)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(signed, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	ref, err := bee.UploadBytes(ctx, bytes.NewReader(data), api.UploadOptions{
+		Tag:     optionBeeTag,
+		Pin:     optionBeePin,
+		BatchID: batchID,
+	})
+	if err != nil {
+		return fmt.Errorf("error uploading %s: %v", signature.FileName, err)
+	}
+
+	logger.Info("signature uploaded", "file", signature.FileName, "root", root.String(), "reference", ref.String())
+	resultFrom(ctx).Signature = ref.String()
+	updateRun(ctx, func(r *store.Run) {
+		r.Signature = ref.String()
+	})
+	return nil
+}
+
+// zimSHA256 returns the checksum of the zim, of its parts joined when it
+// is split.
+func zimSHA256(path string) (string, error) {
+	f, err := indexer.OpenZimFile(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, f.Size())); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func newVerifySignatureCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify-signature",
+		Short: "Verify the signature of a mirror",
+		Long: "\nFetches the signature of the mirror and checks that it covers the given root and was made by the given public key." +
+			"\nThe signature reference is looked up in the local database when --signature is not given.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if optionVerifyRoot == "" || optionVerifyPubKey == "" {
+				return fmt.Errorf("--%s and --%s should be provided", optionNameVerifyRoot, optionNameVerifyPubKey)
+			}
+
+			sigRef := optionVerifySignature
+			if sigRef == "" {
+				var err error
+				if sigRef, err = lookupSignature(optionVerifyRoot); err != nil {
+					return err
+				}
+			}
+
+			addr, err := swarm.ParseHexAddress(sigRef)
+			if err != nil {
+				return fmt.Errorf("invalid signature reference: %v", err)
+			}
+			r, err := bee.DownloadBytes(cmd.Context(), addr)
+			if err != nil {
+				return fmt.Errorf("error fetching signature %s: %v", sigRef, err)
+			}
+			defer r.Close()
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+
+			signed, err := signature.Decode(data)
+			if err != nil {
+				return err
+			}
+			if signed.Statement.Root != optionVerifyRoot {
+				return fmt.Errorf("signature covers root %s, not %s", signed.Statement.Root, optionVerifyRoot)
+			}
+			if err := signed.Verify(optionVerifyPubKey); err != nil {
+				return err
+			}
+
+			prov, hasProv := rootProvenance(cmd.Context(), optionVerifyRoot)
+			if optionJSON {
+				runResult.Data = signed
+				return nil
+			}
+			st := signed.Statement
+			fmt.Fprintf(stdout, "Valid %s signature of %s\n", signed.Algorithm, st.Root)
+			fmt.Fprintf(stdout, "  zim:       %s\n", st.ZimFile)
+			fmt.Fprintf(stdout, "  sha256:    %s\n", st.ZimSHA256)
+			fmt.Fprintf(stdout, "  signed at: %s\n", st.Timestamp.Format(time.RFC3339))
+			fmt.Fprintf(stdout, "  beezim:    %s\n", st.Beezim)
+			if hasProv {
+				fmt.Fprintln(stdout)
+				printProvenance(prov)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&optionVerifyRoot, optionNameVerifyRoot, "", "swarm reference of the mirror")
+	cmd.Flags().StringVar(&optionVerifyPubKey, optionNameVerifyPubKey, "", "hex encoded public key (or ethereum address for secp256k1) of the publisher")
+	cmd.Flags().StringVar(&optionVerifySignature, optionNameVerifySignature, "", "swarm reference of the signature")
+
+	return cmd
+}
+
+// rootProvenance fetches the provenance of the mirror at root and adds it
+// to the result, returning false when the mirror has none or it can not
+// be fetched.
+func rootProvenance(ctx context.Context, root string) (indexer.Provenance, bool) {
+	addr, err := swarm.ParseHexAddress(root)
+	if err != nil {
+		return indexer.Provenance{}, false
+	}
+	data, err := bee.DownloadManifestBytes(ctx, addr, indexer.ProvenanceFile)
+	if err != nil {
+		logger.Debug("mirror provenance not fetched", "root", root, "err", err)
+		return indexer.Provenance{}, false
+	}
+	p, err := indexer.DecodeProvenance(data)
+	if err != nil {
+		logger.Warn("error reading the provenance of the mirror", "root", root, "err", err)
+		return indexer.Provenance{}, false
+	}
+	recordProvenance(runResult, p)
+	return p, true
+}
+
+// lookupSignature returns the signature reference recorded for the root.
+func lookupSignature(root string) (string, error) {
+	db, err := store.Open(filepath.Join(optionDataDir, dbFile))
+	if err != nil {
+		return "", err
+	}
+
+	runs := db.Runs()
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].Reference == root && runs[i].Signature != "" {
+			return runs[i].Signature, nil
+		}
+	}
+	return "", fmt.Errorf("no signature recorded for %s, use --%s", root, optionNameVerifySignature)
+}
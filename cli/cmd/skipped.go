@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/internal/result"
+)
+
+// errTooManySkipped is the error of a parse stopped because more entries
+// than --skip-budget could not be read.
+var errTooManySkipped = errors.New("too many zim entries could not be read")
+
+// skippedReportMu serializes the writes of the runs of the process to the
+// report of --skipped-report.
+var skippedReportMu sync.Mutex
+
+// skippedEntries decides which entries of the parse of a zim that can not
+// be read are skipped, and records them for the report.
+type skippedEntries struct {
+	zimFile string
+	entries []indexer.EntryError
+}
+
+// onEntryError skips the entries up to --skip-budget, all of them when it
+// is negative.
+func (s *skippedEntries) onEntryError(e indexer.EntryError) error {
+	s.entries = append(s.entries, e)
+	if optionSkipBudget >= 0 && len(s.entries) > optionSkipBudget {
+		return fmt.Errorf("%w: %d, the last: %w", errTooManySkipped, len(s.entries), e)
+	}
+	return nil
+}
+
+// report warns about the entries skipped in the result and appends them
+// to the report of --skipped-report, when set.
+func (s *skippedEntries) report(res *result.Result) {
+	if len(s.entries) == 0 {
+		return
+	}
+	msg := fmt.Sprintf("%d articles of %s failed to extract", len(s.entries), s.zimFile)
+	if optionSkippedReport != "" {
+		if err := appendSkippedReport(optionSkippedReport, s.zimFile, s.entries); err != nil {
+			logger.Warn("error writing the report of the skipped entries", "report", optionSkippedReport, "err", err)
+		} else {
+			msg += ", listed in " + optionSkippedReport
+		}
+	}
+	res.Warn(msg)
+}
+
+// appendSkippedReport appends the entries of the zim to the report, one
+// tab separated line of zim, url index, url and error per entry.
+func appendSkippedReport(path, zimFile string, entries []indexer.EntryError) error {
+	skippedReportMu.Lock()
+	defer skippedReportMu.Unlock()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%v\n", zimFile, e.Index, e.URL, e.Err)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -2,14 +2,22 @@ package cmd
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/r0qs/beezim/internal/beeclient/api"
+	"github.com/r0qs/beezim/internal/result"
+	"github.com/r0qs/beezim/internal/store"
 	"github.com/r0qs/beezim/internal/tarball"
+	"github.com/r0qs/beezim/internal/workdir"
+	mirrorpkg "github.com/r0qs/beezim/pkg/mirror"
 
 	"github.com/ethersphere/bee/pkg/swarm"
 	"github.com/spf13/cobra"
@@ -20,9 +28,30 @@ func newUploadCmd() *cobra.Command {
 		Use:   "upload",
 		Short: "Upload tar file to swarm",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if optionPrintFiles && !optionDryRun {
+				return fmt.Errorf("--%s requires --%s", optionNamePrintFiles, optionNameDryRun)
+			}
+			if optionVolumes != "" {
+				if optionDryRun {
+					return fmt.Errorf("--%s can not be used together with --%s", optionNameDryRun, optionNameVolumes)
+				}
+				if optionTarFile != "" {
+					return fmt.Errorf("--%s can not be used together with --%s", optionNameVolumes, optionNameTarFile)
+				}
+				return uploadVolumesCmd(cmd.Context(), optionVolumes)
+			}
 			if err := checkTarFileName(optionTarFile); err != nil {
 				return err
 			}
+			if optionDryRun {
+				return dryRunUpload(cmd.Context(), optionTarFile)
+			}
+
+			unlock, err := lockWiki(cmd.Context(), optionTarFile)
+			if err != nil {
+				return err
+			}
+			defer unlock()
 
 			ctx, cancel := context.WithCancel(cmd.Context())
 			defer cancel()
@@ -31,12 +60,19 @@ func newUploadCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			log.Printf("collection %v uploaded with reference: %v", optionTarFile, addr)
-			fmt.Printf("\nTry the link: %s\n", makeURL(addr.String()))
+			logger.Info("collection uploaded", "tar", optionTarFile, "reference", addr.String())
+			fmt.Fprintf(stdout, "\nTry the link: %s\n", makeURL(addr.String()))
 			return nil
 		},
 	}
 	cmd.Flags().StringVar(&optionTarFile, optionNameTarFile, "", "tar file name")
+	cmd.Flags().StringVar(&optionVolumes, optionNameVolumes, "", "directory of the volumes written by parse --volume-size, uploaded one by one under a single root")
+	cmd.Flags().BoolVar(&optionDryRun, optionNameDryRun, false, "only print the reference the upload would return, computed locally without a node")
+	cmd.Flags().BoolVar(&optionPrintFiles, optionNamePrintFiles, false, "with --dry-run, also print the reference of each file of the tar")
+	addKeepFlags(cmd)
+	addNotifyFlags(cmd)
+	addSignFlags(cmd)
+	addGatewayCheckFlags(cmd)
 	// TODO: add upload all option
 	cmd.AddCommand(
 		newUploadAllCmd(),
@@ -49,14 +85,14 @@ func checkTarFileName(tarFile string) error {
 	if tarFile == "" {
 		return fmt.Errorf("please provide a tar file")
 	}
-	if filepath.Ext(tarFile) != ".tar" {
-		return fmt.Errorf("file must has .tar extention")
+	if filepath.Ext(strings.TrimSuffix(tarFile, tarball.GzipExt)) != ".tar" {
+		return fmt.Errorf("file must has .tar or .tar.gz extention")
 	}
 	return nil
 }
 
 func upload(ctx context.Context, dataDir string, tarFile string, batchID string) (swarm.Address, error) {
-	tarPath := filepath.Join(dataDir, tarFile)
+	tarPath := artifactPath(tarFile)
 	if _, err := os.Stat(tarPath); os.IsNotExist(err) {
 		return swarm.Address{}, fmt.Errorf("tar file %s not found", tarFile)
 	}
@@ -68,6 +104,17 @@ func upload(ctx context.Context, dataDir string, tarFile string, batchID string)
 	// TODO: keep address for local metadata
 	// TODO: command to buy stamps and check if stamp they are usable
 	// --wait-usable-stamp (keep waiting until bought stamp is ready)
+	start := time.Now()
+	defer resultFrom(ctx).Stage("upload", start)
+	updateRun(ctx, func(r *store.Run) {
+		r.Stage = "upload"
+		r.TarFile = tarFile
+		r.BatchID = batchID
+	})
+	if err := mirrorpkg.CheckTar(tarPath); err != nil {
+		return swarm.Address{}, err
+	}
+
 	addr, err := uploadTarFile(ctx, tarPath, tarFile, api.UploadCollectionOptions{
 		Tag:                 optionBeeTag,
 		Pin:                 optionBeePin,
@@ -79,6 +126,121 @@ func upload(ctx context.Context, dataDir string, tarFile string, batchID string)
 		return swarm.Address{}, err
 	}
 
+	if err := signMirror(ctx, dataDir, tarFile, addr, batchID); err != nil {
+		return swarm.Address{}, err
+	}
+
+	if err := checkUploadedRoot(ctx, addr.String(), tarPath, nil); err != nil {
+		return swarm.Address{}, err
+	}
+
+	releaseArtifacts(tarPath)
+	if optionClean {
+		cleanDatadir()
+	}
+	return addr, nil
+}
+
+// dryRunUpload prints the reference the upload of the tar would return,
+// and those of its files with --print-files, without uploading it.
+func dryRunUpload(ctx context.Context, tarFile string) error {
+	tarPath := artifactPath(tarFile)
+	if _, err := os.Stat(tarPath); os.IsNotExist(err) {
+		return fmt.Errorf("tar file %s not found", tarFile)
+	}
+	if err := mirrorpkg.CheckTar(tarPath); err != nil {
+		return err
+	}
+	c, err := stages().LocalReference(ctx, tarPath, api.UploadCollectionOptions{
+		IndexDocumentHeader: "index.html",
+		ErrorDocumentHeader: "error.html",
+	})
+	if err != nil {
+		return err
+	}
+	res := resultFrom(ctx)
+	res.TarFile = tarFile
+	res.Reference = c.Root.String()
+	if optionPrintFiles {
+		files := make(map[string]string, len(c.Files))
+		for _, f := range c.Files {
+			files[f.Path] = f.Reference.String()
+		}
+		runResult.Data = files
+	}
+	logger.Info("reference computed", "tar", tarFile, "reference", c.Root.String(), "files", len(c.Files))
+	if optionJSON {
+		return nil
+	}
+	if optionPrintFiles {
+		for _, f := range c.Files {
+			fmt.Fprintf(stdout, "%s %s\n", f.Reference, f.Path)
+		}
+	}
+	fmt.Fprintf(stdout, "\nReference: %s\n", c.Root)
+	return nil
+}
+
+// uploadVolumesCmd uploads the volumes of the directory and prints the
+// root serving them.
+func uploadVolumesCmd(ctx context.Context, name string) error {
+	// the volumes are locked as the tar of the same wiki
+	tarFile := strings.TrimSuffix(filepath.Base(name), workdir.VolumesSuffix) + ".tar"
+	unlock, err := lockWiki(ctx, tarFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	addr, err := uploadVolumes(ctx, optionDataDir, name, tarFile, optionBeeBatchID)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "\nTry the link: %s\n", makeURL(addr.String()))
+	return nil
+}
+
+// uploadVolumes uploads the volumes of the directory, signing the root
+// serving them as the mirror of the tar.
+func uploadVolumes(ctx context.Context, dataDir string, name string, tarFile string, batchID string) (swarm.Address, error) {
+	dir := artifactPath(name)
+	start := time.Now()
+	defer resultFrom(ctx).Stage("upload", start)
+	updateRun(ctx, func(r *store.Run) {
+		r.Stage = "upload"
+		r.TarFile = filepath.Base(dir)
+		r.BatchID = batchID
+	})
+
+	v, err := stages().UploadVolumes(ctx, dir, api.UploadCollectionOptions{
+		Tag:                 optionBeeTag,
+		Pin:                 optionBeePin,
+		BatchID:             batchID,
+		IndexDocumentHeader: "index.html",
+		ErrorDocumentHeader: "error.html",
+	})
+	if err != nil {
+		return swarm.Address{}, err
+	}
+	addr, err := swarm.ParseHexAddress(v.Root)
+	if err != nil {
+		return swarm.Address{}, err
+	}
+	res := resultFrom(ctx)
+	res.TarFile = filepath.Base(dir)
+	res.Reference = v.Root
+	res.BatchID = batchID
+	updateRun(ctx, func(r *store.Run) {
+		r.Reference = v.Root
+	})
+	logger.Info("volumes uploaded", "volumes", len(v.Volumes), "reference", v.Root)
+
+	if err := signMirror(ctx, dataDir, tarFile, addr, batchID); err != nil {
+		return swarm.Address{}, err
+	}
 	if optionClean {
 		cleanDatadir()
 	}
@@ -98,9 +260,12 @@ func newUploadAllCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
+			refs := make(map[string]string, len(addrs))
 			for name, addr := range addrs {
-				log.Printf("collection %v uploaded with reference: %v", name, addr)
+				logger.Info("collection uploaded", "tar", name, "reference", addr.String())
+				refs[name] = addr.String()
 			}
+			runResult.Data = refs
 			return nil
 		},
 	}
@@ -111,15 +276,30 @@ func uploadAllFrom(ctx context.Context, dataDir string, kiwixMirror string, batc
 		return strings.Contains(filename, kiwixMirror)
 	}
 
-	addrs, err := uploadMatchTar(ctx, dataDir, filter, api.UploadCollectionOptions{
-		Tag:                 optionBeeTag,
-		Pin:                 optionBeePin,
-		BatchID:             batchID,
-		IndexDocumentHeader: "index.html",
-		ErrorDocumentHeader: "error.html",
-	})
-	if err != nil {
-		return nil, err
+	// the workdir is searched too when it is not inside the datadir
+	dirs := []string{dataDir}
+	if rel, err := filepath.Rel(dataDir, work.Dir); err != nil || strings.HasPrefix(rel, "..") {
+		dirs = append(dirs, work.Dir)
+	}
+
+	addrs := make(map[string]swarm.Address)
+	for _, dir := range dirs {
+		found, err := uploadMatchTar(ctx, dir, filter, api.UploadCollectionOptions{
+			Tag:                 optionBeeTag,
+			Pin:                 optionBeePin,
+			BatchID:             batchID,
+			IndexDocumentHeader: "index.html",
+			ErrorDocumentHeader: "error.html",
+		})
+		if err != nil {
+			return nil, err
+		}
+		for name, addr := range found {
+			addrs[name] = addr
+		}
+	}
+	if len(addrs) == 0 {
+		logger.Info("no tar files found for the given filter")
 	}
 
 	if optionClean {
@@ -136,7 +316,16 @@ func uploadMatchTar(ctx context.Context, targetDir string, filter func(x string)
 		}
 
 		if !info.IsDir() && filepath.Ext(info.Name()) == ".tar" && filter(info.Name()) {
+			unlock, err := lockWiki(ctx, info.Name())
+			if errors.Is(err, errSkipped) {
+				logger.Warn("skipping tar", "tar", info.Name(), "err", err)
+				return nil
+			}
+			if err != nil {
+				return err
+			}
 			addr, err := uploadTarFile(ctx, path, info.Name(), opts)
+			unlock()
 			if err != nil {
 				return err
 			}
@@ -147,20 +336,97 @@ func uploadMatchTar(ctx context.Context, targetDir string, filter func(x string)
 	if err != nil {
 		return nil, err
 	}
-	if len(files) == 0 {
-		log.Println("no tar files found for the given filter")
-	}
 	return files, nil
 }
 
+// uploadedRoots are the roots uploaded by the runs of the process, by
+// batch, whose assets --share-assets shares before the runs are recorded
+// completed in the local database.
+var (
+	uploadedRootsMu sync.Mutex
+	uploadedRoots   = make(map[string][]swarm.Address)
+)
+
+// sharedRoots returns the roots whose assets the upload with the batch
+// shares, those of the process first.
+func sharedRoots(batchID string) []swarm.Address {
+	uploadedRootsMu.Lock()
+	roots := slices.Clone(uploadedRoots[batchID])
+	uploadedRootsMu.Unlock()
+	slices.Reverse(roots)
+	for _, r := range stages().SharedRoots(batchID) {
+		if !slices.ContainsFunc(roots, r.Equal) {
+			roots = append(roots, r)
+		}
+	}
+	return roots
+}
+
 func uploadTarFile(ctx context.Context, path string, name string, opts api.UploadCollectionOptions) (swarm.Address, error) {
-	buf, err := tarball.ReadTarBuffer(path)
+	tarFile, shared, err := uploadCollection(ctx, path, name, opts)
 	if err != nil {
 		return swarm.Address{}, err
 	}
-	tarFile := tarball.NewBufferFile(name, buf)
-	if err := bee.UploadCollection(ctx, tarFile, opts); err != nil {
-		return swarm.Address{}, err
+	if optionShareAssets {
+		uploadedRootsMu.Lock()
+		uploadedRoots[opts.BatchID] = append(uploadedRoots[opts.BatchID], tarFile.Address())
+		uploadedRootsMu.Unlock()
+	}
+
+	res := resultFrom(ctx)
+	res.TarFile = name
+	res.TarHash = hex.EncodeToString(tarFile.Hash())
+	res.Reference = tarFile.Address().String()
+	res.BatchID = opts.BatchID
+	updateRun(ctx, func(r *store.Run) {
+		r.Reference = tarFile.Address().String()
+	})
+	if res.Stats != nil {
+		res.Stats.TarSize = tarFile.Size()
+	}
+	if shared.Files > 0 {
+		logger.Info("assets shared with other uploads", "tar", name, "files", shared.Files, "bytes", shared.Saved)
+		if res.Stats == nil {
+			res.Stats = &result.Stats{}
+		}
+		res.Stats.SharedAssets, res.Stats.SharedSaved = shared.Files, shared.Saved
 	}
 	return tarFile.Address(), nil
 }
+
+// uploadCollection uploads the tar as a collection, its assets shared
+// with the other uploads of the batch with --share-assets.
+func uploadCollection(ctx context.Context, path string, name string, opts api.UploadCollectionOptions) (*tarball.File, mirrorpkg.SharedStats, error) {
+	l := limitsFrom(ctx)
+	if l == nil {
+		return uploadShared(ctx, path, name, opts)
+	}
+
+	// the bytes being sent to the node
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, mirrorpkg.SharedStats{}, err
+	}
+	n, err := l.UploadBytes.Acquire(ctx, info.Size())
+	if err != nil {
+		return nil, mirrorpkg.SharedStats{}, err
+	}
+	defer l.UploadBytes.Release(n)
+
+	done, err := l.Uploads.Acquire(ctx)
+	if err != nil {
+		return nil, mirrorpkg.SharedStats{}, err
+	}
+	f, shared, err := uploadShared(ctx, path, name, opts)
+	done(info.Size(), err)
+	return f, shared, err
+}
+
+// uploadShared uploads the tar, sharing its assets with --share-assets.
+func uploadShared(ctx context.Context, path string, name string, opts api.UploadCollectionOptions) (*tarball.File, mirrorpkg.SharedStats, error) {
+	if !optionShareAssets {
+		f, err := stages().Upload(ctx, path, name, opts)
+		return f, mirrorpkg.SharedStats{}, err
+	}
+	return stages().UploadShared(ctx, path, name, opts, sharedRoots(opts.BatchID))
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/internal/result"
+	"github.com/r0qs/beezim/internal/version"
+
+	"github.com/spf13/cobra"
+)
+
+func newVersionCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Print the version of beezim",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			v := version.Get()
+			if optionJSON {
+				runResult.Data = v
+				return nil
+			}
+			fmt.Fprintf(stdout, "beezim %s\n", v)
+			if v.RevisionTime != "" {
+				fmt.Fprintf(stdout, "  commit time: %s\n", v.RevisionTime)
+			}
+			return nil
+		},
+	}
+}
+
+// tarProvenance reads the provenance of the tar and adds it to the
+// result, returning false when the tar has none.
+func tarProvenance(res *result.Result, tarPath string) (indexer.Provenance, bool) {
+	p, err := indexer.ReadProvenance(tarPath)
+	if err != nil {
+		if !errors.Is(err, indexer.ErrNoProvenance) {
+			logger.Warn("error reading the provenance of the tar", "tar", tarPath, "err", err)
+		}
+		return indexer.Provenance{}, false
+	}
+	recordProvenance(res, p)
+	return p, true
+}
+
+// recordProvenance adds the provenance to the result.
+func recordProvenance(res *result.Result, p indexer.Provenance) {
+	if res == nil {
+		return
+	}
+	if data, err := json.Marshal(p); err == nil {
+		res.Provenance = data
+	}
+}
+
+// printProvenance prints the provenance of a mirror.
+func printProvenance(p indexer.Provenance) {
+	fmt.Fprintf(stdout, "Built by beezim %s\n", p.Beezim)
+	fmt.Fprintf(stdout, "  zim:        %s\n", p.ZimFile)
+	if p.ZimSHA256 != "" {
+		fmt.Fprintf(stdout, "  sha256:     %s\n", p.ZimSHA256)
+	}
+	if p.Options != "" {
+		fmt.Fprintf(stdout, "  options:    %s\n", p.Options)
+	}
+	pinned := ""
+	if p.Reproducible {
+		pinned = " (pinned)"
+	}
+	fmt.Fprintf(stdout, "  created at: %s%s\n", p.CreatedAt.Format(time.RFC3339), pinned)
+}
@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/r0qs/beezim/internal/perf"
+	"github.com/r0qs/beezim/internal/result"
+	"github.com/r0qs/beezim/internal/store"
+	"github.com/r0qs/beezim/internal/watch"
+
+	"github.com/spf13/cobra"
+)
+
+const watchStatusFile = "watch-status.json"
+
+func newWatchCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Periodically mirror new releases of the configured wikis",
+		Long: "\nChecks the Kiwix catalog (or a local directory with --watch-dir) for new releases of the given wikis and mirrors them." +
+			"\nWikis are name patterns as accepted by catalog --name. If --wiki is not given, the match patterns of the config file are used.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wikis := optionWatchWikis
+			if len(wikis) == 0 && loadedConfig != nil {
+				for _, w := range loadedConfig.Wikis {
+					wikis = append(wikis, w.Match)
+				}
+			}
+			if len(wikis) == 0 {
+				return fmt.Errorf("no wikis to watch, use --wiki or add wikis to the config file")
+			}
+
+			var err error
+			db, err = store.Open(filepath.Join(optionDataDir, dbFile))
+			if err != nil {
+				return err
+			}
+
+			mirrored, err := recoverWatchRuns(cmd.CommandPath())
+			if err != nil {
+				return err
+			}
+
+			statusFile := optionWatchStatusFile
+			if statusFile == "" {
+				statusFile = filepath.Join(optionDataDir, watchStatusFile)
+			}
+
+			w := watch.New(wikis, watchSource(), watchMirror(cmd), watch.Options{
+				Interval:   optionWatchInterval,
+				Jitter:     optionWatchJitter,
+				Seed:       optionSeed,
+				Mirrored:   mirrored,
+				StatusFile: statusFile,
+				Logger:     logger,
+			})
+
+			if optionWatchStatusAddr != "" {
+				mux := http.NewServeMux()
+				mux.Handle("/status", w.Handler())
+				srv := &http.Server{Addr: optionWatchStatusAddr, Handler: mux}
+				go func() {
+					logger.Info("serving watch status", "url", fmt.Sprintf("http://%s/status", optionWatchStatusAddr))
+					if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+						logger.Error("error serving watch status", "err", err)
+					}
+				}()
+				defer srv.Close()
+			}
+
+			if optionWatchBatches {
+				go watchBatches(cmd.Context())
+			}
+
+			logger.Info("watching wikis", "wikis", len(wikis), "interval", optionWatchInterval, "seed", optionSeed)
+			err = w.Run(cmd.Context())
+			runResult.Data = w.Status()
+			if wasInterrupted() {
+				return nil
+			}
+			return err
+		},
+	}
+	cmd.Flags().StringSliceVar(&optionWatchWikis, optionNameWatchWikis, nil, "name pattern of a wiki to watch (can be repeated)")
+	cmd.Flags().DurationVar(&optionWatchInterval, optionNameWatchInterval, 24*time.Hour, "time between two checks of the same wiki")
+	cmd.Flags().DurationVar(&optionWatchJitter, optionNameWatchJitter, 10*time.Minute, "maximum random delay added to each check")
+	cmd.Flags().StringVar(&optionWatchDir, optionNameWatchDir, "", "look for new zim files in this directory instead of the Kiwix catalog")
+	cmd.Flags().StringVar(&optionWatchStatusFile, optionNameWatchStatusFile, "", fmt.Sprintf("file where the status of each wiki is written (default \"<datadir>/%s\")", watchStatusFile))
+	cmd.Flags().StringVar(&optionWatchStatusAddr, optionNameWatchStatusAddr, "", "address of the HTTP status endpoint (disabled by default)")
+	addCatalogFlags(cmd)
+	addKeepFlags(cmd)
+	addHistoryFlag(cmd)
+	addSplitSearchFlag(cmd)
+	addParseBatchFlags(cmd)
+	addDownloadFlags(cmd)
+	addNotifyFlags(cmd)
+	addSignFlags(cmd)
+	cmd.Flags().BoolVar(&optionWatchBatches, optionNameWatchBatches, false, "top up the batches of the published mirrors on every interval, see batch topup")
+	addBatchFlags(cmd)
+
+	return cmd
+}
+
+func watchSource() watch.Source {
+	if optionWatchDir != "" {
+		return watch.DirSource{Dir: optionWatchDir}
+	}
+	return watch.CatalogSource{
+		Entries: catalogCache().Entries,
+		Filter:  catalogFilter(""),
+	}
+}
+
+// watchMirror returns the function mirroring the releases found by the
+// watcher. Runs are serialized since the per-wiki configuration is
+// applied to the shared options.
+func watchMirror(cmd *cobra.Command) watch.MirrorFunc {
+	var mu sync.Mutex
+	return func(ctx context.Context, r watch.Release) (string, string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err := applyWikiConfig(cmd, r.FileName); err != nil {
+			return "", "", err
+		}
+
+		// every release is recorded and notified with its own run and result
+		p := pipeline{
+			run: &store.Run{
+				ID:        store.NewRunID(),
+				Command:   cmd.CommandPath(),
+				Wiki:      r.Wiki,
+				ZimFile:   r.FileName,
+				Status:    store.StatusRunning,
+				BatchID:   optionBeeBatchID,
+				StartedAt: time.Now().UTC(),
+			},
+			result: newResult(cmd),
+			perf:   perf.Start(),
+		}
+		if err := db.PutRun(*p.run); err != nil {
+			return "", "", err
+		}
+
+		zimFile, zimURL := "", r.URL
+		if optionWatchDir != "" {
+			if err := linkZim(r.URL); err != nil {
+				return p.run.ID, "", p.finish(err)
+			}
+			zimFile, zimURL = r.FileName, ""
+		}
+
+		addr, err := mirror(withPipeline(ctx, p), zimFile, zimURL)
+		if err := p.finish(err); err != nil {
+			return p.run.ID, "", err
+		}
+		// TODO: update the wiki feed and remove old releases once supported
+		return p.run.ID, addr.String(), nil
+	}
+}
+
+// watchBatches runs the batch maintenance on every interval until the
+// context is canceled.
+func watchBatches(ctx context.Context) {
+	for {
+		res := result.New("beezim batch topup")
+		if _, err := maintainBatches(ctx, res); err != nil {
+			logger.Error("error maintaining batches", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(optionWatchInterval):
+		}
+	}
+}
+
+// linkZim makes a zim found in the watched directory available in the datadir.
+func linkZim(zimPath string) error {
+	dst := filepath.Join(optionDataDir, filepath.Base(zimPath))
+	if _, err := os.Lstat(dst); err == nil {
+		return nil
+	}
+	src, err := filepath.Abs(zimPath)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(src, dst)
+}
+
+// recoverWatchRuns marks the runs left running by a previous watch process
+// as interrupted and returns the last release mirrored for each wiki. The
+// releases of interrupted runs are mirrored again, resuming from the
+// preserved artifacts.
+func recoverWatchRuns(command string) (map[string]string, error) {
+	mirrored := make(map[string]string)
+	for _, r := range db.Runs() {
+		if r.Command != command || r.Wiki == "" {
+			continue
+		}
+		switch r.Status {
+		case store.StatusCompleted:
+			mirrored[r.Wiki] = r.ZimFile
+		case store.StatusRunning:
+			// the run may go on in another process
+			if wikiLocked(r.ZimFile) {
+				continue
+			}
+			logger.Info("run did not finish, it will be resumed", "run", r.ID, "zim", r.ZimFile)
+			r.Status = store.StatusInterrupted
+			r.Error = "watch stopped before the run finished"
+			r.Resume = preservedArtifacts(r)
+			if err := db.PutRun(r); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return mirrored, nil
+}
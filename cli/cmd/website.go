@@ -36,6 +36,14 @@ var listWebCmd = &cobra.Command{
 	Short: "Shows the list of compressed websites currently maintained by Kiwix",
 	Args:  cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
+		if optionJSON {
+			sites := make(map[string]string, len(zims))
+			for _, site := range zims {
+				sites[site] = websitePath(site)
+			}
+			runResult.Data = sites
+			return
+		}
 		printWebsiteList()
 	},
 }
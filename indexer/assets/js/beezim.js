@@ -28,18 +28,88 @@ function asyncFetch(method, url, opts = {}) {
 	});
 }
 
+// searchIndexURL returns the url of the search index: in the mirror itself,
+// or in the separate collection referenced by search.json when the index
+// was uploaded on its own.
+async function searchIndexURL(indexPath) {
+	try {
+		const pointer = JSON.parse(await asyncFetch("GET", "search.json"));
+		return bzzURL(pointer.root) + pointer.path;
+	} catch (err) {
+		return "./" + indexPath;
+	}
+}
+
+// pathURL returns the relative url of the file of the mirror at path, a
+// path of the ZIM, its elements escaped, e.g. for "A/C# (language)".
+function pathURL(path) {
+	return path.split("/").map(encodeURIComponent).join("/");
+}
+
+// pageURL returns the url of the search page showing the file at path.
+function pageURL(path) {
+	return "index.html?s=" + encodeURIComponent(path);
+}
+
+// bzzURL returns the url of a swarm root served by the same node or gateway
+// as the current page, keeping the path prefix of the gateway, if any.
+function bzzURL(root) {
+	const path = window.location.pathname;
+	const i = path.indexOf("/bzz/");
+	const prefix = i >= 0 ? path.substring(0, i) : "";
+	return window.location.origin + prefix + "/bzz/" + root + "/";
+}
+
+// normalizeTitle returns the title as the title search compares it: in
+// lower case, without diacritics, as NormalizeTitle of the indexer.
+function normalizeTitle(title) {
+	return title.normalize("NFD").replace(/\p{Mn}/gu, "").toLowerCase();
+}
+
+// editDistance returns the number of characters to insert, delete or
+// replace in a for b.
+function editDistance(a, b) {
+	let previous = Array.from({length: b.length + 1}, (_, j) => j);
+	for (let i = 1; i <= a.length; i++) {
+		const current = [i];
+		for (let j = 1; j <= b.length; j++) {
+			const replace = previous[j - 1] + (a[i - 1] == b[j - 1] ? 0 : 1);
+			current.push(Math.min(previous[j] + 1, current[j - 1] + 1, replace));
+		}
+		previous = current;
+	}
+	return previous[b.length];
+}
+
+const searchShardsDir = "_beezim/search/";
+const titlesFile = "_beezim/titles.json";
+
+// escapeHTML returns the text escaped for the HTML of a page.
+function escapeHTML(text) {
+	return text.replace(/[&<>"']/g, (c) => "&#" + c.charCodeAt(0) + ";");
+}
+
 class BeeZIMSearcher {
-	#articles = [];
+	// #titles are the titles of the title search when they are not
+	// sharded, those of the titles file of the ZIM or else of the index of
+	// the shards, and #shards the shards fetched by #fetchShard otherwise.
+	#titles = null;
+	#shards = [];
+	#fetched = new Map();
 	#initRan = false;
 	#indexURL;
 	#xapian;
 	static searcherReady = [];
 	static #beeZim;
 
+	// constructor returns a searcher of the full text index at xapianPath,
+	// or of the titles only without one, e.g. for the error page.
 	constructor(indexURL, xapianPath) {
 		this.#indexURL = indexURL;
-		this.#xapian = new XapianAPI();
-		this.#xapian.initXapianIndexReadOnly(xapianPath);
+		if (xapianPath) {
+			this.#xapian = new XapianAPI();
+			this.#xapian.initXapianIndexReadOnly(xapianPath);
+		}
 		this.#initRan = true;
 	}
 
@@ -89,26 +159,129 @@ class BeeZIMSearcher {
 	}
 	
 	async LoadFiles() {
-		if (this.#articles.length == 0) {
-			const files = await asyncFetch("GET", "files.json")
-			this.#parseFiles(files);
+		if (this.#titles == null && this.#shards.length == 0) {
+			const index = JSON.parse(await asyncFetch("GET", searchShardsDir + "index.json"));
+			this.#shards = index.shards || [];
+			if (this.#shards.length == 0) {
+				const inlined = index.titles || [];
+				this.#titles = await this.#fetchTitles().catch(() => inlined);
+				if (this.#titles != inlined) {
+					// the titles file has no snippets, those of the index do
+					const snippets = new Map(inlined.filter((t) => t.snippet).map((t) => [t.path, t.snippet]));
+					this.#titles.forEach((t) => t.snippet = snippets.get(t.path));
+				}
+			}
 		}
 	}
 
-	#parseFiles(filesResponse) {
-		let files = JSON.parse(filesResponse);
-		for (const [key, value] of Object.entries(files)) {
-			if (key.startsWith("A/")) {
-				this.#articles.push(value);
+	// #fetchTitles returns the titles of the titles file, pairs of title
+	// and path in the title order of the ZIM.
+	async #fetchTitles() {
+		const titles = JSON.parse(await asyncFetch("GET", titlesFile));
+		return titles.map(([title, path]) => ({title: title, path: path}));
+	}
+
+	#fetchShard(shard) {
+		if (!this.#fetched.has(shard.file)) {
+			const titles = asyncFetch("GET", searchShardsDir + shard.file).then(JSON.parse);
+			titles.catch(() => this.#fetched.delete(shard.file));
+			this.#fetched.set(shard.file, titles);
+		}
+		return this.#fetched.get(shard.file);
+	}
+
+	// #shardsOf returns the shards that may hold the titles starting with
+	// the normalized query: the shard of its longest prefix, then those of
+	// the longer prefixes starting with it.
+	#shardsOf(query) {
+		let longest = null;
+		let shards = [];
+		for (const shard of this.#shards) {
+			if (query.startsWith(shard.prefix)) {
+				if (!longest || shard.prefix.length > longest.prefix.length) {
+					longest = shard;
+				}
+			} else if (shard.prefix.startsWith(query)) {
+				shards.push(shard);
 			}
 		}
+		return longest ? [longest].concat(shards) : shards;
 	}
 
-	GetRandomArticle() {
+	// #titleMatches returns at most max titles containing the query, or
+	// starting with it when the titles are sharded, fetching only the
+	// shards needed.
+	async #titleMatches(query, max) {
+		const normalized = normalizeTitle(query);
+		let matches = [];
+		if (this.#titles) {
+			for (let i = 0; i < this.#titles.length && matches.length < max; i++) {
+				if (normalizeTitle(this.#titles[i].title).indexOf(normalized) > -1) {
+					matches.push(this.#titles[i]);
+				}
+			}
+			return matches;
+		}
+		for (const shard of this.#shardsOf(normalized)) {
+			const titles = await this.#fetchShard(shard);
+			for (let i = 0; i < titles.length && matches.length < max; i++) {
+				if (normalizeTitle(titles[i].title).startsWith(normalized)) {
+					matches.push(titles[i]);
+				}
+			}
+			if (matches.length >= max) {
+				break;
+			}
+		}
+		return matches;
+	}
+
+	// TitleMatches returns at most max titles of the title search matching
+	// the query, as the suggestions of the search box.
+	TitleMatches(query, max) {
+		return this.#titleMatches(query, max);
+	}
+
+	// ClosestTitles returns at most max titles closest to the query, e.g.
+	// the name of a missing page: those matching its longest prefix that
+	// any title matches, the closest first. The prefixes are of two
+	// characters at least, not to fetch most of the shards.
+	async ClosestTitles(query, max) {
+		const normalized = normalizeTitle(query);
+		for (let n = normalized.length; n > 0 && n >= Math.min(2, normalized.length); n = Math.min(n - 1, Math.ceil(n * 3 / 4))) {
+			const matches = await this.#titleMatches(normalized.substring(0, n), max * 10);
+			if (matches.length > 0) {
+				const distance = new Map(matches.map((t) => [t, editDistance(normalizeTitle(t.title), normalized)]));
+				matches.sort((a, b) => distance.get(a) - distance.get(b));
+				return matches.slice(0, max);
+			}
+		}
+		return [];
+	}
+
+	// Snippet returns the snippet of the article of the title search with
+	// the title and path, empty without one.
+	async Snippet(title, path) {
+		let titles = this.#titles;
+		if (!titles) {
+			titles = [];
+			for (const shard of this.#shardsOf(normalizeTitle(title))) {
+				titles = titles.concat(await this.#fetchShard(shard));
+			}
+		}
+		const found = titles.find((t) => t.path == path);
+		return (found && found.snippet) || "";
+	}
+
+	async GetRandomArticle() {
 		if (!this.#initRan) {
 			return "You need to run 'Init()' before searching!";
 		}
-		return this.#articles[this.#articles.length * Math.random() << 0];
+		let titles = this.#titles;
+		if (!titles) {
+			titles = await this.#fetchShard(this.#shards[this.#shards.length * Math.random() << 0]);
+		}
+		return titles[titles.length * Math.random() << 0];
 	}
 
 	IndexSearch(query, offset=0, maxResults=1000) {
@@ -120,6 +293,12 @@ class BeeZIMSearcher {
 			return "You need to run 'Init()' before searching!";
 		}
 
+		// without the full text index, the titles matching the query
+		if (!this.#xapian) {
+			return this.#titleMatches(query, offset + maxResults).then((matches) =>
+				matches.slice(offset).map((t) => ({ title: t.title, data: t.path })));
+		}
+
 		let results = [];
 
 		this.#xapian.queryXapianIndex(query, offset, maxResults).forEach((r) => {
@@ -134,7 +313,7 @@ class BeeZIMSearcher {
 		return results;
 	}
 
-	QuickSearch(query, 	maxResults = 20, titleMatches = 3) {
+	async QuickSearch(query, maxResults = 20, titleMatches = 3) {
 		if (!query) {
 			return [];
 		}
@@ -144,30 +323,28 @@ class BeeZIMSearcher {
 		}
 
 		let results = [];
-		let queryLower = query.toLowerCase();
 
-		this.#xapian.queryXapianIndex(query, 0, maxResults-titleMatches).forEach((r) => {
-			results.push({
-				docid: r.docid,
-				data: r.data,
-				wordcount: parseInt(this.#xapian.getStringValue(r.docid, 1)),
-				title: this.#xapian.getStringValue(r.docid, 0)
+		if (this.#xapian) {
+			this.#xapian.queryXapianIndex(query, 0, maxResults-titleMatches).forEach((r) => {
+				results.push({
+					docid: r.docid,
+					data: r.data,
+					wordcount: parseInt(this.#xapian.getStringValue(r.docid, 1)),
+					title: this.#xapian.getStringValue(r.docid, 0)
+				});
 			});
-		});
+		}
 
 		let wantedTitleMatch = maxResults - results.length;
 		let titleResults = [];
-		for (let i = 0; i < this.#articles.length; i++) {
-			if (wantedTitleMatch <= 0)
-				break;
-			let value = this.#articles[i];
-			if (value.Metadata.Title.toLowerCase().indexOf(queryLower) > -1) {
+		if (wantedTitleMatch > 0) {
+			for (const value of await this.#titleMatches(query, wantedTitleMatch)) {
 				titleResults.push({
 					query: query,
-					title: value.Metadata.Title,
-					data: value.Path
+					title: value.title,
+					data: value.path,
+					snippet: value.snippet
 				});
-				wantedTitleMatch--;
 			}
 		}
 		titleResults.sort(function (a, b) {
@@ -0,0 +1,98 @@
+package indexer_test
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// linkAttr matches the links of the attributes of the HTML elements, and
+// scriptBody the inline scripts, which build links of their own.
+var (
+	linkAttr   = regexp.MustCompile(`\s(?:href|src)="([^"]*)"`)
+	scriptBody = regexp.MustCompile(`(?s)(<script[^>]*>).*?(</script>)`)
+)
+
+// localLinks returns the files of the tar the links of the page point to,
+// leaving out those to other sites and to the page itself.
+func localLinks(t *testing.T, name string, page []byte) []string {
+	t.Helper()
+	var files []string
+	page = scriptBody.ReplaceAll(page, []byte("$1$2"))
+	for _, m := range linkAttr.FindAllSubmatch(page, -1) {
+		u, err := url.Parse(string(m[1]))
+		if err != nil {
+			t.Errorf("%s: link %q: %v", name, m[1], err)
+			continue
+		}
+		if u.Scheme != "" || u.Host != "" || u.Path == "" {
+			continue
+		}
+		files = append(files, path.Join(path.Dir(name), u.Path))
+	}
+	return files
+}
+
+// TestAssetsReferenced writes the pages of a ZIM with the search enabled,
+// a theme and the navigation bar, and checks that every file linked by the
+// HTML pages of the tar is in it, and that the unzim output gets the same
+// assets.
+func TestAssetsReferenced(t *testing.T) {
+	ctx := context.Background()
+	r := groupsZim()
+	// the navigation bar and the theme are linked from the head of the
+	// articles
+	r.Entries[0].Content = []byte("<html><head><title>Zebra</title></head><body><p>Zebra</p></body></html>")
+	idx := indexer.NewWithReader("test.zim", r, true)
+	idx.Logger = logging.Discard()
+	idx.NavBar = true
+	defer idx.Close()
+	if err := idx.SetTheme("dark"); err != nil {
+		t.Fatal(err)
+	}
+	tarFile := tarZim(t, idx)
+	if err := idx.WritePages(indexer.AppendTo(tarFile)); err != nil {
+		t.Fatal(err)
+	}
+	files := readTar(t, tarFile)
+
+	pages := 0
+	for name, data := range files {
+		if !strings.HasSuffix(name, ".html") && !strings.HasPrefix(name, "A/") {
+			continue
+		}
+		pages++
+		for _, f := range localLinks(t, name, data) {
+			if _, ok := files[f]; !ok {
+				t.Errorf("%s links to %s, not in the tar", name, f)
+			}
+		}
+	}
+	if pages == 0 || files[indexer.AssetsPrefix+"/css/beezim.css"] == nil {
+		t.Fatalf("no page or assets in the tar: %d pages", pages)
+	}
+
+	outputDir := t.TempDir()
+	if err := idx.UnZim(ctx, outputDir, idx.ParseZIM(ctx)); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if !strings.HasPrefix(name, indexer.AssetsPrefix+"/") {
+			continue
+		}
+		got, err := os.ReadFile(filepath.Join(outputDir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("asset of the tar not extracted: %v", err)
+		} else if string(got) != string(data) {
+			t.Errorf("%s: extracted %d bytes, %d in the tar", name, len(got), len(data))
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package indexer
+
+import "context"
+
+// DefaultBatchSize is the number of articles sent together by
+// ParseZIMBatches when BatchSize is not set.
+const DefaultBatchSize = 64
+
+// DefaultBatchBuffer is the number of batches parsed ahead of the sink
+// when BatchBuffer is not set. The parser holds up to BatchSize times
+// BatchBuffer+1 payloads in memory besides the batch being written.
+const DefaultBatchBuffer = 4
+
+// batcher groups the parsed articles in batches sent to out.
+type batcher struct {
+	ctx   context.Context
+	out   chan<- []Article
+	size  int
+	batch []Article
+}
+
+func newBatcher(ctx context.Context, out chan<- []Article, size int) *batcher {
+	return &batcher{ctx: ctx, out: out, size: size, batch: make([]Article, 0, size)}
+}
+
+// add adds the article to the batch, sending the batch when it is full.
+// It reports false when the context is canceled, the article is then
+// released.
+func (b *batcher) add(a Article) bool {
+	b.batch = append(b.batch, a)
+	if len(b.batch) < b.size {
+		return true
+	}
+	return b.flush()
+}
+
+// flush sends the pending articles, releasing them when the context is
+// canceled.
+func (b *batcher) flush() bool {
+	if len(b.batch) == 0 {
+		return true
+	}
+	select {
+	case b.out <- b.batch:
+		b.batch = make([]Article, 0, b.size)
+		return true
+	case <-b.ctx.Done():
+		releaseAll(b.batch)
+		b.batch = b.batch[:0]
+		return false
+	}
+}
+
+// abortParse stops the parse of the indexer and releases the articles of
+// batches until it is closed, so that a sink failing does not leave the
+// parser blocked on a send while holding the ZIM. The batches must come
+// from a parse, or from a producer closing them.
+func (idx *SwarmZimIndexer) abortParse(batches <-chan []Article) {
+	if stop := idx.parseStop(batches); stop != nil {
+		stop()
+	}
+	for batch := range batches {
+		releaseAll(batch)
+	}
+}
+
+// trackParse records stop as the cancel of the parse sending to ch, until
+// the returned untrack is called when the parse ends. Keyed by channel,
+// a sink failing stops its own parse, not another one of the indexer.
+func (idx *SwarmZimIndexer) trackParse(ch any, stop context.CancelFunc) (untrack func()) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.parses == nil {
+		idx.parses = make(map[any]context.CancelFunc)
+	}
+	idx.parses[ch] = stop
+	return func() {
+		idx.mu.Lock()
+		defer idx.mu.Unlock()
+		delete(idx.parses, ch)
+	}
+}
+
+// parseStop returns the cancel of the parse sending to ch, nil when ch
+// does not come from a running parse.
+func (idx *SwarmZimIndexer) parseStop(ch any) context.CancelFunc {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.parses[ch]
+}
+
+// releaseAll releases the articles.
+func releaseAll(articles []Article) {
+	for i := range articles {
+		articles[i].Release()
+	}
+}
+
+// ParseZIM sends the articles of the ZIM to the returned channel one at a
+// time, which is closed when all articles were sent or the context is
+// canceled. It is an adapter of ParseZIMBatches.
+func (idx *SwarmZimIndexer) ParseZIM(ctx context.Context) chan Article {
+	zimArticles := make(chan Article)
+	batches := idx.ParseZIMBatches(ctx)
+	untrack := func() {}
+	if stop := idx.parseStop(batches); stop != nil {
+		untrack = idx.trackParse((<-chan Article)(zimArticles), stop)
+	}
+	go func() {
+		defer close(zimArticles)
+		defer untrack()
+		for batch := range batches {
+			for i := range batch {
+				if ctx.Err() != nil {
+					releaseAll(batch[i:])
+					break
+				}
+				select {
+				case zimArticles <- batch[i]:
+				case <-ctx.Done():
+					releaseAll(batch[i:])
+				}
+			}
+		}
+	}()
+	return zimArticles
+}
+
+// batchesOf sends the articles received to the returned channel in
+// batches of one, for the sinks of batches. Aborting the batches stops
+// the parse sending the articles, when they come from ParseZIM.
+func (idx *SwarmZimIndexer) batchesOf(ctx context.Context, files <-chan Article) <-chan []Article {
+	batches := make(chan []Article)
+	untrack := func() {}
+	if stop := idx.parseStop(files); stop != nil {
+		untrack = idx.trackParse((<-chan []Article)(batches), stop)
+	}
+	go func() {
+		defer close(batches)
+		defer untrack()
+		for a := range files {
+			select {
+			case batches <- []Article{a}:
+			case <-ctx.Done():
+				a.Release()
+			}
+		}
+	}()
+	return batches
+}
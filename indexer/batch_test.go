@@ -0,0 +1,162 @@
+package indexer_test
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/indexer/zimtest"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// articlesZim returns a reader of n articles, more than a parse sends
+// ahead of a sink with batches of one.
+func articlesZim(n int) *zimtest.Reader {
+	entries := make([]zimtest.Entry, n)
+	for i := range entries {
+		url := fmt.Sprintf("Article%d", i)
+		entries[i] = zimtest.Entry{Namespace: 'A', URL: url, Title: url, Mime: "text/html", Content: []byte("<p>" + url + "</p>")}
+	}
+	return zimtest.New(entries...)
+}
+
+// settled waits for the goroutines to come back to at most want, failing
+// the test when some are left.
+func settled(t *testing.T, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > want {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("%d goroutines left, want %d:\n%s", runtime.NumGoroutine(), want, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSinkFailureStopsParse(t *testing.T) {
+	for name, sink := range map[string]func(ctx context.Context, idx *indexer.SwarmZimIndexer) error{
+		// the tar can not be created, a directory being there
+		"tar": func(ctx context.Context, idx *indexer.SwarmZimIndexer) error {
+			return idx.TarZim(ctx, t.TempDir(), idx.ParseZIM(ctx))
+		},
+		"tar batches": func(ctx context.Context, idx *indexer.SwarmZimIndexer) error {
+			return idx.TarZimBatches(ctx, t.TempDir(), idx.ParseZIMBatches(ctx))
+		},
+		// the reader of the stream goes away after the first article
+		"stream": func(ctx context.Context, idx *indexer.SwarmZimIndexer) error {
+			r := idx.TarStream(ctx, idx.ParseZIM(ctx))
+			_, err := tar.NewReader(r).Next()
+			if err != nil {
+				return err
+			}
+			return r.Close()
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			idx := newIndexer(t, articlesZim(200))
+			idx.BatchSize, idx.BatchBuffer = 1, 1
+			before := runtime.NumGoroutine()
+
+			done := make(chan error, 1)
+			go func() { done <- sink(context.Background(), idx) }()
+			select {
+			case err := <-done:
+				if name != "stream" && err == nil {
+					t.Error("the sink did not fail")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the sink is blocked on the parse")
+			}
+			settled(t, before)
+
+			// the ZIM is released for the next parse
+			if got := len(parse(t, idx)); got != 200 {
+				t.Errorf("parse after the failure: got %d articles, want 200", got)
+			}
+		})
+	}
+}
+
+func TestSinkFailureStopsOwnParse(t *testing.T) {
+	idx := newIndexer(t, articlesZim(200))
+	idx.BatchSize, idx.BatchBuffer = 1, 1
+	ctx := context.Background()
+
+	first := idx.ParseZIMBatches(ctx)
+	for batch := range first {
+		for _, a := range batch {
+			a.Release()
+		}
+	}
+	second := idx.ParseZIMBatches(ctx)
+	// the sink of the first parse, ended, fails after the second started
+	if err := idx.TarZimBatches(ctx, t.TempDir(), first); err == nil {
+		t.Fatal("the sink did not fail")
+	}
+	n := 0
+	for batch := range second {
+		for _, a := range batch {
+			a.Release()
+			n++
+		}
+	}
+	if err := idx.ParseErr(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 200 {
+		t.Errorf("the second parse sent %d articles, want 200", n)
+	}
+}
+
+// BenchmarkTarPaths writes the tar of a ZIM of articles of 4 KiB through
+// the single article path, sending the articles one at a time to TarZim,
+// and through the batches of ParseZIMBatches, the defaults among them:
+//
+//	go test ./indexer -run - -bench TarPaths -benchmem
+func BenchmarkTarPaths(b *testing.B) {
+	const n, size = 2000, 4 << 10
+	zimPath := poolZim(b, n, size)
+	for _, tt := range []struct {
+		name        string
+		batches     bool
+		size, depth int
+	}{
+		{"articles", false, 0, 0},
+		{"batches=1x1", true, 1, 1},
+		{fmt.Sprintf("batches=%dx%d", indexer.DefaultBatchSize, indexer.DefaultBatchBuffer), true, indexer.DefaultBatchSize, indexer.DefaultBatchBuffer},
+		{"batches=256x16", true, 256, 16},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(n * size)
+			tarFile := filepath.Join(b.TempDir(), "bench.tar")
+			ctx := context.Background()
+			for i := 0; i < b.N; i++ {
+				idx, err := indexer.New(zimPath, false)
+				if err != nil {
+					b.Fatal(err)
+				}
+				idx.Logger = logging.Discard()
+				idx.BatchSize, idx.BatchBuffer = tt.size, tt.depth
+				if tt.batches {
+					err = idx.TarZimBatches(ctx, tarFile, idx.ParseZIMBatches(ctx))
+				} else {
+					err = idx.TarZim(ctx, tarFile, idx.ParseZIM(ctx))
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := idx.ParseErr(); err != nil {
+					b.Fatal(err)
+				}
+				idx.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package indexer
+
+import (
+	"bytes"
+	"sync"
+)
+
+// bufferClasses are the capacities of the pooled buffers, in which the
+// payloads of the ZIM and the generated pages are read and written.
+// Larger payloads are allocated and left to the garbage collector.
+var bufferClasses = [...]int{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10}
+
+var bufferPools [len(bufferClasses)]sync.Pool
+
+// bufferClass returns the smallest class holding n bytes, -1 if none.
+func bufferClass(n int) int {
+	for i, c := range bufferClasses {
+		if n <= c {
+			return i
+		}
+	}
+	return -1
+}
+
+// getBuffer returns an empty buffer with a capacity of at least n bytes.
+func getBuffer(n int) *bytes.Buffer {
+	c := bufferClass(n)
+	if c < 0 || !pooledBuffers {
+		return bytes.NewBuffer(make([]byte, 0, n))
+	}
+	if b, ok := bufferPools[c].Get().(*bytes.Buffer); ok {
+		return b
+	}
+	return bytes.NewBuffer(make([]byte, 0, bufferClasses[c]))
+}
+
+// putBuffer returns the buffer to the pool of its class. Buffers that
+// grew past their class are put in the class below their capacity, but
+// those of the larger payloads are dropped, not to be held by the pool.
+func putBuffer(b *bytes.Buffer) {
+	if poisonReleased {
+		poison(b.Bytes())
+	}
+	if !pooledBuffers || b.Cap() > 2*bufferClasses[len(bufferClasses)-1] {
+		return
+	}
+	c := len(bufferClasses) - 1
+	for c >= 0 && b.Cap() < bufferClasses[c] {
+		c--
+	}
+	if c < 0 {
+		return
+	}
+	b.Reset()
+	bufferPools[c].Put(b)
+}
+
+// poison overwrites a released payload so that a sink still reading it
+// writes garbage that is easy to spot, instead of another article.
+func poison(data []byte) {
+	for i := range data {
+		data[i] = 0xdd
+	}
+}
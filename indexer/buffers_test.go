@@ -0,0 +1,52 @@
+package indexer_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/indexer/zimtest"
+	"github.com/r0qs/beezim/internal/iobuf"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// BenchmarkTarWriterBuffer writes the tar of a ZIM of 5000 articles of up
+// to 20 KiB with buffers of the tar writer of a few sizes, the default of
+// iobuf among them:
+//
+//	go test ./indexer -run - -bench TarWriterBuffer
+//
+// The tar is written under TMPDIR, which can point to the file system to
+// tune, e.g. a network one.
+func BenchmarkTarWriterBuffer(b *testing.B) {
+	const n = 5000
+	entries := make([]zimtest.Entry, n)
+	for i := range entries {
+		url := fmt.Sprintf("Article%d", i)
+		entries[i] = zimtest.Entry{Namespace: 'A', URL: url, Title: url, Mime: "text/html", Content: payload(i, 1<<10+i*(19<<10)/n)}
+	}
+	zimPath := filepath.Join(b.TempDir(), "articles.zim")
+	if err := zimtest.New(entries...).WriteFile(zimPath); err != nil {
+		b.Fatal(err)
+	}
+	for _, size := range []int{iobuf.MinSize, 64 << 10, iobuf.DefaultTarWriter, 1 << 20, 4 << 20} {
+		b.Run(fmt.Sprintf("buffer=%dKiB", size>>10), func(b *testing.B) {
+			tarFile := filepath.Join(b.TempDir(), "bench.tar")
+			ctx := context.Background()
+			for i := 0; i < b.N; i++ {
+				idx, err := indexer.New(zimPath, false)
+				if err != nil {
+					b.Fatal(err)
+				}
+				idx.Logger = logging.Discard()
+				idx.Buffers = iobuf.Config{TarWriter: size}
+				if err := idx.TarZimBatches(ctx, tarFile, idx.ParseZIMBatches(ctx)); err != nil {
+					b.Fatal(err)
+				}
+				idx.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,527 @@
+package indexer
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// ErrCheckpointMismatch is returned by Resume when the checkpoint of the
+// tar was recorded for another ZIM or fingerprint.
+var ErrCheckpointMismatch = errors.New("checkpoint of another zim or options")
+
+// ErrTarTruncated is returned by Resume for a tar smaller than its
+// checkpoint.
+var ErrTarTruncated = errors.New("tar truncated before its checkpoint")
+
+// CheckpointFile returns the name of the checkpoint of the tar written
+// with CheckpointEvery, beside it. It is removed once the tar is ended.
+func CheckpointFile(tarFile string) string {
+	return tarFile + ".checkpoint"
+}
+
+// JournalFile returns the name of the journal of the entries recorded by
+// the parse up to the checkpoint of the tar, beside it.
+func JournalFile(tarFile string) string {
+	return tarFile + ".journal"
+}
+
+// checkpoint is the content of the CheckpointFile.
+type checkpoint struct {
+	ZimFile     string `json:"zimFile"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Index is the url index of the last article written, and Articles
+	// the number of articles written up to it.
+	Index    uint32 `json:"index"`
+	Articles int64  `json:"articles"`
+	// Offset is the size of the tar once the article is written, and
+	// Journal that of the journal.
+	Offset  int64 `json:"offset"`
+	Journal int64 `json:"journal"`
+}
+
+// journalRecord is a line of the journal, recorded by the parse of the
+// entry at the url index: an entry, the Sum and Path of the first
+// article of a payload with Dedup, or a redirect left out of the tar with
+// ManifestRedirects.
+type journalRecord struct {
+	Index    uint32      `json:"index"`
+	Entry    *IndexEntry `json:"entry,omitempty"`
+	Sum      string      `json:"sum,omitempty"`
+	Path     string      `json:"path,omitempty"`
+	Redirect *Redirect   `json:"redirect,omitempty"`
+}
+
+// resumeState is the checkpoint of the tar resumed, see Resume.
+type resumeState struct {
+	tarFile string
+	cp      checkpoint
+	// start is the url index of the entry iterated after the last one
+	// written, the count of entries of the ZIM when there is none.
+	start     uint32
+	dedupSums map[[sha256.Size]byte]string
+	redirects []Redirect
+}
+
+// StartAt makes the parses of the indexer start at the entry at the url
+// index, skipping those the ZIM iterates before it.
+func StartAt(urlIndex uint32) Option {
+	return func(o *options) {
+		o.start = &urlIndex
+	}
+}
+
+// checkCheckpoints returns why the tars can not be checkpointed with the
+// options of the indexer.
+func (idx *SwarmZimIndexer) checkCheckpoints() error {
+	switch {
+	case idx.GzipLevel != 0:
+		return errors.New("a gzipped tar can not be checkpointed")
+	case idx.SearchTarFile != "":
+		return errors.New("a tar can not be checkpointed with its search index apart")
+	case idx.Previous != nil:
+		return errors.New("a tar of the articles changed since the previous version can not be checkpointed")
+	}
+	return nil
+}
+
+// errCheckpointSink is returned by the sinks other than the tar files
+// with CheckpointEvery.
+var errCheckpointSink = errors.New("checkpoints are only recorded for the tar files")
+
+// Resume prepares the next parse and tar written to tarFile, by TarZim,
+// TarZimBatches, TarZimArticles or WriteTar, to resume the tar from its
+// last checkpoint, see CheckpointEvery: the tar is truncated to its size
+// then, the entries recorded before restored, and the parse starts after
+// the last article written, so that the tar is the same as one written
+// at once. The stats of the parse, e.g. DedupStats, only count the
+// articles parsed after the checkpoint. It returns false when the tar has
+// no checkpoint, and is then written from the start.
+func (idx *SwarmZimIndexer) Resume(tarFile string) (bool, error) {
+	data, err := os.ReadFile(CheckpointFile(tarFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return false, fmt.Errorf("%s: %w", CheckpointFile(tarFile), err)
+	}
+	if err := idx.checkCheckpoints(); err != nil {
+		return false, err
+	}
+	if cp.ZimFile != filepath.Base(idx.ZimPath) || cp.Fingerprint != idx.fingerprintString() {
+		return false, fmt.Errorf("%s: %w", filepath.Base(tarFile), ErrCheckpointMismatch)
+	}
+	if info, err := os.Stat(tarFile); err != nil {
+		return false, err
+	} else if info.Size() < cp.Offset {
+		return false, fmt.Errorf("%s: %w: %d bytes, the checkpoint is at %d", filepath.Base(tarFile), ErrTarTruncated, info.Size(), cp.Offset)
+	}
+
+	prefix, start, err := idx.iteratedUpTo(cp.Index)
+	if err != nil {
+		return false, err
+	}
+	r := &resumeState{tarFile: tarFile, start: start}
+	records, err := readJournal(JournalFile(tarFile), cp.Journal)
+	if err != nil {
+		return false, err
+	}
+	// the records of the entries parsed ahead of the tar are recorded
+	// again by the parse
+	kept := records[:0]
+	for _, rec := range records {
+		if int(rec.Index) >= len(prefix) || !prefix[rec.Index] {
+			continue
+		}
+		kept = append(kept, rec)
+		switch {
+		case rec.Entry != nil:
+			idx.mu.Lock()
+			err := idx.entries.Put(*rec.Entry)
+			idx.mu.Unlock()
+			if err != nil {
+				return false, err
+			}
+		case rec.Redirect != nil:
+			r.redirects = append(r.redirects, *rec.Redirect)
+		case rec.Sum != "":
+			var sum [sha256.Size]byte
+			if n, err := hex.Decode(sum[:], []byte(rec.Sum)); err != nil || n != len(sum) {
+				return false, fmt.Errorf("%s: invalid sum %q", JournalFile(tarFile), rec.Sum)
+			}
+			if r.dedupSums == nil {
+				r.dedupSums = make(map[[sha256.Size]byte]string)
+			}
+			if _, ok := r.dedupSums[sum]; !ok {
+				r.dedupSums[sum] = rec.Path
+			}
+		}
+	}
+	if cp.Journal, err = rewriteJournal(JournalFile(tarFile), kept); err != nil {
+		return false, err
+	}
+	if err := writeCheckpoint(CheckpointFile(tarFile), cp); err != nil {
+		return false, err
+	}
+	r.cp = cp
+	idx.mu.Lock()
+	idx.resume = r
+	idx.mu.Unlock()
+	idx.logger().Info("resuming tar from its checkpoint", "tar", filepath.Base(tarFile), "articles", cp.Articles, "bytes", cp.Offset)
+	return true, nil
+}
+
+// fingerprintString returns the Fingerprint recorded in the checkpoints,
+// empty without one.
+func (idx *SwarmZimIndexer) fingerprintString() string {
+	if idx.Fingerprint == nil {
+		return ""
+	}
+	return idx.Fingerprint.String()
+}
+
+// iteratedUpTo returns which of the entries, by url index, the ZIM
+// iterates up to the one at the url index, and the one after it.
+func (idx *SwarmZimIndexer) iteratedUpTo(last uint32) (prefix []bool, next uint32, err error) {
+	if err := beginPass(idx.Z); err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", idx.ZimPath, err)
+	}
+	defer endPass(idx.Z)
+	zimMu.Lock()
+	defer zimMu.Unlock()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: %w: reader panicked: %v", idx.ZimPath, ErrZimCorrupt, r)
+		}
+	}()
+	n := idx.Z.ArticleCount()
+	prefix, next = make([]bool, n), n
+	found := false
+	idx.Z.Iterate(func(i uint32) {
+		switch {
+		case next != n:
+		case found:
+			next = i
+		default:
+			prefix[i] = true
+			found = i == last
+		}
+	})
+	if !found {
+		return nil, 0, fmt.Errorf("%s: %w: entry %d of the checkpoint not found", idx.ZimPath, ErrCheckpointMismatch, last)
+	}
+	return prefix, next, nil
+}
+
+// startIndex returns the url index the parse starts at, false to parse
+// every entry.
+func (idx *SwarmZimIndexer) startIndex() (uint32, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.resume != nil {
+		return idx.resume.start, true
+	}
+	if idx.start != nil {
+		return *idx.start, true
+	}
+	return 0, false
+}
+
+// startFilter returns the filter of the parse telling whether an entry
+// iterated is skipped, see StartAt and Resume.
+func (idx *SwarmZimIndexer) startFilter() func(uint32) bool {
+	start, ok := idx.startIndex()
+	started := !ok
+	return func(i uint32) bool {
+		started = started || i == start
+		return !started
+	}
+}
+
+// resumedDedup returns the payloads sent before the checkpoint of Resume.
+func (idx *SwarmZimIndexer) resumedDedup() map[[sha256.Size]byte]string {
+	if idx.resume == nil {
+		return nil
+	}
+	return maps.Clone(idx.resume.dedupSums)
+}
+
+// journalEntry records the entry parsed from the entry at the url index
+// in the journal with CheckpointEvery. The indexer is locked.
+func (idx *SwarmZimIndexer) journalEntry(i uint32, e IndexEntry) {
+	if idx.CheckpointEvery > 0 {
+		idx.journal = append(idx.journal, journalRecord{Index: i, Entry: &e})
+	}
+}
+
+// journalDedup records the first article of a payload, parsed from the
+// entry at the url index, in the journal with CheckpointEvery. The
+// indexer is locked.
+func (idx *SwarmZimIndexer) journalDedup(i uint32, sum [sha256.Size]byte, p string) {
+	if idx.CheckpointEvery > 0 {
+		idx.journal = append(idx.journal, journalRecord{Index: i, Sum: hex.EncodeToString(sum[:]), Path: p})
+	}
+}
+
+// takeJournal returns the records not journaled yet.
+func (idx *SwarmZimIndexer) takeJournal() []journalRecord {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	records := idx.journal
+	idx.journal = nil
+	return records
+}
+
+// checkpointer records the checkpoints of a tar sink.
+type checkpointer struct {
+	every   int
+	tar     *os.File
+	journal *os.File
+	// cp is the last checkpoint, pending the number of articles written
+	// since, the last one from the entry at last, and redirects the
+	// number of redirects of the sink journaled.
+	cp        checkpoint
+	pending   int
+	last      uint32
+	redirects int
+}
+
+// openTar opens the tar file of the sink, the one resumed truncated to the
+// size of its checkpoint, and returns the checkpoint resumed, if any.
+func (idx *SwarmZimIndexer) openTar(tarFile string) (*os.File, *resumeState, error) {
+	idx.mu.Lock()
+	r := idx.resume
+	idx.mu.Unlock()
+	if r == nil {
+		f, err := os.Create(tarFile)
+		return f, nil, err
+	}
+	if r.tarFile != tarFile {
+		return nil, nil, fmt.Errorf("%s: resumed as %s", filepath.Base(tarFile), filepath.Base(r.tarFile))
+	}
+	f, err := os.OpenFile(tarFile, os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := f.Truncate(r.cp.Offset); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if _, err := f.Seek(r.cp.Offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, r, nil
+}
+
+// resumeFrom restores the state of the sink written up to the checkpoint
+// to its tar f: the directories of the tar, its progress and the
+// redirects left out.
+func (s *tarSink) resumeFrom(f *os.File, r *resumeState) error {
+	tr := tar.NewReader(io.NewSectionReader(f, 0, r.cp.Offset))
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.tarFile, err)
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			s.dirs[path.Clean(h.Name)] = true
+		case tar.TypeReg:
+			s.e.Done++
+			s.e.Bytes += h.Size
+		}
+	}
+	s.redirects = append([]Redirect(nil), r.redirects...)
+	return nil
+}
+
+// startCheckpoints starts the checkpoints of the sink to its tar f, after
+// those of the checkpoint resumed, if any, which is removed once the tar
+// is ended even without CheckpointEvery.
+func (s *tarSink) startCheckpoints(f *os.File, r *resumeState) error {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	c := &checkpointer{every: s.idx.CheckpointEvery, tar: f}
+	c.cp = checkpoint{ZimFile: filepath.Base(s.idx.ZimPath), Fingerprint: s.idx.fingerprintString()}
+	if r != nil {
+		flag = os.O_WRONLY | os.O_APPEND
+		c.cp, c.last, c.redirects = r.cp, r.cp.Index, len(r.redirects)
+	}
+	j, err := os.OpenFile(JournalFile(s.tarFile), flag, 0644)
+	if err != nil {
+		return err
+	}
+	c.journal = j
+	s.cp = c
+	return nil
+}
+
+// checkpointed counts the article of the entry at the url index, written
+// next, recording a checkpoint of the articles written before it every
+// CheckpointEvery articles.
+func (s *tarSink) checkpointed(index uint32) error {
+	c := s.cp
+	if c.every > 0 && c.pending >= c.every {
+		if err := s.checkpoint(); err != nil {
+			return fmt.Errorf("%s: checkpoint: %w", s.tarFile, err)
+		}
+		c.pending = 0
+	}
+	c.pending++
+	c.cp.Articles++
+	c.last = index
+	return nil
+}
+
+// checkpoint syncs the tar and the journal, with the records of the
+// entries parsed meanwhile, and records the checkpoint of the articles
+// written.
+func (s *tarSink) checkpoint() error {
+	c := s.cp
+	if err := s.tw.Flush(); err != nil {
+		return err
+	}
+	if err := s.bw.Flush(); err != nil {
+		return err
+	}
+	if err := c.tar.Sync(); err != nil {
+		return err
+	}
+	offset, err := c.tar.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	// with those of the entries parsed ahead of the tar, left out by
+	// Resume
+	records := s.idx.takeJournal()
+	for _, r := range s.redirects[c.redirects:] {
+		records = append(records, journalRecord{Index: c.last, Redirect: &r})
+	}
+	n, err := appendJournal(c.journal, records)
+	if err != nil {
+		return err
+	}
+	c.redirects = len(s.redirects)
+	c.cp.Index, c.cp.Offset = c.last, offset
+	c.cp.Journal += n
+	return writeCheckpoint(CheckpointFile(s.tarFile), c.cp)
+}
+
+// endCheckpoints removes the checkpoint and the journal of the tar ended.
+func (s *tarSink) endCheckpoints() error {
+	s.cp.journal.Close()
+	s.idx.mu.Lock()
+	s.idx.resume, s.idx.journal = nil, nil
+	s.idx.mu.Unlock()
+	if err := os.Remove(CheckpointFile(s.tarFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.Remove(JournalFile(s.tarFile))
+}
+
+// appendJournal appends the records to the journal, synced, and returns
+// the number of bytes written.
+func appendJournal(f *os.File, records []journalRecord) (int64, error) {
+	cw := &countWriter{w: f}
+	bw := bufio.NewWriter(cw)
+	enc := json.NewEncoder(bw)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return 0, err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	return cw.n, f.Sync()
+}
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// readJournal reads the records of the first size bytes of the journal.
+func readJournal(file string, size int64) ([]journalRecord, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []journalRecord
+	dec := json.NewDecoder(io.LimitReader(bufio.NewReader(f), size))
+	for {
+		var r journalRecord
+		err := dec.Decode(&r)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		records = append(records, r)
+	}
+}
+
+// rewriteJournal replaces the journal by the records and returns its
+// size.
+func rewriteJournal(file string, records []journalRecord) (int64, error) {
+	f, err := os.Create(file + ".tmp")
+	if err != nil {
+		return 0, err
+	}
+	n, err := appendJournal(f, records)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(file+".tmp", file)
+	}
+	return n, err
+}
+
+// writeCheckpoint replaces the checkpoint file by the checkpoint, synced.
+func writeCheckpoint(file string, cp checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(file + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
@@ -0,0 +1,118 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Collisions is what a parse does with the entries of the ZIM sharing
+// their path with another, see SwarmZimIndexer.Collisions.
+type Collisions string
+
+const (
+	// CollisionsKeepLast keeps the entry sent last, the one a tar reader
+	// extracts and bee serves when both are written.
+	CollisionsKeepLast Collisions = ""
+	// CollisionsKeepFirst keeps the entry sent first.
+	CollisionsKeepFirst Collisions = "keep-first"
+	// CollisionsError fails the parse before it sends any article.
+	CollisionsError Collisions = "error"
+)
+
+// ErrPathCollision is returned by a parse with CollisionsError for a ZIM
+// with two entries at the same path.
+var ErrPathCollision = errors.New("entries with the same path")
+
+// ParseCollisions returns the Collisions named s, "keep-last" being
+// CollisionsKeepLast.
+func ParseCollisions(s string) (Collisions, error) {
+	switch c := Collisions(s); c {
+	case CollisionsKeepLast, CollisionsKeepFirst, CollisionsError:
+		return c, nil
+	case "keep-last":
+		return CollisionsKeepLast, nil
+	}
+	return CollisionsKeepLast, fmt.Errorf("invalid collisions %q, use keep-last, %s or %s", s, CollisionsKeepFirst, CollisionsError)
+}
+
+// PathCollisions returns the paths of the entries of the last parse left
+// out for another entry at the same path, once per entry left out.
+func (idx *SwarmZimIndexer) PathCollisions() []string {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.collided
+}
+
+// scanCollisions returns the url indexes of the entries left out of the
+// parse by Collisions, or ErrPathCollision with CollisionsError. The urls
+// of a ZIM are sorted, so the entries at the same path follow each other
+// by url index; the one kept is first or last in the order of the parse.
+func (idx *SwarmZimIndexer) scanCollisions() (map[uint32]bool, error) {
+	idx.mu.Lock()
+	idx.collided, idx.dropped = nil, nil
+	idx.mu.Unlock()
+
+	dups := make(map[uint32]string)
+	func() {
+		// a reader that panics on an entry reads none of them
+		defer func() {
+			if r := recover(); r != nil {
+				dups = nil
+			}
+		}()
+		prev, prevURL := uint32(0), ""
+		for i := uint32(0); i < idx.Z.ArticleCount(); i++ {
+			entry, err := idx.Z.EntryAt(i)
+			if err != nil {
+				prevURL = ""
+				continue
+			}
+			url := entry.FullURL()
+			if url != "" && url == prevURL {
+				dups[prev], dups[i] = url, url
+			}
+			prev, prevURL = i, url
+		}
+	}()
+	if len(dups) == 0 {
+		return nil, nil
+	}
+
+	kept := make(map[string]uint32)
+	var first string
+	idx.Z.Iterate(func(i uint32) {
+		p, ok := dups[i]
+		if !ok {
+			return
+		}
+		if _, seen := kept[p]; !seen {
+			kept[p] = i
+			if first == "" {
+				first = p
+			}
+		} else if idx.Collisions == CollisionsKeepLast {
+			kept[p] = i
+		}
+	})
+	if idx.Collisions == CollisionsError {
+		return nil, fmt.Errorf("%s: %w: %s, %d entries in all", idx.ZimPath, ErrPathCollision, first, len(dups))
+	}
+	out := make(map[uint32]bool, len(dups)-len(kept))
+	for i, p := range dups {
+		if kept[p] != i {
+			out[i] = true
+		}
+	}
+	idx.mu.Lock()
+	idx.dropped = out
+	idx.mu.Unlock()
+	return out, nil
+}
+
+// collide records the path of an entry left out by Collisions.
+func (idx *SwarmZimIndexer) collide(path string) {
+	idx.logger().Warn("entry left out for another at the same path", "article", path)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.collided = append(idx.collided, path)
+}
@@ -0,0 +1,75 @@
+package indexer
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/klauspost/compress/s2"
+)
+
+// minCompressSize is the size under which payloads are not compressed,
+// as the saving would not be worth the CPU.
+const minCompressSize = 1 << 10
+
+// compressible reports whether payloads of the mime type are worth
+// compressing: text, and not the images, videos and fonts that are
+// compressed already.
+func compressible(mimeType string) bool {
+	switch {
+	case strings.HasPrefix(mimeType, "text/"):
+		return true
+	case strings.HasSuffix(mimeType, "+xml"), strings.HasSuffix(mimeType, "/xml"):
+		return true
+	}
+	switch mimeType {
+	case "application/javascript", "application/json":
+		return true
+	}
+	return false
+}
+
+// compress replaces the payload by its s2 encoding, unless it does not
+// get smaller. The encoding is copied out of the pooled scratch buffer,
+// as the size classes would waste most of the saving.
+func (a *Article) compress() {
+	if a.compressed || a.streamed() || len(a.data) < minCompressSize {
+		return
+	}
+	n := s2.MaxEncodedLen(len(a.data))
+	scratch := getBuffer(n)
+	defer putBuffer(scratch)
+	enc := s2.Encode(fill(scratch, n), a.data)
+	if len(enc) >= len(a.data) {
+		return
+	}
+	data := append([]byte(nil), enc...)
+	a.Release()
+	a.data, a.compressed = data, true
+}
+
+// decompress restores the payload compressed by compress, in a pooled
+// buffer.
+func (a *Article) decompress() error {
+	if !a.compressed {
+		return nil
+	}
+	n, err := s2.DecodedLen(a.data)
+	if err != nil {
+		return err
+	}
+	buf := getBuffer(n)
+	if _, err := s2.Decode(fill(buf, n), a.data); err != nil {
+		putBuffer(buf)
+		return err
+	}
+	a.Release()
+	a.buf, a.data, a.compressed = buf, buf.Bytes(), false
+	return nil
+}
+
+// fill extends the empty buffer to n bytes and returns them.
+func fill(buf *bytes.Buffer, n int) []byte {
+	buf.Grow(n)
+	buf.Write(buf.AvailableBuffer()[:n])
+	return buf.Bytes()
+}
@@ -0,0 +1,140 @@
+package indexer_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/indexer/zimtest"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// textZim writes a ZIM of n HTML articles of about size bytes, with a
+// stylesheet, a small article and an image left uncompressed, and
+// returns its path.
+func textZim(tb testing.TB, n int, size int) string {
+	tb.Helper()
+	entries := []zimtest.Entry{
+		{Namespace: '-', URL: "style.css", Mime: "text/css", Content: []byte(strings.Repeat("p { margin: 0 }\n", size/16))},
+		{Namespace: 'A', URL: "Small", Title: "Small", Mime: "text/html", Content: []byte("<p>small</p>")},
+		{Namespace: 'I', URL: "logo.png", Mime: "image/png", Content: bytes.Repeat([]byte("\x89PNG\x00\x01\x02"), size/8)},
+		zimtest.Redirect('A', "Redirect", 1),
+	}
+	for i := range n {
+		url := fmt.Sprintf("Article%d", i)
+		entries = append(entries, zimtest.Entry{Namespace: 'A', URL: url, Title: url, Mime: "text/html", Content: payload(i, size)})
+	}
+	zimPath := filepath.Join(tb.TempDir(), "text.zim")
+	if err := zimtest.New(entries...).WriteFile(zimPath); err != nil {
+		tb.Fatal(err)
+	}
+	return zimPath
+}
+
+// tarBuffered writes the tar of the ZIM to tarFile, with the buffered text
+// articles compressed or not.
+func tarBuffered(tb testing.TB, zimPath string, tarFile string, compressed bool) {
+	tb.Helper()
+	idx, err := indexer.New(zimPath, false)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer idx.Close()
+	idx.Logger = logging.Discard()
+	idx.CompressBuffered = compressed
+	idx.BatchSize, idx.BatchBuffer = 16, 64
+	ctx := context.Background()
+	if err := idx.TarZimBatches(ctx, tarFile, idx.ParseZIMBatches(ctx)); err != nil {
+		tb.Fatal(err)
+	}
+	if err := idx.ParseErr(); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestCompressBufferedIdentical(t *testing.T) {
+	zimPath := textZim(t, 200, 8<<10)
+	dir := t.TempDir()
+	plain, compressed := filepath.Join(dir, "plain.tar"), filepath.Join(dir, "compressed.tar")
+	tarBuffered(t, zimPath, plain, false)
+	tarBuffered(t, zimPath, compressed, true)
+
+	want, err := os.ReadFile(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		files := readTar(t, compressed)
+		for name, data := range readTar(t, plain) {
+			if !bytes.Equal(files[name], data) {
+				t.Errorf("%s: %d bytes with --compress-buffered, %d without", name, len(files[name]), len(data))
+			}
+		}
+		t.Fatalf("the tar of %d bytes differs from the %d without compression", len(got), len(want))
+	}
+}
+
+// BenchmarkCompressBuffered writes the tar of a ZIM of HTML articles of
+// 16 KiB with up to 1024 articles parsed ahead of the tar writer, their
+// payloads compressed or not, reporting the peak of the heap in use
+// besides the time and the allocations:
+//
+//	go test ./indexer -run - -bench CompressBuffered -benchmem
+func BenchmarkCompressBuffered(b *testing.B) {
+	const n, size = 2000, 16 << 10
+	zimPath := textZim(b, n, size)
+	for _, compressed := range []bool{false, true} {
+		b.Run(fmt.Sprintf("compressed=%t", compressed), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(n * size)
+			tarFile := filepath.Join(b.TempDir(), "bench.tar")
+			var peak uint64
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+				stop := samplePeak(&peak)
+				tarBuffered(b, zimPath, tarFile, compressed)
+				stop()
+			}
+			b.ReportMetric(float64(peak), "peak-heap-B")
+		})
+	}
+}
+
+// samplePeak records the peak of the heap in use into peak until the
+// returned function is called.
+func samplePeak(peak *uint64) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var m runtime.MemStats
+		t := time.NewTicker(time.Millisecond)
+		defer t.Stop()
+		for {
+			runtime.ReadMemStats(&m)
+			*peak = max(*peak, m.HeapInuse)
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
@@ -0,0 +1,127 @@
+package indexer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+)
+
+// Dedup is which articles identical to one sent before are replaced by
+// a small alias of it, see SwarmZimIndexer.Dedup.
+type Dedup string
+
+const (
+	// DedupOff sends every article with its payload.
+	DedupOff Dedup = ""
+	// DedupAssets replaces the duplicated articles but the HTML pages,
+	// which stay plain pages for offline browsing.
+	DedupAssets Dedup = "assets"
+	// DedupAll also replaces the duplicated HTML pages.
+	DedupAll Dedup = "all"
+)
+
+// ParseDedup returns the Dedup named s, "off" being DedupOff.
+func ParseDedup(s string) (Dedup, error) {
+	switch d := Dedup(s); d {
+	case DedupOff, DedupAssets, DedupAll:
+		return d, nil
+	case "off":
+		return DedupOff, nil
+	}
+	return DedupOff, fmt.Errorf("invalid dedup %q, use off, %s or %s", s, DedupAssets, DedupAll)
+}
+
+// DedupStats are the duplicated articles of the last parse.
+type DedupStats struct {
+	// Duplicates is the number of articles identical to one sent before,
+	// and Bytes their size.
+	Duplicates int
+	Bytes      int64
+	// Aliased is the number of duplicates replaced by an alias, and
+	// Saved the bytes it saved.
+	Aliased int
+	Saved   int64
+}
+
+// DedupStats returns the duplicated articles of the last parse, none
+// without Dedup.
+func (idx *SwarmZimIndexer) DedupStats() DedupStats {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.dedupStats
+}
+
+// resetDedup forgets the articles of the previous parse, but those of
+// the tar resumed.
+func (idx *SwarmZimIndexer) resetDedup() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.dedupSums = idx.resumedDedup()
+	idx.dedupStats = DedupStats{}
+}
+
+// dedup returns the path of the article sent before with the same
+// payload, if any, and replaces the payload by an alias of it when its
+// type has one and it is smaller. Only the HTML pages and the style
+// sheets can be aliased: bee serves the regular files of a tar only, and
+// a browser does not follow an HTML redirect for an image or a script,
+// which keep their payload, whose chunks Swarm stores once anyway. The
+// articles are deduplicated in the order they are sent, so it is the
+// same for every parse.
+func (idx *SwarmZimIndexer) dedup(a *Article) string {
+	html := baseMime(a.mime) == "text/html"
+	if idx.Dedup == DedupOff || a.isDir || a.exception || html && idx.Dedup != DedupAll {
+		return ""
+	}
+	sum := a.sum
+	if !a.summed {
+		sum = sha256.Sum256(a.data)
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	canonical, ok := idx.dedupSums[sum]
+	if !ok {
+		if idx.dedupSums == nil {
+			idx.dedupSums = make(map[[sha256.Size]byte]string)
+		}
+		idx.dedupSums[sum] = a.path
+		idx.journalDedup(a.index, sum, a.path)
+		return ""
+	}
+
+	size := int(a.Size())
+	idx.dedupStats.Duplicates++
+	idx.dedupStats.Bytes += int64(size)
+	// without theme, the pages fail with ThemeErr
+	t, _ := idx.pageTheme()
+	buf := getBuffer(redirectPageSize)
+	if !writeAlias(t, idx.pageData(a.path, map[string]interface{}{}), buf, a.mime, relativeLink(a.path, canonical)) || buf.Len() >= size {
+		putBuffer(buf)
+		return canonical
+	}
+	a.Release()
+	a.buf, a.data = buf, buf.Bytes()
+	idx.dedupStats.Aliased++
+	idx.dedupStats.Saved += int64(size - buf.Len())
+	return canonical
+}
+
+// writeAlias writes to buf a file of the MIME type standing for the one
+// at link, false when the type has none, a redirect page with the data of
+// pageData for HTML.
+func writeAlias(t *theme, data map[string]interface{}, buf *bytes.Buffer, mimeType string, link string) bool {
+	switch baseMime(mimeType) {
+	case "text/html":
+		return t != nil && t.writeRedirectPage(buf, link, data) == nil
+	case "text/css":
+		fmt.Fprintf(buf, "@import url(\"%s\");\n", link)
+		return true
+	}
+	return false
+}
+
+func baseMime(mimeType string) string {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	return strings.TrimSpace(mimeType)
+}
@@ -0,0 +1,145 @@
+package indexer
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/r0qs/beezim/internal/tarball"
+)
+
+// DiffFile is the name in the tars of the diff of their articles with the
+// previous version of the ZIM, see Previous.
+const DiffFile = "_beezim/diff.json"
+
+// Diff is how the entries of the last parse differ from the Previous ones,
+// the content of DiffFile.
+type Diff struct {
+	// Added and Changed are the paths of the entries sent, new or with
+	// another payload, or redirect target, than before.
+	Added   []string `json:"added"`
+	Changed []string `json:"changed"`
+	// Removed are the previous entries the ZIM no longer has, or whose
+	// articles are left out now, e.g. for their size, redirects included.
+	Removed []ManifestEntry `json:"removed"`
+	// Unchanged is the number of entries left out of the tar as they are
+	// the same as before.
+	Unchanged int `json:"unchanged"`
+}
+
+// ReadEntriesManifest reads the entries of a previous tar, in its
+// EntriesFile, or of the EntriesFile itself when its name ends with
+// .json, e.g. for Previous.
+func ReadEntriesManifest(file string) ([]ManifestEntry, error) {
+	var data []byte
+	var err error
+	if strings.HasSuffix(file, ".json") {
+		data, err = os.ReadFile(file)
+	} else {
+		data, err = tarball.ReadFile(file, EntriesFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []ManifestEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", EntriesFile, err)
+	}
+	return entries, nil
+}
+
+// Diff returns how the entries of the last parse differ from the Previous
+// ones, nothing without them.
+func (idx *SwarmZimIndexer) Diff() Diff {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.diff
+}
+
+// resetDiff forgets the diff of the previous parse, looking for the
+// Previous entries again.
+func (idx *SwarmZimIndexer) resetDiff() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.diff = Diff{}
+	idx.diffPrevious = nil
+	if idx.Previous == nil {
+		return
+	}
+	idx.diffPrevious = make(map[string]ManifestEntry, len(idx.Previous))
+	for _, e := range idx.Previous {
+		// the extra files are not entries of the ZIM
+		if !e.Extra {
+			idx.diffPrevious[e.Path] = e
+		}
+	}
+}
+
+// unchanged records the entry of the article in the diff with the Previous
+// entries, telling whether it is the same as before, the previous entries
+// without SHA256 being taken as changed.
+func (idx *SwarmZimIndexer) unchanged(a *Article) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.diffPrevious == nil || a.exception {
+		return false
+	}
+	path := a.path
+	prev, ok := idx.diffPrevious[path]
+	if !ok {
+		idx.diff.Added = append(idx.diff.Added, path)
+		return false
+	}
+	delete(idx.diffPrevious, path)
+	same := prev.Redirect && a.target != "" && prev.Target == a.target
+	if a.summed {
+		same = prev.SHA256 != "" && prev.SHA256 == hex.EncodeToString(a.sum[:])
+	}
+	if !same {
+		idx.diff.Changed = append(idx.diff.Changed, path)
+		return false
+	}
+	idx.diff.Unchanged++
+	return true
+}
+
+// endDiff records the Previous entries not found by the parse as removed.
+func (idx *SwarmZimIndexer) endDiff() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.diffPrevious == nil {
+		return
+	}
+	for _, p := range slices.Sorted(maps.Keys(idx.diffPrevious)) {
+		idx.diff.Removed = append(idx.diff.Removed, idx.diffPrevious[p])
+	}
+	idx.diffPrevious = nil
+}
+
+// writeDiffFile appends the DiffFile of the last parse to the tar, with
+// Previous.
+func (idx *SwarmZimIndexer) writeDiffFile(w FileWriter) error {
+	if idx.Previous == nil {
+		return nil
+	}
+	d := idx.Diff()
+	// empty arrays rather than null for the readers of the report
+	for _, l := range []*[]string{&d.Added, &d.Changed} {
+		if *l == nil {
+			*l = []string{}
+		}
+	}
+	if d.Removed == nil {
+		d.Removed = []ManifestEntry{}
+	}
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	idx.logger().Info("appending file", "file", DiffFile, "tar", w.Name())
+	return w.WriteFile(tarball.NewBytesFile(DiffFile, append(data, '\n')))
+}
@@ -0,0 +1,129 @@
+package indexer
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// EntriesFile is the name in the tars of the manifest of their entries,
+// see MakeEntriesManifest.
+const EntriesFile = "_beezim/entries.json"
+
+// ManifestEntry is an entry of the EntriesFile.
+type ManifestEntry struct {
+	Path     string `json:"path"`
+	Title    string `json:"title"`
+	MimeType string `json:"mimeType"`
+	Size     int64  `json:"size,omitempty"`
+	Redirect bool   `json:"redirect,omitempty"`
+	// SHA256, in hex, is that of the payload of an article, and Target
+	// the path a redirect ends at, see Previous.
+	SHA256 string `json:"sha256,omitempty"`
+	Target string `json:"target,omitempty"`
+	// Zim is the name of the ZIM file of the entry, in the EntriesFile of
+	// a MultiIndexer.
+	Zim string `json:"zim,omitempty"`
+	// Extra is set for the files of AddExtraFiles, which are not entries
+	// of the ZIM.
+	Extra bool `json:"extra,omitempty"`
+}
+
+// MakeEntriesManifest appends the entries of the parses of the indexer
+// found in the tar, those neither skipped nor failed, to the tar as
+// EntriesFile: a JSON array of ManifestEntry sorted by path.
+func (idx *SwarmZimIndexer) MakeEntriesManifest(tarFile string) error {
+	return idx.writeEntriesManifest(AppendTo(tarFile))
+}
+
+func (idx *SwarmZimIndexer) writeEntriesManifest(w FileWriter) error {
+	return idx.writeEntriesPage(EntriesFile, w, idx.encodeEntriesManifest)
+}
+
+// encodeEntriesManifest writes the EntriesFile one entry per line, so that
+// the entries are never held in memory all at once.
+func (idx *SwarmZimIndexer) encodeEntriesManifest(w io.Writer) error {
+	mw := &manifestWriter{w: w}
+	if err := idx.manifestEntries(mw.write); err != nil {
+		return err
+	}
+	return mw.close()
+}
+
+// ForEachManifestEntry calls fn with the entries of the EntriesFile of
+// the tars of the indexer, in their order, until it fails. The indexer is
+// locked meanwhile, as by ForEachEntry.
+func (idx *SwarmZimIndexer) ForEachManifestEntry(fn func(ManifestEntry) error) error {
+	return idx.manifestEntries(fn)
+}
+
+// manifestEntries calls fn with the entries of the EntriesFile, in their
+// order, the files of AddExtraFiles among them, until it fails.
+func (idx *SwarmZimIndexer) manifestEntries(fn func(ManifestEntry) error) error {
+	var err error
+	extra := idx.ExtraFiles()
+	idx.ForEachEntry(func(e IndexEntry) bool {
+		if e.Metadata.Skipped != "" || e.Metadata.Exception != "" {
+			return true
+		}
+		// the extra files before the entry, both sorted by path
+		for ; len(extra) > 0 && extra[0].Path < e.Path; extra = extra[1:] {
+			if err = fn(extraManifestEntry(extra[0])); err != nil {
+				return false
+			}
+		}
+		err = fn(ManifestEntry{
+			Path:     e.Path,
+			Title:    e.Metadata.Title,
+			MimeType: e.Metadata.MimeType,
+			Size:     e.Size,
+			Redirect: e.Metadata.Redirect,
+			SHA256:   e.SHA256,
+			Target:   e.Metadata.Target,
+		})
+		return err == nil
+	})
+	for ; err == nil && len(extra) > 0; extra = extra[1:] {
+		err = fn(extraManifestEntry(extra[0]))
+	}
+	if err != nil {
+		return err
+	}
+	return idx.EntriesErr()
+}
+
+// manifestWriter writes the JSON array of an EntriesFile one entry per
+// line.
+type manifestWriter struct {
+	w io.Writer
+	// n is the number of entries written.
+	n int
+}
+
+func (mw *manifestWriter) write(e ManifestEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if mw.n == 0 {
+		sep = "[\n"
+	}
+	if _, err := io.WriteString(mw.w, sep); err != nil {
+		return err
+	}
+	if _, err := mw.w.Write(data); err != nil {
+		return err
+	}
+	mw.n++
+	return nil
+}
+
+// close ends the array.
+func (mw *manifestWriter) close() error {
+	end := "\n]\n"
+	if mw.n == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(mw.w, end)
+	return err
+}
@@ -0,0 +1,240 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// EntryStore holds the entries recorded by the parses of an indexer, see
+// WithEntryStore. The indexer calls it with its lock held, one call at a
+// time.
+type EntryStore interface {
+	// Put records the entry, replacing the one at its path.
+	Put(e IndexEntry) error
+	// Get returns the entry at path, and whether there is one.
+	Get(path string) (IndexEntry, bool, error)
+	// Len returns the number of entries.
+	Len() int
+	// Range calls fn with the entries by path until it returns false.
+	Range(fn func(IndexEntry) bool) error
+	// Close releases the entries.
+	Close() error
+}
+
+// WithEntryStore records the entries of the parses of the indexer in s,
+// MemoryStore when nil. Close closes it.
+func WithEntryStore(s EntryStore) Option {
+	return func(o *options) {
+		o.entries = s
+	}
+}
+
+// memoryStore is the default EntryStore, a map of the entries by path.
+type memoryStore map[string]IndexEntry
+
+// MemoryStore returns a store of the entries in memory, about 300 bytes
+// per entry.
+func MemoryStore() EntryStore {
+	return make(memoryStore)
+}
+
+func (m memoryStore) Put(e IndexEntry) error {
+	m[e.Path] = e
+	return nil
+}
+
+func (m memoryStore) Get(path string) (IndexEntry, bool, error) {
+	e, ok := m[path]
+	return e, ok, nil
+}
+
+func (m memoryStore) Len() int {
+	return len(m)
+}
+
+func (m memoryStore) Range(fn func(IndexEntry) bool) error {
+	for _, p := range slices.Sorted(maps.Keys(m)) {
+		if !fn(m[p]) {
+			break
+		}
+	}
+	return nil
+}
+
+func (m memoryStore) Close() error {
+	return nil
+}
+
+// diskBatchSize is the number of entries a diskStore holds in memory
+// before writing them at once.
+const diskBatchSize = 1024
+
+// diskStore is an EntryStore in a LevelDB database of a temporary
+// directory, opened on the first entry.
+type diskStore struct {
+	dir string
+	// created is set when the store created dir, removed by Close.
+	created bool
+	// tmp is the directory of the database, removed by Close.
+	tmp string
+	db  *leveldb.DB
+	// pending are the entries not written yet to the database.
+	pending map[string]IndexEntry
+	n       int
+}
+
+// DiskStore returns a store of the entries in a temporary LevelDB database
+// of dir, for the ZIMs whose millions of entries do not fit in memory.
+// The indexer holds about the entries of diskBatchSize and the caches of
+// the database, some MiB, whatever the number of entries; the pages
+// listing them, such as files.json, are written to dir before they are
+// added to the tar.
+func DiskStore(dir string) EntryStore {
+	return &diskStore{dir: dir, pending: make(map[string]IndexEntry)}
+}
+
+func (s *diskStore) open() error {
+	if s.db != nil {
+		return nil
+	}
+	if _, err := os.Stat(s.dir); os.IsNotExist(err) {
+		s.created = true
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(s.dir, "entries-")
+	if err != nil {
+		return err
+	}
+	db, err := leveldb.OpenFile(tmp, &opt.Options{
+		Filter: filter.NewBloomFilter(10),
+		// the entries are written once and read back by path
+		NoSync: true,
+	})
+	if err != nil {
+		os.RemoveAll(tmp)
+		return fmt.Errorf("entry store: %w", err)
+	}
+	s.tmp, s.db = tmp, db
+	return nil
+}
+
+func (s *diskStore) Put(e IndexEntry) error {
+	if err := s.open(); err != nil {
+		return err
+	}
+	if _, ok := s.pending[e.Path]; !ok {
+		if ok, err := s.db.Has([]byte(e.Path), nil); err != nil {
+			return fmt.Errorf("entry store: %w", err)
+		} else if !ok {
+			s.n++
+		}
+	}
+	s.pending[e.Path] = e
+	if len(s.pending) >= diskBatchSize {
+		return s.flush()
+	}
+	return nil
+}
+
+// flush writes the pending entries to the database.
+func (s *diskStore) flush() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	var b leveldb.Batch
+	for p, e := range s.pending {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		b.Put([]byte(p), data)
+	}
+	if err := s.db.Write(&b, nil); err != nil {
+		return fmt.Errorf("entry store: %w", err)
+	}
+	clear(s.pending)
+	return nil
+}
+
+func (s *diskStore) Get(path string) (IndexEntry, bool, error) {
+	if e, ok := s.pending[path]; ok || s.db == nil {
+		return e, ok, nil
+	}
+	data, err := s.db.Get([]byte(path), nil)
+	if err == leveldb.ErrNotFound {
+		return IndexEntry{}, false, nil
+	} else if err != nil {
+		return IndexEntry{}, false, fmt.Errorf("entry store: %w", err)
+	}
+	var e IndexEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return IndexEntry{}, false, fmt.Errorf("entry store: %s: %w", path, err)
+	}
+	return e, true, nil
+}
+
+func (s *diskStore) Len() int {
+	return s.n
+}
+
+func (s *diskStore) Range(fn func(IndexEntry) bool) error {
+	if s.db == nil {
+		return nil
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+	it := s.db.NewIterator(nil, nil)
+	defer it.Release()
+	for it.Next() {
+		var e IndexEntry
+		if err := json.Unmarshal(it.Value(), &e); err != nil {
+			return fmt.Errorf("entry store: %s: %w", it.Key(), err)
+		}
+		if !fn(e) {
+			break
+		}
+	}
+	if err := it.Error(); err != nil {
+		return fmt.Errorf("entry store: %w", err)
+	}
+	return nil
+}
+
+func (s *diskStore) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	if rmErr := os.RemoveAll(s.tmp); err == nil {
+		err = rmErr
+	}
+	if s.created {
+		// left when another store uses it
+		os.Remove(s.dir)
+		s.created = false
+	}
+	clear(s.pending)
+	s.n = 0
+	return err
+}
+
+// spoolDir returns the directory the pages listing the entries of s are
+// written to before they are added to a tar, none to keep them in memory,
+// as for a DiskStore without entries.
+func spoolDir(s EntryStore) string {
+	if d, ok := s.(*diskStore); ok && d.db != nil {
+		return d.dir
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package indexer
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// ExceptionsDir is the directory of the tar and of the extracted files
+// where a file is written for each entry that could not be extracted,
+// like zimdump does.
+const ExceptionsDir = "_exceptions"
+
+// exception records the entry that could not be extracted in the entries,
+// and returns the article of its exception file, which tells its url and
+// the error.
+func (idx *SwarmZimIndexer) exception(index uint32, url string, err error) Article {
+	name := exceptionPath(index, url)
+	key := url
+	if key == "" {
+		key = name
+	}
+	idx.addParsedEntry(index, IndexEntry{
+		Path: key,
+		Metadata: IndexMetadata{
+			MimeType:  "text/plain",
+			Error:     err.Error(),
+			Exception: name,
+		},
+	})
+	return Article{
+		path:      name,
+		data:      []byte(fmt.Sprintf("entry: %d\nurl: %s\nerror: %v\n", index, url, err)),
+		mime:      "text/plain",
+		exception: true,
+	}
+}
+
+// exceptionPath returns the path of the exception file of the entry, under
+// its url when it is a safe name, see checkEntryName, and a local path.
+func exceptionPath(index uint32, url string) string {
+	if url != "" {
+		if name := ExceptionsDir + "/" + url + ".txt"; checkEntryName(name) == nil && filepath.IsLocal(filepath.FromSlash(name)) {
+			return name
+		}
+	}
+	return fmt.Sprintf("%s/entry-%d.txt", ExceptionsDir, index)
+}
+
+// Exceptions returns the entries that could not be extracted in the parses
+// of the indexer, each with the Error and Exception file of its metadata,
+// by path.
+func (idx *SwarmZimIndexer) Exceptions() []IndexEntry {
+	var entries []IndexEntry
+	idx.ForEachEntry(func(e IndexEntry) bool {
+		if e.Metadata.Error != "" {
+			entries = append(entries, e)
+		}
+		return true
+	})
+	return entries
+}
@@ -0,0 +1,216 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/r0qs/beezim/internal/tarball"
+)
+
+// ErrExtraFileCollision is returned for an extra file, see AddExtraFiles,
+// at the path of another one, of an entry of the ZIM or of a page of the
+// tar, or under one of them.
+var ErrExtraFileCollision = errors.New("extra file at the path of another file")
+
+// ExtraFile is a local file packed into the tars, see AddExtraFiles.
+type ExtraFile struct {
+	// Path is its path in the tars, Source that of the local file.
+	Path   string
+	Source string
+	Size   int64
+}
+
+// AddExtraFiles packs the local files into the tars after the articles,
+// e.g. an about page, a license or a logo, listed in the EntriesFile. The
+// paths maps their path in the tars to that of the local file or of a
+// directory, whose files are packed under the path, "." or "" for the
+// root of the tar, its symlinks followed. It fails for a symlink cycle,
+// and for two files at the same path with ErrExtraFileCollision; the
+// files are checked against the entries of the ZIM and the pages of the
+// tar when they are written.
+func (idx *SwarmZimIndexer) AddExtraFiles(paths map[string]string) error {
+	var files []ExtraFile
+	for _, name := range slices.Sorted(maps.Keys(paths)) {
+		src := paths[name]
+		info, err := os.Stat(src)
+		if err != nil {
+			return fmt.Errorf("extra file %s: %w", name, err)
+		}
+		dest := tarball.TarName(name)
+		if !info.IsDir() {
+			if dest == "" {
+				return fmt.Errorf("extra file %s: no path for the file in the tar", src)
+			}
+			if !info.Mode().IsRegular() {
+				return fmt.Errorf("extra file %s: %s is not a regular file", name, src)
+			}
+			files = append(files, ExtraFile{Path: dest, Source: src, Size: info.Size()})
+			continue
+		}
+		if files, err = walkExtraDir(files, dest, src, []os.FileInfo{info}); err != nil {
+			return err
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	all := append(slices.Clone(idx.extraFiles), files...)
+	slices.SortFunc(all, func(a, b ExtraFile) int { return strings.Compare(a.Path, b.Path) })
+	seen := make(map[string]string, len(all))
+	for _, f := range all {
+		if prev, ok := seen[f.Path]; ok {
+			return fmt.Errorf("extra file %s of %s and %s: %w", f.Path, prev, f.Source, ErrExtraFileCollision)
+		}
+		seen[f.Path] = f.Source
+	}
+	for _, f := range all {
+		for d := path.Dir(f.Path); d != "."; d = path.Dir(d) {
+			if src, ok := seen[d]; ok {
+				return fmt.Errorf("extra file %s of %s is a directory of %s: %w", d, src, f.Path, ErrExtraFileCollision)
+			}
+		}
+	}
+	idx.extraFiles = all
+	return nil
+}
+
+// walkExtraDir appends the files of the directory src to files under
+// dest, following the symlinks; parents are the directories walked down
+// to src, a symlink to one of them being a cycle.
+func walkExtraDir(files []ExtraFile, dest, src string, parents []os.FileInfo) ([]ExtraFile, error) {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return nil, fmt.Errorf("extra file %s: %w", dest, err)
+	}
+	for _, e := range entries {
+		p := filepath.Join(src, e.Name())
+		name := path.Join(dest, e.Name())
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, fmt.Errorf("extra file %s: %w", name, err)
+		}
+		switch {
+		case info.IsDir():
+			if slices.ContainsFunc(parents, func(d os.FileInfo) bool { return os.SameFile(d, info) }) {
+				return nil, fmt.Errorf("extra file %s: symlink cycle at %s", name, p)
+			}
+			if files, err = walkExtraDir(files, name, p, append(parents, info)); err != nil {
+				return nil, err
+			}
+		case info.Mode().IsRegular():
+			files = append(files, ExtraFile{Path: name, Source: p, Size: info.Size()})
+		default:
+			return nil, fmt.Errorf("extra file %s: %s is not a regular file", name, p)
+		}
+	}
+	return files, nil
+}
+
+// ExtraFiles returns the files of AddExtraFiles, sorted by path.
+func (idx *SwarmZimIndexer) ExtraFiles() []ExtraFile {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.extraFiles
+}
+
+// extraManifestEntry returns the entry of the EntriesFile of the file.
+func extraManifestEntry(f ExtraFile) ManifestEntry {
+	return ManifestEntry{
+		Path:     f.Path,
+		Title:    path.Base(f.Path),
+		MimeType: mime.TypeByExtension(path.Ext(f.Path)),
+		Size:     f.Size,
+		Extra:    true,
+	}
+}
+
+// checkExtraFiles fails with ErrExtraFileCollision for an extra file at
+// the path of an entry of the parse, or under one.
+func (idx *SwarmZimIndexer) checkExtraFiles() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for _, f := range idx.extraFiles {
+		for p := f.Path; p != "."; p = path.Dir(p) {
+			_, ok, err := idx.entries.Get(p)
+			if err != nil {
+				return err
+			}
+			if ok {
+				return fmt.Errorf("extra file %s of %s, entry %s of the zim: %w", f.Path, f.Source, p, ErrExtraFileCollision)
+			}
+		}
+	}
+	return nil
+}
+
+// writeExtraFiles appends the extra files to the tar, failing with
+// ErrExtraFileCollision for one at the path of a page of pages, or of one
+// of their directories, or under one.
+func (idx *SwarmZimIndexer) writeExtraFiles(w FileWriter, pages *pageNames) error {
+	files := idx.ExtraFiles()
+	if len(files) == 0 {
+		return nil
+	}
+	for _, f := range files {
+		if pages.dirs[f.Path] {
+			return fmt.Errorf("extra file %s of %s, directory of the pages: %w", f.Path, f.Source, ErrExtraFileCollision)
+		}
+		for p := f.Path; p != "."; p = path.Dir(p) {
+			if pages.names[p] {
+				return fmt.Errorf("extra file %s of %s, page %s: %w", f.Path, f.Source, p, ErrExtraFileCollision)
+			}
+		}
+	}
+	idx.logger().Info("appending extra files", "files", len(files), "tar", w.Name())
+	for _, f := range files {
+		if err := writeExtraFile(w, f); err != nil {
+			return fmt.Errorf("extra file %s: %w", f.Path, err)
+		}
+	}
+	return nil
+}
+
+// writeExtraFile appends the file to the tar, failing when its size
+// changed since AddExtraFiles.
+func writeExtraFile(w FileWriter, f ExtraFile) error {
+	r, err := os.Open(f.Source)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	info, err := r.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() != f.Size {
+		return fmt.Errorf("%s changed since added, %d bytes for %d", f.Source, info.Size(), f.Size)
+	}
+	return w.WriteFile(tarball.NewSizedReaderFile(f.Path, r, f.Size))
+}
+
+// pageNames records the names of the pages written through it, and their
+// directories.
+type pageNames struct {
+	FileWriter
+	names, dirs map[string]bool
+}
+
+func newPageNames(w FileWriter) *pageNames {
+	return &pageNames{FileWriter: w, names: make(map[string]bool), dirs: make(map[string]bool)}
+}
+
+func (p *pageNames) WriteFile(f *tarball.File) error {
+	name := path.Clean(f.Name())
+	p.names[name] = true
+	for d := path.Dir(name); d != "."; d = path.Dir(d) {
+		p.dirs[d] = true
+	}
+	return p.FileWriter.WriteFile(f)
+}
@@ -0,0 +1,462 @@
+package indexer
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/r0qs/beezim/internal/progress"
+	"github.com/r0qs/beezim/internal/tarball"
+)
+
+// Overwrite is what UnZim does with the files already in the directory it
+// extracts to, see SwarmZimIndexer.Overwrite.
+type Overwrite string
+
+const (
+	// OverwriteAlways writes the files again.
+	OverwriteAlways Overwrite = ""
+	// OverwriteSkipSameSize keeps the files of the size of their article,
+	// e.g. those of an extraction stopped before its end, and writes the
+	// others again.
+	OverwriteSkipSameSize Overwrite = "skip-same-size"
+	// OverwriteFail writes none of the files that exist, each failing
+	// with ErrFileExists.
+	OverwriteFail Overwrite = "fail"
+)
+
+// ErrFileExists is returned by UnZim with OverwriteFail for the files
+// already in the directory.
+var ErrFileExists = errors.New("file exists")
+
+// ParseOverwrite returns the Overwrite named s, "always" being
+// OverwriteAlways.
+func ParseOverwrite(s string) (Overwrite, error) {
+	switch o := Overwrite(s); o {
+	case OverwriteAlways, OverwriteSkipSameSize, OverwriteFail:
+		return o, nil
+	case "always":
+		return OverwriteAlways, nil
+	}
+	return OverwriteAlways, fmt.Errorf("invalid overwrite %q, use always, %s or %s", s, OverwriteSkipSameSize, OverwriteFail)
+}
+
+// maxExtractErrors is the number of errors of the files not written
+// returned by UnZim, the others being counted.
+const maxExtractErrors = 100
+
+// UnZim writes the articles received to outputDir.
+func (idx *SwarmZimIndexer) UnZim(ctx context.Context, outputDir string, files <-chan Article) error {
+	return idx.UnZimBatches(ctx, outputDir, idx.batchesOf(ctx, files))
+}
+
+// UnZimBatches writes the batches of articles received to outputDir, with
+// ExtractWorkers files written at the same time. A file that can not be
+// written does not stop the others: the errors of the files are joined
+// once all were written. The parse is stopped right away when the space
+// check fails, or OnEntryError for an entry whose path can not be written.
+func (idx *SwarmZimIndexer) UnZimBatches(ctx context.Context, outputDir string, batches <-chan []Article) (err error) {
+	defer func() {
+		if err != nil {
+			idx.abortParse(batches)
+		}
+	}()
+	s, err := idx.newExtractSink(ctx, outputDir)
+	if err != nil {
+		return err
+	}
+	defer s.wait()
+	for batch := range batches {
+		for i := range batch {
+			if err := s.write(&batch[i]); err != nil {
+				releaseAll(batch[i+1:])
+				return err
+			}
+		}
+	}
+	if err := s.wait(); err != nil {
+		return err
+	}
+	if err := idx.ParseErr(); err != nil {
+		return err
+	}
+	return s.finish()
+}
+
+// extractSink writes articles to their files under a directory.
+type extractSink struct {
+	idx       *SwarmZimIndexer
+	outputDir string
+	copySize  int
+	overwrite Overwrite
+	rep       progress.Reporter
+	// names are the names of the files, given in the order of the parse.
+	names *fsNames
+	// jobs are the articles sent to the workers, nil with a single one,
+	// the sink then writing the articles itself.
+	jobs chan extractJob
+	wg   sync.WaitGroup
+
+	// mu guards the fields below, shared by the workers.
+	mu sync.Mutex
+	// dirs are the directories created or checked under outputDir.
+	dirs  map[string]bool
+	e     progress.Event
+	space spaceChecker
+	// kept is the number of files left as they were by
+	// OverwriteSkipSameSize, failed the number of files not written and
+	// errs the first maxExtractErrors of their errors.
+	kept   int
+	failed int
+	errs   []error
+	// exceptions are the entries whose path could not be written, whose
+	// exception files are written once the others are.
+	exceptions []EntryError
+	// stopped is why the extraction stopped, e.g. the disk being full.
+	stopped error
+}
+
+// extractJob is an article sent to the workers, written to name.
+type extractJob struct {
+	file Article
+	name string
+}
+
+func (idx *SwarmZimIndexer) newExtractSink(ctx context.Context, outputDir string) (*extractSink, error) {
+	if idx.CheckpointEvery > 0 {
+		return nil, errCheckpointSink
+	}
+	if _, err := os.Stat(outputDir); os.IsNotExist(err) {
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	s := &extractSink{
+		idx:       idx,
+		outputDir: outputDir,
+		copySize:  idx.Buffers.WithDefaults().FileCopy,
+		overwrite: idx.Overwrite,
+		rep:       reporter(ctx),
+		names:     newFSNames(),
+		dirs:      make(map[string]bool),
+		e:         progress.Event{Stage: "extract"},
+		space:     spaceChecker{check: idx.SpaceCheck},
+	}
+	if workers := idx.ExtractWorkers; workers > 1 {
+		s.jobs = make(chan extractJob, workers)
+		for w := 0; w < workers; w++ {
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				for j := range s.jobs {
+					s.extract(&j.file, j.name)
+				}
+			}()
+		}
+	}
+	return s, nil
+}
+
+// write sends the article to the workers, or writes it without, and
+// returns why the extraction stopped, if it did.
+func (s *extractSink) write(file *Article) error {
+	if err := s.err(); err != nil {
+		file.Release()
+		return err
+	}
+	name := s.names.name(file.path)
+	if s.jobs == nil {
+		s.extract(file, name)
+		return s.err()
+	}
+	s.jobs <- extractJob{file: *file, name: name}
+	return nil
+}
+
+// extract writes the article to its file at name and releases it. When
+// the path can not be written, its exception file is written once the
+// other files are.
+func (s *extractSink) extract(file *Article, name string) {
+	defer file.Release()
+	if s.err() != nil {
+		return
+	}
+	kept := false
+	err := file.decompress()
+	if err == nil {
+		kept, err = s.writeFile(file, name)
+	}
+	if errors.Is(err, tarball.ErrUnsafeName) || errors.Is(err, errOutsideDir) {
+		e := EntryError{Index: file.index, URL: file.path, Err: err}
+		if err := s.idx.skipEntry(e); err != nil {
+			s.stop(err)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.exceptions = append(s.exceptions, e)
+		return
+	}
+	s.done(file, kept, err)
+}
+
+// writeExceptions writes the exception files of the entries whose path
+// could not be written, by url index so that they get the same names
+// whatever the workers.
+func (s *extractSink) writeExceptions() {
+	slices.SortFunc(s.exceptions, func(a, b EntryError) int {
+		return cmp.Compare(a.Index, b.Index)
+	})
+	for _, e := range s.exceptions {
+		if s.err() != nil {
+			return
+		}
+		ex := s.idx.exception(e.Index, e.URL, e.Err)
+		kept, err := s.writeFile(&ex, s.names.name(ex.path))
+		s.done(&ex, kept, err)
+	}
+}
+
+// writeAssets writes the assets the tars get with the search pages, see
+// MakeAssets.
+func (s *extractSink) writeAssets() error {
+	t, err := s.idx.pageTheme()
+	if err != nil {
+		return err
+	}
+	if err := t.walkAssets(func(name string, data []byte) error {
+		a := Article{path: name, data: data}
+		kept, err := s.writeFile(&a, s.names.name(name))
+		s.done(&a, kept, err)
+		return s.err()
+	}); err != nil {
+		return err
+	}
+	return s.err()
+}
+
+// writeStylesheet writes the stylesheet of SetTheme, when not among the
+// assets written.
+func (s *extractSink) writeStylesheet() error {
+	name, css, err := s.idx.stylesheet(s.idx.enableSearch)
+	if err != nil || name == "" {
+		return err
+	}
+	a := Article{path: name, data: css}
+	kept, err := s.writeFile(&a, s.names.name(name))
+	s.done(&a, kept, err)
+	return s.err()
+}
+
+// writeNavBarStylesheet writes the NavBarStylesheet with NavBar, when not
+// among the assets written.
+func (s *extractSink) writeNavBarStylesheet() error {
+	if !s.idx.NavBar || s.idx.enableSearch {
+		return nil
+	}
+	css, err := s.idx.navBarStylesheet()
+	if err != nil {
+		return err
+	}
+	a := Article{path: NavBarStylesheet, data: css}
+	kept, err := s.writeFile(&a, s.names.name(NavBarStylesheet))
+	s.done(&a, kept, err)
+	return s.err()
+}
+
+// writeFavicon writes the FaviconFile the pages link to.
+func (s *extractSink) writeFavicon() error {
+	data, err := s.idx.faviconData()
+	if err != nil {
+		return err
+	}
+	a := Article{path: FaviconFile, data: data}
+	kept, err := s.writeFile(&a, s.names.name(FaviconFile))
+	s.done(&a, kept, err)
+	return s.err()
+}
+
+// done counts the file written, or its error.
+func (s *extractSink) done(file *Article, kept bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.idx.logger().Warn("file not written", "article", file.path, "err", err)
+		s.failed++
+		if len(s.errs) < maxExtractErrors {
+			s.errs = append(s.errs, fmt.Errorf("%s: %w", file.path, err))
+		}
+		return
+	}
+	if kept {
+		s.kept++
+	}
+	s.e.Done++
+	s.e.Bytes += file.Size()
+	if err := s.space.add(int(file.Size())); err != nil {
+		s.stopped = fmt.Errorf("%s: %w", s.outputDir, err)
+		return
+	}
+	s.rep.Report(s.e)
+}
+
+// stop stops the extraction with err, unless it stopped before.
+func (s *extractSink) stop(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped == nil {
+		s.stopped = err
+	}
+}
+
+// err returns why the extraction stopped, if it did.
+func (s *extractSink) err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopped
+}
+
+// wait waits for the workers to write the articles sent, and returns why
+// the extraction stopped, if it did.
+func (s *extractSink) wait() error {
+	if s.jobs != nil {
+		close(s.jobs)
+		s.wg.Wait()
+		s.jobs = nil
+	}
+	return s.err()
+}
+
+// finish writes the exception files, the assets of the search pages, the
+// stylesheet of SetTheme, the FaviconFile and the PathsFile, reports the end of the stage, and returns the errors of
+// the files not written.
+func (s *extractSink) finish() error {
+	s.writeExceptions()
+	if err := s.err(); err != nil {
+		return err
+	}
+	if s.idx.enableSearch {
+		if err := s.writeAssets(); err != nil {
+			return err
+		}
+	}
+	if err := s.writeStylesheet(); err != nil {
+		return err
+	}
+	if err := s.writeNavBarStylesheet(); err != nil {
+		return err
+	}
+	if err := s.writeFavicon(); err != nil {
+		return err
+	}
+	if err := s.names.writePaths(s.outputDir); err != nil {
+		return err
+	}
+	s.e.Finished = true
+	s.rep.Report(s.e)
+	if s.kept > 0 {
+		s.idx.logger().Info("files already extracted kept", "dir", s.outputDir, "files", s.kept)
+	}
+	if s.failed == 0 {
+		return nil
+	}
+	errs := s.errs
+	if n := s.failed - len(errs); n > 0 {
+		errs = append(errs, fmt.Errorf("and %d more", n))
+	}
+	return fmt.Errorf("%d files not written to %s: %w", s.failed, s.outputDir, errors.Join(errs...))
+}
+
+// writeFile writes the article to name under outputDir, following the
+// Overwrite policy, and reports whether the file there was kept.
+func (s *extractSink) writeFile(file *Article, name string) (bool, error) {
+	filePath, err := tarball.LocalPath(s.outputDir, name)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(s.outputDir, filePath)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	err = s.mkdirs(filepath.Dir(rel))
+	s.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+	if info, err := os.Lstat(filePath); err == nil {
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			// a link left in the directory would be followed by Create
+			return false, fmt.Errorf("%s: %w", rel, errOutsideDir)
+		case s.overwrite == OverwriteFail:
+			return false, ErrFileExists
+		case s.overwrite == OverwriteSkipSameSize && info.Mode().IsRegular() && info.Size() == file.Size():
+			return true, nil
+		}
+	}
+
+	f, err := os.Create(filePath)
+	if err != nil {
+		return false, err
+	}
+	write := writeChunks
+	if file.streamed() {
+		write = copyStreamed
+	}
+	if err := write(f, file, s.copySize); err != nil {
+		f.Close()
+		return false, err
+	}
+	return false, f.Close()
+}
+
+// writeChunks writes data to f in writes of at most size bytes. The data
+// being in memory, it needs no buffer of its own: one allocated per file
+// made the extraction of small files bound by the allocations.
+func writeChunks(f *os.File, a *Article, size int) error {
+	data := a.data
+	for len(data) > 0 {
+		n := min(len(data), size)
+		if _, err := f.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// errOutsideDir is returned for the files whose path goes through a
+// symbolic link of the extracted directory, which may lead out of it.
+var errOutsideDir = errors.New("path goes through a symbolic link")
+
+// mkdirs creates the directory dir of outputDir and its parents, one at a
+// time so that none of them is a symbolic link. It is called with mu
+// held.
+func (s *extractSink) mkdirs(dir string) error {
+	if dir == "." || s.dirs[dir] {
+		return nil
+	}
+	if err := s.mkdirs(filepath.Dir(dir)); err != nil {
+		return err
+	}
+	p := filepath.Join(s.outputDir, dir)
+	info, err := os.Lstat(p)
+	switch {
+	case os.IsNotExist(err):
+		if err := os.Mkdir(p, 0755); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case info.Mode()&os.ModeSymlink != 0:
+		return fmt.Errorf("%s: %w", dir, errOutsideDir)
+	}
+	s.dirs[dir] = true
+	return nil
+}
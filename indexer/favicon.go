@@ -0,0 +1,81 @@
+package indexer
+
+import (
+	"io/fs"
+	"path/filepath"
+
+	"github.com/r0qs/beezim/internal/tarball"
+)
+
+// FaviconFile is the name in the tars of the favicon of the ZIM, which the
+// pages link to, see MakeFavicon.
+const FaviconFile = "_beezim/favicon.png"
+
+// defaultFavicon is the asset of the favicon of the ZIMs without one.
+const defaultFavicon = "img/favicon.png"
+
+// faviconEntries are the entries of the favicon of a ZIM, by preference:
+// the illustration of the current format, and the favicon of the older
+// ones.
+var faviconEntries = []string{illustrationEntry, "M/Favicon", "-/favicon"}
+
+// zimFavicon returns the content of the favicon of the ZIM, read once,
+// nil without one. A parse reads it before locking the reader.
+func (idx *SwarmZimIndexer) zimFavicon() []byte {
+	idx.faviconOnce.Do(func() {
+		zimMu.Lock()
+		defer zimMu.Unlock()
+		for _, url := range faviconEntries {
+			entry, ok := idx.findEntry(url)
+			if !ok || entry.IsDeleted() {
+				continue
+			}
+			if entry.IsRedirect() {
+				target, err := idx.redirectTarget(entry)
+				if err != nil {
+					continue
+				}
+				entry = target
+			}
+			data, err := entry.Data()
+			if err != nil {
+				idx.logger().Warn("error reading zim favicon", "file", filepath.Base(idx.ZimPath), "entry", url, "err", err)
+				continue
+			}
+			if len(data) > 0 {
+				idx.favicon = data
+				return
+			}
+		}
+	})
+	return idx.favicon
+}
+
+// faviconData returns the favicon of the ZIM, or else the default one of
+// the assets.
+func (idx *SwarmZimIndexer) faviconData() ([]byte, error) {
+	if data := idx.zimFavicon(); data != nil {
+		return data, nil
+	}
+	t, err := idx.pageTheme()
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadFile(t.assets, defaultFavicon)
+}
+
+// MakeFavicon appends the favicon of the ZIM, its Illustration_48x48@1
+// metadata or the Favicon of the older ZIMs, to the tar as FaviconFile,
+// the default one of the assets when it has none.
+func (idx *SwarmZimIndexer) MakeFavicon(tarFile string) error {
+	return idx.writeFavicon(AppendTo(tarFile))
+}
+
+func (idx *SwarmZimIndexer) writeFavicon(w FileWriter) error {
+	data, err := idx.faviconData()
+	if err != nil {
+		return err
+	}
+	idx.logger().Info("appending file", "file", FaviconFile, "tar", w.Name())
+	return w.WriteFile(tarball.NewBytesFile(FaviconFile, data))
+}
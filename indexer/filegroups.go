@@ -0,0 +1,324 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"iter"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// The groups of the files page, which lists GroupArticles, GroupMedia,
+// GroupAssets and GroupOther first, then the groups of FileGroup by name
+// and GroupExceptions last.
+const (
+	GroupArticles   = "Articles"
+	GroupMedia      = "Media"
+	GroupAssets     = "Assets"
+	GroupOther      = "Other"
+	GroupExceptions = "Exceptions"
+)
+
+// DefaultFileGroup returns the group of the files page of the entry, by
+// its MIME type then its namespace: the HTML pages, and the redirects of
+// the A and C namespaces, are Articles, the images, videos and sounds,
+// and the I and J namespaces, Media, the - namespace and the rest of the
+// C one Assets, and the others, e.g. the metadata and the search indexes,
+// Other.
+func DefaultFileGroup(e IndexEntry) string {
+	ns, _, _ := strings.Cut(e.Path, "/")
+	if ns == "M" || ns == "X" {
+		return GroupOther
+	}
+	mime := baseMime(e.Metadata.MimeType)
+	switch {
+	case mime == "text/html":
+		return GroupArticles
+	case strings.HasPrefix(mime, "image/"), strings.HasPrefix(mime, "video/"), strings.HasPrefix(mime, "audio/"):
+		return GroupMedia
+	}
+	switch ns {
+	case "A":
+		return GroupArticles
+	case "I", "J":
+		return GroupMedia
+	case "-":
+		return GroupAssets
+	case "C":
+		if e.Metadata.Redirect {
+			return GroupArticles
+		}
+		return GroupAssets
+	default:
+		return GroupOther
+	}
+}
+
+// fileGroupOf returns the group of the files page of the entry, of
+// FileGroup when set and not empty.
+func (idx *SwarmZimIndexer) fileGroupOf(e IndexEntry) string {
+	if idx.FileGroup != nil {
+		if g := idx.FileGroup(e); g != "" {
+			return g
+		}
+	}
+	return DefaultFileGroup(e)
+}
+
+// fileGroupRank orders the groups of the files page.
+func fileGroupRank(g string) int {
+	switch g {
+	case GroupArticles:
+		return 0
+	case GroupMedia:
+		return 1
+	case GroupAssets:
+		return 2
+	case GroupOther:
+		return 3
+	case GroupExceptions:
+		return 5
+	default:
+		return 4
+	}
+}
+
+// fileGroupTitle returns the title of the group, translated for the
+// default ones.
+func (idx *SwarmZimIndexer) fileGroupTitle(g string) string {
+	switch g {
+	case GroupArticles, GroupMedia, GroupAssets, GroupOther, GroupExceptions:
+		return idx.pageLocale().T[g]
+	}
+	return g
+}
+
+// fileGroup is a group of the files page, whose Nodes are read as the page
+// is rendered.
+type fileGroup struct {
+	Path string
+	// Title is the name of the group shown, in the language of the pages
+	// for the default ones.
+	Title string
+	Count int
+	nodes iter.Seq[*Node]
+	// stop ends the Nodes of the groups once the page is rendered, wg
+	// waits for them.
+	stop <-chan struct{}
+	wg   *sync.WaitGroup
+}
+
+// Nodes returns the entries of the group, by title.
+func (g *fileGroup) Nodes() <-chan *Node {
+	c := make(chan *Node, 64)
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer close(c)
+		for n := range g.nodes {
+			select {
+			case c <- n:
+			case <-g.stop:
+				return
+			}
+		}
+	}()
+	return c
+}
+
+// nodeKey is the order of the nodes of a group: by title in lower case,
+// the path for the nodes without title, then by path.
+func nodeKey(n *Node) string {
+	title := n.Title
+	if title == "" {
+		title = n.Path
+	}
+	return strings.ToLower(title) + "\x00" + n.Path
+}
+
+// groupedNodes are the nodes of the files page by group, sorted by
+// nodeKey: in memory, or in a temporary LevelDB database of the directory
+// of a DiskStore, so that they are never held in memory all at once.
+type groupedNodes struct {
+	counts map[string]int
+	mem    map[string][]keyedNode
+	tmp    string
+	db     *leveldb.DB
+	batch  leveldb.Batch
+}
+
+func newGroupedNodes(dir string) (*groupedNodes, error) {
+	g := &groupedNodes{counts: make(map[string]int)}
+	if dir == "" {
+		g.mem = make(map[string][]keyedNode)
+		return g, nil
+	}
+	tmp, err := os.MkdirTemp(dir, "files-")
+	if err != nil {
+		return nil, err
+	}
+	db, err := leveldb.OpenFile(tmp, &opt.Options{NoSync: true})
+	if err != nil {
+		os.RemoveAll(tmp)
+		return nil, fmt.Errorf("files page: %w", err)
+	}
+	g.tmp, g.db = tmp, db
+	return g, nil
+}
+
+// keyedNode is a node in memory and its nodeKey.
+type keyedNode struct {
+	key  string
+	node *Node
+}
+
+func (g *groupedNodes) add(group string, n *Node) error {
+	g.counts[group]++
+	if g.db == nil {
+		g.mem[group] = append(g.mem[group], keyedNode{nodeKey(n), n})
+		return nil
+	}
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	g.batch.Put([]byte(group+"\x00"+nodeKey(n)), data)
+	if g.batch.Len() >= diskBatchSize {
+		return g.flush()
+	}
+	return nil
+}
+
+func (g *groupedNodes) flush() error {
+	if g.db == nil || g.batch.Len() == 0 {
+		return nil
+	}
+	if err := g.db.Write(&g.batch, nil); err != nil {
+		return fmt.Errorf("files page: %w", err)
+	}
+	g.batch.Reset()
+	return nil
+}
+
+// sort sorts the nodes of every group, once they are all added.
+func (g *groupedNodes) sort() error {
+	for _, nodes := range g.mem {
+		slices.SortFunc(nodes, func(a, b keyedNode) int {
+			return strings.Compare(a.key, b.key)
+		})
+	}
+	return g.flush()
+}
+
+// nodes returns the nodes of the group, recording the errors of the
+// database in the indexer.
+func (g *groupedNodes) nodes(idx *SwarmZimIndexer, group string) iter.Seq[*Node] {
+	if g.db == nil {
+		return func(yield func(*Node) bool) {
+			for _, n := range g.mem[group] {
+				if !yield(n.node) {
+					return
+				}
+			}
+		}
+	}
+	return func(yield func(*Node) bool) {
+		it := g.db.NewIterator(util.BytesPrefix([]byte(group+"\x00")), nil)
+		defer it.Release()
+		for it.Next() {
+			n := &Node{}
+			if err := json.Unmarshal(it.Value(), n); err != nil {
+				idx.recordGroupErr(err)
+				return
+			}
+			if !yield(n) {
+				return
+			}
+		}
+		idx.recordGroupErr(it.Error())
+	}
+}
+
+// recordGroupErr fails the pages listing the entries with the error of
+// the nodes of the files page.
+func (idx *SwarmZimIndexer) recordGroupErr(err error) {
+	if err == nil {
+		return
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.recordEntriesErr(fmt.Errorf("files page: %w", err))
+}
+
+func (g *groupedNodes) close() error {
+	if g.db == nil {
+		return nil
+	}
+	err := g.db.Close()
+	if rmErr := os.RemoveAll(g.tmp); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// fileGroups returns the groups of the entries of the parses of the
+// indexer listed by the files page, see DefaultFileGroup, the exception
+// files, listed by their own path, in GroupExceptions. The groups hold
+// their nodes until release is called, once the page is rendered.
+func (idx *SwarmZimIndexer) fileGroups(stop <-chan struct{}, wg *sync.WaitGroup) (groups []*fileGroup, release func() error, err error) {
+	idx.mu.Lock()
+	dir := spoolDir(idx.entries)
+	idx.mu.Unlock()
+	nodes, err := newGroupedNodes(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	idx.ForEachEntry(func(entry IndexEntry) bool {
+		switch {
+		case entry.Metadata.Skipped != "":
+		case entry.Metadata.Exception != "":
+			// the exception file is listed instead of the entry
+			n := fileNode(entry)
+			n.Path = entry.Metadata.Exception
+			err = nodes.add(GroupExceptions, n)
+		default:
+			err = nodes.add(idx.fileGroupOf(entry), fileNode(entry))
+		}
+		return err == nil
+	})
+	if err == nil {
+		err = nodes.sort()
+	}
+	if err == nil {
+		err = idx.EntriesErr()
+	}
+	if err != nil {
+		nodes.close()
+		return nil, nil, err
+	}
+
+	ids := slices.SortedFunc(maps.Keys(nodes.counts), func(a, b string) int {
+		if ra, rb := fileGroupRank(a), fileGroupRank(b); ra != rb {
+			return ra - rb
+		}
+		return strings.Compare(a, b)
+	})
+	for _, id := range ids {
+		groups = append(groups, &fileGroup{
+			Path:  id,
+			Title: idx.fileGroupTitle(id),
+			Count: nodes.counts[id],
+			nodes: nodes.nodes(idx, id),
+			stop:  stop,
+			wg:    wg,
+		})
+	}
+	return groups, nodes.close, nil
+}
@@ -0,0 +1,104 @@
+package indexer_test
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/indexer/zimtest"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+var update = flag.Bool("update", false, "write the golden files of the tests")
+
+// groupsZim returns a reader of entries of every group of the files page,
+// out of the order of their titles.
+func groupsZim() *zimtest.Reader {
+	return zimtest.New(
+		zimtest.Entry{Namespace: 'A', URL: "Zebra", Title: "Zebra", Mime: "text/html", Content: []byte("<p>Zebra</p>")},
+		zimtest.Entry{Namespace: 'A', URL: "Dir/apple", Title: "apple", Mime: "text/html", Content: []byte("<p>apple</p>")},
+		zimtest.Redirect('A', "Home", 0),
+		zimtest.Entry{Namespace: 'A', URL: "Chart.svg", Title: "Chart", Mime: "image/svg+xml", Content: []byte("<svg></svg>")},
+		zimtest.Entry{Namespace: 'I', URL: "logo.png", Mime: "image/png", Content: []byte("\x89PNG\r\n\x1a\n")},
+		zimtest.Entry{Namespace: '-', URL: "style.css", Mime: "text/css", Content: []byte("p{margin:0}")},
+		zimtest.Entry{Namespace: '-', URL: "app.js", Mime: "application/javascript", Content: []byte("var a;")},
+		zimtest.Entry{Namespace: 'M', URL: "Title", Mime: "text/plain", Content: []byte("Groups")},
+	)
+}
+
+// filesPage returns the main element of the files page of the ZIM, with
+// its metadata parsed.
+func filesPage(t *testing.T, r *zimtest.Reader, fileGroup func(indexer.IndexEntry) string) []byte {
+	t.Helper()
+	idx := indexer.NewWithReader("test.zim", r, true)
+	idx.Logger = logging.Discard()
+	idx.FileGroup = fileGroup
+	t.Cleanup(func() { idx.Close() })
+	tarFile := tarZim(t, idx)
+	if err := idx.MakeIndexPage(tarFile, indexer.IndexSearch); err != nil {
+		t.Fatal(err)
+	}
+	page := readTar(t, tarFile)["files.html"]
+	start, end := bytes.Index(page, []byte(`<main id="main">`)), bytes.Index(page, []byte("</main>"))
+	if start < 0 || end < start {
+		t.Fatalf("no main element in the files page:\n%s", page)
+	}
+	return page[start : end+len("</main>\n")]
+}
+
+// TestFilesPageGolden renders the groups of the files page, the layout
+// around them being that of every page.
+func TestFilesPageGolden(t *testing.T) {
+	got := filesPage(t, groupsZim(), nil)
+	golden := filepath.Join("testdata", "files.html")
+	if *update {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got\n%s\nwant the page of %s, go test -update writes it:\n%s", got, golden, want)
+	}
+}
+
+// groupIDs returns the ids of the groups of the files page, in order.
+func groupIDs(page []byte) []string {
+	var ids []string
+	for _, m := range regexp.MustCompile(`id="heading-([^"]+)"`).FindAllSubmatch(page, -1) {
+		ids = append(ids, string(m[1]))
+	}
+	return ids
+}
+
+func TestFileGroupHook(t *testing.T) {
+	// the svg images are maps, listed after the default groups, and the
+	// scripts are moved to Other
+	page := filesPage(t, groupsZim(), func(e indexer.IndexEntry) string {
+		switch {
+		case strings.HasSuffix(e.Path, ".svg"):
+			return "Maps"
+		case e.Metadata.MimeType == "application/javascript":
+			return indexer.GroupOther
+		}
+		return ""
+	})
+	want := []string{indexer.GroupArticles, indexer.GroupMedia, indexer.GroupAssets, indexer.GroupOther, "Maps"}
+	if ids := groupIDs(page); !slices.Equal(ids, want) {
+		t.Errorf("got groups %v, want %v", ids, want)
+	}
+	for _, want := range []string{"Maps (1)", "Other (2)", "Assets (1)"} {
+		if !bytes.Contains(page, []byte(want)) {
+			t.Errorf("no %q in the page:\n%s", want, page)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package indexer
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/r0qs/beezim/internal/tarball"
+)
+
+const (
+	paxZimSHA256 = "BEEZIM.zimsha256"
+	paxFilters   = "BEEZIM.filters"
+)
+
+// ErrNoFingerprint is returned when reading the fingerprint of a tar
+// built before fingerprints were recorded.
+var ErrNoFingerprint = errors.New("tar has no fingerprint")
+
+// Fingerprint identifies the ZIM and the parse options a tar was built
+// from. It is recorded in a PAX global header at the start of the tar.
+type Fingerprint struct {
+	ZimSHA256 string
+	// Filters is a canonical representation of the options that change
+	// the parsed content.
+	Filters string
+}
+
+func (f Fingerprint) String() string {
+	return fmt.Sprintf("zim sha256 %s, filters %q", f.ZimSHA256, f.Filters)
+}
+
+func (f Fingerprint) header() *tar.Header {
+	return &tar.Header{
+		Typeflag: tar.TypeXGlobalHeader,
+		Name:     "pax_global_header",
+		PAXRecords: map[string]string{
+			paxZimSHA256: f.ZimSHA256,
+			paxFilters:   f.Filters,
+		},
+	}
+}
+
+// ReadFingerprint reads the fingerprint recorded in the tar.
+func ReadFingerprint(tarFile string) (Fingerprint, error) {
+	f, err := tarball.Open(tarFile)
+	if err != nil {
+		return Fingerprint{}, err
+	}
+	defer f.Close()
+
+	hdr, err := tar.NewReader(f).Next()
+	if errors.Is(err, io.EOF) {
+		return Fingerprint{}, ErrNoFingerprint
+	}
+	if err != nil {
+		return Fingerprint{}, fmt.Errorf("error reading tar %s: %w", tarFile, err)
+	}
+
+	sum, ok := hdr.PAXRecords[paxZimSHA256]
+	if hdr.Typeflag != tar.TypeXGlobalHeader || !ok {
+		return Fingerprint{}, ErrNoFingerprint
+	}
+	return Fingerprint{ZimSHA256: sum, Filters: hdr.PAXRecords[paxFilters]}, nil
+}
@@ -0,0 +1,205 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// PathsFile lists the articles UnZim gives another name than their path,
+// in the directory extracted.
+const PathsFile = "paths.json"
+
+// FilePath is the name Path UnZim gives the article at URL, the path of
+// the article in the tars.
+type FilePath struct {
+	Path string `json:"path"`
+	URL  string `json:"url"`
+}
+
+// ReadPaths returns the files listed in the PathsFile of the extracted
+// directory, none when it has not any.
+func ReadPaths(dir string) ([]FilePath, error) {
+	data, err := os.ReadFile(filepath.Join(dir, PathsFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var paths []FilePath
+	if err := json.Unmarshal(data, &paths); err != nil {
+		return nil, fmt.Errorf("%s: %w", PathsFile, err)
+	}
+	return paths, nil
+}
+
+// fsNames gives the articles extracted names that every filesystem can
+// write, the tars keeping their paths. The characters Windows refuses and
+// "%" are percent-encoded, as are the trailing dot or space it drops and
+// the first character of its reserved names, e.g. CON. The names equal
+// to one written before once case and unicode normalization are folded,
+// which NTFS and macOS take for the same file, get a "~n" suffix before
+// their extension, as do a file and a directory of the same name, e.g.
+// A/Foo and A/Foo/Bar: the first article of the parse keeps its name.
+type fsNames struct {
+	// used maps the hash of the folded name of each file and directory
+	// to the hash of the name written, with a trailing slash for the
+	// directories.
+	used map[uint64]uint64
+	// dirs maps the directories of the tars to their name.
+	dirs map[string]string
+	// renamed are the articles given another name than their path.
+	renamed []FilePath
+}
+
+func newFSNames() *fsNames {
+	return &fsNames{used: make(map[uint64]uint64), dirs: make(map[string]string)}
+}
+
+// name returns the slash separated name the article at p is written to.
+// The same p gets the same name, so it is called in the order of the
+// parse.
+func (n *fsNames) name(p string) string {
+	dir, base := path.Split(p)
+	parent := ""
+	if dir != "" {
+		parent = n.dir(strings.TrimSuffix(dir, "/"))
+	}
+	name := n.element(parent, base, false)
+	if name != p {
+		n.renamed = append(n.renamed, FilePath{Path: name, URL: p})
+	}
+	return name
+}
+
+// dir returns the name of the directory d of the tars.
+func (n *fsNames) dir(d string) string {
+	if name, ok := n.dirs[d]; ok {
+		return name
+	}
+	parent, base := "", d
+	if i := strings.LastIndexByte(d, '/'); i >= 0 {
+		parent, base = n.dir(d[:i]), d[i+1:]
+	}
+	name := n.element(parent, base, true)
+	n.dirs[d] = name
+	return name
+}
+
+// element returns the name of the file or directory elem of the directory
+// named parent, once escaped and told apart from the names written.
+func (n *fsNames) element(parent string, elem string, dir bool) string {
+	escaped := escapeName(elem)
+	name := path.Join(parent, escaped)
+	for i := 1; ; i++ {
+		key, sum := foldHash(name), hashName(name)
+		if dir {
+			sum = hashName(name + "/")
+		}
+		if owner, ok := n.used[key]; !ok {
+			n.used[key] = sum
+			return name
+		} else if owner == sum {
+			return name
+		}
+		name = path.Join(parent, withSuffix(escaped, i))
+	}
+}
+
+// reservedNames are the names Windows refuses for a file, whatever its
+// extension and case, along with COM and LPT followed by a digit.
+var reservedNames = map[string]bool{"CON": true, "PRN": true, "AUX": true, "NUL": true, "CONIN$": true, "CONOUT$": true}
+
+// escapeName percent-encodes the characters of elem that can not be
+// written on every filesystem.
+func escapeName(elem string) string {
+	reserved := isReservedName(elem)
+	escape := func(i int) bool {
+		c := elem[i]
+		return c < 0x20 || strings.IndexByte(`<>:"\|?*%`, c) >= 0 ||
+			i == len(elem)-1 && (c == '.' || c == ' ') ||
+			i == 0 && reserved
+	}
+	i := 0
+	for i < len(elem) && !escape(i) {
+		i++
+	}
+	if i == len(elem) {
+		return elem
+	}
+	var b strings.Builder
+	b.WriteString(elem[:i])
+	for ; i < len(elem); i++ {
+		if escape(i) {
+			fmt.Fprintf(&b, "%%%02X", elem[i])
+		} else {
+			b.WriteByte(elem[i])
+		}
+	}
+	return b.String()
+}
+
+// isReservedName reports whether Windows takes elem for a device.
+func isReservedName(elem string) bool {
+	base, _, _ := strings.Cut(elem, ".")
+	base = strings.ToUpper(strings.TrimRight(base, " "))
+	if reservedNames[base] {
+		return true
+	}
+	if len(base) < 4 || (base[:3] != "COM" && base[:3] != "LPT") {
+		return false
+	}
+	switch base[3:] {
+	case "0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "¹", "²", "³":
+		return true
+	}
+	return false
+}
+
+// withSuffix returns the escaped name with the suffix ~i before its
+// extension.
+func withSuffix(escaped string, i int) string {
+	ext := path.Ext(escaped)
+	if ext == escaped {
+		ext = ""
+	}
+	return strings.TrimSuffix(escaped, ext) + "~" + strconv.Itoa(i) + ext
+}
+
+// foldHash returns the hash of name once case and unicode normalization
+// are folded.
+func foldHash(name string) uint64 {
+	return hashName(strings.ToLower(norm.NFC.String(name)))
+}
+
+// hashName returns the FNV hash of name, which keeps fsNames small for the
+// millions of files of the largest wikis.
+func hashName(name string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return h.Sum64()
+}
+
+// writePaths writes the PathsFile of the files renamed to dir, or removes
+// the one of a previous extraction when none was.
+func (n *fsNames) writePaths(dir string) error {
+	file := filepath.Join(dir, PathsFile)
+	if len(n.renamed) == 0 {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(n.renamed, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
@@ -0,0 +1,121 @@
+//go:build !windows
+
+package indexer
+
+import (
+	"errors"
+	"os"
+
+	zim "github.com/akhenakh/gozim"
+)
+
+// gozim memory maps the ZIM with syscall.Mmap, which windows does not
+// have, so the readers it opens can only be adapted on the other
+// platforms. NewReader reads the ZIMs on all of them.
+
+// NewFromReader returns an indexer of the ZIM already opened as z, so that
+// several passes over the ZIM share one reader instead of opening and
+// mapping the file again. The caller owns z, as with NewWithReader.
+func NewFromReader(z *zim.ZimReader, zimPath string, enableSearch bool, opts ...Option) *SwarmZimIndexer {
+	return NewWithReader(zimPath, newGozimReader(z, zimPath), enableSearch, opts...)
+}
+
+// gozimReader adapts a reader opened with gozim, which only reads the
+// version 5.0 of the format.
+type gozimReader struct {
+	z *zim.ZimReader
+	// clusters is the number of clusters of the ZIM, zero when its header
+	// could not be read.
+	clusters uint32
+}
+
+// newGozimReader adapts the reader of the ZIM at zimPath.
+func newGozimReader(z *zim.ZimReader, zimPath string) gozimReader {
+	r := gozimReader{z: z}
+	if f, err := os.Open(zimPath); err == nil {
+		if h, err := readZimHeader(f); err == nil {
+			r.clusters = h.ClusterCount
+		}
+		f.Close()
+	}
+	return r
+}
+
+func (r gozimReader) Close() error {
+	return r.z.Close()
+}
+
+func (r gozimReader) Version() (major, minor uint16) {
+	return 5, 0
+}
+
+func (r gozimReader) ArticleCount() uint32 {
+	return r.z.ArticleCount
+}
+
+func (r gozimReader) MainPage() (ZimEntry, error) {
+	a, err := r.z.MainPage()
+	if err != nil || a == nil {
+		return nil, err
+	}
+	return gozimEntry{a, r.clusters}, nil
+}
+
+func (r gozimReader) Iterate(fn func(urlIdx uint32)) {
+	r.z.ListTitlesPtrIterator(fn)
+}
+
+func (r gozimReader) EntryAt(urlIdx uint32) (ZimEntry, error) {
+	a, err := r.z.ArticleAtURLIdx(urlIdx)
+	if err != nil {
+		return nil, err
+	}
+	return gozimEntry{a, r.clusters}, nil
+}
+
+// errGozimLastCluster is returned for the entries of the last cluster of
+// a reader opened with gozim, which can not read them.
+var errGozimLastCluster = errors.New("gozim can not read the last cluster, open the zim with NewReader")
+
+type gozimEntry struct {
+	a        *zim.Article
+	clusters uint32
+}
+
+func (e gozimEntry) FullURL() string                { return e.a.FullURL() }
+func (e gozimEntry) Title() string                  { return e.a.Title }
+func (e gozimEntry) Namespace() byte                { return e.a.Namespace }
+func (e gozimEntry) MimeType() string               { return e.a.MimeType() }
+func (e gozimEntry) IsRedirect() bool               { return e.a.EntryType == zim.RedirectEntry }
+func (e gozimEntry) IsDeleted() bool                { return e.a.EntryType == zim.DeletedEntry }
+func (e gozimEntry) RedirectIndex() (uint32, error) { return e.a.RedirectIndex() }
+
+// Data decompresses the cluster of the entry, unless it is in the blob
+// cache of gozim. The read workers of a parse often read entries of the
+// same cluster at the same time, so they wait for the worker already
+// decompressing it and find it in the cache, instead of all decompressing
+// it.
+func (e gozimEntry) Data() ([]byte, error) {
+	if e.a.EntryType == zim.RedirectEntry || e.a.EntryType == zim.LinkTargetEntry || e.a.EntryType == zim.DeletedEntry {
+		return e.a.Data()
+	}
+	cluster := e.cluster()
+	if e.clusters > 0 && cluster+1 == e.clusters {
+		// gozim reads the end of the last cluster past the cluster
+		// pointers, and allocates whatever size it finds there
+		return nil, errGozimLastCluster
+	}
+	unlock := lockCluster(cluster)
+	defer unlock()
+	return e.a.Data()
+}
+
+// cluster returns the cluster of the entry. gozim does not export it, but
+// stores the target of redirects in the same field so RedirectIndex of a
+// copy marked as a redirect returns it.
+func (e gozimEntry) cluster() uint32 {
+	c := *e.a
+	c.EntryType = zim.RedirectEntry
+	cluster, _ := c.RedirectIndex()
+	return cluster
+}
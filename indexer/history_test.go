@@ -0,0 +1,66 @@
+package indexer_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/indexer/zimtest"
+)
+
+func TestMakeHistoryPage(t *testing.T) {
+	tarFile := filepath.Join(t.TempDir(), "test.tar")
+	// an empty tar, ended by two zero blocks
+	if err := os.WriteFile(tarFile, make([]byte, 1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+	versions := []indexer.Version{
+		{Root: "cc", ZimFile: "wiki_2022-03.zim", PublishedAt: time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC), Available: true},
+		{Root: "bb", ZimFile: "wiki_2022-02.zim", PublishedAt: time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC), Error: "not found"},
+		{Root: "aa", ZimFile: "wiki_2022-01.zim", PublishedAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), Available: true},
+	}
+	if err := indexer.MakeHistoryPage(tarFile, "wiki", versions); err != nil {
+		t.Fatal(err)
+	}
+
+	page := string(readTar(t, tarFile)[indexer.HistoryFile])
+	var last int
+	for _, want := range []string{
+		`<a href="/bzz/cc/">wiki_2022-03.zim</a>`,
+		`wiki_2022-02.zim <code>bb</code> (not retrievable: not found)`,
+		`<a href="/bzz/aa/">wiki_2022-01.zim</a>`,
+	} {
+		i := strings.Index(page, want)
+		if i < last {
+			t.Fatalf("%q missing or out of order in\n%s", want, page)
+		}
+		last = i
+	}
+}
+
+func TestHistoryPageLink(t *testing.T) {
+	for _, history := range []bool{false, true} {
+		r := zimtest.New(
+			zimtest.Entry{Namespace: 'A', URL: "Bar", Title: "Bar", Mime: "text/html", Content: []byte("<p>bar</p>")},
+			zimtest.Entry{Namespace: 'A', URL: "Foo", Title: "Foo", Mime: "text/html", Content: []byte("<p>foo</p>")},
+		)
+		idx := newIndexer(t, r)
+		idx.HistoryPage = history
+		tarFile := tarZim(t, idx)
+		if _, err := idx.MakeListingPages(tarFile); err != nil {
+			t.Fatal(err)
+		}
+
+		index := string(readTar(t, tarFile)[indexer.ListingIndex])
+		if index == "" {
+			t.Fatalf("no %s in the tar", indexer.ListingIndex)
+		}
+		link := strings.Contains(index, `href="../history.html"`) || strings.Contains(index, `href="history.html"`)
+		if link != history {
+			t.Errorf("HistoryPage %v: got link %v in\n%s", history, link, index)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package indexer
+
+import (
+	"embed"
+	"encoding/json"
+	"maps"
+	"strings"
+	"sync"
+)
+
+// i18nFS holds the messages of the pages by language, i18n/en.json being
+// those of the languages without catalog and of the messages missing in
+// the others.
+//
+//go:embed i18n/*.json
+var i18nFS embed.FS
+
+// iso639 are the two letter codes of the three letter ones of the ZIM
+// Language metadata, for the languages with a catalog or written right
+// to left.
+var iso639 = map[string]string{
+	"eng": "en", "spa": "es", "fra": "fr", "fre": "fr", "deu": "de", "ger": "de",
+	"fas": "fa", "per": "fa", "ara": "ar", "heb": "he", "urd": "ur", "pus": "ps",
+	"yid": "yi", "div": "dv", "snd": "sd", "uig": "ug", "ckb": "ckb",
+}
+
+// rtl are the languages written right to left.
+var rtl = map[string]bool{
+	"ar": true, "fa": true, "he": true, "ur": true, "ps": true, "yi": true,
+	"dv": true, "sd": true, "ug": true, "ckb": true,
+}
+
+// locale is the language of the pages of a ZIM: Lang, for their lang
+// attribute, Dir, ltr or rtl, and T their messages by key, e.g.
+// .T.Search in a template.
+type locale struct {
+	Lang string
+	Dir  string
+	T    map[string]string
+}
+
+// catalogs are the messages of the catalogs read, by language.
+var catalogs = sync.OnceValue(func() map[string]map[string]string {
+	all := make(map[string]map[string]string)
+	entries, err := i18nFS.ReadDir("i18n")
+	if err != nil {
+		panic(err)
+	}
+	for _, e := range entries {
+		data, err := i18nFS.ReadFile("i18n/" + e.Name())
+		if err != nil {
+			panic(err)
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic(err)
+		}
+		all[strings.TrimSuffix(e.Name(), ".json")] = messages
+	}
+	return all
+})
+
+// newLocale returns the locale of the language, a code of ISO 639-1 or
+// 639-3 with or without region, e.g. "fa", "fas" or "pt-BR", English when
+// empty.
+func newLocale(lang string) *locale {
+	code, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(lang)), "-")
+	code, _, _ = strings.Cut(code, "_")
+	if two, ok := iso639[code]; ok {
+		// the lang attribute prefers the two letter codes
+		if strings.EqualFold(strings.TrimSpace(lang), code) {
+			lang = two
+		}
+		code = two
+	}
+	l := &locale{Lang: lang, Dir: "ltr", T: make(map[string]string)}
+	if l.Lang == "" {
+		l.Lang = "en"
+	}
+	if rtl[code] {
+		l.Dir = "rtl"
+	}
+	maps.Copy(l.T, catalogs()["en"])
+	maps.Copy(l.T, catalogs()[code])
+	return l
+}
+
+// data adds the locale to the data of a template, as Lang, Dir and T.
+func (l *locale) data(data map[string]interface{}) map[string]interface{} {
+	data["Lang"] = l.Lang
+	data["Dir"] = l.Dir
+	data["T"] = l.T
+	return data
+}
+
+// pageLocale returns the locale of the pages of the indexer, of Language,
+// or else of the Language metadata of the ZIM, read once. A parse reads
+// it before locking the reader, for its redirect pages.
+func (idx *SwarmZimIndexer) pageLocale() *locale {
+	idx.localeOnce.Do(func() {
+		lang := idx.Language
+		if lang == "" {
+			lang = metadataLanguage(idx.ExtractMetadata())
+		}
+		idx.locale = newLocale(lang)
+	})
+	return idx.locale
+}
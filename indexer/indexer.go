@@ -2,25 +2,35 @@ package indexer
 
 import (
 	"archive/tar"
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
 	"embed"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"html/template"
+	"io"
 	"io/fs"
-	"log"
+	"maps"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/r0qs/beezim/internal/iobuf"
+	"github.com/r0qs/beezim/internal/limiter"
+	"github.com/r0qs/beezim/internal/progress"
 	"github.com/r0qs/beezim/internal/tarball"
+	"github.com/r0qs/beezim/pkg/logging"
 
-	zim "github.com/akhenakh/gozim"
-	"github.com/cheggaaa/pb/v3"
+	"github.com/ethersphere/bee/pkg/swarm"
+	"github.com/klauspost/compress/s2"
 )
 
 //go:embed assets/*
@@ -29,32 +39,356 @@ var assetsFS embed.FS
 //go:embed templates/*
 var templateFS embed.FS
 
+// zimMu serializes the use of gozim, whose blob cache and article pool
+// are global and reset by every new reader, so two ZIMs can not be read
+// at the same time.
+var zimMu sync.Mutex
+
+// Article is an entry of the ZIM sent by ParseZIM. Its payload may be
+// held in a pooled buffer, or read from the ZIM by DataReader, see
+// StreamSize: the sink receiving it owns it and must call Release once
+// the payload is written, and not use the payload after. A sink keeping
+// the payload must copy it.
 type Article struct {
 	path  string
 	isDir bool
+	// index is the url index of the entry.
+	index uint32
 	data  []byte
+	// buf is the pooled buffer holding data, nil for the payloads
+	// allocated by gozim.
+	buf *bytes.Buffer
+	// compressed is set while data is compressed, see CompressBuffered.
+	compressed bool
+	// src is the file of the ZIM the payload is read from, of srcSize
+	// bytes, rather than held in data, see StreamSize.
+	src     io.ReaderAt
+	srcSize int64
+	mime    string
+	// exception is set for the exception files of the entries that could
+	// not be extracted, see ExceptionsDir.
+	exception bool
+	// target is the entry a redirect ends at.
+	target string
+	// size and sum are those of the content read from the ZIM, rewritten
+	// with RelativeLinks, Offline and NavBar and minified with Minify, set by the read workers when summed, see IndexEntry.
+	size   int64
+	sum    [sha256.Size]byte
+	summed bool
+	// snippet is the start of the text of the HTML articles, see
+	// Snippets.
+	snippet string
 }
 
 func (a Article) Path() string {
 	return a.path
 }
 
+// Data returns the payload, read into memory for the articles streamed
+// from the ZIM, see DataReader.
 func (a Article) Data() []byte {
-	return a.Data()
+	if a.src != nil {
+		data, err := io.ReadAll(a.DataReader())
+		if err != nil {
+			return nil
+		}
+		return data
+	}
+	if a.compressed {
+		// a copy, the sinks of the package decompress in place
+		data, err := s2.Decode(nil, a.data)
+		if err != nil {
+			return nil
+		}
+		return data
+	}
+	return a.data
+}
+
+// Release returns the payload to the pool. Releasing an article twice,
+// or one whose payload is not pooled, does nothing.
+func (a *Article) Release() {
+	if a.buf != nil {
+		putBuffer(a.buf)
+	}
+	a.buf = nil
+	a.data = nil
+	a.compressed = false
+	a.src, a.srcSize = nil, 0
 }
 
 type IndexMetadata struct {
 	Title    string
 	MimeType string
 	Redirect bool
+	// Target is the path of the entry a redirect ends at.
+	Target string `json:",omitempty"`
+	// Skipped is why the article was left out of the tar, e.g.
+	// SkippedSize, and Size its size then.
+	Skipped string `json:",omitempty"`
+	Size    int64  `json:",omitempty"`
+	// Error is why the entry could not be extracted, and Exception the
+	// path of the file telling it, see ExceptionsDir.
+	Error     string `json:",omitempty"`
+	Exception string `json:",omitempty"`
+	// Duplicate is the path of the article sent before with the same
+	// payload, see Dedup.
+	Duplicate string `json:",omitempty"`
+	// Snippet is the start of the text of the HTML article, for the
+	// search results, see SwarmZimIndexer.Snippets.
+	Snippet string `json:",omitempty"`
 }
 
 type SwarmZimIndexer struct {
 	mu           sync.Mutex
 	ZimPath      string
-	Z            *zim.ZimReader
-	entries      map[string]IndexEntry
+	Z            ZimReader
+	entries      EntryStore
 	enableSearch bool
+	// entriesErr is the first error of the entry store, see EntriesErr.
+	entriesErr error
+	// theme is the templates and assets of the pages, the embedded ones
+	// when nil, and themeErr why it could not be read, see ThemeErr.
+	theme    *theme
+	themeErr error
+	// namespaces and mimes are the filters set with SetNamespaceFilter
+	// and SetMimeFilter, mimeFiltered the articles left out by the
+	// latter.
+	namespaces   NamespaceFilter
+	mimes        MimeFilter
+	mimeFiltered map[string]MimeStats
+	// parses are the cancels of the running parses by the channel they
+	// send to, a <-chan []Article or a <-chan Article, see abortParse.
+	parses map[any]context.CancelFunc
+	// parseErr is why the last parse did not read the ZIM.
+	parseErr error
+	// owned is set when Z was opened by New, and closed is set by Close.
+	owned  bool
+	closed bool
+	// panics are the entries whose reading panicked, skipped those that
+	// could not be read. onEntryErrorMu serializes the calls of
+	// OnEntryError by the read workers.
+	panics         []EntryPanic
+	skipped        []EntryError
+	onEntryErrorMu sync.Mutex
+	// dedupSums are the paths of the articles sent by the payload hash,
+	// with Dedup, and dedupStats their duplicates.
+	dedupSums  map[[sha256.Size]byte]string
+	dedupStats DedupStats
+	// collided are the paths of the entries left out by Collisions, and
+	// dropped their url indexes.
+	collided []string
+	dropped  map[uint32]bool
+	// extraFiles are those of AddExtraFiles, sorted by path.
+	extraFiles []ExtraFile
+
+	// Workers, when set, is the budget shared with other indexers from
+	// which the parse workers are acquired.
+	Workers *limiter.Pool
+	// ReadWorkers is the number of workers of a parse reading entries,
+	// and decompressing their clusters, at the same time, one when zero.
+	// They are acquired from Workers, when set. The articles are still
+	// sent in the order of the ZIM, so the tars are the same.
+	ReadWorkers int
+	// ExtractWorkers is the number of files UnZim writes at the same
+	// time, one when zero, each holding its article meanwhile.
+	ExtractWorkers int
+	// Overwrite is what UnZim does with the files already extracted.
+	Overwrite Overwrite
+	// Fingerprint, when set, is recorded at the start of the tars built.
+	Fingerprint *Fingerprint
+	// Provenance, when set, is written by MakeProvenanceFile and shown in
+	// the about page.
+	Provenance *Provenance
+	// SearchTarFile, when set, receives the entries of the search index
+	// built by TarZim, so they can be uploaded as their own collection.
+	SearchTarFile string
+	// GzipLevel, when not zero, gzips the tars written by TarZim, named
+	// as tarball.GzipName, WriteTar and TarStream, at this level of
+	// compress/gzip, e.g. to keep them on disk; the node only takes plain
+	// tars. The search tar stays plain.
+	GzipLevel int
+	// Logger receives the log output, the default logger when nil.
+	Logger logging.Logger
+	// Progress receives the progress of the parse and of Verify when the
+	// context carries no reporter, DefaultProgress when nil.
+	Progress ProgressReporter
+	// BatchSize is the number of articles sent together by
+	// ParseZIMBatches, DefaultBatchSize when zero.
+	BatchSize int
+	// BatchBuffer is the number of batches parsed ahead of the sink,
+	// DefaultBatchBuffer when zero.
+	BatchBuffer int
+	// CompressBuffered compresses the text payloads while they wait for
+	// the sink, which decompresses them when writing. It saves memory
+	// when the sink is slower than the parser, for some CPU.
+	CompressBuffered bool
+	// Buffers are the sizes of the buffers of the tars and extracted
+	// files written, the defaults when zero.
+	Buffers iobuf.Config
+	// SpaceCheck, when set, is called every SpaceCheckInterval bytes of
+	// tars or extracted files written; writing stops with its error, e.g.
+	// when the disk is about to be full.
+	SpaceCheck func() error
+	// PanicBudget is the number of entries of the parses of the indexer
+	// that can make the ZIM reader panic, each skipped and recorded in
+	// Panics, before the parse fails with ErrTooManyPanics.
+	PanicBudget int
+	// MaxArticleSize is the size in bytes above which the articles are
+	// left out, recorded in the entries as SkippedSize and listed by
+	// MakeSkippedPage; no limit when zero. The data of an article is
+	// still read once to know its size, unless it is streamed.
+	MaxArticleSize int64
+	// StreamSize is the size in bytes from which the payloads the parse
+	// does not rewrite, e.g. images and videos, are read from the file of
+	// the ZIM by the sinks as they write them, rather than held in memory,
+	// when their cluster is not compressed; DefaultStreamSize when zero,
+	// never when negative. They are read once more to be hashed.
+	StreamSize int64
+	// MimePlaceholders replaces the articles left out by the MIME filter
+	// by a small placeholder of their type instead of leaving them out,
+	// so that the links to them do not fail.
+	MimePlaceholders bool
+	// ManifestRedirects leaves the redirects out of the tars, which list
+	// them in RedirectsFile for the uploader to add them to the manifest
+	// as aliases of their target, see also Redirects. The extracted
+	// directories keep their redirect pages.
+	ManifestRedirects bool
+	// redirects are those left out of the last tar.
+	redirects []Redirect
+	// FileGroup, when set, returns the group of the files page listing
+	// the entry instead of DefaultFileGroup, which it falls back to when
+	// it returns an empty group. It is called with the indexer locked.
+	FileGroup func(IndexEntry) string
+	// DropTitleIndex leaves the title indexes of the ZIM out of the tars,
+	// X/title/xapian and the title listings, which only libzim reads,
+	// the search page suggesting the titles of TitlesFile instead. The
+	// full text index of the search stays.
+	DropTitleIndex bool
+	// DropSearchIndexes leaves all the entries of the X namespace out of
+	// the tars and extracted directories, the Xapian databases included,
+	// whatever the namespaces parsed, the search page searching the
+	// titles only. See SearchIndexStats.
+	DropSearchIndexes bool
+	// searchIndexStats are the entries left out by the last parse.
+	searchIndexStats SearchIndexStats
+	// mimeOverrides are the types set with SetMimeOverrides, and
+	// mimeFixes the entries the last parse corrected.
+	mimeOverrides MimeOverrides
+	mimeFixes     MimeFixStats
+	// SniffMime guesses the type of the entries the ZIM gives an empty
+	// type or application/octet-stream, and no override, from the
+	// extension of their path or the start of their content.
+	SniffMime bool
+	// Snippets records the first characters of the visible text of the
+	// HTML articles as their Snippet, shown by the search results of the
+	// DApp, at the cost of reading them once more while parsing.
+	Snippets bool
+	// Sitemap appends the sitemap of the HTML articles to the tars, see
+	// MakeSitemap, with the URLs under SitemapURL when set.
+	Sitemap    bool
+	SitemapURL string
+	// Language, when set, is the language of the pages of the tars, e.g.
+	// "fa" or "fas", instead of the Language metadata of the ZIM, for the
+	// ZIMs without or with a wrong one. It is read before the first parse.
+	Language string
+	// locale is that of the pages, see pageLocale.
+	localeOnce sync.Once
+	locale     *locale
+	// style is the stylesheet of the pages, see SetTheme.
+	style *style
+	// favicon is that of the ZIM, see zimFavicon.
+	faviconOnce sync.Once
+	favicon     []byte
+	// title is that of the ZIM, see zimTitle.
+	titleOnce sync.Once
+	title     string
+	// indexWritten is set once the index page is written, see
+	// MakeIndexPage.
+	indexWritten bool
+	// ListingPageSize is the number of articles of the pages listing the
+	// articles from A to Z, DefaultListingPageSize when zero.
+	ListingPageSize int
+	// MainPage, when set, is the path of the entry taken for the main page
+	// of the ZIM instead of the one it tells, see SelectMainPage.
+	MainPage string
+	// Dedup replaces the articles identical to one sent before, of the
+	// types it covers, by a small alias of it when their type has one,
+	// and records them in the entries as its Duplicate. It keeps a hash
+	// per article of those types.
+	Dedup Dedup
+	// Collisions is which of the entries of the ZIM at the same path is
+	// sent, the others being left out and listed by PathCollisions. The
+	// generated pages are at the root of the tars, beside the namespaces
+	// prefixing the paths of the entries, so no entry takes their path.
+	Collisions Collisions
+	// Minify shrinks the HTML, CSS and JavaScript articles, dropping their
+	// comments and collapsing their spaces as they are read, those that
+	// can not be minified being sent as they are, see MinifyStats.
+	Minify bool
+	// minifyStats are the articles minified by the last parse.
+	minifyStats MinifyStats
+	// Previous are the entries of the tar of the previous version of the
+	// ZIM, see ReadEntriesManifest: the parse then only sends the
+	// articles added or changed since, recording the others as entries,
+	// and the tar gets their Diff as DiffFile. The entries are held in
+	// memory during the parse.
+	Previous []ManifestEntry
+	// diffPrevious are the Previous entries not found by the parse yet,
+	// and diff that of the last parse.
+	diffPrevious map[string]ManifestEntry
+	diff         Diff
+	// RelativeLinks makes the root-absolute links of the HTML and CSS
+	// articles, e.g. /A/Foo, relative to their path as they are read,
+	// so that the tar still works served under a path, e.g. /bzz/<hash>/,
+	// see RelativeLinksStats.
+	RelativeLinks bool
+	// relativeLinksStats are the articles rewritten by the last parse.
+	relativeLinksStats RelativeLinksStats
+	// NavBar adds to the HTML articles with a body, as they are read, a
+	// navigation bar linking to the index page of the tar with the title
+	// of the ZIM, and searching it with the search, styled by the
+	// NavBarStylesheet. The articles already with one are left as they are.
+	NavBar bool
+	// HistoryPage links the index pages, the about page of the search and
+	// the index of the listing pages, to the HistoryFile appended to the
+	// tar after the parse by MakeHistoryPage.
+	HistoryPage bool
+	// Offline rewrites the HTML articles as they are read so that browsing
+	// them sends no request out of the archive, before Minify, the links
+	// in the archive being kept as they are, see OfflineStats.
+	Offline Offline
+	// offlineStats are the articles rewritten by the last parse.
+	offlineStats OfflineStats
+	// CheckpointEvery, when not zero, makes the tars written by TarZim,
+	// TarZimBatches, TarZimArticles and WriteTar record a checkpoint
+	// every CheckpointEvery articles of the parse in their CheckpointFile,
+	// from which Resume resumes the tar when it was interrupted. The
+	// entries recorded by the parse meanwhile are kept in their
+	// JournalFile.
+	CheckpointEvery int
+	// journal are the records of the parse not journaled yet, resume the
+	// checkpoint of Resume and start the url index of StartAt.
+	journal []journalRecord
+	resume  *resumeState
+	start   *uint32
+	// OnEntryError, when set, is called with each entry that can not be
+	// read, recorded in Skipped: the entry is skipped when it returns nil,
+	// and the parse fails with its error otherwise. The entries are all
+	// skipped when it is nil. It is not called concurrently.
+	OnEntryError func(EntryError) error
+}
+
+// ErrNoMainPage is returned when building the index of a ZIM without
+// main page nor article to list instead.
+var ErrNoMainPage = errors.New("zim has no main page")
+
+// SearchIndexPath is the path of the full text search index in the ZIM.
+const SearchIndexPath = "X/fulltext/xapian"
+
+// isSearchIndex reports whether the entry belongs to the search indexes.
+func isSearchIndex(name string) bool {
+	return name == "X" || strings.HasPrefix(name, "X/")
 }
 
 // TODO: store root in a local kv db pointing to the metadata in swarm
@@ -63,401 +397,1124 @@ type SwarmZimIndexer struct {
 type IndexEntry struct {
 	Path     string
 	Metadata IndexMetadata
+	// Size and SHA256, in hex, are those of the content of the article
+	// in the ZIM, the size only for the articles left out for it and
+	// neither for the redirects, exceptions and placeholders.
+	Size   int64  `json:",omitempty"`
+	SHA256 string `json:",omitempty"`
+	// Reference is the Swarm address of the article once uploaded, see
+	// SetReference.
+	Reference swarm.Address
 }
 
-func New(zimPath string, enableSearch bool) (*SwarmZimIndexer, error) {
-	z, err := zim.NewReader(zimPath, false)
+// MarshalJSON leaves out the Reference of the entries not uploaded.
+func (e IndexEntry) MarshalJSON() ([]byte, error) {
+	type entry IndexEntry
+	var ref string
+	if !e.Reference.IsZero() {
+		ref = e.Reference.String()
+	}
+	return json.Marshal(struct {
+		entry
+		Reference string `json:",omitempty"`
+	}{entry(e), ref})
+}
+
+// New opens the ZIM at zimPath and returns its indexer, whose Close
+// closes the ZIM. With WithVerify, a ZIM failing VerifyChecksum is not
+// opened.
+func New(zimPath string, enableSearch bool, opts ...Option) (*SwarmZimIndexer, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	t, err := o.newTheme()
+	if err != nil {
+		return nil, err
+	}
+	if o.verify {
+		if err := verifyChecksum(context.Background(), zimPath, o.progress); err != nil {
+			return nil, err
+		}
+	}
+	z, err := NewReader(zimPath)
 	if err != nil {
 		return nil, err
 	}
+	idx := NewWithReader(zimPath, z, enableSearch, append(opts, withTheme(t))...)
+	idx.owned = true
+	return idx, nil
+}
 
+// NewWithReader returns an indexer of the ZIM read by z. zimPath names
+// the ZIM in the logs and pages. The caller owns z: Close leaves it
+// open, and it must be closed once the passes of every indexer reading it
+// are done. Two passes can not read z at the same time, the second fails
+// with ErrReaderBusy. WithVerify only applies to New, and the error of
+// the directories of WithTemplateDir and WithAssetsDir is that of
+// ThemeErr.
+func NewWithReader(zimPath string, z ZimReader, enableSearch bool, opts ...Option) *SwarmZimIndexer {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.entries == nil {
+		o.entries = MemoryStore()
+	}
+	t, err := o.newTheme()
 	return &SwarmZimIndexer{
 		ZimPath:      zimPath,
 		Z:            z,
-		entries:      make(map[string]IndexEntry),
+		entries:      o.entries,
 		enableSearch: enableSearch,
-	}, nil
+		Progress:     o.progress,
+		theme:        t,
+		themeErr:     err,
+		start:        o.start,
+	}
 }
 
-func (idx *SwarmZimIndexer) AddEntry(entryPath string, metadata IndexMetadata) {
+// Close closes the reader opened by New, the readers given by the caller
+// are left open, and the entry store. It fails with ErrReaderBusy while a
+// parse reads the ZIM; the indexer can not parse once closed.
+func (idx *SwarmZimIndexer) Close() error {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
+	if idx.closed {
+		return nil
+	}
+	if err := beginPass(idx.Z); err != nil {
+		return err
+	}
+	defer endPass(idx.Z)
+	idx.closed = true
+	err := idx.entries.Close()
+	if c, ok := idx.Z.(io.Closer); ok && idx.owned {
+		if cerr := c.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
 
-	idx.entries[entryPath] = IndexEntry{
+// ParseErr returns why the last parse did not read the ZIM, e.g.
+// ErrReaderBusy or the error of its canceled context, or nil. The sinks
+// of the indexer return it.
+func (idx *SwarmZimIndexer) ParseErr() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.parseErr
+}
+
+func (idx *SwarmZimIndexer) logger() logging.Logger {
+	return logging.Or(idx.Logger)
+}
+
+func (idx *SwarmZimIndexer) AddEntry(entryPath string, metadata IndexMetadata) {
+	idx.addEntry(IndexEntry{
 		Path:     entryPath,
 		Metadata: metadata,
+	})
+}
+
+// addEntry records the entry, replacing the one at its path.
+func (idx *SwarmZimIndexer) addEntry(e IndexEntry) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.recordEntriesErr(idx.entries.Put(e))
+}
+
+// addParsedEntry records the entry parsed from the entry of the ZIM at
+// the url index, journaled with CheckpointEvery.
+func (idx *SwarmZimIndexer) addParsedEntry(i uint32, e IndexEntry) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.journalEntry(i, e)
+	idx.recordEntriesErr(idx.entries.Put(e))
+}
+
+// SetReference records the Swarm address of the article at path, e.g.
+// read from the manifest of the collection uploaded or returned by the
+// upload of the article alone. It fails with an error matching
+// fs.ErrNotExist when no parse recorded the article.
+func (idx *SwarmZimIndexer) SetReference(path string, addr swarm.Address) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	e, ok, err := idx.entries.Get(path)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s: %w", path, fs.ErrNotExist)
+	}
+	e.Reference = addr
+	return idx.entries.Put(e)
+}
+
+// recordEntriesErr records the first error of the entry store, with idx.mu
+// held.
+func (idx *SwarmZimIndexer) recordEntriesErr(err error) {
+	if err != nil && idx.entriesErr == nil {
+		idx.entriesErr = fmt.Errorf("%s: %w", filepath.Base(idx.ZimPath), err)
 	}
 }
 
-func (idx *SwarmZimIndexer) Entries() map[string]IndexEntry {
-	return idx.entries
+// EntriesErr returns the first error of the entry store, e.g. of a
+// DiskStore out of space, since which the entries of the indexer are
+// incomplete. The parse fails with it, and so do the pages listing the
+// entries.
+func (idx *SwarmZimIndexer) EntriesErr() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.entriesErr
+}
+
+// EntriesSnapshot returns a copy of the entries of the parses of the
+// indexer, by path, which can be read while a parse adds entries. It holds
+// them all in memory, whatever the entry store.
+func (idx *SwarmZimIndexer) EntriesSnapshot() map[string]IndexEntry {
+	entries := make(map[string]IndexEntry)
+	idx.ForEachEntry(func(e IndexEntry) bool {
+		entries[e.Path] = e
+		return true
+	})
+	return entries
+}
+
+// EntryCount returns the number of entries of the parses of the indexer.
+func (idx *SwarmZimIndexer) EntryCount() int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.entries.Len()
+}
+
+// ForEachEntry calls fn with the entries of the parses of the indexer, by
+// path, until it returns false. The indexer is locked meanwhile: fn must
+// not call its methods, and the parses adding entries wait for it. An
+// error of the entry store ends it, see EntriesErr.
+func (idx *SwarmZimIndexer) ForEachEntry(fn func(IndexEntry) bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.recordEntriesErr(idx.entries.Range(fn))
 }
 
-func (idx *SwarmZimIndexer) ParseZIM() chan Article {
-	zimArticles := make(chan Article)
+// ParseZIMBatches sends the articles of the ZIM to the returned channel
+// in batches of BatchSize articles, with up to BatchBuffer batches parsed
+// ahead of the sink. The channel is closed when all articles were sent or
+// the context is canceled, right away when the ZIM can not be read, see
+// ParseErr. The sinks of the indexer stop the parse when they fail.
+func (idx *SwarmZimIndexer) ParseZIMBatches(ctx context.Context) <-chan []Article {
+	size, depth := idx.BatchSize, idx.BatchBuffer
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	if depth <= 0 {
+		depth = DefaultBatchBuffer
+	}
+	zimArticles := make(chan []Article, depth)
+	ctx, cancel := context.WithCancel(ctx)
+	if err := idx.startPass(); err != nil {
+		cancel()
+		close(zimArticles)
+		return zimArticles
+	}
+	untrack := idx.trackParse((<-chan []Article)(zimArticles), cancel)
 	go func() {
+		defer cancel()
 		defer close(zimArticles)
-		progressBar := pb.New(int(idx.Z.ArticleCount))
-		progressBar.Set(pb.Bytes, true)
-		progressBar.Start()
-
-		log.Printf("Parsing zim file: %s", filepath.Base(idx.ZimPath))
-		start := time.Now()
-		// TODO: improve performance for big files
-		idx.Z.ListTitlesPtrIterator(func(i uint32) {
-			a, err := idx.Z.ArticleAtURLIdx(i)
-			if err != nil || a.EntryType == zim.DeletedEntry {
-				return
+		defer untrack()
+		b := newBatcher(ctx, zimArticles, size)
+		idx.articles(ctx, func(a Article, err error) bool {
+			if err != nil {
+				return false
 			}
-
-			// FIXME: for now, all namespaces are considered equal when parsing
-			// https://openzim.org/wiki/ZIM_file_format and
-			// https://openzim.org/wiki/ZIM_file_format_old_namespace
-			//
-			// Namespaces:
-			// '-': Assets (CSS, JS, Favicon)
-			// 'A': Text files (Article Format)
-			// 'I': Media files
-			// 'M': ZIM Metadata
-			// 'X': Search indexes (Xapian DB)
-			switch a.Namespace {
-			case '-', 'A', 'B', 'C', 'I', 'J', 'U', 'W':
-				// TODO: handle categories: https://openzim.org/wiki/Category_Handling
-				// TODO: handle well known entries: https://openzim.org/wiki/Well_known_entries
-				idx.preProcessing(a, zimArticles)
-			case 'M', 'X':
-				//FIXME: handle cases where the zim file was created without xapian
-				// https://github.com/openzim/libzim/blob/11258f9e624d5b288610b7dc6752b62a0af317c2/README.md#compilation
-				if idx.enableSearch {
-					idx.preProcessing(a, zimArticles)
-				}
-				// TODO: For now we are ignoring some cases, but we should create "_exceptions/" directory in case of errors extracting the files like is done by the zim-tools.
-				// https://github.com/openzim/zim-tools/blob/a26a450110e9ca2ec1b20de8237a3bd382af71f5/src/zimdump.cpp#L214
-			default:
+			if idx.CompressBuffered && compressible(a.mime) {
+				a.compress()
 			}
-			progressBar.Increment()
+			return b.add(a)
 		})
-		progressBar.Finish()
-		elapsed := time.Since(start)
-		log.Printf("File processed in %v", elapsed)
+		b.flush()
 	}()
 	return zimArticles
 }
 
-func (idx *SwarmZimIndexer) preProcessing(article *zim.Article, zimArticles chan<- Article) {
+// article returns the article of the entry, or why it can not be read.
+func (idx *SwarmZimIndexer) article(entry ZimEntry) (Article, error) {
 	var data []byte
-	var err error
+	var buf *bytes.Buffer
+	var src *io.SectionReader
+	var ra ZimEntry
+	mimeType, overridden := entry.MimeType(), false
 
-	if article.EntryType == zim.RedirectEntry {
-		ridx, err := article.RedirectIndex()
-		if err != nil {
-			return
-		}
+	if err := checkEntryName(entry.FullURL()); err != nil {
+		return Article{}, err
+	}
 
-		ra, err := idx.Z.ArticleAtURLIdx(ridx)
+	if entry.IsRedirect() {
+		var err error
+		ra, err = idx.redirectTarget(entry)
 		if err != nil {
-			return
+			return Article{}, err
 		}
 
-		buf, err := buildRedirectPage(path.Base(ra.FullURL()))
+		t, err := idx.pageTheme()
 		if err != nil {
-			log.Fatalf("error building redirect page: %v", err)
+			return Article{}, err
+		}
+		// redirect pages are a large share of the entries of a wiki
+		buf = getBuffer(redirectPageSize)
+		if err := t.writeRedirectPage(buf, relativeLink(entry.FullURL(), ra.FullURL()), idx.pageData(entry.FullURL(), map[string]interface{}{})); err != nil {
+			putBuffer(buf)
+			return Article{}, fmt.Errorf("building redirect page: %w", err)
 		}
 		data = buf.Bytes()
 
 	} else {
-		data, err = article.Data()
+		mimeType, overridden = idx.overrideMime(entry)
+		var err error
+		src, err = idx.streamSection(entry, mimeType)
+		if e, ok := entry.(bufferEntry); ok && err == nil && src == nil {
+			// the payloads of the ZIM are most of the allocations of a
+			// parse, returned to the pool once written by Release
+			if buf, err = e.DataBuffer(); err == nil {
+				data = buf.Bytes()
+			}
+		} else if err == nil && src == nil {
+			data, err = entry.Data()
+		}
 		if err != nil {
-			return
+			return Article{}, fmt.Errorf("reading data: %w", err)
 		}
 	}
 
-	dir, err := filepath.Rel(filepath.Dir(article.FullURL()), article.FullURL())
-	if err != nil {
-		return
+	a := Article{
+		path: entry.FullURL(),
+		data: data,
+		buf:  buf,
+		mime: mimeType,
 	}
-
-	zimArticles <- Article{
-		path:  article.FullURL(),
-		data:  data,
-		isDir: dir == ".",
+	if src != nil {
+		a.src, a.srcSize = src, src.Size()
+	}
+	if idx.SniffMime && ra == nil && !overridden && unknownMime(a.mime) {
+		idx.sniffMime(&a)
 	}
+	if ra != nil {
+		a.target = ra.FullURL()
+	}
+	// entry names are slash separated on every platform
+	a.isDir = path.Dir(a.path) == path.Clean(a.path)
+	return a, nil
+}
 
-	idx.AddEntry(article.FullURL(), IndexMetadata{
-		Title:    article.Title,
-		MimeType: article.MimeType(),
-		Redirect: article.EntryType == zim.RedirectEntry,
-	})
+func (idx *SwarmZimIndexer) mainPage() (ZimEntry, error) {
+	zimMu.Lock()
+	defer zimMu.Unlock()
+	return idx.Z.MainPage()
 }
 
-func (idx *SwarmZimIndexer) UnZim(outputDir string, files <-chan Article) error {
-	if _, err := os.Stat(outputDir); os.IsNotExist(err) {
-		if err := os.MkdirAll(outputDir, 0755); err != nil {
-			return err
+// TarZim writes the articles received to tarFile, or to its GzipName
+// with GzipLevel.
+func (idx *SwarmZimIndexer) TarZim(ctx context.Context, tarFile string, files <-chan Article) error {
+	return idx.TarZimBatches(ctx, tarFile, idx.batchesOf(ctx, files))
+}
+
+// TarZimBatches writes the batches of articles received to tarFile.
+func (idx *SwarmZimIndexer) TarZimBatches(ctx context.Context, tarFile string, batches <-chan []Article) (err error) {
+	defer func() {
+		if err != nil {
+			idx.abortParse(batches)
 		}
+	}()
+	s, err := idx.newTarSink(ctx, tarFile)
+	if err != nil {
+		return err
 	}
+	defer s.close()
+	return idx.writeSink(s, batches, false)
+}
 
-	for file := range files {
-		filePath := filepath.Join(outputDir, file.path)
-		fileDirPath := filepath.Dir(filePath)
+// writeSink writes the batches of articles to the sink, followed by the
+// pages of WritePages when pages is set, and finishes it.
+func (idx *SwarmZimIndexer) writeSink(s *tarSink, batches <-chan []Article, pages bool) error {
+	if err := idx.writeBatches(s, batches, pages); err != nil {
+		return err
+	}
+	if err := s.end(); err != nil {
+		return err
+	}
+	s.done()
+	return nil
+}
 
-		if _, err := os.Stat(fileDirPath); os.IsNotExist(err) {
-			if err := os.MkdirAll(fileDirPath, 0755); err != nil {
+// writeBatches writes the batches of articles to the sink, followed by
+// their redirects and the pages of WritePages when pages is set.
+func (idx *SwarmZimIndexer) writeBatches(s *tarSink, batches <-chan []Article, pages bool) error {
+	for batch := range batches {
+		for i := range batch {
+			if err := s.write(&batch[i]); err != nil {
+				releaseAll(batch[i+1:])
 				return err
 			}
 		}
+	}
+	if err := idx.ParseErr(); err != nil {
+		return err
+	}
+	if err := s.writeRedirects(); err != nil {
+		return err
+	}
+	if pages {
+		return idx.WritePages(s)
+	}
+	return nil
+}
+
+// tarSink writes articles to a tar, and the search index to its own tar
+// when SearchTarFile is set.
+type tarSink struct {
+	idx          *SwarmZimIndexer
+	tarFile      string
+	files        []*os.File
+	bw, searchBw *bufio.Writer
+	tw, searchTw *tar.Writer
+	// dirs are the directories written to tw, searchDirs those written
+	// to searchTw.
+	dirs, searchDirs map[string]bool
+	gz               *gzip.Writer
+	rep              progress.Reporter
+	e                progress.Event
+	space            spaceChecker
+	// redirects are those left out with ManifestRedirects.
+	manifestRedirects bool
+	redirects         []Redirect
+	// prefix is the directory of the files written, with its slash, see
+	// MultiIndexer.
+	prefix string
+	// cp records the checkpoints with CheckpointEvery.
+	cp *checkpointer
+}
+
+func (idx *SwarmZimIndexer) newTarSink(ctx context.Context, tarFile string) (*tarSink, error) {
+	if idx.GzipLevel != 0 {
+		tarFile = tarball.GzipName(tarFile)
+	}
+	if idx.CheckpointEvery > 0 {
+		if err := idx.checkCheckpoints(); err != nil {
+			return nil, err
+		}
+	}
+	f, r, err := idx.openTar(tarFile)
+	if err != nil {
+		return nil, err
+	}
+	s, err := idx.newTarSinkAt(ctx, tarFile, f, idx.SpaceCheck, r)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.files = append(s.files, f)
+	if r != nil {
+		err = s.resumeFrom(f, r)
+	}
+	if err == nil && (idx.CheckpointEvery > 0 || r != nil) {
+		err = s.startCheckpoints(f, r)
+	}
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// newTarSinkTo returns the sink writing the tar named tarFile to w, the
+// space written being checked with check.
+func (idx *SwarmZimIndexer) newTarSinkTo(ctx context.Context, tarFile string, w io.Writer, check func() error) (*tarSink, error) {
+	return idx.newTarSinkAt(ctx, tarFile, w, check, nil)
+}
+
+// newTarSinkAt is newTarSinkTo resuming the tar from the checkpoint r,
+// when set, whose start is already written.
+func (idx *SwarmZimIndexer) newTarSinkAt(ctx context.Context, tarFile string, w io.Writer, check func() error, r *resumeState) (*tarSink, error) {
+	var gz *gzip.Writer
+	if idx.GzipLevel != 0 {
+		if err := tarball.CheckGzipLevel(idx.GzipLevel); err != nil {
+			return nil, err
+		}
+		gz, _ = gzip.NewWriterLevel(w, idx.GzipLevel)
+		w = gz
+	}
+	s := &tarSink{
+		gz:      gz,
+		idx:     idx,
+		tarFile: tarFile,
+		rep:     reporter(ctx),
+		e:       progress.Event{Stage: "tar"},
+		space:   spaceChecker{check: check},
+		dirs:    make(map[string]bool),
+
+		manifestRedirects: idx.ManifestRedirects,
+	}
+	bufSize := idx.Buffers.WithDefaults().TarWriter
+
+	// the tar writer writes every header and payload on its own
+	s.bw = bufio.NewWriterSize(w, bufSize)
+	s.tw = tar.NewWriter(s.bw)
+	if idx.Fingerprint != nil && r == nil {
+		if err := s.tw.WriteHeader(idx.Fingerprint.header()); err != nil {
+			s.close()
+			return nil, err
+		}
+	}
 
-		f, err := os.Create(filePath)
+	s.searchTw, s.searchBw, s.searchDirs = s.tw, s.bw, s.dirs
+	if idx.SearchTarFile != "" {
+		sf, err := os.Create(idx.SearchTarFile)
 		if err != nil {
-			return err
+			s.close()
+			return nil, err
 		}
+		s.files = append(s.files, sf)
+		s.searchBw = bufio.NewWriterSize(sf, bufSize)
+		s.searchTw = tar.NewWriter(s.searchBw)
+		s.searchDirs = make(map[string]bool)
+	}
+	return s, nil
+}
 
-		if _, err := f.Write(file.data); err != nil {
+// write writes the article to its tar and releases it.
+func (s *tarSink) write(file *Article) error {
+	defer file.Release()
+	if s.cp != nil {
+		if err := s.checkpointed(file.index); err != nil {
 			return err
 		}
-
-		f.Close()
 	}
+	tw, dirs := s.tw, s.dirs
+	if isSearchIndex(file.path) {
+		tw, dirs = s.searchTw, s.searchDirs
+	} else if s.manifestRedirects && file.target != "" {
+		s.redirects = append(s.redirects, Redirect{Path: file.path, Target: file.target})
+		return nil
+	}
+	file.path = s.prefix + file.path
+	err := file.decompress()
+	if err == nil {
+		err = writeTarEntry(tw, dirs, file)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", file.path, err)
+	}
+	// directories are not counted
+	size := file.Size()
+	if !file.isDir {
+		s.e.Done++
+		s.e.Bytes += size
+		s.rep.Report(s.e)
+	}
+	if err := s.space.add(int(size)); err != nil {
+		return fmt.Errorf("%s: %w", s.tarFile, err)
+	}
+	return nil
+}
 
+// finish ends the tars and reports the end of the stage.
+func (s *tarSink) finish() error {
+	if err := s.writeRedirects(); err != nil {
+		return err
+	}
+	if err := s.end(); err != nil {
+		return err
+	}
+	s.done()
 	return nil
 }
 
-func (idx *SwarmZimIndexer) TarZim(tarFile string, files <-chan Article) error {
-	f, err := os.Create(tarFile)
+// writeRedirects writes the RedirectsFile of the redirects left out, if
+// any, once the articles are written.
+func (s *tarSink) writeRedirects() error {
+	s.idx.mu.Lock()
+	s.idx.redirects = s.redirects
+	s.idx.mu.Unlock()
+	if len(s.redirects) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(s.redirects)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	return writeTarEntry(s.tw, s.dirs, &Article{path: s.prefix + RedirectsFile, data: data})
+}
 
-	tw := tar.NewWriter(f)
-	for file := range files {
-		hdr := &tar.Header{
-			Name: file.path,
-			Mode: 0644,
-			Size: int64(len(file.data)),
+// end ends the tars.
+func (s *tarSink) end() error {
+	if s.searchTw != s.tw {
+		if err := s.searchTw.Close(); err != nil {
+			return err
 		}
-
-		if file.isDir {
-			hdr.Typeflag = tar.TypeDir
-		} else {
-			hdr.Typeflag = tar.TypeReg
+		if err := s.searchBw.Flush(); err != nil {
+			return err
 		}
-
-		if err := tw.WriteHeader(hdr); err != nil {
+	}
+	if err := s.tw.Close(); err != nil {
+		return err
+	}
+	if err := s.bw.Flush(); err != nil {
+		return err
+	}
+	if s.gz != nil {
+		if err := s.gz.Close(); err != nil {
 			return err
 		}
+	}
+	if s.cp != nil {
+		return s.endCheckpoints()
+	}
+	return nil
+}
 
-		// skip write if it is directory
-		if file.isDir {
-			continue
-		}
+// done reports the end of the stage.
+func (s *tarSink) done() {
+	s.e.Finished = true
+	s.rep.Report(s.e)
+}
 
-		if _, err := tw.Write(file.data); err != nil {
-			return err
-		}
+// WriteFile writes the file to the tar, before it is finished.
+func (s *tarSink) WriteFile(f *tarball.File) error {
+	name := s.prefix + f.Name()
+	if err := writeDirs(s.tw, s.dirs, name, false); err != nil {
+		return err
+	}
+	if err := s.tw.WriteHeader(tarball.Header(name, f.Size())); err != nil {
+		return err
 	}
+	_, err := io.Copy(s.tw, f.DataReader())
+	return err
+}
+
+// Name returns the name of the tar.
+func (s *tarSink) Name() string {
+	return filepath.Base(s.tarFile)
+}
 
-	if err := tw.Close(); err != nil {
+// close closes the files of the tars.
+func (s *tarSink) close() {
+	for _, f := range s.files {
+		f.Close()
+	}
+	if s.cp != nil {
+		s.cp.journal.Close()
+	}
+}
+
+// writeTarEntry writes the header of the article, after those of its
+// directories not in dirs yet, and, unless it is a directory, its payload.
+func writeTarEntry(tw *tar.Writer, dirs map[string]bool, file *Article) error {
+	if err := writeDirs(tw, dirs, file.path, file.isDir); err != nil {
 		return err
 	}
+	// the directory is written with the others
+	if file.isDir {
+		return nil
+	}
+
+	if err := tw.WriteHeader(tarball.Header(file.path, file.Size())); err != nil {
+		return err
+	}
+	return copyPayload(tw, file)
+}
+
+// writeDirs writes the headers of the directories of the entry name not
+// in dirs yet, with its own when it is a directory, and adds them to dirs.
+func writeDirs(tw *tar.Writer, dirs map[string]bool, name string, isDir bool) error {
+	for _, d := range newDirs(dirs, name, isDir) {
+		if err := tw.WriteHeader(tarball.DirHeader(d)); err != nil {
+			return err
+		}
+		dirs[d] = true
+	}
 	return nil
 }
 
-func buildRedirectPage(pagePath string) (*bytes.Buffer, error) {
-	tmplData := map[string]interface{}{
-		"Path": pagePath,
+// newDirs returns the directories of the entry name not in dirs,
+// outermost first, with the entry itself when it is a directory.
+func newDirs(dirs map[string]bool, name string, isDir bool) []string {
+	d := path.Clean(name)
+	if !isDir {
+		d = path.Dir(d)
+	}
+	var missing []string
+	// the parents of a directory written are written before it
+	for ; d != "." && d != "/" && !dirs[d]; d = path.Dir(d) {
+		missing = append(missing, d)
 	}
+	slices.Reverse(missing)
+	return missing
+}
 
-	redirectTmpl, err := template.ParseFS(templateFS, "templates/index-redirect.html")
-	if err != nil {
-		return nil, fmt.Errorf("error parsing index redirect template: %v", err)
+// reporter returns the reporter of the context, discarding the events
+// without one.
+func reporter(ctx context.Context) progress.Reporter {
+	if rep := progress.FromContext(ctx); rep != nil {
+		return rep
 	}
+	return progress.ReporterFunc(func(progress.Event) {})
+}
+
+// redirectPageSize is the size expected for a redirect page.
+const redirectPageSize = 1 << 10
 
+// buildRedirectPage returns the index page redirecting to the file of the
+// tar at pagePath.
+func (idx *SwarmZimIndexer) buildRedirectPage(pagePath string) (*bytes.Buffer, error) {
+	t, err := idx.pageTheme()
+	if err != nil {
+		return nil, err
+	}
 	var buf bytes.Buffer
-	if err := redirectTmpl.ExecuteTemplate(&buf, "index-redirect.html", tmplData); err != nil {
+	if err := t.writeRedirectPage(&buf, relativeLink("index.html", pagePath), idx.pageData("index.html", map[string]interface{}{})); err != nil {
 		return nil, err
 	}
 	return &buf, nil
 }
 
+// IndexMode is the kind of the index.html page of a tar, see MakeIndexPage.
+type IndexMode int
+
+const (
+	// IndexRedirect redirects to the main page of the ZIM, or to the pages
+	// listing its articles without one.
+	IndexRedirect IndexMode = iota
+	// IndexSearch is the page with the text search tool, embedding the
+	// main page, or the pages listing the articles without one.
+	IndexSearch
+	// IndexListing redirects to the pages listing the articles of the ZIM
+	// from A to Z, even with a main page.
+	IndexListing
+)
+
+func (m IndexMode) String() string {
+	switch m {
+	case IndexRedirect:
+		return "redirect"
+	case IndexSearch:
+		return "search"
+	case IndexListing:
+		return "listing"
+	}
+	return fmt.Sprintf("IndexMode(%d)", int(m))
+}
+
+// ErrIndexWritten is returned when writing the index page of an indexer
+// which already wrote one since its last parse, as the tar would hold two
+// index.html.
+var ErrIndexWritten = errors.New("index page already written")
+
+// MakeIndexPage appends the index.html page of the mode to the tar, and
+// the pages it links to, see MakeListingPages. It returns ErrIndexWritten
+// when the indexer already wrote an index page since its last parse, e.g.
+// with WritePages.
+func (idx *SwarmZimIndexer) MakeIndexPage(tarFile string, mode IndexMode) error {
+	return idx.writeIndexPage(AppendTo(tarFile), mode)
+}
+
+func (idx *SwarmZimIndexer) writeIndexPage(w FileWriter, mode IndexMode) error {
+	if mode < IndexRedirect || mode > IndexListing {
+		return fmt.Errorf("invalid index mode %v", mode)
+	}
+	idx.mu.Lock()
+	written := idx.indexWritten
+	idx.indexWritten = true
+	idx.mu.Unlock()
+	if written {
+		return fmt.Errorf("%s: %w", w.Name(), ErrIndexWritten)
+	}
+
+	switch mode {
+	case IndexSearch:
+		return idx.writeIndexSearchPage(w)
+	case IndexListing:
+		return idx.writeListingIndexPage(w)
+	}
+	return idx.writeRedirectIndexPage(w)
+}
+
+// resetIndexPage allows the index page of the next tar to be written.
+func (idx *SwarmZimIndexer) resetIndexPage() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.indexWritten = false
+}
+
 // MakeRedirectIndexPage creates an redirect index to the main page
-// when it exists in the zim archive.
+// when it exists in the zim archive, and to the pages listing its
+// articles otherwise, see MakeListingPages.
+//
+// Deprecated: use MakeIndexPage with IndexRedirect.
 func (idx *SwarmZimIndexer) MakeRedirectIndexPage(tarFile string) error {
-	log.Printf("Appending redirect index.html to %s", filepath.Base(tarFile))
+	return idx.MakeIndexPage(tarFile, IndexRedirect)
+}
 
-	mainPage, err := idx.Z.MainPage()
+func (idx *SwarmZimIndexer) writeRedirectIndexPage(w FileWriter) error {
+	mainURL, err := idx.mainURL(w)
 	if err != nil {
 		return err
 	}
 
-	// TODO: handle the case where there is no main page in the article.
-	// Should we add an index and browse all articles?
-	if mainPage == nil {
-		return errors.New("no index found in the ZIM")
-	}
-
-	buf, err := buildRedirectPage(mainPage.FullURL())
+	idx.logger().Info("appending page", "page", "index.html", "tar", w.Name())
+	buf, err := idx.buildRedirectPage(mainURL)
 	if err != nil {
 		return err
 	}
 
-	return tarball.AppendTarFile(tarFile, tarball.NewBufferFile("index.html", buf))
+	return w.WriteFile(tarball.NewBufferFile("index.html", buf))
 }
 
-// parseTemplate parses a given template and replace content when requested
-func parseTemplate(contentTmpl string, data interface{}) (*bytes.Buffer, error) {
-	baseTmpl, err := template.ParseFS(templateFS, "templates/page/*.html")
+// writeListingIndexPage writes the pages listing the articles, and an
+// index redirecting to them.
+func (idx *SwarmZimIndexer) writeListingIndexPage(w FileWriter) error {
+	n, err := idx.writeListingPages(w, true)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing base templates: %v", err)
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: no article to list", filepath.Base(idx.ZimPath))
 	}
 
-	// add dynamic content to pages
-	// FIXME: current we only support replace the content. Maybe we can improve that in the future do to something like Hugo does, or use Hugo instead.
-	if contentTmpl != "" {
-		tmpl, err := template.New("content").ParseFS(templateFS, fmt.Sprintf("templates/%s", contentTmpl))
-		if err != nil {
-			return nil, err
-		}
+	idx.logger().Info("appending page", "page", "index.html", "tar", w.Name())
+	buf, err := idx.buildRedirectPage(ListingIndex)
+	if err != nil {
+		return err
+	}
 
-		// don't attempt to add in the tree if their is nothing to be added
-		if tmpl != nil && tmpl.Tree != nil {
-			_, err = baseTmpl.AddParseTree("content", tmpl.Tree)
-			if err != nil {
-				return nil, err
-			}
-		}
+	return w.WriteFile(tarball.NewBufferFile("index.html", buf))
+}
+
+// mainURL returns the path of the main page of the ZIM, see
+// SelectMainPage, or of the pages listing its articles, appended to the
+// tar, when it has none.
+func (idx *SwarmZimIndexer) mainURL(w FileWriter) (string, error) {
+	mainURL, err := idx.SelectMainPage()
+	if err != nil || mainURL != "" {
+		return mainURL, err
+	}
+
+	idx.logger().Warn("zim has no main page, listing its articles", "file", filepath.Base(idx.ZimPath))
+	n, err := idx.writeListingPages(w, true)
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return "", fmt.Errorf("%s: %w", filepath.Base(idx.ZimPath), ErrNoMainPage)
 	}
+	return ListingIndex, nil
+}
 
+// parseTemplate parses a given template and replace content when requested
+func (idx *SwarmZimIndexer) parseTemplate(contentTmpl string, data interface{}) (*bytes.Buffer, error) {
 	var buf bytes.Buffer
-	if err := baseTmpl.ExecuteTemplate(&buf, "page", data); err != nil {
+	if err := idx.executeTemplate(&buf, contentTmpl, data); err != nil {
 		return nil, err
 	}
-
 	return &buf, nil
 }
 
+// executeTemplate writes the page of the content template to w.
+func (idx *SwarmZimIndexer) executeTemplate(w io.Writer, contentTmpl string, data interface{}) error {
+	t, err := idx.pageTheme()
+	if err != nil {
+		return err
+	}
+	return t.executePage(w, contentTmpl, data)
+}
+
 // makePage creates a page with a given template data
-func makePage(name, template string, tmplData map[string]interface{}, tarFile string) error {
-	log.Printf("Appending %s page to %s", name, filepath.Base(tarFile))
+func (idx *SwarmZimIndexer) makePage(name, template string, tmplData map[string]interface{}, w FileWriter) error {
+	idx.logger().Info("appending page", "page", name, "tar", w.Name())
 
-	buf, err := parseTemplate(template, tmplData)
+	buf, err := idx.parseTemplate(template, tmplData)
 	if err != nil {
 		return err
 	}
 
-	return tarball.AppendTarFile(tarFile, tarball.NewBufferFile(name, buf))
+	return w.WriteFile(tarball.NewBufferFile(name, buf))
 }
 
-type Node struct {
-	Path     string  `json:"path"`
-	Icon     string  `json:"icon"`
-	MimeType string  `json:"mimeType"`
-	Title    string  `json:"title"`
-	Redirect bool    `json:"redirect"`
-	Nodes    []*Node `json:"nodes"`
-}
-
-func groupDataByPrefix(idxEntries map[string]IndexEntry) map[string]*Node {
-	m := make(map[string]*Node)
-	for p, entry := range idxEntries {
-		n := &Node{
-			Path:     entry.Path,
-			MimeType: entry.Metadata.MimeType,
-			Title:    entry.Metadata.Title,
-			Redirect: entry.Metadata.Redirect,
-			Icon:     "",
-		}
-		var id string
-		switch path.Dir(p)[0] {
-		case '-':
-			id = "Assets"
-		case 'A', 'C':
-			id = "Articles"
-		case 'B':
-			id = "Articles Metadata"
-		case 'I', 'J':
-			id = "Media"
-		case 'M':
-			id = "Metadata"
-		case 'X':
-			id = "Indexes"
-		default:
-			id = "Others" // TODO: handle categories: U,V,W
+// writeEntriesPage appends the page listing the entries written by render
+// to w. The page is held in memory, or written to the directory of the
+// DiskStore first, as large as the entries are.
+func (idx *SwarmZimIndexer) writeEntriesPage(name string, w FileWriter, render func(io.Writer) error) error {
+	idx.logger().Info("appending page", "page", name, "tar", w.Name())
+	idx.mu.Lock()
+	dir := spoolDir(idx.entries)
+	idx.mu.Unlock()
+	if dir == "" {
+		var buf bytes.Buffer
+		if err := render(&buf); err != nil {
+			return err
 		}
+		return w.WriteFile(tarball.NewBufferFile(name, &buf))
+	}
 
-		if _, ok := m[id]; !ok {
-			m[id] = &Node{
-				Path:  id,
-				Icon:  "", //TODO add icons style
-				Nodes: make([]*Node, 0),
-			}
+	f, err := os.CreateTemp(dir, "page-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	bw := bufio.NewWriter(f)
+	if err := render(bw); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return w.WriteFile(tarball.NewSizedReaderFile(name, f, size))
+}
+
+// writeEntriesJSON writes the entries of the parses of the indexer as a
+// JSON object by path, the bytes json.Marshal gives the map of
+// EntriesSnapshot, one entry at a time.
+func (idx *SwarmZimIndexer) writeEntriesJSON(w io.Writer) error {
+	var err error
+	sep := "{"
+	idx.ForEachEntry(func(e IndexEntry) bool {
+		var key, value []byte
+		if key, err = json.Marshal(e.Path); err != nil {
+			return false
 		}
-		m[id].Nodes = append(m[id].Nodes, n)
+		if value, err = json.Marshal(e); err != nil {
+			return false
+		}
+		if _, err = fmt.Fprintf(w, "%s%s:%s", sep, key, value); err != nil {
+			return false
+		}
+		sep = ","
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if sep == "{" {
+		_, err = io.WriteString(w, "{}")
+	} else {
+		_, err = io.WriteString(w, "}")
+	}
+	return err
+}
+
+type Node struct {
+	Path     string `json:"path"`
+	Icon     string `json:"icon"`
+	MimeType string `json:"mimeType"`
+	Title    string `json:"title"`
+	Redirect bool   `json:"redirect"`
+	// Size is the size of the article in the ZIM, as "1.5 KiB", empty
+	// when unknown.
+	Size  string  `json:"size,omitempty"`
+	Nodes []*Node `json:"nodes"`
+}
+
+// Link returns the link to the file of the node from the files page, its
+// path escaped.
+func (n *Node) Link() string {
+	return relativeLink("files.html", n.Path)
+}
+
+// fileNode returns the node of the files page of the entry.
+func fileNode(entry IndexEntry) *Node {
+	n := &Node{
+		Path:     entry.Path,
+		MimeType: entry.Metadata.MimeType,
+		Title:    entry.Metadata.Title,
+		Redirect: entry.Metadata.Redirect,
+		Icon:     "",
+	}
+	if entry.Size > 0 || entry.SHA256 != "" {
+		n.Size = formatBytes(entry.Size)
+	}
+	return n
+}
+
+// formatBytes returns the size in bytes in binary units, e.g. "1.5 KiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
 	}
-	return m
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
 }
 
 // MakeIndexSearchPage creates a custom index with the text search tool and
 // embed the current main page in the new index.
+//
+// Deprecated: use MakeIndexPage with IndexSearch.
 func (idx *SwarmZimIndexer) MakeIndexSearchPage(tarFile string) error {
-	mainPage, err := idx.Z.MainPage()
+	return idx.MakeIndexPage(tarFile, IndexSearch)
+}
+
+func (idx *SwarmZimIndexer) writeIndexSearchPage(w FileWriter) error {
+	mainURL, err := idx.SelectMainPage()
 	if err != nil {
 		return err
 	}
-
-	mainURL := ""
-	if mainPage != nil {
-		mainURL = mainPage.FullURL()
+	// the articles can be browsed from A to Z beside the search, the
+	// listing being embedded instead of the main page without one
+	listed, err := idx.writeListingPages(w, mainURL == "")
+	if err != nil {
+		return err
+	}
+	if mainURL == "" && listed > 0 {
+		mainURL = ListingIndex
+	}
+	exceptions := idx.Exceptions()
+	metadata := idx.ExtractMetadata()
+	var mainLink string
+	if mainURL != "" {
+		mainLink = relativeLink("index.html", mainURL)
 	}
 
-	tmplData := map[string]interface{}{
+	tmplData := idx.pageData("index.html", map[string]interface{}{
 		"File":        filepath.Base(idx.ZimPath),
-		"Count":       strconv.Itoa(int(idx.Z.ArticleCount)),
-		"Articles":    groupDataByPrefix(idx.entries),
+		"Count":       strconv.Itoa(idx.EntryCount() - len(idx.TooLarge()) - len(exceptions)),
+		"Exceptions":  len(exceptions),
 		"HasMainPage": (mainURL != ""),
-		"MainURL":     mainURL,
-	}
+		"MainURL":     mainLink,
+		"Listing":     listed > 0,
+		"ListingURL":  ListingIndex,
+		"Provenance":  idx.Provenance,
+		"Metadata":    metadata,
+		// the search page links to no entry of the X namespace once left out
+		"FullTextSearch": !idx.DropSearchIndexes,
+	})
 
 	// make about's page using about template
-	if err = makePage("about.html", "about.html", tmplData, tarFile); err != nil {
-		return err
-	}
-
-	// make browse files page using files template
-	if err = makePage("files.html", "files.html", tmplData, tarFile); err != nil {
+	if err = idx.makePage("about.html", "about.html", tmplData, w); err != nil {
 		return err
 	}
 
-	// make files page in JSON format
-	if file, err := json.Marshal(idx.entries); err == nil {
-		if err = tarball.AppendTarFile(tarFile, tarball.NewBufferFile("files.json", bytes.NewBuffer(file))); err != nil {
+	// make browse files page using files template, and files page in
+	// JSON format, reading the entries as they are written
+	if err = idx.writeEntriesPage("files.html", w, func(out io.Writer) error {
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		groups, release, err := idx.fileGroups(stop, &wg)
+		if err != nil {
 			return err
 		}
+		defer release()
+		defer wg.Wait()
+		defer close(stop)
+		data := maps.Clone(tmplData)
+		data["Articles"] = groups
+		return idx.executeTemplate(out, "files.html", data)
+	}); err != nil {
+		return err
+	}
+	if err = idx.writeEntriesPage("files.json", w, idx.writeEntriesJSON); err != nil {
+		return err
+	}
+	// shards of the titles for the title search, and the titles of the
+	// ZIM for the suggestions
+	if err = idx.writeSearchShards(w); err != nil {
+		return err
+	}
+	if err = idx.writeTitlesFile(w); err != nil {
+		return err
+	}
+	if err = idx.EntriesErr(); err != nil {
+		return err
 	}
 
 	// make page for displaying search results
-	if err = makePage("searchresult.html", "searchresult.html", tmplData, tarFile); err != nil {
+	if err = idx.makePage("searchresult.html", "searchresult.html", tmplData, w); err != nil {
 		return err
 	}
 
 	// make index page using index-search template
-	return makePage("index.html", "index-search.html", tmplData, tarFile)
+	return idx.makePage("index.html", "index-search.html", tmplData, w)
 }
 
-// MakeErrorPage creates an error page
+// MakeErrorPage creates an error page, the error document of the uploads,
+// served for every missing path. It links to the main page, if any, and
+// with the search suggests the titles closest to the name of the missing
+// page, with a search box of the title search.
 func (idx *SwarmZimIndexer) MakeErrorPage(tarFile string) error {
-	data, err := fs.ReadFile(templateFS, "templates/error.html")
+	return idx.writeErrorPage(AppendTo(tarFile))
+}
+
+func (idx *SwarmZimIndexer) writeErrorPage(w FileWriter) error {
+	t, err := idx.pageTheme()
+	if err != nil {
+		return err
+	}
+	mainURL, err := idx.SelectMainPage()
 	if err != nil {
 		return err
 	}
+	data := map[string]interface{}{"Search": idx.enableSearch}
+	if mainURL != "" {
+		data["MainURL"] = relativeLink("error.html", mainURL)
+	}
+	var buf bytes.Buffer
+	if err := t.execute(&buf, "error.html", idx.pageData("error.html", data)); err != nil {
+		return err
+	}
+
+	return w.WriteFile(tarball.NewBufferFile("error.html", &buf))
+}
+
+// AssetsPrefix is the directory of the tars of the assets of the DApp,
+// the style sheets and scripts of its pages, see MakeAssets.
+const AssetsPrefix = "_beezim/assets"
 
-	return tarball.AppendTarFile(tarFile, tarball.NewBytesFile("error.html", data))
+// MakeAssets appends the assets of the DApp to the tar under AssetsPrefix,
+// those of WithAssetsDir when set. WritePages appends them with the search
+// pages, and UnZim writes them to the directory it extracts to then.
+func (idx *SwarmZimIndexer) MakeAssets(tarFile string) error {
+	return idx.writeAssets(AppendTo(tarFile))
 }
 
+// AddAssets appends the embedded assets of the DApp to the tar.
 func AddAssets(tarFile string) error {
-	log.Printf("Appending assets to %s", filepath.Base(tarFile))
+	t, err := defaultTheme()
+	if err != nil {
+		return err
+	}
+	return t.writeAssets(AppendTo(tarFile))
+}
+
+// writeAssets appends the assets of the indexer, see MakeAssets.
+func (idx *SwarmZimIndexer) writeAssets(w FileWriter) error {
+	t, err := idx.pageTheme()
+	if err != nil {
+		return err
+	}
+	return t.writeAssets(w)
+}
+
+func (t *theme) writeAssets(w FileWriter) error {
+	return t.walkAssets(func(name string, data []byte) error {
+		return w.WriteFile(tarball.NewBytesFile(name, data))
+	})
+}
 
-	return fs.WalkDir(assetsFS, ".", func(path string, d fs.DirEntry, err error) error {
+// walkAssets calls fn with the name under AssetsPrefix and the content of
+// each asset of the theme.
+func (t *theme) walkAssets(fn func(name string, data []byte) error) error {
+	return fs.WalkDir(t.assets, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -466,15 +1523,44 @@ func AddAssets(tarFile string) error {
 			return nil
 		}
 
-		data, err := fs.ReadFile(assetsFS, path)
+		data, err := fs.ReadFile(t.assets, path)
 		if err != nil {
 			return err
 		}
 
-		if err = tarball.AppendTarFile(tarFile, tarball.NewBytesFile(path, data)); err != nil {
-			return err
-		}
-
-		return nil
+		return fn(AssetsPrefix+"/"+path, data)
 	})
 }
+
+// Version is a previous version of a mirror listed in its history page.
+type Version struct {
+	Root        string
+	ZimFile     string
+	PublishedAt time.Time
+	// Available is false when the root could not be retrieved, Error
+	// telling why.
+	Available bool
+	Error     string
+}
+
+// HistoryFile is the name in the tars of the page of MakeHistoryPage.
+const HistoryFile = "history.html"
+
+// MakeHistoryPage creates a page listing the previous versions of the
+// wiki, newest first.
+func MakeHistoryPage(tarFile string, wiki string, versions []Version) error {
+	t, err := defaultTheme()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	data := map[string]interface{}{
+		"Wiki":     wiki,
+		"Versions": versions,
+	}
+	if err := t.execute(&buf, HistoryFile, data); err != nil {
+		return err
+	}
+	return tarball.AppendTarFile(tarFile, tarball.NewBufferFile(HistoryFile, &buf))
+}
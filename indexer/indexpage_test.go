@@ -0,0 +1,101 @@
+package indexer_test
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/r0qs/beezim/indexer"
+)
+
+// tarCount returns the number of entries of the tar named name.
+func tarCount(t *testing.T, tarFile, name string) int {
+	t.Helper()
+	f, err := os.Open(tarFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n := 0
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return n
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name == name {
+			n++
+		}
+	}
+}
+
+func TestMakeIndexPageOnce(t *testing.T) {
+	modes := []indexer.IndexMode{indexer.IndexRedirect, indexer.IndexSearch, indexer.IndexListing}
+	// the index pages written by the deprecated functions and WritePages,
+	// after or before those of the modes
+	writers := map[string]func(idx *indexer.SwarmZimIndexer, tarFile string) error{
+		"MakeRedirectIndexPage": func(idx *indexer.SwarmZimIndexer, tarFile string) error {
+			return idx.MakeRedirectIndexPage(tarFile)
+		},
+		"MakeIndexSearchPage": func(idx *indexer.SwarmZimIndexer, tarFile string) error {
+			return idx.MakeIndexSearchPage(tarFile)
+		},
+		"WritePages": func(idx *indexer.SwarmZimIndexer, tarFile string) error {
+			return idx.WritePages(indexer.AppendTo(tarFile))
+		},
+	}
+	for _, m := range modes {
+		writers[m.String()] = func(idx *indexer.SwarmZimIndexer, tarFile string) error {
+			return idx.MakeIndexPage(tarFile, m)
+		}
+	}
+	for first, writeFirst := range writers {
+		for second, writeSecond := range writers {
+			t.Run(first+"/"+second, func(t *testing.T) {
+				idx := newIndexer(t, groupsZim())
+				tarFile := tarZim(t, idx)
+				if err := writeFirst(idx, tarFile); err != nil {
+					t.Fatal(err)
+				}
+				if err := writeSecond(idx, tarFile); !errors.Is(err, indexer.ErrIndexWritten) {
+					t.Errorf("got %v, want %v", err, indexer.ErrIndexWritten)
+				}
+				if n := tarCount(t, tarFile, "index.html"); n != 1 {
+					t.Errorf("%d index.html in the tar, want 1", n)
+				}
+			})
+		}
+	}
+
+	// the tar of the next parse gets its own index page
+	idx := newIndexer(t, groupsZim())
+	for i := range 2 {
+		tarFile := tarZim(t, idx)
+		if err := idx.MakeIndexPage(tarFile, indexer.IndexSearch); err != nil {
+			t.Fatalf("parse %d: %v", i, err)
+		}
+		if n := tarCount(t, tarFile, "index.html"); n != 1 {
+			t.Errorf("parse %d: %d index.html in the tar, want 1", i, n)
+		}
+	}
+}
+
+func TestMakeIndexPageMode(t *testing.T) {
+	idx := newIndexer(t, groupsZim())
+	tarFile := tarZim(t, idx)
+	if err := idx.MakeIndexPage(tarFile, indexer.IndexMode(3)); err == nil {
+		t.Fatal("wrote the index page of an invalid mode")
+	}
+	if n := tarCount(t, tarFile, "index.html"); n != 0 {
+		t.Errorf("%d index.html in the tar, want none", n)
+	}
+	// the invalid mode writes nothing, the index is still to be written
+	if err := idx.MakeIndexPage(tarFile, indexer.IndexListing); err != nil {
+		t.Fatal(err)
+	}
+}
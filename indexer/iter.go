@@ -0,0 +1,351 @@
+package indexer
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"iter"
+	"maps"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/r0qs/beezim/internal/progress"
+)
+
+// Articles returns the articles of the ZIM, with the same filters and
+// pages as ParseZIM, read when the loop asks for the next one instead of
+// by a goroutine, or a few ahead by the ReadWorkers when there are
+// several. The loop body owns each article and must Release it.
+// The body runs while the ZIM is held, so it must not read a ZIM, e.g.
+// with MakeIndexPage; breaking out of the loop ends the parse
+// right away. An error is yielded, ending the iteration, when the ZIM
+// can not be read (see ParseErr) or the context is canceled. The entries
+// that can not be read are skipped and recorded, see OnEntryError, and
+// so are those making the reader panic, see PanicBudget; their exception
+// files are yielded instead, see ExceptionsDir.
+func (idx *SwarmZimIndexer) Articles(ctx context.Context) iter.Seq2[Article, error] {
+	return func(yield func(Article, error) bool) {
+		if err := idx.startPass(); err != nil {
+			yield(Article{}, err)
+			return
+		}
+		idx.articles(ctx, yield)
+	}
+}
+
+// startPass claims the reader for a parse, recording in parseErr why it
+// can not.
+func (idx *SwarmZimIndexer) startPass() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.parseErr = nil
+	if idx.closed {
+		idx.parseErr = fmt.Errorf("%s: %w", idx.ZimPath, fs.ErrClosed)
+	} else if err := beginPass(idx.Z); err != nil {
+		idx.parseErr = fmt.Errorf("%s: %w", idx.ZimPath, err)
+	}
+	return idx.parseErr
+}
+
+// articles yields the articles of the pass claimed by startPass, and
+// ends it.
+func (idx *SwarmZimIndexer) articles(ctx context.Context, yield func(Article, error) bool) {
+	defer endPass(idx.Z)
+
+	// the redirect pages are in the language of the ZIM, and the favicon
+	// and the title of the navigation bar extracted from it, read before
+	// the reader is locked
+	idx.pageLocale()
+	idx.zimFavicon()
+	if idx.NavBar {
+		idx.zimTitle()
+	}
+	zimMu.Lock()
+	defer zimMu.Unlock()
+	workers := int64(max(idx.ReadWorkers, 1))
+	n, err := idx.Workers.Acquire(ctx, workers)
+	if err != nil {
+		idx.mu.Lock()
+		idx.parseErr = err
+		idx.mu.Unlock()
+		yield(Article{}, err)
+		return
+	}
+	defer idx.Workers.Release(n)
+	if idx.Workers != nil {
+		// the pool may be smaller than the workers asked for
+		workers = n
+	}
+
+	// articles are reported to the reporter of the context, or to
+	// Progress without one
+	total := int64(idx.Z.ArticleCount()) - idx.countFilteredOut()
+	rep := progressReporter(ctx, idx.Progress, idx.Logger, idx.ZimPath)
+
+	kv := []any{"file", filepath.Base(idx.ZimPath), "articles", total, "workers", workers}
+	if v, ok := idx.Z.(versioned); ok {
+		major, minor := v.Version()
+		kv = append(kv, "version", fmt.Sprintf("%d.%d", major, minor), "newNamespaces", NewNamespaceScheme(major, minor))
+	}
+	idx.logger().Info("parsing zim", kv...)
+	start := time.Now()
+	skippedBefore := len(idx.Skipped())
+	idx.resetDedup()
+	idx.resetMinify()
+	idx.resetOffline()
+	idx.resetRelativeLinks()
+	idx.resetSearchIndexes()
+	idx.resetMimeFixes()
+	idx.resetIndexPage()
+	idx.resetDiff()
+	var done, parsed int64
+	// stopped is set once yield returned false or an error was yielded,
+	// inBody while the loop body runs
+	stopped, inBody := false, false
+	fail := func(err error) {
+		idx.mu.Lock()
+		idx.parseErr = err
+		idx.mu.Unlock()
+		stopped = true
+		yield(Article{}, err)
+	}
+	collisions, err := idx.scanCollisions()
+	if err != nil {
+		fail(err)
+	}
+	// the entries before StartAt, or those of the tar resumed, are
+	// skipped, and counted as parsed
+	var startSkipped atomic.Int64
+	from := idx.startFilter()
+	skip := func(i uint32) bool {
+		if from(i) {
+			startSkipped.Add(1)
+			return true
+		}
+		return false
+	}
+	// send yields the article of an entry read
+	send := func(r entryRead) {
+		// a canceled parse is recorded, so that the sinks do not finish
+		// what is left of the ZIM as if it was complete
+		if err := ctx.Err(); err != nil {
+			r.a.Release()
+			fail(err)
+			return
+		}
+		if r.err == nil && !r.ok && idx.filteredOut(r.entry) {
+			return
+		}
+		defer func() {
+			done++
+			rep.Report(progress.Event{Stage: "parse", Done: done + startSkipped.Load(), Total: total, Bytes: parsed})
+		}()
+
+		if r.err != nil {
+			fail(r.err)
+			return
+		}
+		if !r.ok {
+			return
+		}
+		if collisions[r.i] {
+			r.a.Release()
+			idx.collide(r.entry.FullURL())
+			return
+		}
+		r.a.index = r.i
+		var duplicate string
+		if r.entry == nil || !r.entry.IsRedirect() {
+			duplicate = idx.dedup(&r.a)
+		}
+		size := r.a.Size()
+		if idx.unchanged(&r.a) {
+			// in the tar of the previous version, recorded as an entry
+			r.a.Release()
+		} else {
+			inBody = true
+			more := yield(r.a, nil)
+			inBody = false
+			if !more {
+				stopped = true
+				return
+			}
+		}
+		parsed += size
+		if r.a.exception {
+			// recorded with the entry that could not be extracted
+			return
+		}
+		e := IndexEntry{
+			Path: r.entry.FullURL(),
+			Metadata: IndexMetadata{
+				Title:     r.entry.Title(),
+				MimeType:  r.a.mime,
+				Redirect:  r.entry.IsRedirect(),
+				Target:    r.a.target,
+				Duplicate: duplicate,
+				Snippet:   r.a.snippet,
+			},
+		}
+		if r.a.summed {
+			e.Size, e.SHA256 = r.a.size, hex.EncodeToString(r.a.sum[:])
+		}
+		idx.addParsedEntry(r.i, e)
+		if err := idx.EntriesErr(); err != nil {
+			fail(err)
+		}
+	}
+	func() {
+		// the panics of the reader out of an entry, e.g. reading the title
+		// index, stop the parse; those of the loop body are its own
+		defer func() {
+			if r := recover(); r != nil {
+				if inBody {
+					panic(r)
+				}
+				stack := debug.Stack()
+				if p, ok := r.(iterPanic); ok {
+					r, stack = p.value, p.stack
+				}
+				idx.logger().Error("zim reader panicked", "file", filepath.Base(idx.ZimPath), "panic", fmt.Sprint(r), "stack", string(stack))
+				if !stopped {
+					fail(fmt.Errorf("%s: %w: reader panicked: %v", idx.ZimPath, ErrZimCorrupt, r))
+				}
+			}
+		}()
+		if stopped {
+			return
+		}
+		if workers > 1 {
+			idx.readConcurrently(int(workers), func() bool { return stopped }, skip, send)
+			return
+		}
+		idx.Z.Iterate(func(i uint32) {
+			// the iterator of the reader can not be stopped, skip the
+			// remaining articles
+			if stopped || skip(i) {
+				return
+			}
+			send(idx.readAt(i))
+		})
+	}()
+	if err := idx.EntriesErr(); err != nil && !stopped {
+		// e.g. of the entry of an article left out for its size
+		fail(err)
+	}
+	if !stopped {
+		idx.endDiff()
+	}
+	rep.Report(progress.Event{Stage: "parse", Done: done + startSkipped.Load(), Total: total, Bytes: parsed, Finished: true})
+	idx.logger().Info("zim parsed", "file", filepath.Base(idx.ZimPath), "articles", done, "elapsed", time.Since(start))
+	if n := startSkipped.Load(); n > 0 {
+		idx.logger().Info("entries skipped before the start", "file", filepath.Base(idx.ZimPath), "count", n)
+	}
+	if n := len(idx.Skipped()) - skippedBefore; n > 0 {
+		idx.logger().Warn("articles failed to extract", "file", filepath.Base(idx.ZimPath), "count", n)
+	}
+	if d := idx.DedupStats(); d.Duplicates > 0 {
+		idx.logger().Info("duplicated articles", "file", filepath.Base(idx.ZimPath), "articles", d.Duplicates, "bytes", d.Bytes, "aliased", d.Aliased, "savedBytes", d.Saved)
+	}
+	if m := idx.MinifyStats(); m.Minified > 0 || m.Invalid > 0 {
+		idx.logger().Info("minified articles", "file", filepath.Base(idx.ZimPath), "articles", m.Minified, "savedBytes", m.Saved, "invalid", m.Invalid)
+	}
+	if r := idx.RelativeLinksStats(); r.Articles > 0 {
+		idx.logger().Info("root-absolute links made relative", "file", filepath.Base(idx.ZimPath), "articles", r.Articles, "links", r.Links)
+	}
+	if o := idx.OfflineStats(); o.Articles > 0 {
+		idx.logger().Info("articles rewritten for offline browsing", "file", filepath.Base(idx.ZimPath), "articles", o.Articles, "links", o.Links, "resources", o.Resources, "policy", idx.Offline)
+	}
+	if s := idx.SearchIndexStats(); s.Entries > 0 {
+		idx.logger().Info("search indexes left out", "file", filepath.Base(idx.ZimPath), "entries", s.Entries, "bytes", s.Bytes)
+	}
+	if m := idx.MimeFixes(); m.Overridden > 0 || m.Sniffed > 0 {
+		idx.logger().Info("mime types corrected", "file", filepath.Base(idx.ZimPath), "overridden", m.Overridden, "sniffed", m.Sniffed)
+	}
+	if idx.Previous != nil && !stopped {
+		d := idx.Diff()
+		idx.logger().Info("articles diffed with the previous version", "file", filepath.Base(idx.ZimPath), "added", len(d.Added), "changed", len(d.Changed), "removed", len(d.Removed), "unchanged", d.Unchanged)
+	}
+	filtered := idx.MimeFiltered()
+	for _, t := range slices.Sorted(maps.Keys(filtered)) {
+		idx.logger().Info("articles left out by mime type", "file", filepath.Base(idx.ZimPath), "mime", t, "articles", filtered[t].Articles, "bytes", filtered[t].Bytes, "placeholders", idx.MimePlaceholders)
+	}
+}
+
+// included reports whether the entry is parsed.
+func (idx *SwarmZimIndexer) included(entry ZimEntry) bool {
+	ns := entry.Namespace()
+	return idx.namespaces.Keeps(ns, idx.parsedByDefault(ns))
+}
+
+// parsedByDefault reports whether the entries of the namespace are parsed
+// without namespace filter.
+func (idx *SwarmZimIndexer) parsedByDefault(ns byte) bool {
+	// FIXME: for now, all namespaces are considered equal when parsing
+	// https://openzim.org/wiki/ZIM_file_format and
+	// https://openzim.org/wiki/ZIM_file_format_old_namespace
+	//
+	// Namespaces:
+	// '-': Assets (CSS, JS, Favicon)
+	// 'A': Text files (Article Format)
+	// 'I': Media files
+	// 'M': ZIM Metadata
+	// 'X': Search indexes (Xapian DB)
+	switch ns {
+	case '-', 'A', 'B', 'C', 'I', 'J', 'U', 'W':
+		// TODO: handle categories: https://openzim.org/wiki/Category_Handling
+		// TODO: handle well known entries: https://openzim.org/wiki/Well_known_entries
+		return true
+	case 'M', 'X':
+		//FIXME: handle cases where the zim file was created without xapian
+		// https://github.com/openzim/libzim/blob/11258f9e624d5b288610b7dc6752b62a0af317c2/README.md#compilation
+		return idx.enableSearch
+	default:
+		return false
+	}
+}
+
+// UnZimArticles writes the articles of the iterator, e.g. Articles, to
+// outputDir, as UnZimBatches. It returns the first error yielded.
+func (idx *SwarmZimIndexer) UnZimArticles(ctx context.Context, outputDir string, articles iter.Seq2[Article, error]) error {
+	s, err := idx.newExtractSink(ctx, outputDir)
+	if err != nil {
+		return err
+	}
+	defer s.wait()
+	for a, err := range articles {
+		if err != nil {
+			return err
+		}
+		if err := s.write(&a); err != nil {
+			return err
+		}
+	}
+	if err := s.wait(); err != nil {
+		return err
+	}
+	return s.finish()
+}
+
+// TarZimArticles writes the articles of the iterator, e.g. Articles, to
+// tarFile. It returns the first error yielded.
+func (idx *SwarmZimIndexer) TarZimArticles(ctx context.Context, tarFile string, articles iter.Seq2[Article, error]) error {
+	s, err := idx.newTarSink(ctx, tarFile)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	for a, err := range articles {
+		if err != nil {
+			return err
+		}
+		if err := s.write(&a); err != nil {
+			return err
+		}
+	}
+	return s.finish()
+}
@@ -0,0 +1,147 @@
+package indexer_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/indexer/zimtest"
+)
+
+// iterZim returns a reader of articles, two of them redirected to, an
+// image and metadata left out of the parse.
+func iterZim() *zimtest.Reader {
+	r := articlesZim(100)
+	r.Entries = append(r.Entries,
+		zimtest.Redirect('A', "First", 0),
+		zimtest.Redirect('A', "Last", 99),
+		zimtest.Entry{Namespace: 'I', URL: "logo.png", Mime: "image/png", Content: []byte("\x89PNG\r\n\x1a\n")},
+		zimtest.Entry{Namespace: 'M', URL: "Title", Mime: "text/plain", Content: []byte("Test")},
+	)
+	return r
+}
+
+// TestArticlesIdentical writes the tar of the ZIM from the channel of
+// ParseZIM and from the iterator of Articles, read by one worker and by
+// several.
+func TestArticlesIdentical(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			ctx := context.Background()
+			idx := newIndexer(t, iterZim())
+			idx.ReadWorkers = workers
+			want := readTar(t, tarZim(t, idx))
+
+			tarFile := filepath.Join(t.TempDir(), "test.tar")
+			if err := idx.TarZimArticles(ctx, tarFile, idx.Articles(ctx)); err != nil {
+				t.Fatal(err)
+			}
+			got := readTar(t, tarFile)
+			if len(got) != len(want) {
+				t.Errorf("got %d files from the iterator, want %d", len(got), len(want))
+			}
+			for name, data := range want {
+				if !bytes.Equal(got[name], data) {
+					t.Errorf("%s: got %q from the iterator, want %q", name, got[name], data)
+				}
+			}
+
+			var paths, sent []string
+			for a, err := range idx.Articles(ctx) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				paths = append(paths, a.Path())
+				a.Release()
+			}
+			for a := range idx.ParseZIM(ctx) {
+				sent = append(sent, a.Path())
+				a.Release()
+			}
+			// several workers read the entries in any order
+			if workers > 1 {
+				slices.Sort(paths)
+				slices.Sort(sent)
+			}
+			if !slices.Equal(paths, sent) {
+				t.Errorf("iterated %v, the channel sent %v", paths, sent)
+			}
+		})
+	}
+}
+
+// TestArticlesBreak breaks out of the loop after the first article: the
+// read workers are gone and the reader is released once the loop ends.
+func TestArticlesBreak(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			ctx := context.Background()
+			idx := newIndexer(t, iterZim())
+			idx.ReadWorkers = workers
+			before := runtime.NumGoroutine()
+
+			n := 0
+			for a, err := range idx.Articles(ctx) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				a.Release()
+				n++
+				break
+			}
+			if n != 1 {
+				t.Fatalf("got %d articles, want 1", n)
+			}
+			settled(t, before)
+			if err := idx.ParseErr(); err != nil {
+				t.Errorf("break recorded %v", err)
+			}
+
+			// no pass holds the reader: another indexer of it can be closed
+			// and parse it
+			other := indexer.NewWithReader("test.zim", idx.Z, false)
+			other.Logger = idx.Logger
+			if got := len(parse(t, other)); got == 0 {
+				t.Error("parse after the break: no article")
+			}
+			if err := other.Close(); err != nil {
+				t.Errorf("close after the break: %v", err)
+			}
+		})
+	}
+}
+
+// TestTarZimArticlesError stops the tar at the error yielded by the
+// iterator, of its canceled context.
+func TestTarZimArticlesError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	idx := newIndexer(t, iterZim())
+	n := 0
+	articles := func(yield func(indexer.Article, error) bool) {
+		for a, err := range idx.Articles(ctx) {
+			if n++; n == 10 {
+				cancel()
+			}
+			if !yield(a, err) {
+				return
+			}
+		}
+	}
+	tarFile := filepath.Join(t.TempDir(), "test.tar")
+	if err := idx.TarZimArticles(ctx, tarFile, articles); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	if n != 11 {
+		t.Errorf("iterated %d times, want the tar stopped at the error after 10 articles", n)
+	}
+	if err := idx.ParseErr(); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
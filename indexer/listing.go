@@ -0,0 +1,298 @@
+package indexer
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/r0qs/beezim/internal/tarball"
+)
+
+const (
+	// ListingDir is the directory of the pages listing the articles of a
+	// ZIM from A to Z, ListingIndex their first page.
+	ListingDir   = "_listing"
+	ListingIndex = ListingDir + "/index.html"
+	// DefaultListingPageSize is the number of articles of a listing page
+	// when the ListingPageSize of the indexer is zero.
+	DefaultListingPageSize = 1000
+	// listingOther is the bucket of the titles not starting by a latin
+	// letter.
+	listingOther = "other"
+	// listingPassSize is the number of articles of the buckets collected
+	// by each pass over the entries of a DiskStore, which are read again
+	// for the next ones.
+	listingPassSize = 1 << 20
+)
+
+// listingLink is an article of a listing page, or a bucket of its bar.
+type listingLink struct {
+	Title string
+	Link  string
+	// Current is set for the bucket of the page in its bar.
+	Current bool
+}
+
+// listingBucket is the articles of a namespace whose title starts by the
+// same letter.
+type listingBucket struct {
+	Name  string
+	Count int
+	Link  string
+	// articles are collected by writeListingPages.
+	articles []listingArticle
+}
+
+// listingArticle is the title and path of an article of a bucket.
+type listingArticle struct {
+	title, path string
+}
+
+// listingKey is the namespace and name of a bucket.
+type listingKey struct {
+	ns, bucket string
+}
+
+// listingNamespace is the buckets of a namespace, by name.
+type listingNamespace struct {
+	Name    string
+	Count   int
+	Buckets []*listingBucket
+}
+
+// listingBucketName returns the bucket of the title: its first letter, in
+// upper case, or listingOther.
+func listingBucketName(title string) string {
+	r, _ := utf8.DecodeRuneInString(title)
+	r = unicode.ToUpper(r)
+	if r >= 'A' && r <= 'Z' {
+		return string(r)
+	}
+	return listingOther
+}
+
+// listingTitle returns the title of the entry, or its url, its path
+// without namespace, when it has none.
+func listingTitle(e IndexEntry) string {
+	if e.Metadata.Title != "" {
+		return e.Metadata.Title
+	}
+	if _, url, ok := strings.Cut(e.Path, "/"); ok && url != "" {
+		return url
+	}
+	return e.Path
+}
+
+// listed returns the namespace and bucket of the entry when it is listed:
+// the HTML articles, their redirects left out.
+func listed(e IndexEntry) (ns, bucket string, ok bool) {
+	m := e.Metadata
+	if m.Redirect || m.Skipped != "" || m.Exception != "" || baseMime(m.MimeType) != "text/html" {
+		return "", "", false
+	}
+	ns, _, _ = strings.Cut(e.Path, "/")
+	return ns, listingBucketName(listingTitle(e)), true
+}
+
+// listing returns the buckets of the HTML articles of the parses of the
+// indexer by namespace and first letter of their title, their articles
+// not collected yet.
+func (idx *SwarmZimIndexer) listing() []*listingNamespace {
+	byNamespace := make(map[string]map[string]*listingBucket)
+	idx.ForEachEntry(func(e IndexEntry) bool {
+		ns, name, ok := listed(e)
+		if !ok {
+			return true
+		}
+		if byNamespace[ns] == nil {
+			byNamespace[ns] = make(map[string]*listingBucket)
+		}
+		b := byNamespace[ns][name]
+		if b == nil {
+			b = &listingBucket{Name: name}
+			byNamespace[ns][name] = b
+		}
+		b.Count++
+		return true
+	})
+
+	var namespaces []*listingNamespace
+	for ns, buckets := range byNamespace {
+		n := &listingNamespace{Name: ns}
+		for _, b := range buckets {
+			b.Link = relativeLink(ListingIndex, listingPagePath(ns, b.Name, 1))
+			n.Count += b.Count
+			n.Buckets = append(n.Buckets, b)
+		}
+		// the catch-all bucket comes after the letters
+		slices.SortFunc(n.Buckets, func(x, y *listingBucket) int {
+			if (x.Name == listingOther) != (y.Name == listingOther) {
+				if x.Name == listingOther {
+					return 1
+				}
+				return -1
+			}
+			return strings.Compare(x.Name, y.Name)
+		})
+		namespaces = append(namespaces, n)
+	}
+	slices.SortFunc(namespaces, func(x, y *listingNamespace) int {
+		return strings.Compare(x.Name, y.Name)
+	})
+	return namespaces
+}
+
+// listingPagePath returns the path of the nth page of the bucket of the
+// namespace, from 1.
+func listingPagePath(ns, bucket string, n int) string {
+	name := bucket
+	if n > 1 {
+		name += "-" + strconv.Itoa(n)
+	}
+	return path.Join(ListingDir, ns, name+".html")
+}
+
+func (idx *SwarmZimIndexer) listingPageSize() int {
+	if idx.ListingPageSize > 0 {
+		return idx.ListingPageSize
+	}
+	return DefaultListingPageSize
+}
+
+// MakeListingPages appends to the tar the pages listing the HTML articles
+// of the ZIM from A to Z, by namespace, ListingPageSize by page, with
+// ListingIndex linking to them. It returns the number of articles listed,
+// and appends no page when there is none.
+func (idx *SwarmZimIndexer) MakeListingPages(tarFile string) (int, error) {
+	return idx.writeListingPages(AppendTo(tarFile), false)
+}
+
+// writeListingPages writes the listing pages, ListingIndex telling the
+// ZIM has no main page with noMainPage. The articles of the buckets are
+// collected listingPassSize at a time from a DiskStore, all at once from
+// memory, and the pages are the same whatever the store.
+func (idx *SwarmZimIndexer) writeListingPages(w FileWriter, noMainPage bool) (int, error) {
+	namespaces := idx.listing()
+	if err := idx.EntriesErr(); err != nil {
+		return 0, err
+	}
+	if len(namespaces) == 0 {
+		return 0, nil
+	}
+	t, err := idx.pageTheme()
+	if err != nil {
+		return 0, err
+	}
+	file := filepath.Base(idx.ZimPath)
+	writePage := func(name string, data map[string]interface{}) error {
+		var buf bytes.Buffer
+		data["File"] = file
+		data["Index"] = relativeLink(name, ListingIndex)
+		idx.pageData(name, data)
+		if err := t.execute(&buf, "listing.html", data); err != nil {
+			return err
+		}
+		return w.WriteFile(tarball.NewBufferFile(name, &buf))
+	}
+
+	passSize := listingPassSize
+	idx.mu.Lock()
+	if spoolDir(idx.entries) == "" {
+		// all the entries are in memory already
+		passSize = idx.entries.Len()
+	}
+	idx.mu.Unlock()
+
+	type nsBucket struct {
+		ns *listingNamespace
+		b  *listingBucket
+	}
+	var all []nsBucket
+	count := 0
+	for _, n := range namespaces {
+		count += n.Count
+		for _, b := range n.Buckets {
+			all = append(all, nsBucket{n, b})
+		}
+	}
+
+	idx.logger().Info("appending page", "page", ListingIndex, "tar", w.Name())
+	pageSize := idx.listingPageSize()
+	for start := 0; start < len(all); {
+		end, size := start, 0
+		pass := make(map[listingKey]*listingBucket)
+		for end < len(all) && (end == start || size+all[end].b.Count <= passSize) {
+			size += all[end].b.Count
+			pass[listingKey{all[end].ns.Name, all[end].b.Name}] = all[end].b
+			end++
+		}
+		idx.ForEachEntry(func(e IndexEntry) bool {
+			if ns, name, ok := listed(e); ok {
+				if b := pass[listingKey{ns, name}]; b != nil {
+					b.articles = append(b.articles, listingArticle{listingTitle(e), e.Path})
+				}
+			}
+			return true
+		})
+		if err := idx.EntriesErr(); err != nil {
+			return 0, err
+		}
+
+		for _, nb := range all[start:end] {
+			n, b := nb.ns, nb.b
+			slices.SortFunc(b.articles, func(x, y listingArticle) int {
+				if c := strings.Compare(strings.ToLower(x.title), strings.ToLower(y.title)); c != 0 {
+					return c
+				}
+				return strings.Compare(x.path, y.path)
+			})
+			pages := (len(b.articles) + pageSize - 1) / pageSize
+			for p := 1; p <= pages; p++ {
+				name := listingPagePath(n.Name, b.Name, p)
+				articles := b.articles[(p-1)*pageSize : min(p*pageSize, len(b.articles))]
+				links := make([]listingLink, len(articles))
+				for i, a := range articles {
+					links[i] = listingLink{Title: a.title, Link: relativeLink(name, a.path)}
+				}
+				bar := make([]listingLink, len(n.Buckets))
+				for i, other := range n.Buckets {
+					bar[i] = listingLink{
+						Title:   other.Name,
+						Link:    relativeLink(name, listingPagePath(n.Name, other.Name, 1)),
+						Current: other == b,
+					}
+				}
+				data := map[string]interface{}{
+					"Namespace": n.Name,
+					"Bucket":    b.Name,
+					"Buckets":   bar,
+					"Page":      p,
+					"Pages":     pages,
+					"Articles":  links,
+				}
+				if p > 1 {
+					data["Previous"] = relativeLink(name, listingPagePath(n.Name, b.Name, p-1))
+				}
+				if p < pages {
+					data["Next"] = relativeLink(name, listingPagePath(n.Name, b.Name, p+1))
+				}
+				if err := writePage(name, data); err != nil {
+					return 0, fmt.Errorf("listing page %s: %w", name, err)
+				}
+			}
+			b.articles = nil
+		}
+		start = end
+	}
+	data := map[string]interface{}{"Namespaces": namespaces, "Count": count, "NoMainPage": noMainPage}
+	if err := writePage(ListingIndex, data); err != nil {
+		return 0, fmt.Errorf("listing page %s: %w", ListingIndex, err)
+	}
+	return count, nil
+}
@@ -0,0 +1,102 @@
+package indexer
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// mainPageURLs and mainPageTitles are the names of the entries taken for
+// the main page of a ZIM without main page, best first, compared
+// regardless of case.
+var (
+	mainPageURLs   = []string{"main_page", "index.html", "index.htm", "index", "home.html", "home", "main.html", "main"}
+	mainPageTitles = []string{"main page", "home", "index"}
+)
+
+// SelectMainPage returns the path of the main page of the ZIM: MainPage
+// when set, the one the ZIM tells, or else the parsed HTML article whose
+// url, then title, comes first in a list of usual names of main pages,
+// those of the A and C namespaces first. It returns an empty path when
+// none matches, and logs which rule chose the page. It reads the entries
+// of the parse, so it is called after it.
+func (idx *SwarmZimIndexer) SelectMainPage() (string, error) {
+	file := filepath.Base(idx.ZimPath)
+	if idx.MainPage != "" {
+		p := strings.TrimPrefix(idx.MainPage, "/")
+		idx.mu.Lock()
+		_, ok, err := idx.entries.Get(p)
+		idx.mu.Unlock()
+		if err != nil {
+			return "", fmt.Errorf("%s: main page %s: %w", file, p, err)
+		}
+		if !ok {
+			return "", fmt.Errorf("%s: main page %s: %w", file, p, fs.ErrNotExist)
+		}
+		idx.logger().Info("main page selected", "file", file, "path", p, "heuristic", "override")
+		return p, nil
+	}
+
+	mainPage, err := idx.mainPage()
+	if err != nil {
+		return "", err
+	}
+	if mainPage != nil {
+		return mainPage.FullURL(), nil
+	}
+
+	// the best candidate of each name, of the first namespace and then
+	// path, the entries coming by path
+	byURL := make([]*IndexEntry, len(mainPageURLs))
+	byTitle := make([]*IndexEntry, len(mainPageTitles))
+	better := func(best *IndexEntry, e IndexEntry) bool {
+		return best == nil || mainPageNamespaceRank(e.Path) < mainPageNamespaceRank(best.Path)
+	}
+	idx.ForEachEntry(func(e IndexEntry) bool {
+		m := e.Metadata
+		if m.Skipped != "" || m.Exception != "" || !(m.Redirect || baseMime(m.MimeType) == "text/html") {
+			return true
+		}
+		_, url, _ := strings.Cut(e.Path, "/")
+		title := strings.TrimSpace(m.Title)
+		for i, name := range mainPageURLs {
+			if strings.EqualFold(url, name) && better(byURL[i], e) {
+				byURL[i] = &e
+			}
+		}
+		for i, t := range mainPageTitles {
+			if strings.EqualFold(title, t) && better(byTitle[i], e) {
+				byTitle[i] = &e
+			}
+		}
+		return true
+	})
+	if err := idx.EntriesErr(); err != nil {
+		return "", err
+	}
+
+	for i, e := range byURL {
+		if e != nil {
+			idx.logger().Info("main page selected", "file", file, "path", e.Path, "heuristic", "url "+mainPageURLs[i])
+			return e.Path, nil
+		}
+	}
+	for i, e := range byTitle {
+		if e != nil {
+			idx.logger().Info("main page selected", "file", file, "path", e.Path, "heuristic", "title "+mainPageTitles[i])
+			return e.Path, nil
+		}
+	}
+	return "", nil
+}
+
+// mainPageNamespaceRank ranks the namespace of the path for the main
+// page, the articles first.
+func mainPageNamespaceRank(p string) int {
+	switch ns, _, _ := strings.Cut(p, "/"); ns {
+	case "A", "C":
+		return 0
+	}
+	return 1
+}
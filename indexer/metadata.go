@@ -0,0 +1,98 @@
+package indexer
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/r0qs/beezim/internal/tarball"
+)
+
+// MetadataFile is the name in the tars of the metadata of the ZIM.
+const MetadataFile = "metadata.json"
+
+// metadataKeys are the well known entries of the M namespace, see
+// https://wiki.openzim.org/wiki/Metadata.
+var metadataKeys = []string{
+	"Name", "Title", "Description", "LongDescription", "Language",
+	"Creator", "Publisher", "Date", "Tags", "Source", "License", "Flavour",
+	"Scraper", "Counter",
+}
+
+// illustrationEntry is the favicon of the ZIM, whose url is recorded as
+// the Illustration metadata.
+const illustrationEntry = "M/Illustration_48x48@1"
+
+// ExtractMetadata returns the well known metadata of the ZIM, e.g. its
+// Title, Description and Language, read from the M namespace, and the url
+// of its illustration as Illustration. The metadata missing or that can
+// not be read are left out.
+func (idx *SwarmZimIndexer) ExtractMetadata() map[string]string {
+	zimMu.Lock()
+	defer zimMu.Unlock()
+	m := make(map[string]string)
+	for _, key := range metadataKeys {
+		entry, ok := idx.findEntry("M/" + key)
+		if !ok || entry.IsRedirect() || entry.IsDeleted() {
+			continue
+		}
+		data, err := entry.Data()
+		if err != nil {
+			idx.logger().Warn("error reading zim metadata", "file", filepath.Base(idx.ZimPath), "metadata", key, "err", err)
+			continue
+		}
+		if v := strings.TrimSpace(string(data)); v != "" {
+			m[key] = v
+		}
+	}
+	if _, ok := idx.findEntry(illustrationEntry); ok {
+		m["Illustration"] = illustrationEntry
+	}
+	return m
+}
+
+// findEntry returns the entry of the url, searched in the url index, which
+// the format sorts by url. It is called with zimMu held.
+func (idx *SwarmZimIndexer) findEntry(url string) (ZimEntry, bool) {
+	lo, hi := uint32(0), idx.Z.ArticleCount()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		entry, err := idx.Z.EntryAt(mid)
+		if err != nil {
+			return nil, false
+		}
+		switch c := strings.Compare(entry.FullURL(), url); {
+		case c == 0:
+			return entry, true
+		case c < 0:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return nil, false
+}
+
+// metadataLanguage returns the first language of the Language metadata,
+// e.g. eng of "eng,fra", for the lang attribute of the pages.
+func metadataLanguage(m map[string]string) string {
+	lang, _, _ := strings.Cut(m["Language"], ",")
+	return lang
+}
+
+// MakeMetadataFile appends the metadata of the ZIM, see ExtractMetadata,
+// to the tar as MetadataFile, with the FaviconFile of the tar as Favicon.
+func (idx *SwarmZimIndexer) MakeMetadataFile(tarFile string) error {
+	return idx.writeMetadataFile(AppendTo(tarFile))
+}
+
+func (idx *SwarmZimIndexer) writeMetadataFile(w FileWriter) error {
+	m := idx.ExtractMetadata()
+	m["Favicon"] = FaviconFile
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	idx.logger().Info("appending file", "file", MetadataFile, "tar", w.Name())
+	return w.WriteFile(tarball.NewBytesFile(MetadataFile, append(data, '\n')))
+}
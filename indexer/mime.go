@@ -0,0 +1,146 @@
+package indexer
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// MimeFilter restricts the MIME types of the articles parsed, with glob
+// patterns such as "image/*" matched against the type without its
+// parameters.
+type MimeFilter struct {
+	// Allowed, when not empty, are the only types parsed.
+	Allowed []string
+	// Blocked are the types never parsed, even when allowed.
+	Blocked []string
+}
+
+// IsZero reports whether the filter keeps every type.
+func (f MimeFilter) IsZero() bool {
+	return len(f.Allowed) == 0 && len(f.Blocked) == 0
+}
+
+// String is the canonical form of the filter, recorded in the
+// fingerprints of the tars, empty for the zero filter.
+func (f MimeFilter) String() string {
+	var opts []string
+	if len(f.Allowed) > 0 {
+		opts = append(opts, "allow-mime="+strings.Join(sortedPatterns(f.Allowed), ","))
+	}
+	if len(f.Blocked) > 0 {
+		opts = append(opts, "block-mime="+strings.Join(sortedPatterns(f.Blocked), ","))
+	}
+	return strings.Join(opts, " ")
+}
+
+// sortedPatterns returns a sorted copy of the patterns, without
+// duplicates.
+func sortedPatterns(patterns []string) []string {
+	patterns = slices.Clone(patterns)
+	slices.Sort(patterns)
+	return slices.Compact(patterns)
+}
+
+// Allows reports whether the articles of the MIME type are parsed.
+func (f MimeFilter) Allows(mimeType string) bool {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	mimeType = strings.TrimSpace(mimeType)
+	if matchesAny(f.Blocked, mimeType) {
+		return false
+	}
+	return len(f.Allowed) == 0 || matchesAny(f.Allowed, mimeType)
+}
+
+func matchesAny(patterns []string, mimeType string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, mimeType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// MimeStats are the articles of a MIME type left out by the filter.
+type MimeStats struct {
+	Articles int   `json:"articles"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// SetMimeFilter restricts the articles parsed to those whose MIME type
+// matches a pattern of allowed, all of them when it is empty, and no
+// pattern of blocked, which wins over allowed. The articles left out are
+// counted in MimeFiltered, and replaced by a placeholder with
+// MimePlaceholders. Redirects are always parsed.
+func (idx *SwarmZimIndexer) SetMimeFilter(allowed, blocked []string) error {
+	for _, p := range append(slices.Clone(allowed), blocked...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("mime pattern %q: %w", p, err)
+		}
+	}
+	idx.mimes = MimeFilter{Allowed: slices.Clone(allowed), Blocked: slices.Clone(blocked)}
+	return nil
+}
+
+// MimeFilter returns the filter set with SetMimeFilter.
+func (idx *SwarmZimIndexer) MimeFilter() MimeFilter {
+	return idx.mimes
+}
+
+// MimeFiltered returns the articles left out by the MIME filter in the
+// parses of the indexer, by type.
+func (idx *SwarmZimIndexer) MimeFiltered() map[string]MimeStats {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	stats := make(map[string]MimeStats, len(idx.mimeFiltered))
+	for t, s := range idx.mimeFiltered {
+		stats[t] = s
+	}
+	return stats
+}
+
+// filterArticle counts the article left out by the MIME filter, and
+// returns its placeholder with MimePlaceholders.
+func (idx *SwarmZimIndexer) filterArticle(a Article) (Article, bool) {
+	idx.mu.Lock()
+	if idx.mimeFiltered == nil {
+		idx.mimeFiltered = make(map[string]MimeStats)
+	}
+	s := idx.mimeFiltered[a.mime]
+	s.Articles++
+	s.Bytes += a.Size()
+	idx.mimeFiltered[a.mime] = s
+	idx.mu.Unlock()
+
+	a.Release()
+	if !idx.MimePlaceholders {
+		return Article{}, false
+	}
+	a.data = placeholder(a.mime)
+	return a, true
+}
+
+// The placeholders of the articles left out, so that the pages linking
+// to them do not get errors: an empty page, a transparent image, or no
+// content for the other types.
+var (
+	placeholderHTML = []byte(`<!DOCTYPE html><html><head><meta charset="utf-8"><title>Not mirrored</title></head><body><p>This content was left out of the mirror.</p></body></html>`)
+	placeholderSVG  = []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="1" height="1"/>`)
+	// a 1x1 transparent GIF, which browsers display whatever the type of
+	// the image replaced
+	placeholderImage = []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00\x00\x00\x00\x00\x00\x00!\xf9\x04\x01\x00\x00\x00\x00,\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02D\x01\x00;")
+)
+
+func placeholder(mimeType string) []byte {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	switch {
+	case mimeType == "text/html":
+		return placeholderHTML
+	case mimeType == "image/svg+xml":
+		return placeholderSVG
+	case strings.HasPrefix(mimeType, "image/"):
+		return placeholderImage
+	}
+	return nil
+}
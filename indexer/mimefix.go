@@ -0,0 +1,155 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+// MimeOverrides are the MIME types given to the entries of the ZIM
+// instead of those it tells, by pattern: an extension such as ".svg",
+// matched against the extension of the path of the entry whatever its
+// case, or a glob pattern such as "I/*.svg" matched against the whole
+// path. The patterns of paths win over the extensions, and the first in
+// lexical order over the others.
+type MimeOverrides map[string]string
+
+// String is the canonical form of the overrides, recorded in the
+// fingerprints of the tars.
+func (o MimeOverrides) String() string {
+	var opts []string
+	for _, p := range slices.Sorted(maps.Keys(o)) {
+		opts = append(opts, "mime-override="+p+"="+o[p])
+	}
+	return strings.Join(opts, " ")
+}
+
+// lookup returns the type the overrides give to the entry at p.
+func (o MimeOverrides) lookup(p string) (string, bool) {
+	if len(o) == 0 {
+		return "", false
+	}
+	patterns := slices.Sorted(maps.Keys(o))
+	for _, pattern := range patterns {
+		if isExtension(pattern) {
+			continue
+		}
+		if ok, _ := path.Match(pattern, p); ok {
+			return o[pattern], true
+		}
+	}
+	ext := strings.ToLower(path.Ext(p))
+	for _, pattern := range patterns {
+		if isExtension(pattern) && strings.ToLower(pattern) == ext {
+			return o[pattern], true
+		}
+	}
+	return "", false
+}
+
+// isExtension reports whether the pattern of an override is an
+// extension.
+func isExtension(pattern string) bool {
+	return strings.HasPrefix(pattern, ".") && !strings.ContainsAny(pattern, "/*?[\\")
+}
+
+// MimeFixStats are the entries whose MIME type the last parse corrected.
+type MimeFixStats struct {
+	// Overridden is the number of entries given another type by the
+	// overrides, and Sniffed that of those whose type was guessed with
+	// SniffMime.
+	Overridden int
+	Sniffed    int
+}
+
+// SetMimeOverrides sets the types given to the entries instead of those
+// of the ZIM, before the MIME filter and the rewrites see them. The
+// entries corrected are counted in MimeFixes.
+func (idx *SwarmZimIndexer) SetMimeOverrides(overrides map[string]string) error {
+	for p, t := range overrides {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("mime override %q: %w", p, err)
+		}
+		mt, _, err := mime.ParseMediaType(t)
+		if err == nil && !strings.Contains(mt, "/") {
+			err = errors.New("no subtype")
+		}
+		if err != nil {
+			return fmt.Errorf("mime override %q: type %q: %w", p, t, err)
+		}
+	}
+	idx.mimeOverrides = maps.Clone(overrides)
+	return nil
+}
+
+// MimeOverrides returns the overrides set with SetMimeOverrides.
+func (idx *SwarmZimIndexer) MimeOverrides() MimeOverrides {
+	return idx.mimeOverrides
+}
+
+// MimeFixes returns the entries whose type the last parse corrected.
+func (idx *SwarmZimIndexer) MimeFixes() MimeFixStats {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.mimeFixes
+}
+
+// resetMimeFixes forgets the entries of the previous parse.
+func (idx *SwarmZimIndexer) resetMimeFixes() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.mimeFixes = MimeFixStats{}
+}
+
+// overrideMime returns the type of the entry given by the overrides, and
+// whether one matched, that of the ZIM without one.
+func (idx *SwarmZimIndexer) overrideMime(entry ZimEntry) (string, bool) {
+	t := entry.MimeType()
+	o, ok := idx.mimeOverrides.lookup(entry.FullURL())
+	if !ok {
+		return t, false
+	}
+	if o != t {
+		idx.mu.Lock()
+		idx.mimeFixes.Overridden++
+		idx.mu.Unlock()
+	}
+	return o, true
+}
+
+// unknownMime reports whether the type tells nothing of the content, and
+// is guessed with SniffMime.
+func unknownMime(mimeType string) bool {
+	t := baseMime(mimeType)
+	return t == "" || t == "application/octet-stream"
+}
+
+// sniffMime sets the type of the article whose ZIM type is unknown from
+// the extension of its path, or from the start of its payload without
+// one known.
+func (idx *SwarmZimIndexer) sniffMime(a *Article) {
+	t := mime.TypeByExtension(path.Ext(a.path))
+	if t == "" {
+		head := a.data
+		if a.src != nil {
+			head = make([]byte, min(a.srcSize, 512))
+			if _, err := a.src.ReadAt(head, 0); err != nil && err != io.EOF {
+				return
+			}
+		}
+		t = http.DetectContentType(head)
+	}
+	if t == a.mime {
+		return
+	}
+	a.mime = t
+	idx.mu.Lock()
+	idx.mimeFixes.Sniffed++
+	idx.mu.Unlock()
+}
@@ -0,0 +1,51 @@
+package indexer
+
+import "github.com/r0qs/beezim/internal/minify"
+
+// MinifyStats are the articles of the last parse minified, see Minify.
+type MinifyStats struct {
+	// Minified is the number of HTML, CSS and JavaScript articles
+	// minified, and Saved the bytes it saved.
+	Minified int
+	Saved    int64
+	// Invalid is the number of those sent as they are, as they could not
+	// be minified, e.g. with an unterminated comment or tag.
+	Invalid int
+}
+
+// MinifyStats returns the articles minified by the last parse, none
+// without Minify.
+func (idx *SwarmZimIndexer) MinifyStats() MinifyStats {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.minifyStats
+}
+
+// resetMinify forgets the articles of the previous parse.
+func (idx *SwarmZimIndexer) resetMinify() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.minifyStats = MinifyStats{}
+}
+
+// minifyArticle minifies the payload of the article of a type Minify
+// covers, keeping it as it is when it can not be minified.
+func (idx *SwarmZimIndexer) minifyArticle(a *Article) {
+	if !minify.Minifies(a.mime) {
+		return
+	}
+	data, err := minify.Minify(a.mime, a.data)
+	if err != nil {
+		idx.logger().Debug("article not minified", "article", a.path, "err", err)
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if err != nil {
+		idx.minifyStats.Invalid++
+		return
+	}
+	idx.minifyStats.Minified++
+	idx.minifyStats.Saved += int64(len(a.data) - len(data))
+	a.Release()
+	a.data = data
+}
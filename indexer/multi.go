@@ -0,0 +1,194 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/r0qs/beezim/internal/tarball"
+)
+
+// MultiZim is a ZIM of a MultiIndexer.
+type MultiZim struct {
+	Indexer *SwarmZimIndexer
+	// Dir is the directory of the tar holding its articles and pages, the
+	// name of its file without extension when empty.
+	Dir string
+}
+
+// MultiIndexer writes the articles and pages of several ZIMs, e.g. the
+// Wikipedia, Wiktionary and Wikivoyage of a language, to a single tar,
+// each under its own directory so that their paths never collide. The
+// root of the tar gets an index.html linking to the index page of each
+// ZIM, and an EntriesFile listing the entries of them all with their ZIM.
+// The pages of each ZIM, its search included, link to each other relative
+// to its directory, so they keep working there.
+type MultiIndexer struct {
+	Zims []MultiZim
+	// Title is that of the index page, the titles of the ZIMs when empty.
+	Title string
+}
+
+// NewMultiIndexer returns the MultiIndexer of the ZIMs of the indexers,
+// each in the directory named after its file.
+func NewMultiIndexer(indexers ...*SwarmZimIndexer) *MultiIndexer {
+	m := &MultiIndexer{}
+	for _, idx := range indexers {
+		m.Zims = append(m.Zims, MultiZim{Indexer: idx})
+	}
+	return m
+}
+
+// dirs returns the directories of the ZIMs, checked.
+func (m *MultiIndexer) dirs() ([]string, error) {
+	if len(m.Zims) == 0 {
+		return nil, errors.New("no zim to merge")
+	}
+	reserved := map[string]bool{"index.html": true, path.Dir(EntriesFile): true}
+	dirs := make([]string, len(m.Zims))
+	seen := make(map[string]bool)
+	for i, z := range m.Zims {
+		dir := z.Dir
+		if dir == "" {
+			dir = strings.TrimSuffix(filepath.Base(z.Indexer.ZimPath), filepath.Ext(z.Indexer.ZimPath))
+		}
+		if dir == "" || dir == "." || dir == ".." || strings.ContainsAny(dir, `/\`) || reserved[dir] {
+			return nil, fmt.Errorf("invalid directory %q for %s", dir, filepath.Base(z.Indexer.ZimPath))
+		}
+		if seen[dir] {
+			return nil, fmt.Errorf("directory %q of several zims", dir)
+		}
+		seen[dir] = true
+		dirs[i] = dir
+	}
+	return dirs, nil
+}
+
+// TarZims parses the ZIMs one after the other and writes their articles,
+// followed by the pages of WritePages, to tarFile under their directory,
+// then the index page and EntriesFile of the tar. The tar is written with
+// the options of the first indexer, e.g. its GzipLevel and SpaceCheck.
+// The search index can not be written to its own tar, nor the tar get a
+// Fingerprint, which covers a single ZIM.
+func (m *MultiIndexer) TarZims(ctx context.Context, tarFile string) error {
+	dirs, err := m.dirs()
+	if err != nil {
+		return err
+	}
+	for _, z := range m.Zims {
+		if z.Indexer.SearchTarFile != "" {
+			return errors.New("the search index can not be split apart from merged zims")
+		}
+		if z.Indexer.Fingerprint != nil {
+			return errors.New("merged zims can not be fingerprinted")
+		}
+		if z.Indexer.CheckpointEvery > 0 {
+			return errCheckpointSink
+		}
+	}
+	first := m.Zims[0].Indexer
+	s, err := first.newTarSink(ctx, tarFile)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	for i, z := range m.Zims {
+		idx := z.Indexer
+		idx.logger().Info("merging zim", "file", filepath.Base(idx.ZimPath), "dir", dirs[i], "tar", s.Name())
+		s.idx, s.prefix = idx, dirs[i]+"/"
+		s.manifestRedirects, s.redirects = idx.ManifestRedirects, nil
+		batches := idx.ParseZIMBatches(ctx)
+		if err := idx.writeBatches(s, batches, true); err != nil {
+			idx.abortParse(batches)
+			return fmt.Errorf("%s: %w", filepath.Base(idx.ZimPath), err)
+		}
+	}
+	s.idx, s.prefix = first, ""
+
+	if err := m.writeIndexPage(s, dirs); err != nil {
+		return fmt.Errorf("Failed to copy index.html page to tar file: %w", err)
+	}
+	if err := m.writeEntriesManifest(s, dirs); err != nil {
+		return fmt.Errorf("Failed to copy %s to tar file: %w", EntriesFile, err)
+	}
+	if err := s.end(); err != nil {
+		return err
+	}
+	s.done()
+	return nil
+}
+
+// writeIndexPage writes the index page linking to those of the ZIMs.
+func (m *MultiIndexer) writeIndexPage(w FileWriter, dirs []string) error {
+	first := m.Zims[0].Indexer
+	t, err := first.pageTheme()
+	if err != nil {
+		return err
+	}
+	type zimLink struct {
+		Title, URL, File, Favicon string
+	}
+	links := make([]zimLink, len(m.Zims))
+	titles := make([]string, len(m.Zims))
+	for i, z := range m.Zims {
+		titles[i] = z.Indexer.zimTitle()
+		links[i] = zimLink{
+			Title:   titles[i],
+			URL:     relativeLink("index.html", dirs[i]+"/index.html"),
+			File:    filepath.Base(z.Indexer.ZimPath),
+			Favicon: relativeLink("index.html", dirs[i]+"/"+FaviconFile),
+		}
+	}
+	title := m.Title
+	if title == "" {
+		title = strings.Join(titles, " · ")
+	}
+	data := first.pageLocale().data(map[string]interface{}{
+		"Title":   title,
+		"Zims":    links,
+		"Favicon": links[0].Favicon,
+	})
+
+	var buf bytes.Buffer
+	if err := t.execute(&buf, "multi.html", data); err != nil {
+		return err
+	}
+	first.logger().Info("appending page", "page", "index.html", "tar", w.Name())
+	return w.WriteFile(tarball.NewBufferFile("index.html", &buf))
+}
+
+// writeEntriesManifest writes the EntriesFile of the entries of all the
+// ZIMs, sorted by path, their paths in the tar and with their ZIM.
+func (m *MultiIndexer) writeEntriesManifest(w FileWriter, dirs []string) error {
+	// the entries of each ZIM are sorted, and so are the ZIMs by the
+	// prefix of their paths
+	order := make([]int, len(m.Zims))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		return strings.Compare(dirs[a]+"/", dirs[b]+"/")
+	})
+	return m.Zims[0].Indexer.writeEntriesPage(EntriesFile, w, func(out io.Writer) error {
+		mw := &manifestWriter{w: out}
+		for _, i := range order {
+			idx := m.Zims[i].Indexer
+			zim := filepath.Base(idx.ZimPath)
+			if err := idx.manifestEntries(func(e ManifestEntry) error {
+				e.Path = dirs[i] + "/" + e.Path
+				e.Zim = zim
+				return mw.write(e)
+			}); err != nil {
+				return err
+			}
+		}
+		return mw.close()
+	})
+}
@@ -0,0 +1,159 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// FirstZimPart is the extension of the first part of a ZIM split by
+// Kiwix, e.g. wikipedia_en_all.zimaa, followed by .zimab and so on up to
+// .zimzz.
+const FirstZimPart = ".zimaa"
+
+// ZimParts returns the parts of a split ZIM, in order: those of the first
+// part at zimPath, or of the ZIM at zimPath when it does not exist but its
+// first part does, wikipedia_en_all.zim naming wikipedia_en_all.zimaa and
+// the parts after it. It returns nil for a ZIM in one file.
+func ZimParts(zimPath string) ([]string, error) {
+	base, ok := strings.CutSuffix(zimPath, FirstZimPart)
+	if !ok {
+		if !strings.HasSuffix(zimPath, ".zim") {
+			return nil, nil
+		}
+		if _, err := os.Stat(zimPath); !errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		if _, err := os.Stat(zimPath + "aa"); err != nil {
+			return nil, nil
+		}
+		base = strings.TrimSuffix(zimPath, ".zim")
+	}
+	var parts []string
+	for i := 0; i < maxZimParts; i++ {
+		part := zimPartName(base, i)
+		if _, err := os.Stat(part); err != nil {
+			if len(parts) == 0 || !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			// the parts end at the first missing, unless it is a gap
+			if i+1 < maxZimParts {
+				if _, err := os.Stat(zimPartName(base, i+1)); err == nil {
+					return nil, fmt.Errorf("%s: missing part of the split zim", part)
+				}
+			}
+			return parts, nil
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// maxZimParts is the number of parts of a split ZIM, .zimaa to .zimzz.
+const maxZimParts = 26 * 26
+
+// zimPartName returns the name of the part i of the split ZIM base, from
+// base.zimaa for the first.
+func zimPartName(base string, i int) string {
+	return fmt.Sprintf("%s.zim%c%c", base, 'a'+i/26, 'a'+i%26)
+}
+
+// ZimFile is the file of a ZIM, or its parts read as one file when it is
+// split, see ZimParts.
+type ZimFile struct {
+	parts []*os.File
+	// ends are the offsets in the ZIM of the end of each part.
+	ends []int64
+}
+
+// OpenZimFile opens the ZIM at zimPath, or its parts when it is split.
+// The parts are not expected to change while they are read.
+func OpenZimFile(zimPath string) (*ZimFile, error) {
+	names, err := ZimParts(zimPath)
+	if err != nil {
+		return nil, err
+	}
+	if names == nil {
+		names = []string{zimPath}
+	}
+	z := &ZimFile{}
+	var end int64
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			z.Close()
+			return nil, err
+		}
+		z.parts = append(z.parts, f)
+		info, err := f.Stat()
+		if err != nil {
+			z.Close()
+			return nil, err
+		}
+		end += info.Size()
+		z.ends = append(z.ends, end)
+	}
+	return z, nil
+}
+
+// ZimSize returns the size of the ZIM at zimPath, the sum of its parts
+// when it is split.
+func ZimSize(zimPath string) (int64, error) {
+	z, err := OpenZimFile(zimPath)
+	if err != nil {
+		return 0, err
+	}
+	defer z.Close()
+	return z.Size(), nil
+}
+
+// Size returns the size of the ZIM.
+func (z *ZimFile) Size() int64 {
+	if len(z.ends) == 0 {
+		return 0
+	}
+	return z.ends[len(z.ends)-1]
+}
+
+// ReadAt reads the ZIM at off, across the parts it spans.
+func (z *ZimFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("read at negative offset %d", off)
+	}
+	n := 0
+	for n < len(p) {
+		// the part holding off, the first ending after it
+		i := sort.Search(len(z.ends), func(i int) bool { return z.ends[i] > off })
+		if i == len(z.parts) {
+			return n, io.EOF
+		}
+		var start int64
+		if i > 0 {
+			start = z.ends[i-1]
+		}
+		want := min(int64(len(p)-n), z.ends[i]-off)
+		m, err := z.parts[i].ReadAt(p[n:n+int(want)], off-start)
+		n += m
+		off += int64(m)
+		if int64(m) < want {
+			if err == nil || err == io.EOF {
+				// the part is shorter than when it was opened
+				err = io.ErrUnexpectedEOF
+			}
+			return n, fmt.Errorf("%s: %w", z.parts[i].Name(), err)
+		}
+	}
+	return n, nil
+}
+
+// Close closes the parts.
+func (z *ZimFile) Close() error {
+	var errs []error
+	for _, f := range z.parts {
+		errs = append(errs, f.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,168 @@
+package indexer_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/indexer/zimtest"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// splitZim writes a small ZIM and its three parts, cut at the offsets,
+// and returns the path of the whole ZIM, its content and the base path of
+// the parts, e.g. dir/split for dir/split.zimaa.
+func splitZim(t *testing.T, cuts func(size int) [2]int) (string, []byte, string) {
+	t.Helper()
+	dir := t.TempDir()
+	r := zimtest.New(
+		zimtest.Entry{Namespace: 'A', URL: "Main", Title: "Main", Mime: "text/html", Content: []byte("<p>main</p>")},
+		zimtest.Entry{Namespace: 'A', URL: "Page", Title: "Page", Mime: "text/html", Content: bytes.Repeat([]byte("<p>page</p>"), 200)},
+		zimtest.Redirect('A', "Home", 0),
+		zimtest.Entry{Namespace: 'I', URL: "logo.png", Mime: "image/png", Content: bytes.Repeat([]byte("png"), 300)},
+		zimtest.Entry{Namespace: 'M', URL: "Title", Mime: "text/plain", Content: []byte("Split wiki")},
+	)
+	r.Main = 0
+	zimPath := filepath.Join(dir, "whole.zim")
+	if err := r.WriteFile(zimPath); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(zimPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cuts(len(data))
+	base := filepath.Join(dir, "split")
+	for i, part := range [][]byte{data[:c[0]], data[c[0]:c[1]], data[c[1]:]} {
+		name := base + ".zima" + string(rune('a'+i))
+		if err := os.WriteFile(name, part, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return zimPath, data, base
+}
+
+// thirds cuts the ZIM in three parts of about the same size, at offsets
+// falling in the middle of its structures.
+func thirds(size int) [2]int {
+	return [2]int{size/3 + 1, 2*size/3 - 1}
+}
+
+func TestZimParts(t *testing.T) {
+	_, _, base := splitZim(t, thirds)
+	want := []string{base + ".zimaa", base + ".zimab", base + ".zimac"}
+	for _, p := range []string{base + ".zimaa", base + ".zim"} {
+		parts, err := indexer.ZimParts(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(parts, want) {
+			t.Errorf("%s: got parts %v, want %v", filepath.Base(p), parts, want)
+		}
+	}
+
+	if err := os.Remove(base + ".zimab"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := indexer.ZimParts(base + ".zimaa"); err == nil || !strings.Contains(err.Error(), "missing part") {
+		t.Errorf("got error %v with the middle part missing, want a missing part", err)
+	}
+}
+
+func TestZimFileReadAt(t *testing.T) {
+	for name, cuts := range map[string]func(int) [2]int{
+		"thirds": thirds,
+		// a part of a single byte
+		"one byte": func(size int) [2]int { return [2]int{size / 2, size/2 + 1} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, data, base := splitZim(t, cuts)
+			z, err := indexer.OpenZimFile(base + ".zimaa")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer z.Close()
+			if z.Size() != int64(len(data)) {
+				t.Fatalf("size %d, want %d", z.Size(), len(data))
+			}
+
+			// reads starting and ending on both sides of the cuts
+			c := cuts(len(data))
+			for _, off := range []int{0, c[0] - 3, c[0] - 1, c[0], c[1] - 1, c[1], len(data) - 5} {
+				for _, n := range []int{1, 2, 7, c[1] - c[0] + 2} {
+					end := min(off+n, len(data))
+					buf := make([]byte, end-off)
+					if _, err := z.ReadAt(buf, int64(off)); err != nil {
+						t.Fatalf("read of %d bytes at %d: %v", len(buf), off, err)
+					}
+					if !bytes.Equal(buf, data[off:end]) {
+						t.Fatalf("read of %d bytes at %d: got other bytes", len(buf), off)
+					}
+				}
+			}
+			all, err := io.ReadAll(io.NewSectionReader(z, 0, z.Size()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(all, data) {
+				t.Error("the parts read as one file differ from the zim")
+			}
+
+			buf := make([]byte, 10)
+			if n, err := z.ReadAt(buf, int64(len(data)-4)); n != 4 || !errors.Is(err, io.EOF) {
+				t.Errorf("read past the end: got %d bytes, %v, want 4 bytes, EOF", n, err)
+			}
+		})
+	}
+}
+
+func TestParseSplitZim(t *testing.T) {
+	zimPath, _, base := splitZim(t, thirds)
+	ctx := context.Background()
+	if err := indexer.VerifyChecksum(ctx, base+".zimaa"); err != nil {
+		t.Fatal(err)
+	}
+
+	tars := make(map[string]map[string][]byte)
+	for _, p := range []string{zimPath, base + ".zimaa"} {
+		idx, err := indexer.New(p, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer idx.Close()
+		idx.Logger = logging.Discard()
+		tars[p] = readTar(t, tarZim(t, idx))
+	}
+	whole, split := tars[zimPath], tars[base+".zimaa"]
+	for _, name := range []string{"A/Main", "A/Page", "A/Home", "I/logo.png"} {
+		if _, ok := whole[name]; !ok {
+			t.Fatalf("%s: not in the tar of the whole zim", name)
+		}
+		if !bytes.Equal(split[name], whole[name]) {
+			t.Errorf("%s: the split zim gives other bytes", name)
+		}
+	}
+}
+
+func TestVerifySplitZim(t *testing.T) {
+	_, _, base := splitZim(t, thirds)
+	part := base + ".zimab"
+	data, err := os.ReadFile(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(part, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := indexer.VerifyChecksum(context.Background(), base+".zimaa"); err == nil {
+		t.Error("a corrupt middle part passed the checksum")
+	}
+}
@@ -0,0 +1,70 @@
+package indexer
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// errUnsafeName is returned for the entries whose url can not be used as
+// the name of a file of the tar.
+var errUnsafeName = errors.New("url can not be used as a file name")
+
+// checkEntryName checks that the url of an entry is a name that stays
+// under the root of the tar and of the extracted directory, and is served
+// by bee at the same path. Names are not rewritten in the tars: two
+// entries can not end up with the same name, and the links of the pages
+// keep working. UnZim writes them under names of its own, see fsNames.
+func checkEntryName(name string) error {
+	switch {
+	case name == "" || !utf8.ValidString(name) || strings.ContainsRune(name, 0):
+		return errUnsafeName
+	case strings.HasPrefix(name, "/"):
+		return errUnsafeName
+	case path.Clean(name) != name:
+		// empty, "." and ".." elements, or a trailing slash
+		return errUnsafeName
+	case name == "." || name == ".." || strings.HasPrefix(name, "../"):
+		return errUnsafeName
+	case strings.HasPrefix(name, `\`) || slices.Contains(strings.FieldsFunc(name, isSeparator), ".."):
+		// a parent with windows separators, e.g. ..\
+		return errUnsafeName
+	}
+	return nil
+}
+
+func isSeparator(r rune) bool {
+	return r == '/' || r == '\\'
+}
+
+// relativeLink returns the link from the page at from to the entry at
+// to, both names of the tar, whatever their directories and namespaces.
+// Its elements are escaped, so that a name with a "?" or a "#" is not
+// read as a query or a fragment.
+func relativeLink(from, to string) string {
+	dir := strings.Split(path.Dir(from), "/")
+	if dir[0] == "." {
+		dir = nil
+	}
+	target := strings.Split(to, "/")
+	n := 0
+	for n < len(dir) && n < len(target)-1 && dir[n] == target[n] {
+		n++
+	}
+	elems := make([]string, 0, len(dir)-n+len(target)-n)
+	for range dir[n:] {
+		elems = append(elems, "..")
+	}
+	for _, e := range target[n:] {
+		elems = append(elems, url.PathEscape(e))
+	}
+	link := strings.Join(elems, "/")
+	if strings.Contains(elems[0], ":") {
+		// so that the name is not read as a scheme
+		link = "./" + link
+	}
+	return link
+}
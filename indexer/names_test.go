@@ -0,0 +1,189 @@
+package indexer
+
+import (
+	"archive/tar"
+	"bytes"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/r0qs/beezim/internal/tarball"
+)
+
+// The seeds of the fuzz targets are in testdata/fuzz, urls of Kiwix ZIMs
+// that broke the names of the tars or of the extracted files.
+
+// FuzzEntryName checks that the names checkEntryName accepts stay under
+// the root, are written as is in the tars and are reached by the links
+// of the pages.
+func FuzzEntryName(f *testing.F) {
+	root := f.TempDir()
+	f.Fuzz(func(t *testing.T, from, to string) {
+		if checkEntryName(to) != nil {
+			return
+		}
+
+		for _, elem := range strings.FieldsFunc(to, isSeparator) {
+			if elem == ".." {
+				t.Fatalf("%q: accepted with a parent element", to)
+			}
+		}
+		p, err := tarball.LocalPath(root, to)
+		if err != nil {
+			t.Fatalf("%q: %v", to, err)
+		}
+		if rel, err := filepath.Rel(root, p); err != nil || !filepath.IsLocal(rel) {
+			t.Fatalf("%q: extracted to %s, out of %s", to, p, root)
+		}
+
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		if err := tw.WriteHeader(tarball.Header(to, 0)); err != nil {
+			t.Fatalf("%q: writing the tar header: %v", to, err)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		hdr, err := tar.NewReader(&buf).Next()
+		if err != nil {
+			t.Fatalf("%q: reading the tar header: %v", to, err)
+		}
+		if hdr.Name != to {
+			t.Fatalf("%q: read back from the tar as %q", to, hdr.Name)
+		}
+		if hdr.Format&(tar.FormatUSTAR|tar.FormatPAX) == 0 {
+			t.Fatalf("%q: written as a %v header", to, hdr.Format)
+		}
+
+		if checkEntryName(from) != nil {
+			return
+		}
+		link := relativeLink(from, to)
+		ref, err := url.Parse(link)
+		if err != nil {
+			t.Fatalf("link %q from %q to %q: %v", link, from, to, err)
+		}
+		base := &url.URL{Scheme: "http", Host: "localhost", Path: "/" + from}
+		got := base.ResolveReference(ref)
+		if got.Host != base.Host || got.Path != "/"+to || got.RawQuery != "" || got.Fragment != "" {
+			t.Fatalf("link %q from %q goes to %q, not %q", link, from, got, to)
+		}
+	})
+}
+
+func TestRelativeLink(t *testing.T) {
+	for _, tt := range []struct {
+		from, to, want string
+	}{
+		{"A/Foo", "A/Bar", "Bar"},
+		{"A/Foo", "A/Foo", "Foo"},
+		{"A/Dir/Foo", "A/Bar", "../Bar"},
+		{"A/Foo", "A/Dir/Sub/Bar", "Dir/Sub/Bar"},
+		{"A/Dir/Foo", "A/Dir/Sub/Bar", "Sub/Bar"},
+		{"A/Foo", "I/logo.png", "../I/logo.png"},
+		{"A/Dir/Foo", "-/s/style.css", "../../-/s/style.css"},
+		{"index.html", "A/Foo", "A/Foo"},
+		{"A/Dir/Foo", "index.html", "../../index.html"},
+		{"A/Foo", "A/What?", "What%3F"},
+		{"A/Foo", "A/C#", "C%23"},
+		{"A/Foo", "A/Q&A?#top", "Q&A%3F%23top"},
+		{"A/Foo", "A/S\u00e3o Paulo", "S%C3%A3o%20Paulo"},
+		// a first element with a colon is not a scheme
+		{"A/Foo", "A/Talk:Bar", "./Talk:Bar"},
+		{"A/Foo", "A/Dir:1/Bar", "./Dir:1/Bar"},
+		{"A/Foo", "A/Bar/Talk:Baz", "Bar/Talk:Baz"},
+	} {
+		link := relativeLink(tt.from, tt.to)
+		if link != tt.want {
+			t.Errorf("link from %q to %q: got %q, want %q", tt.from, tt.to, link, tt.want)
+		}
+		ref, err := url.Parse(link)
+		if err != nil {
+			t.Fatalf("link %q from %q to %q: %v", link, tt.from, tt.to, err)
+		}
+		base := &url.URL{Scheme: "http", Host: "localhost", Path: "/" + tt.from}
+		if got := base.ResolveReference(ref); got.Path != "/"+tt.to || got.RawQuery != "" || got.Fragment != "" {
+			t.Errorf("link %q from %q goes to %q, not %q", link, tt.from, got, tt.to)
+		}
+	}
+}
+
+// FuzzFSNames checks that the names UnZim gives the files are written on
+// every filesystem, under the root, that two paths never get the same
+// file or a file and a directory, and that the paths file tells their
+// paths back.
+func FuzzFSNames(f *testing.F) {
+	f.Fuzz(func(t *testing.T, a, b string) {
+		if checkEntryName(a) != nil || checkEntryName(b) != nil {
+			return
+		}
+		n := newFSNames()
+		names := map[string]string{a: n.name(a)}
+		names[b] = n.name(b)
+
+		for p, name := range names {
+			for _, elem := range strings.Split(name, "/") {
+				if !portableName(elem) {
+					t.Fatalf("%q: named %q, with %q", p, name, elem)
+				}
+			}
+		}
+		if a != b {
+			na, nb := fold(names[a]), fold(names[b])
+			if na == nb || strings.HasPrefix(na, nb+"/") || strings.HasPrefix(nb, na+"/") {
+				t.Fatalf("%q and %q: named %q and %q", a, b, names[a], names[b])
+			}
+			if fold(a) == fold(b) && names[b] == b {
+				t.Fatalf("%q: the same file as %q, not renamed", b, a)
+			}
+		}
+
+		dir := t.TempDir()
+		if err := n.writePaths(dir); err != nil {
+			t.Fatal(err)
+		}
+		paths, err := ReadPaths(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls := make(map[string]string)
+		for _, fp := range paths {
+			urls[fp.Path] = fp.URL
+		}
+		for p, name := range names {
+			got, ok := urls[name]
+			if !ok {
+				got = name
+			}
+			if got != p {
+				t.Fatalf("%q: file %q read back as %q", p, name, got)
+			}
+		}
+	})
+}
+
+// portableName reports whether elem can be the name of a file on every
+// filesystem.
+func portableName(elem string) bool {
+	if elem == "" || elem == "." || elem == ".." || isReservedName(elem) {
+		return false
+	}
+	if strings.HasSuffix(elem, ".") || strings.HasSuffix(elem, " ") {
+		return false
+	}
+	for i := 0; i < len(elem); i++ {
+		if elem[i] < 0x20 || strings.IndexByte(`<>:"/\|?*`, elem[i]) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// fold returns name once case and unicode normalization are folded, as
+// NTFS and macOS compare names.
+func fold(name string) string {
+	return strings.ToLower(norm.NFC.String(name))
+}
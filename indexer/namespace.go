@@ -0,0 +1,103 @@
+package indexer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// NamespaceFilter restricts the namespaces of the entries parsed.
+type NamespaceFilter struct {
+	// Include, when not empty, are the only namespaces parsed, instead of
+	// those parsed by default.
+	Include []byte
+	// Exclude are the namespaces never parsed, even when in Include.
+	Exclude []byte
+}
+
+// NewNamespaceFilter returns the filter of the namespaces, sorted and
+// without duplicates.
+func NewNamespaceFilter(include, exclude []byte) NamespaceFilter {
+	return NamespaceFilter{Include: sortedNamespaces(include), Exclude: sortedNamespaces(exclude)}
+}
+
+// sortedNamespaces returns a sorted copy of the namespaces, without
+// duplicates.
+func sortedNamespaces(ns []byte) []byte {
+	if len(ns) == 0 {
+		return nil
+	}
+	ns = slices.Clone(ns)
+	slices.Sort(ns)
+	return slices.Compact(ns)
+}
+
+// IsZero reports whether the filter keeps the namespaces parsed by
+// default.
+func (f NamespaceFilter) IsZero() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+// String is the canonical form of the filter, recorded in the
+// fingerprints of the tars, empty for the zero filter.
+func (f NamespaceFilter) String() string {
+	var opts []string
+	if len(f.Include) > 0 {
+		opts = append(opts, fmt.Sprintf("include-namespaces=%s", sortedNamespaces(f.Include)))
+	}
+	if len(f.Exclude) > 0 {
+		opts = append(opts, fmt.Sprintf("exclude-namespaces=%s", sortedNamespaces(f.Exclude)))
+	}
+	return strings.Join(opts, " ")
+}
+
+// Keeps reports whether the entries of the namespace are parsed, given
+// whether they are by default.
+func (f NamespaceFilter) Keeps(ns byte, byDefault bool) bool {
+	if slices.Contains(f.Exclude, ns) {
+		return false
+	}
+	if len(f.Include) > 0 {
+		return slices.Contains(f.Include, ns)
+	}
+	return byDefault
+}
+
+// SetNamespaceFilter restricts the entries parsed to the namespaces of
+// include, those parsed by default when it is empty, without those of
+// exclude, which wins over include. The progress of the parses then
+// counts only the entries of the namespaces kept.
+func (idx *SwarmZimIndexer) SetNamespaceFilter(include, exclude []byte) {
+	idx.namespaces = NewNamespaceFilter(include, exclude)
+}
+
+// NamespaceFilter returns the filter set with SetNamespaceFilter.
+func (idx *SwarmZimIndexer) NamespaceFilter() NamespaceFilter {
+	return idx.namespaces
+}
+
+// filteredOut reports whether the entry is left out by the namespace
+// filter, which does not count it in the progress of the parse.
+func (idx *SwarmZimIndexer) filteredOut(entry ZimEntry) bool {
+	return !idx.namespaces.IsZero() && entry != nil && !entry.IsDeleted() && !idx.included(entry)
+}
+
+// countFilteredOut returns the number of entries left out by the
+// namespace filter, reading every entry once, and 0 without filter or
+// when an entry makes the reader panic.
+func (idx *SwarmZimIndexer) countFilteredOut() (n int64) {
+	if idx.namespaces.IsZero() {
+		return 0
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			n = 0
+		}
+	}()
+	for i := uint32(0); i < idx.Z.ArticleCount(); i++ {
+		if entry, err := idx.Z.EntryAt(i); err == nil && idx.filteredOut(entry) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,103 @@
+package indexer
+
+import (
+	"bytes"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/r0qs/beezim/internal/htmltag"
+	"github.com/r0qs/beezim/internal/tarball"
+)
+
+// NavBarStylesheet is the name in the tars of the stylesheet of the
+// navigation bar of NavBar, among the assets.
+const NavBarStylesheet = AssetsPrefix + "/css/navbar.css"
+
+// navBarMarker is in the articles with the navigation bar, which NavBar
+// adds only once.
+const navBarMarker = `id="beezim-navbar"`
+
+// zimTitle returns the title of the ZIM, read once, its file name without
+// one. A parse with NavBar reads it before locking the reader.
+func (idx *SwarmZimIndexer) zimTitle() string {
+	idx.titleOnce.Do(func() {
+		idx.title = idx.ExtractMetadata()["Title"]
+		if idx.title == "" {
+			idx.title = strings.TrimSuffix(filepath.Base(idx.ZimPath), filepath.Ext(idx.ZimPath))
+		}
+	})
+	return idx.title
+}
+
+// navBarArticle adds the navigation bar of NavBar to the payload of an
+// HTML article, after its body start tag, leaving the articles without
+// one or already with the bar as they are.
+func (idx *SwarmZimIndexer) navBarArticle(a *Article) {
+	if baseMime(a.mime) != "text/html" || bytes.Contains(a.data, []byte(navBarMarker)) {
+		return
+	}
+	at := -1
+	z := htmltag.NewTokenizer(a.data)
+	for n := 0; ; {
+		t, ok := z.Next()
+		if !ok {
+			break
+		}
+		n += len(t.Raw)
+		if t.Kind == htmltag.StartTag && t.Name == "body" {
+			at = n
+			break
+		}
+	}
+	if at < 0 {
+		return
+	}
+	t, err := idx.pageTheme()
+	if err != nil {
+		return
+	}
+	data := map[string]interface{}{
+		"Title":            idx.zimTitle(),
+		"Index":            relativeLink(a.path, "index.html"),
+		"NavBarStylesheet": relativeLink(a.path, NavBarStylesheet),
+	}
+	if idx.enableSearch {
+		data["Search"] = relativeLink(a.path, "searchresult.html")
+	}
+	var bar bytes.Buffer
+	if err := t.execute(&bar, "navbar.html", idx.pageData(a.path, data)); err != nil {
+		idx.logger().Debug("navigation bar not added", "article", a.path, "err", err)
+		return
+	}
+	page := make([]byte, 0, len(a.data)+bar.Len())
+	page = append(page, a.data[:at]...)
+	page = append(page, bytes.TrimSpace(bar.Bytes())...)
+	page = append(page, a.data[at:]...)
+	a.Release()
+	a.data = page
+}
+
+// writeNavBarStylesheet appends the NavBarStylesheet to the tar, when not
+// among the assets appended, withAssets telling whether they are.
+func (idx *SwarmZimIndexer) writeNavBarStylesheet(w FileWriter, withAssets bool) error {
+	if !idx.NavBar || withAssets {
+		return nil
+	}
+	css, err := idx.navBarStylesheet()
+	if err != nil {
+		return err
+	}
+	idx.logger().Info("appending file", "file", NavBarStylesheet, "tar", w.Name())
+	return w.WriteFile(tarball.NewBytesFile(NavBarStylesheet, css))
+}
+
+// navBarStylesheet returns the content of the NavBarStylesheet, from the
+// assets of the theme.
+func (idx *SwarmZimIndexer) navBarStylesheet() ([]byte, error) {
+	t, err := idx.pageTheme()
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadFile(t.assets, strings.TrimPrefix(NavBarStylesheet, AssetsPrefix+"/"))
+}
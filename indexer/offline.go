@@ -0,0 +1,79 @@
+package indexer
+
+import (
+	"fmt"
+
+	"github.com/r0qs/beezim/internal/offline"
+)
+
+// Offline is how the HTML articles are rewritten so that browsing them
+// sends no request out of the archive, see SwarmZimIndexer.Offline.
+type Offline string
+
+const (
+	// OfflineKeep sends the articles as they are.
+	OfflineKeep Offline = ""
+	// OfflineAnnotate drops the scripts, style sheets and images the
+	// articles load from other sites, and opens their links to other
+	// sites in a new tab without referrer.
+	OfflineAnnotate Offline = "annotate"
+	// OfflineStrip also drops those links, keeping their text.
+	OfflineStrip Offline = "strip"
+)
+
+// ParseOffline returns the Offline named s, "keep" being OfflineKeep.
+func ParseOffline(s string) (Offline, error) {
+	switch o := Offline(s); o {
+	case OfflineKeep, OfflineAnnotate, OfflineStrip:
+		return o, nil
+	case "keep":
+		return OfflineKeep, nil
+	}
+	return OfflineKeep, fmt.Errorf("invalid offline policy %q, use keep, %s or %s", s, OfflineAnnotate, OfflineStrip)
+}
+
+// OfflineStats are the HTML articles of the last parse rewritten, see
+// Offline.
+type OfflineStats struct {
+	// Articles is the number of HTML articles rewritten.
+	Articles int
+	// Links is the number of their links to other sites rewritten, and
+	// Resources that of the scripts, style sheets and images of other
+	// sites dropped.
+	Links     int
+	Resources int
+}
+
+// OfflineStats returns the HTML articles rewritten by the last parse, none
+// with OfflineKeep.
+func (idx *SwarmZimIndexer) OfflineStats() OfflineStats {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.offlineStats
+}
+
+// resetOffline forgets the articles of the previous parse.
+func (idx *SwarmZimIndexer) resetOffline() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.offlineStats = OfflineStats{}
+}
+
+// offlineArticle rewrites the payload of an HTML article with Offline,
+// keeping it as it is when it loads and links nothing out of the archive.
+func (idx *SwarmZimIndexer) offlineArticle(a *Article) {
+	if baseMime(a.mime) != "text/html" {
+		return
+	}
+	data, stats := offline.Rewrite(a.data, idx.Offline == OfflineStrip)
+	if stats.Links == 0 && stats.Resources == 0 {
+		return
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.offlineStats.Articles++
+	idx.offlineStats.Links += stats.Links
+	idx.offlineStats.Resources += stats.Resources
+	a.Release()
+	a.data = data
+}
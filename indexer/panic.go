@@ -0,0 +1,134 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// ErrTooManyPanics is returned by a parse stopped because more entries
+// than PanicBudget made the ZIM reader panic.
+var ErrTooManyPanics = errors.New("too many entries made the zim reader panic")
+
+// EntryPanic is a panic of the ZIM reader while reading an entry, which
+// is skipped.
+type EntryPanic struct {
+	// Index is the url index of the entry.
+	Index uint32
+	// URL is the url of the entry, empty when the panic happened before
+	// it was read.
+	URL   string
+	Value interface{}
+	Stack []byte
+}
+
+func (p EntryPanic) Error() string {
+	if p.URL == "" {
+		return fmt.Sprintf("entry %d: zim reader panicked: %v", p.Index, p.Value)
+	}
+	return fmt.Sprintf("entry %d (%s): zim reader panicked: %v", p.Index, p.URL, p.Value)
+}
+
+// Panics returns the entries whose reading panicked in the parses of the
+// indexer.
+func (idx *SwarmZimIndexer) Panics() []EntryPanic {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return append([]EntryPanic(nil), idx.panics...)
+}
+
+// readEntry returns the article of the entry at the url index, false when
+// it is left out. An entry that can not be read is recorded and skipped,
+// see skipEntry. A panic of the reader is recorded and skipped, and
+// reported as ErrTooManyPanics once more than PanicBudget were recorded.
+// The article returned for a skipped entry is its exception file, see
+// ExceptionsDir. An article larger than MaxArticleSize is recorded and
+// left out.
+func (idx *SwarmZimIndexer) readEntry(i uint32) (entry ZimEntry, a Article, ok bool, err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		p := EntryPanic{Index: i, Value: r, Stack: debug.Stack()}
+		if entry != nil {
+			p.URL = entry.FullURL()
+		}
+		if err = idx.recordPanic(p); err != nil {
+			a, ok = Article{}, false
+			return
+		}
+		a, ok = idx.exception(i, p.URL, fmt.Errorf("zim reader panicked: %v", p.Value)), true
+	}()
+
+	entry, err = idx.Z.EntryAt(i)
+	if err != nil {
+		if err := idx.skipEntry(EntryError{Index: i, Err: err}); err != nil {
+			return nil, Article{}, false, err
+		}
+		return nil, idx.exception(i, "", err), true, nil
+	}
+	if entry.IsDeleted() || !idx.included(entry) || idx.DropTitleIndex && isTitleIndex(entry.FullURL()) {
+		return entry, Article{}, false, nil
+	}
+	if idx.DropSearchIndexes && isSearchIndex(entry.FullURL()) {
+		idx.dropSearchIndex(entry)
+		return entry, Article{}, false, nil
+	}
+	a, err = idx.article(entry)
+	if err != nil {
+		if err := idx.skipEntry(EntryError{Index: i, URL: entry.FullURL(), Err: err}); err != nil {
+			return entry, Article{}, false, err
+		}
+		return entry, idx.exception(i, entry.FullURL(), err), true, nil
+	}
+	if !entry.IsRedirect() && !idx.mimes.Allows(a.mime) {
+		a, ok = idx.filterArticle(a)
+		return entry, a, ok, nil
+	}
+	if !entry.IsRedirect() && idx.tooLarge(a) {
+		idx.skipTooLarge(i, entry, a)
+		return entry, Article{}, false, nil
+	}
+	if !entry.IsRedirect() {
+		// rewritten, minified and hashed by the workers reading the ZIM, in
+		// parallel
+		if idx.RelativeLinks {
+			idx.relativeLinksArticle(&a)
+		}
+		if idx.Offline != OfflineKeep {
+			idx.offlineArticle(&a)
+		}
+		if idx.NavBar {
+			idx.navBarArticle(&a)
+		}
+		if idx.Minify {
+			idx.minifyArticle(&a)
+		}
+		if err := a.hash(); err != nil {
+			a.Release()
+			if err := idx.skipEntry(EntryError{Index: i, URL: entry.FullURL(), Err: err}); err != nil {
+				return entry, Article{}, false, err
+			}
+			return entry, idx.exception(i, entry.FullURL(), err), true, nil
+		}
+		if idx.Snippets && baseMime(a.mime) == "text/html" {
+			a.snippet = htmlSnippet(a.data)
+		}
+	}
+	return entry, a, true, nil
+}
+
+// recordPanic records the panic of an entry, returning ErrTooManyPanics
+// when it exceeds the budget.
+func (idx *SwarmZimIndexer) recordPanic(p EntryPanic) error {
+	idx.logger().Error("skipping entry", "index", p.Index, "article", p.URL, "panic", fmt.Sprint(p.Value), "stack", string(p.Stack))
+	idx.mu.Lock()
+	idx.panics = append(idx.panics, p)
+	n := len(idx.panics)
+	idx.mu.Unlock()
+	if n > idx.PanicBudget {
+		return fmt.Errorf("%s: %w: %d, the last: %w", idx.ZimPath, ErrTooManyPanics, n, p)
+	}
+	return nil
+}
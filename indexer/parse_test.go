@@ -0,0 +1,200 @@
+package indexer_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/indexer/zimtest"
+)
+
+// parse returns the articles of the parse of the indexer by path, with
+// their payload.
+func parse(t *testing.T, idx *indexer.SwarmZimIndexer) map[string]string {
+	t.Helper()
+	articles := make(map[string]string)
+	for a := range idx.ParseZIM(context.Background()) {
+		articles[a.Path()] = string(a.Data())
+		a.Release()
+	}
+	if err := idx.ParseErr(); err != nil {
+		t.Fatal(err)
+	}
+	return articles
+}
+
+// skipped returns the url indexes of the entries skipped by the parses of
+// the indexer, with their error.
+func skipped(idx *indexer.SwarmZimIndexer) map[uint32]error {
+	errs := make(map[uint32]error)
+	for _, e := range idx.Skipped() {
+		errs[e.Index] = e.Err
+	}
+	return errs
+}
+
+func TestParseZIM(t *testing.T) {
+	corrupt := errors.New("corrupt cluster")
+	r := zimtest.New(
+		zimtest.Entry{Namespace: 'A', URL: "Main", Title: "Main", Mime: "text/html", Content: []byte("<p>main</p>")},
+		zimtest.Entry{Namespace: 'A', URL: "Dir/Page", Title: "Page", Mime: "text/html", Content: []byte("<p>page</p>")},
+		zimtest.Entry{Namespace: 'I', URL: "logo.png", Mime: "image/png", Content: []byte("png")},
+		zimtest.Entry{Namespace: 'I', URL: "broken.png", Mime: "image/png", DataErr: corrupt},
+		zimtest.Entry{Namespace: 'I', URL: "unreadable.png", Mime: "image/png"},
+	)
+	r.Main = 0
+	r.EntryErrs = map[uint32]error{4: corrupt}
+	idx := newIndexer(t, r)
+
+	got := parse(t, idx)
+	want := map[string]string{
+		"A/Main":     "<p>main</p>",
+		"A/Dir/Page": "<p>page</p>",
+		"I/logo.png": "png",
+	}
+	for p, data := range want {
+		if got[p] != data {
+			t.Errorf("%s: got %q, want %q", p, got[p], data)
+		}
+	}
+	for _, p := range []string{"I/broken.png", "I/unreadable.png"} {
+		if _, ok := got[p]; ok {
+			t.Errorf("%s: parsed, want skipped", p)
+		}
+	}
+
+	errs := skipped(idx)
+	if len(errs) != 2 {
+		t.Fatalf("skipped %v, want entries 3 and 4", errs)
+	}
+	for _, i := range []uint32{3, 4} {
+		if !errors.Is(errs[i], corrupt) {
+			t.Errorf("entry %d: skipped with %v, want %v", i, errs[i], corrupt)
+		}
+	}
+}
+
+func TestParseZIMPanic(t *testing.T) {
+	r := zimtest.New(
+		zimtest.Entry{Namespace: 'A', URL: "Main", Title: "Main", Mime: "text/html", Content: []byte("<p>main</p>")},
+		zimtest.Entry{Namespace: 'I', URL: "logo.png", Mime: "image/png", DataPanic: "index out of range"},
+	)
+	idx := newIndexer(t, r)
+	idx.PanicBudget = 1
+
+	got := parse(t, idx)
+	if _, ok := got["A/Main"]; !ok {
+		t.Errorf("A/Main not parsed")
+	}
+	if _, ok := got["I/logo.png"]; ok {
+		t.Errorf("I/logo.png parsed, want recovered")
+	}
+	panics := idx.Panics()
+	if len(panics) != 1 || panics[0].URL != "I/logo.png" {
+		t.Errorf("got panics %v, want one of I/logo.png", panics)
+	}
+
+	idx = newIndexer(t, r)
+	for a := range idx.ParseZIM(context.Background()) {
+		a.Release()
+	}
+	if err := idx.ParseErr(); !errors.Is(err, indexer.ErrTooManyPanics) {
+		t.Errorf("parse without budget failed with %v, want %v", err, indexer.ErrTooManyPanics)
+	}
+}
+
+func TestParseZIMRedirects(t *testing.T) {
+	r := zimtest.New(
+		zimtest.Entry{Namespace: 'A', URL: "Dir/Target", Title: "Target", Mime: "text/html", Content: []byte("<p>target</p>")},
+		zimtest.Redirect('A', "Home", 0),
+		// a chain, followed to the article it ends at
+		zimtest.Redirect('A', "Old", 1),
+		// a loop
+		zimtest.Redirect('A', "Loop1", 4),
+		zimtest.Redirect('A', "Loop2", 3),
+		// a bad redirect index
+		zimtest.Redirect('A', "Nowhere", 100),
+		zimtest.Entry{Namespace: 'A', URL: "Unreadable", Title: "Unreadable", Redirect: true, RedirectErr: errors.New("bad redirect index")},
+	)
+	idx := newIndexer(t, r)
+
+	got := parse(t, idx)
+	for _, p := range []string{"A/Home", "A/Old"} {
+		page, ok := got[p]
+		if !ok {
+			t.Errorf("%s: no redirect page", p)
+			continue
+		}
+		if !strings.Contains(page, `url=Dir/Target"`) {
+			t.Errorf("%s: redirect page not to Dir/Target:\n%s", p, page)
+		}
+	}
+
+	errs := skipped(idx)
+	for i, want := range map[uint32]error{3: indexer.ErrRedirectLoop, 4: indexer.ErrRedirectLoop} {
+		if !errors.Is(errs[i], want) {
+			t.Errorf("entry %d: skipped with %v, want %v", i, errs[i], want)
+		}
+	}
+	for _, i := range []uint32{5, 6} {
+		if errs[i] == nil {
+			t.Errorf("entry %d: not skipped", i)
+		}
+	}
+	for _, p := range []string{"A/Loop1", "A/Loop2", "A/Nowhere", "A/Unreadable"} {
+		if _, ok := got[p]; ok {
+			t.Errorf("%s: parsed, want skipped", p)
+		}
+	}
+}
+
+func TestParseZIMRedirectChain(t *testing.T) {
+	entries := []zimtest.Entry{
+		{Namespace: 'A', URL: "Target", Title: "Target", Mime: "text/html", Content: []byte("<p>target</p>")},
+	}
+	// MaxRedirectDepth redirects end at the target, one more does not
+	for i := 1; i <= indexer.MaxRedirectDepth+1; i++ {
+		entries = append(entries, zimtest.Redirect('A', "R"+strings.Repeat("x", i), uint32(i-1)))
+	}
+	idx := newIndexer(t, zimtest.New(entries...))
+
+	got := parse(t, idx)
+	last := "A/R" + strings.Repeat("x", indexer.MaxRedirectDepth)
+	if _, ok := got[last]; !ok {
+		t.Errorf("%s: no redirect page after %d redirects", last, indexer.MaxRedirectDepth)
+	}
+	errs := skipped(idx)
+	if len(errs) != 1 || !errors.Is(errs[indexer.MaxRedirectDepth+1], indexer.ErrRedirectChain) {
+		t.Errorf("skipped %v, want entry %d with %v", errs, indexer.MaxRedirectDepth+1, indexer.ErrRedirectChain)
+	}
+}
+
+func TestManifestRedirects(t *testing.T) {
+	r := zimtest.New(
+		zimtest.Entry{Namespace: 'A', URL: "Dir/Target", Title: "Target", Mime: "text/html", Content: []byte("<p>target</p>")},
+		zimtest.Redirect('A', "Home", 0),
+		zimtest.Redirect('A', "Old", 1),
+	)
+	idx := newIndexer(t, r)
+	idx.ManifestRedirects = true
+	files := readTar(t, tarZim(t, idx))
+
+	for _, p := range []string{"A/Home", "A/Old"} {
+		if _, ok := files[p]; ok {
+			t.Errorf("%s: redirect page in the tar", p)
+		}
+	}
+	var redirects []indexer.Redirect
+	if err := json.Unmarshal(files[indexer.RedirectsFile], &redirects); err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(redirects, func(a, b indexer.Redirect) int { return strings.Compare(a.Path, b.Path) })
+	want := []indexer.Redirect{{Path: "A/Home", Target: "A/Dir/Target"}, {Path: "A/Old", Target: "A/Dir/Target"}}
+	if !slices.Equal(redirects, want) {
+		t.Errorf("got redirects %v, want %v", redirects, want)
+	}
+}
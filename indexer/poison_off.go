@@ -0,0 +1,5 @@
+//go:build !race && !poison
+
+package indexer
+
+const poisonReleased = false
@@ -0,0 +1,7 @@
+//go:build race || poison
+
+package indexer
+
+// poisonReleased overwrites the pooled buffers when released, so use
+// after Release is detected by the race builds, or with the poison tag.
+const poisonReleased = true
@@ -0,0 +1,48 @@
+//go:build race || poison
+
+package indexer_test
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/indexer/zimtest"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+func TestReleasePoisons(t *testing.T) {
+	// the payloads of a ZIM file and the redirect pages are pooled
+	zimPath := filepath.Join(t.TempDir(), "poison.zim")
+	if err := zimtest.New(
+		zimtest.Entry{Namespace: 'A', URL: "Main", Title: "Main", Mime: "text/html", Content: []byte("<p>main</p>")},
+		zimtest.Redirect('A', "Home", 0),
+	).WriteFile(zimPath); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := indexer.New(zimPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	idx.Logger = logging.Discard()
+
+	n := 0
+	for a := range idx.ParseZIM(context.Background()) {
+		data := a.Data()
+		a.Release()
+		// a sink using the payload after Release reads garbage
+		if !bytes.Equal(data, bytes.Repeat([]byte{0xdd}, len(data))) {
+			t.Errorf("%s: payload %q left as it was after Release", a.Path(), data)
+		}
+		n++
+	}
+	if err := idx.ParseErr(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("got %d articles, want 2", n)
+	}
+}
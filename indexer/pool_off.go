@@ -0,0 +1,8 @@
+//go:build nopool
+
+package indexer
+
+// pooledBuffers allocates every payload and page instead of reusing the
+// released buffers, to compare the allocations of a parse without the
+// pools, see make benchpool.
+const pooledBuffers = false
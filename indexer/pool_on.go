@@ -0,0 +1,5 @@
+//go:build !nopool
+
+package indexer
+
+const pooledBuffers = true
@@ -0,0 +1,162 @@
+package indexer_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/indexer/zimtest"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// payload returns the content of the i-th article of poolZim, different
+// for every article so that a buffer reused too early shows.
+func payload(i int, size int) []byte {
+	return bytes.Repeat([]byte(fmt.Sprintf("<p>article %d</p>", i)), size/16)
+}
+
+// poolZim writes a ZIM of n articles of about size bytes, read through
+// the pooled buffers, and returns its path.
+func poolZim(tb testing.TB, n int, size int) string {
+	tb.Helper()
+	entries := make([]zimtest.Entry, n)
+	for i := range entries {
+		url := fmt.Sprintf("Article%d", i)
+		entries[i] = zimtest.Entry{Namespace: 'A', URL: url, Title: url, Mime: "text/html", Content: payload(i, size)}
+	}
+	zimPath := filepath.Join(tb.TempDir(), "pool.zim")
+	if err := zimtest.New(entries...).WriteFile(zimPath); err != nil {
+		tb.Fatal(err)
+	}
+	return zimPath
+}
+
+// parseZim parses the ZIM with the read workers and returns the tar
+// stream of its articles.
+func parseZim(tb testing.TB, zimPath string, workers int) (*indexer.SwarmZimIndexer, io.ReadCloser) {
+	tb.Helper()
+	idx, err := indexer.New(zimPath, false)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	idx.Logger = logging.Discard()
+	idx.ReadWorkers = workers
+	ctx := context.Background()
+	return idx, idx.TarStream(ctx, idx.ParseZIM(ctx))
+}
+
+func TestPooledPayloads(t *testing.T) {
+	const n, size = 500, 4 << 10
+	zimPath := poolZim(t, n, size)
+	for _, workers := range []int{1, 4} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			idx, r := parseZim(t, zimPath, workers)
+			defer idx.Close()
+			defer r.Close()
+
+			seen := 0
+			tr := tar.NewReader(r)
+			for {
+				hdr, err := tr.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				var i int
+				if _, err := fmt.Sscanf(hdr.Name, "A/Article%d", &i); err != nil {
+					continue
+				}
+				data, err := io.ReadAll(tr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(data, payload(i, size)) {
+					t.Fatalf("%s: payload of another article or of a released buffer", hdr.Name)
+				}
+				seen++
+			}
+			if err := idx.ParseErr(); err != nil {
+				t.Fatal(err)
+			}
+			if seen != n {
+				t.Errorf("got %d articles, want %d", seen, n)
+			}
+		})
+	}
+}
+
+// BenchmarkParsePool parses a ZIM of articles of 16 KiB to a tar stream,
+// reporting the allocations and the GC of a parse. The nopool build tag
+// allocates the payloads instead of reusing the pooled buffers:
+//
+//	go test ./indexer -run - -bench ParsePool -benchmem
+//	go test ./indexer -run - -bench ParsePool -benchmem -tags nopool
+func BenchmarkParsePool(b *testing.B) {
+	const n, size = 2000, 16 << 10
+	zimPath := poolZim(b, n, size)
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(n * size)
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				idx, r := parseZim(b, zimPath, workers)
+				if _, err := io.Copy(io.Discard, r); err != nil {
+					b.Fatal(err)
+				}
+				r.Close()
+				if err := idx.ParseErr(); err != nil {
+					b.Fatal(err)
+				}
+				idx.Close()
+			}
+			b.StopTimer()
+			runtime.ReadMemStats(&after)
+			b.ReportMetric(float64(after.NumGC-before.NumGC)/float64(b.N), "gc/op")
+			b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/float64(b.N), "gc-pause-ns/op")
+		})
+	}
+}
+
+// BenchmarkRedirectPages parses a ZIM of redirects to a tar stream, whose
+// generated pages are written in the pooled buffers, or allocated with
+// the nopool build tag:
+//
+//	go test ./indexer -run - -bench RedirectPages -benchmem
+//	go test ./indexer -run - -bench RedirectPages -benchmem -tags nopool
+func BenchmarkRedirectPages(b *testing.B) {
+	const n = 2000
+	entries := []zimtest.Entry{{Namespace: 'A', URL: "Target", Title: "Target", Mime: "text/html", Content: []byte("<p>target</p>")}}
+	for i := range n {
+		entries = append(entries, zimtest.Redirect('A', fmt.Sprintf("Redirect%d", i), 0))
+	}
+	zimPath := filepath.Join(b.TempDir(), "redirects.zim")
+	if err := zimtest.New(entries...).WriteFile(zimPath); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx, r := parseZim(b, zimPath, 1)
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			b.Fatal(err)
+		}
+		r.Close()
+		if err := idx.ParseErr(); err != nil {
+			b.Fatal(err)
+		}
+		idx.Close()
+	}
+}
@@ -0,0 +1,57 @@
+package indexer
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/r0qs/beezim/internal/progress"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// ProgressReporter reports the progress of one stage at a time, e.g. as a
+// progress bar: Start begins a stage of total items or bytes, unknown
+// when zero, Increment adds n of them done, and Finish ends the stage.
+// The indexer reports to it when the context carries no reporter.
+type ProgressReporter = progress.Bar
+
+// NoProgress reports nothing, e.g. for the programs using the indexer
+// whose output a bar would garble.
+var NoProgress ProgressReporter = progress.NopBar
+
+// NewBarProgress returns a cheggaaa/pb progress bar drawn on stderr.
+func NewBarProgress() ProgressReporter {
+	return progress.NewTerminalBar()
+}
+
+// NewLogProgress returns a reporter logging the progress to logger, the
+// default logger when nil, every interval, e.g. for a service.
+func NewLogProgress(logger logging.Logger, label string, interval time.Duration) ProgressReporter {
+	return progress.NewLogBar(logger, label, interval)
+}
+
+// DefaultProgress returns a progress bar when stderr is a terminal, and a
+// reporter logging the progress to logger every 10 seconds otherwise.
+func DefaultProgress(logger logging.Logger, label string) ProgressReporter {
+	return progress.DefaultBar(logger, label)
+}
+
+// WithProgress reports the progress of New and of the parses of the
+// indexer to p, see SwarmZimIndexer.Progress.
+func WithProgress(p ProgressReporter) Option {
+	return func(o *options) {
+		o.progress = p
+	}
+}
+
+// progressReporter returns the reporter of the context, or a reporter to
+// p without one, DefaultProgress of the ZIM at zimPath when nil.
+func progressReporter(ctx context.Context, p ProgressReporter, logger logging.Logger, zimPath string) progress.Reporter {
+	if rep := progress.FromContext(ctx); rep != nil {
+		return rep
+	}
+	if p == nil {
+		p = DefaultProgress(logger, filepath.Base(zimPath))
+	}
+	return progress.BarReporter(p)
+}
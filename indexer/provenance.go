@@ -0,0 +1,107 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/r0qs/beezim/internal/tarball"
+	"github.com/r0qs/beezim/internal/version"
+)
+
+// ProvenanceFile is the name in the tars of the provenance of the mirror.
+const ProvenanceFile = "_meta/beezim.json"
+
+// ProvenanceSchemaVersion is incremented on every incompatible change of
+// the provenance document.
+const ProvenanceSchemaVersion = 1
+
+// ErrNoProvenance is returned when reading the provenance of a tar built
+// before it was recorded.
+var ErrNoProvenance = errors.New("tar has no provenance")
+
+// Provenance describes what produced a mirror: the beezim build, the ZIM
+// and the options it was parsed with.
+type Provenance struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	Beezim        version.Info `json:"beezim"`
+	ZimFile       string       `json:"zimFile"`
+	ZimSHA256     string       `json:"zimSHA256,omitempty"`
+	// Options are the options changing the content of the tar, as in the
+	// fingerprint.
+	Options   string    `json:"options,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Reproducible is set when CreatedAt was pinned instead of being the
+	// time of the parse, so that the same ZIM, options and build give the
+	// same tar.
+	Reproducible bool `json:"reproducible,omitempty"`
+}
+
+// NewProvenance returns the provenance of a tar built now, or at pinned
+// when it is not zero, from the ZIM with the fingerprint, which can be
+// nil.
+func NewProvenance(zimPath string, fp *Fingerprint, pinned time.Time) Provenance {
+	p := Provenance{
+		SchemaVersion: ProvenanceSchemaVersion,
+		Beezim:        version.Get(),
+		ZimFile:       filepath.Base(zimPath),
+		CreatedAt:     time.Now().UTC().Truncate(time.Second),
+	}
+	if fp != nil {
+		p.ZimSHA256 = fp.ZimSHA256
+		p.Options = fp.Filters
+	}
+	if !pinned.IsZero() {
+		p.CreatedAt = pinned.UTC()
+		p.Reproducible = true
+	}
+	return p
+}
+
+// MakeProvenanceFile appends the provenance of the indexer to the tar.
+func (idx *SwarmZimIndexer) MakeProvenanceFile(tarFile string) error {
+	return idx.writeProvenanceFile(AppendTo(tarFile))
+}
+
+func (idx *SwarmZimIndexer) writeProvenanceFile(w FileWriter) error {
+	if idx.Provenance == nil {
+		return nil
+	}
+	data, err := json.MarshalIndent(idx.Provenance, "", "  ")
+	if err != nil {
+		return err
+	}
+	return w.WriteFile(tarball.NewBytesFile(ProvenanceFile, append(data, '\n')))
+}
+
+// ReadProvenance reads the provenance recorded in the tar.
+func ReadProvenance(tarFile string) (Provenance, error) {
+	data, err := tarball.ReadFile(tarFile, ProvenanceFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return Provenance{}, ErrNoProvenance
+	}
+	if err != nil {
+		return Provenance{}, err
+	}
+	return DecodeProvenance(data)
+}
+
+// DecodeProvenance decodes a provenance document, written by this or an
+// older version of the schema. Unknown fields are ignored.
+func DecodeProvenance(data []byte) (Provenance, error) {
+	var p Provenance
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(&p); err != nil {
+		return Provenance{}, fmt.Errorf("error reading provenance: %v", err)
+	}
+	if p.SchemaVersion == 0 {
+		return Provenance{}, errors.New("not a beezim provenance: no schema version")
+	}
+	if p.SchemaVersion > ProvenanceSchemaVersion {
+		return Provenance{}, fmt.Errorf("provenance has unsupported schema version %d", p.SchemaVersion)
+	}
+	return p, nil
+}
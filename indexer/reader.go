@@ -0,0 +1,148 @@
+package indexer
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrZimCorrupt is returned for a file that can not be read as a ZIM.
+var ErrZimCorrupt = errors.New("zim is corrupt or truncated")
+
+// ErrReaderBusy is returned when a parse or Close is started on a reader
+// already read by a parse, possibly of another indexer sharing it.
+var ErrReaderBusy = errors.New("zim reader is used by another pass")
+
+// busyReaders are the readers being read by a parse. Readers are compared
+// by identity: the gozim adapter wraps a pointer and the fakes of zimtest
+// are pointers.
+var busyReaders = struct {
+	sync.Mutex
+	m map[ZimReader]bool
+}{m: make(map[ZimReader]bool)}
+
+// beginPass marks the reader as read by a parse, failing with
+// ErrReaderBusy when it already is.
+func beginPass(z ZimReader) error {
+	busyReaders.Lock()
+	defer busyReaders.Unlock()
+	if busyReaders.m[z] {
+		return ErrReaderBusy
+	}
+	busyReaders.m[z] = true
+	return nil
+}
+
+// endPass marks the reader as no longer read.
+func endPass(z ZimReader) {
+	busyReaders.Lock()
+	defer busyReaders.Unlock()
+	delete(busyReaders.m, z)
+}
+
+// ZimReader is the part of a ZIM reader used by the indexer. NewReader
+// adapts the gozim reader, or reads the newer versions itself; the
+// zimtest package has an in-memory one.
+type ZimReader interface {
+	// ArticleCount is the number of entries of the ZIM.
+	ArticleCount() uint32
+	// MainPage returns the main page, nil when the ZIM has none.
+	MainPage() (ZimEntry, error)
+	// Iterate calls fn with the url index of every entry, in title order.
+	Iterate(fn func(urlIdx uint32))
+	// EntryAt returns the entry at the url index.
+	EntryAt(urlIdx uint32) (ZimEntry, error)
+}
+
+// ZimEntry is an entry of a ZimReader.
+type ZimEntry interface {
+	// FullURL is the url of the entry prefixed by its namespace.
+	FullURL() string
+	Title() string
+	Namespace() byte
+	MimeType() string
+	IsRedirect() bool
+	IsDeleted() bool
+	// Data returns the decompressed content of the entry.
+	Data() ([]byte, error)
+	// RedirectIndex returns the url index of the target of a redirect.
+	RedirectIndex() (uint32, error)
+}
+
+// sectionEntry is implemented by the entries whose content can be read
+// from the file of the ZIM as it is, see StreamSize.
+type sectionEntry interface {
+	// DataSection returns a reader of the content of the entry in the
+	// file, nil when it has to be decompressed.
+	DataSection() (*io.SectionReader, error)
+}
+
+// bufferEntry is implemented by the entries whose content can be read
+// into a pooled buffer, released with the article, instead of being
+// allocated for each of them.
+type bufferEntry interface {
+	// DataBuffer returns the decompressed content of the entry in a
+	// buffer of getBuffer.
+	DataBuffer() (*bytes.Buffer, error)
+}
+
+// NewReader opens the ZIM at zimPath, of any version of the formats 5
+// and 6, e.g. the 6.1 of libzim 7 with the new namespace scheme, split
+// in parts or not, see ZimParts.
+func NewReader(zimPath string) (ZimReader, error) {
+	f, err := OpenZimFile(zimPath)
+	if err != nil {
+		return nil, err
+	}
+	h, err := readZimHeader(f)
+	if err == nil && len(f.parts) > 1 && f.Size() < int64(h.ChecksumPos)+md5.Size {
+		// the last parts of a split ZIM are missing
+		err = fmt.Errorf("parts end at %d bytes of %d", f.Size(), int64(h.ChecksumPos)+md5.Size)
+	}
+	if err == nil {
+		var r *zimFileReader
+		if r, err = openZimFile(f, h); err == nil {
+			return r, nil
+		}
+	}
+	f.Close()
+	return nil, fmt.Errorf("%s: %w: %v", zimPath, ErrZimCorrupt, err)
+}
+
+// clusterLocks are the clusters being decompressed, keyed like the blob
+// cache of gozim, which only holds the clusters of one reader.
+var clusterLocks = struct {
+	sync.Mutex
+	m map[uint32]*clusterLock
+}{m: make(map[uint32]*clusterLock)}
+
+type clusterLock struct {
+	sync.Mutex
+	// waiters is the number of entries holding or waiting for the lock.
+	waiters int
+}
+
+// lockCluster locks the cluster and returns the function unlocking it.
+func lockCluster(cluster uint32) func() {
+	clusterLocks.Lock()
+	l := clusterLocks.m[cluster]
+	if l == nil {
+		l = &clusterLock{}
+		clusterLocks.m[cluster] = l
+	}
+	l.waiters++
+	clusterLocks.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		clusterLocks.Lock()
+		if l.waiters--; l.waiters == 0 {
+			delete(clusterLocks.m, cluster)
+		}
+		clusterLocks.Unlock()
+	}
+}
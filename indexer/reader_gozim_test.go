@@ -0,0 +1,56 @@
+//go:build !windows
+
+package indexer_test
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	zim "github.com/akhenakh/gozim"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/indexer/zimtest"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// TestNewFromReader runs two passes over one reader opened with gozim,
+// which the indexers leave open for the caller to close.
+func TestNewFromReader(t *testing.T) {
+	zimPath := filepath.Join(t.TempDir(), "test.zim")
+	// gozim can not read the last cluster, see NewFromReader, which
+	// holds the last entry, sorted after the articles by url
+	r := articlesZim(20)
+	r.Entries = append(r.Entries, zimtest.Entry{Namespace: 'Z', URL: "Last", Mime: "text/plain", Content: []byte("last")})
+	r.ClusterSize = 1
+	if err := r.WriteFile(zimPath); err != nil {
+		t.Fatal(err)
+	}
+	z, err := zim.NewReader(zimPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	var tars []map[string][]byte
+	for range 2 {
+		idx := indexer.NewFromReader(z, zimPath, false)
+		idx.Logger = logging.Discard()
+		tars = append(tars, readTar(t, tarZim(t, idx)))
+		if err := idx.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(tars[0]["A/Article0"]) == 0 {
+		t.Fatal("A/Article0 missing from the first pass")
+	}
+	for name, data := range tars[0] {
+		if !bytes.Equal(tars[1][name], data) {
+			t.Errorf("%s: got %q in the second pass, want %q", name, tars[1][name], data)
+		}
+	}
+	// the reader is still open
+	if _, err := z.ArticleAtURLIdx(0); err != nil {
+		t.Errorf("reader closed by the indexer: %v", err)
+	}
+}
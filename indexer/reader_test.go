@@ -0,0 +1,132 @@
+package indexer_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"testing"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/indexer/zimtest"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// closingReader is a reader shared by indexers, counting its closes.
+type closingReader struct {
+	*zimtest.Reader
+	closes int
+}
+
+func (r *closingReader) Close() error {
+	r.closes++
+	return nil
+}
+
+// TestSharedReader runs two passes over one reader, through two indexers
+// sharing it, which leave it open when closed.
+func TestSharedReader(t *testing.T) {
+	z := &closingReader{Reader: articlesZim(20)}
+	first := indexer.NewWithReader("test.zim", z, false)
+	first.Logger = logging.Discard()
+	second := indexer.NewWithReader("test.zim", z, false)
+	second.Logger = logging.Discard()
+
+	want := readTar(t, tarZim(t, first))
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if z.closes != 0 {
+		t.Fatal("the indexer closed the reader of the caller")
+	}
+	got := readTar(t, tarZim(t, second))
+	if len(got) != len(want) {
+		t.Fatalf("second pass: %d files, want %d", len(got), len(want))
+	}
+	for name, data := range want {
+		if !bytes.Equal(got[name], data) {
+			t.Errorf("%s: got %q in the second pass, want %q", name, got[name], data)
+		}
+	}
+	if err := second.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if z.closes != 0 {
+		t.Error("the indexer closed the reader of the caller")
+	}
+
+	// a closed indexer does not parse, even though its reader is open
+	ctx := context.Background()
+	for range first.ParseZIM(ctx) {
+		t.Fatal("got an article from a closed indexer")
+	}
+	if err := first.ParseErr(); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("got %v, want %v", err, fs.ErrClosed)
+	}
+}
+
+// TestReaderBusy starts a pass while another reads the shared reader.
+func TestReaderBusy(t *testing.T) {
+	z := articlesZim(20)
+	first := newIndexer(t, z)
+	second := newIndexer(t, z)
+	ctx := context.Background()
+
+	n := 0
+	for a, err := range first.Articles(ctx) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.Release()
+		if n++; n > 1 {
+			break
+		}
+		for a, err := range second.Articles(ctx) {
+			if !errors.Is(err, indexer.ErrReaderBusy) {
+				t.Fatalf("second pass: got %s, %v, want %v", a.Path(), err, indexer.ErrReaderBusy)
+			}
+		}
+		if err := second.ParseErr(); !errors.Is(err, indexer.ErrReaderBusy) {
+			t.Errorf("second pass: got %v, want %v", err, indexer.ErrReaderBusy)
+		}
+		for _, idx := range []*indexer.SwarmZimIndexer{first, second} {
+			if err := idx.Close(); !errors.Is(err, indexer.ErrReaderBusy) {
+				t.Errorf("close during the pass: got %v, want %v", err, indexer.ErrReaderBusy)
+			}
+		}
+	}
+
+	// the reader is released when the loop breaks
+	files := readTar(t, tarZim(t, second))
+	for i := range 20 {
+		if name := fmt.Sprintf("A/Article%d", i); files[name] == nil {
+			t.Errorf("second pass after the first: %s missing", name)
+		}
+	}
+}
+
+// TestOwnedReader closes the reader opened by New with the indexer.
+func TestOwnedReader(t *testing.T) {
+	zimPath := filepath.Join(t.TempDir(), "test.zim")
+	if err := articlesZim(20).WriteFile(zimPath); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := indexer.New(zimPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.Logger = logging.Discard()
+	tarZim(t, idx)
+	if err := idx.Close(); err != nil {
+		t.Fatal(err)
+	}
+	e, err := idx.Z.EntryAt(0)
+	if err == nil {
+		_, err = e.Data()
+	}
+	if err == nil {
+		t.Error("read the reader of a closed indexer")
+	}
+}
@@ -0,0 +1,79 @@
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/r0qs/beezim/internal/tarball"
+)
+
+// RedirectsFile lists the redirects left out of the tars built with
+// ManifestRedirects.
+const RedirectsFile = "redirects.json"
+
+// MaxRedirectDepth is the number of redirects followed from a redirect
+// of the ZIM to the entry it ends at.
+const MaxRedirectDepth = 16
+
+var (
+	// ErrRedirectLoop is the error of the redirects which lead back to
+	// one of them, which are skipped.
+	ErrRedirectLoop = errors.New("redirect loop")
+	// ErrRedirectChain is the error of the redirects followed by more
+	// than MaxRedirectDepth others, which are skipped.
+	ErrRedirectChain = errors.New("redirect chain too long")
+)
+
+// redirectTarget returns the entry the redirect ends at once the
+// redirects it leads to are followed, so that its page links to it
+// without a page per redirect in between.
+func (idx *SwarmZimIndexer) redirectTarget(entry ZimEntry) (ZimEntry, error) {
+	seen := make(map[uint32]bool)
+	for depth := 0; ; depth++ {
+		ridx, err := entry.RedirectIndex()
+		if err != nil {
+			return nil, fmt.Errorf("reading redirect: %w", err)
+		}
+		if seen[ridx] {
+			return nil, fmt.Errorf("%w through entry %d", ErrRedirectLoop, ridx)
+		}
+		seen[ridx] = true
+		if depth == MaxRedirectDepth {
+			return nil, fmt.Errorf("%w: more than %d redirects", ErrRedirectChain, MaxRedirectDepth)
+		}
+		target, err := idx.Z.EntryAt(ridx)
+		if err != nil {
+			return nil, fmt.Errorf("reading redirect target %d: %w", ridx, err)
+		}
+		if !target.IsRedirect() {
+			return target, nil
+		}
+		entry = target
+	}
+}
+
+// Redirect is a redirect of the ZIM left out of a tar, from Path to the
+// entry it ends at, Target.
+type Redirect struct {
+	Path   string `json:"path"`
+	Target string `json:"target"`
+}
+
+// ReadRedirects returns the redirects listed in the RedirectsFile of the
+// tar, none when it was built without ManifestRedirects.
+func ReadRedirects(tarFile string) ([]Redirect, error) {
+	data, err := tarball.ReadFile(tarFile, RedirectsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var redirects []Redirect
+	if err := json.Unmarshal(data, &redirects); err != nil {
+		return nil, fmt.Errorf("error decoding %s of %s: %w", RedirectsFile, tarFile, err)
+	}
+	return redirects, nil
+}
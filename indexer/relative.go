@@ -0,0 +1,49 @@
+package indexer
+
+import "github.com/r0qs/beezim/internal/relative"
+
+// RelativeLinksStats are the articles of the last parse whose links were
+// made relative, see RelativeLinks.
+type RelativeLinksStats struct {
+	// Articles is the number of HTML and CSS articles rewritten, and
+	// Links that of their root-absolute links made relative.
+	Articles int
+	Links    int
+}
+
+// RelativeLinksStats returns the articles rewritten by the last parse,
+// none without RelativeLinks.
+func (idx *SwarmZimIndexer) RelativeLinksStats() RelativeLinksStats {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.relativeLinksStats
+}
+
+// resetRelativeLinks forgets the articles of the previous parse.
+func (idx *SwarmZimIndexer) resetRelativeLinks() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.relativeLinksStats = RelativeLinksStats{}
+}
+
+// relativeLinksArticle makes the root-absolute links of the payload of an
+// HTML or CSS article relative to its path in the tar.
+func (idx *SwarmZimIndexer) relativeLinksArticle(a *Article) {
+	var data []byte
+	var n int
+	switch baseMime(a.mime) {
+	case "text/html":
+		data, n = relative.HTML(a.data, a.path)
+	case "text/css":
+		data, n = relative.CSS(a.data, a.path)
+	}
+	if n == 0 {
+		return
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.relativeLinksStats.Articles++
+	idx.relativeLinksStats.Links += n
+	a.Release()
+	a.data = data
+}
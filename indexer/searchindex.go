@@ -0,0 +1,53 @@
+package indexer
+
+// SearchIndexStats are the entries of the X namespace left out by the last
+// parse, see DropSearchIndexes.
+type SearchIndexStats struct {
+	// Entries is the number of entries left out, and Bytes their size.
+	Entries int
+	Bytes   int64
+}
+
+// SearchIndexStats returns the search indexes left out by the last parse,
+// none without DropSearchIndexes.
+func (idx *SwarmZimIndexer) SearchIndexStats() SearchIndexStats {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.searchIndexStats
+}
+
+// resetSearchIndexes forgets the entries of the previous parse.
+func (idx *SwarmZimIndexer) resetSearchIndexes() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.searchIndexStats = SearchIndexStats{}
+}
+
+// dropSearchIndex records the entry of the X namespace as left out, its
+// size read from the file of the ZIM, or its content when its cluster is
+// compressed.
+func (idx *SwarmZimIndexer) dropSearchIndex(entry ZimEntry) {
+	var size int64
+	if !entry.IsRedirect() {
+		size = entrySize(entry)
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.searchIndexStats.Entries++
+	idx.searchIndexStats.Bytes += size
+}
+
+// entrySize returns the size of the content of the entry, zero when it can
+// not be read.
+func entrySize(entry ZimEntry) int64 {
+	if e, ok := entry.(sectionEntry); ok {
+		if r, err := e.DataSection(); err == nil && r != nil {
+			return r.Size()
+		}
+	}
+	data, err := entry.Data()
+	if err != nil {
+		return 0
+	}
+	return int64(len(data))
+}
@@ -0,0 +1,269 @@
+package indexer
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/r0qs/beezim/internal/tarball"
+	"golang.org/x/text/unicode/norm"
+)
+
+// SearchShardsDir is the directory of the tars of the title search of the
+// DApp, whose SearchShardsIndex lists the shards of the titles.
+const SearchShardsDir = "_beezim/search"
+
+// SearchShardsIndex is the index of the shards of the title search. When
+// all the titles fit in one shard, it holds them instead.
+const SearchShardsIndex = SearchShardsDir + "/index.json"
+
+const (
+	// shardPrefixLen is the number of characters of the normalized titles
+	// the shards are keyed by, one more for each split of a shard.
+	shardPrefixLen = 2
+	// maxShardPrefixLen stops the splits of the shards of titles sharing
+	// that many characters, which stay larger than maxShardSize.
+	maxShardPrefixLen = 16
+	// maxShardSize is the size above which a shard is split by the next
+	// character of its titles.
+	maxShardSize = 1 << 20
+	// shardPassSize is the size of the shards collected by each pass over
+	// the entries of a DiskStore, which are read again for the next ones.
+	shardPassSize = 64 << 20
+)
+
+// SearchTitle is a title of the title search, in a shard.
+type SearchTitle struct {
+	Title    string `json:"title"`
+	Path     string `json:"path"`
+	Redirect bool   `json:"redirect,omitempty"`
+	// Snippet is that of the article, see SwarmZimIndexer.Snippets.
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// SearchShard is a shard listed by the SearchShardsIndex: the titles whose
+// normalized form starts with Prefix, in File of SearchShardsDir, except
+// those of the longer prefixes of the other shards.
+type SearchShard struct {
+	Prefix string `json:"prefix"`
+	File   string `json:"file"`
+	Count  int    `json:"count"`
+}
+
+// SearchShardsManifest is the content of the SearchShardsIndex, with
+// either Shards or Titles.
+type SearchShardsManifest struct {
+	Shards []SearchShard `json:"shards,omitempty"`
+	Titles []SearchTitle `json:"titles,omitempty"`
+}
+
+// NormalizeTitle returns the title as the title search compares it: in
+// lower case, without diacritics.
+func NormalizeTitle(title string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(title) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// runePrefix returns the first n characters of s, all of them when it has
+// fewer.
+func runePrefix(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+// searchTitle returns the title of the title search of the entry, the
+// articles of the A namespace found in the tar, and its normalized form.
+func searchTitle(e IndexEntry) (SearchTitle, string, bool) {
+	if !strings.HasPrefix(e.Path, "A/") || e.Metadata.Title == "" ||
+		e.Metadata.Skipped != "" || e.Metadata.Exception != "" {
+		return SearchTitle{}, "", false
+	}
+	t := SearchTitle{Title: e.Metadata.Title, Path: e.Path, Redirect: e.Metadata.Redirect, Snippet: e.Metadata.Snippet}
+	return t, NormalizeTitle(t.Title), true
+}
+
+// titleSize is about the size of the title in a shard.
+func titleSize(t SearchTitle) int64 {
+	return int64(len(t.Title)+len(t.Path)+len(t.Snippet)) + 32
+}
+
+// shardKeys keys the titles of the shards by prefix. The titles of a
+// prefix split are keyed by the longer prefixes, but for those no longer
+// than it.
+type shardKeys map[string]bool
+
+func (k shardKeys) key(normalized string) string {
+	key := runePrefix(normalized, shardPrefixLen)
+	for k[key] && len(key) < len(normalized) {
+		key = runePrefix(normalized, utf8.RuneCountInString(key)+1)
+	}
+	return key
+}
+
+// MakeSearchShards appends the titles of the articles of the parses of
+// the indexer to the tar, as the shards of SearchShardsIndex the title
+// search of the DApp fetches by the prefix of the query.
+func (idx *SwarmZimIndexer) MakeSearchShards(tarFile string) error {
+	return idx.writeSearchShards(AppendTo(tarFile))
+}
+
+// writeSearchShards reads the entries once for the size of the shards of
+// each prefix, once more for each split of the shards larger than
+// maxShardSize, and once for each shardPassSize of shards written from a
+// DiskStore, so that the titles are never held in memory all at once.
+func (idx *SwarmZimIndexer) writeSearchShards(w FileWriter) error {
+	split := make(shardKeys)
+	sizes := make(map[string]int64)
+	var total int64
+	idx.ForEachEntry(func(e IndexEntry) bool {
+		if t, n, ok := searchTitle(e); ok {
+			sizes[split.key(n)] += titleSize(t)
+			total += titleSize(t)
+		}
+		return true
+	})
+	if err := idx.EntriesErr(); err != nil {
+		return err
+	}
+
+	if total <= maxShardSize {
+		var titles []shardTitle
+		idx.ForEachEntry(func(e IndexEntry) bool {
+			if t, n, ok := searchTitle(e); ok {
+				titles = append(titles, shardTitle{t, n})
+			}
+			return true
+		})
+		if err := idx.EntriesErr(); err != nil {
+			return err
+		}
+		return idx.writeSearchShardsIndex(w, SearchShardsManifest{Titles: sortTitles(titles)})
+	}
+
+	for depth := shardPrefixLen; depth < maxShardPrefixLen; depth++ {
+		oversized := make(shardKeys)
+		for key, size := range sizes {
+			if size > maxShardSize && utf8.RuneCountInString(key) == depth {
+				oversized[key] = true
+				split[key] = true
+			}
+		}
+		if len(oversized) == 0 {
+			break
+		}
+		idx.ForEachEntry(func(e IndexEntry) bool {
+			t, n, ok := searchTitle(e)
+			if !ok {
+				return true
+			}
+			if parent := runePrefix(n, depth); oversized[parent] && len(n) > len(parent) {
+				sizes[parent] -= titleSize(t)
+				sizes[runePrefix(n, depth+1)] += titleSize(t)
+			}
+			return true
+		})
+		if err := idx.EntriesErr(); err != nil {
+			return err
+		}
+	}
+
+	keys := slices.Sorted(maps.Keys(sizes))
+	keys = slices.DeleteFunc(keys, func(k string) bool { return sizes[k] == 0 })
+	files := make(map[string]string, len(keys))
+	for i, key := range keys {
+		files[key] = strconv.Itoa(i) + ".json"
+	}
+	passSize := int64(shardPassSize)
+	idx.mu.Lock()
+	if spoolDir(idx.entries) == "" {
+		// all the entries are in memory already
+		passSize = total
+	}
+	idx.mu.Unlock()
+
+	idx.logger().Info("appending search shards", "shards", len(keys), "tar", w.Name())
+	var manifest SearchShardsManifest
+	for start := 0; start < len(keys); {
+		end, size := start, int64(0)
+		for end < len(keys) && (end == start || size+sizes[keys[end]] <= passSize) {
+			size += sizes[keys[end]]
+			end++
+		}
+		pass := make(map[string][]shardTitle, end-start)
+		for _, key := range keys[start:end] {
+			pass[key] = nil
+		}
+		idx.ForEachEntry(func(e IndexEntry) bool {
+			if t, n, ok := searchTitle(e); ok {
+				key := split.key(n)
+				if titles, ok := pass[key]; ok {
+					pass[key] = append(titles, shardTitle{t, n})
+				}
+			}
+			return true
+		})
+		if err := idx.EntriesErr(); err != nil {
+			return err
+		}
+		for _, key := range keys[start:end] {
+			titles := sortTitles(pass[key])
+			data, err := json.Marshal(titles)
+			if err != nil {
+				return err
+			}
+			name := SearchShardsDir + "/" + files[key]
+			if err := w.WriteFile(tarball.NewBytesFile(name, append(data, '\n'))); err != nil {
+				return err
+			}
+			manifest.Shards = append(manifest.Shards, SearchShard{Prefix: key, File: files[key], Count: len(titles)})
+		}
+		start = end
+	}
+	return idx.writeSearchShardsIndex(w, manifest)
+}
+
+// shardTitle is a title of a shard and its normalized form.
+type shardTitle struct {
+	SearchTitle
+	normalized string
+}
+
+// sortTitles returns the titles sorted by their normalized form, then by
+// path.
+func sortTitles(titles []shardTitle) []SearchTitle {
+	slices.SortFunc(titles, func(a, b shardTitle) int {
+		return cmp.Or(strings.Compare(a.normalized, b.normalized), strings.Compare(a.Path, b.Path))
+	})
+	sorted := make([]SearchTitle, len(titles))
+	for i, t := range titles {
+		sorted[i] = t.SearchTitle
+	}
+	return sorted
+}
+
+func (idx *SwarmZimIndexer) writeSearchShardsIndex(w FileWriter, manifest SearchShardsManifest) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if err := enc.Encode(manifest); err != nil {
+		return err
+	}
+	idx.logger().Info("appending file", "file", SearchShardsIndex, "tar", w.Name())
+	return w.WriteFile(tarball.NewBufferFile(SearchShardsIndex, &buf))
+}
@@ -0,0 +1,194 @@
+package indexer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/r0qs/beezim/internal/tarball"
+)
+
+// SitemapFile is the name in the tars of the sitemap index, listing the
+// shards of the sitemap of the HTML articles, see MakeSitemap.
+const SitemapFile = "_beezim/sitemap.xml"
+
+// SitemapShardSize is the number of URLs of a shard of the sitemap, the
+// most the sitemap protocol allows.
+const SitemapShardSize = 50000
+
+// sitemapShard returns the name in the tars of the shard i of the sitemap,
+// from 1.
+func sitemapShard(i int) string {
+	return fmt.Sprintf("_beezim/sitemap-%d.xml", i)
+}
+
+const (
+	sitemapHeader = xml.Header + `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n"
+	sitemapFooter = "</urlset>\n"
+)
+
+// sitemapURL returns the URL in the sitemap file at name of the file at
+// path of the tar: relative to the sitemap, or under baseURL, the URL of
+// the root of the tar once uploaded, when set.
+func sitemapURL(baseURL, name, path string) string {
+	if baseURL == "" {
+		return relativeLink(name, path)
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/" + relativeLink("", path)
+}
+
+// inSitemap tells whether the entry is listed by the sitemap: the HTML
+// articles of the tar, without the redirects and duplicates.
+func inSitemap(e IndexEntry) bool {
+	m := e.Metadata
+	return baseMime(m.MimeType) == "text/html" && !m.Redirect && m.Duplicate == "" &&
+		m.Skipped == "" && m.Exception == ""
+}
+
+// MakeSitemap appends the sitemap of the HTML articles of the parses of
+// the indexer to the tar: the shards of SitemapShardSize URLs, and the
+// SitemapFile listing them. The URLs are relative to the sitemap files
+// without baseURL, the URL of the root of the tar once uploaded, e.g. that
+// of a gateway, and under it otherwise.
+func (idx *SwarmZimIndexer) MakeSitemap(tarFile string, baseURL string) error {
+	return idx.writeSitemap(AppendTo(tarFile), baseURL)
+}
+
+// writeSitemap reads the entries once, writing the shards in memory, or to
+// the directory of the DiskStore, as large as the entries are.
+func (idx *SwarmZimIndexer) writeSitemap(w FileWriter, baseURL string) error {
+	idx.mu.Lock()
+	dir := spoolDir(idx.entries)
+	idx.mu.Unlock()
+
+	var shards []*spool
+	defer func() {
+		for _, s := range shards {
+			s.close()
+		}
+	}()
+	var cur *spool
+	n := 0
+	var err error
+	idx.ForEachEntry(func(e IndexEntry) bool {
+		if !inSitemap(e) {
+			return true
+		}
+		if cur == nil || n == SitemapShardSize {
+			if cur != nil {
+				if _, err = io.WriteString(cur, sitemapFooter); err != nil {
+					return false
+				}
+			}
+			if cur, err = newSpool(dir); err != nil {
+				return false
+			}
+			shards = append(shards, cur)
+			n = 0
+			if _, err = io.WriteString(cur, sitemapHeader); err != nil {
+				return false
+			}
+		}
+		n++
+		err = writeSitemapLoc(cur, "url", sitemapURL(baseURL, sitemapShard(len(shards)), e.Path))
+		return err == nil
+	})
+	if err == nil {
+		err = idx.EntriesErr()
+	}
+	if err == nil && cur != nil {
+		_, err = io.WriteString(cur, sitemapFooter)
+	}
+	if err != nil {
+		return fmt.Errorf("sitemap: %w", err)
+	}
+
+	var index bytes.Buffer
+	index.WriteString(xml.Header + `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n")
+	for i, s := range shards {
+		name := sitemapShard(i + 1)
+		f, err := s.file(name)
+		if err != nil {
+			return fmt.Errorf("sitemap: %w", err)
+		}
+		idx.logger().Info("appending file", "file", name, "tar", w.Name())
+		if err := w.WriteFile(f); err != nil {
+			return err
+		}
+		if err := writeSitemapLoc(&index, "sitemap", sitemapURL(baseURL, SitemapFile, name)); err != nil {
+			return err
+		}
+	}
+	index.WriteString("</sitemapindex>\n")
+	idx.logger().Info("appending file", "file", SitemapFile, "tar", w.Name())
+	return w.WriteFile(tarball.NewBufferFile(SitemapFile, &index))
+}
+
+// writeSitemapLoc writes the element of the sitemap, url or sitemap, of
+// the URL.
+func writeSitemapLoc(w io.Writer, elem string, loc string) error {
+	if _, err := fmt.Fprintf(w, "<%s><loc>", elem); err != nil {
+		return err
+	}
+	if err := xml.EscapeText(w, []byte(loc)); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "</loc></%s>\n", elem)
+	return err
+}
+
+// spool is a file written before it is appended to a tar: in memory, or to
+// a temporary file of dir when set.
+type spool struct {
+	buf bytes.Buffer
+	f   *os.File
+	bw  *bufio.Writer
+}
+
+func newSpool(dir string) (*spool, error) {
+	if dir == "" {
+		return &spool{}, nil
+	}
+	f, err := os.CreateTemp(dir, "page-")
+	if err != nil {
+		return nil, err
+	}
+	return &spool{f: f, bw: bufio.NewWriter(f)}, nil
+}
+
+func (s *spool) Write(p []byte) (int, error) {
+	if s.f == nil {
+		return s.buf.Write(p)
+	}
+	return s.bw.Write(p)
+}
+
+// file returns the file of the tar of the content written, read until
+// close is called.
+func (s *spool) file(name string) (*tarball.File, error) {
+	if s.f == nil {
+		return tarball.NewBufferFile(name, &s.buf), nil
+	}
+	if err := s.bw.Flush(); err != nil {
+		return nil, err
+	}
+	size, err := s.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return tarball.NewSizedReaderFile(name, s.f, size), nil
+}
+
+func (s *spool) close() {
+	if s.f != nil {
+		s.f.Close()
+		os.Remove(s.f.Name())
+	}
+}
@@ -0,0 +1,77 @@
+package indexer
+
+import (
+	"bytes"
+	"path/filepath"
+
+	"github.com/r0qs/beezim/internal/tarball"
+)
+
+// SkippedSize is the Skipped metadata of the entries left out for being
+// larger than MaxArticleSize.
+const SkippedSize = "size"
+
+// tooLarge reports whether the article is larger than MaxArticleSize.
+func (idx *SwarmZimIndexer) tooLarge(a Article) bool {
+	return idx.MaxArticleSize > 0 && a.Size() > idx.MaxArticleSize
+}
+
+// skipTooLarge records the article of the entry as skipped for its size
+// and releases it.
+func (idx *SwarmZimIndexer) skipTooLarge(i uint32, entry ZimEntry, a Article) {
+	size := a.Size()
+	a.Release()
+	idx.logger().Info("skipping article larger than the maximum size", "article", entry.FullURL(), "mime", a.mime, "size", size, "max", idx.MaxArticleSize)
+	idx.addParsedEntry(i, IndexEntry{
+		Path: entry.FullURL(),
+		Metadata: IndexMetadata{
+			Title:    entry.Title(),
+			MimeType: a.mime,
+			Skipped:  SkippedSize,
+			Size:     size,
+		},
+		Size: size,
+	})
+}
+
+// TooLarge returns the entries left out of the parses of the indexer for
+// being larger than MaxArticleSize, by path.
+func (idx *SwarmZimIndexer) TooLarge() []IndexEntry {
+	var entries []IndexEntry
+	idx.ForEachEntry(func(e IndexEntry) bool {
+		if e.Metadata.Skipped == SkippedSize {
+			entries = append(entries, e)
+		}
+		return true
+	})
+	return entries
+}
+
+// MakeSkippedPage appends a page listing the entries left out for their
+// size to the tar, when there are some.
+func (idx *SwarmZimIndexer) MakeSkippedPage(tarFile string) error {
+	return idx.writeSkippedPage(AppendTo(tarFile))
+}
+
+func (idx *SwarmZimIndexer) writeSkippedPage(w FileWriter) error {
+	entries := idx.TooLarge()
+	if len(entries) == 0 {
+		return nil
+	}
+	t, err := idx.pageTheme()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	data := map[string]interface{}{
+		"File":    filepath.Base(idx.ZimPath),
+		"Max":     idx.MaxArticleSize,
+		"Entries": entries,
+	}
+	if err := t.execute(&buf, "skipped.html", data); err != nil {
+		return err
+	}
+	idx.logger().Info("appending page", "page", "skipped.html", "tar", w.Name())
+	return w.WriteFile(tarball.NewBufferFile("skipped.html", &buf))
+}
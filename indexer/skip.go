@@ -0,0 +1,53 @@
+package indexer
+
+import (
+	"fmt"
+)
+
+// EntryError is an entry of the ZIM that could not be read, e.g. whose
+// data or redirect target is corrupt, which is skipped.
+type EntryError struct {
+	// Index is the url index of the entry.
+	Index uint32
+	// URL is the url of the entry, empty when the entry itself could not
+	// be read.
+	URL string
+	Err error
+}
+
+func (e EntryError) Error() string {
+	if e.URL == "" {
+		return fmt.Sprintf("entry %d: %v", e.Index, e.Err)
+	}
+	return fmt.Sprintf("entry %d (%s): %v", e.Index, e.URL, e.Err)
+}
+
+func (e EntryError) Unwrap() error {
+	return e.Err
+}
+
+// Skipped returns the entries that could not be read in the parses of
+// the indexer, besides those of Panics.
+func (idx *SwarmZimIndexer) Skipped() []EntryError {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return append([]EntryError(nil), idx.skipped...)
+}
+
+// skipEntry records the entry that could not be read, returning the
+// error of OnEntryError when the parse must stop.
+func (idx *SwarmZimIndexer) skipEntry(e EntryError) error {
+	idx.logger().Warn("skipping entry", "index", e.Index, "article", e.URL, "err", e.Err)
+	idx.mu.Lock()
+	idx.skipped = append(idx.skipped, e)
+	idx.mu.Unlock()
+	if idx.OnEntryError == nil {
+		return nil
+	}
+	idx.onEntryErrorMu.Lock()
+	defer idx.onEntryErrorMu.Unlock()
+	if err := idx.OnEntryError(e); err != nil {
+		return fmt.Errorf("%s: %w", idx.ZimPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package indexer
+
+import (
+	"bytes"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// snippetLen is the number of characters of the snippets of the articles,
+// see Snippets.
+const snippetLen = 200
+
+// snippetHidden are the elements whose content is left out of the
+// snippets: not visible, or the title of the article the search result
+// already shows.
+var snippetHidden = map[string]bool{
+	"head": true, "title": true, "script": true, "style": true, "noscript": true,
+	"template": true, "svg": true, "math": true, "h1": true,
+}
+
+// snippetInline are the elements which do not separate the words of the
+// text around them.
+var snippetInline = map[string]bool{
+	"a": true, "abbr": true, "b": true, "bdi": true, "bdo": true, "cite": true,
+	"code": true, "data": true, "dfn": true, "em": true, "i": true, "kbd": true,
+	"mark": true, "q": true, "s": true, "samp": true, "small": true, "span": true,
+	"strong": true, "sub": true, "sup": true, "time": true, "u": true, "var": true,
+}
+
+// htmlSnippet returns the first snippetLen characters of the visible text
+// of the HTML page, without its tags and with its spaces collapsed, cut
+// at a word followed by "…" when the text is longer. It reads the page
+// only up to them.
+func htmlSnippet(data []byte) string {
+	var b strings.Builder
+	n, space, more := 0, false, false
+	// hidden is the element whose content is left out
+	hidden := ""
+	for i := 0; i < len(data); {
+		if data[i] == '<' {
+			if bytes.HasPrefix(data[i:], []byte("<!--")) {
+				end := bytes.Index(data[i+4:], []byte("-->"))
+				if end < 0 {
+					break
+				}
+				i += 4 + end + 3
+				continue
+			}
+			if hidden == "script" || hidden == "style" {
+				// their content is not HTML, only their end tag ends it
+				end := closingTag(data[i:], hidden)
+				if end < 0 {
+					break
+				}
+				i += end
+				hidden = ""
+				continue
+			}
+			end := bytes.IndexByte(data[i:], '>')
+			if end < 0 {
+				break
+			}
+			tag := data[i+1 : i+end]
+			i += end + 1
+			name, closing := tagName(tag)
+			switch {
+			case hidden != "":
+				if closing && name == hidden {
+					hidden = ""
+				}
+			case !closing && snippetHidden[name] && !bytes.HasSuffix(tag, []byte("/")):
+				hidden = name
+			case !snippetInline[name]:
+				space = true
+			}
+			continue
+		}
+		r, size := utf8.DecodeRune(data[i:])
+		text := string(r)
+		if r == '&' {
+			if end := bytes.IndexByte(data[i:min(len(data), i+32)], ';'); end > 0 {
+				if s := html.UnescapeString(string(data[i : i+end+1])); s != string(data[i:i+end+1]) {
+					text, size = s, end+1
+				}
+			}
+		}
+		i += size
+		if hidden != "" {
+			continue
+		}
+		for _, r := range text {
+			if unicode.IsSpace(r) {
+				space = true
+				continue
+			}
+			if n >= snippetLen {
+				more = true
+				break
+			}
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+				n++
+			}
+			space = false
+			b.WriteRune(r)
+			n++
+		}
+		if more {
+			break
+		}
+	}
+	s := b.String()
+	if more {
+		if cut := strings.LastIndexByte(s, ' '); cut > 0 {
+			s = s[:cut]
+		}
+		s += "…"
+	}
+	return s
+}
+
+// tagName returns the lowercase name of the tag, between its < and >, and
+// whether it is an end tag. It is empty for the doctype and the others.
+func tagName(tag []byte) (string, bool) {
+	closing := bytes.HasPrefix(tag, []byte("/"))
+	if closing {
+		tag = tag[1:]
+	}
+	end := bytes.IndexFunc(tag, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '/'
+	})
+	if end >= 0 {
+		tag = tag[:end]
+	}
+	if len(tag) == 0 || !isLetter(tag[0]) {
+		return "", closing
+	}
+	return strings.ToLower(string(tag)), closing
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// closingTag returns the index after the end tag of the element in data,
+// -1 without one.
+func closingTag(data []byte, name string) int {
+	for i := 0; ; {
+		start := bytes.Index(data[i:], []byte("</"))
+		if start < 0 {
+			return -1
+		}
+		i += start + 2
+		if len(data) >= i+len(name) && strings.EqualFold(string(data[i:i+len(name)]), name) {
+			end := bytes.IndexByte(data[i:], '>')
+			if end < 0 {
+				return -1
+			}
+			return i + end + 1
+		}
+	}
+}
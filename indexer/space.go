@@ -0,0 +1,24 @@
+package indexer
+
+// SpaceCheckInterval is the number of bytes written between the calls of
+// SpaceCheck.
+const SpaceCheckInterval = 64 << 20
+
+// spaceChecker calls check every SpaceCheckInterval bytes written.
+type spaceChecker struct {
+	check   func() error
+	written int64
+}
+
+// add records n bytes written and runs the check when due.
+func (s *spaceChecker) add(n int) error {
+	if s.check == nil {
+		return nil
+	}
+	s.written += int64(n)
+	if s.written < SpaceCheckInterval {
+		return nil
+	}
+	s.written = 0
+	return s.check()
+}
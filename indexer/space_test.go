@@ -0,0 +1,89 @@
+package indexer_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/indexer/zimtest"
+	"github.com/r0qs/beezim/internal/diskspace"
+	"github.com/r0qs/beezim/internal/workdir"
+)
+
+// TestSpaceGuardMidRun writes the tar of 48 articles of 2 MiB to a workdir
+// whose free space drops under the low-water mark at the first check, at
+// SpaceCheckInterval bytes, then resumes the tar from its checkpoint once
+// space is freed.
+func TestSpaceGuardMidRun(t *testing.T) {
+	const n, size, lowWater = 48, 2 << 20, 256 << 20
+	entries := make([]zimtest.Entry, n)
+	for i := range entries {
+		url := fmt.Sprintf("Article%d", i)
+		entries[i] = zimtest.Entry{Namespace: 'A', URL: url, Title: url, Mime: "text/html", Content: payload(i, size)}
+	}
+
+	var avail atomic.Uint64
+	var checks atomic.Int32
+	work, err := workdir.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	work.Available = func(string) (uint64, error) {
+		checks.Add(1)
+		return avail.Load(), nil
+	}
+	tarFile := work.TarPath("test.zim")
+	ctx := context.Background()
+
+	avail.Store(lowWater - 1)
+	idx := newIndexer(t, zimtest.New(entries...))
+	idx.CheckpointEvery = 4
+	idx.SpaceCheck = work.SpaceGuard(lowWater)
+	err = idx.TarZim(ctx, tarFile, idx.ParseZIM(ctx))
+	if !errors.Is(err, diskspace.ErrNoSpace) {
+		t.Fatalf("got %v, want %v", err, diskspace.ErrNoSpace)
+	}
+	if checks.Load() != 1 {
+		t.Errorf("free space checked %d times, want once", checks.Load())
+	}
+	info, err := os.Stat(tarFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() < indexer.SpaceCheckInterval || info.Size() > indexer.SpaceCheckInterval+2*size {
+		t.Errorf("tar of %d bytes, want it stopped at the check after %d bytes", info.Size(), indexer.SpaceCheckInterval)
+	}
+	if _, err := os.Stat(indexer.CheckpointFile(tarFile)); err != nil {
+		t.Fatalf("checkpoint of the tar: %v", err)
+	}
+
+	// the space freed, the tar is resumed from its checkpoint
+	avail.Store(1 << 40)
+	idx = newIndexer(t, zimtest.New(entries...))
+	idx.CheckpointEvery = 4
+	idx.SpaceCheck = work.SpaceGuard(lowWater)
+	resumed, err := idx.Resume(tarFile)
+	if err != nil || !resumed {
+		t.Fatalf("resumed %t: %v", resumed, err)
+	}
+	if err := idx.TarZim(ctx, tarFile, idx.ParseZIM(ctx)); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.ParseErr(); err != nil {
+		t.Fatal(err)
+	}
+	files := readTar(t, tarFile)
+	for i, e := range entries {
+		if !bytes.Equal(files["A/"+e.URL], e.Content) {
+			t.Fatalf("article %d: %d bytes in the resumed tar, want %d", i, len(files["A/"+e.URL]), len(e.Content))
+		}
+	}
+	if _, err := os.Stat(indexer.CheckpointFile(tarFile)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("checkpoint left after the tar was ended: %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/r0qs/beezim/internal/tarball"
+)
+
+// FileWriter receives the pages and files added to a tar after its
+// articles, see WritePages.
+type FileWriter interface {
+	WriteFile(f *tarball.File) error
+	// Name is the name of the tar, for the logs.
+	Name() string
+}
+
+// AppendTo returns the FileWriter appending the files to the tar file.
+func AppendTo(tarFile string) FileWriter {
+	return tarAppender(tarFile)
+}
+
+type tarAppender string
+
+func (a tarAppender) WriteFile(f *tarball.File) error {
+	return tarball.AppendTarFile(string(a), f)
+}
+
+func (a tarAppender) Name() string {
+	return filepath.Base(string(a))
+}
+
+// WritePages writes the files a tar gets after the articles of the parse:
+// the provenance and metadata files, the EntriesFile, the DiffFile with
+// Previous, the sitemap with Sitemap, the index page,
+// with the search pages and assets when the search is enabled, the
+// stylesheet of SetTheme, the NavBarStylesheet with NavBar, the
+// FaviconFile, the page of the articles left
+// out for their size, the files of AddExtraFiles and the error page.
+func (idx *SwarmZimIndexer) WritePages(w FileWriter) error {
+	if err := idx.checkExtraFiles(); err != nil {
+		return err
+	}
+	pages := newPageNames(w)
+	w = pages
+	if err := idx.writeProvenanceFile(w); err != nil {
+		return fmt.Errorf("Failed to copy %s to tar file: %w", ProvenanceFile, err)
+	}
+	if err := idx.writeMetadataFile(w); err != nil {
+		return fmt.Errorf("Failed to copy %s to tar file: %w", MetadataFile, err)
+	}
+	if err := idx.writeEntriesManifest(w); err != nil {
+		return fmt.Errorf("Failed to copy %s to tar file: %w", EntriesFile, err)
+	}
+	if err := idx.writeDiffFile(w); err != nil {
+		return fmt.Errorf("Failed to copy %s to tar file: %w", DiffFile, err)
+	}
+	if idx.Sitemap {
+		if err := idx.writeSitemap(w, idx.SitemapURL); err != nil {
+			return fmt.Errorf("Failed to copy %s to tar file: %w", SitemapFile, err)
+		}
+	}
+
+	if idx.enableSearch {
+		// index page with search tool
+		if err := idx.writeIndexPage(w, IndexSearch); err != nil {
+			return fmt.Errorf("Failed to copy index.html page to tar file: %w", err)
+		}
+
+		idx.logger().Info("appending assets", "tar", w.Name())
+		if err := idx.writeAssets(w); err != nil {
+			return fmt.Errorf("Failed to copy assets directory to tar file: %w", err)
+		}
+	}
+	if err := idx.writeStylesheet(w, idx.enableSearch); err != nil {
+		return fmt.Errorf("Failed to copy stylesheet to tar file: %w", err)
+	}
+	if err := idx.writeNavBarStylesheet(w, idx.enableSearch); err != nil {
+		return fmt.Errorf("Failed to copy %s to tar file: %w", NavBarStylesheet, err)
+	}
+	if err := idx.writeFavicon(w); err != nil {
+		return fmt.Errorf("Failed to copy %s to tar file: %w", FaviconFile, err)
+	}
+	if !idx.enableSearch {
+		// redirected index page
+		if err := idx.writeIndexPage(w, IndexRedirect); err != nil {
+			return fmt.Errorf("Failed to copy index.html page to tar file: %w", err)
+		}
+	}
+
+	if err := idx.writeSkippedPage(w); err != nil {
+		return fmt.Errorf("Failed to copy skipped.html page to tar file: %w", err)
+	}
+	// error.html is the last file of the tar, and no extra file can take
+	// its place
+	pages.names["error.html"] = true
+	if err := idx.writeExtraFiles(w, pages); err != nil {
+		return fmt.Errorf("Failed to copy extra files to tar file: %w", err)
+	}
+	if err := idx.writeErrorPage(w); err != nil {
+		return fmt.Errorf("Failed to copy error.html page to tar file: %w", err)
+	}
+	return nil
+}
+
+// Redirects returns the redirects left out of the last tar written with
+// ManifestRedirects, as listed in its RedirectsFile.
+func (idx *SwarmZimIndexer) Redirects() []Redirect {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.redirects
+}
+
+// TarStream returns the tar of the articles received, followed by the
+// pages of WritePages, as it is written, e.g. to upload it without
+// writing it to disk. It is gzipped with GzipLevel.
+func (idx *SwarmZimIndexer) TarStream(ctx context.Context, files <-chan Article) io.ReadCloser {
+	return idx.TarStreamBatches(ctx, "stream.tar", idx.batchesOf(ctx, files))
+}
+
+// TarStreamBatches returns the tar named name of the batches of articles
+// received, as TarStream. The tar is written as it is read, so that only
+// the batches parsed ahead are held in memory; the search index is still
+// written to SearchTarFile when set. Reading the stream fails with the
+// error of the parse or of the pages, and closing it before its end stops
+// the parse.
+func (idx *SwarmZimIndexer) TarStreamBatches(ctx context.Context, name string, batches <-chan []Article) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(idx.writeTarStream(ctx, name, pw, batches))
+	}()
+	return pr
+}
+
+// WriteTar writes the batches of articles received to tarFile, named as
+// TarZim does, followed by the pages of WritePages, at once. It is how a
+// tar gzipped with GzipLevel, which can not be appended to, gets them.
+func (idx *SwarmZimIndexer) WriteTar(ctx context.Context, tarFile string, batches <-chan []Article) (err error) {
+	defer func() {
+		if err != nil {
+			idx.abortParse(batches)
+		}
+	}()
+	s, err := idx.newTarSink(ctx, tarFile)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	return idx.writeSink(s, batches, true)
+}
+
+func (idx *SwarmZimIndexer) writeTarStream(ctx context.Context, name string, w io.Writer, batches <-chan []Article) (err error) {
+	defer func() {
+		if err != nil {
+			idx.abortParse(batches)
+		}
+	}()
+	if idx.CheckpointEvery > 0 {
+		return errCheckpointSink
+	}
+	// only the search tar is written to disk
+	var check func() error
+	if idx.SearchTarFile != "" {
+		check = idx.SpaceCheck
+	}
+	s, err := idx.newTarSinkTo(ctx, name, w, check)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	return idx.writeSink(s, batches, true)
+}
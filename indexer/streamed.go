@@ -0,0 +1,122 @@
+package indexer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/r0qs/beezim/internal/minify"
+
+	"github.com/klauspost/compress/s2"
+)
+
+// DefaultStreamSize is the StreamSize of the indexers that set none, the
+// largest of the pooled buffers.
+const DefaultStreamSize = 256 << 10
+
+// streamSize returns the size from which the articles are streamed, zero
+// when they never are.
+func (idx *SwarmZimIndexer) streamSize() int64 {
+	switch {
+	case idx.StreamSize < 0:
+		return 0
+	case idx.StreamSize == 0:
+		return DefaultStreamSize
+	}
+	return idx.StreamSize
+}
+
+// streamSection returns a reader of the payload of the entry in the file
+// of the ZIM when it is at least of streamSize, nil when it is smaller,
+// has to be decompressed or is a text of the type the parse rewrites.
+func (idx *SwarmZimIndexer) streamSection(entry ZimEntry, mimeType string) (*io.SectionReader, error) {
+	min := idx.streamSize()
+	e, ok := entry.(sectionEntry)
+	mimeType = baseMime(mimeType)
+	if min == 0 || !ok || compressible(mimeType) || minify.Minifies(mimeType) {
+		return nil, nil
+	}
+	r, err := e.DataSection()
+	if err != nil || r == nil || r.Size() < min {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Size returns the size of the payload.
+func (a Article) Size() int64 {
+	switch {
+	case a.src != nil:
+		return a.srcSize
+	case a.compressed:
+		n, err := s2.DecodedLen(a.data)
+		if err != nil {
+			return 0
+		}
+		return int64(n)
+	}
+	return int64(len(a.data))
+}
+
+// DataReader returns a reader of the payload, read from the ZIM as it is
+// for the articles streamed, see StreamSize.
+func (a Article) DataReader() io.Reader {
+	if a.src != nil {
+		return io.NewSectionReader(a.src, 0, a.srcSize)
+	}
+	return bytes.NewReader(a.Data())
+}
+
+// streamed reports whether the payload is read from the ZIM by the sinks.
+func (a Article) streamed() bool {
+	return a.src != nil
+}
+
+// hash sets the size and sum of the payload.
+func (a *Article) hash() error {
+	if a.src == nil {
+		a.size, a.sum, a.summed = int64(len(a.data)), sha256.Sum256(a.data), true
+		return nil
+	}
+	h := sha256.New()
+	if err := copyPayload(h, a); err != nil {
+		return fmt.Errorf("reading data: %w", err)
+	}
+	a.size, a.summed = a.srcSize, true
+	h.Sum(a.sum[:0])
+	return nil
+}
+
+// copyPayload writes the payload of the article, once decompressed, to
+// w, failing when the ZIM holds less of it than its size.
+func copyPayload(w io.Writer, a *Article) error {
+	if a.src == nil {
+		_, err := w.Write(a.data)
+		return err
+	}
+	n, err := io.Copy(w, a.DataReader())
+	if err == nil && n < a.srcSize {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// copyStreamed writes the payload of the streamed article to f in writes
+// of at most size bytes.
+func copyStreamed(f *os.File, a *Article, size int) error {
+	buf := getBuffer(size)
+	defer putBuffer(buf)
+	n, err := io.CopyBuffer(onlyWriter{f}, a.DataReader(), fill(buf, size))
+	if err == nil && n < a.srcSize {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// onlyWriter hides the ReadFrom of a file, so that io.CopyBuffer uses the
+// buffer.
+type onlyWriter struct {
+	io.Writer
+}
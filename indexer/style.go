@@ -0,0 +1,100 @@
+package indexer
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/r0qs/beezim/internal/tarball"
+)
+
+// The stylesheets of the pages embedded in the assets, see SetTheme.
+const (
+	ThemeLight = "light"
+	ThemeDark  = "dark"
+	ThemeSepia = "sepia"
+)
+
+// themesDir is the directory of the tars of the stylesheets of SetTheme,
+// customTheme the name there of the stylesheet of a file.
+const (
+	themesDir   = AssetsPrefix + "/css/themes"
+	customTheme = "custom.css"
+)
+
+// style is the stylesheet of SetTheme: the name in the tars of an embedded
+// one, or of the content of a file.
+type style struct {
+	name string
+	css  []byte
+}
+
+// SetTheme links the pages of the tars, the search, error and redirect
+// pages, to the stylesheet of the theme: ThemeLight, ThemeDark or
+// ThemeSepia, or else the path of a CSS file, read right away and packed
+// into the tars, "" to link none. The stylesheet is appended to the tars
+// even without the assets of the search pages.
+func (idx *SwarmZimIndexer) SetTheme(theme string) error {
+	switch {
+	case theme == "":
+		idx.style = nil
+	case slices.Contains([]string{ThemeLight, ThemeDark, ThemeSepia}, theme):
+		idx.style = &style{name: themesDir + "/" + theme + ".css"}
+	default:
+		css, err := os.ReadFile(theme)
+		if err != nil {
+			return fmt.Errorf("theme %q is not %s, %s, %s nor a CSS file: %w", theme, ThemeLight, ThemeDark, ThemeSepia, err)
+		}
+		idx.style = &style{name: themesDir + "/" + customTheme, css: css}
+	}
+	return nil
+}
+
+// pageData adds to the data of the template of the page at name its
+// locale, see pageLocale, the link to the FaviconFile as Favicon and the
+// one to the stylesheet of SetTheme as Stylesheet, if any.
+func (idx *SwarmZimIndexer) pageData(name string, data map[string]interface{}) map[string]interface{} {
+	idx.pageLocale().data(data)
+	data["Favicon"] = relativeLink(name, FaviconFile)
+	if idx.style != nil {
+		data["Stylesheet"] = relativeLink(name, idx.style.name)
+	}
+	if idx.HistoryPage {
+		data["History"] = relativeLink(name, HistoryFile)
+	}
+	return data
+}
+
+// stylesheet returns the name and content of the stylesheet of SetTheme
+// appended to the tars besides the assets, withAssets telling whether
+// they are, "" when there is none.
+func (idx *SwarmZimIndexer) stylesheet(withAssets bool) (string, []byte, error) {
+	if idx.style == nil || withAssets && idx.style.css == nil {
+		return "", nil, nil
+	}
+	if idx.style.css != nil {
+		return idx.style.name, idx.style.css, nil
+	}
+	t, err := idx.pageTheme()
+	if err != nil {
+		return "", nil, err
+	}
+	css, err := fs.ReadFile(t.assets, strings.TrimPrefix(idx.style.name, AssetsPrefix+"/"))
+	if err != nil {
+		return "", nil, err
+	}
+	return idx.style.name, css, nil
+}
+
+// writeStylesheet appends the stylesheet of SetTheme to the tar, see
+// stylesheet.
+func (idx *SwarmZimIndexer) writeStylesheet(w FileWriter, withAssets bool) error {
+	name, css, err := idx.stylesheet(withAssets)
+	if err != nil || name == "" {
+		return err
+	}
+	idx.logger().Info("appending file", "file", name, "tar", w.Name())
+	return w.WriteFile(tarball.NewBytesFile(name, css))
+}
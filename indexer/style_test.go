@@ -0,0 +1,94 @@
+package indexer_test
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// stylesheetLink matches the links to stylesheets of the pages.
+var stylesheetLink = regexp.MustCompile(`<link href="([^"]+)" rel="stylesheet">`)
+
+// stylesheets returns the files of the tar the page links as stylesheets.
+func stylesheets(name string, page []byte) []string {
+	var files []string
+	for _, m := range stylesheetLink.FindAllSubmatch(page, -1) {
+		files = append(files, path.Join(path.Dir(name), string(m[1])))
+	}
+	return files
+}
+
+func TestSetTheme(t *testing.T) {
+	custom := filepath.Join(t.TempDir(), "wiki.css")
+	customCSS := []byte("body { background: #fdf6e3; }\n")
+	if err := os.WriteFile(custom, customCSS, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		theme, want string
+	}{
+		{"", ""},
+		{indexer.ThemeLight, indexer.AssetsPrefix + "/css/themes/light.css"},
+		{indexer.ThemeDark, indexer.AssetsPrefix + "/css/themes/dark.css"},
+		{indexer.ThemeSepia, indexer.AssetsPrefix + "/css/themes/sepia.css"},
+		{custom, indexer.AssetsPrefix + "/css/themes/custom.css"},
+	} {
+		for _, search := range []bool{true, false} {
+			idx := indexer.NewWithReader("test.zim", groupsZim(), search)
+			idx.Logger = logging.Discard()
+			defer idx.Close()
+			if err := idx.SetTheme(tt.theme); err != nil {
+				t.Fatal(err)
+			}
+			tarFile := tarZim(t, idx)
+			if err := idx.WritePages(indexer.AppendTo(tarFile)); err != nil {
+				t.Fatal(err)
+			}
+			files := readTar(t, tarFile)
+
+			// the index, search and error pages, and the redirect pages
+			pages := []string{"index.html", "error.html", "A/Home"}
+			if search {
+				pages = append(pages, "searchresult.html", "about.html")
+			}
+			for _, name := range pages {
+				page, ok := files[name]
+				if !ok {
+					t.Fatalf("theme %q, search %t: no %s in the tar", tt.theme, search, name)
+				}
+				linked := stylesheets(name, page)
+				if tt.want == "" {
+					if slices.ContainsFunc(linked, func(f string) bool { return path.Dir(f) == indexer.AssetsPrefix+"/css/themes" }) {
+						t.Errorf("theme %q, search %t: %s links the stylesheets %v", tt.theme, search, name, linked)
+					}
+					continue
+				}
+				if !slices.Contains(linked, tt.want) {
+					t.Errorf("theme %q, search %t: %s links the stylesheets %v, want %s", tt.theme, search, name, linked, tt.want)
+				}
+			}
+			if tt.want != "" && files[tt.want] == nil {
+				t.Errorf("theme %q, search %t: stylesheet %s not in the tar", tt.theme, search, tt.want)
+			}
+			if tt.theme == custom && !bytes.Equal(files[tt.want], customCSS) {
+				t.Errorf("custom theme: got %q in the tar, want the file %q", files[tt.want], customCSS)
+			}
+		}
+	}
+}
+
+func TestSetThemeMissing(t *testing.T) {
+	idx := newIndexer(t, groupsZim())
+	if err := idx.SetTheme("solarized"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v, want the theme neither embedded nor a file", err)
+	}
+}
@@ -0,0 +1,236 @@
+package indexer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// theme is the templates and assets of the pages of the tars: the
+// embedded ones, or those of the directories of WithTemplateDir and
+// WithAssetsDir, which they fall back to for the files missing there.
+type theme struct {
+	templates fs.FS
+	assets    fs.FS
+	// base is the templates of templates/page, and pages those of
+	// templates by name, each parsed with its "content" template.
+	base  *template.Template
+	pages map[string]*template.Template
+}
+
+// WithTemplateDir reads the templates of the pages from dir instead of the
+// embedded ones, those missing there included, e.g. dir/page/header.html
+// for templates/page/header.html. New fails when one does not parse.
+func WithTemplateDir(dir string) Option {
+	return func(o *options) {
+		o.templateDir = dir
+	}
+}
+
+// WithAssetsDir packs the assets of the DApp from dir instead of the
+// embedded ones, those missing there included, e.g. dir/css/beezim.css
+// for assets/css/beezim.css.
+func WithAssetsDir(dir string) Option {
+	return func(o *options) {
+		o.assetsDir = dir
+	}
+}
+
+// withTheme sets the theme New parsed for NewWithReader.
+func withTheme(t *theme) Option {
+	return func(o *options) {
+		o.theme = t
+	}
+}
+
+// defaultTheme is the theme of the embedded templates and assets.
+var defaultTheme = sync.OnceValues(func() (*theme, error) {
+	return parseTheme(mustSub(templateFS, "templates"), mustSub(assetsFS, "assets"))
+})
+
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// newTheme returns the theme of the options, the default one without
+// directories.
+func (o *options) newTheme() (*theme, error) {
+	if o.theme != nil || o.templateDir == "" && o.assetsDir == "" {
+		return o.theme, nil
+	}
+	def, err := defaultTheme()
+	if err != nil {
+		return nil, err
+	}
+	t := *def
+	if o.templateDir != "" {
+		templates, err := overlay(o.templateDir, def.templates)
+		if err != nil {
+			return nil, fmt.Errorf("template directory: %w", err)
+		}
+		parsed, err := parseTheme(templates, def.assets)
+		if err != nil {
+			return nil, fmt.Errorf("template directory %s: %w", o.templateDir, err)
+		}
+		t = *parsed
+	}
+	if o.assetsDir != "" {
+		if t.assets, err = overlay(o.assetsDir, def.assets); err != nil {
+			return nil, fmt.Errorf("assets directory: %w", err)
+		}
+	}
+	return &t, nil
+}
+
+// parseTheme parses the templates of the pages.
+func parseTheme(templates, assets fs.FS) (*theme, error) {
+	t := &theme{templates: templates, assets: assets, pages: make(map[string]*template.Template)}
+	var err error
+	if t.base, err = template.ParseFS(templates, "page/*.html"); err != nil {
+		return nil, fmt.Errorf("error parsing base templates: %w", err)
+	}
+	names, err := fs.Glob(templates, "*.html")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if t.pages[name], err = template.New("content").ParseFS(templates, name); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// page returns the template of the name, e.g. "listing.html".
+func (t *theme) page(name string) (*template.Template, error) {
+	tmpl, ok := t.pages[name]
+	if !ok {
+		return nil, fmt.Errorf("template %s not found", name)
+	}
+	return tmpl, nil
+}
+
+// execute writes the page of the template of the name to w.
+func (t *theme) execute(w io.Writer, name string, data interface{}) error {
+	tmpl, err := t.page(name)
+	if err != nil {
+		return err
+	}
+	return tmpl.ExecuteTemplate(w, name, data)
+}
+
+// executePage writes the page of templates/page with the content of the
+// content template, the empty content when "", to w.
+func (t *theme) executePage(w io.Writer, contentTmpl string, data interface{}) error {
+	baseTmpl, err := t.base.Clone()
+	if err != nil {
+		return err
+	}
+
+	// add dynamic content to pages
+	// FIXME: current we only support replace the content. Maybe we can improve that in the future do to something like Hugo does, or use Hugo instead.
+	if contentTmpl != "" {
+		tmpl, err := t.page(contentTmpl)
+		if err != nil {
+			return err
+		}
+
+		// don't attempt to add in the tree if their is nothing to be added
+		if tmpl.Tree != nil {
+			_, err = baseTmpl.AddParseTree("content", tmpl.Tree)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return baseTmpl.ExecuteTemplate(w, "page", data)
+}
+
+// writeRedirectPage writes a page redirecting to pagePath, with the data of
+// the page, see pageData.
+func (t *theme) writeRedirectPage(buf *bytes.Buffer, pagePath string, data map[string]interface{}) error {
+	data["Path"] = pagePath
+	return t.execute(buf, "index-redirect.html", data)
+}
+
+// pageTheme returns the theme of the pages of the indexer, failing with the
+// error of its directories, see ThemeErr.
+func (idx *SwarmZimIndexer) pageTheme() (*theme, error) {
+	if idx.themeErr != nil {
+		return nil, idx.themeErr
+	}
+	if idx.theme != nil {
+		return idx.theme, nil
+	}
+	return defaultTheme()
+}
+
+// ThemeErr returns why the directories of WithTemplateDir or
+// WithAssetsDir given to NewWithReader or NewFromReader could not be
+// read, which the pages then fail with, or nil. New fails with it right
+// away.
+func (idx *SwarmZimIndexer) ThemeErr() error {
+	return idx.themeErr
+}
+
+// overlay returns the files of dir, then those of base missing there.
+func overlay(dir string, base fs.FS) (fs.FS, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s: not a directory", dir)
+	}
+	return overlayFS{os.DirFS(dir), base}, nil
+}
+
+// overlayFS reads the files of top, and those of base it does not have.
+type overlayFS struct {
+	top, base fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.top.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.base.Open(name)
+	}
+	return f, err
+}
+
+// ReadDir lists the files of the directory in both, by name.
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	top, err := fs.ReadDir(o.top, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	base, baseErr := fs.ReadDir(o.base, name)
+	if baseErr != nil && !errors.Is(baseErr, fs.ErrNotExist) {
+		return nil, baseErr
+	}
+	if err != nil && baseErr != nil {
+		return nil, err
+	}
+	entries := slices.Clone(top)
+	for _, e := range base {
+		if !slices.ContainsFunc(top, func(t fs.DirEntry) bool { return t.Name() == e.Name() }) {
+			entries = append(entries, e)
+		}
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries, nil
+}
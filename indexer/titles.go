@@ -0,0 +1,94 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TitlesFile is the name in the tars of the titles of their articles, see
+// MakeTitlesFile.
+const TitlesFile = "_beezim/titles.json"
+
+// isTitleIndex reports whether the entry is a title index of the ZIM, its
+// Xapian database or its title listings, left out with DropTitleIndex.
+func isTitleIndex(name string) bool {
+	return strings.HasPrefix(name, "X/title/") || strings.HasPrefix(name, "X/listing/titleOrdered/")
+}
+
+// suggested reports whether the entry is suggested by the TitlesFile: the
+// HTML articles found in the tar and the redirects of the articles
+// namespaces.
+func suggested(e IndexEntry) bool {
+	m := e.Metadata
+	if m.Skipped != "" || m.Exception != "" {
+		return false
+	}
+	if m.Redirect {
+		return strings.HasPrefix(e.Path, "A/") || strings.HasPrefix(e.Path, "C/")
+	}
+	return baseMime(m.MimeType) == "text/html"
+}
+
+// MakeTitlesFile appends to the tar the titles of its articles as
+// TitlesFile, for the suggestions of a search page: a JSON array of the
+// title and path of each, in the title order of the ZIM read from its
+// title pointer list.
+func (idx *SwarmZimIndexer) MakeTitlesFile(tarFile string) error {
+	return idx.writeTitlesFile(AppendTo(tarFile))
+}
+
+func (idx *SwarmZimIndexer) writeTitlesFile(w FileWriter) error {
+	return idx.writeEntriesPage(TitlesFile, w, idx.encodeTitles)
+}
+
+// encodeTitles writes the TitlesFile one title at a time. The entries the
+// reader can not read were skipped by the parse and are left out, as
+// those left out by Collisions for another at the same path.
+func (idx *SwarmZimIndexer) encodeTitles(w io.Writer) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: reading the titles: zim reader panicked: %v", idx.ZimPath, r)
+		}
+	}()
+	sep := "["
+	idx.Z.Iterate(func(i uint32) {
+		if err != nil {
+			return
+		}
+		entry, entryErr := idx.Z.EntryAt(i)
+		if entryErr != nil || entry.IsDeleted() {
+			return
+		}
+		p := entry.FullURL()
+		idx.mu.Lock()
+		dropped := idx.dropped[i]
+		e, ok, getErr := idx.entries.Get(p)
+		idx.mu.Unlock()
+		if err = getErr; err != nil || !ok || dropped || !suggested(e) {
+			return
+		}
+		title := entry.Title()
+		if title == "" {
+			title = listingTitle(e)
+		}
+		var data []byte
+		if data, err = json.Marshal([2]string{title, p}); err != nil {
+			return
+		}
+		if _, err = io.WriteString(w, sep); err == nil {
+			_, err = w.Write(data)
+		}
+		sep = ","
+	})
+	if err != nil {
+		return err
+	}
+	if sep == "[" {
+		_, err = io.WriteString(w, "[]\n")
+	} else {
+		_, err = io.WriteString(w, "]\n")
+	}
+	return err
+}
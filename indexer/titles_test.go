@@ -0,0 +1,46 @@
+package indexer_test
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/indexer/zimtest"
+)
+
+func TestTitlesFileCollisions(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		collisions indexer.Collisions
+		want       [][2]string
+	}{
+		{"keep-first", indexer.CollisionsKeepFirst, [][2]string{{"x1", "A/x"}, {"y", "A/y"}}},
+		{"keep-last", indexer.CollisionsKeepLast, [][2]string{{"x2", "A/x"}, {"y", "A/y"}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := zimtest.New(
+				zimtest.Entry{Namespace: 'A', URL: "x", Title: "x1", Mime: "text/html", Content: []byte("<p>1</p>")},
+				zimtest.Entry{Namespace: 'A', URL: "x", Title: "x2", Mime: "text/html", Content: []byte("<p>2</p>")},
+				zimtest.Entry{Namespace: 'A', URL: "y", Title: "y", Mime: "text/html", Content: []byte("<p>y</p>")},
+			)
+			idx := newIndexer(t, r)
+			idx.Collisions = tt.collisions
+			tarFile := tarZim(t, idx)
+			if err := idx.MakeTitlesFile(tarFile); err != nil {
+				t.Fatal(err)
+			}
+			if got := idx.PathCollisions(); !slices.Equal(got, []string{"A/x"}) {
+				t.Errorf("got collisions %v, want [A/x]", got)
+			}
+
+			var titles [][2]string
+			if err := json.Unmarshal(readTar(t, tarFile)[indexer.TitlesFile], &titles); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(titles, tt.want) {
+				t.Errorf("got titles %v, want %v", titles, tt.want)
+			}
+		})
+	}
+}
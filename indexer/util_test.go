@@ -0,0 +1,66 @@
+package indexer_test
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/indexer/zimtest"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// newIndexer returns an indexer of the reader, logging to the test.
+func newIndexer(t *testing.T, r *zimtest.Reader, opts ...indexer.Option) *indexer.SwarmZimIndexer {
+	t.Helper()
+	idx := indexer.NewWithReader("test.zim", r, false, opts...)
+	idx.Logger = logging.Discard()
+	t.Cleanup(func() { idx.Close() })
+	return idx
+}
+
+// tarZim parses the ZIM of the indexer to a tar in a temporary directory
+// and returns its path.
+func tarZim(t *testing.T, idx *indexer.SwarmZimIndexer) string {
+	t.Helper()
+	ctx := context.Background()
+	tarFile := filepath.Join(t.TempDir(), "test.tar")
+	if err := idx.TarZim(ctx, tarFile, idx.ParseZIM(ctx)); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.ParseErr(); err != nil {
+		t.Fatal(err)
+	}
+	return tarFile
+}
+
+// readTar returns the files of the tar by name.
+func readTar(t *testing.T, tarFile string) map[string][]byte {
+	t.Helper()
+	f, err := os.Open(tarFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = data
+	}
+}
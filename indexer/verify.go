@@ -0,0 +1,108 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/r0qs/beezim/internal/progress"
+)
+
+// ErrChecksumMismatch is returned, with ErrZimCorrupt, by Verify for a
+// ZIM whose content does not match its checksum, e.g. a truncated
+// download.
+var ErrChecksumMismatch = errors.New("zim checksum mismatch")
+
+// Option configures New.
+type Option func(*options)
+
+type options struct {
+	verify   bool
+	progress ProgressReporter
+	entries  EntryStore
+	// templateDir and assetsDir are those of WithTemplateDir and
+	// WithAssetsDir, and theme their theme once parsed by New.
+	templateDir string
+	assetsDir   string
+	theme       *theme
+	// start is the url index of StartAt.
+	start *uint32
+}
+
+// WithVerify verifies the checksum of the ZIM before New opens it, see
+// VerifyChecksum.
+func WithVerify(verify bool) Option {
+	return func(o *options) {
+		o.verify = verify
+	}
+}
+
+// Verify verifies the checksum of the ZIM of the indexer, see
+// VerifyChecksum.
+func (idx *SwarmZimIndexer) Verify(ctx context.Context) error {
+	p := idx.Progress
+	if p == nil {
+		p = DefaultProgress(idx.Logger, filepath.Base(idx.ZimPath))
+	}
+	return verifyChecksum(ctx, idx.ZimPath, p)
+}
+
+// VerifyChecksum compares the MD5 checksum ending the ZIM at zimPath with
+// the one of the rest of the file, read as a stream. A ZIM of another
+// size than its header tells fails right away. The bytes read are
+// reported to the reporter of the context as the verify stage, or to
+// DefaultProgress without one.
+func VerifyChecksum(ctx context.Context, zimPath string) error {
+	return verifyChecksum(ctx, zimPath, nil)
+}
+
+// verifyChecksum is VerifyChecksum reporting to p without reporter in
+// the context, DefaultProgress when nil.
+func verifyChecksum(ctx context.Context, zimPath string, p ProgressReporter) error {
+	f, err := OpenZimFile(zimPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h, err := readZimHeader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w: %v", zimPath, ErrZimCorrupt, err)
+	}
+	if size := int64(h.ChecksumPos) + md5.Size; f.Size() != size {
+		return fmt.Errorf("%s: %w: %w: %d bytes instead of %d", zimPath, ErrZimCorrupt, ErrChecksumMismatch, f.Size(), size)
+	}
+
+	total := int64(h.ChecksumPos)
+	var r io.Reader = &ctxReader{ctx: ctx, r: io.NewSectionReader(f, 0, total)}
+	r = progress.NewReader(r, progressReporter(ctx, p, nil, zimPath), "verify", 0, total)
+
+	sum := md5.New()
+	if _, err := io.Copy(sum, r); err != nil {
+		return fmt.Errorf("%s: %w", zimPath, err)
+	}
+	want := make([]byte, md5.Size)
+	if _, err := f.ReadAt(want, total); err != nil {
+		return fmt.Errorf("%s: %w", zimPath, err)
+	}
+	if got := sum.Sum(nil); !bytes.Equal(got, want) {
+		return fmt.Errorf("%s: %w: %w: %x instead of %x", zimPath, ErrZimCorrupt, ErrChecksumMismatch, got, want)
+	}
+	return nil
+}
+
+// ctxReader stops reading once the context is canceled.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
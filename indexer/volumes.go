@@ -0,0 +1,308 @@
+package indexer
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/r0qs/beezim/internal/progress"
+	"github.com/r0qs/beezim/internal/tarball"
+)
+
+// VolumesFile is the file listing the volumes written by TarZimSplit, in
+// their directory.
+const VolumesFile = "volumes.json"
+
+// tarTrailerSize is the size of the end of a tar.
+const tarTrailerSize = 2 * 512
+
+// Volume is a tar of the volumes of a ZIM.
+type Volume struct {
+	// Name is the name of the tar in the directory of the volumes.
+	Name string `json:"name"`
+	// Size is the size in bytes of the tar, before it is gzipped.
+	Size int64 `json:"size"`
+	// Paths are the files of the tar.
+	Paths []string `json:"paths"`
+	// Reference is the root of the upload of the volume, once uploaded.
+	Reference string `json:"reference,omitempty"`
+}
+
+// Volumes is the content of VolumesFile.
+type Volumes struct {
+	// MaxVolumeSize is the size in bytes the volumes were split at.
+	MaxVolumeSize int64    `json:"maxVolumeSize"`
+	Volumes       []Volume `json:"volumes"`
+	// Root is the manifest serving the files of all the volumes, once
+	// they are uploaded and merged.
+	Root string `json:"root,omitempty"`
+}
+
+// VolumeName returns the name of the nth volume, from 0.
+func VolumeName(n int) string {
+	return fmt.Sprintf("volume-%03d.tar", n)
+}
+
+// ReadVolumes reads the VolumesFile of the directory of volumes.
+func ReadVolumes(dir string) (*Volumes, error) {
+	data, err := os.ReadFile(filepath.Join(dir, VolumesFile))
+	if err != nil {
+		return nil, err
+	}
+	var v Volumes
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("%s: %w", VolumesFile, err)
+	}
+	return &v, nil
+}
+
+// WriteVolumes writes the VolumesFile of the directory of volumes.
+func WriteVolumes(dir string, v *Volumes) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, VolumesFile), data, 0644)
+}
+
+// TarZimSplit writes the articles received to tars of dir named by
+// VolumeName, followed by the pages of WritePages, starting a new volume
+// rather than going over maxVolumeSize bytes, and lists them in
+// VolumesFile. An article is never split across volumes: one larger than
+// maxVolumeSize gets a volume of its own. With ManifestRedirects, the
+// redirects of all the volumes are listed in the RedirectsFile of the
+// volume ending the articles. The search index can not be written to its
+// own tar.
+func (idx *SwarmZimIndexer) TarZimSplit(ctx context.Context, dir string, maxVolumeSize int64, files <-chan Article) error {
+	return idx.TarZimSplitBatches(ctx, dir, maxVolumeSize, idx.batchesOf(ctx, files))
+}
+
+// TarZimSplitBatches writes the batches of articles received to volumes,
+// as TarZimSplit.
+func (idx *SwarmZimIndexer) TarZimSplitBatches(ctx context.Context, dir string, maxVolumeSize int64, batches <-chan []Article) (err error) {
+	defer func() {
+		if err != nil {
+			idx.abortParse(batches)
+		}
+	}()
+	if idx.SearchTarFile != "" {
+		return errors.New("the search index can not be split apart from volumes")
+	}
+	if idx.CheckpointEvery > 0 {
+		return errCheckpointSink
+	}
+	if maxVolumeSize <= 0 {
+		return fmt.Errorf("invalid volume size %d", maxVolumeSize)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	s := &splitSink{
+		idx:     idx,
+		ctx:     ctx,
+		dir:     dir,
+		volumes: &Volumes{MaxVolumeSize: maxVolumeSize},
+		e:       progress.Event{Stage: "tar"},
+	}
+	if idx.Fingerprint != nil {
+		s.base = headerSize(idx.Fingerprint.header())
+	}
+	defer s.close()
+
+	for batch := range batches {
+		for i := range batch {
+			if err := s.write(&batch[i]); err != nil {
+				releaseAll(batch[i+1:])
+				return err
+			}
+		}
+	}
+	if err := idx.ParseErr(); err != nil {
+		return err
+	}
+	if err := s.writeRedirects(); err != nil {
+		return err
+	}
+	if err := idx.WritePages(s); err != nil {
+		return err
+	}
+	return s.finish()
+}
+
+// splitSink writes articles to volumes, each a tarSink, see TarZimSplit.
+type splitSink struct {
+	idx *SwarmZimIndexer
+	ctx context.Context
+	dir string
+	// cur is the volume being written, of size bytes once ended.
+	cur  *tarSink
+	size int64
+	// base is the size of an empty volume, without its trailer.
+	base      int64
+	volumes   *Volumes
+	redirects []Redirect
+	// e is the progress of the volumes ended.
+	e progress.Event
+}
+
+// write writes the article to its volume and releases it.
+func (s *splitSink) write(file *Article) error {
+	if s.idx.ManifestRedirects && file.target != "" {
+		s.redirects = append(s.redirects, Redirect{Path: file.path, Target: file.target})
+		file.Release()
+		return nil
+	}
+	if err := file.decompress(); err != nil {
+		file.Release()
+		return fmt.Errorf("%s: %w", file.path, err)
+	}
+	if err := s.reserve(file.path, file.Size(), file.isDir); err != nil {
+		file.Release()
+		return err
+	}
+	return s.cur.write(file)
+}
+
+// WriteFile writes the file to its volume.
+func (s *splitSink) WriteFile(f *tarball.File) error {
+	if err := s.reserve(f.Name(), f.Size(), false); err != nil {
+		return err
+	}
+	return s.cur.WriteFile(f)
+}
+
+// Name returns the name of the directory of the volumes.
+func (s *splitSink) Name() string {
+	return filepath.Base(s.dir)
+}
+
+// reserve makes room for the entry name of size bytes, in a new volume
+// when it does not fit the current one, and lists it in its volume.
+func (s *splitSink) reserve(name string, size int64, isDir bool) error {
+	max := s.volumes.MaxVolumeSize
+	v := len(s.volumes.Volumes) - 1
+	if s.cur != nil && s.size+s.entrySize(name, size, isDir)+tarTrailerSize > max && len(s.volumes.Volumes[v].Paths) > 0 {
+		if err := s.endVolume(); err != nil {
+			return err
+		}
+	}
+	if s.cur == nil {
+		if err := s.newVolume(); err != nil {
+			return err
+		}
+		v = len(s.volumes.Volumes) - 1
+	}
+	s.size += s.entrySize(name, size, isDir)
+	if !isDir {
+		s.volumes.Volumes[v].Paths = append(s.volumes.Volumes[v].Paths, name)
+	}
+	return nil
+}
+
+// entrySize returns the size the entry name of size bytes adds to the
+// current volume, with the directories it has not written yet.
+func (s *splitSink) entrySize(name string, size int64, isDir bool) int64 {
+	var n int64
+	for _, d := range newDirs(s.cur.dirs, name, isDir) {
+		n += headerSize(tarball.DirHeader(d))
+	}
+	if !isDir {
+		n += headerSize(tarball.Header(name, size)) + blockSize(size)
+	}
+	return n
+}
+
+// newVolume starts the next volume, carrying on the progress of the
+// previous ones.
+func (s *splitSink) newVolume() error {
+	name := VolumeName(len(s.volumes.Volumes))
+	t, err := s.idx.newTarSink(s.ctx, filepath.Join(s.dir, name))
+	if err != nil {
+		return err
+	}
+	// the split sink lists the redirects of all the volumes
+	t.manifestRedirects = false
+	t.e = s.e
+	s.cur, s.size = t, s.base
+	s.volumes.Volumes = append(s.volumes.Volumes, Volume{Name: filepath.Base(t.tarFile)})
+	return nil
+}
+
+// endVolume ends the current volume.
+func (s *splitSink) endVolume() error {
+	t := s.cur
+	s.cur = nil
+	defer t.close()
+	if err := t.end(); err != nil {
+		return err
+	}
+	s.e = t.e
+	s.volumes.Volumes[len(s.volumes.Volumes)-1].Size = s.size + tarTrailerSize
+	return nil
+}
+
+// writeRedirects writes the RedirectsFile of the redirects left out, if
+// any, to the current volume.
+func (s *splitSink) writeRedirects() error {
+	s.idx.mu.Lock()
+	s.idx.redirects = s.redirects
+	s.idx.mu.Unlock()
+	if len(s.redirects) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(s.redirects)
+	if err != nil {
+		return err
+	}
+	if err := s.reserve(RedirectsFile, int64(len(data)), false); err != nil {
+		return err
+	}
+	return writeTarEntry(s.cur.tw, s.cur.dirs, &Article{path: RedirectsFile, data: data})
+}
+
+// finish ends the last volume, writes VolumesFile and reports the end of
+// the stage.
+func (s *splitSink) finish() error {
+	t := s.cur
+	if t == nil {
+		return errors.New("no volume written")
+	}
+	if err := s.endVolume(); err != nil {
+		return err
+	}
+	if err := WriteVolumes(s.dir, s.volumes); err != nil {
+		return err
+	}
+	t.done()
+	return nil
+}
+
+// close closes the volume being written, if any.
+func (s *splitSink) close() {
+	if s.cur != nil {
+		s.cur.close()
+	}
+}
+
+// headerSize returns the size in a tar of the header, with its PAX
+// records, e.g. for a long name, whose padding the tar writer leaves for
+// the next write.
+func headerSize(hdr *tar.Header) int64 {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(hdr); err != nil {
+		// the tar writer fails with the same error
+		return 0
+	}
+	return blockSize(int64(buf.Len()))
+}
+
+// blockSize returns the size of n bytes in the blocks of a tar.
+func blockSize(n int64) int64 {
+	return (n + 511) / 512 * 512
+}
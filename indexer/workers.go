@@ -0,0 +1,131 @@
+package indexer
+
+import (
+	"runtime/debug"
+	"sync"
+)
+
+// readAheadPerWorker is the number of entries each read worker can read
+// ahead of the entry waited for, so that a slow entry does not stall the
+// other workers.
+const readAheadPerWorker = 4
+
+// entryRead is an entry read by readEntry.
+type entryRead struct {
+	// i is the url index of the entry.
+	i     uint32
+	entry ZimEntry
+	a     Article
+	ok    bool
+	err   error
+}
+
+// readAt reads the entry at the url index.
+func (idx *SwarmZimIndexer) readAt(i uint32) entryRead {
+	r := entryRead{i: i}
+	r.entry, r.a, r.ok, r.err = idx.readEntry(i)
+	return r
+}
+
+// iterPanic is a panic of the iterator of the reader, raised again with
+// its stack by readConcurrently.
+type iterPanic struct {
+	value interface{}
+	stack []byte
+}
+
+// readConcurrently reads the entries of the iteration not skipped with
+// the given number of workers, decompressing their clusters in parallel,
+// and calls send with each of them in the iteration order, on the calling
+// goroutine, until stopped returns true. skip is called by the goroutine
+// of the iteration. The gozim reader reads with
+// ReadAt and its blob cache is safe for concurrent use, and the parse
+// holds zimMu, so no other reader resets the cache meanwhile. A panic of
+// the iterator is raised again as an iterPanic once the workers ended.
+func (idx *SwarmZimIndexer) readConcurrently(workers int, stopped func() bool, skip func(uint32) bool, send func(entryRead)) {
+	type job struct {
+		i    uint32
+		read chan entryRead
+	}
+	window := workers * readAheadPerWorker
+	// slots bound the entries read and not sent yet, jobs are read by the
+	// workers and pending are sent in the iteration order
+	slots := make(chan struct{}, window)
+	jobs := make(chan job, window)
+	pending := make(chan chan entryRead, window)
+	quit := make(chan struct{})
+	var panicked *iterPanic
+
+	go func() {
+		defer close(pending)
+		defer close(jobs)
+		defer func() {
+			if r := recover(); r != nil {
+				panicked = &iterPanic{value: r, stack: debug.Stack()}
+			}
+		}()
+		idx.Z.Iterate(func(i uint32) {
+			// the iterator of the reader can not be stopped, skip the
+			// remaining entries
+			select {
+			case <-quit:
+				return
+			default:
+			}
+			if skip(i) {
+				return
+			}
+			select {
+			case <-quit:
+				return
+			case slots <- struct{}{}:
+			}
+			j := job{i: i, read: make(chan entryRead, 1)}
+			jobs <- j
+			pending <- j.read
+		})
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				select {
+				case <-quit:
+					j.read <- entryRead{}
+				default:
+					j.read <- idx.readAt(j.i)
+				}
+			}
+		}()
+	}
+
+	// the entries read after the parse stopped, or while the loop body
+	// panics, are released
+	var once sync.Once
+	stop := func() { once.Do(func() { close(quit) }) }
+	defer func() {
+		stop()
+		for read := range pending {
+			r := <-read
+			r.a.Release()
+		}
+		wg.Wait()
+	}()
+	for read := range pending {
+		r := <-read
+		<-slots
+		if stopped() {
+			stop()
+			r.a.Release()
+			continue
+		}
+		send(r)
+	}
+	wg.Wait()
+	if panicked != nil {
+		panic(*panicked)
+	}
+}
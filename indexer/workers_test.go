@@ -0,0 +1,48 @@
+package indexer_test
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/r0qs/beezim/indexer/zimtest"
+)
+
+// BenchmarkReadWorkers parses a ZIM of 4000 articles of 16 KiB in zstd
+// clusters of 32 articles to a tar stream with 1 and 8 read workers, the
+// clusters being decompressed in parallel with more workers:
+//
+//	go test ./indexer -run - -bench ReadWorkers
+//
+// make benchparse compares them on a real ZIM.
+func BenchmarkReadWorkers(b *testing.B) {
+	const n, size = 4000, 16 << 10
+	entries := make([]zimtest.Entry, n)
+	for i := range entries {
+		url := fmt.Sprintf("Article%d", i)
+		entries[i] = zimtest.Entry{Namespace: 'A', URL: url, Title: url, Mime: "text/html", Content: payload(i, size)}
+	}
+	r := zimtest.New(entries...)
+	r.Compression, r.ClusterSize = zimtest.Zstd, 32
+	zimPath := filepath.Join(b.TempDir(), "clusters.zim")
+	if err := r.WriteFile(zimPath); err != nil {
+		b.Fatal(err)
+	}
+	for _, workers := range []int{1, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(n * size)
+			for i := 0; i < b.N; i++ {
+				idx, tr := parseZim(b, zimPath, workers)
+				if _, err := io.Copy(io.Discard, tr); err != nil {
+					b.Fatal(err)
+				}
+				tr.Close()
+				if err := idx.ParseErr(); err != nil {
+					b.Fatal(err)
+				}
+				idx.Close()
+			}
+		})
+	}
+}
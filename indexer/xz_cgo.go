@@ -0,0 +1,19 @@
+//go:build cgo
+
+package indexer
+
+import (
+	"io"
+
+	xz "github.com/remyoudompheng/go-liblzma"
+)
+
+// newXZReader decompresses the XZ clusters with liblzma, as gozim does
+// when cgo is enabled.
+func newXZReader(r io.Reader) (io.ReadCloser, error) {
+	dec, err := xz.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return dec, nil
+}
@@ -0,0 +1,19 @@
+//go:build !cgo
+
+package indexer
+
+import (
+	"io"
+
+	"github.com/ulikunitz/xz"
+)
+
+// newXZReader decompresses the XZ clusters in Go, as gozim does without
+// cgo, e.g. in the builds for windows.
+func newXZReader(r io.Reader) (io.ReadCloser, error) {
+	dec, err := xz.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(dec), nil
+}
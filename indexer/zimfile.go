@@ -0,0 +1,471 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The ZIM format, see https://openzim.org/wiki/ZIM_file_format.
+const (
+	zimMagic      = 72173914
+	zimHeaderSize = 80
+	noZimPage     = 0xffffffff
+	// the mime type indexes of the dirents without content
+	redirectMime   = 0xffff
+	linkTargetMime = 0xfffe
+	deletedMime    = 0xfffd
+	// the low bits of the first byte of a cluster are its compression, and
+	// extendedCluster is set when its blob offsets are 64 bits
+	clusterCompression = 0x0f
+	extendedCluster    = 0x10
+	// direntWindow is the first read of a dirent, grown for the longer
+	// urls and titles
+	direntWindow = 512
+	maxDirent    = 64 << 10
+	// clusterCacheSize is the number of decompressed clusters kept
+	clusterCacheSize = 8
+)
+
+// errUnsupportedZim is returned for the ZIMs of a major version neither
+// reader reads.
+var errUnsupportedZim = errors.New("unsupported zim version")
+
+// zimHeader is the header of a ZIM file.
+type zimHeader struct {
+	Magic         uint32
+	Major, Minor  uint16
+	UUID          [16]byte
+	EntryCount    uint32
+	ClusterCount  uint32
+	URLPtrPos     uint64
+	TitlePtrPos   uint64
+	ClusterPtrPos uint64
+	MimeListPos   uint64
+	MainPage      uint32
+	LayoutPage    uint32
+	ChecksumPos   uint64
+}
+
+// readZimHeader reads the header of the ZIM.
+func readZimHeader(r io.ReaderAt) (zimHeader, error) {
+	var h zimHeader
+	if err := binary.Read(io.NewSectionReader(r, 0, zimHeaderSize), binary.LittleEndian, &h); err != nil {
+		return h, err
+	}
+	if h.Magic != zimMagic {
+		return h, errors.New("not a ZIM file")
+	}
+	if h.Major != 5 && h.Major != 6 {
+		return h, fmt.Errorf("%w %d.%d", errUnsupportedZim, h.Major, h.Minor)
+	}
+	return h, nil
+}
+
+// NewNamespaceScheme reports whether the ZIM of the version puts all its
+// content in the C namespace, as libzim 7 writes them, instead of the A,
+// I and - namespaces.
+func NewNamespaceScheme(major, minor uint16) bool {
+	return major > 6 || major == 6 && minor >= 1
+}
+
+// versioned is implemented by the readers knowing the version of their
+// ZIM.
+type versioned interface {
+	Version() (major, minor uint16)
+}
+
+// zimFileReader reads the ZIMs gozim refuses, of any minor version of the
+// format 5 and 6, whose clusters may have 64 bits offsets.
+type zimFileReader struct {
+	f         *ZimFile
+	h         zimHeader
+	mimeTypes []string
+
+	mu sync.Mutex
+	// clusters are the last decompressed clusters, oldest first
+	clusters []cachedCluster
+}
+
+type cachedCluster struct {
+	n        uint32
+	data     []byte
+	extended bool
+}
+
+// cachedCluster returns the cluster when it was decompressed recently.
+func (r *zimFileReader) cachedCluster(n uint32) (cachedCluster, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.clusters {
+		if c.n == n {
+			return c, true
+		}
+	}
+	return cachedCluster{}, false
+}
+
+// openZimFile returns the zimFileReader of the ZIM file of the header.
+func openZimFile(f *ZimFile, h zimHeader) (*zimFileReader, error) {
+	r := &zimFileReader{f: f, h: h}
+	// the mime list ends with an empty string
+	b, err := r.readStrings(h.MimeListPos, -1)
+	if err != nil {
+		return nil, fmt.Errorf("reading mime list: %w", err)
+	}
+	r.mimeTypes = b
+	return r, nil
+}
+
+func (r *zimFileReader) Close() error {
+	return r.f.Close()
+}
+
+func (r *zimFileReader) Version() (major, minor uint16) {
+	return r.h.Major, r.h.Minor
+}
+
+func (r *zimFileReader) ArticleCount() uint32 {
+	return r.h.EntryCount
+}
+
+func (r *zimFileReader) MainPage() (ZimEntry, error) {
+	if r.h.MainPage == noZimPage {
+		return nil, nil
+	}
+	return r.EntryAt(r.h.MainPage)
+}
+
+// Iterate calls fn in the title order, or in the url order when the
+// title pointers can not be read.
+func (r *zimFileReader) Iterate(fn func(urlIdx uint32)) {
+	titles := make([]byte, 4*int64(r.h.EntryCount))
+	if _, err := r.f.ReadAt(titles, int64(r.h.TitlePtrPos)); err != nil {
+		for i := uint32(0); i < r.h.EntryCount; i++ {
+			fn(i)
+		}
+		return
+	}
+	for i := uint32(0); i < r.h.EntryCount; i++ {
+		fn(binary.LittleEndian.Uint32(titles[4*i:]))
+	}
+}
+
+func (r *zimFileReader) EntryAt(urlIdx uint32) (ZimEntry, error) {
+	if urlIdx >= r.h.EntryCount {
+		return nil, fmt.Errorf("url index %d out of range", urlIdx)
+	}
+	pos, err := r.uint64At(r.h.URLPtrPos + 8*uint64(urlIdx))
+	if err != nil {
+		return nil, err
+	}
+
+	// the dirents without content are shorter, and may end the file
+	var fixed [16]byte
+	if n, err := r.f.ReadAt(fixed[:], int64(pos)); n < 12 {
+		return nil, fmt.Errorf("reading dirent %d: %w", urlIdx, err)
+	}
+	e := &zimFileEntry{
+		r:         r,
+		mime:      binary.LittleEndian.Uint16(fixed[0:]),
+		namespace: fixed[3],
+	}
+	var strs uint64
+	switch e.mime {
+	case redirectMime:
+		e.redirect = binary.LittleEndian.Uint32(fixed[8:])
+		strs = pos + 12
+	case linkTargetMime, deletedMime:
+		strs = pos + 8
+	default:
+		e.cluster = binary.LittleEndian.Uint32(fixed[8:])
+		e.blob = binary.LittleEndian.Uint32(fixed[12:])
+		strs = pos + 16
+	}
+	names, err := r.readStrings(strs, 2)
+	if err != nil {
+		return nil, fmt.Errorf("reading dirent %d: %w", urlIdx, err)
+	}
+	e.url, e.title = names[0], names[1]
+	if e.title == "" {
+		e.title = e.url
+	}
+	return e, nil
+}
+
+// readStrings reads n strings ending with a NUL at pos, or until an empty
+// one when n is negative.
+func (r *zimFileReader) readStrings(pos uint64, n int) ([]string, error) {
+	for size := direntWindow; ; size *= 2 {
+		buf := make([]byte, size)
+		read, err := r.f.ReadAt(buf, int64(pos))
+		if read == 0 && err != nil {
+			return nil, err
+		}
+		buf = buf[:read]
+
+		var strs []string
+		for rest := buf; ; {
+			end := bytes.IndexByte(rest, 0)
+			if end < 0 {
+				break
+			}
+			s := string(rest[:end])
+			rest = rest[end+1:]
+			if n < 0 && s == "" {
+				return strs, nil
+			}
+			strs = append(strs, s)
+			if len(strs) == n {
+				return strs, nil
+			}
+		}
+		if read < size || size >= maxDirent {
+			return nil, errors.New("unterminated string")
+		}
+	}
+}
+
+func (r *zimFileReader) uint64At(pos uint64) (uint64, error) {
+	var b [8]byte
+	if _, err := r.f.ReadAt(b[:], int64(pos)); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b[:]), nil
+}
+
+// clusterBounds returns the offsets of the start and end of the cluster.
+func (r *zimFileReader) clusterBounds(n uint32) (start, end uint64, err error) {
+	if n >= r.h.ClusterCount {
+		return 0, 0, fmt.Errorf("cluster %d out of range", n)
+	}
+	if start, err = r.uint64At(r.h.ClusterPtrPos + 8*uint64(n)); err != nil {
+		return 0, 0, err
+	}
+	if n+1 == r.h.ClusterCount {
+		end = r.h.ChecksumPos
+	} else if end, err = r.uint64At(r.h.ClusterPtrPos + 8*uint64(n+1)); err != nil {
+		return 0, 0, err
+	}
+	if end <= start {
+		return 0, 0, fmt.Errorf("cluster %d is empty", n)
+	}
+	return start, end, nil
+}
+
+// cluster returns the content of the cluster after its first byte,
+// decompressed, and whether its offsets are 64 bits.
+func (r *zimFileReader) cluster(n uint32) ([]byte, bool, error) {
+	start, end, err := r.clusterBounds(n)
+	if err != nil {
+		return nil, false, err
+	}
+	raw := make([]byte, end-start)
+	if _, err := r.f.ReadAt(raw, int64(start)); err != nil {
+		return nil, false, fmt.Errorf("reading cluster %d: %w", n, err)
+	}
+	extended := raw[0]&extendedCluster != 0
+
+	var dec io.ReadCloser
+	switch c := raw[0] & clusterCompression; c {
+	case 0, 1:
+		return raw[1:], extended, nil
+	case 4:
+		dec, err = newXZReader(bytes.NewReader(raw[1:]))
+	case 5:
+		var zr *zstd.Decoder
+		if zr, err = zstd.NewReader(bytes.NewReader(raw[1:])); err == nil {
+			dec = zr.IOReadCloser()
+		}
+	default:
+		return nil, false, fmt.Errorf("cluster %d: unhandled compression %d", n, c)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("cluster %d: %w", n, err)
+	}
+	defer dec.Close()
+	data, err := io.ReadAll(dec)
+	if err != nil {
+		return nil, false, fmt.Errorf("decompressing cluster %d: %w", n, err)
+	}
+	return data, extended, nil
+}
+
+// blob returns a copy of the blob of the cluster.
+func (r *zimFileReader) blob(cluster, blob uint32) ([]byte, error) {
+	return r.readBlob(cluster, blob, func(n int) []byte { return make([]byte, n) })
+}
+
+// readBlob copies the blob of the cluster into the bytes returned by
+// alloc for its size.
+func (r *zimFileReader) readBlob(cluster, blob uint32, alloc func(n int) []byte) ([]byte, error) {
+	// the read workers wait for the one decompressing the cluster, see
+	// gozimEntry.Data
+	unlock := lockCluster(cluster)
+	defer unlock()
+
+	c, ok := r.cachedCluster(cluster)
+	if !ok {
+		// the blobs of the clusters that are not compressed are read on
+		// their own, rather than the whole cluster
+		if s, err := r.blobSection(cluster, blob); err != nil || s != nil {
+			if err != nil {
+				return nil, err
+			}
+			data := alloc(int(s.Size()))
+			if _, err := io.ReadFull(s, data); err != nil {
+				return nil, fmt.Errorf("reading cluster %d: %w", cluster, err)
+			}
+			return data, nil
+		}
+		data, extended, err := r.cluster(cluster)
+		if err != nil {
+			return nil, err
+		}
+		c = cachedCluster{n: cluster, data: data, extended: extended}
+		r.mu.Lock()
+		if len(r.clusters) == clusterCacheSize {
+			r.clusters = r.clusters[1:]
+		}
+		r.clusters = append(r.clusters, c)
+		r.mu.Unlock()
+	}
+	data, extended := c.data, c.extended
+
+	offset := func(i uint64) (uint64, error) {
+		if extended {
+			if 8*i+8 > uint64(len(data)) {
+				return 0, fmt.Errorf("cluster %d: blob %d out of range", cluster, blob)
+			}
+			return binary.LittleEndian.Uint64(data[8*i:]), nil
+		}
+		if 4*i+4 > uint64(len(data)) {
+			return 0, fmt.Errorf("cluster %d: blob %d out of range", cluster, blob)
+		}
+		return uint64(binary.LittleEndian.Uint32(data[4*i:])), nil
+	}
+	start, err := offset(uint64(blob))
+	if err != nil {
+		return nil, err
+	}
+	end, err := offset(uint64(blob) + 1)
+	if err != nil {
+		return nil, err
+	}
+	if start > end || end > uint64(len(data)) {
+		return nil, fmt.Errorf("cluster %d: blob %d out of bounds", cluster, blob)
+	}
+	b := alloc(int(end - start))
+	copy(b, data[start:end])
+	return b, nil
+}
+
+// blobSection returns a reader of the blob of the cluster in the file,
+// nil when the cluster is compressed and has to be decompressed whole,
+// see blob.
+func (r *zimFileReader) blobSection(cluster, blob uint32) (*io.SectionReader, error) {
+	start, end, err := r.clusterBounds(cluster)
+	if err != nil {
+		return nil, err
+	}
+	var info [1]byte
+	if _, err := r.f.ReadAt(info[:], int64(start)); err != nil {
+		return nil, fmt.Errorf("reading cluster %d: %w", cluster, err)
+	}
+	if c := info[0] & clusterCompression; c != 0 && c != 1 {
+		return nil, nil
+	}
+	width := uint64(4)
+	if info[0]&extendedCluster != 0 {
+		width = 8
+	}
+	// the offsets of the blob and of the next one, from the start of the
+	// data of the cluster
+	data := start + 1
+	pos := data + width*uint64(blob)
+	if pos+2*width > end {
+		return nil, fmt.Errorf("cluster %d: blob %d out of range", cluster, blob)
+	}
+	b := make([]byte, 2*width)
+	if _, err := r.f.ReadAt(b, int64(pos)); err != nil {
+		return nil, fmt.Errorf("reading cluster %d: %w", cluster, err)
+	}
+	var from, to uint64
+	if width == 8 {
+		from, to = binary.LittleEndian.Uint64(b), binary.LittleEndian.Uint64(b[8:])
+	} else {
+		from, to = uint64(binary.LittleEndian.Uint32(b)), uint64(binary.LittleEndian.Uint32(b[4:]))
+	}
+	if from > to || to > end-data {
+		return nil, fmt.Errorf("cluster %d: blob %d out of bounds", cluster, blob)
+	}
+	return io.NewSectionReader(r.f, int64(data+from), int64(to-from)), nil
+}
+
+type zimFileEntry struct {
+	r             *zimFileReader
+	mime          uint16
+	namespace     byte
+	url, title    string
+	cluster, blob uint32
+	redirect      uint32
+}
+
+func (e *zimFileEntry) FullURL() string  { return string(e.namespace) + "/" + e.url }
+func (e *zimFileEntry) Title() string    { return e.title }
+func (e *zimFileEntry) Namespace() byte  { return e.namespace }
+func (e *zimFileEntry) IsRedirect() bool { return e.mime == redirectMime }
+func (e *zimFileEntry) IsDeleted() bool {
+	return e.mime == deletedMime || e.mime == linkTargetMime
+}
+
+func (e *zimFileEntry) MimeType() string {
+	if int(e.mime) >= len(e.r.mimeTypes) {
+		return ""
+	}
+	return e.r.mimeTypes[e.mime]
+}
+
+func (e *zimFileEntry) Data() ([]byte, error) {
+	if e.IsRedirect() || e.IsDeleted() {
+		return nil, nil
+	}
+	return e.r.blob(e.cluster, e.blob)
+}
+
+// DataSection returns a reader of the content of the entry in the file
+// of the ZIM, nil when its cluster is compressed.
+func (e *zimFileEntry) DataSection() (*io.SectionReader, error) {
+	if e.IsRedirect() || e.IsDeleted() {
+		return nil, nil
+	}
+	return e.r.blobSection(e.cluster, e.blob)
+}
+
+// DataBuffer returns the content of the entry in a pooled buffer.
+func (e *zimFileEntry) DataBuffer() (*bytes.Buffer, error) {
+	if e.IsRedirect() || e.IsDeleted() {
+		return new(bytes.Buffer), nil
+	}
+	var buf *bytes.Buffer
+	_, err := e.r.readBlob(e.cluster, e.blob, func(n int) []byte {
+		buf = getBuffer(n)
+		return fill(buf, n)
+	})
+	if err != nil && buf != nil {
+		putBuffer(buf)
+	}
+	return buf, err
+}
+
+func (e *zimFileEntry) RedirectIndex() (uint32, error) {
+	if !e.IsRedirect() {
+		return 0, errors.New("not a redirect")
+	}
+	return e.redirect, nil
+}
@@ -0,0 +1,293 @@
+package zimtest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// ErrNotWritable is returned by Write for a reader whose scripted errors
+// can not be represented in a ZIM file.
+var ErrNotWritable = errors.New("reader can not be written as a zim")
+
+const (
+	zimMagic     = 72173914
+	headerSize   = 80
+	noPage       = 0xffffffff
+	redirectMime = 0xffff
+	deletedMime  = 0xfffd
+	// extendedCluster flags the clusters with 64 bits offsets.
+	extendedCluster = 0x10
+	// defaultMime replaces an empty Mime, which would end the mime list.
+	defaultMime = "application/octet-stream"
+	// lookahead is the padding after the dirents, as gozim reads 2KiB past
+	// a dirent for its url and title.
+	lookahead = 2048
+)
+
+// Compression is the compression of a cluster, as its first byte.
+type Compression byte
+
+// The compressions of the clusters read by indexer.NewReader.
+const (
+	Uncompressed Compression = 1
+	XZ           Compression = 4
+	Zstd         Compression = 5
+)
+
+// WriteFile writes the reader as a ZIM file at name, see Write.
+func (r *Reader) WriteFile(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := r.Write(f); err != nil {
+		f.Close()
+		os.Remove(name)
+		return err
+	}
+	return f.Close()
+}
+
+// Write writes the reader as a ZIM of its version that indexer.NewReader,
+// and gozim for 5.0, read back as described. The url pointers of a ZIM
+// are sorted by full url, which readers search, so the entries get the
+// url index of their rank in that order, the redirect targets, Main and
+// Order being remapped to them; the title order is Order, whose length
+// must then be the number of entries, or the order of the entries. Two
+// entries with the same full url are not writable. The content is stored
+// in clusters of ClusterSize blobs of the Compression, extended with 64
+// bits offsets from the version 6.
+//
+// The ZIM format stores an empty title as the url and gozim truncates the
+// url and title of an entry to 2KiB; an empty Mime is written as
+// application/octet-stream. Readers with scripted errors are not
+// writable.
+func (r *Reader) Write(w io.Writer) error {
+	if r.MainErr != nil || len(r.EntryErrs) > 0 {
+		return fmt.Errorf("%w: scripted reader errors", ErrNotWritable)
+	}
+	if r.Order != nil && len(r.Order) != len(r.Entries) {
+		return fmt.Errorf("%w: order has %d indexes for %d entries", ErrNotWritable, len(r.Order), len(r.Entries))
+	}
+	if r.Main != NoMainPage && (r.Main < 0 || r.Main >= len(r.Entries)) {
+		return fmt.Errorf("%w: main page %d out of range", ErrNotWritable, r.Main)
+	}
+
+	// rank maps the position of an entry to its url index
+	sorted := make([]int, len(r.Entries))
+	for i := range sorted {
+		sorted[i] = i
+	}
+	slices.SortStableFunc(sorted, func(i, j int) int {
+		return compareURL(r.Entries[i], r.Entries[j])
+	})
+	rank := make([]uint32, len(r.Entries))
+	for n, i := range sorted {
+		if n > 0 && compareURL(r.Entries[sorted[n-1]], r.Entries[i]) == 0 {
+			e := r.Entries[i]
+			return fmt.Errorf("%w: duplicate url %c/%s", ErrNotWritable, e.Namespace, e.URL)
+		}
+		rank[i] = uint32(n)
+	}
+	remap := func(idx uint32) uint32 {
+		if int(idx) < len(rank) {
+			return rank[idx]
+		}
+		return idx
+	}
+
+	perCluster := r.ClusterSize
+	if perCluster <= 0 {
+		perCluster = max(len(r.Entries), 1)
+	}
+	var mimes []string
+	mimeIdx := make(map[string]uint16)
+	var blobs [][]byte
+	var dirents bytes.Buffer
+	direntPos := make([]uint64, len(r.Entries))
+	for i, e := range r.Entries {
+		if e.DataErr != nil || e.RedirectErr != nil || e.DataPanic != nil {
+			return fmt.Errorf("%w: scripted errors of entry %d", ErrNotWritable, i)
+		}
+		direntPos[i] = uint64(dirents.Len())
+		title := e.Title
+		if title == "" {
+			title = e.URL
+		}
+
+		switch {
+		case e.Redirect:
+			writeLE(&dirents, uint16(redirectMime), byte(0), e.Namespace, uint32(0), remap(e.Target))
+		case e.Deleted:
+			writeLE(&dirents, uint16(deletedMime), byte(0), e.Namespace, uint32(0), uint32(0), uint32(0))
+		default:
+			mime := e.Mime
+			if mime == "" {
+				mime = defaultMime
+			}
+			idx, ok := mimeIdx[mime]
+			if !ok {
+				idx = uint16(len(mimes))
+				mimeIdx[mime] = idx
+				mimes = append(mimes, mime)
+			}
+			cluster, blob := len(blobs)/perCluster, len(blobs)%perCluster
+			writeLE(&dirents, idx, byte(0), e.Namespace, uint32(0), uint32(cluster), uint32(blob))
+			blobs = append(blobs, e.Content)
+		}
+		dirents.WriteString(e.URL)
+		dirents.WriteByte(0)
+		dirents.WriteString(title)
+		dirents.WriteByte(0)
+	}
+
+	var mimeList bytes.Buffer
+	for _, m := range mimes {
+		mimeList.WriteString(m)
+		mimeList.WriteByte(0)
+	}
+	mimeList.WriteByte(0)
+
+	var clusters [][][]byte
+	for b := range slices.Chunk(blobs, perCluster) {
+		clusters = append(clusters, b)
+	}
+	clusterCount := uint32(len(clusters))
+
+	// header, mime list, url, title and cluster pointers, dirents, padding,
+	// clusters and checksum
+	mimeListPos := uint64(headerSize)
+	urlPtrPos := mimeListPos + uint64(mimeList.Len())
+	titlePtrPos := urlPtrPos + 8*uint64(len(r.Entries))
+	clusterPtrPos := titlePtrPos + 4*uint64(len(r.Entries))
+	direntsPos := clusterPtrPos + 8*uint64(clusterCount)
+	clusterPos := direntsPos + uint64(dirents.Len()) + lookahead
+
+	major, minor := r.Major, r.Minor
+	if major == 0 {
+		major = 5
+	}
+	var data bytes.Buffer
+	clusterPtrs := make([]uint64, len(clusters))
+	for i, blobs := range clusters {
+		clusterPtrs[i] = clusterPos + uint64(data.Len())
+		// offsets relative to the end of the compression byte
+		var blob bytes.Buffer
+		offsetSize := 4
+		flags := byte(r.Compression)
+		if flags == 0 {
+			flags = byte(Uncompressed)
+		}
+		if major >= 6 {
+			offsetSize = 8
+			flags |= extendedCluster
+		}
+		offset := uint64(offsetSize * (len(blobs) + 1))
+		writeOffset := func() {
+			if offsetSize == 8 {
+				writeLE(&blob, offset)
+			} else {
+				writeLE(&blob, uint32(offset))
+			}
+		}
+		for _, b := range blobs {
+			writeOffset()
+			offset += uint64(len(b))
+		}
+		writeOffset()
+		for _, b := range blobs {
+			blob.Write(b)
+		}
+		data.WriteByte(flags)
+		if err := compress(&data, blob.Bytes(), r.Compression); err != nil {
+			return err
+		}
+	}
+	checksumPos := clusterPos + uint64(data.Len())
+
+	mainPage := uint32(noPage)
+	if r.Main != NoMainPage {
+		mainPage = rank[r.Main]
+	}
+
+	sum := md5.New()
+	bw := bufio.NewWriter(io.MultiWriter(w, sum))
+	writeLE(bw, uint32(zimMagic), major, minor, [16]byte{},
+		uint32(len(r.Entries)), clusterCount,
+		urlPtrPos, titlePtrPos, clusterPtrPos, mimeListPos,
+		mainPage, uint32(noPage), checksumPos)
+	bw.Write(mimeList.Bytes())
+	for _, i := range sorted {
+		writeLE(bw, direntsPos+direntPos[i])
+	}
+	for i := range r.Entries {
+		idx := uint32(i)
+		if r.Order != nil {
+			idx = r.Order[i]
+		}
+		writeLE(bw, remap(idx))
+	}
+	for _, ptr := range clusterPtrs {
+		writeLE(bw, ptr)
+	}
+	bw.Write(dirents.Bytes())
+	bw.Write(make([]byte, lookahead))
+	bw.Write(data.Bytes())
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	_, err := w.Write(sum.Sum(nil))
+	return err
+}
+
+// compareURL compares the full urls of two entries, by namespace then url.
+func compareURL(a, b Entry) int {
+	if a.Namespace != b.Namespace {
+		return int(a.Namespace) - int(b.Namespace)
+	}
+	return strings.Compare(a.URL, b.URL)
+}
+
+// writeLE writes the values in little endian to a buffered writer, whose
+// errors are reported on flush.
+func writeLE(w io.Writer, values ...interface{}) {
+	for _, v := range values {
+		binary.Write(w, binary.LittleEndian, v)
+	}
+}
+
+// compress writes the content of a cluster to w with the compression.
+func compress(w io.Writer, data []byte, c Compression) error {
+	var enc io.WriteCloser
+	var err error
+	switch c {
+	case 0, Uncompressed:
+		_, err := w.Write(data)
+		return err
+	case XZ:
+		enc, err = xz.NewWriter(w)
+	case Zstd:
+		enc, err = zstd.NewWriter(w)
+	default:
+		return fmt.Errorf("%w: compression %d", ErrNotWritable, c)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := enc.Write(data); err != nil {
+		enc.Close()
+		return err
+	}
+	return enc.Close()
+}
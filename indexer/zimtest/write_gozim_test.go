@@ -0,0 +1,65 @@
+//go:build !windows
+
+package zimtest_test
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	zim "github.com/akhenakh/gozim"
+)
+
+// gozim memory maps the ZIM, which does not build on windows.
+
+func TestWriteGozim(t *testing.T) {
+	r := unsorted()
+	zimPath := filepath.Join(t.TempDir(), "test.zim")
+	if err := r.WriteFile(zimPath); err != nil {
+		t.Fatal(err)
+	}
+	z, err := zim.NewReader(zimPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	var urls []string
+	byURL := make(map[string]*zim.Article)
+	for i := range z.ArticleCount {
+		a, err := z.ArticleAtURLIdx(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls = append(urls, a.FullURL())
+		byURL[a.FullURL()] = a
+	}
+	if !slices.IsSorted(urls) {
+		t.Errorf("url index not sorted: %v", urls)
+	}
+	for _, e := range r.Entries {
+		u := string(e.Namespace) + "/" + e.URL
+		a, ok := byURL[u]
+		if !ok {
+			t.Errorf("%s: missing", u)
+			continue
+		}
+		if e.Redirect {
+			continue
+		}
+		data, err := a.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != string(e.Content) {
+			t.Errorf("%s: got %q, want %q", u, data, e.Content)
+		}
+	}
+	main, err := z.MainPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if main.FullURL() != "A/Alpha" {
+		t.Errorf("got main page %s, want A/Alpha", main.FullURL())
+	}
+}
@@ -0,0 +1,165 @@
+package zimtest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/indexer/zimtest"
+)
+
+// unsorted returns a reader whose entries are not in url order, with a
+// redirect and a main page to remap.
+func unsorted() *zimtest.Reader {
+	r := zimtest.New(
+		zimtest.Entry{Namespace: 'A', URL: "Zeta", Title: "Zeta", Mime: "text/html", Content: []byte("<p>zeta</p>")},
+		zimtest.Entry{Namespace: 'M', URL: "Language", Mime: "text/plain", Content: []byte("eng")},
+		zimtest.Entry{Namespace: 'A', URL: "Alpha", Title: "Alpha", Mime: "text/html", Content: []byte("<p>alpha</p>")},
+		zimtest.Redirect('A', "Home", 0),
+		zimtest.Entry{Namespace: 'I', URL: "logo.png", Mime: "image/png", Content: []byte("png")},
+		zimtest.Entry{Namespace: 'M', URL: "Title", Mime: "text/plain", Content: []byte("Test wiki")},
+	)
+	r.Main = 2
+	return r
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	for _, v := range []struct {
+		major, minor uint16
+		compression  zimtest.Compression
+		clusterSize  int
+	}{
+		{5, 0, zimtest.Uncompressed, 0},
+		{6, 0, zimtest.Uncompressed, 0},
+		{6, 1, zimtest.Uncompressed, 0},
+		{5, 0, zimtest.XZ, 0},
+		{6, 1, zimtest.XZ, 0},
+		{5, 0, zimtest.Zstd, 0},
+		{6, 1, zimtest.Zstd, 0},
+		{5, 0, zimtest.Uncompressed, 2},
+		{6, 1, zimtest.Zstd, 2},
+	} {
+		t.Run(fmt.Sprintf("%d.%d compression %d clusters of %d", v.major, v.minor, v.compression, v.clusterSize), func(t *testing.T) {
+			r := unsorted()
+			r.Major, r.Minor = v.major, v.minor
+			r.Compression = v.compression
+			r.ClusterSize = v.clusterSize
+			zimPath := filepath.Join(t.TempDir(), "test.zim")
+			if err := r.WriteFile(zimPath); err != nil {
+				t.Fatal(err)
+			}
+			if err := indexer.VerifyChecksum(context.Background(), zimPath); err != nil {
+				t.Fatal(err)
+			}
+
+			z, err := indexer.NewReader(zimPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer z.(interface{ Close() error }).Close()
+			checkReader(t, r, z)
+
+			idx, err := indexer.New(zimPath, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer idx.Close()
+			m := idx.ExtractMetadata()
+			if m["Language"] != "eng" || m["Title"] != "Test wiki" {
+				t.Errorf("got metadata %v, want Language eng and Title Test wiki", m)
+			}
+		})
+	}
+}
+
+// checkReader checks that z, read from the ZIM written of r, has the
+// entries of r in url order.
+func checkReader(t *testing.T, r *zimtest.Reader, z indexer.ZimReader) {
+	t.Helper()
+	if n := z.ArticleCount(); int(n) != len(r.Entries) {
+		t.Fatalf("got %d entries, want %d", n, len(r.Entries))
+	}
+
+	var urls []string
+	byURL := make(map[string]indexer.ZimEntry)
+	for i := range z.ArticleCount() {
+		e, err := z.EntryAt(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls = append(urls, e.FullURL())
+		byURL[e.FullURL()] = e
+	}
+	if !slices.IsSorted(urls) {
+		t.Errorf("url index not sorted: %v", urls)
+	}
+
+	for _, want := range r.Entries {
+		u := string(want.Namespace) + "/" + want.URL
+		e, ok := byURL[u]
+		if !ok {
+			t.Errorf("%s: missing", u)
+			continue
+		}
+		if e.IsRedirect() != want.Redirect {
+			t.Errorf("%s: got redirect %v, want %v", u, e.IsRedirect(), want.Redirect)
+		}
+		if want.Redirect {
+			target, err := e.RedirectIndex()
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantTarget := r.Entries[want.Target]
+			if got, want := urls[target], string(wantTarget.Namespace)+"/"+wantTarget.URL; got != want {
+				t.Errorf("%s: got redirect to %s, want %s", u, got, want)
+			}
+			continue
+		}
+		if e.MimeType() != want.Mime {
+			t.Errorf("%s: got mime %q, want %q", u, e.MimeType(), want.Mime)
+		}
+		data, err := e.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != string(want.Content) {
+			t.Errorf("%s: got %q, want %q", u, data, want.Content)
+		}
+	}
+
+	main, err := z.MainPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if main == nil || main.FullURL() != "A/Alpha" {
+		t.Errorf("got main page %v, want A/Alpha", main)
+	}
+
+	// the title order is the order of the entries
+	var order []string
+	z.Iterate(func(i uint32) {
+		order = append(order, urls[i])
+	})
+	var want []string
+	for _, e := range r.Entries {
+		want = append(want, string(e.Namespace)+"/"+e.URL)
+	}
+	if !slices.Equal(order, want) {
+		t.Errorf("got title order %v, want %v", order, want)
+	}
+}
+
+func TestWriteDuplicateURL(t *testing.T) {
+	r := zimtest.New(
+		zimtest.Entry{Namespace: 'A', URL: "Foo", Content: []byte("1")},
+		zimtest.Entry{Namespace: 'A', URL: "Foo", Content: []byte("2")},
+	)
+	err := r.WriteFile(filepath.Join(t.TempDir(), "test.zim"))
+	if !errors.Is(err, zimtest.ErrNotWritable) {
+		t.Errorf("got %v, want %v", err, zimtest.ErrNotWritable)
+	}
+}
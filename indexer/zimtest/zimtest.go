@@ -0,0 +1,167 @@
+// Package zimtest provides an in-memory ZIM reader for testing the
+// indexer without ZIM fixtures, with failures that can be scripted per
+// entry:
+//
+//	r := zimtest.New(
+//		zimtest.Entry{Namespace: 'A', URL: "Main", Title: "Main", Content: []byte("<html>")},
+//		zimtest.Redirect('A', "Home", 0),
+//		zimtest.Entry{Namespace: 'I', URL: "logo.png", DataErr: errors.New("corrupt cluster")},
+//	)
+//	r.Main = 0
+//	idx := indexer.NewWithReader("test.zim", r, false)
+//
+// Readers without scripted errors can be written as ZIM files opened by
+// indexer.NewReader or gozim, for fixtures of the code reading ZIMs from
+// disk:
+//
+//	err := r.WriteFile(filepath.Join(dir, "test.zim"))
+package zimtest
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/r0qs/beezim/indexer"
+)
+
+// NoMainPage is the Main of a reader without main page.
+const NoMainPage = -1
+
+// ErrNotRedirect is returned by RedirectIndex for an entry that is not a
+// redirect.
+var ErrNotRedirect = errors.New("not a redirect entry")
+
+// Entry is an entry of a Reader. The zero values of the error fields
+// make it readable.
+type Entry struct {
+	Namespace byte
+	URL       string
+	Title     string
+	Mime      string
+	Content   []byte
+	Deleted   bool
+
+	// Redirect makes the entry a redirect to the url index Target, which
+	// may be out of range.
+	Redirect bool
+	Target   uint32
+
+	// DataErr is returned by Data, e.g. for a corrupt cluster.
+	DataErr error
+	// RedirectErr is returned by RedirectIndex.
+	RedirectErr error
+	// DataPanic, when set, is the value Data panics with, as gozim does on
+	// some corrupt clusters.
+	DataPanic interface{}
+}
+
+// Redirect returns a redirect entry to the url index target.
+func Redirect(namespace byte, url string, target uint32) Entry {
+	return Entry{Namespace: namespace, URL: url, Title: url, Redirect: true, Target: target}
+}
+
+// Reader is an in-memory ZimReader. The entries are indexed by their
+// position and iterated in that order unless Order is set.
+type Reader struct {
+	Entries []Entry
+	// Order is the order of the url indexes in the iteration, the order
+	// of the entries when nil. It may hold indexes out of range.
+	Order []uint32
+	// Main is the url index of the main page, or NoMainPage.
+	Main int
+	// MainErr is returned by MainPage.
+	MainErr error
+	// EntryErrs are returned by EntryAt for their url index.
+	EntryErrs map[uint32]error
+	// Major and Minor are the version of the format written by Write, 5.0
+	// when zero, e.g. 6.1 for the new namespace scheme of libzim 7 with
+	// the content in C.
+	Major, Minor uint16
+	// Compression is the compression of the clusters written by Write,
+	// Uncompressed when zero.
+	Compression Compression
+	// ClusterSize is the number of blobs of the clusters written by Write,
+	// all of them in one cluster when zero.
+	ClusterSize int
+}
+
+// New returns a reader of the entries, without main page.
+func New(entries ...Entry) *Reader {
+	return &Reader{Entries: entries, Main: NoMainPage}
+}
+
+var _ indexer.ZimReader = (*Reader)(nil)
+
+// ArticleCount returns the number of entries.
+func (r *Reader) ArticleCount() uint32 {
+	return uint32(len(r.Entries))
+}
+
+// MainPage returns the entry at Main.
+func (r *Reader) MainPage() (indexer.ZimEntry, error) {
+	if r.MainErr != nil {
+		return nil, r.MainErr
+	}
+	if r.Main == NoMainPage {
+		return nil, nil
+	}
+	return r.EntryAt(uint32(r.Main))
+}
+
+// Iterate calls fn with the url indexes of Order, or of all entries.
+func (r *Reader) Iterate(fn func(urlIdx uint32)) {
+	if r.Order != nil {
+		for _, i := range r.Order {
+			fn(i)
+		}
+		return
+	}
+	for i := range r.Entries {
+		fn(uint32(i))
+	}
+}
+
+// EntryAt returns the entry at the url index, or its scripted error.
+func (r *Reader) EntryAt(urlIdx uint32) (indexer.ZimEntry, error) {
+	if err := r.EntryErrs[urlIdx]; err != nil {
+		return nil, err
+	}
+	if int(urlIdx) >= len(r.Entries) {
+		return nil, fmt.Errorf("url index %d out of range", urlIdx)
+	}
+	return entry{r.Entries[urlIdx]}, nil
+}
+
+// entry adapts an Entry, whose fields collide with the method names of
+// indexer.ZimEntry.
+type entry struct {
+	e Entry
+}
+
+func (e entry) FullURL() string  { return string(e.e.Namespace) + "/" + e.e.URL }
+func (e entry) Title() string    { return e.e.Title }
+func (e entry) Namespace() byte  { return e.e.Namespace }
+func (e entry) MimeType() string { return e.e.Mime }
+func (e entry) IsRedirect() bool { return e.e.Redirect }
+func (e entry) IsDeleted() bool  { return e.e.Deleted }
+
+func (e entry) Data() ([]byte, error) {
+	if e.e.DataPanic != nil {
+		panic(e.e.DataPanic)
+	}
+	if e.e.DataErr != nil {
+		return nil, e.e.DataErr
+	}
+	// like gozim, every call returns a copy
+	return append([]byte(nil), e.e.Content...), nil
+}
+
+func (e entry) RedirectIndex() (uint32, error) {
+	if e.e.RedirectErr != nil {
+		return 0, e.e.RedirectErr
+	}
+	if !e.e.Redirect {
+		return 0, ErrNotRedirect
+	}
+	return e.e.Target, nil
+}
@@ -17,7 +17,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/ethersphere/bee/pkg/swarm"
 )
@@ -30,9 +32,14 @@ func newDirsService(a *Api) *DirsService {
 	return &DirsService{api: a}
 }
 
-// Download downloads data from the node
+// Download downloads data from the node, the segments of the path
+// escaped to the names of the manifest.
 func (ds *DirsService) Download(ctx context.Context, addr swarm.Address, path string) (resp io.ReadCloser, err error) {
-	return ds.api.C.RequestData(ctx, http.MethodGet, fmt.Sprintf("/bzz/%s/%s", addr.String(), path), nil)
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return ds.api.C.RequestData(ctx, http.MethodGet, fmt.Sprintf("/bzz/%s/%s", addr.String(), strings.Join(segments, "/")), nil)
 }
 
 // DirsUploadResponse represents Upload's response
@@ -40,13 +47,16 @@ type DirsUploadResponse struct {
 	Reference swarm.Address `json:"reference"`
 }
 
-// Upload uploads TAR collection to the node
+// Upload uploads TAR collection to the node, of an unknown size when
+// size is negative.
 func (ds *DirsService) Upload(ctx context.Context, data io.Reader, size int64, o UploadCollectionOptions) (DirsUploadResponse, error) {
 	var resp DirsUploadResponse
 
 	header := make(http.Header)
 	header.Set("Content-Type", "application/x-tar")
-	header.Set("Content-Length", strconv.FormatInt(size, 10))
+	if size >= 0 {
+		header.Set("Content-Length", strconv.FormatInt(size, 10))
+	}
 	header.Set(SwarmCollectionHeader, "true")
 	header.Set(SwarmDeferredUploadHeader, "true")
 	header.Set(SwarmPostageBatchIdHeader, o.BatchID)
@@ -14,6 +14,7 @@ package beeclient
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -22,6 +23,8 @@ import (
 	"github.com/r0qs/beezim/internal/beeclient/api"
 	"github.com/r0qs/beezim/internal/beeclient/debugapi"
 	"github.com/r0qs/beezim/internal/httpclient"
+	"github.com/r0qs/beezim/internal/iobuf"
+	"github.com/r0qs/beezim/internal/progress"
 	"github.com/r0qs/beezim/internal/tarball"
 
 	"github.com/ethersphere/bee/pkg/swarm"
@@ -32,15 +35,28 @@ type ClientOptions struct {
 	APIInsecureTLS      bool
 	DebugAPIURL         *url.URL
 	DebugAPIInsecureTLS bool
+	// RateLimiter, when set, limits the requests sent to both APIs.
+	RateLimiter httpclient.RateLimiter
+	// Buffers sets the size of the writes of the uploads, UploadChunk,
+	// the default when zero.
+	Buffers iobuf.Config
+	// Progress receives the bytes of the uploads when the context carries
+	// no reporter, none when nil.
+	Progress progress.Bar
 }
 
 type BeeClient struct {
-	api   *api.Api
-	debug *debugapi.DebugAPI
+	api         *api.Api
+	debug       *debugapi.DebugAPI
+	uploadChunk int
+	progress    progress.Bar
 }
 
 func NewBee(opts ClientOptions) (c *BeeClient, err error) {
-	c = &BeeClient{}
+	if err := opts.Buffers.Validate(); err != nil {
+		return nil, err
+	}
+	c = &BeeClient{uploadChunk: opts.Buffers.WithDefaults().UploadChunk, progress: opts.Progress}
 
 	if opts.APIURL != nil {
 		c.api, err = api.NewAPI(opts.APIURL, &httpclient.ClientOptions{
@@ -51,6 +67,7 @@ func NewBee(opts ClientOptions) (c *BeeClient, err error) {
 					},
 				},
 			},
+			RateLimiter: opts.RateLimiter,
 		})
 		if err != nil {
 			return nil, err
@@ -65,6 +82,7 @@ func NewBee(opts ClientOptions) (c *BeeClient, err error) {
 					},
 				},
 			},
+			RateLimiter: opts.RateLimiter,
 		})
 		if err != nil {
 			return nil, err
@@ -73,6 +91,17 @@ func NewBee(opts ClientOptions) (c *BeeClient, err error) {
 	return c, nil
 }
 
+// Shutdown releases the resources held by the client. It must be called
+// once no more requests are in flight, e.g. after an interrupted upload.
+func (c *BeeClient) Shutdown() {
+	if c.api != nil {
+		c.api.C.CloseIdleConnections()
+	}
+	if c.debug != nil {
+		c.debug.C.CloseIdleConnections()
+	}
+}
+
 func (c *BeeClient) DownloadChunk(ctx context.Context, addr swarm.Address, targets ...string) (io.ReadCloser, error) {
 	return c.api.Chunk.Download(ctx, addr, targets...)
 }
@@ -94,9 +123,16 @@ func (c *BeeClient) UploadBytes(ctx context.Context, data io.Reader, o api.Uploa
 // UploadCollection uploads TAR collection bytes to the node
 func (c *BeeClient) UploadCollection(ctx context.Context, f *tarball.File, o api.UploadCollectionOptions) (err error) {
 	h := tarball.FileHasher()
-	r, err := c.api.Dirs.Upload(ctx, io.TeeReader(f.DataReader(), h), f.Size(), o)
+	var data io.Reader = f.DataReader()
+	if rep := progress.FromContext(ctx); rep != nil {
+		data = progress.NewReader(data, rep, "upload", 0, max(f.Size(), 0))
+	} else if c.progress != nil {
+		data = progress.NewReader(data, progress.BarReporter(c.progress), "upload", 0, max(f.Size(), 0))
+	}
+	data = iobuf.NewChunkReader(io.TeeReader(data, h), c.uploadChunk)
+	r, err := c.api.Dirs.Upload(ctx, data, f.Size(), o)
 	if err != nil {
-		return fmt.Errorf("upload collection: %v", err)
+		return fmt.Errorf("upload collection %s: %w", f.Name(), err)
 	}
 
 	f.SetAddress(r.Reference)
@@ -104,17 +140,30 @@ func (c *BeeClient) UploadCollection(ctx context.Context, f *tarball.File, o api
 	return
 }
 
+// ErrRootNotFound is returned when the node does not serve a root, or a
+// path under it. The error also matches httpclient.ErrNotFound.
+var ErrRootNotFound = errors.New("root not found")
+
+// manifestError adds the root and path to an error downloading from a
+// manifest.
+func manifestError(addr swarm.Address, path string, err error) error {
+	if errors.Is(err, httpclient.ErrNotFound) {
+		return fmt.Errorf("download manifest %s/%s: %w: %w", addr, path, ErrRootNotFound, err)
+	}
+	return fmt.Errorf("download manifest %s/%s: %w", addr, path, err)
+}
+
 // DownloadManifestFile downloads manifest file from the node and returns it's size and hash
 func (c *BeeClient) DownloadManifestFile(ctx context.Context, addr swarm.Address, path string) (size int64, hash []byte, err error) {
 	r, err := c.api.Dirs.Download(ctx, addr, path)
 	if err != nil {
-		return 0, nil, fmt.Errorf("download manifest file %s: %w", path, err)
+		return 0, nil, manifestError(addr, path, err)
 	}
 
 	h := tarball.FileHasher()
 	size, err = io.Copy(h, r)
 	if err != nil {
-		return 0, nil, fmt.Errorf("download manifest file %s: %w", path, err)
+		return 0, nil, manifestError(addr, path, err)
 	}
 
 	return size, h.Sum(nil), nil
@@ -123,13 +172,13 @@ func (c *BeeClient) DownloadManifestFile(ctx context.Context, addr swarm.Address
 func (c *BeeClient) DownloadManifestBytes(ctx context.Context, addr swarm.Address, path string) ([]byte, error) {
 	r, err := c.api.Dirs.Download(ctx, addr, path)
 	if err != nil {
-		return nil, fmt.Errorf("download manifest %s: %w", path, err)
+		return nil, manifestError(addr, path, err)
 	}
 	defer r.Close()
 
 	buf, err := io.ReadAll(r)
 	if err != nil {
-		return nil, fmt.Errorf("download manifest %s: %w", path, err)
+		return nil, manifestError(addr, path, err)
 	}
 
 	return buf, nil
@@ -151,3 +200,18 @@ func (c *BeeClient) CreatePostageBatch(ctx context.Context, amount int64, depth
 func (c *BeeClient) PostageBatches(ctx context.Context) ([]debugapi.PostageStampResponse, error) {
 	return c.debug.Postage.PostageBatches(ctx)
 }
+
+// ChainState returns the postage contract state known by the node
+func (c *BeeClient) ChainState(ctx context.Context) (debugapi.ChainStateResponse, error) {
+	return c.debug.Postage.ChainState(ctx)
+}
+
+// TopUpPostageBatch increases the balance of a batch by amount per chunk
+func (c *BeeClient) TopUpPostageBatch(ctx context.Context, batchID string, amount int64, o debugapi.PostageOptions) error {
+	return c.debug.Postage.TopUpPostageBatch(ctx, batchID, amount, o)
+}
+
+// HasDebugAPI reports whether the client is connected to the debug api
+func (c *BeeClient) HasDebugAPI() bool {
+	return c.debug != nil
+}
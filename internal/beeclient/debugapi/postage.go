@@ -81,3 +81,29 @@ func (ps *PostageService) PostageBatches(ctx context.Context) ([]PostageStampRes
 	}
 	return resp.Stamps, nil
 }
+
+type ChainStateResponse struct {
+	Block        uint64         `json:"block"`
+	TotalAmount  *bigint.BigInt `json:"totalAmount"`
+	CurrentPrice *bigint.BigInt `json:"currentPrice"`
+}
+
+// ChainState fetches the postage contract state known by the node
+func (ps *PostageService) ChainState(ctx context.Context) (ChainStateResponse, error) {
+	var resp ChainStateResponse
+	err := ps.debugAPI.C.Request(ctx, http.MethodGet, "/chainstate", nil, &resp)
+	return resp, err
+}
+
+// TopUpPostageBatch adds the amount per chunk to the batch balance
+func (ps *PostageService) TopUpPostageBatch(ctx context.Context, batchID string, amount int64, o PostageOptions) error {
+	h := http.Header{}
+
+	if o.GasPrice != "" {
+		h.Add(api.GasPriceHeader, o.GasPrice)
+	}
+
+	url := fmt.Sprintf("/stamps/topup/%s/%d", batchID, amount)
+	var resp postageResponse
+	return ps.debugAPI.C.RequestWithHeader(ctx, http.MethodPatch, url, h, nil, &resp)
+}
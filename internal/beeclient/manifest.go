@@ -0,0 +1,239 @@
+package beeclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/r0qs/beezim/internal/beeclient/api"
+
+	"github.com/ethersphere/bee/pkg/manifest"
+	"github.com/ethersphere/bee/pkg/manifest/mantaray"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// Alias is a path added to a manifest, serving the file of the manifest
+// at Target.
+type Alias struct {
+	Path   string
+	Target string
+	// Root, when set, is the manifest Target is looked up in instead, e.g.
+	// that of another upload whose file is then served without being
+	// uploaded again.
+	Root swarm.Address
+}
+
+// AddAliases adds the aliases to the manifest at root and returns the
+// root of the manifest with them, along with the aliases whose target
+// is not in the manifest, which are left out. The nodes of the manifest
+// changed are uploaded with the options.
+func (c *BeeClient) AddAliases(ctx context.Context, root swarm.Address, aliases []Alias, o api.UploadOptions) (swarm.Address, []Alias, error) {
+	return addAliases(ctx, root, aliases, &manifestStore{c: c, o: o})
+}
+
+// addAliases adds the aliases to the manifest at root, its nodes being
+// loaded and saved with ls.
+func addAliases(ctx context.Context, root swarm.Address, aliases []Alias, ls mantaray.LoadSaver) (swarm.Address, []Alias, error) {
+	// the nodes loaded by a lookup are not saved again once changed, so
+	// the targets are looked up in tries of their own
+	lookups := make(map[string]*mantaray.Node)
+	trie := mantaray.NewNodeRef(root.Bytes())
+	var missing []Alias
+	for _, a := range aliases {
+		from := root
+		if !a.Root.IsZero() {
+			from = a.Root
+		}
+		lookup, ok := lookups[from.String()]
+		if !ok {
+			lookup = mantaray.NewNodeRef(from.Bytes())
+			lookups[from.String()] = lookup
+		}
+		target, err := lookup.LookupNode(ctx, []byte(a.Target), ls)
+		if errors.Is(err, mantaray.ErrNotFound) || err == nil && !target.IsValueType() {
+			missing = append(missing, a)
+			continue
+		}
+		if err != nil {
+			return swarm.Address{}, nil, fmt.Errorf("manifest %s: looking up %s: %w", from, a.Target, err)
+		}
+		metadata := make(map[string]string, len(target.Metadata()))
+		for k, v := range target.Metadata() {
+			metadata[k] = v
+		}
+		if _, ok := metadata[manifest.EntryMetadataFilenameKey]; ok {
+			metadata[manifest.EntryMetadataFilenameKey] = path.Base(a.Path)
+		}
+		if err := trie.Add(ctx, []byte(a.Path), target.Entry(), metadata, ls); err != nil {
+			return swarm.Address{}, nil, fmt.Errorf("manifest %s: adding %s: %w", root, a.Path, err)
+		}
+	}
+	if len(missing) == len(aliases) {
+		return root, missing, nil
+	}
+	if err := trie.Save(ctx, ls); err != nil {
+		return swarm.Address{}, nil, fmt.Errorf("manifest %s: saving: %w", root, err)
+	}
+	return swarm.NewAddress(trie.Reference()), missing, nil
+}
+
+// FileReferences returns the references of the files at the paths of the
+// manifest at root, by path, leaving out the paths that are not files of
+// the manifest.
+func (c *BeeClient) FileReferences(ctx context.Context, root swarm.Address, paths []string) (map[string]swarm.Address, error) {
+	return fileReferences(ctx, root, paths, &manifestStore{c: c})
+}
+
+// fileReferences returns the references of the files at the paths of the
+// manifest at root, its nodes being loaded with ls.
+func fileReferences(ctx context.Context, root swarm.Address, paths []string, ls mantaray.LoadSaver) (map[string]swarm.Address, error) {
+	lookup := mantaray.NewNodeRef(root.Bytes())
+	refs := make(map[string]swarm.Address, len(paths))
+	for _, p := range paths {
+		n, err := lookup.LookupNode(ctx, []byte(p), ls)
+		if errors.Is(err, mantaray.ErrNotFound) || err == nil && !n.IsValueType() {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("manifest %s: looking up %s: %w", root, p, err)
+		}
+		refs[p] = swarm.NewAddress(n.Entry())
+	}
+	return refs, nil
+}
+
+// ContentType is the MIME type of a file of a manifest, which the node
+// serves as its Content-Type.
+type ContentType struct {
+	Path string
+	Type string
+}
+
+// SetContentTypes gives the files of the manifest at root their type, and
+// returns the root of the manifest with them, along with the number of
+// files changed. The files not in the manifest, or whose type is the same
+// but for its parameters, are left as they are. The nodes of the
+// manifest are uploaded again with the options.
+func (c *BeeClient) SetContentTypes(ctx context.Context, root swarm.Address, types []ContentType, o api.UploadOptions) (swarm.Address, int, error) {
+	return setContentTypes(ctx, root, types, &manifestStore{c: c, o: o})
+}
+
+// setContentTypes gives the files of the manifest at root their type, its
+// nodes being loaded and saved with ls. The manifest is built again with
+// the files of the old one: mantaray can not save the nodes of a trie
+// loaded whose metadata changed in place.
+func setContentTypes(ctx context.Context, root swarm.Address, types []ContentType, ls mantaray.LoadSaver) (swarm.Address, int, error) {
+	byPath := make(map[string]string, len(types))
+	for _, t := range types {
+		byPath[t.Path] = t.Type
+	}
+	m, err := manifest.NewDefaultManifest(ls, false)
+	if err != nil {
+		return swarm.Address{}, 0, err
+	}
+	changed := 0
+	src := mantaray.NewNodeRef(root.Bytes())
+	err = src.WalkNode(ctx, nil, ls, func(p []byte, n *mantaray.Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if !n.IsValueType() {
+			return nil
+		}
+		metadata := n.Metadata()
+		if t, ok := byPath[string(p)]; ok && !sameType(metadata[manifest.EntryMetadataContentTypeKey], t) {
+			metadata = make(map[string]string, len(n.Metadata())+1)
+			for k, v := range n.Metadata() {
+				metadata[k] = v
+			}
+			metadata[manifest.EntryMetadataContentTypeKey] = t
+			changed++
+		}
+		return m.Add(ctx, string(p), manifest.NewEntry(swarm.NewAddress(n.Entry()), metadata))
+	})
+	if err != nil {
+		return swarm.Address{}, 0, fmt.Errorf("manifest %s: setting the content types: %w", root, err)
+	}
+	if changed == 0 {
+		return root, 0, nil
+	}
+	ref, err := m.Store(ctx)
+	if err != nil {
+		return swarm.Address{}, 0, fmt.Errorf("manifest %s: saving: %w", root, err)
+	}
+	return ref, changed, nil
+}
+
+// sameType reports whether the MIME types are the same without their
+// parameters, e.g. text/html and text/html; charset=utf-8.
+func sameType(a, b string) bool {
+	a, _, _ = strings.Cut(a, ";")
+	b, _, _ = strings.Cut(b, ";")
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+// MergeManifests adds the files of the manifests at roots to the first
+// one, the files of the later ones replacing those at the same path, and
+// returns the root of the manifest serving them all. The metadata of the
+// first root, e.g. its index document, is kept. The nodes of the manifest
+// changed are uploaded with the options.
+func (c *BeeClient) MergeManifests(ctx context.Context, roots []swarm.Address, o api.UploadOptions) (swarm.Address, error) {
+	if len(roots) == 0 {
+		return swarm.Address{}, errors.New("no manifest to merge")
+	}
+	ls := &manifestStore{c: c, o: o}
+	trie := mantaray.NewNodeRef(roots[0].Bytes())
+	for _, root := range roots[1:] {
+		src := mantaray.NewNodeRef(root.Bytes())
+		err := src.WalkNode(ctx, nil, ls, func(p []byte, n *mantaray.Node, err error) error {
+			if err != nil {
+				return err
+			}
+			if !n.IsValueType() || string(p) == manifest.RootPath {
+				return nil
+			}
+			return trie.Add(ctx, p, n.Entry(), n.Metadata(), ls)
+		})
+		if err != nil {
+			return swarm.Address{}, fmt.Errorf("manifest %s: merging %s: %w", roots[0], root, err)
+		}
+	}
+	if len(roots) == 1 {
+		return roots[0], nil
+	}
+	if err := trie.Save(ctx, ls); err != nil {
+		return swarm.Address{}, fmt.Errorf("manifest %s: saving: %w", roots[0], err)
+	}
+	return swarm.NewAddress(trie.Reference()), nil
+}
+
+// manifestStore loads and saves the nodes of a manifest as bytes of the
+// node.
+type manifestStore struct {
+	c *BeeClient
+	o api.UploadOptions
+}
+
+func (s *manifestStore) Load(ctx context.Context, ref []byte) ([]byte, error) {
+	r, err := s.c.DownloadBytes(ctx, swarm.NewAddress(ref))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (s *manifestStore) Save(ctx context.Context, data []byte) ([]byte, error) {
+	addr, err := s.c.UploadBytes(ctx, bytes.NewReader(data), s.o)
+	if err != nil {
+		return nil, err
+	}
+	if addr.IsZero() {
+		return nil, errors.New("upload of a manifest node returned no reference")
+	}
+	return addr.Bytes(), nil
+}
@@ -0,0 +1,220 @@
+package beeclient
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/r0qs/beezim/internal/beeclient/api"
+	"github.com/r0qs/beezim/internal/tarball"
+
+	"github.com/ethersphere/bee/pkg/file/pipeline"
+	"github.com/ethersphere/bee/pkg/file/pipeline/bmt"
+	"github.com/ethersphere/bee/pkg/file/pipeline/feeder"
+	"github.com/ethersphere/bee/pkg/file/pipeline/hashtrie"
+	"github.com/ethersphere/bee/pkg/manifest"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// LocalCollection is the manifest a node makes of a tar uploaded as a
+// collection, computed without one.
+type LocalCollection struct {
+	// Root is the reference of the manifest, with the aliases.
+	Root swarm.Address
+	// Files are the regular files of the tar the manifest serves, in
+	// their order in the tar.
+	Files []LocalFile
+	// Missing are the aliases whose target is not in the manifest, left
+	// out as by AddAliases.
+	Missing []Alias
+	// Typed is the number of files given another type, as by
+	// SetContentTypes.
+	Typed int
+}
+
+// LocalFile is a file of a LocalCollection.
+type LocalFile struct {
+	Path      string
+	Reference swarm.Address
+}
+
+// LocalReference returns the manifest the node makes of the tar, gzipped
+// or not, uploaded as a collection with the options, then given the types
+// as by SetContentTypes and the aliases as by AddAliases. The chunks are
+// hashed as the node splits them, unencrypted, but neither stored nor
+// uploaded.
+func LocalReference(ctx context.Context, tarPath string, o api.UploadCollectionOptions, types []ContentType, aliases []Alias) (*LocalCollection, error) {
+	if strings.ContainsRune(o.IndexDocumentHeader, '/') {
+		return nil, errors.New("index document suffix must not include slash character")
+	}
+	r, err := tarball.Open(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	ls := newLocalStore()
+	m, err := manifest.NewDefaultManifest(ls, false)
+	if err != nil {
+		return nil, err
+	}
+	c := &LocalCollection{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read tar %s: %w", tarPath, err)
+		}
+		// the global headers are left out of the uploads, see
+		// tarball.ReadTarBuffer
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		// the paths and metadata of the node, see storeDir of its api,
+		// running on unix
+		info := hdr.FileInfo()
+		p := path.Clean(hdr.Name)
+		if p == "." || !info.Mode().IsRegular() {
+			continue
+		}
+		ref, err := hashData(ctx, tr)
+		if err != nil {
+			return nil, fmt.Errorf("hash %s: %w", p, err)
+		}
+		metadata := map[string]string{
+			manifest.EntryMetadataContentTypeKey: mime.TypeByExtension(filepath.Ext(hdr.Name)),
+			manifest.EntryMetadataFilenameKey:    info.Name(),
+		}
+		if err := m.Add(ctx, p, manifest.NewEntry(ref, metadata)); err != nil {
+			return nil, fmt.Errorf("add %s to manifest: %w", p, err)
+		}
+		c.Files = append(c.Files, LocalFile{Path: p, Reference: ref})
+	}
+	if len(c.Files) == 0 {
+		return nil, fmt.Errorf("no files in tar %s", tarPath)
+	}
+	if o.IndexDocumentHeader != "" || o.ErrorDocumentHeader != "" {
+		metadata := map[string]string{}
+		if o.IndexDocumentHeader != "" {
+			metadata[manifest.WebsiteIndexDocumentSuffixKey] = o.IndexDocumentHeader
+		}
+		if o.ErrorDocumentHeader != "" {
+			metadata[manifest.WebsiteErrorDocumentPathKey] = o.ErrorDocumentHeader
+		}
+		if err := m.Add(ctx, manifest.RootPath, manifest.NewEntry(swarm.ZeroAddress, metadata)); err != nil {
+			return nil, fmt.Errorf("add %s to manifest: %w", manifest.RootPath, err)
+		}
+	}
+	c.Root, err = m.Store(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("store manifest: %w", err)
+	}
+	if len(types) > 0 {
+		c.Root, c.Typed, err = setContentTypes(ctx, c.Root, types, ls)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(aliases) > 0 {
+		c.Root, c.Missing, err = addAliases(ctx, c.Root, aliases, ls)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// DataReference returns the reference the node gives the data of a file,
+// unencrypted, computed without uploading it.
+func DataReference(ctx context.Context, r io.Reader) (swarm.Address, error) {
+	return hashData(ctx, r)
+}
+
+// hashData feeds the data to the splitter of the node, without the
+// writer storing its chunks, and returns the root of their trie.
+func hashData(ctx context.Context, r io.Reader) (swarm.Address, error) {
+	p := newHashPipeline()
+	data := make([]byte, swarm.ChunkSize)
+	for {
+		n, err := r.Read(data)
+		if n > 0 {
+			if _, err := p.Write(data[:n]); err != nil {
+				return swarm.ZeroAddress, err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return swarm.ZeroAddress, err
+		}
+		if err := ctx.Err(); err != nil {
+			return swarm.ZeroAddress, err
+		}
+	}
+	sum, err := p.Sum()
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	return swarm.NewAddress(sum), nil
+}
+
+// newHashPipeline returns the pipeline of the node for unencrypted
+// uploads without its store writers.
+func newHashPipeline() pipeline.Interface {
+	tw := hashtrie.NewHashTrieWriter(swarm.ChunkSize, swarm.Branches, swarm.HashSize, func() pipeline.ChainWriter {
+		return bmt.NewBmtWriter(endWriter{})
+	})
+	return feeder.NewChunkFeederWriter(swarm.ChunkSize, bmt.NewBmtWriter(tw))
+}
+
+// endWriter ends the short pipelines of the intermediate chunks, whose
+// reference is read by the hash trie once hashed.
+type endWriter struct{}
+
+func (endWriter) ChainWrite(*pipeline.PipeWriteArgs) error { return nil }
+func (endWriter) Sum() ([]byte, error)                     { return nil, nil }
+
+// localStore saves the nodes of a manifest in memory at their reference
+// as bytes, for them to be loaded again, e.g. to add aliases. The forks
+// of a node are saved concurrently.
+type localStore struct {
+	mu    sync.Mutex
+	nodes map[string][]byte
+}
+
+func newLocalStore() *localStore {
+	return &localStore{nodes: make(map[string][]byte)}
+}
+
+func (s *localStore) Load(_ context.Context, ref []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.nodes[string(ref)]
+	if !ok {
+		return nil, fmt.Errorf("manifest node %s not saved", swarm.NewAddress(ref))
+	}
+	return data, nil
+}
+
+func (s *localStore) Save(ctx context.Context, data []byte) ([]byte, error) {
+	addr, err := hashData(ctx, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodes[string(addr.Bytes())] = bytes.Clone(data)
+	return addr.Bytes(), nil
+}
@@ -0,0 +1,103 @@
+package catalog
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// DefaultCacheTTL is how long a cached catalog is considered fresh.
+const DefaultCacheTTL = 24 * time.Hour
+
+// Cache keeps a local copy of the catalog to avoid fetching it
+// on every run.
+type Cache struct {
+	Path string
+	TTL  time.Duration
+	URL  string
+	// Logger receives the log output, the default logger when nil.
+	Logger logging.Logger
+}
+
+// NewCache returns a catalog cache stored in the given file.
+func NewCache(cacheFile string, ttl time.Duration) *Cache {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return &Cache{
+		Path: cacheFile,
+		TTL:  ttl,
+		URL:  KiwixCatalogURL,
+	}
+}
+
+// Entries returns the cached catalog entries, refreshing the cache
+// from the remote catalog when it is missing or expired.
+func (c *Cache) Entries(ctx context.Context) ([]Entry, error) {
+	if c.fresh() {
+		f, err := os.Open(c.Path)
+		if err == nil {
+			defer f.Close()
+			return Parse(f)
+		}
+	}
+
+	if err := c.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(c.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Refresh downloads the remote catalog and replaces the cached copy.
+func (c *Cache) Refresh(ctx context.Context) error {
+	logging.Or(c.Logger).Info("fetching catalog", "url", c.URL)
+
+	data, err := fetchRaw(ctx, c.URL)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0755); err != nil {
+		return err
+	}
+
+	// write to a temporary file first so an interrupted download
+	// never leaves a truncated catalog behind
+	tmp := c.Path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, c.Path)
+}
+
+func (c *Cache) fresh() bool {
+	info, err := os.Stat(c.Path)
+	if err != nil {
+		return false
+	}
+	return time.Since(info.ModTime()) < c.TTL
+}
@@ -0,0 +1,241 @@
+package catalog
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// KiwixCatalogURL is the OPDS v2 catalog of all ZIMs published by Kiwix.
+	KiwixCatalogURL = "https://library.kiwix.org/catalog/v2/entries?count=-1"
+
+	acquisitionRel = "http://opds-spec.org/acquisition/open-access"
+	metalinkSuffix = ".meta4"
+)
+
+// Entry represents a ZIM file published in the catalog.
+type Entry struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	Language    string    `json:"language"`
+	Category    string    `json:"category"`
+	Flavour     string    `json:"flavour,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Size        int64     `json:"size"`
+	Date        time.Time `json:"date"`
+	URL         string    `json:"url"`
+}
+
+// FileName returns the name of the ZIM file pointed by the entry URL.
+func (e Entry) FileName() string {
+	return path.Base(e.URL)
+}
+
+type opdsFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Entries []opdsEntry `xml:"entry"`
+}
+
+type opdsEntry struct {
+	ID       string     `xml:"id"`
+	Title    string     `xml:"title"`
+	Updated  string     `xml:"updated"`
+	Summary  string     `xml:"summary"`
+	Language string     `xml:"language"`
+	Name     string     `xml:"name"`
+	Flavour  string     `xml:"flavour"`
+	Category string     `xml:"category"`
+	Tags     string     `xml:"tags"`
+	Links    []opdsLink `xml:"link"`
+}
+
+type opdsLink struct {
+	Rel    string `xml:"rel,attr"`
+	Type   string `xml:"type,attr"`
+	Href   string `xml:"href,attr"`
+	Length int64  `xml:"length,attr"`
+}
+
+// Parse decodes an OPDS catalog feed and returns its ZIM entries.
+// Entries without a download link are ignored.
+func Parse(r io.Reader) ([]Entry, error) {
+	var feed opdsFeed
+	if err := xml.NewDecoder(r).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("error decoding catalog: %v", err)
+	}
+
+	entries := make([]Entry, 0, len(feed.Entries))
+	for _, oe := range feed.Entries {
+		e := Entry{
+			ID:          strings.TrimPrefix(oe.ID, "urn:uuid:"),
+			Name:        oe.Name,
+			Title:       oe.Title,
+			Description: oe.Summary,
+			Language:    oe.Language,
+			Category:    oe.Category,
+			Flavour:     oe.Flavour,
+		}
+		if oe.Tags != "" {
+			e.Tags = strings.Split(oe.Tags, ";")
+		}
+		if t, err := time.Parse(time.RFC3339, oe.Updated); err == nil {
+			e.Date = t
+		}
+
+		for _, l := range oe.Links {
+			if l.Rel == acquisitionRel {
+				// Kiwix links to a metalink file, the ZIM is served
+				// by the mirrors at the same path without the suffix.
+				e.URL = strings.TrimSuffix(l.Href, metalinkSuffix)
+				e.Size = l.Length
+				break
+			}
+		}
+		if e.URL == "" {
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// Fetch downloads and parses the catalog from the given url.
+func Fetch(ctx context.Context, catalogURL string) ([]Entry, error) {
+	data, err := fetchRaw(ctx, catalogURL)
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+
+	return Parse(data)
+}
+
+func fetchRaw(ctx context.Context, catalogURL string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, catalogURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching catalog: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("error fetching catalog: %v [status: %v]", catalogURL, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// Filter selects catalog entries. Empty fields match everything.
+type Filter struct {
+	// Language is the ISO-639-3 code of the ZIM language (e.g. "eng").
+	// Multiple languages may be given separated by comma.
+	Language string
+	Category string
+	// Name is a glob pattern matched against the entry name (e.g. "wikipedia_en_*")
+	// or the ZIM file name.
+	Name string
+}
+
+// Match reports whether the entry satisfies the filter.
+func (f Filter) Match(e Entry) bool {
+	if f.Language != "" && !matchLanguage(f.Language, e.Language) {
+		return false
+	}
+	if f.Category != "" && !strings.EqualFold(f.Category, e.Category) {
+		return false
+	}
+	if f.Name != "" && !matchName(f.Name, e) {
+		return false
+	}
+	return true
+}
+
+func matchLanguage(want, langs string) bool {
+	for _, l := range strings.Split(langs, ",") {
+		for _, w := range strings.Split(want, ",") {
+			if strings.EqualFold(strings.TrimSpace(w), l) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchName(pattern string, e Entry) bool {
+	// patterns without wildcards are treated as substrings
+	if !strings.ContainsAny(pattern, "*?[") {
+		return strings.Contains(e.Name, pattern) || strings.Contains(e.FileName(), pattern)
+	}
+
+	for _, name := range []string{e.Name, e.FileName()} {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Select returns all entries matching the filter sorted by name
+// and date, newest first.
+func Select(entries []Entry, f Filter) []Entry {
+	var selected []Entry
+	for _, e := range entries {
+		if f.Match(e) {
+			selected = append(selected, e)
+		}
+	}
+
+	sort.SliceStable(selected, func(i, j int) bool {
+		if selected[i].Name != selected[j].Name {
+			return selected[i].Name < selected[j].Name
+		}
+		return selected[i].Date.After(selected[j].Date)
+	})
+	return selected
+}
+
+// Latest returns the newest entry matching the filter.
+func Latest(entries []Entry, f Filter) (Entry, error) {
+	var latest Entry
+	found := false
+	for _, e := range Select(entries, f) {
+		if !found || e.Date.After(latest.Date) {
+			latest = e
+			found = true
+		}
+	}
+
+	if !found {
+		return Entry{}, fmt.Errorf("no catalog entry found matching %q", f.Name)
+	}
+	return latest, nil
+}
+
+// WikiName returns the name of the wiki of a ZIM file: its file name
+// without the extension and the release date, e.g.
+// wikipedia_en_climate_change_mini for
+// wikipedia_en_climate_change_mini_2022-03.zim.
+func WikiName(fileName string) string {
+	name := strings.TrimSuffix(path.Base(fileName), ".zim")
+	i := strings.LastIndex(name, "_")
+	if i < 0 {
+		return name
+	}
+	if _, err := time.Parse("2006-01", name[i+1:]); err != nil {
+		return name
+	}
+	return name[:i]
+}
@@ -0,0 +1,131 @@
+package catalog
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readCatalog parses the OPDS feed of testdata/catalog.xml.
+func readCatalog(t *testing.T) []Entry {
+	t.Helper()
+	f, err := os.Open("testdata/catalog.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	entries, err := Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestParse(t *testing.T) {
+	entries := readCatalog(t)
+	var files []string
+	for _, e := range entries {
+		files = append(files, e.FileName())
+	}
+	// the entry without a download link is left out
+	want := []string{
+		"wikipedia_en_climate_change_mini_2022-03.zim",
+		"wikipedia_en_climate_change_mini_2022-05.zim",
+		"wikipedia_fr_all_maxi_2022-04.zim",
+		"wiktionary_mul_all_nopic_2022-02.zim",
+	}
+	if !slices.Equal(files, want) {
+		t.Fatalf("got files %v, want %v", files, want)
+	}
+
+	e := entries[0]
+	if e.ID != "1f2b5a3c-0d4e-4f6a-8b7c-9d0e1f2a3b4c" {
+		t.Errorf("id %q, want it without urn:uuid:", e.ID)
+	}
+	if e.URL != "https://download.kiwix.org/zim/wikipedia/wikipedia_en_climate_change_mini_2022-03.zim" {
+		t.Errorf("url %q, want the zim of the metalink", e.URL)
+	}
+	if e.Name != "wikipedia_en_climate_change" || e.Flavour != "mini" || e.Category != "wikipedia" || e.Language != "eng" {
+		t.Errorf("got name %q, flavour %q, category %q, language %q", e.Name, e.Flavour, e.Category, e.Language)
+	}
+	if e.Title != "Climate Change" || e.Description != "Wikipedia articles about climate change" {
+		t.Errorf("got title %q, description %q", e.Title, e.Description)
+	}
+	if e.Size != 7905261 {
+		t.Errorf("size %d, want 7905261", e.Size)
+	}
+	if !e.Date.Equal(time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("date %v, want 2022-03-01", e.Date)
+	}
+	if !slices.Equal(e.Tags, []string{"wikipedia", "_category:wikipedia", "_pictures:no", "_videos:no"}) {
+		t.Errorf("tags %q", e.Tags)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	if _, err := Parse(strings.NewReader("<feed><entry>")); err == nil {
+		t.Error("a truncated feed parsed")
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	entries := readCatalog(t)
+	for _, tt := range []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"everything", Filter{}, []string{"wikipedia_en_climate_change", "wikipedia_en_climate_change", "wikipedia_fr_all", "wiktionary_mul_all"}},
+		{"language", Filter{Language: "fra"}, []string{"wikipedia_fr_all", "wiktionary_mul_all"}},
+		{"language case", Filter{Language: "ENG"}, []string{"wikipedia_en_climate_change", "wikipedia_en_climate_change", "wiktionary_mul_all"}},
+		{"languages", Filter{Language: "deu, fra"}, []string{"wikipedia_fr_all", "wiktionary_mul_all"}},
+		{"no language", Filter{Language: "deu"}, nil},
+		{"category", Filter{Category: "Wiktionary"}, []string{"wiktionary_mul_all"}},
+		// without wildcards, a substring of the name or of the file name
+		{"substring", Filter{Name: "climate"}, []string{"wikipedia_en_climate_change", "wikipedia_en_climate_change"}},
+		{"file substring", Filter{Name: "maxi_2022"}, []string{"wikipedia_fr_all"}},
+		// with wildcards, the whole name or file name
+		{"glob", Filter{Name: "wikipedia_*_all"}, []string{"wikipedia_fr_all"}},
+		{"glob prefix", Filter{Name: "wikipedia_en_*"}, []string{"wikipedia_en_climate_change", "wikipedia_en_climate_change"}},
+		{"glob file", Filter{Name: "*_2022-05.zim"}, []string{"wikipedia_en_climate_change"}},
+		{"glob not substring", Filter{Name: "climate*"}, nil},
+		{"all fields", Filter{Language: "eng,fra", Category: "wikipedia", Name: "wikipedia_*"}, []string{"wikipedia_en_climate_change", "wikipedia_en_climate_change", "wikipedia_fr_all"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, e := range entries {
+				if tt.filter.Match(e) {
+					got = append(got, e.Name)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("%+v matched %v, want %v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLatest(t *testing.T) {
+	entries := readCatalog(t)
+	e, err := Latest(entries, Filter{Name: "wikipedia_en_climate_change"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.FileName() != "wikipedia_en_climate_change_mini_2022-05.zim" {
+		t.Errorf("latest %s, want the release of 2022-05", e.FileName())
+	}
+	// the newest of several wikis
+	if e, err := Latest(entries, Filter{Language: "fra"}); err != nil || e.Name != "wikipedia_fr_all" {
+		t.Errorf("latest in fra %s, %v, want wikipedia_fr_all", e.Name, err)
+	}
+	if _, err := Latest(entries, Filter{Name: "gutenberg"}); err == nil {
+		t.Error("got an entry without a download link")
+	}
+
+	sel := Select(entries, Filter{Name: "climate"})
+	if len(sel) != 2 || !sel[0].Date.After(sel[1].Date) {
+		t.Errorf("selected %v, want the two releases newest first", sel)
+	}
+}
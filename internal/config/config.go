@@ -0,0 +1,173 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds option values loaded from a configuration file. The keys
+// of the option sections are the names of the command-line flags.
+//
+//	global:
+//	  batch-id: ${BEE_BATCH_ID}
+//	  enable-search: true
+//	wikis:
+//	  - match: "wikipedia_en_*"
+//	    options:
+//	      enable-search: false
+type Config struct {
+	Global map[string]interface{} `yaml:"global"`
+	Wikis  []WikiOverride         `yaml:"wikis"`
+}
+
+// WikiOverride replaces global options for the ZIMs whose file name
+// matches the glob pattern.
+type WikiOverride struct {
+	Match   string                 `yaml:"match"`
+	Options map[string]interface{} `yaml:"options"`
+}
+
+// Value is a resolved option value and the section where it was defined.
+type Value struct {
+	Value  string
+	Source string
+}
+
+// Load reads the configuration file, expanding the ${VAR} references of
+// its string values to environment variables. Every other $ is kept as
+// is. Unknown sections are reported as errors.
+func Load(configFile string) (*Config, error) {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	// an empty document is a valid, empty, configuration
+	if err := dec.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error parsing config %s: %v", configFile, err)
+	}
+
+	expandMap(c.Global)
+	for i := range c.Wikis {
+		c.Wikis[i].Match = expandEnv(c.Wikis[i].Match)
+		expandMap(c.Wikis[i].Options)
+	}
+
+	for i, w := range c.Wikis {
+		if w.Match == "" {
+			return nil, fmt.Errorf("error parsing config %s: wikis[%d] has no match pattern", configFile, i)
+		}
+		if _, err := path.Match(w.Match, ""); err != nil {
+			return nil, fmt.Errorf("error parsing config %s: invalid pattern %q: %v", configFile, w.Match, err)
+		}
+	}
+	return &c, nil
+}
+
+// envRef matches the ${VAR} references expanded by Load.
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces the ${VAR} references of s by the value of the
+// environment variables.
+func expandEnv(s string) string {
+	return envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		return os.Getenv(ref[2 : len(ref)-1])
+	})
+}
+
+// expandMap expands the references of the string values of opts, and of
+// those nested in lists and maps.
+func expandMap(opts map[string]interface{}) {
+	for k, v := range opts {
+		opts[k] = expandValue(v)
+	}
+}
+
+func expandValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case string:
+		return expandEnv(t)
+	case []interface{}:
+		for i, item := range t {
+			t[i] = expandValue(item)
+		}
+	case map[string]interface{}:
+		expandMap(t)
+	}
+	return v
+}
+
+// Validate reports the options not present in the known set.
+func (c *Config) Validate(known func(name string) bool) error {
+	var unknown []string
+	check := func(section string, opts map[string]interface{}) {
+		for k := range opts {
+			if !known(k) {
+				unknown = append(unknown, fmt.Sprintf("%s: %s", section, k))
+			}
+		}
+	}
+
+	check("global", c.Global)
+	for _, w := range c.Wikis {
+		check(fmt.Sprintf("wikis[%s]", w.Match), w.Options)
+	}
+
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown options in config: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// Resolve returns the options for the given ZIM file name. Per-wiki
+// overrides are applied over the global section in the order they are
+// defined, so the last matching section wins.
+func (c *Config) Resolve(zimName string) map[string]Value {
+	opts := make(map[string]Value)
+	for k, v := range c.Global {
+		opts[k] = Value{Value: toString(v), Source: "global"}
+	}
+
+	if zimName == "" {
+		return opts
+	}
+
+	for _, w := range c.Wikis {
+		if ok, _ := path.Match(w.Match, zimName); !ok {
+			continue
+		}
+		for k, v := range w.Options {
+			opts[k] = Value{Value: toString(v), Source: fmt.Sprintf("wikis[%s]", w.Match)}
+		}
+	}
+	return opts
+}
+
+// toString converts a yaml value to the string representation parsed by flags.
+func toString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		items := make([]string, len(t))
+		for i, item := range t {
+			items[i] = toString(item)
+		}
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(t)
+	}
+}
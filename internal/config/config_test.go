@@ -0,0 +1,152 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadExpandsEnv(t *testing.T) {
+	t.Setenv("BEEZIM_TEST_BATCH", "b47c")
+	t.Setenv("BEEZIM_TEST_WIKI", "wikipedia")
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	data := `# ${BEEZIM_TEST_UNSET} in a comment
+global:
+  batch-id: ${BEEZIM_TEST_BATCH}
+  notify-secret: pa$$word$BEEZIM_TEST_BATCH
+  notify-template: '{{.Reference}} costs $5, ${BEEZIM_TEST_UNSET}.'
+  notify-url:
+    - https://example.org/${BEEZIM_TEST_BATCH}
+    - https://example.org/$BEEZIM_TEST_BATCH
+  retries: 3
+wikis:
+  - match: "${BEEZIM_TEST_WIKI}_*"
+    options:
+      stamp: ${BEEZIM_TEST_BATCH}-$1
+`
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"batch-id":        "b47c",
+		"notify-secret":   "pa$$word$BEEZIM_TEST_BATCH",
+		"notify-template": "{{.Reference}} costs $5, .",
+		"notify-url":      "https://example.org/b47c,https://example.org/$BEEZIM_TEST_BATCH",
+		"retries":         "3",
+		"stamp":           "b47c-$1",
+	}
+	got := c.Resolve("wikipedia_en_top.zim")
+	if len(got) != len(want) {
+		t.Errorf("got %d options, want %d: %v", len(got), len(want), got)
+	}
+	for k, v := range want {
+		if got[k].Value != v {
+			t.Errorf("%s: got %q, want %q", k, got[k].Value, v)
+		}
+	}
+	if got["stamp"].Source != "wikis[wikipedia_*]" {
+		t.Errorf("stamp: got source %q, want %q", got["stamp"].Source, "wikis[wikipedia_*]")
+	}
+}
+
+// load writes the configuration to a file and loads it.
+func load(t *testing.T, data string) (*Config, error) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return Load(file)
+}
+
+func TestValidate(t *testing.T) {
+	c, err := load(t, `global:
+  batch-id: b47c
+  colour: blue
+wikis:
+  - match: "wikipedia_*"
+    options:
+      retry: 3
+      retries: 3
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	known := func(name string) bool { return name == "batch-id" || name == "retries" }
+	err = c.Validate(known)
+	want := "unknown options in config: global: colour, wikis[wikipedia_*]: retry"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+	if err := (&Config{Global: map[string]interface{}{"batch-id": "b47c"}}).Validate(known); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"unknown section": "wiki:\n  - match: \"*\"\n",
+		"unknown field":   "wikis:\n  - match: \"*\"\n    option:\n      retries: 3\n",
+		"no match":        "wikis:\n  - options:\n      retries: 3\n",
+		"invalid pattern": "wikis:\n  - match: \"wikipedia_[\"\n",
+	} {
+		if _, err := load(t, data); err == nil {
+			t.Errorf("%s: loaded %q", name, data)
+		}
+	}
+	c, err := load(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts := c.Resolve("wikipedia_en_all.zim"); len(opts) != 0 {
+		t.Errorf("empty config resolved %v", opts)
+	}
+}
+
+func TestResolveOrder(t *testing.T) {
+	c, err := load(t, `global:
+  kiwix: wiktionary
+  retries: 3
+wikis:
+  - match: "wikipedia_*"
+    options:
+      kiwix: wikipedia
+      retries: 5
+  - match: "*_en_*"
+    options:
+      kiwix: wikibooks
+  - match: "*_fr_*"
+    options:
+      kiwix: wikiversity
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		zim                    string
+		kiwix, kiwixSource     string
+		retries, retriesSource string
+	}{
+		{"", "wiktionary", "global", "3", "global"},
+		{"wiktionary_de_all.zim", "wiktionary", "global", "3", "global"},
+		{"wikipedia_de_all.zim", "wikipedia", "wikis[wikipedia_*]", "5", "wikis[wikipedia_*]"},
+		// the last section matching wins, the others still apply
+		{"wikipedia_en_all.zim", "wikibooks", "wikis[*_en_*]", "5", "wikis[wikipedia_*]"},
+		{"wikibooks_fr_all.zim", "wikiversity", "wikis[*_fr_*]", "3", "global"},
+	} {
+		opts := c.Resolve(tt.zim)
+		if got := opts["kiwix"]; got != (Value{tt.kiwix, tt.kiwixSource}) {
+			t.Errorf("%q: kiwix %+v, want %s of %s", tt.zim, got, tt.kiwix, tt.kiwixSource)
+		}
+		if got := opts["retries"]; got != (Value{tt.retries, tt.retriesSource}) {
+			t.Errorf("%q: retries %+v, want %s of %s", tt.zim, got, tt.retries, tt.retriesSource)
+		}
+	}
+}
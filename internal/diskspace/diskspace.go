@@ -0,0 +1,38 @@
+package diskspace
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnsupported is returned when the free space can not be queried
+// on the current platform.
+var ErrUnsupported = errors.New("disk space check not supported on this platform")
+
+// ErrNoSpace is returned when a filesystem has less space available than
+// required.
+var ErrNoSpace = errors.New("not enough disk space")
+
+// Available returns the number of bytes available to unprivileged
+// users on the filesystem containing path.
+func Available(path string) (uint64, error) {
+	return available(path)
+}
+
+// Check returns an error when the filesystem containing path has less
+// than required bytes available. Platforms where the free space can not
+// be queried are always considered to have enough space.
+func Check(path string, required uint64) error {
+	avail, err := Available(path)
+	if errors.Is(err, ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if avail < required {
+		return fmt.Errorf("%w in %s: required %d bytes, available %d bytes", ErrNoSpace, path, required, avail)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package diskspace
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	avail, err := Available(dir)
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if avail == 0 {
+		t.Fatalf("no space available in %s", dir)
+	}
+
+	if err := Check(dir, 1); err != nil {
+		t.Errorf("1 byte: %v", err)
+	}
+	if err := Check(dir, math.MaxUint64); !errors.Is(err, ErrNoSpace) {
+		t.Errorf("got %v, want %v", err, ErrNoSpace)
+	}
+	if _, err := Available(dir + "/missing"); err == nil {
+		t.Error("got the space of a missing directory")
+	}
+}
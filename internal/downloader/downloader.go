@@ -0,0 +1,336 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/r0qs/beezim/internal/diskspace"
+	"github.com/r0qs/beezim/internal/iobuf"
+	"github.com/r0qs/beezim/internal/progress"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+const (
+	partSuffix     = ".part"
+	stateSuffix    = ".part.json"
+	checksumSuffix = ".sha256"
+
+	defaultRetries    = 5
+	defaultRetryDelay = 2 * time.Second
+)
+
+var (
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	errNoChecksum       = errors.New("checksum file not published")
+	errNoRangeSupport   = errors.New("server does not support range requests")
+)
+
+// Options configures a download.
+type Options struct {
+	// Mirrors are alternative urls for the same file, tried in order
+	// when the main url fails.
+	Mirrors []string
+	// Retries is the number of attempts per url before trying the next mirror.
+	Retries    int
+	RetryDelay time.Duration
+	// VerifyChecksum fetches the .sha256 sidecar file published next to
+	// the file and verifies the download against it. The verification is
+	// skipped when no sidecar file is published.
+	VerifyChecksum bool
+	// Progress receives the bytes downloaded, none when nil. When the
+	// context carries a progress reporter the download is reported to it
+	// instead.
+	Progress   progress.Bar
+	HTTPClient *http.Client
+	// Buffers sets the copy buffer of the download, DownloadCopy, the
+	// default when zero.
+	Buffers iobuf.Config
+	// Logger receives the log output, the default logger when nil.
+	Logger logging.Logger
+}
+
+// state is persisted next to the partial download so an interrupted
+// download can be resumed.
+type state struct {
+	URL          string `json:"url"`
+	Size         int64  `json:"size"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// Download fetches the file at url into dstFile. Partial downloads are kept
+// next to dstFile and continued by subsequent calls with the same url.
+func Download(ctx context.Context, url string, dstFile string, o Options) error {
+	if o.Retries <= 0 {
+		o.Retries = defaultRetries
+	}
+	if o.RetryDelay <= 0 {
+		o.RetryDelay = defaultRetryDelay
+	}
+	if o.HTTPClient == nil {
+		o.HTTPClient = http.DefaultClient
+	}
+	if err := o.Buffers.Validate(); err != nil {
+		return err
+	}
+	o.Buffers = o.Buffers.WithDefaults()
+	o.Logger = logging.Or(o.Logger)
+
+	urls := append([]string{url}, o.Mirrors...)
+	var err error
+	for _, u := range urls {
+		if err = downloadWithRetry(ctx, u, dstFile, o); err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		o.Logger.Warn("download failed", "url", u, "err", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if o.VerifyChecksum {
+		if err := verify(ctx, urls, dstFile, o); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(dstFile+partSuffix, dstFile); err != nil {
+		return err
+	}
+	os.Remove(dstFile + stateSuffix)
+	return nil
+}
+
+func downloadWithRetry(ctx context.Context, url string, dstFile string, o Options) error {
+	var err error
+	for attempt := 1; attempt <= o.Retries; attempt++ {
+		if err = fetch(ctx, url, dstFile, o); err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || errors.Is(err, errNoRangeSupport) {
+			return err
+		}
+
+		o.Logger.Warn("download attempt failed", "attempt", attempt, "retries", o.Retries, "url", url, "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(o.RetryDelay * time.Duration(attempt)):
+		}
+	}
+	return err
+}
+
+// fetch downloads the remaining bytes of the file, appending to the
+// partial download when the remote file did not change.
+func fetch(ctx context.Context, url string, dstFile string, o Options) error {
+	partFile := dstFile + partSuffix
+	st, offset := loadState(dstFile, url)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if st.ETag != "" {
+			req.Header.Set("If-Range", st.ETag)
+		} else if st.LastModified != "" {
+			req.Header.Set("If-Range", st.LastModified)
+		}
+	}
+
+	resp, err := o.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		// the server sent the whole file, either because it is the
+		// first attempt or because the remote file changed
+		offset = 0
+		flags |= os.O_TRUNC
+		st = state{
+			URL:          url,
+			Size:         resp.ContentLength,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+		}
+		if err := checkSpace(dstFile, st.Size); err != nil {
+			return err
+		}
+		if err := saveState(dstFile, st); err != nil {
+			return err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// nothing left to download
+		if st.Size > 0 && offset == st.Size {
+			return nil
+		}
+		return errNoRangeSupport
+	default:
+		return fmt.Errorf("download failed: %v [status: %v]", url, resp.Status)
+	}
+
+	dest, err := os.OpenFile(partFile, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	var body io.Reader = resp.Body
+	if rep := progress.FromContext(ctx); rep != nil {
+		body = progress.NewReader(resp.Body, rep, "download", offset, st.Size)
+	} else if o.Progress != nil {
+		body = progress.NewReader(resp.Body, progress.BarReporter(o.Progress), "download", offset, st.Size)
+	}
+
+	n, err := iobuf.Copy(dest, body, o.Buffers.DownloadCopy)
+	if err != nil {
+		return err
+	}
+
+	if st.Size > 0 && offset+n != st.Size {
+		return fmt.Errorf("incomplete download: got %d of %d bytes", offset+n, st.Size)
+	}
+	return nil
+}
+
+func loadState(dstFile string, url string) (state, int64) {
+	var st state
+	data, err := os.ReadFile(dstFile + stateSuffix)
+	if err != nil {
+		return st, 0
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return state{}, 0
+	}
+
+	info, err := os.Stat(dstFile + partSuffix)
+	if err != nil {
+		return state{}, 0
+	}
+	// a partial download from another url may be a different file,
+	// unless it is validated by the same ETag
+	if st.URL != url && st.ETag == "" {
+		return state{}, 0
+	}
+	return st, info.Size()
+}
+
+func saveState(dstFile string, st state) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dstFile+stateSuffix, data, 0644)
+}
+
+func checkSpace(dstFile string, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	return diskspace.Check(filepath.Dir(dstFile), uint64(size))
+}
+
+// verify compares the partial download with the checksum published
+// in the sidecar file of any of the given urls.
+func verify(ctx context.Context, urls []string, dstFile string, o Options) error {
+	var expected string
+	var err error
+	for _, u := range urls {
+		if expected, err = fetchChecksum(ctx, u+checksumSuffix, o); err == nil {
+			break
+		}
+	}
+	if errors.Is(err, errNoChecksum) {
+		o.Logger.Warn("no checksum published, skipping verification", "file", filepath.Base(dstFile))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error fetching checksum: %v", err)
+	}
+
+	o.Logger.Info("verifying checksum", "file", filepath.Base(dstFile))
+	actual, err := fileChecksum(dstFile + partSuffix)
+	if err != nil {
+		return err
+	}
+
+	if !strings.EqualFold(expected, actual) {
+		// the partial file is useless, start over next time
+		os.Remove(dstFile + partSuffix)
+		os.Remove(dstFile + stateSuffix)
+		return fmt.Errorf("%w for %s: expected %s, got %s", ErrChecksumMismatch, filepath.Base(dstFile), expected, actual)
+	}
+	return nil
+}
+
+// fetchChecksum returns the hex encoded sha256 from a sidecar file
+// in the sha256sum format: "<hash>  <filename>".
+func fetchChecksum(ctx context.Context, url string, o Options) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := o.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", errNoChecksum
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%v [status: %v]", url, resp.Status)
+	}
+
+	line, err := bufio.NewReader(io.LimitReader(resp.Body, 1<<10)).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty checksum file %s", url)
+	}
+	if _, err := hex.DecodeString(fields[0]); err != nil || len(fields[0]) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid checksum in %s", url)
+	}
+	return fields[0], nil
+}
+
+func fileChecksum(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,246 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// content is the file served, large enough to be cut in the middle.
+var content = bytes.Repeat([]byte("0123456789abcdef"), 4096)
+
+// fileServer serves content at /wiki.zim with range requests and its
+// checksum at /wiki.zim.sha256, recording the requests.
+type fileServer struct {
+	etag string
+	// dropFirst cuts the first response in the middle of the body.
+	dropFirst bool
+
+	mu     sync.Mutex
+	ranges []string
+	sent   int
+}
+
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/wiki.zim.sha256":
+		sum := sha256.Sum256(content)
+		w.Write([]byte(hex.EncodeToString(sum[:]) + "  wiki.zim\n"))
+		return
+	case "/wiki.zim":
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	first := len(s.ranges) == 1
+	s.mu.Unlock()
+	if s.etag != "" {
+		w.Header().Set("ETag", s.etag)
+	}
+	if first && s.dropFirst {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Write(content[:len(content)/2])
+		s.count(len(content) / 2)
+		w.(http.Flusher).Flush()
+		// the connection is closed before the end of the body
+		panic(http.ErrAbortHandler)
+	}
+	cw := &countingWriter{ResponseWriter: w}
+	http.ServeContent(cw, r, "wiki.zim", time.Time{}, bytes.NewReader(content))
+	s.count(cw.n)
+}
+
+func (s *fileServer) count(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent += n
+}
+
+// requests returns the Range header of the requests of the file.
+func (s *fileServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ranges...)
+}
+
+// countingWriter counts the bytes of the file written, not those of the
+// errors.
+type countingWriter struct {
+	http.ResponseWriter
+	status int
+	n      int
+}
+
+func (w *countingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	if w.status < http.StatusBadRequest {
+		w.n += n
+	}
+	return n, err
+}
+
+// options returns the options of a download retrying right away.
+func options() Options {
+	return Options{Retries: 3, RetryDelay: time.Millisecond, Logger: logging.Discard()}
+}
+
+// checkDownloaded checks that dstFile holds content and that the files
+// of the partial download are gone.
+func checkDownloaded(t *testing.T, dstFile string) {
+	t.Helper()
+	data, err := os.ReadFile(dstFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("downloaded %d bytes differ from the %d served", len(data), len(content))
+	}
+	for _, suffix := range []string{partSuffix, stateSuffix} {
+		if _, err := os.Stat(dstFile + suffix); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s left after the download: %v", suffix, err)
+		}
+	}
+}
+
+func TestDownloadResumesDroppedConnection(t *testing.T) {
+	fs := &fileServer{etag: `"v1"`, dropFirst: true}
+	srv := httptest.NewServer(fs)
+	defer srv.Close()
+
+	dstFile := filepath.Join(t.TempDir(), "wiki.zim")
+	o := options()
+	o.VerifyChecksum = true
+	if err := Download(context.Background(), srv.URL+"/wiki.zim", dstFile, o); err != nil {
+		t.Fatal(err)
+	}
+	checkDownloaded(t, dstFile)
+
+	want := []string{"", "bytes=" + strconv.Itoa(len(content)/2) + "-"}
+	if got := fs.requests(); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got requests with ranges %q, want %q", got, want)
+	}
+	if fs.sent != len(content) {
+		t.Errorf("sent %d bytes, want each of the %d once", fs.sent, len(content))
+	}
+}
+
+func TestDownloadChecksumMismatch(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/wiki.zim", &fileServer{})
+	mux.HandleFunc("/wiki.zim.sha256", func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256([]byte("another file"))
+		w.Write([]byte(hex.EncodeToString(sum[:]) + "  wiki.zim\n"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dstFile := filepath.Join(t.TempDir(), "wiki.zim")
+	o := options()
+	o.VerifyChecksum = true
+	err := Download(context.Background(), srv.URL+"/wiki.zim", dstFile, o)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("got %v, want %v", err, ErrChecksumMismatch)
+	}
+	for _, p := range []string{dstFile, dstFile + partSuffix, dstFile + stateSuffix} {
+		if _, err := os.Stat(p); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s left after the mismatch: %v", filepath.Base(p), err)
+		}
+	}
+}
+
+// writePart writes a partial download of dstFile from url.
+func writePart(t *testing.T, dstFile string, url string, etag string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(dstFile+partSuffix, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveState(dstFile, state{URL: url, Size: int64(len(content)), ETag: etag}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDownloadAlreadyComplete(t *testing.T) {
+	fs := &fileServer{etag: `"v1"`}
+	srv := httptest.NewServer(fs)
+	defer srv.Close()
+
+	// the whole file was downloaded, but not renamed
+	dstFile := filepath.Join(t.TempDir(), "wiki.zim")
+	url := srv.URL + "/wiki.zim"
+	writePart(t, dstFile, url, `"v1"`, content)
+	if err := Download(context.Background(), url, dstFile, options()); err != nil {
+		t.Fatal(err)
+	}
+	checkDownloaded(t, dstFile)
+
+	// the range past the end is refused with 416, nothing is sent again
+	want := "bytes=" + strconv.Itoa(len(content)) + "-"
+	if got := fs.requests(); len(got) != 1 || got[0] != want {
+		t.Errorf("got requests with ranges %q, want %q", got, want)
+	}
+	if fs.sent != 0 {
+		t.Errorf("sent %d bytes again", fs.sent)
+	}
+}
+
+func TestDownloadMirrorWithPart(t *testing.T) {
+	const half = 1000
+	for _, tt := range []struct {
+		name string
+		// etag is that of the partial download of the main url
+		etag string
+		// data is the partial download
+		data []byte
+		// wantRange is the range asked to the mirror
+		wantRange string
+	}{
+		// the ETag tells it is the same file, whatever the url
+		{"same etag", `"v1"`, content[:half], "bytes=" + strconv.Itoa(half) + "-"},
+		// without an ETag, the part of another url may be another file
+		{"no etag", "", bytes.Repeat([]byte("x"), half), ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			}))
+			defer broken.Close()
+			fs := &fileServer{etag: `"v1"`}
+			mirror := httptest.NewServer(fs)
+			defer mirror.Close()
+
+			dstFile := filepath.Join(t.TempDir(), "wiki.zim")
+			url := broken.URL + "/wiki.zim"
+			writePart(t, dstFile, url, tt.etag, tt.data)
+			o := options()
+			o.Mirrors = []string{mirror.URL + "/wiki.zim"}
+			o.VerifyChecksum = true
+			if err := Download(context.Background(), url, dstFile, o); err != nil {
+				t.Fatal(err)
+			}
+			checkDownloaded(t, dstFile)
+			if got := fs.requests(); len(got) != 1 || got[0] != tt.wantRange {
+				t.Errorf("mirror got requests with ranges %q, want %q", got, tt.wantRange)
+			}
+		})
+	}
+}
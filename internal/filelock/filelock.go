@@ -0,0 +1,194 @@
+// Package filelock provides advisory locks on files, so that several
+// beezim processes, e.g. overlapping cron runs, can share a database and a
+// workdir. The locks are taken with flock on unix and LockFileEx on
+// Windows, and released by the system when their process dies. The lock
+// file records the process holding it.
+package filelock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrLocked is returned when the lock is held by another process.
+var ErrLocked = errors.New("locked")
+
+// errUnsupported is returned by lockFile on the platforms and filesystems
+// without file locks, where the lock is the record of its owner.
+var errUnsupported = errors.New("file locks not supported")
+
+// errBusy is returned by lockFile when the lock is held.
+var errBusy = errors.New("lock busy")
+
+// pollInterval is how often Acquire retries a held lock.
+const pollInterval = 250 * time.Millisecond
+
+// Owner is the process holding a lock.
+type Owner struct {
+	PID   int       `json:"pid"`
+	Host  string    `json:"host"`
+	Since time.Time `json:"since"`
+}
+
+func (o Owner) String() string {
+	if o.PID == 0 {
+		return "unknown process"
+	}
+	return fmt.Sprintf("pid %d on %s since %s", o.PID, o.Host, o.Since.Local().Format(time.RFC3339))
+}
+
+// Lock is a lock held by the process.
+type Lock struct {
+	path string
+	f    *os.File
+	// Stale is the owner recorded by a process which did not release the
+	// lock, e.g. it crashed, zero when the lock was released.
+	Stale Owner
+}
+
+// TryLock takes the lock of the file at path, created if needed, or fails
+// with ErrLocked when another process holds it. Where file locks are not
+// supported the recorded owner is the lock, broken when the owner is a
+// process of this host which is gone.
+func TryLock(path string) (*Lock, error) {
+	host, _ := os.Hostname()
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		prev, _ := readOwner(f)
+
+		err = lockFile(f)
+		if errors.Is(err, errUnsupported) {
+			err = nil
+			if prev.PID != 0 && (prev.Host != host || processAlive(prev.PID)) {
+				err = errBusy
+			}
+		}
+		if errors.Is(err, errBusy) {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w by %s", path, ErrLocked, prev)
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("locking %s: %w", path, err)
+		}
+
+		// the file may have been removed and created again, e.g. by a
+		// clean, since it was opened: the lock is then not the one of path
+		if !samePath(f, path) {
+			unlockFile(f)
+			f.Close()
+			continue
+		}
+
+		// the owner is read again, it may have changed while locking
+		if prev, err = readOwner(f); err != nil {
+			prev = Owner{}
+		}
+		owner := Owner{PID: os.Getpid(), Host: host, Since: time.Now().UTC()}
+		if err := writeOwner(f, owner); err != nil {
+			unlockFile(f)
+			f.Close()
+			return nil, fmt.Errorf("locking %s: %w", path, err)
+		}
+		return &Lock{path: path, f: f, Stale: prev}, nil
+	}
+}
+
+// Acquire takes the lock of the file at path, waiting for it until the
+// context is done.
+func Acquire(ctx context.Context, path string) (*Lock, error) {
+	for {
+		l, err := TryLock(path)
+		if !errors.Is(err, ErrLocked) {
+			return l, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w, waited %w", err, ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Path returns the path of the lock file.
+func (l *Lock) Path() string {
+	return l.path
+}
+
+// Unlock clears the owner and releases the lock. The file is left for the
+// next holder.
+func (l *Lock) Unlock() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	err := l.f.Truncate(0)
+	if uerr := unlockFile(l.f); err == nil {
+		err = uerr
+	}
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.f = nil
+	return err
+}
+
+// ReadOwner returns the owner recorded in the lock file at path, zero
+// when the lock is not held.
+func ReadOwner(path string) (Owner, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Owner{}, nil
+	}
+	if err != nil {
+		return Owner{}, err
+	}
+	defer f.Close()
+	return readOwner(f)
+}
+
+func readOwner(f *os.File) (Owner, error) {
+	var o Owner
+	data, err := io.ReadAll(io.NewSectionReader(f, 0, 1<<16))
+	if err != nil || len(data) == 0 {
+		return o, err
+	}
+	if err := json.Unmarshal(data, &o); err != nil {
+		return Owner{}, fmt.Errorf("reading lock %s: %w", f.Name(), err)
+	}
+	return o, nil
+}
+
+func writeOwner(f *os.File, o Owner) error {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(append(data, '\n'), 0); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// samePath reports whether the open file is still the one at path.
+func samePath(f *os.File, path string) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	pi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fi, pi)
+}
@@ -0,0 +1,114 @@
+package filelock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTryLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wiki.lock")
+	l, err := TryLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Stale.PID != 0 {
+		t.Errorf("stale owner %s of a new lock", l.Stale)
+	}
+	owner, err := ReadOwner(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner.PID != os.Getpid() {
+		t.Errorf("owner %s, want this process", owner)
+	}
+
+	// every lock opens the file again, which the lock of the file held
+	// excludes as that of another process
+	if _, err := TryLock(path); !errors.Is(err, ErrLocked) {
+		t.Fatalf("got %v, want %v", err, ErrLocked)
+	}
+	if err := l.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if owner, err := ReadOwner(path); err != nil || owner.PID != 0 {
+		t.Errorf("owner %s once unlocked: %v", owner, err)
+	}
+	if err := l.Unlock(); err != nil {
+		t.Errorf("second unlock: %v", err)
+	}
+	l, err = TryLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Unlock()
+}
+
+func TestAcquire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wiki.lock")
+	l, err := TryLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := Acquire(ctx, path); !errors.Is(err, ErrLocked) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v waited %v", err, ErrLocked, context.DeadlineExceeded)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		l.Unlock()
+	}()
+	waiter, err := Acquire(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiter.Unlock()
+}
+
+// TestStaleLock takes a lock whose owner did not release it, the file
+// still recording it.
+func TestStaleLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wiki.lock")
+	crashed := Owner{PID: 1 << 22, Host: "other", Since: time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeOwner(f, crashed); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	l, err := TryLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Unlock()
+	if l.Stale != crashed {
+		t.Errorf("stale owner %s, want %s", l.Stale, crashed)
+	}
+}
+
+// TestRemovedLock locks a file removed and created again while it was
+// held, e.g. by a clean: the lock is taken on the new file.
+func TestRemovedLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wiki.lock")
+	l, err := TryLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Unlock()
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	again, err := TryLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again.Unlock()
+}
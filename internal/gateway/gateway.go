@@ -0,0 +1,248 @@
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/r0qs/beezim/internal/progress"
+)
+
+const (
+	defaultInterval   = time.Second
+	defaultRetries    = 3
+	defaultMaxBackoff = time.Minute
+)
+
+// Check is a file expected to be served under the root.
+type Check struct {
+	// Path is relative to the root, empty for the index document.
+	Path   string
+	SHA256 string
+}
+
+// Options configures a Checker.
+type Options struct {
+	// Interval is the minimum time between two requests to the same gateway.
+	Interval time.Duration
+	// Retries is the number of attempts per path when the gateway asks to
+	// slow down (429) or fails with a server error.
+	Retries int
+	// MaxBackoff caps the time waited before retrying.
+	MaxBackoff time.Duration
+	HTTPClient *http.Client
+}
+
+// Failure is a path not served as expected.
+type Failure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// Report is the outcome of checking a root through a gateway.
+type Report struct {
+	Gateway string    `json:"gateway"`
+	Success bool      `json:"success"`
+	Checked int       `json:"checked"`
+	Failed  []Failure `json:"failed,omitempty"`
+	// AvgLatency and MaxLatency are the time to the first byte of the
+	// successful requests in milliseconds.
+	AvgLatency int64 `json:"avgLatencyMs"`
+	MaxLatency int64 `json:"maxLatencyMs"`
+}
+
+// Checker fetches content through public gateways with plain HTTP requests.
+type Checker struct {
+	opts Options
+}
+
+// New returns a gateway checker.
+func New(o Options) *Checker {
+	if o.Interval <= 0 {
+		o.Interval = defaultInterval
+	}
+	if o.Retries <= 0 {
+		o.Retries = defaultRetries
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = defaultMaxBackoff
+	}
+	if o.HTTPClient == nil {
+		o.HTTPClient = &http.Client{Timeout: time.Minute}
+	}
+	return &Checker{opts: o}
+}
+
+// Run checks the files of root through every gateway. Gateways are
+// checked concurrently, the requests to each gateway are sequential.
+func (c *Checker) Run(ctx context.Context, gateways []string, root string, checks []Check) []Report {
+	reports := make([]Report, len(gateways))
+
+	// the checks of all the gateways are reported as a single stage
+	rep := progress.FromContext(ctx)
+	total := int64(len(gateways) * len(checks))
+	var mu sync.Mutex
+	var done int64
+	checked := func() {
+		if rep == nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		done++
+		rep.Report(progress.Event{Stage: "verify", Done: done, Total: total, Finished: done == total})
+	}
+
+	var wg sync.WaitGroup
+	for i, gw := range gateways {
+		wg.Add(1)
+		go func(i int, gw string) {
+			defer wg.Done()
+			reports[i] = c.check(ctx, gw, root, checks, checked)
+		}(i, gw)
+	}
+	wg.Wait()
+	return reports
+}
+
+func (c *Checker) check(ctx context.Context, gateway string, root string, checks []Check, checked func()) Report {
+	r := Report{Gateway: gateway}
+	var total, ok int64
+
+	for i, chk := range checks {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				r.Failed = append(r.Failed, Failure{Path: chk.Path, Error: ctx.Err().Error()})
+				checked()
+				continue
+			case <-time.After(c.opts.Interval):
+			}
+		}
+
+		r.Checked++
+		latency, err := c.fetch(ctx, FileURL(gateway, root, chk.Path), chk.SHA256)
+		checked()
+		if err != nil {
+			r.Failed = append(r.Failed, Failure{Path: chk.Path, Error: err.Error()})
+			continue
+		}
+
+		ms := latency.Milliseconds()
+		total += ms
+		ok++
+		if ms > r.MaxLatency {
+			r.MaxLatency = ms
+		}
+	}
+
+	if ok > 0 {
+		r.AvgLatency = total / ok
+	}
+	r.Success = len(r.Failed) == 0 && r.Checked > 0
+	return r
+}
+
+// FileURL returns the url of a file of the root served by the gateway.
+func FileURL(gateway string, root string, filePath string) string {
+	segments := strings.Split(filePath, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return fmt.Sprintf("%s/bzz/%s/%s", strings.TrimSuffix(gateway, "/"), root, strings.Join(segments, "/"))
+}
+
+// fetch downloads the url and compares its content with the expected
+// hash, retrying when the gateway is overloaded.
+func (c *Checker) fetch(ctx context.Context, fileURL string, want string) (time.Duration, error) {
+	var err error
+	for attempt := 1; attempt <= c.opts.Retries; attempt++ {
+		var latency, wait time.Duration
+		latency, wait, err = c.get(ctx, fileURL, want)
+		if err == nil || wait < 0 {
+			return latency, err
+		}
+		if attempt == c.opts.Retries {
+			break
+		}
+
+		if wait == 0 {
+			wait = c.opts.Interval * time.Duration(1<<attempt)
+		}
+		if wait > c.opts.MaxBackoff {
+			wait = c.opts.MaxBackoff
+		}
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	return 0, err
+}
+
+// get performs a single request. The returned wait is negative when the
+// request should not be retried, or the delay asked by the gateway.
+func (c *Checker) get(ctx context.Context, fileURL string, want string) (latency time.Duration, wait time.Duration, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return 0, -1, err
+	}
+	req.Header.Set("User-Agent", "beezim")
+
+	start := time.Now()
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, -1, err
+		}
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	latency = time.Since(start)
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		io.Copy(io.Discard, resp.Body)
+		return 0, retryAfter(resp.Header.Get("Retry-After")), fmt.Errorf("rate limited: %v", resp.Status)
+	case resp.StatusCode >= 500:
+		io.Copy(io.Discard, resp.Body)
+		return 0, 0, fmt.Errorf("unexpected status: %v", resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		io.Copy(io.Discard, resp.Body)
+		return 0, -1, fmt.Errorf("unexpected status: %v", resp.Status)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return 0, 0, err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); want != "" && got != want {
+		return 0, -1, fmt.Errorf("content mismatch: expected sha256 %s, got %s", want, got)
+	}
+	return latency, 0, nil
+}
+
+// retryAfter parses a Retry-After header given in seconds or as a date.
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
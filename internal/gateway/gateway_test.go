@@ -0,0 +1,162 @@
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const root = "b1f5c2b8f1d3c6a0e7d4b2a9c8f7e6d5c4b3a2918f7e6d5c4b3a2918f7e6d5c4"
+
+// files are served under the root, the index document at its path.
+var files = map[string]string{
+	"":             "<html>index</html>",
+	"A/Main":       "<html>main</html>",
+	"I/logo.png":   "\x89PNG logo",
+	"A/Dir/A Page": "<html>page</html>",
+}
+
+func checks() []Check {
+	var c []Check
+	for _, p := range []string{"", "A/Main", "I/logo.png", "A/Dir/A Page"} {
+		sum := sha256.Sum256([]byte(files[p]))
+		c = append(c, Check{Path: p, SHA256: hex.EncodeToString(sum[:])})
+	}
+	return c
+}
+
+// gateway serves the files of the root, overridden by the content of
+// corrupt, and answers 429 to the first limited requests.
+type gateway struct {
+	corrupt map[string]string
+	limited int
+
+	mu       sync.Mutex
+	requests int
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	g.requests++
+	limited := g.requests <= g.limited
+	g.mu.Unlock()
+	if limited {
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	p, ok := strings.CutPrefix(r.URL.Path, "/bzz/"+root+"/")
+	content, found := files[p]
+	if c, corrupted := g.corrupt[p]; corrupted {
+		content = c
+	}
+	if !ok || !found {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write([]byte(content))
+}
+
+func (g *gateway) count() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.requests
+}
+
+func TestRunCorruptGateway(t *testing.T) {
+	good := httptest.NewServer(&gateway{})
+	defer good.Close()
+	corrupt := httptest.NewServer(&gateway{corrupt: map[string]string{"A/Main": "<html>spam</html>"}})
+	defer corrupt.Close()
+
+	c := New(Options{Interval: time.Millisecond})
+	reports := c.Run(context.Background(), []string{good.URL, corrupt.URL + "/"}, root, checks())
+	if len(reports) != 2 {
+		t.Fatalf("got %d reports, want 2", len(reports))
+	}
+
+	r := reports[0]
+	if r.Gateway != good.URL || !r.Success || r.Checked != 4 || len(r.Failed) != 0 {
+		t.Errorf("good gateway: %+v", r)
+	}
+	if r.MaxLatency < r.AvgLatency {
+		t.Errorf("good gateway: max latency %d below the average %d", r.MaxLatency, r.AvgLatency)
+	}
+
+	r = reports[1]
+	if r.Gateway != corrupt.URL+"/" || r.Success || r.Checked != 4 {
+		t.Errorf("corrupt gateway: %+v", r)
+	}
+	if len(r.Failed) != 1 || r.Failed[0].Path != "A/Main" || !strings.Contains(r.Failed[0].Error, "content mismatch") {
+		t.Errorf("corrupt gateway failed %+v, want the mismatch of A/Main", r.Failed)
+	}
+}
+
+func TestRunRateLimited(t *testing.T) {
+	gw := &gateway{limited: 2}
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	c := New(Options{Interval: time.Millisecond, Retries: 3})
+	r := c.Run(context.Background(), []string{srv.URL}, root, checks()[:1])[0]
+	if !r.Success || gw.count() != 3 {
+		t.Errorf("got %+v after %d requests, want the third one to succeed", r, gw.count())
+	}
+
+	// the retries run out
+	gw = &gateway{limited: 5}
+	srv = httptest.NewServer(gw)
+	defer srv.Close()
+	r = c.Run(context.Background(), []string{srv.URL}, root, checks()[:1])[0]
+	if r.Success || len(r.Failed) != 1 || !strings.Contains(r.Failed[0].Error, "rate limited") || gw.count() != 3 {
+		t.Errorf("got %+v after %d requests, want it rate limited after 3", r, gw.count())
+	}
+}
+
+func TestRunNotFound(t *testing.T) {
+	gw := &gateway{}
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	// a missing file is not retried
+	c := New(Options{Interval: time.Millisecond})
+	r := c.Run(context.Background(), []string{srv.URL}, root, []Check{{Path: "A/Missing"}})[0]
+	if r.Success || len(r.Failed) != 1 || !strings.Contains(r.Failed[0].Error, "404") || gw.count() != 1 {
+		t.Errorf("got %+v after %d requests", r, gw.count())
+	}
+}
+
+func TestFileURL(t *testing.T) {
+	for _, tt := range []struct {
+		gateway, path, want string
+	}{
+		{"https://gateway.ethswarm.org", "", "https://gateway.ethswarm.org/bzz/" + root + "/"},
+		{"https://gateway.ethswarm.org/", "A/Main", "https://gateway.ethswarm.org/bzz/" + root + "/A/Main"},
+		{"https://gateway.ethswarm.org", "A/Dir/A Page?", "https://gateway.ethswarm.org/bzz/" + root + "/A/Dir/A%20Page%3F"},
+	} {
+		if got := FileURL(tt.gateway, root, tt.path); got != tt.want {
+			t.Errorf("FileURL(%q, %q) = %q, want %q", tt.gateway, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if d := retryAfter("120"); d != 2*time.Minute {
+		t.Errorf("got %v, want 2m", d)
+	}
+	if d := retryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("got %v, want an hour", d)
+	}
+	for _, v := range []string{"", "0", "-1", "soon"} {
+		if d := retryAfter(v); d != 0 {
+			t.Errorf("%q: got %v, want 0", v, d)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package gateway
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math/rand"
+	"sort"
+
+	"github.com/r0qs/beezim/internal/tarball"
+)
+
+// SampleChecks returns the index document and a sample of files of the
+// tar drawn from rnd.
+func SampleChecks(tarPath string, sample int, rnd *rand.Rand) ([]Check, error) {
+	a, err := tarball.OpenArchive(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Close()
+
+	var checks []Check
+	var files []string
+	for _, name := range a.Names() {
+		e, _ := a.Entry(name)
+		if !e.Header.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		if name == "index.html" {
+			sum, err := archiveSHA256(a, name)
+			if err != nil {
+				return nil, err
+			}
+			checks = append(checks, Check{Path: "", SHA256: sum})
+			continue
+		}
+		files = append(files, name)
+	}
+
+	rnd.Shuffle(len(files), func(i, j int) {
+		files[i], files[j] = files[j], files[i]
+	})
+	if sample < len(files) {
+		files = files[:sample]
+	}
+	for _, name := range files {
+		sum, err := archiveSHA256(a, name)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, Check{Path: name, SHA256: sum})
+	}
+	return checks, nil
+}
+
+func archiveSHA256(a *tarball.Archive, name string) (string, error) {
+	r, _, err := a.Open(name)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// AliasChecks returns a sample of the aliases drawn from rnd, each path
+// expected to serve the file of the tar at its target. The aliases whose
+// target is not in the tar are not checked.
+func AliasChecks(tarPath string, aliases map[string]string, sample int, rnd *rand.Rand) ([]Check, error) {
+	a, err := tarball.OpenArchive(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Close()
+
+	var paths []string
+	for p, target := range aliases {
+		if _, ok := a.Entry(target); ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	rnd.Shuffle(len(paths), func(i, j int) {
+		paths[i], paths[j] = paths[j], paths[i]
+	})
+	if sample < len(paths) {
+		paths = paths[:sample]
+	}
+	var checks []Check
+	for _, p := range paths {
+		sum, err := archiveSHA256(a, aliases[p])
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, Check{Path: p, SHA256: sum})
+	}
+	return checks, nil
+}
+
+// SampleStream returns a reader of the tar read from r along with the
+// function returning, once it was read, the same kind of checks as
+// SampleChecks: the index document and a sample of files, drawn from rnd
+// as the tar goes by, e.g. while it is uploaded. Only the checks of the
+// sample are held.
+func SampleStream(r io.Reader, sample int, rnd *rand.Rand) (io.Reader, func() ([]Check, error)) {
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	var checks []Check
+	var err error
+	go func() {
+		defer close(done)
+		checks, err = sampleTar(pr, sample, rnd)
+		// the rest of the stream is read even when the tar is invalid
+		io.Copy(io.Discard, pr)
+	}()
+	return &teeStream{r: r, w: pw}, func() ([]Check, error) {
+		// the stream may not have been read to its end
+		pw.CloseWithError(io.ErrUnexpectedEOF)
+		<-done
+		return checks, err
+	}
+}
+
+// teeStream writes to w what is read from r, and closes w at the end of r.
+type teeStream struct {
+	r io.Reader
+	w *io.PipeWriter
+}
+
+func (t *teeStream) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if _, err := t.w.Write(p[:n]); err != nil {
+			return n, err
+		}
+	}
+	if err == io.EOF {
+		t.w.Close()
+	} else if err != nil {
+		t.w.CloseWithError(err)
+	}
+	return n, err
+}
+
+// sampleTar returns the index document and a reservoir sample of the
+// files of the tar read from r.
+func sampleTar(r io.Reader, sample int, rnd *rand.Rand) ([]Check, error) {
+	tr := tar.NewReader(r)
+	var index, files []Check
+	seen := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeXGlobalHeader || !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, tr); err != nil {
+			return nil, err
+		}
+		c := Check{Path: hdr.Name, SHA256: hex.EncodeToString(h.Sum(nil))}
+		if hdr.Name == "index.html" {
+			c.Path = ""
+			index = []Check{c}
+			continue
+		}
+		seen++
+		if len(files) < sample {
+			files = append(files, c)
+		} else if j := rnd.Intn(seen); j < sample {
+			files[j] = c
+		}
+	}
+	return append(index, files...), nil
+}
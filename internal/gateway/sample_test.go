@@ -0,0 +1,131 @@
+package gateway
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/r0qs/beezim/internal/random"
+)
+
+// writeTar writes a tar of an index document and 100 articles and returns
+// its path.
+func writeTar(t *testing.T) string {
+	t.Helper()
+	tarPath := filepath.Join(t.TempDir(), "mirror.tar")
+	f, err := os.Create(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	write := func(name string, content string) {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("index.html", "<html>index</html>")
+	for i := range 100 {
+		write(fmt.Sprintf("A/Article%d", i), fmt.Sprintf("<p>article %d</p>", i))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return tarPath
+}
+
+func paths(checks []Check) []string {
+	p := make([]string, len(checks))
+	for i, c := range checks {
+		p[i] = c.Path
+	}
+	return p
+}
+
+func TestSampleChecksSeed(t *testing.T) {
+	tarPath := writeTar(t)
+	sample := func(seed int64) []Check {
+		checks, err := SampleChecks(tarPath, 10, random.New(seed, "verify"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return checks
+	}
+
+	first := sample(42)
+	if len(first) != 11 || first[0].Path != "" {
+		t.Fatalf("got checks %v, want the index document and 10 files", paths(first))
+	}
+	if again := sample(42); !slices.Equal(again, first) {
+		t.Errorf("the same seed sampled %v, then %v", paths(first), paths(again))
+	}
+	if other := sample(43); slices.Equal(other, first) {
+		t.Errorf("another seed sampled the same files %v", paths(other))
+	}
+}
+
+func TestSampleStreamSeed(t *testing.T) {
+	tarPath := writeTar(t)
+	sample := func(seed int64) []Check {
+		f, err := os.Open(tarPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		r, checks := SampleStream(f, 10, random.New(seed, "verify"))
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			t.Fatal(err)
+		}
+		c, err := checks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	first := sample(42)
+	if len(first) != 11 || first[0].Path != "" {
+		t.Fatalf("got checks %v, want the index document and 10 files", paths(first))
+	}
+	if again := sample(42); !slices.Equal(again, first) {
+		t.Errorf("the same seed sampled %v, then %v", paths(first), paths(again))
+	}
+	if other := sample(43); slices.Equal(other, first) {
+		t.Errorf("another seed sampled the same files %v", paths(other))
+	}
+}
+
+func TestAliasChecksSeed(t *testing.T) {
+	tarPath := writeTar(t)
+	aliases := make(map[string]string)
+	for i := range 50 {
+		aliases[fmt.Sprintf("A/Alias%d", i)] = fmt.Sprintf("A/Article%d", i)
+	}
+	// an alias whose target is not in the tar is not checked
+	aliases["A/Dangling"] = "A/Missing"
+	sample := func(seed int64) []Check {
+		checks, err := AliasChecks(tarPath, aliases, 5, random.New(seed, "verify-redirects"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return checks
+	}
+
+	first := sample(42)
+	if len(first) != 5 || slices.Contains(paths(first), "A/Dangling") {
+		t.Fatalf("got checks %v, want 5 aliases", paths(first))
+	}
+	// the iteration order of the map does not change the sample
+	for range 10 {
+		if again := sample(42); !slices.Equal(again, first) {
+			t.Fatalf("the same seed sampled %v, then %v", paths(first), paths(again))
+		}
+	}
+}
@@ -0,0 +1,285 @@
+// Package htmltag reads the tags of the HTML pages of the ZIMs for the
+// passes rewriting some of them, e.g. their links.
+//
+// Its tokenizer is tolerant: a part of the page it does not read as a tag,
+// e.g. a < of a text or an unterminated tag, is a text, and the tokens
+// cover every byte of the page, so that a pass copying them as they are
+// but those it rewrites keeps the rest of the page byte for byte.
+package htmltag
+
+import (
+	"bytes"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Kind is the kind of a token.
+type Kind int
+
+const (
+	// Text is a text of the page, or a part of it not read as a tag.
+	Text Kind = iota
+	// Comment is a comment, up to the end of the page when unterminated.
+	Comment
+	// StartTag and EndTag are the tags of the elements.
+	StartTag
+	EndTag
+	// RawText is the content of a script, style, textarea or title
+	// element, which holds no tag, up to the end of the page without its
+	// end tag.
+	RawText
+)
+
+// Token is a part of a page.
+type Token struct {
+	Kind Kind
+	// Raw are its bytes in the page.
+	Raw []byte
+	// Name is the name of a tag in lower case, or that of the element of
+	// a RawText.
+	Name string
+	// Attrs are the attributes of a start tag, in their order.
+	Attrs []Attr
+}
+
+// Attr is an attribute of a tag. Its name is in lower case, and its value
+// as it is in the tag, unquoted but with its character references.
+type Attr struct {
+	Name  string
+	Value string
+	// Start and End are the indexes of the attribute in the Raw of its
+	// tag, and Quote that of its value, 0 for none.
+	Start, End int
+	Quote      byte
+}
+
+// rawElements are the elements whose content is text.
+var rawElements = map[string]bool{"script": true, "style": true, "textarea": true, "title": true}
+
+// Tokenizer reads the tokens of a page.
+type Tokenizer struct {
+	data []byte
+	i    int
+	// raw is the element whose content is read next, after its start tag
+	raw string
+}
+
+// NewTokenizer returns a tokenizer of the page.
+func NewTokenizer(data []byte) *Tokenizer {
+	return &Tokenizer{data: data}
+}
+
+// Next returns the next token of the page, false at its end.
+func (z *Tokenizer) Next() (Token, bool) {
+	data, i := z.data, z.i
+	if i >= len(data) {
+		return Token{}, false
+	}
+	if name := z.raw; name != "" {
+		z.raw = ""
+		end := RawEnd(data[i:], name)
+		if end < 0 {
+			end = len(data) - i
+		}
+		if end > 0 {
+			z.i += end
+			return Token{Kind: RawText, Raw: data[i : i+end], Name: name}, true
+		}
+	}
+	if bytes.HasPrefix(data[i:], []byte("<!--")) {
+		end := len(data)
+		if n := bytes.Index(data[i+4:], []byte("-->")); n >= 0 {
+			end = i + 4 + n + 3
+		}
+		z.i = end
+		return Token{Kind: Comment, Raw: data[i:end]}, true
+	}
+	if data[i] == '<' {
+		if t, ok := readTag(data[i:]); ok {
+			z.i += len(t.Raw)
+			if t.Kind == StartTag && rawElements[t.Name] {
+				z.raw = t.Name
+			}
+			return t, true
+		}
+	}
+	// the text up to the next <, with the one starting it when not a tag
+	end := len(data)
+	if n := bytes.IndexByte(data[i+1:], '<'); n >= 0 {
+		end = i + 1 + n
+	}
+	z.i = end
+	return Token{Kind: Text, Raw: data[i:end]}, true
+}
+
+// Attr returns the value of the attribute of the name, the first one for
+// an attribute repeated as the browsers do.
+func (t Token) Attr(name string) (string, bool) {
+	for _, a := range t.Attrs {
+		if a.Name == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// AppendWith appends the tag to out with the values of the attributes of
+// set, replacing those of the same name, dropped when "", and added in the
+// order of their names for those it does not have. The rest of the tag is
+// kept as it is.
+func (t Token) AppendWith(out []byte, set map[string]string) []byte {
+	last := len(t.Name) + 1
+	out = append(out, t.Raw[:last]...)
+	done := make(map[string]bool)
+	for _, a := range t.Attrs {
+		v, ok := set[a.Name]
+		switch {
+		case !ok:
+			out = append(out, t.Raw[last:a.End]...)
+		case v == "" || done[a.Name]:
+			// dropped with the spaces before it
+			out = append(out, t.Raw[last:spaceStart(t.Raw, a.Start)]...)
+		default:
+			out = append(out, t.Raw[last:a.Start]...)
+			out = appendAttr(out, string(t.Raw[a.Start:a.Start+len(a.Name)]), v, a.Quote)
+		}
+		done[a.Name] = true
+		last = a.End
+	}
+	for _, name := range slices.Sorted(maps.Keys(set)) {
+		if v := set[name]; v != "" && !done[name] {
+			out = appendAttr(append(out, ' '), name, v, '"')
+		}
+	}
+	return append(out, t.Raw[last:]...)
+}
+
+// appendAttr appends the attribute with its value in the quotes, double
+// ones for none, or the others for a value holding them.
+func appendAttr(out []byte, name, value string, quote byte) []byte {
+	if quote == 0 {
+		quote = '"'
+	}
+	if strings.IndexByte(value, quote) >= 0 {
+		quote = '"' + '\'' - quote
+	}
+	out = append(out, name...)
+	out = append(out, '=', quote)
+	out = append(out, value...)
+	return append(out, quote)
+}
+
+// spaceStart returns the index of the spaces before i in raw.
+func spaceStart(raw []byte, i int) int {
+	for i > 0 && isSpace(raw[i-1]) {
+		i--
+	}
+	return i
+}
+
+// readTag reads the tag starting data, false when it is not one, e.g. the
+// < of a text or an unterminated tag.
+func readTag(data []byte) (Token, bool) {
+	t := Token{Kind: StartTag}
+	j := 1
+	if j < len(data) && data[j] == '/' {
+		t.Kind = EndTag
+		j++
+	}
+	start := j
+	for j < len(data) && (isLetter(data[j]) || j > start && isNameChar(data[j])) {
+		j++
+	}
+	if j == start {
+		return Token{}, false
+	}
+	t.Name = strings.ToLower(string(data[start:j]))
+	for {
+		for j < len(data) && (isSpace(data[j]) || data[j] == '/') {
+			j++
+		}
+		if j >= len(data) {
+			return Token{}, false
+		}
+		if data[j] == '>' {
+			t.Raw = data[:j+1]
+			return t, true
+		}
+		a, ok := readAttr(data, j)
+		if !ok {
+			return Token{}, false
+		}
+		t.Attrs = append(t.Attrs, a)
+		j = a.End
+	}
+}
+
+// readAttr reads the attribute at i of data, false when unterminated.
+func readAttr(data []byte, i int) (Attr, bool) {
+	a := Attr{Start: i}
+	for i < len(data) && !isSpace(data[i]) && data[i] != '=' && data[i] != '>' && (data[i] != '/' || i == a.Start) {
+		i++
+	}
+	a.Name = strings.ToLower(string(data[a.Start:i]))
+	j := i
+	for j < len(data) && isSpace(data[j]) {
+		j++
+	}
+	if j >= len(data) || data[j] != '=' {
+		a.End = i
+		return a, true
+	}
+	j++
+	for j < len(data) && isSpace(data[j]) {
+		j++
+	}
+	if j >= len(data) {
+		return Attr{}, false
+	}
+	if q := data[j]; q == '"' || q == '\'' {
+		end := bytes.IndexByte(data[j+1:], q)
+		if end < 0 {
+			return Attr{}, false
+		}
+		a.Value, a.Quote = string(data[j+1:j+1+end]), q
+		j += 1 + end + 1
+	} else {
+		v := j
+		for j < len(data) && !isSpace(data[j]) && data[j] != '>' {
+			j++
+		}
+		a.Value = string(data[v:j])
+	}
+	a.End = j
+	return a, true
+}
+
+// RawEnd returns the index in data of the end tag of the element, -1
+// without one.
+func RawEnd(data []byte, name string) int {
+	for i := 0; ; {
+		start := bytes.Index(data[i:], []byte("</"))
+		if start < 0 {
+			return -1
+		}
+		i += start
+		if end := i + 2 + len(name); end <= len(data) && strings.EqualFold(string(data[i+2:end]), name) &&
+			(end == len(data) || data[end] == '>' || data[end] == '/' || isSpace(data[end])) {
+			return i
+		}
+		i += 2
+	}
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isNameChar(c byte) bool {
+	return isLetter(c) || '0' <= c && c <= '9' || c == '-' || c == ':' || c == '_'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
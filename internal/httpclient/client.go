@@ -33,6 +33,13 @@ type Client struct {
 
 type ClientOptions struct {
 	HTTPClient *http.Client
+	// RateLimiter, when set, is waited on before every request.
+	RateLimiter RateLimiter
+}
+
+// RateLimiter limits the rate of requests sent by a client.
+type RateLimiter interface {
+	Wait(ctx context.Context) error
 }
 
 func NewClient(u *url.URL, o *ClientOptions) (c *Client, err error) {
@@ -44,13 +51,13 @@ func NewClient(u *url.URL, o *ClientOptions) (c *Client, err error) {
 	if o.HTTPClient == nil {
 		o.HTTPClient = new(http.Client)
 	}
-	c.HTTPClient = httpClientWithTransport(u, o.HTTPClient)
+	c.HTTPClient = httpClientWithTransport(u, o.HTTPClient, o.RateLimiter)
 	c.Host = u.Host
 
 	return c, nil
 }
 
-func httpClientWithTransport(baseURL *url.URL, httpc *http.Client) *http.Client {
+func httpClientWithTransport(baseURL *url.URL, httpc *http.Client, rl RateLimiter) *http.Client {
 	if httpc == nil {
 		httpc = new(http.Client)
 	}
@@ -59,12 +66,12 @@ func httpClientWithTransport(baseURL *url.URL, httpc *http.Client) *http.Client
 	if transport == nil {
 		transport = http.DefaultTransport
 	}
-	httpc.Transport = roundTripper(baseURL, transport)
+	httpc.Transport = roundTripper(baseURL, transport, rl)
 
 	return httpc
 }
 
-func roundTripper(baseURL *url.URL, transport http.RoundTripper) http.RoundTripper {
+func roundTripper(baseURL *url.URL, transport http.RoundTripper, rl RateLimiter) http.RoundTripper {
 	if !strings.HasSuffix(baseURL.Path, "/") {
 		baseURL.Path += "/"
 	}
@@ -75,10 +82,20 @@ func roundTripper(baseURL *url.URL, transport http.RoundTripper) http.RoundTripp
 			return nil, err
 		}
 		r.URL = u
+		if rl != nil {
+			if err := rl.Wait(r.Context()); err != nil {
+				return nil, err
+			}
+		}
 		return transport.RoundTrip(r)
 	})
 }
 
+// CloseIdleConnections closes the idle connections kept by the client transport.
+func (c *Client) CloseIdleConnections() {
+	c.HTTPClient.CloseIdleConnections()
+}
+
 // requestJSON handles the HTTP request response cycle. It JSON encodes the request
 // body, creates an HTTP request with provided method on a path with required
 // headers and decodes request body if the v argument is not nil and content type is
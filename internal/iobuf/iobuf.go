@@ -0,0 +1,109 @@
+// Package iobuf configures the sizes of the I/O buffers of the pipeline.
+package iobuf
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The tar writer issues a write per header and payload. Writing the tar
+// of 5000 articles of up to 20KiB to a local disk took 46ms without
+// buffer, 40ms with 4KiB, 26ms with 64KiB to 1MiB and 33ms with 4MiB,
+// which no longer fits in the CPU caches, see BenchmarkTarWriterBuffer
+// of the indexer. Network file systems favor larger buffers. The other
+// defaults keep the writes of large files in few system calls without
+// holding much memory per stage.
+const (
+	DefaultTarWriter    = 256 << 10
+	DefaultFileCopy     = 1 << 20
+	DefaultUploadChunk  = 256 << 10
+	DefaultDownloadCopy = 256 << 10
+)
+
+// MinSize and MaxSize bound the sizes accepted by Validate.
+const (
+	MinSize = 4 << 10
+	MaxSize = 64 << 20
+)
+
+// ErrInvalidSize is returned by Validate for a size out of bounds.
+var ErrInvalidSize = errors.New("invalid buffer size")
+
+// Config is the sizes in bytes of the I/O buffers, the defaults when zero.
+type Config struct {
+	// TarWriter buffers the tar files written by the parse.
+	TarWriter int
+	// FileCopy is the size of the writes of the files extracted from a
+	// ZIM.
+	FileCopy int
+	// UploadChunk is the size of the writes of the body of an upload.
+	UploadChunk int
+	// DownloadCopy is the buffer copying a download to its file.
+	DownloadCopy int
+}
+
+// Validate checks that the sizes set are between MinSize and MaxSize.
+func (c Config) Validate() error {
+	sizes := []struct {
+		name string
+		size int
+	}{
+		{"tar writer", c.TarWriter},
+		{"file copy", c.FileCopy},
+		{"upload chunk", c.UploadChunk},
+		{"download copy", c.DownloadCopy},
+	}
+	for _, s := range sizes {
+		if s.size != 0 && (s.size < MinSize || s.size > MaxSize) {
+			return fmt.Errorf("%w: %s buffer of %d bytes, must be between %d and %d", ErrInvalidSize, s.name, s.size, MinSize, MaxSize)
+		}
+	}
+	return nil
+}
+
+// WithDefaults returns the config with the defaults for the sizes not set.
+func (c Config) WithDefaults() Config {
+	def := func(size *int, d int) {
+		if *size == 0 {
+			*size = d
+		}
+	}
+	def(&c.TarWriter, DefaultTarWriter)
+	def(&c.FileCopy, DefaultFileCopy)
+	def(&c.UploadChunk, DefaultUploadChunk)
+	def(&c.DownloadCopy, DefaultDownloadCopy)
+	return c
+}
+
+// Copy copies src to dst through a buffer of size bytes. Unlike
+// io.CopyBuffer, the buffer is used even when dst is a file or src a
+// reader in memory.
+func Copy(dst io.Writer, src io.Reader, size int) (int64, error) {
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, make([]byte, size))
+}
+
+// NewChunkReader returns a reader of r whose reads and writes to a
+// writer, such as the connection of an upload, are of at most size
+// bytes.
+func NewChunkReader(r io.Reader, size int) io.Reader {
+	return &chunkReader{r: r, size: size}
+}
+
+type chunkReader struct {
+	r    io.Reader
+	size int
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(p) > c.size {
+		p = p[:c.size]
+	}
+	return c.r.Read(p)
+}
+
+// WriteTo is used by io.Copy, which would otherwise copy through its own
+// 32KiB buffer.
+func (c *chunkReader) WriteTo(w io.Writer) (int64, error) {
+	return Copy(w, c.r, c.size)
+}
@@ -0,0 +1,73 @@
+package keystore
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ethersphere/bee/pkg/keystore/file"
+)
+
+// DefaultDir is the keystore directory relative to the user home.
+const DefaultDir = ".beezim/keystore"
+
+// Keystore holds the keys of the mirror publisher. secp256k1 keys are
+// stored encrypted in the same format used by bee.
+type Keystore struct {
+	dir string
+	svc *file.Service
+}
+
+// Open returns the keystore at dir. The directory is created when the
+// first key is generated.
+func Open(dir string) *Keystore {
+	return &Keystore{dir: dir, svc: file.New(dir)}
+}
+
+// Secp256k1 returns the named key, generating it on first use.
+func (k *Keystore) Secp256k1(name, password string) (key *ecdsa.PrivateKey, created bool, err error) {
+	if name == "" {
+		return nil, false, errors.New("key name is empty")
+	}
+	key, created, err = k.svc.Key(name, password)
+	if err != nil {
+		return nil, false, fmt.Errorf("error loading key %s from %s: %w", name, k.dir, err)
+	}
+	return key, created, nil
+}
+
+// Ed25519 reads an ed25519 private key from a PKCS #8 PEM file, as
+// generated by "openssl genpkey -algorithm ed25519". Relative paths are
+// looked up in the keystore directory.
+func (k *Keystore) Ed25519(keyFile string) (ed25519.PrivateKey, error) {
+	if !filepath.IsAbs(keyFile) {
+		if _, err := os.Stat(keyFile); err != nil {
+			keyFile = filepath.Join(k.dir, keyFile)
+		}
+	}
+
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("error decoding key %s: no PEM data found", keyFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding key %s: %v", keyFile, err)
+	}
+
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key %s is not an ed25519 key", keyFile)
+	}
+	return key, nil
+}
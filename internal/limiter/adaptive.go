@@ -0,0 +1,183 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// The controller judges the latency per MiB, as the requests it limits
+// upload payloads of any size. Payloads under a MiB count as one, their
+// latency being mostly the fixed cost of the request.
+const (
+	latencyUnit = 1 << 20
+	// latencyTolerance is how much slower than the fastest observed a
+	// request can be before the node is deemed overloaded.
+	latencyTolerance = 2
+	// probeSlowdown is how many more fast requests it takes to grow the
+	// limit back to where it was decreased.
+	probeSlowdown = 8
+)
+
+// Decision is a change of the limit of an Adaptive limiter.
+type Decision struct {
+	From, To int
+	// Reason is "latency", "error" or "increase".
+	Reason string
+	// Latency is the latency per MiB of the request that triggered the
+	// decision, and Best the fastest observed.
+	Latency, Best time.Duration
+}
+
+// Adaptive limits the requests in flight to a node with an AIMD scheme:
+// the limit grows by one after a limit's worth of fast requests sent
+// while it was reached, and shrinks by a quarter when a request fails or
+// is much slower than the fastest observed. Only the requests started
+// after a decrease can decrease the limit again, so a burst of slow
+// responses counts once, and growing back to the limit of the last
+// decrease is slower, so the limit settles under it instead of
+// oscillating. A request slow even at the minimum limit becomes the
+// fastest observed, as the node got slower for good. A nil Adaptive is
+// unlimited.
+type Adaptive struct {
+	min, max int
+	// OnDecision, when set, is called with every change of the limit. It
+	// must not call the limiter.
+	OnDecision func(Decision)
+
+	mu       sync.Mutex
+	limit    int
+	inFlight int
+	wake     chan struct{}
+	best     time.Duration
+	// epoch is incremented on decreases, good counts the fast requests of
+	// the current epoch sent at the limit and ceiling is the limit of the
+	// last decrease.
+	epoch   int
+	good    int
+	ceiling int
+}
+
+// NewAdaptive returns a limiter starting at min requests in flight and
+// bounded by max, or nil (unlimited) when max is not positive. min is at
+// least 1 and at most max.
+func NewAdaptive(min, max int) *Adaptive {
+	if max <= 0 {
+		return nil
+	}
+	if min < 1 {
+		min = 1
+	}
+	if min > max {
+		min = max
+	}
+	return &Adaptive{min: min, max: max, limit: min, wake: make(chan struct{})}
+}
+
+// Limit returns the current limit, 0 when unlimited.
+func (a *Adaptive) Limit() int {
+	if a == nil {
+		return 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.limit
+}
+
+// Acquire blocks until a request can be sent and returns the function to
+// call with its payload size and error once it completed.
+func (a *Adaptive) Acquire(ctx context.Context) (func(size int64, err error), error) {
+	if a == nil {
+		return func(int64, error) {}, nil
+	}
+	for {
+		a.mu.Lock()
+		if a.inFlight < a.limit {
+			a.inFlight++
+			saturated := a.inFlight == a.limit
+			epoch := a.epoch
+			a.mu.Unlock()
+			start := time.Now()
+			var once sync.Once
+			return func(size int64, err error) {
+				once.Do(func() { a.done(epoch, saturated, time.Since(start), size, err) })
+			}, nil
+		}
+		wake := a.wake
+		a.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+// done releases the slot of a request and adjusts the limit.
+func (a *Adaptive) done(epoch int, saturated bool, d time.Duration, size int64, err error) {
+	a.mu.Lock()
+	a.inFlight--
+	close(a.wake)
+	a.wake = make(chan struct{})
+
+	// canceled requests say nothing about the node
+	if errors.Is(err, context.Canceled) {
+		a.mu.Unlock()
+		return
+	}
+	if size < latencyUnit {
+		size = latencyUnit
+	}
+	latency := time.Duration(float64(d) * latencyUnit / float64(size))
+
+	decision := Decision{From: a.limit, Latency: latency}
+	switch {
+	case err != nil:
+		decision.Reason = "error"
+	case a.best == 0 || latency < a.best:
+		a.best = latency
+	case latency > latencyTolerance*a.best:
+		decision.Reason = "latency"
+	}
+	decision.Best = a.best
+
+	switch decision.Reason {
+	case "latency", "error":
+		if decision.Reason == "latency" && a.limit == a.min {
+			a.best = latency
+			break
+		}
+		if epoch != a.epoch {
+			break
+		}
+		a.epoch++
+		a.good = 0
+		a.ceiling = a.limit
+		a.limit -= (a.limit + 3) / 4
+		if a.limit < a.min {
+			a.limit = a.min
+		}
+	default:
+		if !saturated || epoch != a.epoch || a.limit >= a.max {
+			break
+		}
+		a.good++
+		needed := a.limit
+		if a.ceiling > 0 && a.limit+1 >= a.ceiling {
+			needed *= probeSlowdown
+		}
+		if a.good >= needed {
+			a.good = 0
+			a.limit++
+			decision.Reason = "increase"
+		}
+	}
+	decision.To = a.limit
+	a.mu.Unlock()
+
+	if decision.To != decision.From && a.OnDecision != nil {
+		a.OnDecision(decision)
+	}
+}
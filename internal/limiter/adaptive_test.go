@@ -0,0 +1,201 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// node is a mock node serving its capacity of requests in parallel in
+// base time, slower as more are in flight, and failing the requests
+// beyond overload when set.
+type node struct {
+	capacity int
+	base     atomic.Int64
+	overload int
+
+	inFlight atomic.Int32
+}
+
+var errOverloaded = errors.New("503 service unavailable")
+
+func (n *node) serve() error {
+	in := int(n.inFlight.Add(1))
+	defer n.inFlight.Add(-1)
+	if n.overload > 0 && in > n.overload {
+		time.Sleep(time.Duration(n.base.Load()) / 4)
+		return errOverloaded
+	}
+	d := time.Duration(n.base.Load())
+	if in > n.capacity {
+		d = d * time.Duration(in) / time.Duration(n.capacity)
+	}
+	time.Sleep(d)
+	return nil
+}
+
+// trace records the limit of every decision.
+type trace struct {
+	mu        sync.Mutex
+	decisions []Decision
+}
+
+func (t *trace) record(d Decision) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.decisions = append(t.decisions, d)
+}
+
+func (t *trace) since(i int) []Decision {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Decision(nil), t.decisions[i:]...)
+}
+
+func (t *trace) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.decisions)
+}
+
+// load sends requests of 1 MiB to the node from 64 workers through the
+// limiter until the duration elapsed.
+func load(t *testing.T, a *Adaptive, n *node, d time.Duration) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	var wg sync.WaitGroup
+	for range 64 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// a free slot is acquired even once the context is done
+			for ctx.Err() == nil {
+				done, err := a.Acquire(ctx)
+				if err != nil {
+					return
+				}
+				done(latencyUnit, n.serve())
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// band checks that the limit of the decisions stays within min and max.
+func band(t *testing.T, decisions []Decision, min, max int) {
+	t.Helper()
+	if len(decisions) == 0 {
+		t.Error("no decision")
+	}
+	for _, d := range decisions {
+		if d.To < min || d.To > max {
+			t.Errorf("limit %d out of [%d, %d]: %+v", d.To, min, max, d)
+		}
+	}
+}
+
+func TestAdaptiveStable(t *testing.T) {
+	n := &node{capacity: 8}
+	n.base.Store(int64(5 * time.Millisecond))
+	var tr trace
+	a := NewAdaptive(1, 64)
+	a.OnDecision = tr.record
+
+	// the limit grows from the minimum up to the point where the node
+	// slows down, twice its capacity
+	load(t, a, n, time.Second)
+	if l := a.Limit(); l < n.capacity/2 || l > 2*n.capacity+2 {
+		t.Fatalf("limit %d after the ramp up, want it near the capacity %d", l, n.capacity)
+	}
+
+	// then stays in the band of the capacity, probing the limit where
+	// the node slows down and backing off by a quarter at most
+	settled := tr.len()
+	load(t, a, n, time.Second)
+	band(t, tr.since(settled), n.capacity, 2*n.capacity+2)
+}
+
+func TestAdaptiveErrors(t *testing.T) {
+	// the node fails the requests over 12 in flight, before it slows down
+	n := &node{capacity: 32, overload: 12}
+	n.base.Store(int64(5 * time.Millisecond))
+	var tr trace
+	a := NewAdaptive(2, 64)
+	a.OnDecision = tr.record
+
+	load(t, a, n, 500*time.Millisecond)
+	settled := tr.len()
+	load(t, a, n, time.Second)
+	band(t, tr.since(settled), n.overload/2, n.overload+1)
+	// the node never slows down, only the scheduling of the test may
+	// delay a request
+	decreases := make(map[string]int)
+	for _, d := range tr.since(0) {
+		if d.To < d.From {
+			decreases[d.Reason]++
+		}
+	}
+	if decreases["error"] == 0 || decreases["latency"] > decreases["error"]/4 {
+		t.Errorf("decreased %v, want them for errors", decreases)
+	}
+}
+
+func TestAdaptiveSlowerNode(t *testing.T) {
+	n := &node{capacity: 8}
+	n.base.Store(int64(2 * time.Millisecond))
+	a := NewAdaptive(1, 64)
+	load(t, a, n, 500*time.Millisecond)
+	before := a.Limit()
+
+	// the node gets four times slower for good: the limit drops, then
+	// grows back as the slow latency becomes the fastest observed
+	n.base.Store(int64(8 * time.Millisecond))
+	load(t, a, n, 1500*time.Millisecond)
+	if l := a.Limit(); l < n.capacity/2 || l > 2*n.capacity+2 {
+		t.Errorf("limit %d, %d before the slow down, want it back near the capacity %d", l, before, n.capacity)
+	}
+}
+
+func TestAdaptiveBounds(t *testing.T) {
+	if a := NewAdaptive(1, 0); a != nil {
+		t.Fatal("got a limiter without maximum")
+	}
+	var a *Adaptive
+	done, err := a.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	done(0, nil)
+	if a.Limit() != 0 {
+		t.Errorf("unlimited limit %d", a.Limit())
+	}
+
+	if l := NewAdaptive(0, 4).Limit(); l != 1 {
+		t.Errorf("started at %d, want the minimum of 1", l)
+	}
+	if l := NewAdaptive(8, 4).Limit(); l != 4 {
+		t.Errorf("started at %d, want the maximum of 4", l)
+	}
+
+	// the requests over the limit wait for a slot
+	a = NewAdaptive(1, 1)
+	done, err = a.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := a.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the second request to wait", err)
+	}
+	// a canceled request frees its slot and says nothing of the node
+	done(latencyUnit, context.Canceled)
+	done(latencyUnit, errOverloaded)
+	if _, err := a.Acquire(context.Background()); err != nil {
+		t.Errorf("slot not released: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// Pool is a budget shared by concurrent pipelines, e.g. parse workers or
+// bytes held in memory by uploads. A nil Pool is unlimited.
+type Pool struct {
+	size int64
+	sem  *semaphore.Weighted
+}
+
+// NewPool returns a pool of the given size, or nil (unlimited) when size
+// is not positive.
+func NewPool(size int64) *Pool {
+	if size <= 0 {
+		return nil
+	}
+	return &Pool{size: size, sem: semaphore.NewWeighted(size)}
+}
+
+// Acquire blocks until n units are available and returns how many were
+// taken, which must be given back to Release. Requests larger than the
+// pool take the whole pool instead of waiting forever.
+func (p *Pool) Acquire(ctx context.Context, n int64) (int64, error) {
+	if p == nil || n <= 0 {
+		return 0, nil
+	}
+	if n > p.size {
+		n = p.size
+	}
+	if err := p.sem.Acquire(ctx, n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Release gives back n units acquired from the pool.
+func (p *Pool) Release(n int64) {
+	if p == nil || n <= 0 {
+		return
+	}
+	p.sem.Release(n)
+}
+
+// Size returns the size of the pool, 0 when unlimited.
+func (p *Pool) Size() int64 {
+	if p == nil {
+		return 0
+	}
+	return p.size
+}
+
+// Rate spaces requests evenly to at most the given number per second.
+// A nil Rate is unlimited.
+type Rate struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewRate returns a rate limiter, or nil (unlimited) when perSecond is
+// not positive.
+func NewRate(perSecond float64) *Rate {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &Rate{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// Wait blocks until the next request is allowed.
+func (r *Rate) Wait(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	wait := r.next.Sub(now)
+	r.next = r.next.Add(r.interval)
+	r.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Limits are the budgets shared by all the wikis mirrored concurrently.
+// Each stage has its own budget so a wiki holding a slot of one stage
+// never waits for a slot of the same stage held by itself.
+type Limits struct {
+	// ParseWorkers limits the workers reading ZIM files.
+	ParseWorkers *Pool
+	// TarWriters limits the tars being written.
+	TarWriters *Pool
+	// UploadBytes limits the bytes of the tars being uploaded at the same
+	// time.
+	UploadBytes *Pool
+	// Uploads adapts the number of tars uploaded at the same time to the
+	// node.
+	Uploads *Adaptive
+}
@@ -0,0 +1,166 @@
+package minify
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// CSS returns the stylesheet without its comments, but the /*! ones, with
+// its spaces collapsed and dropped around the braces, semicolons, commas
+// and child combinators, after the colons and before those of the
+// declarations, and without the semicolon ending a block.
+func CSS(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	space, semicolon := false, false
+	// decl tells whether the current block holds declarations, where the
+	// space before a colon can be dropped, unlike in a selector where
+	// "a :hover" is not "a:hover"; blocks holds that of the enclosing
+	// blocks and stmt is the start in out of the current statement
+	decl, blocks, stmt := false, []bool(nil), 0
+	// emit writes the pending space and semicolon before c
+	emit := func(c byte) {
+		if semicolon {
+			semicolon = false
+			if c != '}' {
+				out = append(out, ';')
+			}
+		}
+		if space && len(out) > 0 &&
+			!strings.ContainsRune(cssTight+":", rune(out[len(out)-1])) &&
+			!strings.ContainsRune(cssTight, rune(c)) &&
+			!(decl && c == ':') {
+			out = append(out, ' ')
+		}
+		space = false
+	}
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case isSpace(c):
+			space = true
+			i++
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated comment", ErrInvalid)
+			}
+			if i+2 < len(data) && data[i+2] == '!' {
+				emit('/')
+				out = append(out, data[i:i+2+end+2]...)
+			} else {
+				// a comment separates the tokens around it
+				space = true
+			}
+			i += 2 + end + 2
+		case c == '"' || c == '\'':
+			emit(c)
+			end, err := quoted(data, i, false)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, data[i:end]...)
+			i = end
+		case c == '\\':
+			emit(c)
+			i = cssEscape(data, i, &out)
+		case c == ';':
+			// dropped before the end of a block
+			if semicolon {
+				out = append(out, ';')
+			}
+			semicolon, space = true, false
+			i++
+		default:
+			emit(c)
+			out = append(out, c)
+			i++
+			switch c {
+			case '{':
+				prelude := out[stmt : len(out)-1]
+				if j := bytes.LastIndexByte(prelude, ';'); j >= 0 {
+					prelude = prelude[j+1:]
+				}
+				blocks = append(blocks, decl)
+				decl = !cssRuleBlock(prelude)
+				stmt = len(out)
+			case '}':
+				decl = false
+				if n := len(blocks); n > 0 {
+					decl, blocks = blocks[n-1], blocks[:n-1]
+				}
+				stmt = len(out)
+			}
+		}
+	}
+	if semicolon {
+		out = append(out, ';')
+	}
+	return out, nil
+}
+
+// cssTight are the characters the spaces around can be dropped.
+const cssTight = "{};,>"
+
+// cssRuleBlock tells whether the block of the prelude holds rules rather
+// than declarations, as those of the conditional at-rules and keyframes.
+func cssRuleBlock(prelude []byte) bool {
+	if len(prelude) == 0 || prelude[0] != '@' {
+		return false
+	}
+	name, _, _ := strings.Cut(string(prelude[1:]), " ")
+	name, _, _ = strings.Cut(name, "(")
+	name = strings.ToLower(name)
+	if strings.HasPrefix(name, "-") {
+		if _, unprefixed, ok := strings.Cut(name[1:], "-"); ok {
+			name = unprefixed
+		}
+	}
+	switch name {
+	case "media", "supports", "document", "layer", "container", "scope", "starting-style", "keyframes":
+		return true
+	}
+	return false
+}
+
+// cssEscape copies the escape at i of data to out, with the space ending a
+// hexadecimal one, and returns the index after it.
+func cssEscape(data []byte, i int, out *[]byte) int {
+	start := i
+	i++
+	n := 0
+	for i < len(data) && n < 6 && isHex(data[i]) {
+		i++
+		n++
+	}
+	switch {
+	case n > 0 && i < len(data) && isSpace(data[i]):
+		i++
+	case n == 0 && i < len(data):
+		i++
+	}
+	*out = append(*out, data[start:i]...)
+	return i
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// quoted returns the index after the string starting with the quote at i
+// of data, whose lines may be continued by a backslash, or end it when
+// multiline.
+func quoted(data []byte, i int, multiline bool) (int, error) {
+	q := data[i]
+	for i++; i < len(data); i++ {
+		switch c := data[i]; {
+		case c == '\\':
+			i++
+		case c == q:
+			return i + 1, nil
+		case isNewline(c) && !multiline:
+			return 0, fmt.Errorf("%w: unterminated string", ErrInvalid)
+		}
+	}
+	return 0, fmt.Errorf("%w: unterminated string", ErrInvalid)
+}
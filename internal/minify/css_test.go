@@ -0,0 +1,50 @@
+package minify
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCSS(t *testing.T) {
+	for _, tt := range []struct {
+		name, in, want string
+	}{
+		{"spaces", "a  {\n  color : red ;\n  margin:0 auto;\n}\n", "a{color:red;margin:0 auto}"},
+		{"colon", "a{color :red}", "a{color:red}"},
+		{"colon tab", "a{color\t:\tred}", "a{color:red}"},
+		{"colon comment", "a{color/* x */:red}", "a{color:red}"},
+		{"pseudo class", "a:hover{color:red}", "a:hover{color:red}"},
+		{"descendant pseudo class", "a :hover{color :red}", "a :hover{color:red}"},
+		{"pseudo element", "p ::before{content :''}", "p ::before{content:''}"},
+		{"string", `a{content:"x : y"}`, `a{content:"x : y"}`},
+		{"string colon", `a{content :"  :  "}`, `a{content:"  :  "}`},
+		{"media", "@media (min-width : 10px){a :hover{color : red}}", "@media (min-width :10px){a :hover{color:red}}"},
+		{"nested media", "@supports (display:grid){@media print{a :first-child{b :c}}}", "@supports (display:grid){@media print{a :first-child{b:c}}}"},
+		{"after media", "@media print{a{b:c}} a :hover{b :c}", "@media print{a{b:c}}a :hover{b:c}"},
+		{"font face", "@font-face{font-family : x}", "@font-face{font-family:x}"},
+		{"keyframes", "@-webkit-keyframes x{from{opacity : 0}}", "@-webkit-keyframes x{from{opacity:0}}"},
+		{"import", "@import 'a.css' ;a :hover{b :c}", "@import 'a.css';a :hover{b:c}"},
+		{"last semicolon", "a{b:c;;}", "a{b:c;}"},
+		{"child combinator", "a > b , c{d:e}", "a>b,c{d:e}"},
+		{"kept comment", "/*! license */a{b:c}", "/*! license */a{b:c}"},
+		{"escape", `.a\:b :hover{c :d}`, `.a\:b :hover{c:d}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CSS([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSSInvalid(t *testing.T) {
+	for _, in := range []string{"a{b:c}/* x", `a{content:"x}`} {
+		if _, err := CSS([]byte(in)); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%q: got %v, want %v", in, err, ErrInvalid)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package minify
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// HTML returns the page without its comments, but the conditional ones,
+// with the spaces of its text and tags collapsed, a newline kept in place
+// of those holding one. The content of pre and textarea is kept as is, and
+// that of script and style minified as JavaScript and CSS when of those
+// types, kept as is otherwise.
+func HTML(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case isSpace(c):
+			j := i
+			newline := false
+			for j < len(data) && isSpace(data[j]) {
+				newline = newline || isNewline(data[j])
+				j++
+			}
+			switch {
+			case len(out) > 0 && isSpace(out[len(out)-1]):
+				// around a comment dropped
+				if newline {
+					out[len(out)-1] = '\n'
+				}
+			case newline:
+				out = append(out, '\n')
+			default:
+				out = append(out, ' ')
+			}
+			i = j
+		case bytes.HasPrefix(data[i:], []byte("<!--")):
+			end := bytes.Index(data[i+4:], []byte("-->"))
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated comment", ErrInvalid)
+			}
+			comment := data[i : i+4+end+3]
+			if bytes.HasPrefix(comment, []byte("<!--[")) || bytes.HasPrefix(comment, []byte("<!--<![")) {
+				out = append(out, comment...)
+			}
+			i += len(comment)
+		case bytes.HasPrefix(data[i:], []byte("<![CDATA[")):
+			end := bytes.Index(data[i:], []byte("]]>"))
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated CDATA section", ErrInvalid)
+			}
+			out = append(out, data[i:i+end+3]...)
+			i += end + 3
+		case c == '<' && i+1 < len(data) && (data[i+1] == '!' || data[i+1] == '?'):
+			end := bytes.IndexByte(data[i:], '>')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated declaration", ErrInvalid)
+			}
+			out = append(out, data[i:i+end+1]...)
+			i += end + 1
+		case c == '<' && i+1 < len(data) && (isLetter(data[i+1]) || data[i+1] == '/' && i+2 < len(data) && isLetter(data[i+2])):
+			end, err := tagEnd(data, i)
+			if err != nil {
+				return nil, err
+			}
+			tag := data[i:end]
+			out = appendTag(out, tag)
+			i = end
+			name := strings.ToLower(string(tagName(tag)))
+			if tag[1] == '/' || bytes.HasSuffix(tag, []byte("/>")) {
+				continue
+			}
+			switch name {
+			case "script", "style", "pre", "textarea":
+				closing := rawEnd(data[i:], name)
+				if closing < 0 {
+					return nil, fmt.Errorf("%w: unterminated %s", ErrInvalid, name)
+				}
+				content, err := rawContent(name, tag, data[i:i+closing])
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, content...)
+				i += closing
+			}
+		default:
+			out = append(out, c)
+			i++
+		}
+	}
+	return out, nil
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// tagEnd returns the index after the tag starting at i of data, at its
+// first > out of the quotes of its attributes.
+func tagEnd(data []byte, i int) (int, error) {
+	var q byte
+	for i++; i < len(data); i++ {
+		switch c := data[i]; {
+		case q != 0:
+			if c == q {
+				q = 0
+			}
+		case c == '"' || c == '\'':
+			q = c
+		case c == '>':
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: unterminated tag", ErrInvalid)
+}
+
+// appendTag appends the tag to out with its spaces out of quotes collapsed
+// to one, and dropped before its >.
+func appendTag(out []byte, tag []byte) []byte {
+	var q byte
+	space := false
+	for _, c := range tag {
+		switch {
+		case q != 0:
+			if c == q {
+				q = 0
+			}
+		case isSpace(c):
+			space = true
+			continue
+		case c == '"' || c == '\'':
+			q = c
+		}
+		if space && c != '>' {
+			out = append(out, ' ')
+		}
+		space = false
+		out = append(out, c)
+	}
+	return out
+}
+
+// tagName returns the name of the tag, between its < or </ and its first
+// space, / or >.
+func tagName(tag []byte) []byte {
+	name := bytes.TrimPrefix(tag[1:], []byte("/"))
+	if end := bytes.IndexFunc(name, func(r rune) bool {
+		return r == '/' || r == '>' || r < 0x80 && isSpace(byte(r))
+	}); end >= 0 {
+		name = name[:end]
+	}
+	return name
+}
+
+// rawEnd returns the index in data of the end tag of the element, -1
+// without one.
+func rawEnd(data []byte, name string) int {
+	for i := 0; ; {
+		start := bytes.Index(data[i:], []byte("</"))
+		if start < 0 {
+			return -1
+		}
+		i += start
+		if end := i + 2 + len(name); end <= len(data) && strings.EqualFold(string(data[i+2:end]), name) &&
+			(end == len(data) || data[end] == '>' || data[end] == '/' || isSpace(data[end])) {
+			return i
+		}
+		i += 2
+	}
+}
+
+// typeAttr matches the type attribute of a tag.
+var typeAttr = regexp.MustCompile(`(?i)\stype\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+
+// rawContent returns the content of the element of the tag, minified for
+// the scripts and style sheets.
+func rawContent(name string, tag []byte, content []byte) ([]byte, error) {
+	var typ string
+	if m := typeAttr.FindSubmatch(tag); m != nil {
+		typ = strings.ToLower(strings.TrimSpace(string(m[1]) + string(m[2]) + string(m[3])))
+	}
+	switch {
+	case name == "script" && (typ == "" || typ == "module" || kind(typ) == "js"):
+		return JS(content)
+	case name == "style" && (typ == "" || kind(typ) == "css"):
+		return CSS(content)
+	}
+	return content, nil
+}
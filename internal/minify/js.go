@@ -0,0 +1,221 @@
+package minify
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// JS returns the script without its comments, but the /*! ones, and with
+// its spaces collapsed: dropped between the tokens they do not separate,
+// and a newline kept in place of those holding one, for the semicolons the
+// script may leave out, but after or before a bracket, semicolon or comma.
+// The strings, template literals and regular expressions are kept as is.
+func JS(data []byte) ([]byte, error) {
+	m := jsMinifier{data: data, out: make([]byte, 0, len(data))}
+	if err := m.run(); err != nil {
+		return nil, err
+	}
+	return m.out, nil
+}
+
+type jsMinifier struct {
+	data []byte
+	out  []byte
+	// space and newline are the spaces before the next token
+	space, newline bool
+	// word is the last identifier written, for the keywords a regular
+	// expression may follow
+	word []byte
+	// depth counts the open braces, and templates the depths of the
+	// substitutions of the template literals they are in
+	depth     int
+	templates []int
+}
+
+// emit writes the space or newline pending before c.
+func (m *jsMinifier) emit(c byte) {
+	if len(m.out) > 0 {
+		last := m.out[len(m.out)-1]
+		switch {
+		case m.newline && !strings.ContainsRune("{;,([", rune(last)) && !strings.ContainsRune(")}];,", rune(c)):
+			m.out = append(m.out, '\n')
+		case (m.space || m.newline) && jsSeparates(last, c):
+			m.out = append(m.out, ' ')
+		}
+	}
+	if m.space || m.newline || !isIdent(c) {
+		m.word = m.word[:0]
+	}
+	m.space, m.newline = false, false
+}
+
+// jsSeparates tells whether a space between a and b separates two tokens.
+func jsSeparates(a, b byte) bool {
+	return isIdent(a) && isIdent(b) || a == b && (a == '+' || a == '-' || a == '/') ||
+		a == '/' && b == '*' || isDigit(a) && b == '.' || a == '<' && b == '!' || a == '-' && b == '>'
+}
+
+func isIdent(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || isDigit(c) || c == '_' || c == '$' || c == '\\' || c >= 0x80
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// jsRegexpKeywords are the keywords a regular expression may follow.
+var jsRegexpKeywords = map[string]bool{
+	"return": true, "typeof": true, "case": true, "do": true, "else": true, "in": true, "of": true,
+	"new": true, "delete": true, "void": true, "throw": true, "instanceof": true, "yield": true, "await": true,
+}
+
+// regexpAllowed tells whether a slash starts a regular expression rather
+// than a division, by the token before it.
+func (m *jsMinifier) regexpAllowed() bool {
+	if len(m.out) == 0 {
+		return true
+	}
+	last := m.out[len(m.out)-1]
+	if isIdent(last) {
+		return len(m.word) > 0 && jsRegexpKeywords[string(m.word)]
+	}
+	return strings.ContainsRune("(,=:[!&|?{};+-*%<>~^", rune(last))
+}
+
+func (m *jsMinifier) run() error {
+	data := m.data
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case isSpace(c):
+			if isNewline(c) {
+				m.newline = true
+			} else {
+				m.space = true
+			}
+			i++
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			end := bytes.IndexAny(data[i:], "\r\n")
+			if end < 0 {
+				end = len(data) - i
+			}
+			i += end
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return fmt.Errorf("%w: unterminated comment", ErrInvalid)
+			}
+			comment := data[i : i+2+end+2]
+			if bytes.ContainsAny(comment, "\r\n") {
+				m.newline = true
+			} else {
+				m.space = true
+			}
+			if i+2 < len(data) && data[i+2] == '!' {
+				m.emit('/')
+				m.out = append(m.out, comment...)
+				m.newline = true
+			}
+			i += len(comment)
+		case c == '"' || c == '\'':
+			m.emit(c)
+			end, err := quoted(data, i, false)
+			if err != nil {
+				return err
+			}
+			m.out = append(m.out, data[i:end]...)
+			i = end
+		case c == '`':
+			m.emit(c)
+			end, err := m.template(i)
+			if err != nil {
+				return err
+			}
+			i = end
+		case c == '/' && m.regexpAllowed():
+			m.emit(c)
+			end, err := jsRegexp(data, i)
+			if err != nil {
+				return err
+			}
+			m.out = append(m.out, data[i:end]...)
+			i = end
+		case c == '{':
+			m.emit(c)
+			m.out = append(m.out, c)
+			m.depth++
+			i++
+		case c == '}' && len(m.templates) > 0 && m.templates[len(m.templates)-1] == m.depth:
+			// the end of a substitution, back in its template literal
+			m.emit(c)
+			m.templates = m.templates[:len(m.templates)-1]
+			end, err := m.template(i)
+			if err != nil {
+				return err
+			}
+			i = end
+		case c == '}':
+			m.emit(c)
+			m.out = append(m.out, c)
+			m.depth--
+			i++
+		default:
+			m.emit(c)
+			m.out = append(m.out, c)
+			if isIdent(c) {
+				m.word = append(m.word, c)
+			}
+			i++
+		}
+	}
+	if len(m.templates) > 0 {
+		return fmt.Errorf("%w: unterminated template literal", ErrInvalid)
+	}
+	return nil
+}
+
+// template copies the template literal from i, its opening backtick or
+// the end of a substitution, up to its closing backtick or its next
+// substitution, and returns the index after them.
+func (m *jsMinifier) template(i int) (int, error) {
+	data := m.data
+	start := i
+	for i++; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '`':
+			m.out = append(m.out, data[start:i+1]...)
+			return i + 1, nil
+		case '$':
+			if i+1 < len(data) && data[i+1] == '{' {
+				m.out = append(m.out, data[start:i+2]...)
+				m.templates = append(m.templates, m.depth)
+				return i + 2, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("%w: unterminated template literal", ErrInvalid)
+}
+
+// jsRegexp returns the index after the regular expression starting at i
+// of data, before its flags.
+func jsRegexp(data []byte, i int) (int, error) {
+	class := false
+	for i++; i < len(data); i++ {
+		switch c := data[i]; {
+		case c == '\\':
+			i++
+		case isNewline(c):
+			return 0, fmt.Errorf("%w: unterminated regular expression", ErrInvalid)
+		case c == '[':
+			class = true
+		case c == ']':
+			class = false
+		case c == '/' && !class:
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: unterminated regular expression", ErrInvalid)
+}
@@ -0,0 +1,54 @@
+// Package minify shrinks the HTML, CSS and JavaScript articles of the ZIMs
+// without changing how they render: it drops their comments and collapses
+// their spaces, leaving everything else byte for byte, so that a document
+// it does not understand fails with ErrInvalid instead of being broken.
+package minify
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalid is the error of a document that can not be minified, e.g.
+// with an unterminated comment, string or tag, which is then kept as is.
+var ErrInvalid = errors.New("invalid document")
+
+// Minifies tells whether the MIME type is one of those Minify shrinks.
+func Minifies(mimeType string) bool {
+	return kind(mimeType) != ""
+}
+
+// Minify returns the document of the MIME type minified, the document
+// itself for the other types.
+func Minify(mimeType string, data []byte) ([]byte, error) {
+	switch kind(mimeType) {
+	case "html":
+		return HTML(data)
+	case "css":
+		return CSS(data)
+	case "js":
+		return JS(data)
+	}
+	return data, nil
+}
+
+func kind(mimeType string) string {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	switch strings.ToLower(strings.TrimSpace(mimeType)) {
+	case "text/html", "application/xhtml+xml":
+		return "html"
+	case "text/css":
+		return "css"
+	case "application/javascript", "text/javascript", "application/x-javascript", "text/ecmascript", "application/ecmascript":
+		return "js"
+	}
+	return ""
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isNewline(c byte) bool {
+	return c == '\n' || c == '\r'
+}
@@ -0,0 +1,193 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/r0qs/beezim/internal/result"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+const (
+	// SignatureHeader holds the hex encoded HMAC-SHA256 of the request
+	// body computed with the shared secret, prefixed by "sha256=".
+	SignatureHeader = "X-Beezim-Signature"
+
+	// DefaultTemplate is the summary sent in the text field of the payload.
+	DefaultTemplate = `{{.Command}} {{if .Success}}succeeded{{else}}failed{{end}}` +
+		`{{with .Inputs.ZimFile}} for {{.}}{{end}}` +
+		`{{with .Reference}}: reference {{.}}{{end}}` +
+		`{{with .Error}}: {{.}}{{end}}`
+
+	defaultRetries    = 3
+	defaultRetryDelay = 2 * time.Second
+)
+
+// permanentError is a failure that will not succeed by retrying.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Options configures the notifications.
+type Options struct {
+	// Secret signs the payload when not empty.
+	Secret string
+	// Retries is the number of attempts per url.
+	Retries    int
+	RetryDelay time.Duration
+	// Template renders the text field of the payload. DefaultTemplate is
+	// used when nil.
+	Template   *template.Template
+	HTTPClient *http.Client
+	// Logger receives the log output, the default logger when nil.
+	Logger logging.Logger
+}
+
+// Notifier posts the result of a run to webhooks.
+type Notifier struct {
+	urls []string
+	opts Options
+}
+
+// New returns a notifier posting to the given urls.
+func New(urls []string, o Options) *Notifier {
+	if o.Retries <= 0 {
+		o.Retries = defaultRetries
+	}
+	if o.RetryDelay <= 0 {
+		o.RetryDelay = defaultRetryDelay
+	}
+	if o.Template == nil {
+		o.Template = template.Must(template.New("summary").Parse(DefaultTemplate))
+	}
+	if o.HTTPClient == nil {
+		o.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &Notifier{urls: urls, opts: o}
+}
+
+// ParseTemplate parses a summary template, which is executed with the
+// run result.
+func ParseTemplate(text string) (*template.Template, error) {
+	t, err := template.New("summary").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing notification template: %v", err)
+	}
+	return t, nil
+}
+
+// Payload returns the JSON document posted for the result: the JSON
+// result with an additional "text" field holding the rendered summary.
+func (n *Notifier) Payload(res *result.Result) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := res.Write(&buf); err != nil {
+		return nil, err
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		return nil, err
+	}
+
+	var text strings.Builder
+	if err := n.opts.Template.Execute(&text, res); err != nil {
+		return nil, fmt.Errorf("error rendering notification template: %v", err)
+	}
+	doc["text"] = text.String()
+
+	return json.Marshal(doc)
+}
+
+// Notify posts the result to every url. All urls are tried even if some
+// fail, the returned error describes every failure.
+func (n *Notifier) Notify(ctx context.Context, res *result.Result) error {
+	body, err := n.Payload(res)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, u := range n.urls {
+		if err := n.postWithRetry(ctx, u, body); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", u, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("error sending notification: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// Sign returns the signature of the body for the given secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n *Notifier) postWithRetry(ctx context.Context, url string, body []byte) error {
+	var err error
+	for attempt := 1; attempt <= n.opts.Retries; attempt++ {
+		if err = n.post(ctx, url, body); err == nil {
+			return nil
+		}
+		var perr permanentError
+		if ctx.Err() != nil || errors.As(err, &perr) {
+			return err
+		}
+
+		logging.Or(n.opts.Logger).Warn("notification attempt failed", "attempt", attempt, "retries", n.opts.Retries, "url", url, "err", err)
+		if attempt == n.opts.Retries {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(n.opts.RetryDelay * time.Duration(attempt)):
+		}
+	}
+	return err
+}
+
+func (n *Notifier) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "beezim")
+	if n.opts.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(n.opts.Secret, body))
+	}
+
+	resp, err := n.opts.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("unexpected status: %v", resp.Status)
+	default:
+		return permanentError{fmt.Errorf("unexpected status: %v", resp.Status)}
+	}
+}
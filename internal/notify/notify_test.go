@@ -0,0 +1,146 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/r0qs/beezim/internal/result"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// receiver is a webhook answering the given statuses in turn, the last one
+// once they are all answered, and checking the signature of every request.
+type receiver struct {
+	t        *testing.T
+	secret   string
+	statuses []int
+
+	mu     sync.Mutex
+	bodies [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		rc.t.Error(err)
+		return
+	}
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+		rc.t.Errorf("got %s of %q", r.Method, r.Header.Get("Content-Type"))
+	}
+	mac := hmac.New(sha256.New, []byte(rc.secret))
+	mac.Write(body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := r.Header.Get(SignatureHeader); !hmac.Equal([]byte(got), []byte(want)) {
+		rc.t.Errorf("signature %q, want %q", got, want)
+	}
+
+	rc.mu.Lock()
+	rc.bodies = append(rc.bodies, body)
+	status := rc.statuses[min(len(rc.bodies), len(rc.statuses))-1]
+	rc.mu.Unlock()
+	w.WriteHeader(status)
+}
+
+func (rc *receiver) attempts() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.bodies)
+}
+
+func runResult() *result.Result {
+	r := result.New("beezim mirror")
+	r.Inputs.ZimFile = "wikipedia_en_climate_change_mini_2022-05.zim"
+	r.Reference = "b1f5c2b8"
+	r.Finish(nil)
+	return r
+}
+
+func TestNotifyRetries(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		statuses []int
+		attempts int
+		err      bool
+	}{
+		{"ok", []int{http.StatusOK}, 1, false},
+		{"retried", []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusNoContent}, 3, false},
+		{"retries exhausted", []int{http.StatusBadGateway}, 3, true},
+		// a client error is not retried
+		{"permanent", []int{http.StatusBadRequest}, 1, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := &receiver{t: t, secret: "s3cret", statuses: tt.statuses}
+			srv := httptest.NewServer(rc)
+			defer srv.Close()
+
+			n := New([]string{srv.URL}, Options{Secret: "s3cret", RetryDelay: time.Millisecond, Logger: logging.Discard()})
+			err := n.Notify(context.Background(), runResult())
+			if (err != nil) != tt.err {
+				t.Errorf("got error %v, want one %t", err, tt.err)
+			}
+			if got := rc.attempts(); got != tt.attempts {
+				t.Errorf("got %d attempts, want %d", got, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestNotifyPayload(t *testing.T) {
+	rc := &receiver{t: t, secret: "s3cret", statuses: []int{http.StatusOK}}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	// the failing url does not keep the others from being notified
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer failing.Close()
+
+	n := New([]string{failing.URL, srv.URL}, Options{Secret: "s3cret", RetryDelay: time.Millisecond, Logger: logging.Discard()})
+	err := n.Notify(context.Background(), runResult())
+	if err == nil || !strings.Contains(err.Error(), failing.URL) || strings.Contains(err.Error(), srv.URL+":") {
+		t.Errorf("got %v, want the failure of %s only", err, failing.URL)
+	}
+	if rc.attempts() != 1 {
+		t.Fatalf("got %d notifications, want 1", rc.attempts())
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(rc.bodies[0], &doc); err != nil {
+		t.Fatal(err)
+	}
+	want := "beezim mirror succeeded for wikipedia_en_climate_change_mini_2022-05.zim: reference b1f5c2b8"
+	if doc["text"] != want {
+		t.Errorf("text %q, want %q", doc["text"], want)
+	}
+	if doc["command"] != "beezim mirror" || doc["reference"] != "b1f5c2b8" || doc["success"] != true {
+		t.Errorf("payload %s, want the result", rc.bodies[0])
+	}
+}
+
+func TestNotifyCanceled(t *testing.T) {
+	rc := &receiver{t: t, secret: "s3cret", statuses: []int{http.StatusServiceUnavailable}}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n := New([]string{srv.URL}, Options{Secret: "s3cret", Retries: 5, RetryDelay: time.Hour, Logger: logging.Discard()})
+	time.AfterFunc(50*time.Millisecond, cancel)
+	err := n.Notify(ctx, runResult())
+	if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Errorf("got %v, want the notification canceled", err)
+	}
+	if rc.attempts() != 1 {
+		t.Errorf("got %d attempts, want 1 before the cancellation", rc.attempts())
+	}
+}
@@ -0,0 +1,145 @@
+// Package offline rewrites the HTML pages of the ZIMs so that browsing them
+// sends no request out of the archive: the scripts, style sheets and images
+// they load from other sites are dropped, and their links to other sites
+// made plain text, or opened in a new tab without referrer.
+//
+// The pages are read with the tolerant tokenizer of htmltag, everything but
+// the tags rewritten being kept as it is, so that the links in the archive
+// are kept byte for byte.
+package offline
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/r0qs/beezim/internal/htmltag"
+)
+
+// Stats are the rewrites of a page.
+type Stats struct {
+	// Links is the number of links to other sites rewritten.
+	Links int
+	// Resources is the number of scripts, style sheets and images of
+	// other sites dropped.
+	Resources int
+}
+
+// Rewrite returns the page with the resources of other sites dropped, and
+// its links to other sites made plain text with strip, opened in a new tab
+// without referrer otherwise.
+func Rewrite(data []byte, strip bool) ([]byte, Stats) {
+	var stats Stats
+	out := make([]byte, 0, len(data))
+	// anchors tells for each open a element whether its tags are dropped,
+	// and script whether the content and end tag of a script are
+	var anchors []bool
+	script := false
+	z := htmltag.NewTokenizer(data)
+	for {
+		t, ok := z.Next()
+		if !ok {
+			break
+		}
+		switch {
+		case script && (t.Kind == htmltag.RawText || t.Kind == htmltag.EndTag && t.Name == "script"):
+			script = t.Kind == htmltag.RawText
+			continue
+		case t.Kind == htmltag.EndTag && t.Name == "a":
+			if n := len(anchors); n > 0 {
+				dropped := anchors[n-1]
+				anchors = anchors[:n-1]
+				if dropped {
+					continue
+				}
+			}
+		case t.Kind != htmltag.StartTag:
+		case t.Name == "a":
+			href, ok := t.Attr("href")
+			if !ok || !Remote(href) {
+				anchors = append(anchors, false)
+				break
+			}
+			stats.Links++
+			anchors = append(anchors, strip)
+			if strip {
+				continue
+			}
+			rel, _ := t.Attr("rel")
+			out = t.AppendWith(out, map[string]string{"rel": addRel(rel, "noreferrer", "noopener"), "target": "_blank"})
+			continue
+		case t.Name == "script":
+			if src, ok := t.Attr("src"); ok && Remote(src) {
+				stats.Resources++
+				script = true
+				continue
+			}
+		case t.Name == "link":
+			if href, ok := t.Attr("href"); ok && Remote(href) {
+				stats.Resources++
+				continue
+			}
+		case t.Name == "img":
+			if src, ok := t.Attr("src"); ok && Remote(src) {
+				stats.Resources++
+				continue
+			}
+			if srcset, ok := t.Attr("srcset"); ok && remoteSrcset(srcset) {
+				stats.Resources++
+				out = t.AppendWith(out, map[string]string{"srcset": ""})
+				continue
+			}
+		}
+		out = append(out, t.Raw...)
+	}
+	return out, stats
+}
+
+// Remote tells whether the URL is that of another site: absolute, e.g.
+// https://example.org/a.png, or relative to the scheme, e.g.
+// //example.org/a.png, rather than a path in the archive, a fragment, or
+// a data, mailto or javascript URL.
+func Remote(u string) bool {
+	// the browsers ignore the spaces around the URLs, and the tabs and
+	// newlines in them
+	u = strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(u))
+	if strings.HasPrefix(u, "//") || strings.HasPrefix(u, `\\`) || strings.HasPrefix(u, `/\`) {
+		return true
+	}
+	scheme, _, ok := strings.Cut(u, ":")
+	if !ok || strings.ContainsAny(scheme, "/?#") {
+		return false
+	}
+	switch strings.ToLower(scheme) {
+	case "http", "https", "ftp", "ws", "wss":
+		return true
+	}
+	return false
+}
+
+// addRel returns the rel attribute with the link types added, keeping
+// those it has.
+func addRel(rel string, types ...string) string {
+	fields := strings.Fields(rel)
+	for _, t := range types {
+		if !slices.ContainsFunc(fields, func(f string) bool { return strings.EqualFold(f, t) }) {
+			fields = append(fields, t)
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// remoteSrcset tells whether one of the images of the srcset attribute is
+// that of another site.
+func remoteSrcset(srcset string) bool {
+	for _, candidate := range strings.Split(srcset, ",") {
+		if fields := strings.Fields(candidate); len(fields) > 0 && Remote(fields[0]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package offline
+
+import "testing"
+
+func TestRewrite(t *testing.T) {
+	for _, tt := range []struct {
+		name, page       string
+		annotated, strip string
+		stats            Stats
+	}{
+		{
+			name:      "remote script",
+			page:      `<head><script async src="https://www.googletagmanager.com/gtag/js?id=G-1"></script><script>var a = "</p>";</script></head>`,
+			annotated: `<head><script>var a = "</p>";</script></head>`,
+			stats:     Stats{Resources: 1},
+		},
+		{
+			name:      "script in the archive",
+			page:      `<script src="../-/j/js_modules/jsConfigVars.js"></script>`,
+			annotated: `<script src="../-/j/js_modules/jsConfigVars.js"></script>`,
+		},
+		{
+			name:      "remote stylesheet and font",
+			page:      `<link rel="stylesheet" href="//fonts.googleapis.com/css?family=Lato"><link rel="stylesheet" href="../-/s/style.css">`,
+			annotated: `<link rel="stylesheet" href="../-/s/style.css">`,
+			stats:     Stats{Resources: 1},
+		},
+		{
+			name:      "remote image",
+			page:      `<p><img src="https://upload.wikimedia.org/a.png" alt="a"> <IMG SRC='../I/b.png'></p>`,
+			annotated: `<p> <IMG SRC='../I/b.png'></p>`,
+			stats:     Stats{Resources: 1},
+		},
+		{
+			name:      "remote srcset",
+			page:      `<img src="../I/c.png" srcset="../I/c-2x.png 2x, https://upload.wikimedia.org/c-3x.png 3x">`,
+			annotated: `<img src="../I/c.png">`,
+			stats:     Stats{Resources: 1},
+		},
+		{
+			name:      "external link",
+			page:      `<p>See <a class="external" href="https://example.org/">the site</a>.</p>`,
+			annotated: `<p>See <a class="external" href="https://example.org/" rel="noreferrer noopener" target="_blank">the site</a>.</p>`,
+			strip:     `<p>See the site.</p>`,
+			stats:     Stats{Links: 1},
+		},
+		{
+			name:      "external link with rel",
+			page:      `<a rel="nofollow" href=http://example.org target=_self>x</a>`,
+			annotated: `<a rel="nofollow noreferrer noopener" href=http://example.org target="_blank">x</a>`,
+			strip:     `x`,
+			stats:     Stats{Links: 1},
+		},
+		{
+			name: "anchors out of and in the archive",
+			// the anchor in the archive is kept with its end tag
+			page:      `<a href="https://example.org">out</a><a href="Brazil#History">in <b>b</b></a>`,
+			annotated: `<a href="https://example.org" rel="noreferrer noopener" target="_blank">out</a><a href="Brazil#History">in <b>b</b></a>`,
+			strip:     `out<a href="Brazil#History">in <b>b</b></a>`,
+			stats:     Stats{Links: 1},
+		},
+		{
+			name:      "links in the archive",
+			page:      "<a href=\"../A/S%C3%A3o_Paulo\">S\u00e3o Paulo</a><a href='#cite_note-1'>[1]</a><a href=\"mailto:a@example.org\">mail</a><a href=\"data:text/plain,a\">data</a><a>none</a>",
+			annotated: "<a href=\"../A/S%C3%A3o_Paulo\">S\u00e3o Paulo</a><a href='#cite_note-1'>[1]</a><a href=\"mailto:a@example.org\">mail</a><a href=\"data:text/plain,a\">data</a><a>none</a>",
+		},
+		{
+			name:      "malformed",
+			page:      `<p>unclosed <a href="https://example.org">link <img src="https://example.org/x.png"`,
+			annotated: `<p>unclosed <a href="https://example.org" rel="noreferrer noopener" target="_blank">link <img src="https://example.org/x.png"`,
+			strip:     `<p>unclosed link <img src="https://example.org/x.png"`,
+			stats:     Stats{Links: 1},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			strip := tt.strip
+			if strip == "" {
+				strip = tt.annotated
+			}
+			for _, want := range []struct {
+				strip bool
+				page  string
+			}{{false, tt.annotated}, {true, strip}} {
+				got, stats := Rewrite([]byte(tt.page), want.strip)
+				if string(got) != want.page {
+					t.Errorf("strip %t:\ngot  %s\nwant %s", want.strip, got, want.page)
+				}
+				if stats != tt.stats {
+					t.Errorf("strip %t: got %+v, want %+v", want.strip, stats, tt.stats)
+				}
+			}
+		})
+	}
+}
+
+func TestRemote(t *testing.T) {
+	for u, want := range map[string]bool{
+		"https://example.org/a.png":  true,
+		"HTTP://example.org":         true,
+		"//example.org/a.png":        true,
+		" \thttps://example.org":     true,
+		"ht\ntps://example.org":      true,
+		`\\example.org`:              true,
+		"wss://example.org/socket":   true,
+		"../I/a.png":                 false,
+		"A/Foo:Bar":                  false,
+		"Foo?q=http://example.org":   false,
+		"#top":                       false,
+		"mailto:a@example.org":       false,
+		"javascript:void(0)":         false,
+		"data:image/png;base64,AAAA": false,
+		"":                           false,
+	} {
+		if got := Remote(u); got != want {
+			t.Errorf("Remote(%q) = %t, want %t", u, got, want)
+		}
+	}
+}
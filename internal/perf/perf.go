@@ -0,0 +1,143 @@
+// Package perf measures the throughput of the pipeline stages from their
+// progress events, and samples the memory and GC of the process while
+// they run.
+package perf
+
+import (
+	"runtime"
+	"runtime/metrics"
+	"sync"
+	"time"
+
+	"github.com/r0qs/beezim/internal/progress"
+	"github.com/r0qs/beezim/internal/result"
+)
+
+// SampleInterval is the interval at which the memory is sampled.
+const SampleInterval = 100 * time.Millisecond
+
+// Collector is a progress reporter recording the items and bytes done by
+// each stage, between its first and last event.
+type Collector struct {
+	mu      sync.Mutex
+	stages  map[string]*stage
+	order   []string
+	peakMem uint64
+
+	gcCycles   uint64
+	gcPause    uint64
+	allocs     uint64
+	allocBytes uint64
+	stop       chan struct{}
+	stopOnce   sync.Once
+	done       chan struct{}
+}
+
+type stage struct {
+	first, last time.Time
+	items       int64
+	bytes       int64
+}
+
+// Start returns a collector sampling the memory until Stop.
+func Start() *Collector {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	c := &Collector{
+		stages:     make(map[string]*stage),
+		gcCycles:   uint64(ms.NumGC),
+		gcPause:    ms.PauseTotalNs,
+		allocs:     ms.Mallocs,
+		allocBytes: ms.TotalAlloc,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go c.sample()
+	return c
+}
+
+// Report records the event of a stage.
+func (c *Collector) Report(e progress.Event) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.stages[e.Stage]
+	if !ok {
+		s = &stage{first: now}
+		c.stages[e.Stage] = s
+		c.order = append(c.order, e.Stage)
+	}
+	s.last = now
+	// counts are cumulative, a resumed download starts past zero
+	if e.Done > s.items {
+		s.items = e.Done
+	}
+	if e.Bytes > s.bytes {
+		s.bytes = e.Bytes
+	}
+}
+
+var memorySamples = []metrics.Sample{
+	{Name: "/memory/classes/total:bytes"},
+	{Name: "/memory/classes/heap/released:bytes"},
+}
+
+func (c *Collector) sample() {
+	defer close(c.done)
+	samples := make([]metrics.Sample, len(memorySamples))
+	copy(samples, memorySamples)
+	t := time.NewTicker(SampleInterval)
+	defer t.Stop()
+	for {
+		metrics.Read(samples)
+		if samples[0].Value.Kind() == metrics.KindUint64 && samples[1].Value.Kind() == metrics.KindUint64 {
+			held := samples[0].Value.Uint64() - samples[1].Value.Uint64()
+			c.mu.Lock()
+			if held > c.peakMem {
+				c.peakMem = held
+			}
+			c.mu.Unlock()
+		}
+		select {
+		case <-c.stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Stop stops the sampling and returns the performance of the stages
+// reported, in the order they started.
+func (c *Collector) Stop() *result.Performance {
+	c.stopOnce.Do(func() { close(c.stop) })
+	<-c.done
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := &result.Performance{
+		PeakRSS:        peakRSS(),
+		PeakGoMemory:   int64(c.peakMem),
+		GCCycles:       uint64(ms.NumGC) - c.gcCycles,
+		GCPauseSeconds: time.Duration(ms.PauseTotalNs - c.gcPause).Seconds(),
+		Allocs:         ms.Mallocs - c.allocs,
+		AllocBytes:     ms.TotalAlloc - c.allocBytes,
+	}
+	for _, name := range c.order {
+		s := c.stages[name]
+		sp := result.StagePerformance{
+			Stage:   name,
+			Seconds: s.last.Sub(s.first).Seconds(),
+			Items:   s.items,
+			Bytes:   s.bytes,
+		}
+		if sp.Seconds > 0 {
+			sp.ItemsPerSecond = float64(s.items) / sp.Seconds
+			sp.BytesPerSecond = float64(s.bytes) / sp.Seconds
+		}
+		p.Stages = append(p.Stages, sp)
+	}
+	return p
+}
@@ -0,0 +1,161 @@
+package perf
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/indexer/zimtest"
+	"github.com/r0qs/beezim/internal/progress"
+	"github.com/r0qs/beezim/internal/result"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// fixtureTar writes the tar of a ZIM of n articles, an image and a
+// redirect, reporting the stages to rep, and returns the number of files
+// of the tar and the bytes of their content.
+func fixtureTar(t *testing.T, n int, rep progress.Reporter) (files int64, size int64) {
+	t.Helper()
+	entries := []zimtest.Entry{
+		{Namespace: 'I', URL: "logo.png", Mime: "image/png", Content: []byte("\x89PNG logo")},
+		zimtest.Redirect('A', "Home", 1),
+	}
+	for i := range n {
+		url := fmt.Sprintf("Article%d", i)
+		entries = append(entries, zimtest.Entry{Namespace: 'A', URL: url, Title: url, Mime: "text/html", Content: bytes.Repeat([]byte("<p>"+url+"</p>"), 64)})
+	}
+	dir := t.TempDir()
+	zimPath := filepath.Join(dir, "fixture.zim")
+	if err := zimtest.New(entries...).WriteFile(zimPath); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := indexer.New(zimPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	idx.Logger = logging.Discard()
+
+	ctx := progress.WithReporter(context.Background(), rep)
+	tarFile := filepath.Join(dir, "fixture.tar")
+	if err := idx.TarZimBatches(ctx, tarFile, idx.ParseZIMBatches(ctx)); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.ParseErr(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(tarFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			files++
+			size += hdr.Size
+		}
+	}
+	return files, size
+}
+
+// TestFixtureRun checks the performance of the parse and the tar of a
+// fixture against the tar written.
+func TestFixtureRun(t *testing.T) {
+	c := Start()
+	files, size := fixtureTar(t, 500, c)
+	runtime.GC()
+	p := c.Stop()
+
+	if len(p.Stages) != 2 || p.Stages[0].Stage != "parse" || p.Stages[1].Stage != "tar" {
+		t.Fatalf("got stages %+v, want the parse then the tar", p.Stages)
+	}
+	for _, s := range p.Stages {
+		// every file of the tar is parsed, then written
+		if s.Items != files || s.Bytes != size {
+			t.Errorf("%s: %d items of %d bytes, want the %d files of %d bytes of the tar", s.Stage, s.Items, s.Bytes, files, size)
+		}
+		if s.Seconds <= 0 {
+			t.Errorf("%s: took %v seconds", s.Stage, s.Seconds)
+			continue
+		}
+		if !near(s.ItemsPerSecond*s.Seconds, float64(s.Items)) || !near(s.BytesPerSecond*s.Seconds, float64(s.Bytes)) {
+			t.Errorf("%s: %.0f items/s and %.0f B/s over %vs, want %d items of %d bytes", s.Stage, s.ItemsPerSecond, s.BytesPerSecond, s.Seconds, s.Items, s.Bytes)
+		}
+	}
+	if p.PeakGoMemory <= 0 || p.Allocs == 0 || p.AllocBytes == 0 {
+		t.Errorf("peak Go memory %d, %d allocations of %d bytes", p.PeakGoMemory, p.Allocs, p.AllocBytes)
+	}
+	if p.GCCycles == 0 {
+		t.Errorf("no GC cycle counted, one was forced")
+	}
+	if runtime.GOOS == "linux" && p.PeakRSS < p.PeakGoMemory/2 {
+		t.Errorf("peak RSS %d, under half the peak Go memory %d", p.PeakRSS, p.PeakGoMemory)
+	}
+
+	// embedded in the JSON result
+	res := result.New("beezim parse")
+	res.SetPerformance(p)
+	res.Finish(nil)
+	var buf bytes.Buffer
+	if err := res.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Performance result.Performance `json:"performance"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if got := doc.Performance; len(got.Stages) != 2 || got.Stages[1] != p.Stages[1] || got.PeakGoMemory != p.PeakGoMemory || got.AllocBytes != p.AllocBytes {
+		t.Errorf("result holds %+v, want %+v", got, *p)
+	}
+}
+
+func TestCollectorCumulative(t *testing.T) {
+	c := Start()
+	// a download resumed past its first half
+	c.Report(progress.Event{Stage: "download", Bytes: 50, TotalBytes: 100})
+	c.Report(progress.Event{Stage: "download", Bytes: 100, TotalBytes: 100, Finished: true})
+	c.Report(progress.Event{Stage: "verify", Done: 3, Total: 3})
+	// a late event of a stage does not lower its counts
+	c.Report(progress.Event{Stage: "download", Bytes: 80})
+	p := c.Stop()
+
+	want := []result.StagePerformance{{Stage: "download", Bytes: 100}, {Stage: "verify", Items: 3}}
+	if len(p.Stages) != len(want) {
+		t.Fatalf("got stages %+v, want %+v", p.Stages, want)
+	}
+	for i, s := range p.Stages {
+		if s.Stage != want[i].Stage || s.Items != want[i].Items || s.Bytes != want[i].Bytes {
+			t.Errorf("got %+v, want %+v", s, want[i])
+		}
+	}
+	// a stage of a single event has no duration nor rate
+	if s := p.Stages[1]; s.Seconds != 0 || s.ItemsPerSecond != 0 {
+		t.Errorf("verify: %+v, want no rate", s)
+	}
+	// Stop may be called again
+	c.Stop()
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-6*math.Max(1, math.Abs(b))
+}
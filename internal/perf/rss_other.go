@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !freebsd
+
+package perf
+
+func peakRSS() int64 {
+	return 0
+}
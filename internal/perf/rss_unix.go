@@ -0,0 +1,21 @@
+//go:build linux || darwin || freebsd
+
+package perf
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// peakRSS returns the peak resident memory of the process.
+func peakRSS() int64 {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	// kilobytes, but bytes on darwin
+	if runtime.GOOS == "darwin" {
+		return int64(ru.Maxrss)
+	}
+	return int64(ru.Maxrss) << 10
+}
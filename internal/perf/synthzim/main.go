@@ -0,0 +1,44 @@
+// Command synthzim writes a ZIM of synthetic HTML articles, e.g. for the
+// memory benchmark of the entry stores, make benchentries, or of the
+// buffer pools, make benchpool, with articles of -size bytes.
+//
+//	synthzim -entries 5000000 -o datadir/synth.zim
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/r0qs/beezim/indexer/zimtest"
+)
+
+func main() {
+	entries := flag.Int("entries", 1000000, "number of articles")
+	out := flag.String("o", "synth.zim", "path of the zim written")
+	size := flag.Int("size", 0, "size in bytes of the articles, padded with text")
+	flag.Parse()
+
+	content := []byte("<html><body>synthetic</body></html>")
+	if pad := *size - len(content); pad > 0 {
+		content = []byte("<html><body>synthetic " + strings.Repeat("x", pad-1) + "</body></html>")
+	}
+	r := zimtest.New()
+	r.Entries = make([]zimtest.Entry, 0, *entries)
+	for i := range *entries {
+		r.Entries = append(r.Entries, zimtest.Entry{
+			Namespace: 'A',
+			URL:       fmt.Sprintf("Article_%09d", i),
+			Title:     fmt.Sprintf("Article %d", i),
+			Mime:      "text/html",
+			Content:   content,
+		})
+	}
+	// the first article is the main page, so that no listing of the
+	// articles is built
+	r.Main = 0
+	if err := r.WriteFile(*out); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,114 @@
+package postage
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// DefaultBlockTime is the block time of the chain where the postage
+// contract is deployed.
+const DefaultBlockTime = 5 * time.Second
+
+// ActionKind is what the maintenance does with a batch.
+type ActionKind string
+
+const (
+	KindOK    ActionKind = "ok"
+	KindTopUp ActionKind = "topup"
+	KindAlert ActionKind = "alert"
+)
+
+// Batch is a postage batch used by published mirrors.
+type Batch struct {
+	ID    string
+	Depth uint8
+	// TTL is the remaining time to live in seconds, as estimated by the node.
+	TTL int64
+	// Exists is false when the node no longer knows the batch, usually
+	// because it expired.
+	Exists bool
+	Roots  []string
+}
+
+// Policy defines when and how much batches are topped up.
+type Policy struct {
+	// Threshold is the TTL below which a batch is topped up.
+	Threshold time.Duration
+	// Target is the TTL a topped up batch should reach.
+	Target    time.Duration
+	BlockTime time.Duration
+	// MaxSpend caps the total cost of the top-ups, including the already
+	// spent amount given to Plan. Batches are only reported when nil.
+	MaxSpend *big.Int
+}
+
+// Action is the planned maintenance of a batch.
+type Action struct {
+	BatchID string     `json:"batchID"`
+	Depth   uint8      `json:"depth"`
+	TTL     int64      `json:"ttl"`
+	Kind    ActionKind `json:"kind"`
+	// Amount is the top-up amount per chunk.
+	Amount int64 `json:"amount,omitempty"`
+	// Cost is the total cost of the top-up (amount per chunk times the
+	// number of chunks of the batch) in PLUR.
+	Cost   *big.Int `json:"cost,omitempty"`
+	NewTTL int64    `json:"newTTL,omitempty"`
+	Reason string   `json:"reason,omitempty"`
+	Roots  []string `json:"roots,omitempty"`
+}
+
+// Plan returns the action for each batch. price is the current price per
+// chunk per block and spent what was already spent in the cap period.
+func Plan(batches []Batch, price *big.Int, spent *big.Int, p Policy) []Action {
+	if p.BlockTime <= 0 {
+		p.BlockTime = DefaultBlockTime
+	}
+	total := new(big.Int)
+	if spent != nil {
+		total.Set(spent)
+	}
+
+	actions := make([]Action, 0, len(batches))
+	for _, b := range batches {
+		a := Action{BatchID: b.ID, Depth: b.Depth, TTL: b.TTL, Kind: KindOK, Roots: b.Roots}
+		ttl := time.Duration(b.TTL) * time.Second
+
+		switch {
+		case !b.Exists:
+			a.Kind = KindAlert
+			a.Reason = "batch not found, it may have expired"
+		case ttl >= p.Threshold:
+		case price == nil || price.Sign() <= 0:
+			a.Kind = KindAlert
+			a.Reason = "current postage price unknown"
+		default:
+			blocks := int64((p.Target - ttl + p.BlockTime - 1) / p.BlockTime)
+			amount := new(big.Int).Mul(big.NewInt(blocks), price)
+			if !amount.IsInt64() {
+				a.Kind = KindAlert
+				a.Reason = "top-up amount overflows"
+				break
+			}
+			a.Amount = amount.Int64()
+			a.Cost = new(big.Int).Lsh(amount, uint(b.Depth))
+			a.NewTTL = int64(p.Target / time.Second)
+
+			if p.MaxSpend == nil {
+				a.Kind = KindAlert
+				a.Reason = fmt.Sprintf("TTL below %v and no spending cap configured", p.Threshold)
+				break
+			}
+			if next := new(big.Int).Add(total, a.Cost); next.Cmp(p.MaxSpend) > 0 {
+				a.Kind = KindAlert
+				a.Reason = fmt.Sprintf("top-up of %v would exceed the spending cap of %v (already spent %v)", a.Cost, p.MaxSpend, total)
+				break
+			}
+			total.Add(total, a.Cost)
+			a.Kind = KindTopUp
+		}
+		actions = append(actions, a)
+	}
+	return actions
+}
@@ -0,0 +1,88 @@
+package postage
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// price is the price per chunk per block of the chain state.
+var price = big.NewInt(24000)
+
+// batch returns a batch of depth 17 with the given TTL.
+func batch(id string, ttl time.Duration) Batch {
+	return Batch{ID: id, Depth: 17, TTL: int64(ttl / time.Second), Exists: true, Roots: []string{"root-" + id}}
+}
+
+// topUpCost is the cost of bringing a batch of depth 17 from ttl to 30 days.
+func topUpCost(ttl time.Duration) (amount int64, cost *big.Int) {
+	blocks := int64((30*day - ttl) / DefaultBlockTime)
+	amount = blocks * price.Int64()
+	return amount, new(big.Int).Lsh(big.NewInt(amount), 17)
+}
+
+func TestPlan(t *testing.T) {
+	amount, cost := topUpCost(day)
+	_, costTwo := topUpCost(2 * day)
+	for _, tt := range []struct {
+		name    string
+		batches []Batch
+		price   *big.Int
+		spent   *big.Int
+		cap     *big.Int
+		want    []ActionKind
+	}{
+		{"above threshold", []Batch{batch("a", 8*day)}, price, nil, cost, []ActionKind{KindOK}},
+		{"at threshold", []Batch{batch("a", 7*day)}, price, nil, cost, []ActionKind{KindOK}},
+		{"below threshold", []Batch{batch("a", day)}, price, nil, cost, []ActionKind{KindTopUp}},
+		{"no cap", []Batch{batch("a", day)}, price, nil, nil, []ActionKind{KindAlert}},
+		{"cap hit", []Batch{batch("a", day)}, price, nil, new(big.Int).Sub(cost, big.NewInt(1)), []ActionKind{KindAlert}},
+		// what was spent in the period counts against the cap
+		{"cap hit by spent", []Batch{batch("a", day)}, price, big.NewInt(1), cost, []ActionKind{KindAlert}},
+		// the second top-up no longer fits, the third batch does not need one
+		{"cap hit by second", []Batch{batch("a", day), batch("b", 2*day), batch("c", 10*day)}, price, nil, new(big.Int).Add(cost, big.NewInt(1)), []ActionKind{KindTopUp, KindAlert, KindOK}},
+		{"cap of both", []Batch{batch("a", day), batch("b", 2*day)}, price, nil, new(big.Int).Add(cost, costTwo), []ActionKind{KindTopUp, KindTopUp}},
+		{"expired", []Batch{{ID: "a", Roots: []string{"root-a"}}}, price, nil, cost, []ActionKind{KindAlert}},
+		{"price unknown", []Batch{batch("a", day)}, nil, nil, cost, []ActionKind{KindAlert}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			actions := Plan(tt.batches, tt.price, tt.spent, Policy{Threshold: 7 * day, Target: 30 * day, MaxSpend: tt.cap})
+			if len(actions) != len(tt.want) {
+				t.Fatalf("got %d actions, want %d", len(actions), len(tt.want))
+			}
+			for i, a := range actions {
+				if a.Kind != tt.want[i] {
+					t.Errorf("%s: %s (%s), want %s", a.BatchID, a.Kind, a.Reason, tt.want[i])
+				}
+				if a.BatchID != tt.batches[i].ID || len(a.Roots) != 1 || a.Roots[0] != "root-"+a.BatchID {
+					t.Errorf("action %+v of batch %s", a, tt.batches[i].ID)
+				}
+				if a.Kind == KindAlert && a.Reason == "" {
+					t.Errorf("%s: alert without reason", a.BatchID)
+				}
+			}
+		})
+	}
+
+	a := Plan([]Batch{batch("a", day)}, price, nil, Policy{Threshold: 7 * day, Target: 30 * day, MaxSpend: cost})[0]
+	if a.Amount != amount || a.Cost.Cmp(cost) != 0 || a.NewTTL != int64(30*day/time.Second) {
+		t.Errorf("top-up of %d per chunk costing %v to a TTL of %d, want %d costing %v to 30 days", a.Amount, a.Cost, a.NewTTL, amount, cost)
+	}
+}
+
+func TestPlanBlockTime(t *testing.T) {
+	p := Policy{Threshold: 7 * day, Target: 30 * day, BlockTime: 10 * time.Second, MaxSpend: big.NewInt(1 << 62)}
+	a := Plan([]Batch{batch("a", day)}, price, nil, p)[0]
+	amount, _ := topUpCost(day)
+	if a.Kind != KindTopUp || a.Amount != amount/2 {
+		t.Errorf("got %s of %d, want half the amount with twice the block time", a.Kind, a.Amount)
+	}
+
+	// an amount per chunk not held by an int64
+	a = Plan([]Batch{batch("a", day)}, new(big.Int).Lsh(big.NewInt(1), 60), nil, p)[0]
+	if a.Kind != KindAlert {
+		t.Errorf("got %s, want an alert for the overflow", a.Kind)
+	}
+}
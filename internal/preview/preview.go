@@ -0,0 +1,335 @@
+package preview
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/r0qs/beezim/internal/tarball"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// Source is a collection of files served by the preview server.
+type Source interface {
+	// Open returns the content of a regular file.
+	Open(name string) (io.ReadSeekCloser, time.Time, error)
+	// List returns the names of the entries directly under dir.
+	List(dir string) []string
+	Close() error
+}
+
+// Options mirrors the collection options used when uploading to bee.
+type Options struct {
+	IndexDocument string
+	ErrorDocument string
+	// Listing enables directory listings for paths without an index document.
+	Listing bool
+	// MetadataFile is the entries metadata file generated by the indexer
+	// used to resolve the mime type of the files.
+	MetadataFile string
+	// RedirectsFile lists the redirects the uploader adds to the manifest,
+	// served as their target.
+	RedirectsFile string
+	// PathsFile lists the files extracted under another name than their
+	// path, served at their path.
+	PathsFile string
+	// Logger receives the log output, the default logger when nil.
+	Logger logging.Logger
+}
+
+type server struct {
+	src       Source
+	opts      Options
+	mimeTypes map[string]string
+	redirects map[string]string
+	paths     map[string]string
+}
+
+// NewHandler returns a handler serving the files of the source the same
+// way bee serves an uploaded collection.
+func NewHandler(src Source, o Options) http.Handler {
+	o.Logger = logging.Or(o.Logger)
+	s := &server{
+		src:       src,
+		opts:      o,
+		mimeTypes: make(map[string]string),
+		redirects: make(map[string]string),
+		paths:     make(map[string]string),
+	}
+	if o.MetadataFile != "" {
+		s.loadMimeTypes(o.MetadataFile)
+	}
+	if o.RedirectsFile != "" {
+		s.loadRedirects(o.RedirectsFile)
+	}
+	if o.PathsFile != "" {
+		s.loadPaths(o.PathsFile)
+	}
+	return s
+}
+
+func (s *server) loadMimeTypes(name string) {
+	r, _, err := s.src.Open(name)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+
+	var entries map[string]struct {
+		Metadata struct {
+			MimeType string
+		}
+	}
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		s.opts.Logger.Error("error reading metadata file", "file", name, "err", err)
+		return
+	}
+
+	for p, e := range entries {
+		if e.Metadata.MimeType != "" {
+			s.mimeTypes[p] = e.Metadata.MimeType
+		}
+	}
+}
+
+func (s *server) loadRedirects(name string) {
+	r, _, err := s.src.Open(name)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+
+	var redirects []struct {
+		Path   string `json:"path"`
+		Target string `json:"target"`
+	}
+	if err := json.NewDecoder(r).Decode(&redirects); err != nil {
+		s.opts.Logger.Error("error reading redirects file", "file", name, "err", err)
+		return
+	}
+	for _, rd := range redirects {
+		s.redirects[rd.Path] = rd.Target
+	}
+}
+
+func (s *server) loadPaths(name string) {
+	r, _, err := s.src.Open(name)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+
+	var paths []struct {
+		Path string `json:"path"`
+		URL  string `json:"url"`
+	}
+	if err := json.NewDecoder(r).Decode(&paths); err != nil {
+		s.opts.Logger.Error("error reading paths file", "file", name, "err", err)
+		return
+	}
+	for _, p := range paths {
+		s.paths[p.URL] = p.Path
+	}
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name != "" && s.serveFile(w, r, name, http.StatusOK) {
+		return
+	}
+	if target, ok := s.redirects[name]; ok && s.serveFile(w, r, target, http.StatusOK) {
+		return
+	}
+
+	if s.opts.IndexDocument != "" && s.serveFile(w, r, path.Join(name, s.opts.IndexDocument), http.StatusOK) {
+		return
+	}
+
+	if s.opts.Listing {
+		if children := s.src.List(name); len(children) > 0 {
+			s.serveListing(w, name, children)
+			return
+		}
+	}
+
+	if s.opts.ErrorDocument != "" && s.serveFile(w, r, s.opts.ErrorDocument, http.StatusNotFound) {
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// serveFile writes the named file and reports whether it exists.
+func (s *server) serveFile(w http.ResponseWriter, r *http.Request, name string, status int) bool {
+	file := name
+	if p, ok := s.paths[name]; ok {
+		file = p
+	}
+	content, modTime, err := s.src.Open(file)
+	if err != nil {
+		return false
+	}
+	defer content.Close()
+
+	if mimeType, ok := s.mimeTypes[name]; ok {
+		w.Header().Set("Content-Type", mimeType)
+	}
+
+	if status != http.StatusOK {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		w.WriteHeader(status)
+		if r.Method != http.MethodHead {
+			io.Copy(w, content)
+		}
+		return true
+	}
+
+	http.ServeContent(w, r, path.Base(name), modTime, content)
+	return true
+}
+
+var listingTmpl = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Index of /{{ .Dir }}</title></head>
+<body>
+<h1>Index of /{{ .Dir }}</h1>
+<ul>
+{{ range .Entries }}<li><a href="/{{ . }}">{{ . }}</a></li>
+{{ end }}</ul>
+</body>
+</html>
+`))
+
+func (s *server) serveListing(w http.ResponseWriter, dir string, entries []string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := listingTmpl.Execute(w, map[string]interface{}{
+		"Dir":     dir,
+		"Entries": entries,
+	}); err != nil {
+		s.opts.Logger.Error("error rendering listing", "dir", dir, "err", err)
+	}
+}
+
+type tarSource struct {
+	archive *tarball.Archive
+}
+
+// NewTarSource returns a source reading the files of a tar archive.
+func NewTarSource(tarFile string) (Source, error) {
+	a, err := tarball.OpenArchive(tarFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tarSource{archive: a}, nil
+}
+
+// sectionFile adapts the archive readers to the Source interface.
+type sectionFile struct {
+	*io.SectionReader
+}
+
+func (sectionFile) Close() error {
+	return nil
+}
+
+func (s *tarSource) Open(name string) (io.ReadSeekCloser, time.Time, error) {
+	r, hdr, err := s.archive.Open(name)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if !hdr.FileInfo().Mode().IsRegular() {
+		return nil, time.Time{}, os.ErrNotExist
+	}
+	return sectionFile{r}, hdr.ModTime, nil
+}
+
+func (s *tarSource) List(dir string) []string {
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+
+	seen := make(map[string]bool)
+	var children []string
+	for _, name := range s.archive.Names() {
+		if !strings.HasPrefix(name, prefix) || name == dir {
+			continue
+		}
+		child := prefix + strings.SplitN(strings.TrimPrefix(name, prefix), "/", 2)[0]
+		if !seen[child] {
+			seen[child] = true
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+func (s *tarSource) Close() error {
+	return s.archive.Close()
+}
+
+type dirSource struct {
+	root string
+}
+
+// NewDirSource returns a source reading the files of an extracted zim.
+func NewDirSource(dir string) (Source, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &dirSource{root: dir}, nil
+}
+
+func (s *dirSource) Open(name string) (io.ReadSeekCloser, time.Time, error) {
+	f, err := os.Open(filepath.Join(s.root, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, time.Time{}, err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, time.Time{}, os.ErrNotExist
+	}
+	return f, info.ModTime(), nil
+}
+
+func (s *dirSource) List(dir string) []string {
+	files, err := os.ReadDir(filepath.Join(s.root, filepath.FromSlash(dir)))
+	if err != nil {
+		return nil
+	}
+
+	children := make([]string, 0, len(files))
+	for _, f := range files {
+		children = append(children, path.Join(dir, f.Name()))
+	}
+	sort.Strings(children)
+	return children
+}
+
+func (s *dirSource) Close() error {
+	return nil
+}
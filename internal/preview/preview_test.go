@@ -0,0 +1,172 @@
+package preview
+
+import (
+	"archive/tar"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// files are those of a mirror, with the metadata of the indexer giving the
+// type of the article, which has no extension.
+var files = map[string]string{
+	"index.html":        "<html>index</html>",
+	"error.html":        "<html>not found</html>",
+	"A/Main":            "<html>main</html>",
+	"A/Dir/Page":        "<html>page</html>",
+	"I/logo.png":        "\x89PNG logo",
+	"entries.json":      `{"A/Main": {"Metadata": {"MimeType": "text/html"}}, "A/Dir/Page": {"Metadata": {"MimeType": "text/html"}}}`,
+	"redirects.json":    `[{"path": "A/Home", "target": "A/Main"}]`,
+	"listed/index.html": "<html>listed</html>",
+}
+
+// writeTar writes the files to a tar and returns its path.
+func writeTar(t *testing.T) string {
+	t.Helper()
+	tarFile := filepath.Join(t.TempDir(), "mirror.tar")
+	f, err := os.Create(tarFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), ModTime: time.Unix(1650000000, 0)}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return tarFile
+}
+
+// writeDir writes the files to a directory, as extracted, and returns it.
+func writeDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestHandler(t *testing.T) {
+	tarSrc, err := NewTarSource(writeTar(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tarSrc.Close()
+	dirSrc, err := NewDirSource(writeDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dirSrc.Close()
+
+	for name, src := range map[string]Source{"tar": tarSrc, "dir": dirSrc} {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(NewHandler(src, Options{
+				IndexDocument: "index.html",
+				ErrorDocument: "error.html",
+				Listing:       true,
+				MetadataFile:  "entries.json",
+				RedirectsFile: "redirects.json",
+			}))
+			defer srv.Close()
+
+			for _, tt := range []struct {
+				path     string
+				status   int
+				mimeType string
+				body     string
+			}{
+				{"/", http.StatusOK, "text/html; charset=utf-8", files["index.html"]},
+				{"/A/Main", http.StatusOK, "text/html", files["A/Main"]},
+				{"/A/Dir/Page", http.StatusOK, "text/html", files["A/Dir/Page"]},
+				{"/I/logo.png", http.StatusOK, "image/png", files["I/logo.png"]},
+				// the redirects of the manifest serve their target
+				{"/A/Home", http.StatusOK, "text/html", files["A/Main"]},
+				// the index document of a directory
+				{"/listed/", http.StatusOK, "text/html; charset=utf-8", files["listed/index.html"]},
+				{"/A/Missing", http.StatusNotFound, "text/html; charset=utf-8", files["error.html"]},
+				// paths out of the root are cleaned
+				{"/../index.html", http.StatusOK, "text/html; charset=utf-8", files["index.html"]},
+			} {
+				resp, err := http.Get(srv.URL + tt.path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if resp.StatusCode != tt.status {
+					t.Errorf("%s: status %d, want %d", tt.path, resp.StatusCode, tt.status)
+				}
+				if got := resp.Header.Get("Content-Type"); got != tt.mimeType {
+					t.Errorf("%s: type %q, want %q", tt.path, got, tt.mimeType)
+				}
+				if string(body) != tt.body {
+					t.Errorf("%s: got %q, want %q", tt.path, body, tt.body)
+				}
+			}
+
+			// a directory without index document is listed
+			resp, err := http.Get(srv.URL + "/A/Dir")
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `<a href="/A/Dir/Page">`) {
+				t.Errorf("/A/Dir: status %d, listing %q", resp.StatusCode, body)
+			}
+
+			resp, err = http.Post(srv.URL+"/A/Main", "text/plain", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusMethodNotAllowed {
+				t.Errorf("POST: status %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+			}
+		})
+	}
+}
+
+func TestHandlerNotFound(t *testing.T) {
+	src, err := NewDirSource(writeDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	// without error document nor listing
+	srv := httptest.NewServer(NewHandler(src, Options{IndexDocument: "index.html"}))
+	defer srv.Close()
+	for _, p := range []string{"/A/Missing", "/A/Dir", "/A/Home"} {
+		resp, err := http.Get(srv.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: status %d, want %d", p, resp.StatusCode, http.StatusNotFound)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package progress
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/r0qs/beezim/pkg/logging"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/mattn/go-isatty"
+)
+
+// Bar reports the progress of one stage at a time, e.g. as a progress
+// bar. Start begins a stage of total items or bytes, unknown when zero,
+// Increment adds n of them done, and Finish ends the stage. Increment may
+// be called concurrently.
+type Bar interface {
+	Start(total int64)
+	Increment(n int64)
+	Finish()
+}
+
+// NopBar reports nothing.
+var NopBar Bar = nopBar{}
+
+type nopBar struct{}
+
+func (nopBar) Start(int64)     {}
+func (nopBar) Increment(int64) {}
+func (nopBar) Finish()         {}
+
+// DefaultBar returns a terminal bar when stderr is a terminal, and a bar
+// logging every 10 seconds to logger, labeled with label, otherwise.
+func DefaultBar(logger logging.Logger, label string) Bar {
+	if isatty.IsTerminal(os.Stderr.Fd()) {
+		return NewTerminalBar()
+	}
+	return NewLogBar(logger, label, 10*time.Second)
+}
+
+// terminalBar draws a cheggaaa/pb bar on stderr.
+type terminalBar struct {
+	mu  sync.Mutex
+	bar *pb.ProgressBar
+}
+
+// NewTerminalBar returns a bar drawn on stderr, which garbles the output
+// of anything else written there meanwhile.
+func NewTerminalBar() Bar {
+	return &terminalBar{}
+}
+
+func (b *terminalBar) Start(total int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bar = pb.Start64(total)
+}
+
+func (b *terminalBar) Increment(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.bar != nil {
+		b.bar.Add64(n)
+	}
+}
+
+func (b *terminalBar) Finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.bar != nil {
+		b.bar.Finish()
+		b.bar = nil
+	}
+}
+
+// logBar logs the progress of a stage every interval.
+type logBar struct {
+	log      logging.Logger
+	label    string
+	interval time.Duration
+
+	mu     sync.Mutex
+	total  int64
+	done   int64
+	start  time.Time
+	logged time.Time
+}
+
+// NewLogBar returns a bar logging the progress to logger, the default
+// logger when nil, once every interval and when the stage ends, for the
+// runs without terminal.
+func NewLogBar(logger logging.Logger, label string, interval time.Duration) Bar {
+	return &logBar{log: logging.Or(logger), label: label, interval: interval}
+}
+
+func (b *logBar) Start(total int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.total, b.done = total, 0
+	b.start = time.Now()
+	b.logged = b.start
+}
+
+func (b *logBar) Increment(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done += n
+	if now := time.Now(); now.Sub(b.logged) >= b.interval {
+		b.logged = now
+		b.report("progress")
+	}
+}
+
+func (b *logBar) Finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.report("progress finished")
+}
+
+// report logs the progress, with b.mu held.
+func (b *logBar) report(msg string) {
+	kv := []any{"label", b.label, "done", b.done}
+	if b.total > 0 {
+		kv = append(kv, "total", b.total, "percent", b.done*100/b.total)
+	}
+	kv = append(kv, "seconds", int64(time.Since(b.start).Seconds()))
+	b.log.Info(msg, kv...)
+}
+
+// barReporter reports the events of one stage at a time to a bar.
+type barReporter struct {
+	bar Bar
+
+	mu      sync.Mutex
+	stage   string
+	started bool
+	done    int64
+}
+
+// BarReporter returns a reporter of the events to bar, one stage at a
+// time: the events of the other stages are dropped until the stage
+// reported finishes. The items done are reported, or the bytes for the
+// stages counting none, e.g. an upload.
+func BarReporter(bar Bar) Reporter {
+	return &barReporter{bar: bar}
+}
+
+func (r *barReporter) Report(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.started && e.Stage != r.stage {
+		return
+	}
+	done, total := e.Done, e.Total
+	if done == 0 && total == 0 {
+		done, total = e.Bytes, e.TotalBytes
+	}
+	if !r.started {
+		r.bar.Start(total)
+		r.stage, r.started, r.done = e.Stage, true, 0
+	}
+	if done > r.done {
+		r.bar.Increment(done - r.done)
+		r.done = done
+	}
+	if e.Finished {
+		r.bar.Finish()
+		r.started = false
+	}
+}
@@ -0,0 +1,91 @@
+package progress
+
+import (
+	"context"
+	"io"
+)
+
+// Event is a progress update of a pipeline stage. Counts are in items
+// (e.g. articles, files) and bytes; zero totals are unknown.
+type Event struct {
+	Stage      string
+	Done       int64
+	Total      int64
+	Bytes      int64
+	TotalBytes int64
+	// Finished is set on the last event of the stage.
+	Finished bool
+}
+
+// Reporter receives the progress events of the pipeline stages.
+// Implementations must be safe for concurrent use and cheap, since
+// events are reported for every item processed.
+type Reporter interface {
+	Report(Event)
+}
+
+// ReporterFunc adapts a function to a Reporter.
+type ReporterFunc func(Event)
+
+// Report calls f(e).
+func (f ReporterFunc) Report(e Event) {
+	f(e)
+}
+
+// Tee returns a reporter forwarding the events to every non-nil reporter.
+func Tee(rs ...Reporter) Reporter {
+	var tee []Reporter
+	for _, r := range rs {
+		if r != nil {
+			tee = append(tee, r)
+		}
+	}
+	return ReporterFunc(func(e Event) {
+		for _, r := range tee {
+			r.Report(e)
+		}
+	})
+}
+
+type reporterKey struct{}
+
+// WithReporter returns a context carrying the reporter of the stages run
+// with it.
+func WithReporter(ctx context.Context, r Reporter) context.Context {
+	return context.WithValue(ctx, reporterKey{}, r)
+}
+
+// FromContext returns the reporter carried by the context, nil if none.
+func FromContext(ctx context.Context) Reporter {
+	r, _ := ctx.Value(reporterKey{}).(Reporter)
+	return r
+}
+
+// Reader reports the bytes read from the underlying reader as events of
+// a stage, the last one finishing the stage.
+type Reader struct {
+	r     io.Reader
+	rep   Reporter
+	event Event
+}
+
+// NewReader returns a reader reporting to rep. The offset are the bytes
+// already processed, e.g. by a previous partial download.
+func NewReader(r io.Reader, rep Reporter, stage string, offset, total int64) *Reader {
+	pr := &Reader{
+		r:     r,
+		rep:   rep,
+		event: Event{Stage: stage, Bytes: offset, TotalBytes: total},
+	}
+	rep.Report(pr.event)
+	return pr
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.event.Bytes += int64(n)
+	// readers of a known size may stop reading before EOF
+	r.event.Finished = err == io.EOF || (r.event.TotalBytes > 0 && r.event.Bytes >= r.event.TotalBytes)
+	r.rep.Report(r.event)
+	return n, err
+}
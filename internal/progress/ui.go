@@ -0,0 +1,324 @@
+package progress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options configures a UI.
+type Options struct {
+	// TTY redraws the lines in place below the log output, otherwise the
+	// lines that changed are written as periodic log lines.
+	TTY bool
+	// Interval between two renderings, 200ms on a terminal and 10s
+	// otherwise by default.
+	Interval time.Duration
+	// Now returns the current time, time.Now by default.
+	Now func() time.Time
+}
+
+// UI renders progress lines, either one per stage of a run or one per
+// run mirrored concurrently.
+type UI struct {
+	mu      sync.Mutex
+	out     io.Writer
+	opts    Options
+	lines   []*Line
+	drawn   int
+	logged  map[*Line]string
+	prevLog io.Writer
+	stopped bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewUI returns a UI writing to out.
+func NewUI(out io.Writer, opts Options) *UI {
+	if opts.Interval == 0 {
+		opts.Interval = 10 * time.Second
+		if opts.TTY {
+			opts.Interval = 200 * time.Millisecond
+		}
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &UI{
+		out:    out,
+		opts:   opts,
+		logged: make(map[*Line]string),
+	}
+}
+
+// Line returns the line with the given label, adding it below the others
+// on first use. The line shows the stage of the events it receives.
+func (u *UI) Line(label string) *Line {
+	return u.line(label, true)
+}
+
+func (u *UI) line(label string, showStage bool) *Line {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, l := range u.lines {
+		if l.label == label {
+			return l
+		}
+	}
+	l := &Line{label: label, showStage: showStage, now: u.opts.Now}
+	u.lines = append(u.lines, l)
+	return l
+}
+
+// Stages returns a reporter rendering one line per stage.
+func (u *UI) Stages() Reporter {
+	return ReporterFunc(func(e Event) {
+		u.line(e.Stage, false).Report(e)
+	})
+}
+
+// Start renders the UI periodically until Stop is called. On a terminal
+// the standard logger is routed through the UI so log lines are written
+// above the progress lines.
+func (u *UI) Start() {
+	u.stop = make(chan struct{})
+	u.done = make(chan struct{})
+	if u.opts.TTY {
+		u.prevLog = log.Writer()
+		log.SetOutput(u)
+	}
+
+	go func() {
+		defer close(u.done)
+		ticker := time.NewTicker(u.opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-u.stop:
+				return
+			case <-ticker.C:
+				u.Render()
+			}
+		}
+	}()
+}
+
+// Stop renders the final state of the lines and restores the logger. The
+// lines are left as is by the output written afterwards.
+func (u *UI) Stop() {
+	if u.stop == nil {
+		return
+	}
+	close(u.stop)
+	<-u.done
+	u.stop = nil
+	u.Render()
+
+	u.mu.Lock()
+	u.stopped = true
+	u.drawn = 0
+	u.mu.Unlock()
+	if u.opts.TTY {
+		log.SetOutput(u.prevLog)
+	}
+}
+
+// Write writes output above the progress lines, e.g. the log output.
+func (u *UI) Write(p []byte) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var b bytes.Buffer
+	u.clear(&b)
+	b.Write(p)
+	u.draw(&b)
+	if _, err := u.out.Write(b.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Render writes the progress lines: redrawn in place on a terminal, or
+// the lines that changed since the last rendering otherwise.
+func (u *UI) Render() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var b bytes.Buffer
+	if u.opts.TTY {
+		u.clear(&b)
+		u.draw(&b)
+	} else {
+		now := u.opts.Now()
+		width := u.labelWidth()
+		for _, l := range u.lines {
+			text := l.text(width)
+			if !l.started() || u.logged[l] == text {
+				continue
+			}
+			u.logged[l] = text
+			fmt.Fprintf(&b, "%s progress: %s\n", now.Format("2006/01/02 15:04:05"), text)
+		}
+	}
+	u.out.Write(b.Bytes())
+}
+
+func (u *UI) clear(b *bytes.Buffer) {
+	if u.opts.TTY && u.drawn > 0 {
+		fmt.Fprintf(b, "\x1b[%dA\x1b[J", u.drawn)
+	}
+	u.drawn = 0
+}
+
+func (u *UI) draw(b *bytes.Buffer) {
+	if !u.opts.TTY || u.stopped {
+		return
+	}
+	width := u.labelWidth()
+	for _, l := range u.lines {
+		fmt.Fprintf(b, "\x1b[2K%s\n", l.text(width))
+	}
+	u.drawn = len(u.lines)
+}
+
+func (u *UI) labelWidth() int {
+	width := 0
+	for _, l := range u.lines {
+		if len(l.label) > width {
+			width = len(l.label)
+		}
+	}
+	return width
+}
+
+// Line is a progress line showing the last event received.
+type Line struct {
+	mu        sync.Mutex
+	label     string
+	showStage bool
+	now       func() time.Time
+
+	event  Event
+	base   Event
+	start  time.Time
+	end    time.Time
+	status string
+}
+
+// Report records the event, restarting the clock when a new stage starts.
+// A line showing the stages of a run keeps the stage it shows until it
+// finishes, since stages like parse and tar run at the same time.
+func (l *Line) Report(e Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	started := !l.start.IsZero()
+	if l.showStage && started && e.Stage != l.event.Stage && !l.event.Finished {
+		return
+	}
+	if !started || e.Stage != l.event.Stage || (l.event.Finished && !e.Finished) {
+		l.start = l.now()
+		l.end = time.Time{}
+		// the rate only counts the progress made since the stage started
+		l.base = e
+	}
+	l.event = e
+	if e.Finished && l.end.IsZero() {
+		l.end = l.now()
+	}
+}
+
+// SetStatus replaces the progress of the line with a final status.
+func (l *Line) SetStatus(status string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.status = status
+}
+
+func (l *Line) started() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return !l.start.IsZero() || l.status != ""
+}
+
+func (l *Line) text(width int) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	label := fmt.Sprintf("%-*s", width, l.label)
+	if l.status != "" {
+		return label + "  " + l.status
+	}
+	if l.start.IsZero() {
+		return label + "  waiting"
+	}
+
+	e := l.event
+	var parts []string
+	if l.showStage {
+		parts = append(parts, fmt.Sprintf("%-8s", e.Stage))
+	}
+
+	switch {
+	case e.Total > 0:
+		parts = append(parts, fmt.Sprintf("%d/%d", e.Done, e.Total))
+	case e.Done > 0:
+		parts = append(parts, fmt.Sprintf("%d", e.Done))
+	}
+	switch {
+	case e.TotalBytes > 0:
+		parts = append(parts, fmt.Sprintf("%s/%s", formatBytes(e.Bytes), formatBytes(e.TotalBytes)))
+	case e.Bytes > 0:
+		parts = append(parts, formatBytes(e.Bytes))
+	}
+
+	// the percentage, rate and ETA are in bytes when some were reported
+	inBytes := e.Bytes > 0 || e.TotalBytes > 0
+	done, total, base := e.Done, e.Total, l.base.Done
+	if inBytes {
+		done, total, base = e.Bytes, e.TotalBytes, l.base.Bytes
+	}
+	if total > 0 {
+		parts = append(parts, fmt.Sprintf("%3d%%", 100*done/total))
+	}
+
+	end := l.end
+	if end.IsZero() {
+		end = l.now()
+	}
+	elapsed := end.Sub(l.start)
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(done-base) / elapsed.Seconds()
+	}
+	switch {
+	case rate > 0 && inBytes:
+		parts = append(parts, formatBytes(int64(rate))+"/s")
+	case rate > 0:
+		parts = append(parts, fmt.Sprintf("%.0f/s", rate))
+	}
+
+	if !l.end.IsZero() {
+		parts = append(parts, fmt.Sprintf("done in %v", elapsed.Round(time.Second)))
+	} else if total > 0 && rate > 0 {
+		remaining := time.Duration(float64(total-done) / rate * float64(time.Second))
+		parts = append(parts, fmt.Sprintf("ETA %v", remaining.Round(time.Second)))
+	}
+	return label + "  " + strings.Join(parts, "  ")
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
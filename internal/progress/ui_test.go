@@ -0,0 +1,172 @@
+package progress
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clock is a time advanced by the tests.
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time { return c.t }
+
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newClock() *clock {
+	return &clock{t: time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+// rendered returns the output written since the last call.
+func rendered(buf *bytes.Buffer) string {
+	s := buf.String()
+	buf.Reset()
+	return s
+}
+
+func TestUIStagesLog(t *testing.T) {
+	c := newClock()
+	var buf bytes.Buffer
+	u := NewUI(&buf, Options{Now: c.now})
+	rep := u.Stages()
+
+	// nothing is logged before a stage starts
+	u.Render()
+	if got := rendered(&buf); got != "" {
+		t.Errorf("rendered %q before any event", got)
+	}
+
+	rep.Report(Event{Stage: "parse", Total: 1000})
+	c.advance(10 * time.Second)
+	rep.Report(Event{Stage: "parse", Done: 500, Total: 1000})
+	u.Render()
+	want := "2022/05/01 12:00:10 progress: parse  500/1000   50%  50/s  ETA 10s\n"
+	if got := rendered(&buf); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// the lines that did not change are not logged again
+	u.Render()
+	if got := rendered(&buf); got != "" {
+		t.Errorf("rendered %q again", got)
+	}
+
+	// the upload starts while the parse ends, the lines are aligned
+	rep.Report(Event{Stage: "upload", TotalBytes: 4 << 20})
+	c.advance(2 * time.Second)
+	rep.Report(Event{Stage: "parse", Done: 1000, Total: 1000, Finished: true})
+	rep.Report(Event{Stage: "upload", Bytes: 1 << 20, TotalBytes: 4 << 20})
+	u.Line("verify")
+	u.Render()
+	want = "2022/05/01 12:00:12 progress: parse   1000/1000  100%  83/s  done in 12s\n" +
+		"2022/05/01 12:00:12 progress: upload  1.0 MiB/4.0 MiB   25%  512.0 KiB/s  ETA 6s\n"
+	if got := rendered(&buf); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// the finished stage keeps its time
+	c.advance(6 * time.Second)
+	rep.Report(Event{Stage: "upload", Bytes: 4 << 20, TotalBytes: 4 << 20, Finished: true})
+	u.Line("verify").SetStatus("skipped")
+	u.Render()
+	want = "2022/05/01 12:00:18 progress: upload  4.0 MiB/4.0 MiB  100%  512.0 KiB/s  done in 8s\n" +
+		"2022/05/01 12:00:18 progress: verify  skipped\n"
+	if got := rendered(&buf); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestUIRunLines(t *testing.T) {
+	c := newClock()
+	var buf bytes.Buffer
+	u := NewUI(&buf, Options{Now: c.now})
+	en, fr := u.Line("wikipedia_en"), u.Line("wikipedia_fr")
+
+	en.Report(Event{Stage: "parse", Done: 10})
+	// the tar running with the parse does not replace it on the line
+	en.Report(Event{Stage: "tar", Bytes: 1 << 10})
+	c.advance(time.Second)
+	en.Report(Event{Stage: "parse", Done: 20})
+	u.Render()
+	want := "2022/05/01 12:00:01 progress: wikipedia_en  parse     20  10/s\n"
+	if got := rendered(&buf); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	en.Report(Event{Stage: "parse", Done: 30, Finished: true})
+	en.Report(Event{Stage: "upload", Bytes: 2 << 10, TotalBytes: 8 << 10})
+	fr.SetStatus("failed: no space left")
+	u.Render()
+	want = "2022/05/01 12:00:01 progress: wikipedia_en  upload    2.0 KiB/8.0 KiB   25%\n" +
+		"2022/05/01 12:00:01 progress: wikipedia_fr  failed: no space left\n"
+	if got := rendered(&buf); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestUITerminal(t *testing.T) {
+	c := newClock()
+	var buf bytes.Buffer
+	u := NewUI(&buf, Options{TTY: true, Interval: time.Hour, Now: c.now})
+	rep := u.Stages()
+	rep.Report(Event{Stage: "parse", Done: 1, Total: 4})
+	u.Line("upload")
+
+	flags := log.Flags()
+	log.SetFlags(0)
+	defer log.SetFlags(flags)
+	prev := log.Writer()
+	u.Start()
+	u.Render()
+	want := "\x1b[2Kparse   1/4   25%\n\x1b[2Kupload  waiting\n"
+	if got := rendered(&buf); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// the log output is written above the lines, drawn again below it
+	log.Print("uploading")
+	want = "\x1b[2A\x1b[Juploading\n\x1b[2Kparse   1/4   25%\n\x1b[2Kupload  waiting\n"
+	if got := rendered(&buf); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	rep.Report(Event{Stage: "parse", Done: 4, Total: 4, Finished: true})
+	u.Stop()
+	want = "\x1b[2A\x1b[J\x1b[2Kparse   4/4  100%  done in 0s\n\x1b[2Kupload  waiting\n"
+	if got := rendered(&buf); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if log.Writer() != prev {
+		t.Error("the logger was not restored")
+	}
+	// the lines are left as drawn
+	u.Write([]byte("done\n"))
+	if got := rendered(&buf); got != "done\n" {
+		t.Errorf("got %q after stop", got)
+	}
+}
+
+func TestReader(t *testing.T) {
+	var events []Event
+	rep := ReporterFunc(func(e Event) { events = append(events, e) })
+	r := NewReader(strings.NewReader("0123456789"), rep, "download", 5, 15)
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) < 2 || events[0] != (Event{Stage: "download", Bytes: 5, TotalBytes: 15}) {
+		t.Fatalf("got events %+v, want the offset first", events)
+	}
+	if last := events[len(events)-1]; last.Bytes != 15 || !last.Finished {
+		t.Errorf("last event %+v, want the 15 bytes finished", last)
+	}
+	// the stage finishes once the total is read, before EOF
+	for _, e := range events {
+		if e.Finished != (e.Bytes == 15) {
+			t.Errorf("event %+v finished %t", e, e.Finished)
+		}
+	}
+}
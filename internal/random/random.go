@@ -0,0 +1,33 @@
+// Package random derives the random sources of the sampling features from
+// the seed of a run, so that runs given the same seed choose the same
+// samples.
+package random
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
+	"time"
+)
+
+// NewSeed returns a random seed, never zero as zero stands for no seed.
+func NewSeed() int64 {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return time.Now().UnixNano() | 1
+	}
+	if s := int64(binary.LittleEndian.Uint64(b[:]) >> 1); s != 0 {
+		return s
+	}
+	return 1
+}
+
+// New returns the source of the named feature for the seed. Every feature
+// has its own source, so that its samples do not depend on the draws of
+// the others. The source is not safe for concurrent use.
+func New(seed int64, feature string) *rand.Rand {
+	h := fnv.New64a()
+	h.Write([]byte(feature))
+	return rand.New(rand.NewSource(seed ^ int64(h.Sum64())))
+}
@@ -0,0 +1,43 @@
+package random
+
+import (
+	"slices"
+	"testing"
+)
+
+// draws returns the first n draws of the source of the feature.
+func draws(seed int64, feature string, n int) []int {
+	rnd := New(seed, feature)
+	d := make([]int, n)
+	for i := range d {
+		d[i] = rnd.Intn(1000)
+	}
+	return d
+}
+
+func TestNew(t *testing.T) {
+	if !slices.Equal(draws(42, "verify", 20), draws(42, "verify", 20)) {
+		t.Error("the same seed drew other samples")
+	}
+	if slices.Equal(draws(42, "verify", 20), draws(43, "verify", 20)) {
+		t.Error("another seed drew the same samples")
+	}
+	// every feature has its own source
+	if slices.Equal(draws(42, "verify", 20), draws(42, "verify-redirects", 20)) {
+		t.Error("two features drew the same samples")
+	}
+}
+
+func TestNewSeed(t *testing.T) {
+	seen := make(map[int64]bool)
+	for range 100 {
+		s := NewSeed()
+		if s <= 0 {
+			t.Fatalf("seed %d, want it positive", s)
+		}
+		seen[s] = true
+	}
+	if len(seen) < 99 {
+		t.Errorf("%d distinct seeds out of 100", len(seen))
+	}
+}
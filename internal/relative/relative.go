@@ -0,0 +1,151 @@
+// Package relative rewrites the root-absolute links of the articles of the
+// ZIMs, e.g. href="/A/Foo", into links relative to the article, so that
+// they still reach the archive when it is served under a path, e.g.
+// https://gateway/bzz/<reference>/, rather than the root of the gateway.
+//
+// The root of the archive is taken for that of the site, so that /A/Foo is
+// the article A/Foo of the archive. The other links, e.g. relative ones or
+// those to other sites, are kept byte for byte.
+package relative
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/r0qs/beezim/internal/htmltag"
+)
+
+// Link returns the link from the article of the name, a path of the
+// archive, to the URL when it is root-absolute, false otherwise, keeping
+// its query and fragment.
+func Link(name, u string) (string, bool) {
+	spaces := u[:len(u)-len(strings.TrimLeft(u, " \t\n\r\f"))]
+	p := u[len(spaces):]
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.HasPrefix(p, `/\`) {
+		return u, false
+	}
+	prefix := strings.Repeat("../", strings.Count(name, "/"))
+	if prefix == "" {
+		// so that the first element is not read as a scheme, nor an
+		// empty path as the article itself
+		prefix = "./"
+	}
+	return spaces + prefix + p[1:], true
+}
+
+// urlAttrs are the attributes holding a URL, of every element but data of
+// object.
+var urlAttrs = map[string]bool{
+	"href": true, "src": true, "poster": true, "action": true, "formaction": true,
+	"background": true, "cite": true, "xlink:href": true,
+}
+
+// HTML returns the page of the name with its root-absolute links relative,
+// those of the attributes of its tags, of their srcset and of the url() of
+// their style and of its style sheets, and the number of links rewritten.
+func HTML(data []byte, name string) ([]byte, int) {
+	n := 0
+	out := make([]byte, 0, len(data))
+	z := htmltag.NewTokenizer(data)
+	for {
+		t, ok := z.Next()
+		if !ok {
+			break
+		}
+		switch {
+		case t.Kind == htmltag.RawText && t.Name == "style":
+			css, links := CSS(t.Raw, name)
+			out = append(out, css...)
+			n += links
+			continue
+		case t.Kind == htmltag.StartTag:
+			set := make(map[string]string)
+			for _, a := range t.Attrs {
+				if _, seen := set[a.Name]; seen {
+					continue
+				}
+				v, links := attr(t.Name, a, name)
+				if links > 0 {
+					set[a.Name] = v
+					n += links
+				}
+			}
+			if len(set) > 0 {
+				out = t.AppendWith(out, set)
+				continue
+			}
+		}
+		out = append(out, t.Raw...)
+	}
+	return out, n
+}
+
+// attr returns the value of the attribute of a tag of the element with
+// its root-absolute links relative, and the number of links rewritten.
+func attr(element string, a htmltag.Attr, name string) (string, int) {
+	switch {
+	case urlAttrs[a.Name] || a.Name == "data" && element == "object":
+		if l, ok := Link(name, a.Value); ok {
+			return l, 1
+		}
+	case a.Name == "srcset" || a.Name == "imagesrcset":
+		return srcset(a.Value, name)
+	case a.Name == "style":
+		css, n := CSS([]byte(a.Value), name)
+		return string(css), n
+	}
+	return a.Value, 0
+}
+
+// srcset returns the srcset with the root-absolute URLs of its images
+// relative, their descriptors and spaces kept.
+func srcset(value, name string) (string, int) {
+	n := 0
+	candidates := strings.Split(value, ",")
+	for i, c := range candidates {
+		start := len(c) - len(strings.TrimLeft(c, " \t\n\r\f"))
+		end := strings.IndexAny(c[start:], " \t\n\r\f")
+		if end < 0 {
+			end = len(c)
+		} else {
+			end += start
+		}
+		if l, ok := Link(name, c[start:end]); ok {
+			candidates[i] = c[:start] + l + c[end:]
+			n++
+		}
+	}
+	return strings.Join(candidates, ","), n
+}
+
+// cssURL matches the URLs of a style sheet, in url() or after @import,
+// whose quotes are character references in a style attribute.
+var cssURL = regexp.MustCompile(`(?i)\burl\(\s*(?:["']|&quot;|&#39;|&apos;)?([^"')\s]*)|@import\s+(?:["']|&quot;|&#39;|&apos;)([^"']*)`)
+
+// CSS returns the style sheet of the name, an article or a page holding
+// it, with the root-absolute URLs of its url() and @import relative, and
+// the number of URLs rewritten.
+func CSS(data []byte, name string) ([]byte, int) {
+	matches := cssURL.FindAllSubmatchIndex(data, -1)
+	if matches == nil {
+		return data, 0
+	}
+	n := 0
+	out := make([]byte, 0, len(data))
+	last := 0
+	for _, m := range matches {
+		start, end := m[2], m[3]
+		if start < 0 {
+			start, end = m[4], m[5]
+		}
+		l, ok := Link(name, string(data[start:end]))
+		if !ok {
+			continue
+		}
+		out = append(out, data[last:start]...)
+		out = append(out, l...)
+		last = end
+		n++
+	}
+	return append(out, data[last:]...), n
+}
@@ -0,0 +1,244 @@
+package result
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SchemaVersion is incremented on every incompatible change of the
+// Result document.
+const SchemaVersion = 1
+
+// Result is the machine-readable summary of a beezim run.
+type Result struct {
+	mu sync.Mutex
+
+	SchemaVersion int    `json:"schemaVersion"`
+	Command       string `json:"command"`
+	Success       bool   `json:"success"`
+	Error         string `json:"error,omitempty"`
+	// FailedStage is the stage that failed, the stages before it
+	// succeeded: e.g. a root can be uploaded but not verified.
+	FailedStage string `json:"failedStage,omitempty"`
+
+	Inputs Inputs `json:"inputs"`
+	Stats  *Stats `json:"stats,omitempty"`
+
+	TarFile   string `json:"tarFile,omitempty"`
+	TarHash   string `json:"tarHash,omitempty"`
+	Reference string `json:"reference,omitempty"`
+	// SearchReference is the root of the search index, when uploaded as
+	// its own collection.
+	SearchReference string        `json:"searchReference,omitempty"`
+	Signature       string        `json:"signature,omitempty"`
+	BatchID         string        `json:"batchID,omitempty"`
+	FeedAddress     string        `json:"feedAddress,omitempty"`
+	Verification    *Verification `json:"verification,omitempty"`
+	// Provenance is the provenance document recorded in the tar, see
+	// indexer.Provenance.
+	Provenance json.RawMessage `json:"provenance,omitempty"`
+
+	StartedAt time.Time `json:"startedAt"`
+	// Durations holds the time spent in each stage in seconds.
+	Durations map[string]float64 `json:"durations,omitempty"`
+	Warnings  []string           `json:"warnings,omitempty"`
+	// Performance is the throughput of the stages and the resources used.
+	Performance *Performance `json:"performance,omitempty"`
+
+	// Data holds the output of the commands that only list information.
+	Data interface{} `json:"data,omitempty"`
+}
+
+// Inputs describes what was requested by the user.
+type Inputs struct {
+	ZimFile      string            `json:"zimFile,omitempty"`
+	ZimURL       string            `json:"zimURL,omitempty"`
+	Kiwix        string            `json:"kiwix,omitempty"`
+	EnableSearch bool              `json:"enableSearch"`
+	ExtractOnly  bool              `json:"extractOnly,omitempty"`
+	Filters      map[string]string `json:"filters,omitempty"`
+	// Seed is the seed of the random samples, which the same inputs and
+	// seed choose again.
+	Seed int64 `json:"seed,omitempty"`
+}
+
+// Stats summarizes the parsed content.
+type Stats struct {
+	Articles int   `json:"articles"`
+	Entries  int   `json:"entries"`
+	ZimSize  int64 `json:"zimSize,omitempty"`
+	TarSize  int64 `json:"tarSize,omitempty"`
+	// Panics is the number of entries skipped because they made the ZIM
+	// reader panic.
+	Panics int `json:"panics,omitempty"`
+	// Skipped is the number of entries skipped because they could not be
+	// read.
+	Skipped int `json:"skipped,omitempty"`
+	// MimeFiltered are the articles left out by the MIME filter, by type.
+	MimeFiltered map[string]MimeStats `json:"mimeFiltered,omitempty"`
+	// TooLarge is the number of articles left out for being larger than
+	// the maximum article size.
+	TooLarge int `json:"tooLarge,omitempty"`
+	// Duplicates is the number of articles identical to one parsed
+	// before, and DedupSaved the bytes saved by replacing them by
+	// aliases.
+	Duplicates int   `json:"duplicates,omitempty"`
+	DedupSaved int64 `json:"dedupSaved,omitempty"`
+	// Minified is the number of articles minified, MinifySaved the bytes
+	// it saved, and MinifyInvalid the number of those sent as they are as
+	// they could not be minified.
+	Minified      int   `json:"minified,omitempty"`
+	MinifySaved   int64 `json:"minifySaved,omitempty"`
+	MinifyInvalid int   `json:"minifyInvalid,omitempty"`
+	// RelativeArticles is the number of articles whose root-absolute
+	// links were made relative, and RelativeLinks that of those links.
+	RelativeArticles int `json:"relativeArticles,omitempty"`
+	RelativeLinks    int `json:"relativeLinks,omitempty"`
+	// OfflineRewritten is the number of HTML articles rewritten for
+	// offline browsing, OfflineLinks that of their links to other sites
+	// rewritten and OfflineResources that of the resources of other sites
+	// dropped.
+	OfflineRewritten int `json:"offlineRewritten,omitempty"`
+	OfflineLinks     int `json:"offlineLinks,omitempty"`
+	OfflineResources int `json:"offlineResources,omitempty"`
+	// DiffAdded, DiffChanged and DiffRemoved are the numbers of entries
+	// added, changed and removed since the previous version of the ZIM,
+	// and DiffUnchanged that of those left out of the tar as unchanged.
+	DiffAdded     int `json:"diffAdded,omitempty"`
+	DiffChanged   int `json:"diffChanged,omitempty"`
+	DiffRemoved   int `json:"diffRemoved,omitempty"`
+	DiffUnchanged int `json:"diffUnchanged,omitempty"`
+	// Collisions is the number of entries left out for another entry at
+	// the same path.
+	Collisions int `json:"collisions,omitempty"`
+	// ExtraFiles is the number of local files packed into the tar besides
+	// the ZIM, and ExtraBytes their size.
+	ExtraFiles int   `json:"extraFiles,omitempty"`
+	ExtraBytes int64 `json:"extraBytes,omitempty"`
+	// SearchIndexes is the number of entries of the X namespace left out
+	// of the tar, and SearchIndexBytes their size.
+	SearchIndexes    int   `json:"searchIndexes,omitempty"`
+	SearchIndexBytes int64 `json:"searchIndexBytes,omitempty"`
+	// MimeOverridden is the number of entries given another MIME type by
+	// the overrides, and MimeSniffed that of those whose unknown type was
+	// guessed.
+	MimeOverridden int `json:"mimeOverridden,omitempty"`
+	MimeSniffed    int `json:"mimeSniffed,omitempty"`
+	// SharedAssets is the number of files of the tar served from the
+	// manifest of another upload instead of being uploaded again, and
+	// SharedSaved their size.
+	SharedAssets int   `json:"sharedAssets,omitempty"`
+	SharedSaved  int64 `json:"sharedSaved,omitempty"`
+}
+
+// MimeStats are the articles of a MIME type left out of the parse.
+type MimeStats struct {
+	Articles int   `json:"articles"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// Performance summarizes the throughput of the stages and the resources
+// used by a run.
+type Performance struct {
+	Stages []StagePerformance `json:"stages,omitempty"`
+	// PeakRSS is the peak resident memory of the process, zero when it
+	// is not known on the platform.
+	PeakRSS int64 `json:"peakRSS,omitempty"`
+	// PeakGoMemory is the peak of the memory held by the Go runtime,
+	// sampled during the run.
+	PeakGoMemory   int64   `json:"peakGoMemory"`
+	GCCycles       uint64  `json:"gcCycles"`
+	GCPauseSeconds float64 `json:"gcPauseSeconds"`
+	// Allocs and AllocBytes are the heap allocations of the run.
+	Allocs     uint64 `json:"allocs"`
+	AllocBytes uint64 `json:"allocBytes"`
+}
+
+// StagePerformance is the throughput of a stage. Stages that report no
+// progress only have their duration.
+type StagePerformance struct {
+	Stage          string  `json:"stage"`
+	Seconds        float64 `json:"seconds"`
+	Items          int64   `json:"items,omitempty"`
+	Bytes          int64   `json:"bytes,omitempty"`
+	ItemsPerSecond float64 `json:"itemsPerSecond,omitempty"`
+	BytesPerSecond float64 `json:"bytesPerSecond,omitempty"`
+}
+
+// Verification is the outcome of checking the uploaded content.
+type Verification struct {
+	Verified bool     `json:"verified"`
+	Checked  int      `json:"checked"`
+	Failed   []string `json:"failed,omitempty"`
+}
+
+// New returns an empty result for the given command.
+func New(command string) *Result {
+	return &Result{
+		SchemaVersion: SchemaVersion,
+		Command:       command,
+		StartedAt:     time.Now().UTC(),
+		Durations:     make(map[string]float64),
+	}
+}
+
+// Stage records the time elapsed since start for the named stage.
+func (r *Result) Stage(name string, start time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Durations[name] = time.Since(start).Seconds()
+}
+
+// SetPerformance records the performance of the run, adding the stages
+// timed with Stage that reported no progress.
+func (r *Result) SetPerformance(p *Performance) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seen := make(map[string]bool)
+	for _, s := range p.Stages {
+		seen[s.Stage] = true
+	}
+	var names []string
+	for name := range r.Durations {
+		if !seen[name] && name != "total" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p.Stages = append(p.Stages, StagePerformance{Stage: name, Seconds: r.Durations[name]})
+	}
+	r.Performance = p
+}
+
+// Warn records a non-fatal problem found during the run.
+func (r *Result) Warn(msg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Warnings = append(r.Warnings, msg)
+}
+
+// Finish records the outcome of the run.
+func (r *Result) Finish(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Success = err == nil
+	r.Error = ""
+	if err != nil {
+		r.Error = err.Error()
+	}
+	r.Durations["total"] = time.Since(r.StartedAt).Seconds()
+}
+
+// Write encodes the result as an indented JSON document.
+func (r *Result) Write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
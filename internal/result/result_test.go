@@ -0,0 +1,118 @@
+package result
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "write the golden files of the tests")
+
+// mirrorResult returns the result of a mirror that uploaded its root but
+// failed to verify it, with every section set.
+func mirrorResult() *Result {
+	r := New("beezim mirror")
+	r.StartedAt = time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	r.Inputs = Inputs{
+		ZimFile:      "wikipedia_en_climate_change_mini_2022-05.zim",
+		EnableSearch: true,
+		Filters:      map[string]string{"catalog-lang": "eng"},
+		Seed:         42,
+	}
+	r.Stats = &Stats{
+		Articles:     120,
+		Entries:      150,
+		ZimSize:      7905261,
+		TarSize:      9437184,
+		Skipped:      1,
+		MimeFiltered: map[string]MimeStats{"video/webm": {Articles: 2, Bytes: 1 << 20}},
+	}
+	r.TarFile = "wikipedia_en_climate_change_mini_2022-05.tar"
+	r.TarHash = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	r.Reference = "b1f5c2b8f1d3c6a0e7d4b2a9c8f7e6d5c4b3a2918f7e6d5c4b3a2918f7e6d5c4"
+	r.BatchID = "6c3d4f1a2b5e8d7c9f0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60"
+	r.Verification = &Verification{Checked: 11, Failed: []string{"A/Missing"}}
+	r.Provenance = json.RawMessage(`{"schemaVersion":1}`)
+	r.Warn("1 entries could not be extracted")
+	r.SetPerformance(&Performance{
+		Stages:       []StagePerformance{{Stage: "parse", Seconds: 2, Items: 150, ItemsPerSecond: 75}},
+		PeakGoMemory: 64 << 20,
+	})
+	r.FailedStage = "verify"
+	r.Finish(errors.New("1 of 11 files not available"))
+	// durations fixed after Finish, which measures the total
+	r.Durations = map[string]float64{"parse": 2, "upload": 1.5, "total": 4}
+	return r
+}
+
+func TestWriteGolden(t *testing.T) {
+	var buf bytes.Buffer
+	if err := mirrorResult().Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("testdata", "mirror.json")
+	if *update {
+		if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got\n%s\nwant the document of %s, go test -update writes it:\n%s", buf.Bytes(), golden, want)
+	}
+}
+
+func TestWriteEmpty(t *testing.T) {
+	// the fields of a listing are left out, but for those scripts rely on
+	r := New("beezim catalog")
+	r.StartedAt = time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	r.Data = []string{"wikipedia_en_all"}
+	r.Success = true
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"schemaVersion", "command", "success", "inputs", "startedAt", "data"}
+	for _, k := range want {
+		if _, ok := doc[k]; !ok {
+			t.Errorf("no %s in %s", k, buf.Bytes())
+		}
+	}
+	if len(doc) != len(want) {
+		t.Errorf("got the fields of %s, want only %v", buf.Bytes(), want)
+	}
+}
+
+func TestWriteOneDocument(t *testing.T) {
+	// stdout only has the document in JSON mode, so that it can be piped
+	// to a JSON parser as it is
+	var buf bytes.Buffer
+	if err := mirrorResult().Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(&buf)
+	var doc Result
+	if err := dec.Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.SchemaVersion != SchemaVersion || doc.Reference == "" {
+		t.Errorf("decoded schema %d, reference %q", doc.SchemaVersion, doc.Reference)
+	}
+	var more json.RawMessage
+	if err := dec.Decode(&more); !errors.Is(err, io.EOF) {
+		t.Errorf("got %s after the document, want nothing: %v", more, err)
+	}
+}
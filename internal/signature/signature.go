@@ -0,0 +1,192 @@
+package signature
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethersphere/bee/pkg/crypto"
+)
+
+const (
+	// FileName is the name of the signature document.
+	FileName = "_mirror.sig"
+
+	// StatementVersion is the version of the signed statement format.
+	StatementVersion = 1
+
+	Secp256k1 = "secp256k1"
+	Ed25519   = "ed25519"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrKeyMismatch      = errors.New("signature was not made by the given public key")
+)
+
+// Statement is what the mirror publisher attests about an uploaded mirror.
+type Statement struct {
+	Version   int       `json:"version"`
+	ZimFile   string    `json:"zimFile"`
+	ZimSHA256 string    `json:"zimSHA256,omitempty"`
+	Root      string    `json:"root"`
+	Timestamp time.Time `json:"timestamp"`
+	Beezim    string    `json:"beezim"`
+}
+
+// Bytes returns the canonical encoding of the statement that is signed:
+// compact JSON with the fields in declaration order and the timestamp in
+// UTC with second precision.
+func (s Statement) Bytes() []byte {
+	s.Timestamp = s.Timestamp.UTC().Truncate(time.Second)
+	data, _ := json.Marshal(s)
+	return data
+}
+
+// Signed is the detached signature document uploaded next to a mirror.
+type Signed struct {
+	Statement Statement `json:"statement"`
+	Algorithm string    `json:"algorithm"`
+	// PublicKey is the hex encoded public key of the signer: the
+	// compressed key for secp256k1 or the raw key for ed25519.
+	PublicKey string `json:"publicKey"`
+	Signature string `json:"signature"`
+}
+
+// Signer signs statements.
+type Signer interface {
+	Algorithm() string
+	PublicKey() []byte
+	Sign(data []byte) ([]byte, error)
+}
+
+type secp256k1Signer struct {
+	signer crypto.Signer
+	key    *ecdsa.PrivateKey
+}
+
+// NewSecp256k1Signer returns a signer producing ethereum compatible
+// signatures, as used by bee for feeds and single owner chunks.
+func NewSecp256k1Signer(key *ecdsa.PrivateKey) Signer {
+	return &secp256k1Signer{signer: crypto.NewDefaultSigner(key), key: key}
+}
+
+func (s *secp256k1Signer) Algorithm() string { return Secp256k1 }
+
+func (s *secp256k1Signer) PublicKey() []byte {
+	return crypto.EncodeSecp256k1PublicKey(&s.key.PublicKey)
+}
+
+func (s *secp256k1Signer) Sign(data []byte) ([]byte, error) {
+	return s.signer.Sign(data)
+}
+
+type ed25519Signer struct {
+	key ed25519.PrivateKey
+}
+
+// NewEd25519Signer returns a signer using an ed25519 key.
+func NewEd25519Signer(key ed25519.PrivateKey) Signer {
+	return &ed25519Signer{key: key}
+}
+
+func (s *ed25519Signer) Algorithm() string { return Ed25519 }
+
+func (s *ed25519Signer) PublicKey() []byte {
+	return []byte(s.key.Public().(ed25519.PublicKey))
+}
+
+func (s *ed25519Signer) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(s.key, data), nil
+}
+
+// Sign signs the statement.
+func Sign(st Statement, signer Signer) (*Signed, error) {
+	st.Version = StatementVersion
+	st.Timestamp = st.Timestamp.UTC().Truncate(time.Second)
+
+	sig, err := signer.Sign(st.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("error signing statement: %v", err)
+	}
+	return &Signed{
+		Statement: st,
+		Algorithm: signer.Algorithm(),
+		PublicKey: hex.EncodeToString(signer.PublicKey()),
+		Signature: hex.EncodeToString(sig),
+	}, nil
+}
+
+// Decode parses a signature document.
+func Decode(data []byte) (*Signed, error) {
+	var s Signed
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("error decoding signature: %v", err)
+	}
+	if s.Statement.Version > StatementVersion {
+		return nil, fmt.Errorf("unsupported statement version %d", s.Statement.Version)
+	}
+	return &s, nil
+}
+
+// Verify checks that the statement was signed by the given public key.
+// secp256k1 keys may also be given as their ethereum address.
+func (s *Signed) Verify(pubKey string) error {
+	sig, err := decodeHex(s.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	want, err := decodeHex(pubKey)
+	if err != nil {
+		return fmt.Errorf("error decoding public key: %v", err)
+	}
+	data := s.Statement.Bytes()
+
+	switch s.Algorithm {
+	case Secp256k1:
+		pub, err := crypto.Recover(sig, data)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+		}
+		if !matchSecp256k1(pub, want) {
+			return ErrKeyMismatch
+		}
+		return nil
+	case Ed25519:
+		if len(want) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid ed25519 public key length %d", len(want))
+		}
+		if !ed25519.Verify(ed25519.PublicKey(want), data, sig) {
+			return ErrInvalidSignature
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", s.Algorithm)
+	}
+}
+
+// matchSecp256k1 compares the recovered key with a compressed or
+// uncompressed public key, or with an ethereum address.
+func matchSecp256k1(pub *ecdsa.PublicKey, want []byte) bool {
+	switch len(want) {
+	case 20:
+		addr, err := crypto.NewEthereumAddress(*pub)
+		return err == nil && bytes.Equal(addr, want)
+	case 33:
+		return bytes.Equal(crypto.EncodeSecp256k1PublicKey(pub), want)
+	case 65:
+		return pub.X.Cmp(new(big.Int).SetBytes(want[1:33])) == 0 && pub.Y.Cmp(new(big.Int).SetBytes(want[33:])) == 0
+	}
+	return false
+}
+
+func decodeHex(s string) ([]byte, error) {
+	return hex.DecodeString(strings.TrimPrefix(s, "0x"))
+}
@@ -0,0 +1,161 @@
+package signature
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ethersphere/bee/pkg/crypto"
+)
+
+func statement() Statement {
+	return Statement{
+		ZimFile:   "wikipedia_en_climate_change_mini_2022-05.zim",
+		ZimSHA256: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		Root:      "b1f5c2b8f1d3c6a0e7d4b2a9c8f7e6d5c4b3a2918f7e6d5c4b3a2918f7e6d5c4",
+		Timestamp: time.Date(2022, 5, 1, 12, 0, 0, 500, time.FixedZone("CEST", 2*3600)),
+		Beezim:    "v0.1.0",
+	}
+}
+
+// signers returns a signer of every algorithm and another of the same
+// algorithm, from fixed keys.
+func signers() map[string][2]Signer {
+	return map[string][2]Signer{
+		Secp256k1: {
+			NewSecp256k1Signer(crypto.Secp256k1PrivateKeyFromBytes(bytes.Repeat([]byte{1}, 32))),
+			NewSecp256k1Signer(crypto.Secp256k1PrivateKeyFromBytes(bytes.Repeat([]byte{2}, 32))),
+		},
+		Ed25519: {
+			NewEd25519Signer(ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))),
+			NewEd25519Signer(ed25519.NewKeyFromSeed(bytes.Repeat([]byte{2}, ed25519.SeedSize))),
+		},
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	for alg, s := range signers() {
+		t.Run(alg, func(t *testing.T) {
+			signer, other := s[0], s[1]
+			signed, err := Sign(statement(), signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if signed.Algorithm != alg || signed.Statement.Version != StatementVersion {
+				t.Errorf("signed with %q version %d", signed.Algorithm, signed.Statement.Version)
+			}
+			if !signed.Statement.Timestamp.Equal(time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)) || signed.Statement.Timestamp.Location() != time.UTC {
+				t.Errorf("timestamp %v, want it in UTC to the second", signed.Statement.Timestamp)
+			}
+
+			// the document uploaded, decoded and verified from its key
+			data, err := json.Marshal(signed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := Decode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := decoded.Verify(signed.PublicKey); err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+			if err := decoded.Verify("0x" + signed.PublicKey); err != nil {
+				t.Errorf("verify with 0x: %v", err)
+			}
+
+			// another key
+			err = decoded.Verify(hex.EncodeToString(other.PublicKey()))
+			want := ErrKeyMismatch
+			if alg == Ed25519 {
+				want = ErrInvalidSignature
+			}
+			if !errors.Is(err, want) {
+				t.Errorf("verified by another key: got %v, want %v", err, want)
+			}
+		})
+	}
+}
+
+func TestVerifySecp256k1Keys(t *testing.T) {
+	key := crypto.Secp256k1PrivateKeyFromBytes(bytes.Repeat([]byte{1}, 32))
+	signed, err := Sign(statement(), NewSecp256k1Signer(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := crypto.NewEthereumAddress(key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uncompressed := make([]byte, 65)
+	uncompressed[0] = 4
+	key.PublicKey.X.FillBytes(uncompressed[1:33])
+	key.PublicKey.Y.FillBytes(uncompressed[33:])
+	for name, pub := range map[string][]byte{
+		"address":      addr,
+		"compressed":   crypto.EncodeSecp256k1PublicKey(&key.PublicKey),
+		"uncompressed": uncompressed,
+	} {
+		if err := signed.Verify(hex.EncodeToString(pub)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if err := signed.Verify(hex.EncodeToString(addr[:19])); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("truncated address: got %v, want %v", err, ErrKeyMismatch)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	for alg, s := range signers() {
+		signed, err := Sign(statement(), s[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range []struct {
+			name   string
+			tamper func(s *Signed)
+		}{
+			{"root", func(s *Signed) { s.Statement.Root = "00" + s.Statement.Root[2:] }},
+			{"zim", func(s *Signed) { s.Statement.ZimFile = "wikipedia_en_all_maxi_2022-05.zim" }},
+			{"timestamp", func(s *Signed) { s.Statement.Timestamp = s.Statement.Timestamp.Add(time.Second) }},
+			{"signature", func(s *Signed) {
+				sig, _ := hex.DecodeString(s.Signature)
+				sig[10] ^= 1
+				s.Signature = hex.EncodeToString(sig)
+			}},
+		} {
+			t.Run(alg+"/"+tt.name, func(t *testing.T) {
+				tampered := *signed
+				tt.tamper(&tampered)
+				err := tampered.Verify(signed.PublicKey)
+				if !errors.Is(err, ErrKeyMismatch) && !errors.Is(err, ErrInvalidSignature) {
+					t.Errorf("got %v, want the tampered statement rejected", err)
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"json":    `{"statement":`,
+		"version": `{"statement": {"version": 2}, "algorithm": "ed25519"}`,
+	} {
+		if _, err := Decode([]byte(data)); err == nil {
+			t.Errorf("%s: decoded %s", name, data)
+		}
+	}
+
+	signed := &Signed{Statement: statement(), Algorithm: "rsa", PublicKey: "00", Signature: "00"}
+	if err := signed.Verify("00"); err == nil || errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("got %v, want the algorithm unsupported", err)
+	}
+	signed.Algorithm, signed.Signature = Ed25519, "not hex"
+	if err := signed.Verify("00"); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("got %v, want %v", err, ErrInvalidSignature)
+	}
+}
@@ -0,0 +1,208 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"time"
+)
+
+// Export is a portable dump of the database, used to move the mirroring
+// history to another machine. It holds no secrets nor local state: the
+// credentials of the zim urls and the pointers to the preserved artifacts
+// are removed.
+type Export struct {
+	SchemaVersion int            `json:"schemaVersion"`
+	ExportedAt    time.Time      `json:"exportedAt"`
+	Runs          []Run          `json:"runs"`
+	Spends        []Spend        `json:"spends,omitempty"`
+	GatewayChecks []GatewayCheck `json:"gatewayChecks,omitempty"`
+}
+
+// ImportStats counts the records added and updated by an import.
+type ImportStats struct {
+	RunsAdded   int `json:"runsAdded"`
+	RunsUpdated int `json:"runsUpdated"`
+	SpendsAdded int `json:"spendsAdded"`
+	ChecksAdded int `json:"gatewayChecksAdded"`
+	// Skipped are the records already in the database, or older than
+	// the recorded ones.
+	Skipped int `json:"skipped"`
+}
+
+// Export returns a dump of the database.
+func (s *Store) Export() Export {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refresh()
+
+	e := Export{
+		SchemaVersion: SchemaVersion,
+		ExportedAt:    time.Now().UTC(),
+		Runs:          make([]Run, len(s.db.Runs)),
+		Spends:        append([]Spend(nil), s.db.Spends...),
+		GatewayChecks: append([]GatewayCheck(nil), s.db.GatewayChecks...),
+	}
+	for i, r := range s.db.Runs {
+		r.ZimURL = redactURL(r.ZimURL)
+		r.Resume = nil
+		e.Runs[i] = r
+	}
+	return e
+}
+
+// ReadExport decodes an export, written by this or an older version of
+// the schema. Unknown fields are ignored.
+func ReadExport(r io.Reader) (Export, error) {
+	var e Export
+	if err := json.NewDecoder(r).Decode(&e); err != nil {
+		return Export{}, fmt.Errorf("error reading export: %v", err)
+	}
+	if e.SchemaVersion == 0 {
+		return Export{}, errors.New("not a beezim export: no schema version")
+	}
+	if e.SchemaVersion > SchemaVersion {
+		return Export{}, fmt.Errorf("export has unsupported schema version %d, upgrade beezim to import it", e.SchemaVersion)
+	}
+	return e, nil
+}
+
+// Import merges the export into the database and persists it. The runs
+// of a ZIM already recorded, by the file name naming its wiki and
+// release, are conflicts: the record with the newer verification, the
+// completed run updated last, is kept. The same run, or another without
+// ZIM, is matched by ID and replaced only if the imported one was updated
+// more recently. Spends and gateway checks already recorded are skipped.
+func (s *Store) Import(e Export) (ImportStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var stats ImportStats
+	err := s.update(func() {
+		// the imported runs are matched against the local ones only, so
+		// that all the runs of an export are imported in a new database
+		ids := make(map[string]int, len(s.db.Runs))
+		zims := make(map[string]int)
+		for i, r := range s.db.Runs {
+			ids[r.ID] = i
+			if k := zimKey(r); k != "" {
+				if j, ok := zims[k]; !ok || newerVerification(r, s.db.Runs[j]) {
+					zims[k] = i
+				}
+			}
+		}
+		var added []Run
+		for _, r := range e.Runs {
+			i, sameRun := ids[r.ID]
+			if sameRun && zimKey(s.db.Runs[i]) != zimKey(r) {
+				// the id of another run, started at the same time
+				sameRun = false
+			}
+			j, sameZim := zims[zimKey(r)]
+			switch {
+			case sameRun && r.UpdatedAt.After(s.db.Runs[i].UpdatedAt):
+				// the artifacts of the local run are still on this machine
+				r.Resume = s.db.Runs[i].Resume
+				s.db.Runs[i] = r
+				stats.RunsUpdated++
+			case sameRun:
+				stats.Skipped++
+			case zimKey(r) != "" && sameZim:
+				if !newerVerification(r, s.db.Runs[j]) {
+					stats.Skipped++
+					continue
+				}
+				s.db.Runs[j] = r
+				stats.RunsUpdated++
+			default:
+				added = append(added, r)
+				stats.RunsAdded++
+			}
+		}
+		s.db.Runs = append(s.db.Runs, added...)
+
+		spends := make(map[string]bool, len(s.db.Spends))
+		for _, sp := range s.db.Spends {
+			spends[spendKey(sp)] = true
+		}
+		for _, sp := range e.Spends {
+			if spends[spendKey(sp)] {
+				stats.Skipped++
+				continue
+			}
+			spends[spendKey(sp)] = true
+			s.db.Spends = append(s.db.Spends, sp)
+			stats.SpendsAdded++
+		}
+
+		checks := make(map[string]bool, len(s.db.GatewayChecks))
+		for _, c := range s.db.GatewayChecks {
+			checks[checkKey(c)] = true
+		}
+		for _, c := range e.GatewayChecks {
+			if checks[checkKey(c)] {
+				stats.Skipped++
+				continue
+			}
+			checks[checkKey(c)] = true
+			s.db.GatewayChecks = append(s.db.GatewayChecks, c)
+			stats.ChecksAdded++
+		}
+
+		// keep the records oldest first
+		sort.SliceStable(s.db.Runs, func(i, j int) bool {
+			return s.db.Runs[i].StartedAt.Before(s.db.Runs[j].StartedAt)
+		})
+		sort.SliceStable(s.db.Spends, func(i, j int) bool {
+			return s.db.Spends[i].Time.Before(s.db.Spends[j].Time)
+		})
+		sort.SliceStable(s.db.GatewayChecks, func(i, j int) bool {
+			return s.db.GatewayChecks[i].Time.Before(s.db.GatewayChecks[j].Time)
+		})
+	})
+	return stats, err
+}
+
+// zimKey identifies the ZIM mirrored by a run, by its file name naming
+// the wiki and the release, e.g. wikipedia_en_top_2022-05.zim, empty for
+// a run without ZIM.
+func zimKey(r Run) string {
+	if r.ZimFile == "" {
+		return ""
+	}
+	return path.Base(r.ZimFile)
+}
+
+// newerVerification reports whether a was verified after b: a completed
+// run passed the verification of the mirror of its ZIM when last updated.
+func newerVerification(a, b Run) bool {
+	if a.Status != StatusCompleted {
+		return false
+	}
+	return b.Status != StatusCompleted || a.UpdatedAt.After(b.UpdatedAt)
+}
+
+func spendKey(sp Spend) string {
+	return fmt.Sprintf("%s %s %s %d %s", sp.Time.UTC().Format(time.RFC3339Nano), sp.Kind, sp.BatchID, sp.Amount, sp.Cost)
+}
+
+// checkKey identifies a gateway check: a root is checked once at a time
+// through each gateway.
+func checkKey(c GatewayCheck) string {
+	return c.Root + " " + c.Gateway + " " + c.Time.UTC().Format(time.RFC3339Nano)
+}
+
+// redactURL removes the credentials of a url.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	u.User = nil
+	return u.String()
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package store
+
+import "os"
+
+func rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package store
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// rename replaces the database file. Windows refuses to replace a file
+// open in another process, e.g. a beezim reading the database or an
+// antivirus scanning it, so the rename is retried for a short while.
+func rename(oldpath, newpath string) error {
+	var err error
+	for i := 0; i < 10; i++ {
+		if err = os.Rename(oldpath, newpath); err == nil {
+			return nil
+		}
+		if !errors.Is(err, windows.ERROR_ACCESS_DENIED) && !errors.Is(err, windows.ERROR_SHARING_VIOLATION) {
+			return err
+		}
+		time.Sleep(time.Duration(i+1) * 10 * time.Millisecond)
+	}
+	return err
+}
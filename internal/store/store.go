@@ -0,0 +1,276 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/r0qs/beezim/internal/filelock"
+)
+
+// SchemaVersion is the version of the database file format.
+const SchemaVersion = 1
+
+// RunStatus is the state of a beezim run.
+type RunStatus string
+
+const (
+	StatusRunning     RunStatus = "running"
+	StatusCompleted   RunStatus = "completed"
+	StatusFailed      RunStatus = "failed"
+	StatusInterrupted RunStatus = "interrupted"
+	// StatusSkipped is a run which did not start, its wiki being used by
+	// another run.
+	StatusSkipped RunStatus = "skipped"
+)
+
+// Run records a pipeline execution and what is needed to resume it.
+type Run struct {
+	ID        string    `json:"id"`
+	Command   string    `json:"command"`
+	Wiki      string    `json:"wiki,omitempty"`
+	ZimFile   string    `json:"zimFile,omitempty"`
+	ZimURL    string    `json:"zimURL,omitempty"`
+	TarFile   string    `json:"tarFile,omitempty"`
+	Stage     string    `json:"stage,omitempty"`
+	Status    RunStatus `json:"status"`
+	Reference string    `json:"reference,omitempty"`
+	// SearchReference is the root of the search index, when uploaded as
+	// its own collection.
+	SearchReference string    `json:"searchReference,omitempty"`
+	Signature       string    `json:"signature,omitempty"`
+	BatchID         string    `json:"batchID,omitempty"`
+	Error           string    `json:"error,omitempty"`
+	StartedAt       time.Time `json:"startedAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+	// Resume holds pointers to the artifacts preserved by an interrupted
+	// run (e.g. partial download, generated tar) keyed by their kind.
+	Resume map[string]string `json:"resume,omitempty"`
+}
+
+// Spend records a payment made by beezim, e.g. a postage batch top-up.
+type Spend struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	BatchID string    `json:"batchID"`
+	// Amount is the amount per chunk and Cost the total paid in PLUR,
+	// encoded as a decimal string.
+	Amount int64  `json:"amount"`
+	Depth  uint8  `json:"depth"`
+	Cost   string `json:"cost"`
+}
+
+// GatewayCheck records the availability of a root through a public gateway.
+type GatewayCheck struct {
+	Time       time.Time `json:"time"`
+	Root       string    `json:"root"`
+	Gateway    string    `json:"gateway"`
+	Success    bool      `json:"success"`
+	Checked    int       `json:"checked"`
+	Failed     int       `json:"failed"`
+	AvgLatency int64     `json:"avgLatencyMs"`
+	MaxLatency int64     `json:"maxLatencyMs"`
+	Errors     []string  `json:"errors,omitempty"`
+}
+
+type database struct {
+	SchemaVersion int            `json:"schemaVersion"`
+	Runs          []Run          `json:"runs"`
+	Spends        []Spend        `json:"spends,omitempty"`
+	GatewayChecks []GatewayCheck `json:"gatewayChecks,omitempty"`
+}
+
+// lockTimeout is how long a write waits for the lock of the database,
+// which other processes only hold while writing.
+const lockTimeout = 30 * time.Second
+
+// Store is a local database persisted as a JSON file. It can be shared by
+// several processes: the writes lock the file and apply their change to
+// its latest version, and the reads load it again once it changed.
+type Store struct {
+	mu   sync.Mutex
+	path string
+	db   database
+	// loaded is the file the database was loaded from, nil when it did
+	// not exist.
+	loaded os.FileInfo
+}
+
+// Open loads the database from path, creating an empty one if the
+// file does not exist.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the database file.
+func (s *Store) load() error {
+	db := database{SchemaVersion: SchemaVersion}
+	fi, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.db, s.loaded = db, nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, &db); err != nil {
+		return fmt.Errorf("error reading database %s: %v", s.path, err)
+	}
+	if db.SchemaVersion > SchemaVersion {
+		return fmt.Errorf("database %s has unsupported schema version %d", s.path, db.SchemaVersion)
+	}
+	db.SchemaVersion = SchemaVersion
+	s.db, s.loaded = db, fi
+	return nil
+}
+
+// refresh loads the database again when another process replaced the
+// file. The loaded version is kept when it can not be read.
+func (s *Store) refresh() {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return
+	}
+	if s.loaded != nil && os.SameFile(fi, s.loaded) && fi.ModTime().Equal(s.loaded.ModTime()) && fi.Size() == s.loaded.Size() {
+		return
+	}
+	s.load()
+}
+
+// update applies fn to the latest version of the database and persists
+// it, holding the lock of the file.
+func (s *Store) update(fn func()) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lockTimeout)
+	defer cancel()
+	l, err := filelock.Acquire(ctx, s.path+".lock")
+	if err != nil {
+		return fmt.Errorf("error locking database: %w", err)
+	}
+	defer l.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+	fn()
+	return s.save()
+}
+
+// NewRunID returns an identifier for a new run.
+func NewRunID() string {
+	return time.Now().UTC().Format("20060102T150405.000000000")
+}
+
+// PutRun inserts or replaces the run with the same ID and persists the database.
+func (s *Store) PutRun(r Run) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r.UpdatedAt = time.Now().UTC()
+	return s.update(func() {
+		for i := range s.db.Runs {
+			if s.db.Runs[i].ID == r.ID {
+				s.db.Runs[i] = r
+				return
+			}
+		}
+		s.db.Runs = append(s.db.Runs, r)
+	})
+}
+
+// Runs returns all recorded runs, oldest first.
+func (s *Store) Runs() []Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+
+	runs := make([]Run, len(s.db.Runs))
+	copy(runs, s.db.Runs)
+	return runs
+}
+
+// AddSpend records a payment and persists the database.
+func (s *Store) AddSpend(sp Spend) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sp.Time.IsZero() {
+		sp.Time = time.Now().UTC()
+	}
+	return s.update(func() {
+		s.db.Spends = append(s.db.Spends, sp)
+	})
+}
+
+// Spends returns all recorded payments, oldest first.
+func (s *Store) Spends() []Spend {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+
+	spends := make([]Spend, len(s.db.Spends))
+	copy(spends, s.db.Spends)
+	return spends
+}
+
+// AddGatewayChecks records the outcome of gateway checks and persists the database.
+func (s *Store) AddGatewayChecks(checks ...GatewayCheck) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	return s.update(func() {
+		for _, c := range checks {
+			if c.Time.IsZero() {
+				c.Time = now
+			}
+			s.db.GatewayChecks = append(s.db.GatewayChecks, c)
+		}
+	})
+}
+
+// GatewayChecks returns all recorded gateway checks, oldest first.
+func (s *Store) GatewayChecks() []GatewayCheck {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+
+	checks := make([]GatewayCheck, len(s.db.GatewayChecks))
+	copy(checks, s.db.GatewayChecks)
+	return checks
+}
+
+// save writes the database to a temporary file and renames it, so a
+// crash never leaves a truncated database behind.
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s.db, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.loaded, _ = os.Stat(s.path)
+	return nil
+}
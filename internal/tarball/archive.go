@@ -0,0 +1,211 @@
+package tarball
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ArchiveEntry describes where the content of a file is stored in a tar.
+type ArchiveEntry struct {
+	Header *tar.Header
+	Offset int64
+}
+
+// Archive provides random access to the files stored in a tar file.
+type Archive struct {
+	f       *os.File
+	entries map[string]ArchiveEntry
+	names   []string
+	// tmp is the file a gzipped tar is decompressed to.
+	tmp string
+}
+
+// ErrTarIncomplete is returned for a tar that was not completely written,
+// e.g. by an interrupted parse.
+var ErrTarIncomplete = errors.New("tar is incomplete")
+
+// OpenArchive indexes the headers of a tar file so its files can be
+// read without scanning the whole archive again. A gzipped tar is
+// decompressed to a temporary file first, removed by Close.
+func OpenArchive(tarFile string) (*Archive, error) {
+	gz, err := IsGzip(tarFile)
+	if err != nil {
+		return nil, err
+	}
+	var f *os.File
+	var tmp string
+	if gz {
+		if f, err = decompress(tarFile); err != nil {
+			return nil, err
+		}
+		tmp = f.Name()
+	} else if f, err = os.Open(tarFile); err != nil {
+		return nil, err
+	}
+
+	a := &Archive{
+		f:       f,
+		entries: make(map[string]ArchiveEntry),
+		tmp:     tmp,
+	}
+
+	// the tar reader does not buffer the underlying file, so its
+	// position after reading a header is where the file data starts
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			a.Close()
+			return nil, fmt.Errorf("error reading tar %s: %w", tarFile, ErrTarIncomplete)
+		}
+		if err != nil {
+			a.Close()
+			return nil, fmt.Errorf("error reading tar %s: %w", tarFile, err)
+		}
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+
+		offset, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			a.Close()
+			return nil, err
+		}
+
+		name := cleanName(hdr.Name)
+		if _, ok := a.entries[name]; !ok {
+			a.names = append(a.names, name)
+		}
+		a.entries[name] = ArchiveEntry{
+			Header: hdr,
+			Offset: offset,
+		}
+	}
+	sort.Strings(a.names)
+
+	return a, nil
+}
+
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// Names returns the sorted names of all entries in the archive.
+func (a *Archive) Names() []string {
+	return a.names
+}
+
+// Entry returns the entry stored with the given name.
+func (a *Archive) Entry(name string) (ArchiveEntry, bool) {
+	e, ok := a.entries[cleanName(name)]
+	return e, ok
+}
+
+// Open returns a reader for the content of the named file.
+func (a *Archive) Open(name string) (*io.SectionReader, *tar.Header, error) {
+	e, ok := a.Entry(name)
+	if !ok {
+		return nil, nil, os.ErrNotExist
+	}
+	return io.NewSectionReader(a.f, e.Offset, e.Header.Size), e.Header, nil
+}
+
+// Close closes the underlying tar file.
+func (a *Archive) Close() error {
+	err := a.f.Close()
+	if a.tmp != "" {
+		os.Remove(a.tmp)
+	}
+	return err
+}
+
+// ReadFile returns the content of the named file of the tar, the last one
+// of that name as with OpenArchive, or an error matching os.ErrNotExist.
+// It scans the headers without indexing them, seeking past the content of
+// a plain tar, so reading a file of a tar of millions of files takes no
+// more memory than the file.
+func ReadFile(tarFile string, name string) ([]byte, error) {
+	var r io.Reader
+	if gz, err := IsGzip(tarFile); err != nil {
+		return nil, err
+	} else if gz {
+		rc, err := Open(tarFile)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		r = rc
+	} else {
+		f, err := os.Open(tarFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	name = cleanName(name)
+	var data []byte
+	found := false
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("error reading tar %s: %w", tarFile, ErrTarIncomplete)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading tar %s: %w", tarFile, err)
+		}
+		if hdr.Typeflag == tar.TypeXGlobalHeader || cleanName(hdr.Name) != name {
+			continue
+		}
+		if data, err = io.ReadAll(tr); err != nil {
+			return nil, fmt.Errorf("error reading %s of %s: %w", name, tarFile, err)
+		}
+		found = true
+	}
+	if !found {
+		return nil, os.ErrNotExist
+	}
+	return data, nil
+}
+
+// decompress returns the temporary file of the gzipped tar decompressed,
+// at its start.
+func decompress(tarFile string) (*os.File, error) {
+	r, err := Open(tarFile)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	f, err := os.CreateTemp("", "beezim-*.tar")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = ErrTarIncomplete
+		}
+		return nil, fmt.Errorf("error reading tar %s: %w", tarFile, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
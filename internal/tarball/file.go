@@ -48,6 +48,26 @@ func NewBytesFile(name string, data []byte) *File {
 	}
 }
 
+// NewReaderFile returns a file of an unknown size, whose Size is -1, read
+// from r, e.g. a tar written as it is uploaded.
+func NewReaderFile(name string, r io.Reader) *File {
+	return &File{
+		name:       name,
+		dataReader: r,
+		size:       -1,
+	}
+}
+
+// NewSizedReaderFile returns a file of size bytes read from r, e.g. a page
+// written to a temporary file.
+func NewSizedReaderFile(name string, r io.Reader, size int64) *File {
+	return &File{
+		name:       name,
+		dataReader: r,
+		size:       size,
+	}
+}
+
 // CalculateHash calculates hash from dataReader.
 // It replaces dataReader with another that will contain the data.
 func (f *File) CalculateHash() error {
@@ -0,0 +1,82 @@
+package tarball
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// GzipExt is the extension added to the name of the gzipped tars.
+const GzipExt = ".gz"
+
+// ErrTarCompressed is returned when appending to a gzipped tar, which
+// can only be written at once.
+var ErrTarCompressed = errors.New("tar is compressed")
+
+// GzipName returns the name of the gzipped tar of the tar name, e.g.
+// wiki.tar.gz for wiki.tar.
+func GzipName(name string) string {
+	return strings.TrimSuffix(name, GzipExt) + GzipExt
+}
+
+// CheckGzipLevel returns an error for a level that is not one of those
+// of compress/gzip.
+func CheckGzipLevel(level int) error {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return fmt.Errorf("invalid gzip level %d, from %d to %d", level, gzip.HuffmanOnly, gzip.BestCompression)
+	}
+	return nil
+}
+
+// IsGzip reports whether the file is gzipped, from its first bytes.
+func IsGzip(tarFile string) (bool, error) {
+	f, err := os.Open(tarFile)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return isGzip(bufio.NewReader(f))
+}
+
+func isGzip(r *bufio.Reader) (bool, error) {
+	magic, err := r.Peek(2)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return magic[0] == 0x1f && magic[1] == 0x8b, nil
+}
+
+// Open opens the tar file for reading, decompressed when it is gzipped.
+func Open(tarFile string) (io.ReadCloser, error) {
+	f, err := os.Open(tarFile)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	gz, err := isGzip(br)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !gz {
+		return readCloser{br, f}, nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error reading tar %s: %w", tarFile, err)
+	}
+	return readCloser{zr, f}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,33 @@
+package tarball
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Names in tars and manifests are slash separated, whatever the platform.
+// The helpers below convert them from and to the paths of the filesystem,
+// which use the separator of the OS.
+
+// ErrUnsafeName is returned for a name that would escape the directory
+// it is extracted to.
+var ErrUnsafeName = errors.New("name escapes the target directory")
+
+// TarName returns the tar name of the file at rel, a path relative to the
+// directory being archived.
+func TarName(rel string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(rel)), "/")
+}
+
+// LocalPath returns the path under dir of the file named name in a tar or
+// manifest.
+func LocalPath(dir string, name string) (string, error) {
+	rel := filepath.FromSlash(path.Clean(name))
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%s: %w", name, ErrUnsafeName)
+	}
+	return filepath.Join(dir, rel), nil
+}
@@ -0,0 +1,44 @@
+package tarball
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestTarName(t *testing.T) {
+	for rel, want := range map[string]string{
+		filepath.Join("A", "Foo"):          "A/Foo",
+		filepath.Join("A", "..", "I", "x"): "I/x",
+		filepath.Join("_beezim", "a.js"):   "_beezim/a.js",
+		"index.html":                       "index.html",
+	} {
+		if got := TarName(rel); got != want {
+			t.Errorf("TarName(%q) = %q, want %q", rel, got, want)
+		}
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	dir := t.TempDir()
+	for name, want := range map[string]string{
+		"A/Foo":          filepath.Join(dir, "A", "Foo"),
+		"A/Dir/Page":     filepath.Join(dir, "A", "Dir", "Page"),
+		"A/./Foo":        filepath.Join(dir, "A", "Foo"),
+		"_beezim/a.js":   filepath.Join(dir, "_beezim", "a.js"),
+		"A/C# (lang)":    filepath.Join(dir, "A", "C# (lang)"),
+		"A/Dir/../Other": filepath.Join(dir, "A", "Other"),
+	} {
+		got, err := LocalPath(dir, name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if got != want {
+			t.Errorf("LocalPath(%q) = %q, want %q", name, got, want)
+		}
+	}
+	for _, name := range []string{"../outside", "A/../../outside", "/etc/passwd"} {
+		if p, err := LocalPath(dir, name); !errors.Is(err, ErrUnsafeName) {
+			t.Errorf("%q: got %q, %v, want %v", name, p, err, ErrUnsafeName)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+//go:build windows
+
+package tarball
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTarNameWindows(t *testing.T) {
+	for rel, want := range map[string]string{
+		`A\Foo`:        "A/Foo",
+		`A\Dir\Page`:   "A/Dir/Page",
+		`_beezim\a.js`: "_beezim/a.js",
+		`A\..\I\x.png`: "I/x.png",
+	} {
+		if got := TarName(rel); got != want {
+			t.Errorf("TarName(%q) = %q, want %q", rel, got, want)
+		}
+	}
+}
+
+func TestLocalPathWindows(t *testing.T) {
+	got, err := LocalPath(`C:\wiki`, "A/Dir/Page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `C:\wiki\A\Dir\Page`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// a drive, a root or a name windows reserves does not stay in the
+	// directory
+	for _, name := range []string{"C:/Windows", `A/..\..\outside`, "//host/share", "A/CON", "NUL"} {
+		if p, err := LocalPath(`C:\wiki`, name); !errors.Is(err, ErrUnsafeName) {
+			t.Errorf("%q: got %q, %v, want %v", name, p, err, ErrUnsafeName)
+		}
+	}
+}
@@ -2,20 +2,54 @@ package tarball
 
 import (
 	"archive/tar"
+	"bufio"
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
+// Header returns the header of a file of the tars written by beezim. The
+// files all have the same mode, owner and time, so that the same content
+// gives the same tar, and so the same swarm reference.
+func Header(name string, size int64) *tar.Header {
+	return &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Unix(0, 0),
+	}
+}
+
+// DirHeader returns the header of a directory of the tars written by
+// beezim, named with a trailing slash, with the same owner and time as
+// those of Header.
+func DirHeader(name string) *tar.Header {
+	return &tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     strings.TrimSuffix(name, "/") + "/",
+		Mode:     0755,
+		ModTime:  time.Unix(0, 0),
+	}
+}
+
+// AppendTarFile appends the file to the tar, which can not be gzipped.
 func AppendTarFile(tarFile string, file *File) error {
 	tf, err := os.OpenFile(tarFile, os.O_RDWR, os.ModePerm)
 	if err != nil {
 		return err
 	}
 	defer tf.Close()
+	if gz, err := isGzip(bufio.NewReader(tf)); err != nil {
+		return err
+	} else if gz {
+		return fmt.Errorf("appending %s to %s: %w", file.name, filepath.Base(tarFile), ErrTarCompressed)
+	}
 
 	// https://www.freebsd.org/cgi/man.cgi?query=tar&sektion=5
 	// A tar archive consists of a series	of 512-byte records.
@@ -26,13 +60,7 @@ func AppendTarFile(tarFile string, file *File) error {
 	}
 	tw := tar.NewWriter(tf)
 
-	hdr := &tar.Header{
-		Name: file.name,
-		Mode: 0600,
-		Size: file.size,
-	}
-
-	if err := tw.WriteHeader(hdr); err != nil {
+	if err := tw.WriteHeader(Header(file.name, file.size)); err != nil {
 		return err
 	}
 
@@ -43,16 +71,74 @@ func AppendTarFile(tarFile string, file *File) error {
 	return tw.Close()
 }
 
+// ReadTarBuffer reads the tar, gzipped or not, into a plain tar without
+// its global headers.
 func ReadTarBuffer(tarFile string) (*bytes.Buffer, error) {
-	f, err := os.Open(tarFile)
+	f, err := Open(tarFile)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
 	var buf bytes.Buffer
-	tw := tar.NewWriter(&buf)
-	tr := tar.NewReader(f)
+	if err := copyTar(&buf, f, nil); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// StreamTar returns the plain tar of ReadTarBuffer written as it is read,
+// rather than held in memory, and its size. Closing the reader before
+// its end stops the writing.
+func StreamTar(tarFile string) (io.ReadCloser, int64, error) {
+	size, err := plainSize(tarFile)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := Open(tarFile)
+	if err != nil {
+		return nil, 0, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		defer f.Close()
+		// fewer writes to the pipe than the headers and payloads
+		bw := bufio.NewWriterSize(pw, 64<<10)
+		err := copyTar(bw, f, nil)
+		if err == nil {
+			err = bw.Flush()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, size, nil
+}
+
+// FilterTar returns the plain tar of StreamTar without the files for
+// which skip returns true, written as it is read. Its size is only known
+// once it was read.
+func FilterTar(tarFile string, skip func(name string) bool) (io.ReadCloser, error) {
+	f, err := Open(tarFile)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		defer f.Close()
+		bw := bufio.NewWriterSize(pw, 64<<10)
+		err := copyTar(bw, f, skip)
+		if err == nil {
+			err = bw.Flush()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// copyTar writes the tar read from r to w without its global headers, nor
+// the files for which skip, when set, returns true.
+func copyTar(w io.Writer, r io.Reader, skip func(name string) bool) error {
+	tw := tar.NewWriter(w)
+	tr := tar.NewReader(r)
 
 	for {
 		hdr, err := tr.Next()
@@ -60,26 +146,85 @@ func ReadTarBuffer(tarFile string) (*bytes.Buffer, error) {
 			break
 		}
 		if err != nil {
-			return nil, err
+			return err
+		}
+		// global headers only hold metadata about the tar itself
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		if skip != nil && hdr.Typeflag == tar.TypeReg && skip(hdr.Name) {
+			continue
 		}
 
 		if err := tw.WriteHeader(hdr); err != nil {
-			return nil, err
+			return err
 		}
 
 		if _, err := io.Copy(tw, tr); err != nil {
-			return nil, err
+			return err
 		}
 	}
 
-	if err := tw.Close(); err != nil {
-		return nil, err
+	return tw.Close()
+}
+
+// plainSize returns the size of the tar written by copyTar, reading only
+// the headers of a tar that is not gzipped.
+func plainSize(tarFile string) (int64, error) {
+	gz, err := IsGzip(tarFile)
+	if err != nil {
+		return 0, err
 	}
-	return &buf, nil
+	var r io.ReadCloser
+	if gz {
+		r, err = Open(tarFile)
+	} else {
+		// a file, whose payloads the tar reader seeks over
+		r, err = os.Open(tarFile)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	var size countWriter
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		// the headers are written on their own, with the records of the
+		// long names, and the payloads padded to whole blocks
+		if err := tar.NewWriter(&size).WriteHeader(hdr); err != nil {
+			return 0, err
+		}
+		size += countWriter((hdr.Size + blockSize - 1) / blockSize * blockSize)
+	}
+	// the two zero blocks ending the tar
+	return int64(size) + 2*blockSize, nil
 }
 
+// blockSize is the size of the blocks of a tar.
+const blockSize = 512
+
+// countWriter counts the bytes written to it.
+type countWriter int64
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	*c += countWriter(len(p))
+	return len(p), nil
+}
+
+// Untar extracts the files of the tar, gzipped or not, to targetDir.
 func Untar(tarFile string, targetDir string) error {
-	reader, err := os.Open(tarFile)
+	reader, err := Open(tarFile)
 	if err != nil {
 		return err
 	}
@@ -95,7 +240,10 @@ func Untar(tarFile string, targetDir string) error {
 			return err
 		}
 
-		filePath := filepath.Join(targetDir, header.Name)
+		filePath, err := LocalPath(targetDir, header.Name)
+		if err != nil {
+			return err
+		}
 		baseFilePath := filepath.Dir(filePath)
 		if _, err := os.Stat(baseFilePath); os.IsNotExist(err) {
 			if err := os.MkdirAll(baseFilePath, 0755); err != nil {
@@ -155,7 +303,7 @@ func Tar(sourceDir string, tarFile string) error {
 			return err
 		}
 
-		hdr.Name = filepath.Join(baseDir, strings.TrimPrefix(path, sourceDir))
+		hdr.Name = TarName(filepath.Join(baseDir, strings.TrimPrefix(path, sourceDir)))
 		if err := tw.WriteHeader(hdr); err != nil {
 			return err
 		}
@@ -0,0 +1,78 @@
+// Package version identifies the build of beezim, from the version set
+// at link time and the build information recorded by the Go toolchain.
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// Version is the version of beezim, set at build time with
+// -ldflags "-X github.com/r0qs/beezim/internal/version.Version=<version>".
+// When not set, it is the version of the module, e.g. when installed with
+// go install, or "dev".
+var Version string
+
+// Info describes the build of beezim.
+type Info struct {
+	Version string `json:"version"`
+	// Revision is the commit the binary was built from, and Modified is
+	// set when the tree had uncommitted changes.
+	Revision     string `json:"revision,omitempty"`
+	RevisionTime string `json:"revisionTime,omitempty"`
+	Modified     bool   `json:"modified,omitempty"`
+	GoVersion    string `json:"goVersion"`
+}
+
+func (i Info) String() string {
+	var b strings.Builder
+	b.WriteString(i.Version)
+	if i.Revision != "" {
+		rev := i.Revision
+		if len(rev) > 12 {
+			rev = rev[:12]
+		}
+		fmt.Fprintf(&b, " (%s", rev)
+		if i.Modified {
+			b.WriteString(", modified")
+		}
+		b.WriteString(")")
+	}
+	fmt.Fprintf(&b, " %s", i.GoVersion)
+	return b.String()
+}
+
+var (
+	once sync.Once
+	info Info
+)
+
+// Get returns the build of the running beezim.
+func Get() Info {
+	once.Do(func() {
+		info = Info{Version: Version, GoVersion: runtime.Version()}
+		bi, ok := debug.ReadBuildInfo()
+		if ok {
+			if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+				info.Version = bi.Main.Version
+			}
+			for _, s := range bi.Settings {
+				switch s.Key {
+				case "vcs.revision":
+					info.Revision = s.Value
+				case "vcs.time":
+					info.RevisionTime = s.Value
+				case "vcs.modified":
+					info.Modified = s.Value == "true"
+				}
+			}
+		}
+		if info.Version == "" {
+			info.Version = "dev"
+		}
+	})
+	return info
+}
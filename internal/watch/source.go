@@ -0,0 +1,72 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/r0qs/beezim/internal/catalog"
+)
+
+// CatalogSource finds releases in the Kiwix catalog.
+type CatalogSource struct {
+	// Entries returns the catalog entries, usually from a catalog.Cache.
+	Entries func(ctx context.Context) ([]catalog.Entry, error)
+	// Filter restricts the entries considered. Its Name is replaced by the wiki.
+	Filter catalog.Filter
+}
+
+// Latest returns the newest catalog entry whose name matches the wiki.
+func (s CatalogSource) Latest(ctx context.Context, wiki string) (Release, error) {
+	entries, err := s.Entries(ctx)
+	if err != nil {
+		return Release{}, err
+	}
+
+	f := s.Filter
+	f.Name = wiki
+	e, err := catalog.Latest(entries, f)
+	if err != nil {
+		return Release{}, err
+	}
+	return Release{Wiki: wiki, FileName: e.FileName(), URL: e.URL, Date: e.Date}, nil
+}
+
+// DirSource finds releases in a local directory, e.g. a directory where
+// ZIM files are synced by another tool. The URL of its releases is the
+// path of the file.
+type DirSource struct {
+	Dir string
+}
+
+// Latest returns the most recently modified ZIM file whose name matches the wiki.
+func (s DirSource) Latest(ctx context.Context, wiki string) (Release, error) {
+	files, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return Release{}, err
+	}
+
+	var entries []catalog.Entry
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".zim" {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			return Release{}, err
+		}
+		entries = append(entries, catalog.Entry{
+			Name: strings.TrimSuffix(f.Name(), ".zim"),
+			URL:  filepath.Join(s.Dir, f.Name()),
+			Date: info.ModTime(),
+		})
+	}
+
+	e, err := catalog.Latest(entries, catalog.Filter{Name: wiki})
+	if err != nil {
+		return Release{}, fmt.Errorf("no zim file in %s matching %q", s.Dir, wiki)
+	}
+	return Release{Wiki: wiki, FileName: e.FileName(), URL: e.URL, Date: e.Date}, nil
+}
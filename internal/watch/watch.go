@@ -0,0 +1,281 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/r0qs/beezim/internal/random"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// Release is a published version of a watched wiki.
+type Release struct {
+	Wiki     string    `json:"wiki"`
+	FileName string    `json:"fileName"`
+	URL      string    `json:"url"`
+	Date     time.Time `json:"date"`
+}
+
+// Source finds the newest release of a wiki.
+type Source interface {
+	Latest(ctx context.Context, wiki string) (Release, error)
+}
+
+// MirrorFunc mirrors a release and returns the id of the recorded run and
+// the swarm reference of the uploaded content.
+type MirrorFunc func(ctx context.Context, r Release) (runID string, reference string, err error)
+
+// Status is the state of a watched wiki.
+type Status struct {
+	Wiki        string    `json:"wiki"`
+	LastCheck   time.Time `json:"lastCheck"`
+	NextCheck   time.Time `json:"nextCheck"`
+	CheckError  string    `json:"checkError,omitempty"`
+	Mirrored    string    `json:"mirrored,omitempty"`
+	Running     bool      `json:"running"`
+	LastRun     *RunInfo  `json:"lastRun,omitempty"`
+	LastRelease *Release  `json:"lastRelease,omitempty"`
+}
+
+// RunInfo summarizes the last mirror run of a wiki.
+type RunInfo struct {
+	ID         string    `json:"id,omitempty"`
+	FileName   string    `json:"fileName"`
+	Success    bool      `json:"success"`
+	Reference  string    `json:"reference,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+// Options configures a Watcher.
+type Options struct {
+	// Interval is the time between two checks of the same wiki.
+	Interval time.Duration
+	// Jitter is the maximum random delay added to every check, so wikis
+	// are not all checked (and mirrored) at the same time.
+	Jitter time.Duration
+	// Seed draws the jitter of every wiki, a random seed when zero.
+	Seed int64
+	// Mirrored maps each wiki to the file name of its last mirrored
+	// release, as recovered from the local database.
+	Mirrored map[string]string
+	// StatusFile, if set, receives the JSON status of all wikis on every change.
+	StatusFile string
+	// Logger receives the log output, the default logger when nil.
+	Logger logging.Logger
+}
+
+// Watcher periodically checks a source for new releases of the watched
+// wikis and mirrors them.
+type Watcher struct {
+	src    Source
+	mirror MirrorFunc
+	opts   Options
+
+	mu       sync.Mutex
+	status   map[string]*Status
+	inflight map[string]bool
+
+	// fileMu serializes the writes of the status file, from the snapshot
+	// of the status to the rename, so an older snapshot never replaces a
+	// newer one.
+	fileMu sync.Mutex
+}
+
+// New returns a watcher for the given wikis.
+func New(wikis []string, src Source, mirror MirrorFunc, opts Options) *Watcher {
+	w := &Watcher{
+		src:      src,
+		mirror:   mirror,
+		opts:     opts,
+		status:   make(map[string]*Status, len(wikis)),
+		inflight: make(map[string]bool),
+	}
+	if w.opts.Seed == 0 {
+		w.opts.Seed = random.NewSeed()
+	}
+	for _, wiki := range wikis {
+		w.status[wiki] = &Status{Wiki: wiki, Mirrored: opts.Mirrored[wiki]}
+	}
+	return w
+}
+
+// Run checks every wiki until the context is canceled. The first check of
+// each wiki happens after a random delay up to the configured jitter.
+func (w *Watcher) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for wiki := range w.status {
+		wg.Add(1)
+		go func(wiki string) {
+			defer wg.Done()
+			rnd := random.New(w.opts.Seed, "watch/"+wiki)
+			delay := w.jitter(rnd)
+			for {
+				w.update(wiki, func(s *Status) {
+					s.NextCheck = time.Now().Add(delay).UTC()
+				})
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+
+				w.Check(ctx, wiki)
+				delay = w.opts.Interval + w.jitter(rnd)
+			}
+		}(wiki)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (w *Watcher) jitter(rnd *rand.Rand) time.Duration {
+	if w.opts.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rnd.Int63n(int64(w.opts.Jitter)))
+}
+
+// Check looks for a new release of the wiki and mirrors it. It reports
+// whether a mirror run was started. Concurrent checks of the same wiki
+// are skipped while a run is in progress.
+func (w *Watcher) Check(ctx context.Context, wiki string) bool {
+	w.mu.Lock()
+	if w.inflight[wiki] {
+		w.mu.Unlock()
+		w.log().Info("wiki is still being mirrored, skipping check", "wiki", wiki)
+		return false
+	}
+	w.inflight[wiki] = true
+	w.mu.Unlock()
+
+	defer func() {
+		w.mu.Lock()
+		delete(w.inflight, wiki)
+		w.mu.Unlock()
+	}()
+
+	release, err := w.src.Latest(ctx, wiki)
+	w.update(wiki, func(s *Status) {
+		s.LastCheck = time.Now().UTC()
+		s.CheckError = ""
+		if err != nil {
+			s.CheckError = err.Error()
+			return
+		}
+		s.LastRelease = &release
+	})
+	if err != nil {
+		w.log().Error("error checking wiki", "wiki", wiki, "err", err)
+		return false
+	}
+
+	if release.FileName == w.mirrored(wiki) {
+		w.log().Info("wiki is up to date", "wiki", wiki, "release", release.FileName)
+		return false
+	}
+
+	w.log().Info("new release found", "wiki", wiki, "release", release.FileName)
+	info := &RunInfo{FileName: release.FileName, StartedAt: time.Now().UTC()}
+	w.update(wiki, func(s *Status) {
+		s.Running = true
+	})
+
+	info.ID, info.Reference, err = w.mirror(ctx, release)
+	info.FinishedAt = time.Now().UTC()
+	info.Success = err == nil
+	if err != nil {
+		info.Error = err.Error()
+		w.log().Error("error mirroring release", "wiki", wiki, "release", release.FileName, "err", err)
+	}
+
+	w.update(wiki, func(s *Status) {
+		s.Running = false
+		s.LastRun = info
+		if err == nil {
+			s.Mirrored = release.FileName
+		}
+	})
+	return true
+}
+
+func (w *Watcher) log() logging.Logger {
+	return logging.Or(w.opts.Logger)
+}
+
+func (w *Watcher) mirrored(wiki string) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status[wiki].Mirrored
+}
+
+func (w *Watcher) update(wiki string, fn func(s *Status)) {
+	w.mu.Lock()
+	fn(w.status[wiki])
+	w.mu.Unlock()
+
+	if w.opts.StatusFile != "" {
+		if err := w.writeStatusFile(); err != nil {
+			w.log().Error("error writing status file", "file", w.opts.StatusFile, "err", err)
+		}
+	}
+}
+
+// Status returns the state of all watched wikis sorted by name.
+func (w *Watcher) Status() []Status {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	status := make([]Status, 0, len(w.status))
+	for _, s := range w.status {
+		status = append(status, *s)
+	}
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Wiki < status[j].Wiki
+	})
+	return status
+}
+
+func (w *Watcher) writeStatusFile() error {
+	w.fileMu.Lock()
+	defer w.fileMu.Unlock()
+
+	data, err := json.MarshalIndent(w.Status(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(w.opts.StatusFile), 0755); err != nil {
+		return err
+	}
+	tmp := w.opts.StatusFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.opts.StatusFile)
+}
+
+// Handler serves the status of the watched wikis as JSON.
+func (w *Watcher) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			rw.Header().Set("Allow", "GET, HEAD")
+			http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(rw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(w.Status()); err != nil {
+			w.log().Error("error writing status", "err", err)
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/r0qs/beezim/internal/catalog"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// polls is a catalog whose n-th poll returns the n-th list of entries, the
+// last one once they are all polled.
+type polls struct {
+	mu      sync.Mutex
+	n       int
+	entries [][]catalog.Entry
+}
+
+func (p *polls) Entries(ctx context.Context) ([]catalog.Entry, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e := p.entries[min(p.n, len(p.entries)-1)]
+	p.n++
+	return e, nil
+}
+
+func (p *polls) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.n
+}
+
+func release(date string) catalog.Entry {
+	d, err := time.Parse("2006-01", date)
+	if err != nil {
+		panic(err)
+	}
+	return catalog.Entry{
+		Name: "wikipedia_en_climate_change",
+		URL:  "https://download.kiwix.org/zim/wikipedia/wikipedia_en_climate_change_mini_" + date + ".zim",
+		Date: d,
+	}
+}
+
+// TestTwoPolls checks the catalog twice, the second poll offering a newer
+// release than the one recorded in the local database, and mirrors it once.
+func TestTwoPolls(t *testing.T) {
+	const wiki = "wikipedia_en_climate_change"
+	src := &polls{entries: [][]catalog.Entry{
+		{release("2022-03")},
+		{release("2022-03"), release("2022-05")},
+	}}
+	var runs []Release
+	mirror := func(ctx context.Context, r Release) (string, string, error) {
+		runs = append(runs, r)
+		return "run-1", "ref", nil
+	}
+	statusFile := filepath.Join(t.TempDir(), "status.json")
+	w := New([]string{wiki}, CatalogSource{Entries: src.Entries}, mirror, Options{
+		Mirrored:   map[string]string{wiki: "wikipedia_en_climate_change_mini_2022-03.zim"},
+		StatusFile: statusFile,
+		Logger:     logging.Discard(),
+	})
+
+	ctx := context.Background()
+	if w.Check(ctx, wiki) {
+		t.Fatal("mirrored the release of the local database")
+	}
+	if !w.Check(ctx, wiki) {
+		t.Fatal("the newer release was not mirrored")
+	}
+	// the release mirrored is now up to date
+	if w.Check(ctx, wiki) {
+		t.Fatal("mirrored the same release twice")
+	}
+	if len(runs) != 1 {
+		t.Fatalf("got %d runs, want 1", len(runs))
+	}
+	if runs[0].FileName != "wikipedia_en_climate_change_mini_2022-05.zim" || runs[0].URL != release("2022-05").URL {
+		t.Errorf("mirrored %+v, want the release of 2022-05", runs[0])
+	}
+
+	data, err := os.ReadFile(statusFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status []Status
+	if err := json.Unmarshal(data, &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 1 {
+		t.Fatalf("status of %d wikis, want 1", len(status))
+	}
+	s := status[0]
+	if s.Mirrored != runs[0].FileName || s.Running || s.LastRun == nil || !s.LastRun.Success || s.LastRun.ID != "run-1" || s.LastRun.Reference != "ref" {
+		t.Errorf("status %+v, last run %+v", s, s.LastRun)
+	}
+}
+
+// TestRunTwoPolls runs the watcher until the catalog was polled twice after
+// its newer release, the status file recording every check.
+func TestRunTwoPolls(t *testing.T) {
+	const wiki = "wikipedia_en_climate_change"
+	src := &polls{entries: [][]catalog.Entry{
+		{release("2022-03")},
+		{release("2022-05")},
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var runs atomic.Int32
+	mirror := func(ctx context.Context, r Release) (string, string, error) {
+		runs.Add(1)
+		return "", "", nil
+	}
+	w := New([]string{wiki}, CatalogSource{Entries: func(ctx context.Context) ([]catalog.Entry, error) {
+		e, err := src.Entries(ctx)
+		if src.count() >= 4 {
+			cancel()
+		}
+		return e, err
+	}}, mirror, Options{
+		Interval:   time.Millisecond,
+		Jitter:     time.Millisecond,
+		Seed:       1,
+		Mirrored:   map[string]string{wiki: "wikipedia_en_climate_change_mini_2022-03.zim"},
+		StatusFile: filepath.Join(t.TempDir(), "status.json"),
+		Logger:     logging.Discard(),
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the catalog was not polled")
+	}
+	if n := runs.Load(); n != 1 {
+		t.Errorf("got %d runs, want 1", n)
+	}
+}
+
+// TestCheckSingleFlight skips the checks of a wiki while it is mirrored.
+func TestCheckSingleFlight(t *testing.T) {
+	const wiki = "wikipedia_en_climate_change"
+	src := &polls{entries: [][]catalog.Entry{{release("2022-05")}}}
+	started, finish := make(chan struct{}), make(chan struct{})
+	var runs atomic.Int32
+	mirror := func(ctx context.Context, r Release) (string, string, error) {
+		runs.Add(1)
+		close(started)
+		<-finish
+		return "", "", errors.New("upload failed")
+	}
+	w := New([]string{wiki}, CatalogSource{Entries: src.Entries}, mirror, Options{Logger: logging.Discard()})
+
+	ctx := context.Background()
+	done := make(chan bool)
+	go func() { done <- w.Check(ctx, wiki) }()
+	<-started
+	if w.Check(ctx, wiki) {
+		t.Error("checked a wiki being mirrored")
+	}
+	if s := w.Status()[0]; !s.Running {
+		t.Errorf("status %+v, want it running", s)
+	}
+	close(finish)
+	if !<-done {
+		t.Error("the first check did not mirror")
+	}
+	if n := runs.Load(); n != 1 {
+		t.Errorf("got %d runs, want 1", n)
+	}
+	// a failed run is not recorded as mirrored
+	s := w.Status()[0]
+	if s.Running || s.Mirrored != "" || s.LastRun == nil || s.LastRun.Success || s.LastRun.Error != "upload failed" {
+		t.Errorf("status %+v, last run %+v", s, s.LastRun)
+	}
+}
@@ -0,0 +1,260 @@
+package workdir
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/r0qs/beezim/internal/diskspace"
+	"github.com/r0qs/beezim/internal/filelock"
+)
+
+// expansionFactor is how much larger the parsed content is expected to be
+// than its ZIM. ZIM clusters are compressed with xz or zstd, text heavy
+// wikis expand about three times while media is stored already compressed.
+const expansionFactor = 3
+
+// EstimateSize returns the disk space expected to be needed to parse a ZIM
+// of the given size, either to a tar or to an extraction directory.
+func EstimateSize(zimSize int64) uint64 {
+	if zimSize <= 0 {
+		return 0
+	}
+	return uint64(zimSize) * expansionFactor
+}
+
+// Workdir is the directory holding the artifacts generated from the ZIMs:
+// the tars and the extraction directories.
+type Workdir struct {
+	Dir string
+	// Available returns the free bytes on the filesystem containing path.
+	Available func(path string) (uint64, error)
+}
+
+// New returns the workdir at dir, creating it if needed.
+func New(dir string) (*Workdir, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Workdir{Dir: dir, Available: diskspace.Available}, nil
+}
+
+// baseName returns the name of the ZIM without extension, shared by all
+// its artifacts.
+func baseName(zimFile string) string {
+	return strings.TrimSuffix(filepath.Base(zimFile), filepath.Ext(zimFile))
+}
+
+// TarPath returns the path of the tar built from the ZIM.
+func (w *Workdir) TarPath(zimFile string) string {
+	return filepath.Join(w.Dir, baseName(zimFile)+".tar")
+}
+
+// SearchTarPath returns the path of the tar holding the search index of
+// the ZIM, when it is uploaded as its own collection.
+func (w *Workdir) SearchTarPath(zimFile string) string {
+	return filepath.Join(w.Dir, baseName(zimFile)+"-search.tar")
+}
+
+// VolumesSuffix ends the name of the directory of the volumes of a ZIM.
+const VolumesSuffix = "-volumes"
+
+// VolumesDir returns the directory of the volumes of the tar built from
+// the ZIM, when it is split.
+func (w *Workdir) VolumesDir(zimFile string) string {
+	return filepath.Join(w.Dir, baseName(zimFile)+VolumesSuffix)
+}
+
+// EntriesSuffix ends the name of the directory of the entries of a parse
+// kept on disk.
+const EntriesSuffix = "-entries"
+
+// EntriesDir returns the directory of the entries of the parses of the
+// ZIM kept on disk, see indexer.DiskStore, removed by Clean once they are
+// left by a process that did not end.
+func (w *Workdir) EntriesDir(zimFile string) string {
+	return filepath.Join(w.Dir, baseName(zimFile)+EntriesSuffix)
+}
+
+// ExtractDir returns the directory where the ZIM is extracted.
+func (w *Workdir) ExtractDir(zimFile string) string {
+	return filepath.Join(w.Dir, baseName(zimFile))
+}
+
+// lockSuffix is the extension of the lock files of the wikis.
+const lockSuffix = ".lock"
+
+// LockPath returns the path of the file locking the artifacts of the ZIM,
+// held by the process generating or uploading them.
+func (w *Workdir) LockPath(zimFile string) string {
+	return filepath.Join(w.Dir, baseName(zimFile)+lockSuffix)
+}
+
+// CheckSpace returns an error when the workdir has less than required
+// bytes available. Platforms where the free space can not be queried are
+// always considered to have enough space.
+func (w *Workdir) CheckSpace(required uint64) error {
+	avail, ok, err := w.available()
+	if !ok {
+		return err
+	}
+
+	if avail < required {
+		return fmt.Errorf("%w in workdir %s: about %s required, %s available", diskspace.ErrNoSpace, w.Dir, formatBytes(required), formatBytes(avail))
+	}
+	return nil
+}
+
+// SpaceGuard returns a check failing once the workdir has less than
+// lowWater bytes available, so that long writes stop before the disk is
+// full.
+func (w *Workdir) SpaceGuard(lowWater uint64) func() error {
+	return func() error {
+		avail, ok, err := w.available()
+		if !ok {
+			return err
+		}
+
+		if avail < lowWater {
+			return fmt.Errorf("%w in workdir %s: %s left, under the %s kept free", diskspace.ErrNoSpace, w.Dir, formatBytes(avail), formatBytes(lowWater))
+		}
+		return nil
+	}
+}
+
+// available returns the bytes available in the workdir, ok false when they
+// are not known.
+func (w *Workdir) available() (avail uint64, ok bool, err error) {
+	avail, err = w.Available(w.Dir)
+	if errors.Is(err, diskspace.ErrUnsupported) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return avail, true, nil
+}
+
+// Policy decides which artifacts survive a successful upload.
+type Policy struct {
+	KeepTar       bool
+	KeepExtracted bool
+}
+
+// Release removes the artifacts of the ZIM not kept by the policy.
+func (w *Workdir) Release(zimFile string, p Policy) error {
+	if !p.KeepTar {
+		for _, tar := range []string{w.TarPath(zimFile), w.SearchTarPath(zimFile)} {
+			if err := os.Remove(tar); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		if err := os.RemoveAll(w.VolumesDir(zimFile)); err != nil {
+			return err
+		}
+	}
+	if !p.KeepExtracted {
+		if err := os.RemoveAll(w.ExtractDir(zimFile)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Artifacts returns the names of all the entries in the workdir.
+func (w *Workdir) Artifacts() ([]string, error) {
+	entries, err := os.ReadDir(w.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Clean removes the artifacts whose name is not in keep and returns the
+// paths removed. The artifacts of the wikis locked by a process are kept,
+// and the lock files are left, empty once released. Nothing is removed on
+// a dry run.
+func (w *Workdir) Clean(keep map[string]bool, dryRun bool) ([]string, error) {
+	names, err := w.Artifacts()
+	if err != nil {
+		return nil, err
+	}
+
+	// the locks are held until the end of the clean, so that no process
+	// starts writing the artifacts being removed
+	var locks []*filelock.Lock
+	defer func() {
+		for _, l := range locks {
+			l.Unlock()
+		}
+	}()
+	locked := make(map[string]bool)
+	for _, name := range names {
+		if !strings.HasSuffix(name, lockSuffix) {
+			continue
+		}
+		l, err := filelock.TryLock(filepath.Join(w.Dir, name))
+		if errors.Is(err, filelock.ErrLocked) {
+			locked[strings.TrimSuffix(name, lockSuffix)] = true
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		locks = append(locks, l)
+	}
+
+	var removed []string
+	for _, name := range names {
+		if keep[name] || strings.HasSuffix(name, lockSuffix) || isLocked(name, locked) {
+			continue
+		}
+		p := filepath.Join(w.Dir, name)
+		if !dryRun {
+			if err := os.RemoveAll(p); err != nil {
+				return removed, err
+			}
+		}
+		removed = append(removed, p)
+	}
+	return removed, nil
+}
+
+// isLocked reports whether the artifact belongs to a locked wiki, the
+// checkpoint and journal of a tar belonging to the wiki of the tar.
+func isLocked(name string, locked map[string]bool) bool {
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".checkpoint"), ".journal")
+	for _, base := range []string{
+		name,
+		strings.TrimSuffix(name, ".tar"),
+		strings.TrimSuffix(name, "-search.tar"),
+		strings.TrimSuffix(name, EntriesSuffix),
+	} {
+		if locked[base] {
+			return true
+		}
+	}
+	return false
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
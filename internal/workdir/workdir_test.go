@@ -0,0 +1,174 @@
+package workdir
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/r0qs/beezim/internal/diskspace"
+	"github.com/r0qs/beezim/internal/filelock"
+)
+
+// statfs returns the free space of the workdir given to Available.
+func statfs(w *Workdir, avail uint64, err error) {
+	w.Available = func(path string) (uint64, error) {
+		if path != w.Dir {
+			return 0, errors.New("statfs of another path " + path)
+		}
+		return avail, err
+	}
+}
+
+func TestCheckSpace(t *testing.T) {
+	w, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	required := EstimateSize(1 << 30)
+	if required != 3<<30 {
+		t.Fatalf("estimated %d for 1 GiB, want 3 GiB", required)
+	}
+
+	statfs(w, 2<<30, nil)
+	err = w.CheckSpace(required)
+	if !errors.Is(err, diskspace.ErrNoSpace) {
+		t.Fatalf("got %v, want %v", err, diskspace.ErrNoSpace)
+	}
+	if want := "not enough disk space in workdir " + w.Dir + ": about 3.0 GiB required, 2.0 GiB available"; err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+
+	statfs(w, 3<<30, nil)
+	if err := w.CheckSpace(required); err != nil {
+		t.Errorf("enough space: %v", err)
+	}
+	// the platforms without statfs always have enough space
+	statfs(w, 0, diskspace.ErrUnsupported)
+	if err := w.CheckSpace(required); err != nil {
+		t.Errorf("unsupported: %v", err)
+	}
+	statfs(w, 0, os.ErrPermission)
+	if err := w.CheckSpace(required); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("got %v, want the error of statfs", err)
+	}
+}
+
+func TestSpaceGuard(t *testing.T) {
+	w, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the space left shrinks as the tar is written
+	left := []uint64{2 << 20, 1 << 20, 512 << 10}
+	w.Available = func(string) (uint64, error) {
+		avail := left[0]
+		left = left[1:]
+		return avail, nil
+	}
+	guard := w.SpaceGuard(1 << 20)
+	for i, want := range []bool{false, false, true} {
+		err := guard()
+		if got := errors.Is(err, diskspace.ErrNoSpace); got != want {
+			t.Errorf("check %d: got %v, want out of space %t", i, err, want)
+		}
+	}
+}
+
+// touch creates the files and directories, ending with a slash, of the workdir.
+func touch(t *testing.T, w *Workdir, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		var err error
+		if dir, ok := strings.CutSuffix(name, "/"); ok {
+			err = os.MkdirAll(filepath.Join(w.Dir, dir), 0o755)
+		} else {
+			err = os.WriteFile(filepath.Join(w.Dir, name), []byte(name), 0o644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRelease(t *testing.T) {
+	for _, tt := range []struct {
+		policy Policy
+		left   []string
+	}{
+		{Policy{}, []string{"other.tar"}},
+		{Policy{KeepTar: true}, []string{"other.tar", "wiki-search.tar", "wiki-volumes", "wiki.tar"}},
+		{Policy{KeepExtracted: true}, []string{"other.tar", "wiki"}},
+	} {
+		w, err := New(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		touch(t, w, "wiki.tar", "wiki-search.tar", "wiki-volumes/", "wiki/", "other.tar")
+		if err := w.Release("wiki.zim", tt.policy); err != nil {
+			t.Fatal(err)
+		}
+		left, err := w.Artifacts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(left, tt.left) {
+			t.Errorf("%+v: left %v, want %v", tt.policy, left, tt.left)
+		}
+	}
+}
+
+func TestClean(t *testing.T) {
+	w, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	touch(t, w,
+		// resumed from its checkpoint
+		"kept.tar", "kept.tar.checkpoint", "kept.tar.journal",
+		// written by another process
+		"locked.tar", "locked.tar.checkpoint", "locked.tar.journal", "locked-search.tar", "locked-entries/", "locked/",
+		"old.tar", "old.tar.checkpoint", "old/", "released.lock",
+	)
+	l, err := filelock.TryLock(w.LockPath("locked.zim"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Unlock()
+	keep := map[string]bool{"kept.tar": true, "kept.tar.checkpoint": true, "kept.tar.journal": true}
+
+	want := []string{filepath.Join(w.Dir, "old"), filepath.Join(w.Dir, "old.tar"), filepath.Join(w.Dir, "old.tar.checkpoint")}
+	removed, err := w.Clean(keep, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(removed, want) {
+		t.Errorf("dry run removed %v, want %v", removed, want)
+	}
+	before, _ := w.Artifacts()
+	if len(before) != 14 {
+		t.Errorf("dry run left %d artifacts, want 14", len(before))
+	}
+
+	removed, err = w.Clean(keep, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(removed, want) {
+		t.Errorf("removed %v, want %v", removed, want)
+	}
+	left, err := w.Artifacts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLeft := []string{
+		"kept.tar", "kept.tar.checkpoint", "kept.tar.journal",
+		"locked", "locked-entries", "locked-search.tar", "locked.lock", "locked.tar", "locked.tar.checkpoint", "locked.tar.journal",
+		"released.lock",
+	}
+	if !slices.Equal(left, wantLeft) {
+		t.Errorf("left %v, want %v", left, wantLeft)
+	}
+}
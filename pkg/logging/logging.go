@@ -0,0 +1,37 @@
+// Package logging defines the logger of beezim, so programs embedding it
+// can route its logs to their own logging setup.
+package logging
+
+import (
+	"io"
+	"log/slog"
+)
+
+// Logger is a leveled logger. The message is followed by alternating keys
+// and values, e.g. logger.Info("zim parsed", "file", name, "articles", n).
+// A *slog.Logger is a Logger.
+type Logger interface {
+	Debug(msg string, kv ...any)
+	Info(msg string, kv ...any)
+	Warn(msg string, kv ...any)
+	Error(msg string, kv ...any)
+}
+
+// Default returns the default slog logger, which writes to the standard
+// log package output (stderr) unless replaced with slog.SetDefault.
+func Default() Logger {
+	return slog.Default()
+}
+
+// Or returns l, or the default logger when l is nil.
+func Or(l Logger) Logger {
+	if l == nil {
+		return Default()
+	}
+	return l
+}
+
+// Discard returns a logger dropping everything.
+func Discard() Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
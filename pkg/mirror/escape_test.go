@@ -0,0 +1,174 @@
+package mirror
+
+import (
+	"context"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/indexer/zimtest"
+	"github.com/r0qs/beezim/internal/tarball"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// escapedArticles are articles whose paths are not valid in a URL as they
+// are, by their content.
+var escapedArticles = map[string]string{
+	"A/Café":                      "<html><body>café</body></html>",
+	"A/C# (programming language)": "<html><body>c sharp</body></html>",
+	"A/100% juice":                "<html><body>juice</body></html>",
+}
+
+// links are the links of the pages, with the URLs of their attributes
+// and of the refresh of the redirect pages.
+var links = regexp.MustCompile(`(?:href|src)="([^"]*)"|url=([^"]*)"`)
+
+// pageLinks returns the links of the page to the articles of the A
+// namespace, resolved against its URL.
+func pageLinks(page *url.URL, body string) []*url.URL {
+	var resolved []*url.URL
+	for _, m := range links.FindAllStringSubmatch(body, -1) {
+		link := html.UnescapeString(m[1] + m[2])
+		ref, err := url.Parse(link)
+		if err != nil || ref.IsAbs() {
+			continue
+		}
+		u := page.ResolveReference(ref)
+		if strings.Contains(u.Path, "/A/") {
+			resolved = append(resolved, u)
+		}
+	}
+	return resolved
+}
+
+func TestRunEscapedLinks(t *testing.T) {
+	entries := []zimtest.Entry{
+		{Namespace: 'A', URL: "Café", Title: "Café", Mime: "text/html", Content: []byte(escapedArticles["A/Café"])},
+		{Namespace: 'A', URL: "C# (programming language)", Title: "C#", Mime: "text/html", Content: []byte(escapedArticles["A/C# (programming language)"])},
+		{Namespace: 'A', URL: "100% juice", Title: "100% juice", Mime: "text/html", Content: []byte(escapedArticles["A/100% juice"])},
+		zimtest.Redirect('A', "CSharp", 1),
+		zimtest.Redirect('A', "Juice #1", 2),
+		{Namespace: 'M', URL: "Title", Mime: "text/plain", Content: []byte("Escapes")},
+	}
+	r := zimtest.New(entries...)
+	r.Main = 0
+	dir := t.TempDir()
+	zimPath := filepath.Join(dir, "escapes_en_all_2022-05.zim")
+	if err := r.WriteFile(zimPath); err != nil {
+		t.Fatal(err)
+	}
+
+	fake, bee := newFakeBee(t)
+	ctx := context.Background()
+	res, err := Run(ctx, Options{
+		ZimPath:      zimPath,
+		WorkDir:      dir,
+		EnableSearch: true,
+		Sitemap:      true,
+		SitemapURL:   "https://example.org",
+		BatchID:      "batch",
+		Bee:          bee,
+		Logger:       logging.Discard(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the names of the tar and of the manifest are those of the ZIM
+	tarPath := filepath.Join(dir, res.TarFile)
+	for p, want := range escapedArticles {
+		data, err := tarball.ReadFile(tarPath, p)
+		if err != nil {
+			t.Errorf("%s: %v", p, err)
+		} else if string(data) != want {
+			t.Errorf("%s: got %q in the tar, want %q", p, data, want)
+		}
+		if fake.uploads(p) != 1 {
+			t.Errorf("%s: not in the manifest", p)
+		}
+	}
+	entriesManifest, err := indexer.ReadEntriesManifest(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := make(map[string]bool)
+	for _, e := range entriesManifest {
+		paths[e.Path] = true
+	}
+	for p := range escapedArticles {
+		if !paths[p] {
+			t.Errorf("%s: not in %s as it is", p, indexer.EntriesFile)
+		}
+	}
+	sitemap, err := tarball.ReadFile(tarPath, "_beezim/sitemap-1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	locs := make(map[string]bool)
+	for _, m := range regexp.MustCompile(`<loc>([^<]*)</loc>`).FindAllStringSubmatch(string(sitemap), -1) {
+		u, err := url.Parse(html.UnescapeString(m[1]))
+		if err != nil {
+			t.Errorf("sitemap url %s: %v", m[1], err)
+			continue
+		}
+		if u.Host == "example.org" && u.RawQuery == "" && u.Fragment == "" {
+			locs[strings.TrimPrefix(u.Path, "/")] = true
+		}
+	}
+	for p := range escapedArticles {
+		if !locs[p] {
+			t.Errorf("%s: not in the sitemap, in %s", p, sitemap)
+		}
+	}
+
+	// the links of the pages lead to the articles through the node
+	root := fake.url.JoinPath("bzz", res.Reference)
+	reached := make(map[string]bool)
+	for _, page := range []string{"index.html", "files.html", "A/CSharp", "A/Juice #1"} {
+		u := root.JoinPath(page)
+		body := get(t, u)
+		found := pageLinks(u, body)
+		if len(found) == 0 {
+			t.Errorf("%s: no link to the articles", page)
+		}
+		for _, link := range found {
+			got := get(t, link)
+			p := strings.TrimPrefix(link.Path, root.Path+"/")
+			if want, ok := escapedArticles[p]; ok {
+				reached[p] = true
+				if got != want {
+					t.Errorf("%s: link %s served %q, want %q", page, link, got, want)
+				}
+			}
+		}
+	}
+	for p := range escapedArticles {
+		if !reached[p] {
+			t.Errorf("%s: linked by none of the pages", p)
+		}
+	}
+}
+
+// get returns the body of the URL, failing the test unless it is served.
+func get(t *testing.T, u *url.URL) string {
+	t.Helper()
+	resp, err := http.Get(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s: %s", u, resp.Status)
+	}
+	return string(body)
+}
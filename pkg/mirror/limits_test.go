@@ -0,0 +1,129 @@
+package mirror
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/r0qs/beezim/internal/beeclient/api"
+	"github.com/r0qs/beezim/internal/limiter"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// TestSharedLimitsTwoWikis mirrors two wikis at the same time with a
+// budget of one for every stage, taking the budgets in the order of the
+// batch mode: the tar writer, the parse workers feeding it, then the
+// bytes and the slot of the upload. Each parse asks for more read workers
+// than the pool holds.
+func TestSharedLimitsTwoWikis(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		name := "tar"
+		if stream {
+			name = "stream"
+		}
+		t.Run(name, func(t *testing.T) {
+			fake, bee := newFakeBee(t)
+			p := New(Options{Bee: bee, Logger: logging.Discard()})
+			limits := &limiter.Limits{
+				ParseWorkers: limiter.NewPool(1),
+				TarWriters:   limiter.NewPool(1),
+				UploadBytes:  limiter.NewPool(1),
+				Uploads:      limiter.NewAdaptive(1, 1),
+			}
+			dir := t.TempDir()
+			upload := api.UploadCollectionOptions{BatchID: "batch", IndexDocumentHeader: "index.html"}
+
+			// mirror runs a wiki as a job of the batch mode does
+			mirror := func(ctx context.Context, wiki string) error {
+				zimPath := writeWiki(t, dir, wiki, []byte("\x89PNG "+wiki))
+				tarPath := filepath.Join(dir, wiki+".tar")
+				n, err := limits.TarWriters.Acquire(ctx, 1)
+				if err != nil {
+					return err
+				}
+				o := TarOptions{EnableSearch: true, Workers: limits.ParseWorkers, ReadWorkers: 4, BatchSize: 1, BatchBuffer: 1}
+				if stream {
+					defer limits.TarWriters.Release(n)
+					_, err := p.StreamUpload(ctx, zimPath, filepath.Base(tarPath), StreamOptions{TarOptions: o, Upload: upload})
+					return err
+				}
+				_, err = p.BuildTar(ctx, zimPath, tarPath, o)
+				limits.TarWriters.Release(n)
+				if err != nil {
+					return err
+				}
+
+				info, err := os.Stat(tarPath)
+				if err != nil {
+					return err
+				}
+				n, err = limits.UploadBytes.Acquire(ctx, info.Size())
+				if err != nil {
+					return err
+				}
+				defer limits.UploadBytes.Release(n)
+				done, err := limits.Uploads.Acquire(ctx)
+				if err != nil {
+					return err
+				}
+				_, err = p.Upload(ctx, tarPath, filepath.Base(tarPath), upload)
+				done(info.Size(), err)
+				return err
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			errs := make(chan error, 2)
+			var wg sync.WaitGroup
+			for _, wiki := range []string{"first", "second"} {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errs <- mirror(ctx, wiki)
+				}()
+			}
+			finished := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(finished)
+			}()
+			select {
+			case <-finished:
+			case <-time.After(30 * time.Second):
+				buf := make([]byte, 1<<20)
+				cancel()
+				t.Fatalf("the wikis are deadlocked on the budgets:\n%s", buf[:runtime.Stack(buf, true)])
+			}
+			close(errs)
+			for err := range errs {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, wiki := range []string{"first", "second"} {
+				if fake.uploads("I/"+wiki+".png") != 1 {
+					t.Errorf("%s: not uploaded", wiki)
+				}
+			}
+
+			// every budget was given back
+			ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			for name, pool := range map[string]*limiter.Pool{"parse workers": limits.ParseWorkers, "tar writers": limits.TarWriters, "upload bytes": limits.UploadBytes} {
+				n, err := pool.Acquire(ctx, pool.Size())
+				if err != nil {
+					t.Errorf("%s still held: %v", name, err)
+					continue
+				}
+				pool.Release(n)
+			}
+			if _, err := limits.Uploads.Acquire(ctx); err != nil {
+				t.Errorf("upload slot still held: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,482 @@
+// Package mirror mirrors ZIM files to Swarm: it parses a ZIM into a tar,
+// uploads the tar as a collection and verifies that the uploaded root is
+// served. It is the pipeline run by the beezim command, usable by other
+// programs without shelling out to it.
+//
+//	bee, err := mirror.NewBee("http://localhost:1633", "http://localhost:1635")
+//	...
+//	res, err := mirror.Run(ctx, mirror.Options{
+//		ZimPath: "wikipedia_es_climate_change_mini_2022-02.zim",
+//		BatchID: batchID,
+//		Bee:     bee,
+//	})
+//
+// The result is returned even when the run fails, recording how far it
+// went: a root can be uploaded but not verified.
+package mirror
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/internal/beeclient"
+	"github.com/r0qs/beezim/internal/beeclient/api"
+	"github.com/r0qs/beezim/internal/iobuf"
+	"github.com/r0qs/beezim/internal/progress"
+	"github.com/r0qs/beezim/internal/random"
+	"github.com/r0qs/beezim/internal/result"
+	"github.com/r0qs/beezim/internal/store"
+	"github.com/r0qs/beezim/internal/tarball"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// Result is the outcome of a run, the document written by the --json
+// flag of the beezim command.
+type Result = result.Result
+
+// Verification is the outcome of checking an uploaded root.
+type Verification = result.Verification
+
+// Reporter receives the progress events of the stages.
+type Reporter = progress.Reporter
+
+// ReporterFunc adapts a function to a Reporter.
+type ReporterFunc = progress.ReporterFunc
+
+// Event is a progress update of a stage.
+type Event = progress.Event
+
+// ProgressReporter reports the progress of one stage at a time, e.g. as a
+// progress bar, see indexer.ProgressReporter.
+type ProgressReporter = progress.Bar
+
+// Bee is a client of the bee node the mirrors are uploaded to.
+type Bee = beeclient.BeeClient
+
+// Store is the local database recording the runs.
+type Store = store.Store
+
+// NewBee returns a client of the node at the api and debug api urls. The
+// debug api is optional.
+func NewBee(apiURL string, debugAPIURL string) (*Bee, error) {
+	opts := beeclient.ClientOptions{}
+	var err error
+	if opts.APIURL, err = url.Parse(apiURL); err != nil {
+		return nil, fmt.Errorf("invalid bee api url: %v", err)
+	}
+	if debugAPIURL != "" {
+		if opts.DebugAPIURL, err = url.Parse(debugAPIURL); err != nil {
+			return nil, fmt.Errorf("invalid bee debug api url: %v", err)
+		}
+	}
+	return beeclient.NewBee(opts)
+}
+
+// OpenStore opens the local database at path, creating it if needed.
+func OpenStore(path string) (*Store, error) {
+	return store.Open(path)
+}
+
+// ErrNotVerified is returned by Run when the uploaded root is not served.
+var ErrNotVerified = errors.New("uploaded root is not available")
+
+// BufferConfig is the sizes in bytes of the I/O buffers of the pipeline,
+// the defaults when zero.
+type BufferConfig = iobuf.Config
+
+var (
+	// ErrTarIncomplete is returned for a tar whose build did not finish.
+	ErrTarIncomplete = tarball.ErrTarIncomplete
+	// ErrRootNotFound is returned when the node does not serve a root, or
+	// a path under it.
+	ErrRootNotFound = beeclient.ErrRootNotFound
+)
+
+// Options configures a run.
+type Options struct {
+	// ZimPath is the ZIM to mirror.
+	ZimPath string
+	// WorkDir receives the tar, the directory of the ZIM by default.
+	WorkDir string
+	// EnableSearch embeds the search engine and DApp.
+	EnableSearch bool
+	// Namespaces, Mimes, MimePlaceholders and MaxArticleSize filter the
+	// entries parsed, Dedup replaces the duplicated ones by aliases and
+	// Collisions keeps one of those at the same path, see TarOptions.
+	Namespaces       indexer.NamespaceFilter
+	Mimes            indexer.MimeFilter
+	MimePlaceholders bool
+	MaxArticleSize   int64
+	Dedup            indexer.Dedup
+	Collisions       indexer.Collisions
+	// MimeOverrides and SniffMime correct the MIME types of the entries,
+	// see TarOptions.
+	MimeOverrides indexer.MimeOverrides
+	SniffMime     bool
+	// Minify minifies the HTML, CSS and JavaScript articles, RelativeLinks
+	// makes their root-absolute links relative, Offline rewrites the HTML
+	// ones for offline browsing and NavBar adds them a navigation bar, see
+	// TarOptions.
+	Minify        bool
+	RelativeLinks bool
+	Offline       indexer.Offline
+	NavBar        bool
+	// MainPage, when set, is the path of the main page, see TarOptions.
+	MainPage string
+	// ListingPageSize is the number of articles of the listing pages, see
+	// TarOptions.
+	ListingPageSize int
+	// DropTitleIndex leaves the title indexes of the ZIM out of the tar,
+	// see TarOptions.
+	DropTitleIndex bool
+	// DropSearchIndexes leaves the X namespace of the ZIM out of the tar,
+	// see TarOptions.
+	DropSearchIndexes bool
+	// Snippets records the start of the text of the HTML articles for the
+	// search results, see TarOptions.
+	Snippets bool
+	// Sitemap adds the sitemap of the HTML articles, under SitemapURL when
+	// set, see TarOptions.
+	Sitemap    bool
+	SitemapURL string
+	// TemplateDir and AssetsDir replace the embedded templates and assets
+	// of the pages, see TarOptions.
+	TemplateDir string
+	AssetsDir   string
+	// ExtraFiles are the local files packed into the tar, see TarOptions.
+	ExtraFiles map[string]string
+	// Language, when set, is the language of the pages, see TarOptions.
+	Language string
+	// Theme is the stylesheet of the pages, see TarOptions.
+	Theme string
+	// ManifestRedirects adds the redirects to the manifest instead of
+	// redirect pages, see TarOptions.
+	ManifestRedirects bool
+	// VerifyZim verifies the checksum of the ZIM before parsing it.
+	VerifyZim bool
+	// GzipLevel keeps the tar gzipped on disk, see TarOptions; Prepare
+	// can not append to it then.
+	GzipLevel int
+	// Force parses the ZIM again even if a tar built from it exists.
+	Force bool
+	// Stream uploads the tar as it is written, without writing it to
+	// disk, see StreamUpload. The tar is always parsed again, Prepare
+	// can not be used and the redirects added to the manifest are not
+	// verified.
+	Stream bool
+	// BatchSize, BatchBuffer, ReadWorkers and CompressBuffered tune the
+	// parse, see TarOptions.
+	BatchSize        int
+	BatchBuffer      int
+	ReadWorkers      int
+	CompressBuffered bool
+	// Buffers are the sizes of the I/O buffers of the parse, the defaults
+	// when zero.
+	Buffers BufferConfig
+	// ReproducibleTime pins the time recorded in the provenance of the
+	// tar, see TarOptions.
+	ReproducibleTime time.Time
+	// EntryStoreDir keeps the entries of the parse on disk, see
+	// TarOptions.
+	EntryStoreDir string
+	// OnEntryError decides whether the parse skips an entry that can not
+	// be read, see TarOptions.
+	OnEntryError func(indexer.EntryError) error
+
+	BatchID string
+	Pin     bool
+	Tag     uint32
+	// ShareAssets serves the assets of the tar already uploaded by the
+	// runs of the Store completed with the batch from their manifest
+	// instead of uploading them again, see Pipeline.UploadShared. A
+	// streamed tar can not share its assets.
+	ShareAssets bool
+
+	// Verify configures the verification of the uploaded root. Ten files
+	// are checked besides the index document when no sample is given.
+	Verify VerifyOptions
+
+	// Bee is the node the tar is uploaded to.
+	Bee *Bee
+	// Store, when set, records the run.
+	Store *Store
+	// Reporter, when set, receives the progress of the stages.
+	Reporter Reporter
+	// Progress, when set without Reporter, receives the progress of one
+	// stage at a time; the parse reports to indexer.DefaultProgress
+	// without either.
+	Progress ProgressReporter
+	// Logger receives the log output, the default logger when nil.
+	Logger logging.Logger
+
+	// Prepare, when set, is called with the tar before it is uploaded,
+	// e.g. to add pages to it.
+	Prepare func(ctx context.Context, tarPath string) error
+}
+
+// Pipeline runs the stages of a mirror with the same node, database,
+// reporter and logger.
+type Pipeline struct {
+	bee   *Bee
+	store *Store
+	rep   Reporter
+	log   logging.Logger
+}
+
+// New returns a pipeline using the node, database, reporter and logger
+// of the options.
+func New(o Options) *Pipeline {
+	rep := o.Reporter
+	if rep == nil && o.Progress != nil {
+		rep = progress.BarReporter(o.Progress)
+	}
+	return &Pipeline{bee: o.Bee, store: o.Store, rep: rep, log: logging.Or(o.Logger)}
+}
+
+// context returns the context carrying the reporter of the pipeline.
+func (p *Pipeline) context(ctx context.Context) context.Context {
+	if p.rep == nil {
+		return ctx
+	}
+	return progress.WithReporter(ctx, p.rep)
+}
+
+// Run mirrors the ZIM: parse and tar, upload, verify. The result records
+// the stages done and, when the run fails, the stage that failed.
+func Run(ctx context.Context, o Options) (*Result, error) {
+	res := result.New("mirror")
+	zimFile := filepath.Base(o.ZimPath)
+	if o.Verify.Seed == 0 {
+		o.Verify.Seed = random.NewSeed()
+	}
+	res.Inputs = result.Inputs{ZimFile: zimFile, EnableSearch: o.EnableSearch, Seed: o.Verify.Seed}
+	if o.Bee == nil {
+		err := errors.New("no bee client")
+		res.Finish(err)
+		return res, err
+	}
+
+	if o.Stream && o.Prepare != nil {
+		err := errors.New("a streamed tar can not be prepared")
+		res.Finish(err)
+		return res, err
+	}
+	if o.Stream && o.ShareAssets {
+		err := errors.New("a streamed tar can not share its assets")
+		res.Finish(err)
+		return res, err
+	}
+
+	p := New(o)
+	if o.Verify.Sample == 0 {
+		o.Verify.Sample = 10
+	}
+	workDir := o.WorkDir
+	if workDir == "" {
+		workDir = filepath.Dir(o.ZimPath)
+	}
+	res.TarFile = strings.TrimSuffix(zimFile, filepath.Ext(zimFile)) + ".tar"
+	tarPath := filepath.Join(workDir, res.TarFile)
+	diskPath := tarPath
+	if o.GzipLevel != 0 {
+		diskPath = tarball.GzipName(tarPath)
+	}
+
+	run := &store.Run{
+		ID:        store.NewRunID(),
+		Command:   "mirror",
+		ZimFile:   zimFile,
+		TarFile:   res.TarFile,
+		Status:    store.StatusRunning,
+		BatchID:   o.BatchID,
+		StartedAt: time.Now().UTC(),
+	}
+	stage := func(name string) {
+		run.Stage = name
+		p.putRun(run)
+	}
+	fail := func(err error) (*Result, error) {
+		res.FailedStage = run.Stage
+		res.Finish(err)
+		run.Status = store.StatusFailed
+		run.Error = err.Error()
+		p.putRun(run)
+		return res, err
+	}
+
+	stage("parse")
+	start := time.Now()
+	fp, err := Fingerprint(o.ZimPath, o.EnableSearch)
+	if err != nil {
+		return fail(err)
+	}
+	tarOpts := TarOptions{
+		EnableSearch:      o.EnableSearch,
+		Fingerprint:       &fp,
+		Namespaces:        o.Namespaces,
+		Mimes:             o.Mimes,
+		MimePlaceholders:  o.MimePlaceholders,
+		MimeOverrides:     o.MimeOverrides,
+		SniffMime:         o.SniffMime,
+		Minify:            o.Minify,
+		Offline:           o.Offline,
+		RelativeLinks:     o.RelativeLinks,
+		NavBar:            o.NavBar,
+		MaxArticleSize:    o.MaxArticleSize,
+		Dedup:             o.Dedup,
+		Collisions:        o.Collisions,
+		MainPage:          o.MainPage,
+		ListingPageSize:   o.ListingPageSize,
+		DropTitleIndex:    o.DropTitleIndex,
+		DropSearchIndexes: o.DropSearchIndexes,
+		Snippets:          o.Snippets,
+		Sitemap:           o.Sitemap,
+		SitemapURL:        o.SitemapURL,
+		TemplateDir:       o.TemplateDir,
+		AssetsDir:         o.AssetsDir,
+		ExtraFiles:        o.ExtraFiles,
+		Language:          o.Language,
+		Theme:             o.Theme,
+		ManifestRedirects: o.ManifestRedirects,
+		VerifyZim:         o.VerifyZim,
+		BatchSize:         o.BatchSize,
+		BatchBuffer:       o.BatchBuffer,
+		ReadWorkers:       o.ReadWorkers,
+		CompressBuffered:  o.CompressBuffered,
+		Buffers:           o.Buffers,
+		ReproducibleTime:  o.ReproducibleTime,
+		OnEntryError:      o.OnEntryError,
+		GzipLevel:         o.GzipLevel,
+		EntryStoreDir:     o.EntryStoreDir,
+	}
+	fp.Filters = tarOpts.Filters()
+	uploadOpts := api.UploadCollectionOptions{
+		Tag:                 o.Tag,
+		Pin:                 o.Pin,
+		BatchID:             o.BatchID,
+		IndexDocumentHeader: "index.html",
+		ErrorDocumentHeader: "error.html",
+	}
+	if o.Stream {
+		return p.runStream(ctx, o, res, run, tarOpts, uploadOpts, stage, fail)
+	}
+
+	reuse := false
+	if !o.Force {
+		if reuse, err = p.ReusableTar(diskPath, fp); err != nil {
+			return fail(err)
+		}
+	}
+	if reuse {
+		p.log.Info("reusing tar built from the same zim and options", "tar", res.TarFile)
+	} else {
+		res.Stats, err = p.BuildTar(ctx, o.ZimPath, tarPath, tarOpts)
+		if err != nil {
+			return fail(err)
+		}
+	}
+	if o.Prepare != nil {
+		if err := o.Prepare(ctx, diskPath); err != nil {
+			return fail(err)
+		}
+	}
+	res.Stage("parse", start)
+
+	stage("upload")
+	start = time.Now()
+	var f *tarball.File
+	if o.ShareAssets {
+		var shared SharedStats
+		f, shared, err = p.UploadShared(ctx, diskPath, res.TarFile, uploadOpts, p.SharedRoots(o.BatchID))
+		if shared.Files > 0 {
+			if res.Stats == nil {
+				res.Stats = &result.Stats{}
+			}
+			res.Stats.SharedAssets, res.Stats.SharedSaved = shared.Files, shared.Saved
+		}
+	} else {
+		f, err = p.Upload(ctx, diskPath, res.TarFile, uploadOpts)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	res.Stage("upload", start)
+	res.Reference = f.Address().String()
+	res.TarHash = hex.EncodeToString(f.Hash())
+	res.BatchID = o.BatchID
+	run.Reference = res.Reference
+	p.log.Info("collection uploaded", "tar", res.TarFile, "reference", res.Reference, "batch", o.BatchID)
+
+	stage("verify")
+	start = time.Now()
+	res.Verification, _, err = p.Verify(ctx, res.Reference, diskPath, o.Verify)
+	if err != nil {
+		return fail(err)
+	}
+	res.Stage("verify", start)
+	if !res.Verification.Verified {
+		return fail(ErrNotVerified)
+	}
+
+	res.Finish(nil)
+	run.Status = store.StatusCompleted
+	p.putRun(run)
+	return res, nil
+}
+
+// runStream runs the parse and the upload of Run as one stage, with
+// StreamUpload, then verifies the files sampled from the stream.
+func (p *Pipeline) runStream(ctx context.Context, o Options, res *Result, run *store.Run, tarOpts TarOptions, uploadOpts api.UploadCollectionOptions, stage func(string), fail func(error) (*Result, error)) (*Result, error) {
+	stage("upload")
+	start := time.Now()
+	s, err := p.StreamUpload(ctx, o.ZimPath, res.TarFile, StreamOptions{
+		TarOptions: tarOpts,
+		Upload:     uploadOpts,
+		Sample:     o.Verify.Sample,
+		Seed:       o.Verify.Seed,
+	})
+	if s != nil {
+		res.Stats = s.Stats
+	}
+	if err != nil {
+		return fail(err)
+	}
+	res.Stage("upload", start)
+	res.Reference = s.File.Address().String()
+	res.TarHash = hex.EncodeToString(s.File.Hash())
+	res.BatchID = o.BatchID
+	run.Reference = res.Reference
+	p.log.Info("collection uploaded", "tar", res.TarFile, "reference", res.Reference, "batch", o.BatchID)
+
+	stage("verify")
+	start = time.Now()
+	res.Verification, _, err = p.VerifyChecks(ctx, res.Reference, s.Checks, o.Verify)
+	if err != nil {
+		return fail(err)
+	}
+	res.Stage("verify", start)
+	if !res.Verification.Verified {
+		return fail(ErrNotVerified)
+	}
+
+	res.Finish(nil)
+	run.Status = store.StatusCompleted
+	p.putRun(run)
+	return res, nil
+}
+
+func (p *Pipeline) putRun(r *store.Run) {
+	if p.store == nil {
+		return
+	}
+	if err := p.store.PutRun(*r); err != nil {
+		p.log.Error("error updating run in the local database", "run", r.ID, "err", err)
+	}
+}
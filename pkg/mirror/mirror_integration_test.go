@@ -0,0 +1,179 @@
+//go:build integration
+
+package mirror_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethersphere/bee/pkg/swarm"
+
+	"github.com/r0qs/beezim/indexer/zimtest"
+	"github.com/r0qs/beezim/internal/beeclient/api"
+	"github.com/r0qs/beezim/pkg/logging"
+	"github.com/r0qs/beezim/pkg/mirror"
+	"github.com/r0qs/beezim/pkg/testenv"
+)
+
+// logo is the payload of the image of the fixture, served as is.
+var logo = []byte("\x89PNG\r\n\x1a\nnot really a png")
+
+// writeFixture writes a small ZIM with a main page, an article, a
+// redirect, an image and metadata, and returns its path.
+func writeFixture(t *testing.T) string {
+	t.Helper()
+	r := zimtest.New(
+		zimtest.Entry{Namespace: 'A', URL: "Foo", Title: "Foo", Mime: "text/html", Content: []byte(`<html><body><p>Foo</p><img src="../I/logo.png"></body></html>`)},
+		zimtest.Redirect('A', "Home", 2),
+		zimtest.Entry{Namespace: 'A', URL: "Main", Title: "Main", Mime: "text/html", Content: []byte(`<html><body><a href="Foo">Foo</a></body></html>`)},
+		zimtest.Entry{Namespace: 'I', URL: "logo.png", Mime: "image/png", Content: logo},
+		zimtest.Entry{Namespace: 'M', URL: "Language", Mime: "text/plain", Content: []byte("eng")},
+		zimtest.Entry{Namespace: 'M', URL: "Title", Mime: "text/plain", Content: []byte("Fixture")},
+	)
+	r.Main = 2
+	zimPath := filepath.Join(t.TempDir(), "fixture_en_all_2022-05.zim")
+	if err := r.WriteFile(zimPath); err != nil {
+		t.Fatal(err)
+	}
+	return zimPath
+}
+
+// tarFiles returns the regular files of the tar by name.
+func tarFiles(t *testing.T, tarPath string) map[string][]byte {
+	t.Helper()
+	f, err := os.Open(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	files := make(map[string][]byte)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = data
+	}
+}
+
+func TestMirrorVerifyRestore(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+	zimPath := writeFixture(t)
+
+	res, err := mirror.Run(ctx, mirror.Options{
+		ZimPath: zimPath,
+		BatchID: env.BatchID,
+		Bee:     env.Bee,
+		Logger:  logging.Discard(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Verification == nil || !res.Verification.Verified {
+		t.Fatalf("mirror not verified: %+v", res.Verification)
+	}
+	root, err := swarm.ParseHexAddress(res.Reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every file of the tar is checked again, not a sample
+	tarPath := filepath.Join(filepath.Dir(zimPath), res.TarFile)
+	files := tarFiles(t, tarPath)
+	p := mirror.New(mirror.Options{Bee: env.Bee, Logger: logging.Discard()})
+	v, _, err := p.Verify(ctx, res.Reference, tarPath, mirror.VerifyOptions{Sample: len(files)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Verified || len(v.Failed) > 0 {
+		t.Fatalf("root %s not verified: %+v", res.Reference, v)
+	}
+
+	// the files restored from the node are those of the tar, and the
+	// image those of the zim
+	for name, want := range files {
+		got, err := env.Bee.DownloadManifestBytes(ctx, root, name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: restored %d bytes differ from the %d of the tar", name, len(got), len(want))
+		}
+	}
+	if got, err := env.Bee.DownloadManifestBytes(ctx, root, "I/logo.png"); err != nil || !bytes.Equal(got, logo) {
+		t.Errorf("I/logo.png: got %q, %v, want %q", got, err, logo)
+	}
+	for _, name := range []string{"A/Foo", "A/Main", "index.html"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("%s not in the tar", name)
+		}
+	}
+}
+
+func TestLocalReferenceMatchesUpload(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+	zimPath := writeFixture(t)
+
+	for _, manifestRedirects := range []bool{false, true} {
+		p := mirror.New(mirror.Options{Bee: env.Bee, Logger: logging.Discard()})
+		tarPath := filepath.Join(t.TempDir(), "fixture.tar")
+		if _, err := p.BuildTar(ctx, zimPath, tarPath, mirror.TarOptions{ManifestRedirects: manifestRedirects}); err != nil {
+			t.Fatal(err)
+		}
+		opts := api.UploadCollectionOptions{
+			BatchID:             env.BatchID,
+			IndexDocumentHeader: "index.html",
+			ErrorDocumentHeader: "error.html",
+		}
+
+		local, err := p.LocalReference(ctx, tarPath, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uploaded, err := p.Upload(ctx, tarPath, filepath.Base(tarPath), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !local.Root.Equal(uploaded.Address()) {
+			t.Errorf("manifest redirects %v: local reference %s, uploaded root %s", manifestRedirects, local.Root, uploaded.Address())
+		}
+		// the reference of every file is that of its bytes on the node
+		files := tarFiles(t, tarPath)
+		for _, f := range local.Files {
+			rc, err := env.Bee.DownloadBytes(ctx, f.Reference)
+			if err != nil {
+				t.Errorf("%s: %v", f.Path, err)
+				continue
+			}
+			got, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Errorf("%s: %v", f.Path, err)
+				continue
+			}
+			if !bytes.Equal(got, files[f.Path]) {
+				t.Errorf("manifest redirects %v: %s: reference %s holds %d bytes, not the %d of the tar", manifestRedirects, f.Path, f.Reference, len(got), len(files[f.Path]))
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// logEvent is a message logged with its keys and values.
+type logEvent struct {
+	level string
+	msg   string
+	kv    map[string]any
+}
+
+// captureLogger is a logging.Logger recording the events logged.
+type captureLogger struct {
+	mu     sync.Mutex
+	events []logEvent
+}
+
+func (l *captureLogger) Debug(msg string, kv ...any) { l.log("debug", msg, kv) }
+func (l *captureLogger) Info(msg string, kv ...any)  { l.log("info", msg, kv) }
+func (l *captureLogger) Warn(msg string, kv ...any)  { l.log("warn", msg, kv) }
+func (l *captureLogger) Error(msg string, kv ...any) { l.log("error", msg, kv) }
+
+func (l *captureLogger) log(level string, msg string, kv []any) {
+	e := logEvent{level: level, msg: msg, kv: make(map[string]any)}
+	for i := 0; i+1 < len(kv); i += 2 {
+		e.kv[fmt.Sprint(kv[i])] = kv[i+1]
+	}
+	if len(kv)%2 != 0 {
+		e.kv["!BADKEY"] = kv[len(kv)-1]
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, e)
+}
+
+// find returns the first event logged with the message.
+func (l *captureLogger) find(msg string) (logEvent, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range l.events {
+		if e.msg == msg {
+			return e, true
+		}
+	}
+	return logEvent{}, false
+}
+
+func TestRunLogs(t *testing.T) {
+	_, bee := newFakeBee(t)
+	log := &captureLogger{}
+	zimPath := writeWiki(t, t.TempDir(), "wiki", []byte("logo"))
+	res, err := Run(context.Background(), Options{
+		ZimPath: zimPath,
+		BatchID: "batch",
+		Bee:     bee,
+		Logger:  log,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		msg   string
+		level string
+		want  map[string]any
+	}{
+		{"parsing zim", "info", map[string]any{"file": filepath.Base(zimPath)}},
+		{"zim parsed", "info", map[string]any{"file": filepath.Base(zimPath), "articles": 4}},
+		{"collection uploaded", "info", map[string]any{"tar": res.TarFile, "reference": res.Reference, "batch": "batch"}},
+	} {
+		e, ok := log.find(tt.msg)
+		if !ok {
+			t.Errorf("%q not logged", tt.msg)
+			continue
+		}
+		if e.level != tt.level {
+			t.Errorf("%q logged at level %s, want %s", tt.msg, e.level, tt.level)
+		}
+		for k, want := range tt.want {
+			if got, ok := e.kv[k]; !ok {
+				t.Errorf("%q logged without %s", tt.msg, k)
+			} else if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("%q logged with %s=%v, want %v", tt.msg, k, got, want)
+			}
+		}
+	}
+	if e, ok := log.find("parsing zim"); ok {
+		for _, k := range []string{"articles", "workers"} {
+			if _, ok := e.kv[k]; !ok {
+				t.Errorf("%q logged without %s", e.msg, k)
+			}
+		}
+	}
+	if e, ok := log.find("zim parsed"); ok {
+		if _, ok := e.kv["elapsed"]; !ok {
+			t.Errorf("%q logged without elapsed", e.msg)
+		}
+	}
+	for _, e := range log.events {
+		if _, ok := e.kv["!BADKEY"]; ok {
+			t.Errorf("%q logged with a key without value", e.msg)
+		}
+		if e.level == "error" {
+			t.Errorf("error logged: %q %v", e.msg, e.kv)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/internal/tarball"
+	"github.com/r0qs/beezim/internal/version"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// buildTar builds the tar of the ZIM with the search and the provenance
+// pinned at pinned, when not zero, and returns its path.
+func buildTar(t *testing.T, zimPath string, pinned time.Time) string {
+	t.Helper()
+	fp, err := Fingerprint(zimPath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarPath := filepath.Join(t.TempDir(), "wiki.tar")
+	p := New(Options{Logger: logging.Discard()})
+	if _, err := p.BuildTar(context.Background(), zimPath, tarPath, TarOptions{
+		EnableSearch:     true,
+		Fingerprint:      &fp,
+		ReproducibleTime: pinned,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return tarPath
+}
+
+func TestProvenanceSchema(t *testing.T) {
+	zimPath := writeWiki(t, t.TempDir(), "wiki", []byte("logo"))
+	tarPath := buildTar(t, zimPath, time.Time{})
+
+	data, err := tarball.ReadFile(tarPath, indexer.ProvenanceFile)
+	if err != nil {
+		t.Fatalf("%s: %v", indexer.ProvenanceFile, err)
+	}
+	// the fields of the schema, read by other tools
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"schemaVersion", "beezim", "zimFile", "zimSHA256", "options", "createdAt"}
+	for _, k := range want {
+		if _, ok := doc[k]; !ok {
+			t.Errorf("no %s in %s", k, data)
+		}
+	}
+	for k := range doc {
+		if !slices.Contains(want, k) {
+			t.Errorf("unexpected %s in %s", k, data)
+		}
+	}
+	if v, ok := doc["schemaVersion"].(float64); !ok || int(v) != indexer.ProvenanceSchemaVersion {
+		t.Errorf("schemaVersion %v, want %d", doc["schemaVersion"], indexer.ProvenanceSchemaVersion)
+	}
+	if b, ok := doc["beezim"].(map[string]any); !ok || b["version"] == nil || b["goVersion"] == nil {
+		t.Errorf("beezim %v, want its version and go version", doc["beezim"])
+	}
+
+	prov, err := indexer.ReadProvenance(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp, err := Fingerprint(zimPath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prov.ZimFile != filepath.Base(zimPath) || prov.ZimSHA256 != fp.ZimSHA256 || prov.Options != fp.Filters {
+		t.Errorf("provenance of %s %s %q, want %s %s %q", prov.ZimFile, prov.ZimSHA256, prov.Options, filepath.Base(zimPath), fp.ZimSHA256, fp.Filters)
+	}
+	if prov.Beezim != version.Get() {
+		t.Errorf("built with %v, want %v", prov.Beezim, version.Get())
+	}
+	if prov.Reproducible || time.Since(prov.CreatedAt) > time.Hour {
+		t.Errorf("created at %v, reproducible %t, want the time of the parse", prov.CreatedAt, prov.Reproducible)
+	}
+
+	about, err := tarball.ReadFile(tarPath, "about.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{prov.ZimFile, prov.ZimSHA256, prov.Beezim.Version} {
+		if !bytes.Contains(about, []byte(s)) {
+			t.Errorf("about page without %q", s)
+		}
+	}
+}
+
+func TestDecodeProvenance(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		doc  string
+		err  string
+	}{
+		{"current", `{"schemaVersion":1,"zimFile":"a.zim","createdAt":"2022-05-01T00:00:00Z"}`, ""},
+		{"unknown fields", `{"schemaVersion":1,"zimFile":"a.zim","future":true}`, ""},
+		{"no schema version", `{"zimFile":"a.zim"}`, "no schema version"},
+		{"newer schema", `{"schemaVersion":2}`, "unsupported schema version 2"},
+		{"not json", `zim`, "error reading provenance"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := indexer.DecodeProvenance([]byte(tt.doc))
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if p.ZimFile != "a.zim" {
+					t.Errorf("zim file %q, want a.zim", p.ZimFile)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestProvenanceReproducible(t *testing.T) {
+	zimPath := writeWiki(t, t.TempDir(), "wiki", []byte("logo"))
+	pinned := time.Unix(1651363200, 0)
+
+	first := buildTar(t, zimPath, pinned)
+	// a second later, the time of the parse would differ
+	time.Sleep(time.Second)
+	second := buildTar(t, zimPath, pinned)
+
+	prov, err := indexer.ReadProvenance(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !prov.Reproducible || !prov.CreatedAt.Equal(pinned) {
+		t.Errorf("created at %v, reproducible %t, want pinned to %v", prov.CreatedAt, prov.Reproducible, pinned)
+	}
+	a, err := os.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Error("two tars of the same zim with the provenance pinned differ")
+	}
+}
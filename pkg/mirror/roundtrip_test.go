@@ -0,0 +1,129 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/r0qs/beezim/indexer/zimtest"
+	"github.com/r0qs/beezim/internal/preview"
+	"github.com/r0qs/beezim/internal/tarball"
+	"github.com/r0qs/beezim/pkg/logging"
+
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// TestMirrorServeRestore mirrors a fixture ZIM, restores the files of the
+// manifest to a directory and serves both the tar and the directory,
+// joining the slash separated names of the tar to the paths of the OS
+// on the way, e.g. on windows.
+func TestMirrorServeRestore(t *testing.T) {
+	r := zimtest.New(
+		zimtest.Entry{Namespace: 'A', URL: "Main", Title: "Main", Mime: "text/html", Content: []byte(`<html><body><a href="Dir/Page">page</a></body></html>`)},
+		zimtest.Entry{Namespace: 'A', URL: "Dir/Page", Title: "Page", Mime: "text/html", Content: []byte(`<html><body><img src="../../I/logo.png"></body></html>`)},
+		zimtest.Entry{Namespace: 'I', URL: "logo.png", Mime: "image/png", Content: []byte("\x89PNG logo")},
+		zimtest.Entry{Namespace: 'M', URL: "Title", Mime: "text/plain", Content: []byte("Loop")},
+	)
+	r.Main = 0
+	dir := t.TempDir()
+	zimPath := filepath.Join(dir, "loop_en_all_2022-05.zim")
+	if err := r.WriteFile(zimPath); err != nil {
+		t.Fatal(err)
+	}
+
+	_, bee := newFakeBee(t)
+	ctx := context.Background()
+	res, err := Run(ctx, Options{
+		ZimPath: zimPath,
+		WorkDir: dir,
+		BatchID: "batch",
+		Bee:     bee,
+		Logger:  logging.Discard(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarPath := filepath.Join(dir, res.TarFile)
+	a, err := tarball.OpenArchive(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	// the files of the manifest restored from the node
+	root, err := swarm.ParseHexAddress(res.Reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := filepath.Join(t.TempDir(), "restored")
+	files := make(map[string][]byte)
+	for _, name := range a.Names() {
+		f, hdr, err := a.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		if files[name], err = io.ReadAll(f); err != nil {
+			t.Fatal(err)
+		}
+		data, err := bee.DownloadManifestBytes(ctx, root, name)
+		if err != nil {
+			t.Fatalf("restoring %s: %v", name, err)
+		}
+		p, err := tarball.LocalPath(restored, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"index.html", "A/Main", "A/Dir/Page", "I/logo.png"} {
+		if _, ok := files[name]; !ok {
+			t.Fatalf("%s: not in the tar", name)
+		}
+	}
+
+	tarSrc, err := preview.NewTarSource(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tarSrc.Close()
+	dirSrc, err := preview.NewDirSource(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dirSrc.Close()
+	for name, src := range map[string]preview.Source{"tar": tarSrc, "restored": dirSrc} {
+		srv := httptest.NewServer(preview.NewHandler(src, preview.Options{
+			IndexDocument: "index.html",
+			ErrorDocument: "error.html",
+			Logger:        logging.Discard(),
+		}))
+		defer srv.Close()
+		u, err := url.Parse(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for p, want := range map[string][]byte{
+			"":           files["index.html"],
+			"A/Main":     files["A/Main"],
+			"A/Dir/Page": files["A/Dir/Page"],
+			"I/logo.png": files["I/logo.png"],
+		} {
+			if got := get(t, u.JoinPath(p)); !bytes.Equal([]byte(got), want) {
+				t.Errorf("%s: /%s served %q, want %q", name, p, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package mirror
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/ethersphere/bee/pkg/swarm"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/internal/beeclient"
+	"github.com/r0qs/beezim/internal/beeclient/api"
+	"github.com/r0qs/beezim/internal/store"
+	"github.com/r0qs/beezim/internal/tarball"
+)
+
+// SharedStats are the files of a tar served from the manifest of another
+// upload by UploadShared instead of being uploaded again.
+type SharedStats struct {
+	// Files is the number of files shared, and Saved their size.
+	Files int
+	Saved int64
+}
+
+// SharedRoots returns the roots of the runs of the database completed
+// with the batch, the most recent first, whose files UploadShared can
+// serve. None without a database.
+func (p *Pipeline) SharedRoots(batchID string) []swarm.Address {
+	if p.store == nil || batchID == "" {
+		return nil
+	}
+	runs := p.store.Runs()
+	slices.SortStableFunc(runs, func(a, b store.Run) int {
+		return b.UpdatedAt.Compare(a.UpdatedAt)
+	})
+	var roots []swarm.Address
+	for _, r := range runs {
+		if r.Status != store.StatusCompleted || r.BatchID != batchID || r.Reference == "" {
+			continue
+		}
+		root, err := swarm.ParseHexAddress(r.Reference)
+		if err != nil || slices.ContainsFunc(roots, root.Equal) {
+			continue
+		}
+		roots = append(roots, root)
+	}
+	return roots
+}
+
+// UploadShared uploads the tar as Upload does, but for its assets that
+// are already files of the manifest of one of the roots, e.g. the style
+// sheets, scripts and icons of the other wikis of a mirror: they are left
+// out of the tar sent to the node, and its manifest serves the file of
+// the first root having them. The roots must have been uploaded with the
+// batch of the options, so that the files shared expire with the tar.
+//
+// The assets are the files of the entries of the tar but the HTML pages,
+// the redirects and the files added by beezim, found in the
+// indexer.EntriesFile of the roots by the SHA-256 of their payload, and
+// shared when their Swarm reference there is that of the file of the
+// tar. A tar without EntriesFile is uploaded as a whole.
+func (p *Pipeline) UploadShared(ctx context.Context, tarPath string, name string, opts api.UploadCollectionOptions, roots []swarm.Address) (*tarball.File, SharedStats, error) {
+	shared, stats, err := p.sharedAssets(ctx, tarPath, roots)
+	if err != nil {
+		return nil, SharedStats{}, err
+	}
+	if len(shared) == 0 {
+		f, err := p.Upload(ctx, tarPath, name, opts)
+		return f, stats, err
+	}
+	p.log.Info("leaving out the assets shared with other uploads", "tar", name, "files", stats.Files, "bytes", stats.Saved)
+
+	skip := make(map[string]bool, len(shared))
+	for _, a := range shared {
+		skip[a.Path] = true
+	}
+	r, err := tarball.FilterTar(tarPath, func(name string) bool {
+		return skip[path.Clean(name)]
+	})
+	if err != nil {
+		return nil, SharedStats{}, err
+	}
+	defer r.Close()
+	tarFile := tarball.NewReaderFile(name, r)
+	if err := p.bee.UploadCollection(p.context(ctx), tarFile, opts); err != nil {
+		return nil, SharedStats{}, err
+	}
+	if err := p.completeManifest(ctx, tarPath, tarFile, shared, opts); err != nil {
+		return nil, SharedStats{}, err
+	}
+	return tarFile, stats, nil
+}
+
+// sharedAssets returns the aliases serving the assets of the tar from the
+// manifests of the roots, see UploadShared.
+func (p *Pipeline) sharedAssets(ctx context.Context, tarPath string, roots []swarm.Address) ([]beeclient.Alias, SharedStats, error) {
+	if len(roots) == 0 {
+		return nil, SharedStats{}, nil
+	}
+	entries, err := indexer.ReadEntriesManifest(tarPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, SharedStats{}, nil
+	}
+	if err != nil {
+		return nil, SharedStats{}, fmt.Errorf("reading the entries of %s: %w", tarPath, err)
+	}
+	// the assets of the tar not found yet, by payload
+	wanted := make(map[string][]indexer.ManifestEntry)
+	for _, e := range entries {
+		if sharedAsset(e) {
+			wanted[e.SHA256] = append(wanted[e.SHA256], e)
+		}
+	}
+	if len(wanted) == 0 {
+		return nil, SharedStats{}, nil
+	}
+
+	archive, err := tarball.OpenArchive(tarPath)
+	if err != nil {
+		return nil, SharedStats{}, err
+	}
+	defer archive.Close()
+
+	var shared []beeclient.Alias
+	var stats SharedStats
+	for _, root := range roots {
+		if len(wanted) == 0 {
+			break
+		}
+		theirs, err := p.rootEntries(ctx, root)
+		if err != nil {
+			p.log.Warn("assets of a root not shared", "root", root, "err", err)
+			continue
+		}
+		var candidates []beeclient.Alias
+		for _, e := range theirs {
+			if !sharedAsset(e) {
+				continue
+			}
+			for _, ours := range wanted[e.SHA256] {
+				candidates = append(candidates, beeclient.Alias{Path: ours.Path, Target: e.Path, Root: root})
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		targets := make([]string, len(candidates))
+		for i, a := range candidates {
+			targets[i] = a.Target
+		}
+		refs, err := p.bee.FileReferences(ctx, root, targets)
+		if err != nil {
+			return nil, SharedStats{}, err
+		}
+		found := make(map[string]bool)
+		for _, a := range candidates {
+			if found[a.Path] {
+				continue
+			}
+			ref, ok := refs[a.Target]
+			if !ok {
+				continue
+			}
+			ours, size, err := fileReference(ctx, archive, a.Path)
+			if err != nil {
+				return nil, SharedStats{}, err
+			}
+			if !ref.Equal(ours) {
+				continue
+			}
+			found[a.Path] = true
+			shared = append(shared, a)
+			stats.Files++
+			stats.Saved += size
+		}
+		for sum, ours := range wanted {
+			ours = slices.DeleteFunc(ours, func(e indexer.ManifestEntry) bool { return found[e.Path] })
+			if len(ours) == 0 {
+				delete(wanted, sum)
+			} else {
+				wanted[sum] = ours
+			}
+		}
+	}
+	slices.SortFunc(shared, func(a, b beeclient.Alias) int {
+		return cmp.Compare(a.Path, b.Path)
+	})
+	return shared, stats, nil
+}
+
+// sharedAsset reports whether the file of the entry can be served from the
+// manifest of another upload.
+func sharedAsset(e indexer.ManifestEntry) bool {
+	return e.SHA256 != "" && !e.Redirect && !e.Extra && baseType(e.MimeType) != "text/html" &&
+		!strings.HasPrefix(e.Path, path.Dir(indexer.EntriesFile)+"/")
+}
+
+// rootEntries returns the entries of the indexer.EntriesFile served under
+// the root.
+func (p *Pipeline) rootEntries(ctx context.Context, root swarm.Address) ([]indexer.ManifestEntry, error) {
+	data, err := p.bee.DownloadManifestBytes(ctx, root, indexer.EntriesFile)
+	if err != nil {
+		return nil, err
+	}
+	var entries []indexer.ManifestEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", indexer.EntriesFile, err)
+	}
+	return entries, nil
+}
+
+// fileReference returns the Swarm reference and the size of the file of
+// the tar at name.
+func fileReference(ctx context.Context, archive *tarball.Archive, name string) (swarm.Address, int64, error) {
+	r, hdr, err := archive.Open(name)
+	if err != nil {
+		return swarm.Address{}, 0, err
+	}
+	ref, err := beeclient.DataReference(ctx, r)
+	if err != nil {
+		return swarm.Address{}, 0, fmt.Errorf("hashing %s: %w", name, err)
+	}
+	return ref, hdr.Size, nil
+}
+
+// addShared adds the files shared with other uploads to the manifest of
+// the uploaded file, see UploadShared.
+func (p *Pipeline) addShared(ctx context.Context, shared []beeclient.Alias, tarFile *tarball.File, opts api.UploadOptions) error {
+	if len(shared) == 0 {
+		return nil
+	}
+	p.log.Info("adding the shared assets to the manifest", "root", tarFile.Address(), "files", len(shared))
+	root, missing, err := p.bee.AddAliases(ctx, tarFile.Address(), shared, opts)
+	if err != nil {
+		return fmt.Errorf("adding the shared assets of %s: %w", tarFile.Name(), err)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("adding the shared assets of %s: %s not found at %s/%s", tarFile.Name(), missing[0].Path, missing[0].Root, missing[0].Target)
+	}
+	tarFile.SetAddress(root)
+	return nil
+}
@@ -0,0 +1,314 @@
+package mirror
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethersphere/bee/pkg/manifest"
+	"github.com/ethersphere/bee/pkg/manifest/mantaray"
+	"github.com/ethersphere/bee/pkg/swarm"
+
+	"github.com/r0qs/beezim/indexer/zimtest"
+	"github.com/r0qs/beezim/internal/beeclient"
+	"github.com/r0qs/beezim/internal/store"
+	"github.com/r0qs/beezim/pkg/logging"
+)
+
+// fakeBee is a node keeping the collections and bytes uploaded in memory,
+// the files at the reference the node gives them, and recording the files
+// of each collection uploaded.
+type fakeBee struct {
+	// url is the API of the node.
+	url         *url.URL
+	mu          sync.Mutex
+	data        map[string][]byte
+	collections [][]string
+}
+
+func newFakeBee(t *testing.T) (*fakeBee, *Bee) {
+	t.Helper()
+	f := &fakeBee{data: make(map[string][]byte)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.url = u
+	bee, err := beeclient.NewBee(beeclient.ClientOptions{APIURL: u})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, bee
+}
+
+func (f *fakeBee) Load(_ context.Context, ref []byte) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.data[string(ref)]
+	if !ok {
+		return nil, mantaray.ErrNotFound
+	}
+	return data, nil
+}
+
+func (f *fakeBee) Save(_ context.Context, data []byte) ([]byte, error) {
+	sum := sha256.Sum256(data)
+	f.put(sum[:], data)
+	return sum[:], nil
+}
+
+func (f *fakeBee) put(ref []byte, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.data[string(ref)] = bytes.Clone(data)
+}
+
+func (f *fakeBee) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var ref swarm.Address
+	var err error
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/bzz":
+		ref, err = f.uploadCollection(ctx, r)
+	case r.Method == http.MethodPost && r.URL.Path == "/bytes":
+		var data []byte
+		if data, err = io.ReadAll(r.Body); err == nil {
+			var sum []byte
+			sum, err = f.Save(ctx, data)
+			ref = swarm.NewAddress(sum)
+		}
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/bytes/"):
+		f.serve(w, ctx, strings.TrimPrefix(r.URL.Path, "/bytes/"), "", false)
+		return
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/bzz/"):
+		root, p, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/bzz/"), "/")
+		f.serve(w, ctx, root, p, true)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, `{"reference":"%s"}`, ref)
+}
+
+// uploadCollection stores the files of the tar of the request and returns
+// the root of their manifest, made as the node does.
+func (f *fakeBee) uploadCollection(ctx context.Context, r *http.Request) (swarm.Address, error) {
+	m, err := manifest.NewDefaultManifest(f, false)
+	if err != nil {
+		return swarm.Address{}, err
+	}
+	var files []string
+	tr := tar.NewReader(r.Body)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return swarm.Address{}, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return swarm.Address{}, err
+		}
+		ref, err := beeclient.DataReference(ctx, bytes.NewReader(data))
+		if err != nil {
+			return swarm.Address{}, err
+		}
+		f.put(ref.Bytes(), data)
+		p := path.Clean(hdr.Name)
+		files = append(files, p)
+		metadata := map[string]string{
+			manifest.EntryMetadataContentTypeKey: mime.TypeByExtension(path.Ext(p)),
+			manifest.EntryMetadataFilenameKey:    path.Base(p),
+		}
+		if err := m.Add(ctx, p, manifest.NewEntry(ref, metadata)); err != nil {
+			return swarm.Address{}, err
+		}
+	}
+	metadata := map[string]string{
+		manifest.WebsiteIndexDocumentSuffixKey: r.Header.Get("Swarm-Index-Document"),
+		manifest.WebsiteErrorDocumentPathKey:   r.Header.Get("Swarm-Error-Document"),
+	}
+	if err := m.Add(ctx, manifest.RootPath, manifest.NewEntry(swarm.ZeroAddress, metadata)); err != nil {
+		return swarm.Address{}, err
+	}
+	f.mu.Lock()
+	f.collections = append(f.collections, files)
+	f.mu.Unlock()
+	return m.Store(ctx)
+}
+
+// serve writes the bytes at the reference, or the file at p of the
+// manifest at the reference, its index document when p is empty.
+func (f *fakeBee) serve(w http.ResponseWriter, ctx context.Context, reference string, p string, inManifest bool) {
+	ref, err := hex.DecodeString(reference)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if inManifest {
+		root := mantaray.NewNodeRef(ref)
+		if p == "" {
+			n, err := root.LookupNode(ctx, []byte(manifest.RootPath), f)
+			if err != nil {
+				http.NotFound(w, nil)
+				return
+			}
+			p = n.Metadata()[manifest.WebsiteIndexDocumentSuffixKey]
+		}
+		n, err := root.LookupNode(ctx, []byte(p), f)
+		if err != nil || !n.IsValueType() {
+			http.NotFound(w, nil)
+			return
+		}
+		ref = n.Entry()
+	}
+	data, err := f.Load(ctx, ref)
+	if err != nil {
+		http.NotFound(w, nil)
+		return
+	}
+	w.Write(data)
+}
+
+// uploads returns the number of collections uploaded with the file at p.
+func (f *fakeBee) uploads(p string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, files := range f.collections {
+		if slices.Contains(files, p) {
+			n++
+		}
+	}
+	return n
+}
+
+// writeWiki writes a small ZIM with its own page and the logo shared by
+// the wikis, and returns its path.
+func writeWiki(t *testing.T, dir string, name string, logo []byte) string {
+	t.Helper()
+	r := zimtest.New(
+		zimtest.Entry{Namespace: 'A', URL: "Main", Title: name, Mime: "text/html", Content: []byte(`<html><body><img src="../I/logo.png">` + name + `</body></html>`)},
+		zimtest.Entry{Namespace: 'I', URL: "logo.png", Mime: "image/png", Content: logo},
+		zimtest.Entry{Namespace: 'I', URL: name + ".png", Mime: "image/png", Content: []byte(name)},
+		zimtest.Entry{Namespace: 'M', URL: "Title", Mime: "text/plain", Content: []byte(name)},
+	)
+	r.Main = 0
+	zimPath := filepath.Join(dir, name+"_en_all_2022-05.zim")
+	if err := r.WriteFile(zimPath); err != nil {
+		t.Fatal(err)
+	}
+	return zimPath
+}
+
+func TestRunShareAssets(t *testing.T) {
+	fake, bee := newFakeBee(t)
+	db, err := store.Open(filepath.Join(t.TempDir(), "beezim.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	dir := t.TempDir()
+	logo := bytes.Repeat([]byte("\x89PNG shared logo "), 500)
+
+	var results []*Result
+	for _, wiki := range []string{"first", "second"} {
+		res, err := Run(ctx, Options{
+			ZimPath:     writeWiki(t, dir, wiki, logo),
+			BatchID:     "batch",
+			Bee:         bee,
+			Store:       db,
+			Logger:      logging.Discard(),
+			ShareAssets: true,
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", wiki, err)
+		}
+		results = append(results, res)
+	}
+
+	if n := fake.uploads("I/logo.png"); n != 1 {
+		t.Errorf("logo uploaded %d times, want once", n)
+	}
+	if n := fake.uploads("I/second.png"); n != 1 {
+		t.Errorf("asset of the second wiki uploaded %d times, want once", n)
+	}
+	if s := results[0].Stats; s.SharedAssets != 0 || s.SharedSaved != 0 {
+		t.Errorf("first wiki shared %d files, %d bytes, want none", s.SharedAssets, s.SharedSaved)
+	}
+	if s := results[1].Stats; s.SharedAssets != 1 || s.SharedSaved != int64(len(logo)) {
+		t.Errorf("second wiki shared %d files, %d bytes, want 1 file, %d bytes", s.SharedAssets, s.SharedSaved, len(logo))
+	}
+
+	root, err := swarm.ParseHexAddress(results[1].Reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p, want := range map[string][]byte{"I/logo.png": logo, "I/second.png": []byte("second")} {
+		got, err := bee.DownloadManifestBytes(ctx, root, p)
+		if err != nil {
+			t.Errorf("%s: %v", p, err)
+		} else if !bytes.Equal(got, want) {
+			t.Errorf("%s: served %q, want %q", p, got, want)
+		}
+	}
+}
+
+func TestRunShareAssetsOtherBatch(t *testing.T) {
+	fake, bee := newFakeBee(t)
+	db, err := store.Open(filepath.Join(t.TempDir(), "beezim.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	logo := []byte("\x89PNG shared logo")
+
+	for _, wiki := range []string{"first", "second"} {
+		res, err := Run(context.Background(), Options{
+			ZimPath:     writeWiki(t, dir, wiki, logo),
+			BatchID:     "batch-" + wiki,
+			Bee:         bee,
+			Store:       db,
+			Logger:      logging.Discard(),
+			ShareAssets: true,
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", wiki, err)
+		}
+		if res.Stats.SharedAssets != 0 {
+			t.Errorf("%s: shared %d files with another batch", wiki, res.Stats.SharedAssets)
+		}
+	}
+	if n := fake.uploads("I/logo.png"); n != 2 {
+		t.Errorf("logo uploaded %d times with two batches, want twice", n)
+	}
+}
@@ -0,0 +1,983 @@
+package mirror
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/internal/beeclient"
+	"github.com/r0qs/beezim/internal/beeclient/api"
+	"github.com/r0qs/beezim/internal/gateway"
+	"github.com/r0qs/beezim/internal/limiter"
+	"github.com/r0qs/beezim/internal/random"
+	"github.com/r0qs/beezim/internal/result"
+	"github.com/r0qs/beezim/internal/tarball"
+
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// ErrTarMismatch is returned by ReusableTar for a tar built from another
+// ZIM or with other options.
+var ErrTarMismatch = errors.New("tar was built from another zim or with other options")
+
+// Fingerprint returns the fingerprint of the tar built from the ZIM.
+// The filters describe the options changing the content of the tar.
+func Fingerprint(zimPath string, enableSearch bool) (indexer.Fingerprint, error) {
+	f, err := indexer.OpenZimFile(zimPath)
+	if err != nil {
+		return indexer.Fingerprint{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, f.Size())); err != nil {
+		return indexer.Fingerprint{}, err
+	}
+	return indexer.Fingerprint{
+		ZimSHA256: hex.EncodeToString(h.Sum(nil)),
+		Filters:   fmt.Sprintf("enable-search=%t", enableSearch),
+	}, nil
+}
+
+// Filters returns the options changing the content of the tar, in the
+// canonical form recorded as the Filters of its fingerprint: two tars of
+// the same ZIM with the same Filters are the same.
+func (o TarOptions) Filters() string {
+	filters := []string{fmt.Sprintf("enable-search=%t", o.EnableSearch)}
+	add := func(format string, args ...interface{}) {
+		filters = append(filters, fmt.Sprintf(format, args...))
+	}
+	if o.SearchTarPath != "" {
+		add("split-search=true")
+	}
+	if !o.Namespaces.IsZero() {
+		add("%s", o.Namespaces)
+	}
+	if !o.Mimes.IsZero() {
+		add("%s", o.Mimes)
+		if o.MimePlaceholders {
+			add("mime-placeholders=true")
+		}
+	}
+	if len(o.MimeOverrides) > 0 {
+		add("%s", o.MimeOverrides)
+	}
+	if o.SniffMime {
+		add("sniff-mime=true")
+	}
+	if o.MaxArticleSize > 0 {
+		add("max-article-size=%d", o.MaxArticleSize)
+	}
+	if o.ManifestRedirects {
+		add("manifest-redirects=true")
+	}
+	if o.Minify {
+		add("minify=true")
+	}
+	if o.RelativeLinks {
+		add("relative-links=true")
+	}
+	if o.NavBar {
+		add("nav-bar=true")
+	}
+	if o.HistoryPage {
+		add("history=true")
+	}
+	if o.Offline != indexer.OfflineKeep {
+		add("offline=%s", o.Offline)
+	}
+	if o.Dedup != indexer.DedupOff {
+		add("dedup=%s", o.Dedup)
+	}
+	if o.Collisions != indexer.CollisionsKeepLast {
+		add("collisions=%s", o.Collisions)
+	}
+	if o.MainPage != "" {
+		add("main-page=%s", o.MainPage)
+	}
+	if o.DiffFrom != "" {
+		add("diff-from=%s", o.DiffFrom)
+	}
+	if o.DropTitleIndex {
+		add("drop-title-index=true")
+	}
+	if o.DropSearchIndexes {
+		add("drop-search-indexes=true")
+	}
+	if o.Snippets {
+		add("snippets=true")
+	}
+	if o.Sitemap {
+		add("sitemap=true")
+		if o.SitemapURL != "" {
+			add("sitemap-url=%s", o.SitemapURL)
+		}
+	}
+	if o.TemplateDir != "" {
+		add("template-dir=%s", o.TemplateDir)
+	}
+	if o.AssetsDir != "" {
+		add("assets-dir=%s", o.AssetsDir)
+	}
+	for _, name := range slices.Sorted(maps.Keys(o.ExtraFiles)) {
+		add("extra-file=%s=%s", name, o.ExtraFiles[name])
+	}
+	if o.Language != "" {
+		add("language=%s", o.Language)
+	}
+	if o.Theme != "" {
+		add("theme=%s", o.Theme)
+	}
+	if n := o.ListingPageSize; n > 0 && n != indexer.DefaultListingPageSize {
+		add("listing-page-size=%d", n)
+	}
+	return strings.Join(filters, " ")
+}
+
+// ReusableTar reports whether the tar left by a previous run was
+// completely built with the fingerprint, so it can be uploaded without
+// parsing the ZIM again.
+func (p *Pipeline) ReusableTar(tarPath string, fp indexer.Fingerprint) (bool, error) {
+	if _, err := os.Stat(tarPath); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	name := filepath.Base(tarPath)
+	got, err := indexer.ReadFingerprint(tarPath)
+	if errors.Is(err, indexer.ErrNoFingerprint) {
+		p.log.Info("tar has no fingerprint, parsing the zim again", "tar", name)
+		return false, nil
+	}
+	if err != nil {
+		p.log.Warn("error reading tar fingerprint, parsing the zim again", "tar", name, "err", err)
+		return false, nil
+	}
+	if got != fp {
+		return false, fmt.Errorf("%s: %w (%v, expected %v)", name, ErrTarMismatch, got, fp)
+	}
+
+	if err := CheckTar(tarPath); errors.Is(err, ErrTarIncomplete) {
+		p.log.Info("tar is incomplete, parsing the zim again", "tar", name)
+		return false, nil
+	} else if err != nil {
+		p.log.Warn("error opening tar, parsing the zim again", "tar", name, "err", err)
+		return false, nil
+	}
+	return true, nil
+}
+
+// CheckTar returns an error matching ErrTarIncomplete when the tar was
+// not completely built.
+func CheckTar(tarPath string) error {
+	a, err := tarball.OpenArchive(tarPath)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	// error.html is the last file added to the tar
+	if _, ok := a.Entry("error.html"); !ok {
+		return fmt.Errorf("%s: %w", filepath.Base(tarPath), ErrTarIncomplete)
+	}
+	return nil
+}
+
+// TarOptions configures the tar built from a ZIM.
+type TarOptions struct {
+	// EnableSearch embeds the search engine and DApp.
+	EnableSearch bool
+	// Fingerprint, when set, is recorded in the tar.
+	Fingerprint *indexer.Fingerprint
+	// Namespaces restricts the namespaces of the entries parsed, see
+	// indexer.SwarmZimIndexer.SetNamespaceFilter.
+	Namespaces indexer.NamespaceFilter
+	// Mimes restricts the MIME types of the articles parsed, and
+	// MimePlaceholders replaces those left out by placeholders, see
+	// indexer.SwarmZimIndexer.SetMimeFilter.
+	Mimes            indexer.MimeFilter
+	MimePlaceholders bool
+	// MimeOverrides corrects the MIME types of the entries, and SniffMime
+	// guesses the unknown ones, see
+	// indexer.SwarmZimIndexer.SetMimeOverrides.
+	MimeOverrides indexer.MimeOverrides
+	SniffMime     bool
+	// Minify minifies the HTML, CSS and JavaScript articles, see
+	// indexer.SwarmZimIndexer.Minify.
+	Minify bool
+	// RelativeLinks makes the root-absolute links of the HTML and CSS
+	// articles relative, see indexer.SwarmZimIndexer.RelativeLinks.
+	RelativeLinks bool
+	// NavBar adds a navigation bar to the HTML articles, see
+	// indexer.SwarmZimIndexer.NavBar.
+	NavBar bool
+	// Offline rewrites the HTML articles so that browsing them sends no
+	// request out of the archive, see indexer.SwarmZimIndexer.Offline.
+	Offline indexer.Offline
+	// HistoryPage links the index pages to the history page appended to
+	// the tar, see indexer.SwarmZimIndexer.HistoryPage.
+	HistoryPage bool
+	// Previous are the entries of the tar of the previous version of the
+	// ZIM, the tar then only getting the articles added or changed since,
+	// see indexer.SwarmZimIndexer.Previous.
+	Previous []indexer.ManifestEntry
+	// DiffFrom names the tar Previous was read from, recorded in the
+	// fingerprint of the tar instead of its entries.
+	DiffFrom string
+	// MaxArticleSize is the size in bytes above which the articles are
+	// left out and listed in a skipped.html page, no limit when zero.
+	MaxArticleSize int64
+	// ManifestRedirects leaves the redirects out of the tar, for Upload
+	// to add them to the manifest, see
+	// indexer.SwarmZimIndexer.ManifestRedirects.
+	ManifestRedirects bool
+	// MainPage, when set, is the path of the entry taken for the main page
+	// of the ZIM, see indexer.SwarmZimIndexer.SelectMainPage.
+	MainPage string
+	// ListingPageSize is the number of articles of the pages listing them
+	// from A to Z, see indexer.SwarmZimIndexer.ListingPageSize.
+	ListingPageSize int
+	// DropTitleIndex leaves the title indexes of the ZIM out of the tar,
+	// see indexer.SwarmZimIndexer.DropTitleIndex.
+	DropTitleIndex bool
+	// DropSearchIndexes leaves the X namespace of the ZIM out of the tar,
+	// see indexer.SwarmZimIndexer.DropSearchIndexes.
+	DropSearchIndexes bool
+	// Snippets records the start of the text of the HTML articles for the
+	// search results, see indexer.SwarmZimIndexer.Snippets.
+	Snippets bool
+	// Sitemap adds the sitemap of the HTML articles to the tar, with the
+	// URLs under SitemapURL when set, see indexer.SwarmZimIndexer.Sitemap.
+	Sitemap    bool
+	SitemapURL string
+	// TemplateDir and AssetsDir, when set, are the directories of the
+	// templates and assets of the pages replacing the embedded ones, see
+	// indexer.WithTemplateDir and indexer.WithAssetsDir.
+	TemplateDir string
+	AssetsDir   string
+	// ExtraFiles are the local files and directories packed into the tar
+	// by their path in it, see indexer.SwarmZimIndexer.AddExtraFiles.
+	ExtraFiles map[string]string
+	// Language, when set, is the language of the pages, see
+	// indexer.SwarmZimIndexer.Language.
+	Language string
+	// Theme, when set, is the stylesheet of the pages, an embedded one or
+	// the path of a CSS file, see indexer.SwarmZimIndexer.SetTheme.
+	Theme string
+	// Dedup replaces the articles identical to one parsed before by
+	// aliases, see indexer.SwarmZimIndexer.Dedup.
+	Dedup indexer.Dedup
+	// Collisions is which of the entries at the same path is kept, see
+	// indexer.SwarmZimIndexer.Collisions.
+	Collisions indexer.Collisions
+	// VerifyZim verifies the checksum of the ZIM before parsing it, see
+	// indexer.VerifyChecksum.
+	VerifyZim bool
+	// SearchTarPath, when set, receives the search index apart.
+	SearchTarPath string
+	// GzipLevel, when not zero, gzips the tar at this level of
+	// compress/gzip, written to the tarball.GzipName of its path, see
+	// indexer.SwarmZimIndexer.GzipLevel. Upload and Verify read it as a
+	// plain tar, StreamUpload can not send it.
+	GzipLevel int
+	// Workers, when set, is the budget of parse workers shared with
+	// other runs.
+	Workers *limiter.Pool
+	// BatchSize and BatchBuffer are the number of articles sent together
+	// to the tar writer and the number of batches parsed ahead of it, the
+	// indexer defaults when zero.
+	BatchSize   int
+	BatchBuffer int
+	// ReadWorkers is the number of workers reading the ZIM at the same
+	// time, acquired from Workers when set, one when zero.
+	ReadWorkers int
+	// CompressBuffered compresses the text articles waiting for the tar
+	// writer, saving memory when writing is slower than parsing.
+	CompressBuffered bool
+	// Buffers are the sizes of the buffers of the tars written, the
+	// defaults when zero.
+	Buffers BufferConfig
+	// SpaceCheck, when set, is called while the tar is written, which
+	// stops with its error.
+	SpaceCheck func() error
+	// Reader, when set, is the ZIM already opened by the caller, e.g. with
+	// indexer.NewReader, read instead of opening zimPath again and left
+	// open.
+	Reader indexer.ZimReader
+	// PanicBudget is the number of entries making the ZIM reader panic
+	// that are skipped before the parse fails.
+	PanicBudget int
+	// OnEntryError, when set, decides whether the parse skips an entry
+	// that can not be read, see indexer.SwarmZimIndexer.
+	OnEntryError func(indexer.EntryError) error
+	// ReproducibleTime, when set, is the time recorded in the provenance
+	// of the tar instead of the time of the parse, so that the same ZIM,
+	// options and beezim build give the same tar.
+	ReproducibleTime time.Time
+	// EntryStoreDir, when set, keeps the entries of the parse in an
+	// indexer.DiskStore of the directory instead of memory, for the ZIMs
+	// of millions of entries.
+	EntryStoreDir string
+	// CheckpointEvery, when not zero, records a checkpoint of the tar
+	// every CheckpointEvery articles, and Resume makes BuildTar resume
+	// the tar from its last one, see indexer.SwarmZimIndexer.Resume.
+	CheckpointEvery int
+	Resume          bool
+}
+
+// mimeStats returns the stats of the articles left out by the MIME
+// filter of a parse, nil when none was.
+func mimeStats(filtered map[string]indexer.MimeStats) map[string]result.MimeStats {
+	if len(filtered) == 0 {
+		return nil
+	}
+	stats := make(map[string]result.MimeStats, len(filtered))
+	for t, s := range filtered {
+		stats[t] = result.MimeStats(s)
+	}
+	return stats
+}
+
+// BuildTar parses the ZIM and writes the tar ready to be uploaded, with
+// its pages, in one pass.
+func (p *Pipeline) BuildTar(ctx context.Context, zimPath string, tarPath string, o TarOptions) (*result.Stats, error) {
+	sidx, err := p.newIndexer(ctx, zimPath, o)
+	if err != nil {
+		return nil, err
+	}
+	defer sidx.Close()
+
+	// stop parsing if building the tar fails
+	ctx, cancel := context.WithCancel(p.context(ctx))
+	defer cancel()
+
+	stats := newStats(sidx, zimPath)
+	defer recordStats(stats, sidx)
+
+	if o.Resume {
+		if _, err := sidx.Resume(tarPath); err != nil {
+			return stats, err
+		}
+	}
+	zimArticles := sidx.ParseZIMBatches(ctx)
+	if err := sidx.WriteTar(ctx, tarPath, zimArticles); err != nil {
+		return stats, err
+	}
+	if o.GzipLevel != 0 {
+		tarPath = tarball.GzipName(tarPath)
+	}
+	if info, err := os.Stat(tarPath); err == nil {
+		stats.TarSize = info.Size()
+	}
+	return stats, nil
+}
+
+// newIndexer returns the indexer of the ZIM configured with the options,
+// once the ZIM is verified when asked.
+func (p *Pipeline) newIndexer(ctx context.Context, zimPath string, o TarOptions) (*indexer.SwarmZimIndexer, error) {
+	if err := o.Buffers.Validate(); err != nil {
+		return nil, err
+	}
+	if o.VerifyZim {
+		if err := indexer.VerifyChecksum(p.context(ctx), zimPath); err != nil {
+			return nil, err
+		}
+	}
+	opts := []indexer.Option{indexer.WithTemplateDir(o.TemplateDir), indexer.WithAssetsDir(o.AssetsDir)}
+	if o.EntryStoreDir != "" {
+		opts = append(opts, indexer.WithEntryStore(indexer.DiskStore(o.EntryStoreDir)))
+	}
+	var sidx *indexer.SwarmZimIndexer
+	if o.Reader != nil {
+		sidx = indexer.NewWithReader(zimPath, o.Reader, o.EnableSearch, opts...)
+		if err := sidx.ThemeErr(); err != nil {
+			sidx.Close()
+			return nil, err
+		}
+	} else {
+		var err error
+		sidx, err = indexer.New(zimPath, o.EnableSearch, opts...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sidx.Fingerprint = o.Fingerprint
+	sidx.SetNamespaceFilter(o.Namespaces.Include, o.Namespaces.Exclude)
+	if err := sidx.SetMimeFilter(o.Mimes.Allowed, o.Mimes.Blocked); err != nil {
+		sidx.Close()
+		return nil, err
+	}
+	if err := sidx.SetMimeOverrides(o.MimeOverrides); err != nil {
+		sidx.Close()
+		return nil, err
+	}
+	sidx.SniffMime = o.SniffMime
+	sidx.MimePlaceholders = o.MimePlaceholders
+	sidx.Minify = o.Minify
+	sidx.RelativeLinks = o.RelativeLinks
+	sidx.Offline = o.Offline
+	sidx.NavBar = o.NavBar
+	sidx.HistoryPage = o.HistoryPage
+	sidx.Previous = o.Previous
+	sidx.MaxArticleSize = o.MaxArticleSize
+	sidx.Dedup = o.Dedup
+	sidx.Collisions = o.Collisions
+	sidx.MainPage = o.MainPage
+	sidx.ListingPageSize = o.ListingPageSize
+	sidx.DropTitleIndex = o.DropTitleIndex
+	sidx.DropSearchIndexes = o.DropSearchIndexes
+	sidx.Snippets = o.Snippets
+	sidx.Sitemap = o.Sitemap
+	sidx.SitemapURL = o.SitemapURL
+	sidx.Language = o.Language
+	if err := sidx.SetTheme(o.Theme); err != nil {
+		sidx.Close()
+		return nil, err
+	}
+	sidx.ManifestRedirects = o.ManifestRedirects
+	if err := sidx.AddExtraFiles(o.ExtraFiles); err != nil {
+		sidx.Close()
+		return nil, err
+	}
+	prov := indexer.NewProvenance(zimPath, o.Fingerprint, o.ReproducibleTime)
+	sidx.Provenance = &prov
+	sidx.SearchTarFile = o.SearchTarPath
+	sidx.GzipLevel = o.GzipLevel
+	sidx.Workers = o.Workers
+	sidx.BatchSize = o.BatchSize
+	sidx.BatchBuffer = o.BatchBuffer
+	sidx.ReadWorkers = o.ReadWorkers
+	sidx.CompressBuffered = o.CompressBuffered
+	sidx.Buffers = o.Buffers
+	sidx.SpaceCheck = o.SpaceCheck
+	sidx.PanicBudget = o.PanicBudget
+	sidx.OnEntryError = o.OnEntryError
+	sidx.CheckpointEvery = o.CheckpointEvery
+	sidx.Logger = p.log
+	return sidx, nil
+}
+
+// newStats returns the stats of the parse of the ZIM by the indexer, see
+// recordStats.
+func newStats(sidx *indexer.SwarmZimIndexer, zimPath string) *result.Stats {
+	stats := &result.Stats{Articles: int(sidx.Z.ArticleCount())}
+	if size, err := indexer.ZimSize(zimPath); err == nil {
+		stats.ZimSize = size
+	}
+	return stats
+}
+
+// recordStats records in the stats the entries of the parse.
+func recordStats(stats *result.Stats, sidx *indexer.SwarmZimIndexer) {
+	stats.Entries = sidx.EntryCount()
+	stats.Panics = len(sidx.Panics())
+	stats.Skipped = len(sidx.Skipped())
+	stats.MimeFiltered = mimeStats(sidx.MimeFiltered())
+	stats.TooLarge = len(sidx.TooLarge())
+	d := sidx.DedupStats()
+	stats.Duplicates, stats.DedupSaved = d.Duplicates, d.Saved
+	m := sidx.MinifyStats()
+	stats.Minified, stats.MinifySaved, stats.MinifyInvalid = m.Minified, m.Saved, m.Invalid
+	r := sidx.RelativeLinksStats()
+	stats.RelativeArticles, stats.RelativeLinks = r.Articles, r.Links
+	o := sidx.OfflineStats()
+	stats.OfflineRewritten, stats.OfflineLinks, stats.OfflineResources = o.Articles, o.Links, o.Resources
+	x := sidx.SearchIndexStats()
+	stats.SearchIndexes, stats.SearchIndexBytes = x.Entries, x.Bytes
+	f := sidx.MimeFixes()
+	stats.MimeOverridden, stats.MimeSniffed = f.Overridden, f.Sniffed
+	if sidx.Previous != nil {
+		diff := sidx.Diff()
+		stats.DiffAdded, stats.DiffChanged, stats.DiffRemoved, stats.DiffUnchanged = len(diff.Added), len(diff.Changed), len(diff.Removed), diff.Unchanged
+	}
+	stats.Collisions = len(sidx.PathCollisions())
+	for _, f := range sidx.ExtraFiles() {
+		stats.ExtraFiles++
+		stats.ExtraBytes += f.Size
+	}
+}
+
+// Upload uploads the tar as a collection named name. The files of the
+// manifest are then given the types of the entries of the tar the node
+// does not tell from their extension, see contentTypes, and the redirects
+// left out of a tar built with ManifestRedirects are added to it; its
+// root is the address of the file returned.
+func (p *Pipeline) Upload(ctx context.Context, tarPath string, name string, opts api.UploadCollectionOptions) (*tarball.File, error) {
+	r, size, err := tarball.StreamTar(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	tarFile := tarball.NewSizedReaderFile(name, r, size)
+	if err := p.bee.UploadCollection(p.context(ctx), tarFile, opts); err != nil {
+		return nil, err
+	}
+	if err := p.completeManifest(ctx, tarPath, tarFile, nil, opts); err != nil {
+		return nil, err
+	}
+	return tarFile, nil
+}
+
+// completeManifest adds the shared files to the manifest of the tar
+// uploaded, see UploadShared, then gives its files the types of the
+// entries of the tar and adds the redirects left out of it.
+func (p *Pipeline) completeManifest(ctx context.Context, tarPath string, tarFile *tarball.File, shared []beeclient.Alias, opts api.UploadCollectionOptions) error {
+	types, err := contentTypes(tarPath)
+	if err != nil {
+		return err
+	}
+	redirects, err := indexer.ReadRedirects(tarPath)
+	if err != nil {
+		return err
+	}
+	uploadOpts := api.UploadOptions{Pin: opts.Pin, Tag: opts.Tag, BatchID: opts.BatchID}
+	if err := p.addShared(ctx, shared, tarFile, uploadOpts); err != nil {
+		return err
+	}
+	if err := p.setContentTypes(ctx, types, tarFile, uploadOpts); err != nil {
+		return err
+	}
+	return p.addRedirects(ctx, redirects, tarFile, uploadOpts)
+}
+
+// LocalReference returns the root the node would return for the Upload
+// of the tar with the options, and the references of its files, computed
+// without uploading it, see beeclient.LocalReference.
+func (p *Pipeline) LocalReference(ctx context.Context, tarPath string, opts api.UploadCollectionOptions) (*beeclient.LocalCollection, error) {
+	types, err := contentTypes(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	redirects, err := indexer.ReadRedirects(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	aliases := make([]beeclient.Alias, len(redirects))
+	for i, r := range redirects {
+		aliases[i] = beeclient.Alias{Path: r.Path, Target: r.Target}
+	}
+	c, err := beeclient.LocalReference(ctx, tarPath, opts, types, aliases)
+	if err != nil {
+		return nil, fmt.Errorf("computing the reference of %s: %w", filepath.Base(tarPath), err)
+	}
+	if len(c.Missing) > 0 {
+		p.log.Warn("redirects to files not in the manifest left out", "redirects", len(c.Missing), "first", c.Missing[0].Path, "target", c.Missing[0].Target)
+	}
+	return c, nil
+}
+
+// BuildVolumes parses the ZIM and writes its tar to volumes of dir of at
+// most maxVolumeSize bytes, see indexer.SwarmZimIndexer.TarZimSplit. The
+// stats count the size of all the volumes.
+func (p *Pipeline) BuildVolumes(ctx context.Context, zimPath string, dir string, maxVolumeSize int64, o TarOptions) (*result.Stats, error) {
+	sidx, err := p.newIndexer(ctx, zimPath, o)
+	if err != nil {
+		return nil, err
+	}
+	defer sidx.Close()
+
+	// stop parsing if building the volumes fails
+	ctx, cancel := context.WithCancel(p.context(ctx))
+	defer cancel()
+
+	stats := newStats(sidx, zimPath)
+	defer recordStats(stats, sidx)
+
+	if err := sidx.TarZimSplitBatches(ctx, dir, maxVolumeSize, sidx.ParseZIMBatches(ctx)); err != nil {
+		return stats, err
+	}
+	v, err := indexer.ReadVolumes(dir)
+	if err != nil {
+		return stats, err
+	}
+	for _, vol := range v.Volumes {
+		if info, err := os.Stat(filepath.Join(dir, vol.Name)); err == nil {
+			stats.TarSize += info.Size()
+		}
+	}
+	return stats, nil
+}
+
+// UploadVolumes uploads the volumes of dir written by BuildVolumes, each
+// as its own collection, then merges their manifests into one root
+// serving all their files, given the types of their entries and with the
+// redirects left out of them. The references are recorded in the
+// indexer.VolumesFile of dir as they are uploaded, so that a failed upload
+// resumes from the first volume not uploaded.
+func (p *Pipeline) UploadVolumes(ctx context.Context, dir string, opts api.UploadCollectionOptions) (*indexer.Volumes, error) {
+	v, err := indexer.ReadVolumes(dir)
+	if err != nil {
+		return nil, err
+	}
+	ctx = p.context(ctx)
+	uploadOpts := api.UploadOptions{Pin: opts.Pin, Tag: opts.Tag, BatchID: opts.BatchID}
+
+	var roots []swarm.Address
+	var types []beeclient.ContentType
+	var redirects []indexer.Redirect
+	for i := range v.Volumes {
+		vol := &v.Volumes[i]
+		tarPath := filepath.Join(dir, vol.Name)
+		t, err := contentTypes(tarPath)
+		if err != nil {
+			return v, err
+		}
+		types = append(types, t...)
+		r, err := indexer.ReadRedirects(tarPath)
+		if err != nil {
+			return v, err
+		}
+		redirects = append(redirects, r...)
+		if vol.Reference == "" {
+			p.log.Info("uploading volume", "volume", vol.Name, "volumes", len(v.Volumes))
+			r, size, err := tarball.StreamTar(tarPath)
+			if err != nil {
+				return v, err
+			}
+			f := tarball.NewSizedReaderFile(vol.Name, r, size)
+			err = p.bee.UploadCollection(ctx, f, opts)
+			r.Close()
+			if err != nil {
+				return v, err
+			}
+			vol.Reference = f.Address().String()
+			if err := indexer.WriteVolumes(dir, v); err != nil {
+				return v, err
+			}
+		}
+		root, err := swarm.ParseHexAddress(vol.Reference)
+		if err != nil {
+			return v, fmt.Errorf("%s: reference of %s: %w", indexer.VolumesFile, vol.Name, err)
+		}
+		roots = append(roots, root)
+	}
+
+	p.log.Info("merging the manifests of the volumes", "volumes", len(roots))
+	root, err := p.bee.MergeManifests(ctx, roots, uploadOpts)
+	if err != nil {
+		return v, err
+	}
+	merged := tarball.NewBytesFile(filepath.Base(dir), nil)
+	merged.SetAddress(root)
+	if err := p.setContentTypes(ctx, types, merged, uploadOpts); err != nil {
+		return v, err
+	}
+	if err := p.addRedirects(ctx, redirects, merged, uploadOpts); err != nil {
+		return v, err
+	}
+	v.Root = merged.Address().String()
+	if err := indexer.WriteVolumes(dir, v); err != nil {
+		return v, err
+	}
+	return v, nil
+}
+
+// StreamOptions configures StreamUpload.
+type StreamOptions struct {
+	TarOptions
+	// Upload configures the upload of the tar.
+	Upload api.UploadCollectionOptions
+	// Sample is the number of files of the tar sampled for VerifyChecks
+	// besides the index document, drawn from Seed, or a random seed when
+	// zero.
+	Sample int
+	Seed   int64
+}
+
+// Streamed is the result of StreamUpload.
+type Streamed struct {
+	// File is the uploaded tar, whose address is the root of the upload.
+	File *tarball.File
+	// Stats are the stats of the parse.
+	Stats *result.Stats
+	// Checks are the index document and the files sampled from the tar.
+	Checks []gateway.Check
+}
+
+// StreamUpload parses the ZIM and uploads its tar as a collection named
+// name as it is written, without writing it to disk, so that the memory
+// used does not depend on the size of the ZIM. The files of its manifest
+// are then given the types of their entries, and the redirects left out
+// of a tar with ManifestRedirects are added to it; they are not part of
+// the Checks, which are drawn from the tar as it is uploaded.
+func (p *Pipeline) StreamUpload(ctx context.Context, zimPath string, name string, o StreamOptions) (*Streamed, error) {
+	if o.GzipLevel != 0 {
+		return nil, errors.New("the node only takes plain tars, a streamed tar can not be gzipped")
+	}
+	sidx, err := p.newIndexer(ctx, zimPath, o.TarOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer sidx.Close()
+
+	// stop parsing if the upload fails
+	ctx, cancel := context.WithCancel(p.context(ctx))
+	defer cancel()
+
+	s := &Streamed{Stats: newStats(sidx, zimPath)}
+	defer recordStats(s.Stats, sidx)
+
+	stream := sidx.TarStreamBatches(ctx, name, sidx.ParseZIMBatches(ctx))
+	defer stream.Close()
+	counted := &countingReader{r: stream}
+	if o.Seed == 0 {
+		o.Seed = random.NewSeed()
+	}
+	r, checks := gateway.SampleStream(counted, o.Sample, random.New(o.Seed, "verify"))
+	s.File = tarball.NewReaderFile(name, r)
+	p.log.Info("streaming the tar to the node", "tar", name)
+	err = p.bee.UploadCollection(ctx, s.File, o.Upload)
+	if err != nil {
+		// the parse stops with the stream
+		stream.Close()
+	}
+	var sampleErr error
+	s.Checks, sampleErr = checks()
+	n, readErr := counted.result()
+	if err != nil {
+		// the upload fails with the tar it could not read
+		if readErr != nil {
+			return s, readErr
+		}
+		return s, err
+	}
+	if sampleErr != nil {
+		return s, fmt.Errorf("sampling %s: %w", name, sampleErr)
+	}
+	s.Stats.TarSize = n
+
+	var types []beeclient.ContentType
+	if err := sidx.ForEachManifestEntry(func(e indexer.ManifestEntry) error {
+		types = appendContentType(types, e)
+		return nil
+	}); err != nil {
+		return s, err
+	}
+	uploadOpts := api.UploadOptions{Pin: o.Upload.Pin, Tag: o.Upload.Tag, BatchID: o.Upload.BatchID}
+	if err := p.setContentTypes(ctx, types, s.File, uploadOpts); err != nil {
+		return s, err
+	}
+	if err := p.addRedirects(ctx, sidx.Redirects(), s.File, uploadOpts); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// countingReader counts the bytes read, and records the read error other
+// than io.EOF. The http client may still read it once the request ended.
+type countingReader struct {
+	r   io.Reader
+	mu  sync.Mutex
+	n   int64
+	err error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n += int64(n)
+	if err != nil && err != io.EOF {
+		c.err = err
+	}
+	return n, err
+}
+
+// result returns the bytes read and the read error.
+func (c *countingReader) result() (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n, c.err
+}
+
+// contentTypes returns the types of the entries listed in the
+// indexer.EntriesFile of the tar that the node does not tell from their
+// extension, see appendContentType, none when the tar has no such file.
+func contentTypes(tarPath string) ([]beeclient.ContentType, error) {
+	entries, err := indexer.ReadEntriesManifest(tarPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading the entries of %s: %w", filepath.Base(tarPath), err)
+	}
+	var types []beeclient.ContentType
+	for _, e := range entries {
+		types = appendContentType(types, e)
+	}
+	return types, nil
+}
+
+// appendContentType appends the type of the file of the entry when the
+// node would not give it the same from its extension, as the articles
+// without one, e.g. A/Foo, which it serves without type, or those of the
+// ZIM of another type than their extension tells. The redirects are
+// pages, and the extra files typed by their extension.
+func appendContentType(types []beeclient.ContentType, e indexer.ManifestEntry) []beeclient.ContentType {
+	t := e.MimeType
+	if e.Redirect {
+		t = "text/html; charset=utf-8"
+	}
+	if e.Extra || t == "" {
+		return types
+	}
+	// the type the node gives the files of the tar, see storeDir of its
+	// api
+	if byExt := mime.TypeByExtension(filepath.Ext(e.Path)); byExt != "" && baseType(byExt) == baseType(t) {
+		return types
+	}
+	return append(types, beeclient.ContentType{Path: e.Path, Type: t})
+}
+
+// baseType returns the MIME type without its parameters.
+func baseType(t string) string {
+	t, _, _ = strings.Cut(t, ";")
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
+// setContentTypes gives the files of the manifest of the uploaded file
+// their type.
+func (p *Pipeline) setContentTypes(ctx context.Context, types []beeclient.ContentType, tarFile *tarball.File, opts api.UploadOptions) error {
+	if len(types) == 0 {
+		return nil
+	}
+	p.log.Info("setting the content types of the manifest", "root", tarFile.Address(), "files", len(types))
+	root, changed, err := p.bee.SetContentTypes(ctx, tarFile.Address(), types, opts)
+	if err != nil {
+		return fmt.Errorf("setting the content types of %s: %w", tarFile.Name(), err)
+	}
+	p.log.Info("content types set", "root", root, "files", changed)
+	tarFile.SetAddress(root)
+	return nil
+}
+
+// addRedirects adds the redirects left out of the tar to the manifest of
+// the uploaded file, as aliases of their target.
+func (p *Pipeline) addRedirects(ctx context.Context, redirects []indexer.Redirect, tarFile *tarball.File, opts api.UploadOptions) error {
+	if len(redirects) == 0 {
+		return nil
+	}
+	aliases := make([]beeclient.Alias, len(redirects))
+	for i, r := range redirects {
+		aliases[i] = beeclient.Alias{Path: r.Path, Target: r.Target}
+	}
+	p.log.Info("adding the redirects to the manifest", "root", tarFile.Address(), "redirects", len(aliases))
+	root, missing, err := p.bee.AddAliases(ctx, tarFile.Address(), aliases, opts)
+	if err != nil {
+		return fmt.Errorf("adding the redirects of %s: %w", tarFile.Name(), err)
+	}
+	if len(missing) > 0 {
+		p.log.Warn("redirects to files not in the manifest left out", "redirects", len(missing), "first", missing[0].Path, "target", missing[0].Target)
+	}
+	tarFile.SetAddress(root)
+	return nil
+}
+
+// VerifyOptions configures the verification of an uploaded root.
+type VerifyOptions struct {
+	// Gateways are the public gateways checked besides the node.
+	Gateways []string
+	// Sample is the number of files checked besides the index document.
+	Sample int
+	// Gateway configures the requests sent to the gateways.
+	Gateway gateway.Options
+	// SkipNode only checks the gateways.
+	SkipNode bool
+	// Seed chooses the sample, Verify draws a seed when zero. The same
+	// seed chooses the same files of a tar.
+	Seed int64
+}
+
+// Verify checks that the index document and a sample of the files of
+// the tar are served under the root, through the node and the gateways,
+// and as many of the redirects added to its manifest, if any.
+// The reports of the gateways are returned along with the verification.
+func (p *Pipeline) Verify(ctx context.Context, root string, tarPath string, o VerifyOptions) (*result.Verification, []gateway.Report, error) {
+	if o.Seed == 0 {
+		o.Seed = random.NewSeed()
+	}
+	checks, err := gateway.SampleChecks(tarPath, o.Sample, random.New(o.Seed, "verify"))
+	if err != nil {
+		return nil, nil, err
+	}
+	redirects, err := indexer.ReadRedirects(tarPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(redirects) > 0 {
+		aliases := make(map[string]string, len(redirects))
+		for _, r := range redirects {
+			aliases[r.Path] = r.Target
+		}
+		redirectChecks, err := gateway.AliasChecks(tarPath, aliases, o.Sample, random.New(o.Seed, "verify-redirects"))
+		if err != nil {
+			return nil, nil, err
+		}
+		checks = append(checks, redirectChecks...)
+	}
+	return p.VerifyChecks(ctx, root, checks, o)
+}
+
+// VerifyChecks runs the checks under the root, through the node and the
+// gateways, e.g. those of a tar sampled by StreamUpload. The sample and
+// seed of the options are not used.
+func (p *Pipeline) VerifyChecks(ctx context.Context, root string, checks []gateway.Check, o VerifyOptions) (*result.Verification, []gateway.Report, error) {
+	ctx = p.context(ctx)
+
+	v := &result.Verification{Verified: true}
+	if !o.SkipNode {
+		p.log.Info("checking root through the node", "root", root, "files", len(checks), "seed", o.Seed)
+		p.verifyNode(ctx, v, root, checks)
+	}
+
+	var reports []gateway.Report
+	if len(o.Gateways) > 0 {
+		p.log.Info("checking root through gateways", "root", root, "files", len(checks), "gateways", len(o.Gateways), "seed", o.Seed)
+		reports = gateway.New(o.Gateway).Run(ctx, o.Gateways, root, checks)
+		AddReports(v, reports)
+	}
+	return v, reports, nil
+}
+
+func (p *Pipeline) verifyNode(ctx context.Context, v *result.Verification, root string, checks []gateway.Check) {
+	addr, err := swarm.ParseHexAddress(root)
+	if err != nil {
+		v.Verified = false
+		v.Failed = append(v.Failed, fmt.Sprintf("node: invalid root %s: %v", root, err))
+		return
+	}
+	for _, c := range checks {
+		v.Checked++
+		data, err := p.bee.DownloadManifestBytes(ctx, addr, c.Path)
+		if err == nil {
+			if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != c.SHA256 {
+				err = errors.New("content differs from the tar")
+			}
+		}
+		if err != nil {
+			v.Verified = false
+			v.Failed = append(v.Failed, fmt.Sprintf("node /%s: %v", c.Path, err))
+		}
+	}
+}
+
+// AddReports adds the checks of the gateway reports to the verification.
+func AddReports(v *result.Verification, reports []gateway.Report) {
+	for _, r := range reports {
+		v.Checked += r.Checked
+		v.Verified = v.Verified && r.Success
+		for _, f := range r.Failed {
+			v.Failed = append(v.Failed, fmt.Sprintf("%s /%s: %s", r.Gateway, f.Path, f.Error))
+		}
+	}
+}
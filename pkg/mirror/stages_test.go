@@ -0,0 +1,64 @@
+package mirror
+
+import (
+	"testing"
+
+	"github.com/r0qs/beezim/indexer"
+)
+
+func TestTarOptionsFilters(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts TarOptions
+		want string
+	}{
+		{"default", TarOptions{}, "enable-search=false"},
+		{"default page size", TarOptions{EnableSearch: true, ListingPageSize: indexer.DefaultListingPageSize}, "enable-search=true"},
+		{"split search", TarOptions{EnableSearch: true, SearchTarPath: "work/x.search.tar"}, "enable-search=true split-search=true"},
+		{"diff from", TarOptions{Previous: []indexer.ManifestEntry{{}}, DiffFrom: "old.tar"}, "enable-search=false diff-from=old.tar"},
+		{"sitemap url", TarOptions{Sitemap: true, SitemapURL: "https://example.org"}, "enable-search=false sitemap=true sitemap-url=https://example.org"},
+		{"placeholders without mimes", TarOptions{MimePlaceholders: true}, "enable-search=false"},
+		{
+			"all",
+			TarOptions{
+				EnableSearch:      true,
+				SearchTarPath:     "x.search.tar",
+				Namespaces:        indexer.NewNamespaceFilter([]byte("CA"), nil),
+				Mimes:             indexer.MimeFilter{Blocked: []string{"video/*", "audio/*"}},
+				MimePlaceholders:  true,
+				MimeOverrides:     indexer.MimeOverrides{".svg": "image/svg+xml"},
+				SniffMime:         true,
+				MaxArticleSize:    1 << 20,
+				ManifestRedirects: true,
+				Minify:            true,
+				RelativeLinks:     true,
+				NavBar:            true,
+				Offline:           indexer.OfflineStrip,
+				Dedup:             indexer.DedupAssets,
+				Collisions:        indexer.CollisionsKeepFirst,
+				MainPage:          "A/Main",
+				DiffFrom:          "old.tar",
+				DropTitleIndex:    true,
+				Snippets:          true,
+				Sitemap:           true,
+				TemplateDir:       "templates",
+				AssetsDir:         "assets",
+				ExtraFiles:        map[string]string{"b.txt": "/tmp/b", "a.txt": "/tmp/a"},
+				Language:          "fr",
+				Theme:             "dark",
+				ListingPageSize:   50,
+			},
+			"enable-search=true split-search=true include-namespaces=AC block-mime=audio/*,video/* mime-placeholders=true" +
+				" mime-override=.svg=image/svg+xml sniff-mime=true max-article-size=1048576 manifest-redirects=true minify=true" +
+				" relative-links=true nav-bar=true offline=strip dedup=assets collisions=keep-first main-page=A/Main" +
+				" diff-from=old.tar drop-title-index=true snippets=true sitemap=true template-dir=templates assets-dir=assets" +
+				" extra-file=a.txt=/tmp/a extra-file=b.txt=/tmp/b language=fr theme=dark listing-page-size=50",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.Filters(); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+// Package testenv runs a bee node in dev mode for the integration tests,
+// so they exercise the real manifest and postage stamp behavior of the
+// node instead of mocks. It serves the tests of beezim as well as those
+// of the programs mirroring with package mirror.
+//
+// The package is only built with the integration build tag:
+//
+//	go test -tags integration ./...
+//
+// A test gets a node with a usable batch from New, which skips the test
+// when no node can be started:
+//
+//	func TestMirror(t *testing.T) {
+//		env := testenv.New(t)
+//		res, err := mirror.Run(ctx, mirror.Options{ZimPath: zimPath, Bee: env.Bee, BatchID: env.BatchID})
+//	}
+//
+// The node is chosen from the environment, the first available wins:
+//
+//	BEEZIM_TEST_BEE_API_URL        a running node, with BEEZIM_TEST_BEE_DEBUG_API_URL
+//	BEEZIM_TEST_BEE_BIN            a bee binary, "bee" in the PATH by default
+//	BEEZIM_TEST_BEE_IMAGE          a docker image, ethersphere/bee:<version> by default
+//	BEEZIM_TEST_BEE_DOWNLOAD=1     download the bee binary of BEEZIM_TEST_BEE_VERSION
+package testenv
@@ -0,0 +1,352 @@
+//go:build integration
+
+package testenv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/r0qs/beezim/internal/beeclient"
+	"github.com/r0qs/beezim/internal/beeclient/api"
+	"github.com/r0qs/beezim/internal/beeclient/debugapi"
+	"github.com/r0qs/beezim/internal/httpclient"
+)
+
+// DefaultVersion is the version of bee started when none is given.
+const DefaultVersion = "1.4.3"
+
+// ErrUnavailable is returned when no bee node can be started.
+var ErrUnavailable = errors.New("no bee node available: set BEEZIM_TEST_BEE_API_URL, BEEZIM_TEST_BEE_BIN or BEEZIM_TEST_BEE_DOWNLOAD=1, or install docker")
+
+// Options configures the node started.
+type Options struct {
+	// APIURL and DebugAPIURL are the urls of a running node, used instead
+	// of starting one.
+	APIURL      string
+	DebugAPIURL string
+	// Binary is the path of the bee binary to run.
+	Binary string
+	// Image is the docker image run when there is no binary.
+	Image string
+	// Download fetches the bee binary of Version when there is neither a
+	// binary nor docker.
+	Download bool
+	// Version of bee downloaded, DefaultVersion by default.
+	Version string
+	// CacheDir keeps the downloaded binaries, the user cache dir by default.
+	CacheDir string
+	// ReadyTimeout is the time given to the node to be ready, one minute by
+	// default.
+	ReadyTimeout time.Duration
+	// BatchAmount and BatchDepth of the batch provisioned, a batch large
+	// enough for a small ZIM by default.
+	BatchAmount int64
+	BatchDepth  uint64
+}
+
+// OptionsFromEnv returns the options set by the BEEZIM_TEST_BEE_*
+// environment variables.
+func OptionsFromEnv() Options {
+	return Options{
+		APIURL:      os.Getenv("BEEZIM_TEST_BEE_API_URL"),
+		DebugAPIURL: os.Getenv("BEEZIM_TEST_BEE_DEBUG_API_URL"),
+		Binary:      os.Getenv("BEEZIM_TEST_BEE_BIN"),
+		Image:       os.Getenv("BEEZIM_TEST_BEE_IMAGE"),
+		Download:    os.Getenv("BEEZIM_TEST_BEE_DOWNLOAD") == "1",
+		Version:     os.Getenv("BEEZIM_TEST_BEE_VERSION"),
+	}
+}
+
+// Env is a bee node ready to be used, with a usable batch.
+type Env struct {
+	APIURL      *url.URL
+	DebugAPIURL *url.URL
+	// Bee is a client of both APIs of the node, the mirror.Bee of the
+	// pipelines.
+	Bee *beeclient.BeeClient
+	// API is a client of the API of the node.
+	API *api.Api
+	// BatchID is the batch provisioned for the uploads.
+	BatchID string
+
+	stop func() error
+}
+
+// New starts a node configured from the environment and stops it when the
+// test ends. The test is skipped when no node is available, and fails when
+// the node does not start.
+func New(t testing.TB) *Env {
+	t.Helper()
+	ctx := context.Background()
+	env, err := Start(ctx, OptionsFromEnv())
+	if errors.Is(err, ErrUnavailable) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := env.Stop(); err != nil {
+			t.Logf("error stopping bee: %v", err)
+		}
+	})
+	return env
+}
+
+// Start starts a node, or connects to the running one, waits until it is
+// ready and provisions a batch.
+func Start(ctx context.Context, o Options) (env *Env, err error) {
+	if o.Version == "" {
+		o.Version = DefaultVersion
+	}
+	if o.ReadyTimeout == 0 {
+		o.ReadyTimeout = time.Minute
+	}
+	if o.BatchAmount == 0 {
+		o.BatchAmount = 100000000
+	}
+	if o.BatchDepth == 0 {
+		o.BatchDepth = 20
+	}
+
+	env = &Env{stop: func() error { return nil }}
+	if o.APIURL != "" {
+		if env.APIURL, err = url.Parse(o.APIURL); err != nil {
+			return nil, fmt.Errorf("invalid bee api url: %v", err)
+		}
+		if env.DebugAPIURL, err = url.Parse(o.DebugAPIURL); err != nil || o.DebugAPIURL == "" {
+			return nil, fmt.Errorf("invalid bee debug api url %q", o.DebugAPIURL)
+		}
+	} else if err := env.launch(ctx, o); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			env.Stop()
+		}
+	}()
+
+	env.Bee, err = beeclient.NewBee(beeclient.ClientOptions{
+		APIURL:      env.APIURL,
+		DebugAPIURL: env.DebugAPIURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	env.API, err = api.NewAPI(env.APIURL, &httpclient.ClientOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	readyCtx, cancel := context.WithTimeout(ctx, o.ReadyTimeout)
+	defer cancel()
+	if err := waitReady(readyCtx, env.DebugAPIURL); err != nil {
+		return nil, err
+	}
+	if env.BatchID, err = provisionBatch(readyCtx, env.Bee, o.BatchAmount, o.BatchDepth); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// Stop stops the node started, if any.
+func (e *Env) Stop() error {
+	if e.Bee != nil {
+		e.Bee.Shutdown()
+	}
+	return e.stop()
+}
+
+// launch runs bee in dev mode from a binary, a docker image or a
+// downloaded binary, in this order.
+func (e *Env) launch(ctx context.Context, o Options) error {
+	apiPort, err := freePort()
+	if err != nil {
+		return err
+	}
+	debugPort, err := freePort()
+	if err != nil {
+		return err
+	}
+	e.APIURL = &url.URL{Scheme: "http", Host: fmt.Sprintf("localhost:%d", apiPort)}
+	e.DebugAPIURL = &url.URL{Scheme: "http", Host: fmt.Sprintf("localhost:%d", debugPort)}
+
+	bin := o.Binary
+	if bin == "" {
+		bin, _ = exec.LookPath("bee")
+	}
+	if bin == "" && o.Image == "" {
+		if _, err := exec.LookPath("docker"); err == nil {
+			o.Image = "ethersphere/bee:" + o.Version
+		} else if o.Download {
+			if bin, err = download(ctx, o); err != nil {
+				return err
+			}
+		} else {
+			return ErrUnavailable
+		}
+	}
+
+	if bin != "" {
+		return e.runBinary(bin, apiPort, debugPort)
+	}
+	return e.runDocker(o.Image, apiPort, debugPort)
+}
+
+func devArgs(apiAddr, debugAddr string) []string {
+	return []string{
+		"dev",
+		"--api-addr=" + apiAddr,
+		"--debug-api-enable=true",
+		"--debug-api-addr=" + debugAddr,
+		"--cors-allowed-origins=*",
+	}
+}
+
+func (e *Env) runBinary(bin string, apiPort, debugPort int) error {
+	cmd := exec.Command(bin, devArgs(fmt.Sprintf("localhost:%d", apiPort), fmt.Sprintf("localhost:%d", debugPort))...)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting bee: %v", err)
+	}
+	e.stop = func() error {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil
+	}
+	return nil
+}
+
+func (e *Env) runDocker(image string, apiPort, debugPort int) error {
+	args := append([]string{
+		"run", "--rm", "--detach",
+		"--publish", fmt.Sprintf("%d:1633", apiPort),
+		"--publish", fmt.Sprintf("%d:1635", debugPort),
+		image,
+	}, devArgs(":1633", ":1635")...)
+	out, err := exec.Command("docker", args...).Output()
+	if err != nil {
+		return fmt.Errorf("error starting bee container: %v", err)
+	}
+	id := strings.TrimSpace(string(out))
+	e.stop = func() error {
+		return exec.Command("docker", "rm", "--force", id).Run()
+	}
+	return nil
+}
+
+// download fetches the bee binary of the version for this platform, once.
+func download(ctx context.Context, o Options) (string, error) {
+	dir := o.CacheDir
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(cache, "beezim", "bee")
+	}
+	bin := filepath.Join(dir, "bee-"+o.Version)
+	if _, err := os.Stat(bin); err == nil {
+		return bin, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	u := fmt.Sprintf("https://github.com/ethersphere/bee/releases/download/v%s/bee-%s-%s", o.Version, runtime.GOOS, runtime.GOARCH)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error downloading bee: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error downloading bee from %s: %s", u, resp.Status)
+	}
+
+	f, err := os.CreateTemp(dir, "bee-*.part")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return "", fmt.Errorf("error downloading bee: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(f.Name(), 0755); err != nil {
+		return "", err
+	}
+	return bin, os.Rename(f.Name(), bin)
+}
+
+// waitReady polls the readiness endpoint of the debug api.
+func waitReady(ctx context.Context, debugURL *url.URL) error {
+	u := debugURL.ResolveReference(&url.URL{Path: "/readiness"}).String()
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return err
+		}
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("bee is not ready: %v", ctx.Err())
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// provisionBatch buys a batch and waits until it can be used.
+func provisionBatch(ctx context.Context, bee *beeclient.BeeClient, amount int64, depth uint64) (string, error) {
+	id, err := bee.CreatePostageBatch(ctx, amount, depth, "beezim-testenv", debugapi.PostageOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error buying a batch: %v", err)
+	}
+	for {
+		batches, err := bee.PostageBatches(ctx)
+		if err != nil {
+			return "", err
+		}
+		for _, b := range batches {
+			if b.BatchID == id && b.Usable {
+				return id, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("batch %s is not usable: %v", id, ctx.Err())
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}